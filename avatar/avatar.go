@@ -0,0 +1,258 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package avatar implements publishing and retrieving user avatars.
+//
+// Avatars are stored in two personal eventing (PEP) nodes: a data node that
+// contains the image itself and a metadata node that describes the available
+// images.
+// Because the metadata node is small, contacts subscribe to it and only fetch
+// the image data when the avatar changes and the data is not already cached.
+// To receive notifications when the avatars of contacts change, register a
+// Handler and advertise the NSNotify feature.
+package avatar // import "mellium.im/xmpp/avatar"
+
+import (
+	"context"
+	/* #nosec */
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/pubsub"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NSData     = "urn:xmpp:avatar:data"
+	NSMetadata = "urn:xmpp:avatar:metadata"
+
+	// NSNotify is the feature that must be advertised to receive avatar change
+	// notifications.
+	NSNotify = NSMetadata + "+notify"
+)
+
+// Errors returned by this package.
+var (
+	ErrNoAvatar = errors.New("avatar: no avatar is published")
+	ErrBadData  = errors.New("avatar: image data does not match its ID")
+)
+
+// Info describes an avatar image.
+type Info struct {
+	// ID is the hex encoded SHA-1 hash of the image data.
+	ID string `xml:"id,attr"`
+
+	// Type is the media type of the image, for example "image/png".
+	Type string `xml:"type,attr"`
+
+	// Bytes is the size of the image data.
+	Bytes uint32 `xml:"bytes,attr"`
+
+	Width  uint16 `xml:"width,attr,omitempty"`
+	Height uint16 `xml:"height,attr,omitempty"`
+
+	// URL is set if the image is hosted outside of the data node.
+	URL string `xml:"url,attr,omitempty"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (i Info) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{
+		{Name: xml.Name{Local: "bytes"}, Value: strconv.FormatUint(uint64(i.Bytes), 10)},
+		{Name: xml.Name{Local: "id"}, Value: i.ID},
+		{Name: xml.Name{Local: "type"}, Value: i.Type},
+	}
+	if i.Width > 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "width"}, Value: strconv.FormatUint(uint64(i.Width), 10)})
+	}
+	if i.Height > 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "height"}, Value: strconv.FormatUint(uint64(i.Height), 10)})
+	}
+	if i.URL != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "url"}, Value: i.URL})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "info"}, Attr: attrs})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (i Info) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, i.TokenReader())
+}
+
+type metadata struct {
+	XMLName xml.Name `xml:"urn:xmpp:avatar:metadata metadata"`
+	Info    []Info   `xml:"info"`
+}
+
+func metadataReader(info ...Info) xml.TokenReader {
+	var r []xml.TokenReader
+	for _, i := range info {
+		r = append(r, i.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(r...),
+		xml.StartElement{Name: xml.Name{Space: NSMetadata, Local: "metadata"}},
+	)
+}
+
+// Hash returns the ID of an image.
+func Hash(data []byte) string {
+	/* #nosec */
+	h := sha1.Sum(data)
+	return hex.EncodeToString(h[:])
+}
+
+// Publish publishes an image as the users avatar.
+// The ID and size of info are set from the data and the resulting info is
+// returned.
+func Publish(ctx context.Context, s *xmpp.Session, data []byte, info Info) (Info, error) {
+	info.ID = Hash(data)
+	info.Bytes = uint32(len(data))
+	info.URL = ""
+
+	// The data must be published first so that anyone who receives the new
+	// metadata can fetch it.
+	_, err := pubsub.Publish(ctx, s, jid.JID{}, NSData, info.ID, xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(data))),
+		xml.StartElement{Name: xml.Name{Space: NSData, Local: "data"}},
+	))
+	if err != nil {
+		return info, err
+	}
+	_, err = pubsub.Publish(ctx, s, jid.JID{}, NSMetadata, info.ID, metadataReader(info))
+	return info, err
+}
+
+// Disable publishes empty metadata to indicate that the user no longer has an
+// avatar.
+func Disable(ctx context.Context, s *xmpp.Session) error {
+	_, err := pubsub.Publish(ctx, s, jid.JID{}, NSMetadata, "", metadataReader())
+	return err
+}
+
+// FetchMetadata returns the images that make up the avatar of j.
+// If j does not have an avatar, the list is empty.
+func FetchMetadata(ctx context.Context, s *xmpp.Session, j jid.JID) ([]Info, error) {
+	iter := pubsub.Fetch(ctx, s, j.Bare(), pubsub.Query{Node: NSMetadata, MaxItems: 1})
+	var m metadata
+	for iter.Next() {
+		err := xml.NewTokenDecoder(iter.Item()).Decode(&m)
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return nil, err
+		}
+	}
+	err := iter.Err()
+	if err != nil {
+		/* #nosec */
+		iter.Close()
+		return nil, err
+	}
+	return m.Info, iter.Close()
+}
+
+// FetchData returns the image data with the given ID from the data node of j.
+// If the data does not match the ID, ErrBadData is returned.
+func FetchData(ctx context.Context, s *xmpp.Session, j jid.JID, id string) ([]byte, error) {
+	iter := pubsub.Fetch(ctx, s, j.Bare(), pubsub.Query{Node: NSData, Item: []string{id}})
+	var d struct {
+		XMLName xml.Name `xml:"urn:xmpp:avatar:data data"`
+		Data    string   `xml:",chardata"`
+	}
+	for iter.Next() {
+		if iter.ID() != id {
+			continue
+		}
+		err := xml.NewTokenDecoder(iter.Item()).Decode(&d)
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return nil, err
+		}
+	}
+	err := iter.Err()
+	if err != nil {
+		/* #nosec */
+		iter.Close()
+		return nil, err
+	}
+	err = iter.Close()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(d.Data)
+	if err != nil {
+		return nil, err
+	}
+	if Hash(data) != id {
+		return nil, ErrBadData
+	}
+	return data, nil
+}
+
+// Fetch returns the avatar of j.
+// If the avatar has a PNG image it is preferred, otherwise the first image
+// that is stored in the data node is used.
+// If c is not nil, image data is looked up in the cache before it is
+// requested and added to the cache after it is fetched.
+//
+// If j has not published an avatar, ErrNoAvatar is returned.
+// If all of the images are hosted outside of the data node, the info for the
+// first one is returned with no data and it is up to the caller to fetch it.
+func Fetch(ctx context.Context, s *xmpp.Session, j jid.JID, c Cache) (Info, []byte, error) {
+	infos, err := FetchMetadata(ctx, s, j)
+	if err != nil {
+		return Info{}, nil, err
+	}
+	if len(infos) == 0 {
+		return Info{}, nil, ErrNoAvatar
+	}
+	info, ok := pick(infos)
+	if !ok {
+		return info, nil, nil
+	}
+	if c != nil {
+		if data, ok := c.Get(info.ID); ok {
+			return info, data, nil
+		}
+	}
+	data, err := FetchData(ctx, s, j, info.ID)
+	if err != nil {
+		return info, nil, err
+	}
+	if c != nil {
+		c.Put(info.ID, data)
+	}
+	return info, data, nil
+}
+
+// pick returns the image that should be fetched from the data node.
+// If none of the images are in the data node, the first image is returned
+// along with false.
+func pick(infos []Info) (Info, bool) {
+	found := -1
+	for i, info := range infos {
+		if info.URL != "" {
+			continue
+		}
+		if info.Type == "image/png" {
+			return info, true
+		}
+		if found == -1 {
+			found = i
+		}
+	}
+	if found == -1 {
+		return infos[0], false
+	}
+	return infos[found], true
+}
@@ -0,0 +1,247 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package avatar_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/avatar"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/storage"
+)
+
+type pepItem struct {
+	ID      string
+	Payload []xml.Token
+}
+
+func (item pepItem) TokenReader() xml.TokenReader {
+	var payload []xml.TokenReader
+	for _, tok := range item.Payload {
+		payload = append(payload, xmlstream.Token(tok))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(payload...),
+		xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: item.ID}},
+		},
+	)
+}
+
+func rawItem(id, payload string) pepItem {
+	toks, err := xmlstream.ReadAll(xml.NewDecoder(strings.NewReader(payload)))
+	if err != nil {
+		panic(err)
+	}
+	return pepItem{ID: id, Payload: toks}
+}
+
+// pep returns a handler that acts like a PEP service storing the most recent
+// item published to each node.
+func pep(nodes map[string]pepItem, fetched *int) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var node string
+			for {
+				tok, err := t.Token()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				start, ok := tok.(xml.StartElement)
+				if !ok {
+					continue
+				}
+				switch start.Name.Local {
+				case "publish":
+					_, node = attr(start, "node")
+				case "item":
+					item := pepItem{}
+					_, item.ID = attr(start, "id")
+					item.Payload, err = xmlstream.ReadAll(xmlstream.Inner(t))
+					if err != nil {
+						return err
+					}
+					nodes[node] = item
+				}
+			}
+			_, err := xmlstream.Copy(t, iq.Result(nil))
+			return err
+		})(m)
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var req struct {
+				Items struct {
+					Node string `xml:"node,attr"`
+				} `xml:"items"`
+			}
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+			if err != nil {
+				return err
+			}
+			if req.Items.Node == avatar.NSData {
+				*fetched++
+			}
+			_, err = xmlstream.Copy(t, iq.Result(xmlstream.Wrap(
+				xmlstream.Wrap(nodes[req.Items.Node].TokenReader(), xml.StartElement{
+					Name: xml.Name{Local: "items"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: req.Items.Node}},
+				}),
+				xml.StartElement{Name: xml.Name{Space: pubsub.NS, Local: "pubsub"}},
+			)))
+			return err
+		})(m)
+	}
+}
+
+func attr(start xml.StartElement, local string) (bool, string) {
+	for _, a := range start.Attr {
+		if a.Name.Local == local {
+			return true, a.Value
+		}
+	}
+	return false, ""
+}
+
+func TestPublishFetch(t *testing.T) {
+	nodes := make(map[string]pepItem)
+	var fetched int
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(mux.New(pep(nodes, &fetched))))
+
+	ctx := context.Background()
+	img := []byte("not really a png")
+	info, err := avatar.Publish(ctx, cs.Client, img, avatar.Info{Type: "image/png", Width: 64, Height: 64})
+	if err != nil {
+		t.Fatalf("error publishing avatar: %v", err)
+	}
+	if info.ID != avatar.Hash(img) || info.Bytes != uint32(len(img)) {
+		t.Errorf("wrong info returned: %+v", info)
+	}
+	if nodes[avatar.NSData].ID != info.ID || nodes[avatar.NSMetadata].ID != info.ID {
+		t.Errorf("wrong item IDs published: want=%s, got data=%s metadata=%s", info.ID, nodes[avatar.NSData].ID, nodes[avatar.NSMetadata].ID)
+	}
+
+	cache := avatar.NewMemCache(1)
+	for i := 0; i < 2; i++ {
+		gotInfo, data, err := avatar.Fetch(ctx, cs.Client, cs.Client.LocalAddr(), cache)
+		if err != nil {
+			t.Fatalf("error fetching avatar: %v", err)
+		}
+		if gotInfo != info {
+			t.Errorf("wrong info: want=%+v, got=%+v", info, gotInfo)
+		}
+		if !bytes.Equal(data, img) {
+			t.Errorf("wrong data: want=%q, got=%q", img, data)
+		}
+	}
+	if fetched != 1 {
+		t.Errorf("expected data to be fetched once and then cached, got %d fetches", fetched)
+	}
+
+	err = avatar.Disable(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error disabling avatar: %v", err)
+	}
+	_, _, err = avatar.Fetch(ctx, cs.Client, cs.Client.LocalAddr(), cache)
+	if err != avatar.ErrNoAvatar {
+		t.Errorf("wrong error after disabling avatar: want=%v, got=%v", avatar.ErrNoAvatar, err)
+	}
+}
+
+func TestBadData(t *testing.T) {
+	nodes := map[string]pepItem{
+		avatar.NSMetadata: rawItem(avatar.Hash([]byte("a")), `<metadata xmlns="urn:xmpp:avatar:metadata"><info bytes="1" id="`+avatar.Hash([]byte("a"))+`" type="image/png"/></metadata>`),
+		avatar.NSData:     rawItem(avatar.Hash([]byte("a")), `<data xmlns="urn:xmpp:avatar:data">Yg==</data>`),
+	}
+	var fetched int
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(mux.New(pep(nodes, &fetched))))
+
+	_, _, err := avatar.Fetch(context.Background(), cs.Client, cs.Client.LocalAddr(), nil)
+	if err != avatar.ErrBadData {
+		t.Errorf("wrong error: want=%v, got=%v", avatar.ErrBadData, err)
+	}
+}
+
+func TestDirCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avatar")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := avatar.DirCache(dir + "/cache")
+	id := avatar.Hash([]byte("data"))
+	cache.Put(id, []byte("data"))
+	data, ok := cache.Get(id)
+	if !ok || string(data) != "data" {
+		t.Errorf("wrong cached data: want=data, got=%q (%t)", data, ok)
+	}
+	cache.Put("../escaped", []byte("data"))
+	if _, err := os.Stat(dir + "/escaped"); !os.IsNotExist(err) {
+		t.Errorf("expected invalid ID not to be written outside of the cache, got err=%v", err)
+	}
+}
+
+func TestKVCache(t *testing.T) {
+	kv := &storage.Mem{}
+	cache := avatar.KVCache(kv)
+	id := avatar.Hash([]byte("data"))
+	if _, ok := cache.Get(id); ok {
+		t.Errorf("did not expect empty cache to contain data")
+	}
+	cache.Put(id, []byte("data"))
+	data, ok := cache.Get(id)
+	if !ok || string(data) != "data" {
+		t.Errorf("wrong cached data: want=data, got=%q (%t)", data, ok)
+	}
+	if keys, _ := kv.Keys(""); len(keys) != 1 || keys[0] != "avatar/"+id {
+		t.Errorf("wrong keys: want=[avatar/%s], got=%v", id, keys)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	updates := make(chan []avatar.Info, 1)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(avatar.Handle(avatar.Handler{
+			Update: func(_ jid.JID, info []avatar.Info) error {
+				updates <- info
+				return nil
+			},
+		}))),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(`<message xmlns="jabber:client" from="juliet@capulet.lit" to="romeo@montague.lit/home" type="headline"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:avatar:metadata"><item id="111f4b3c50d7b0df729d299bc6f8e9ef9066971f"><metadata xmlns="urn:xmpp:avatar:metadata"><info bytes="12345" width="64" height="64" id="111f4b3c50d7b0df729d299bc6f8e9ef9066971f" type="image/png"/></metadata></item></items></event></message>`)))
+	if err != nil {
+		t.Fatalf("error sending notification: %v", err)
+	}
+
+	select {
+	case info := <-updates:
+		want := avatar.Info{ID: "111f4b3c50d7b0df729d299bc6f8e9ef9066971f", Type: "image/png", Bytes: 12345, Width: 64, Height: 64}
+		if len(info) != 1 || info[0] != want {
+			t.Errorf("wrong update: want=%+v, got=%+v", want, info)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for avatar update")
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package avatar
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"mellium.im/xmpp/internal/lru"
+	"mellium.im/xmpp/storage"
+)
+
+// Cache stores image data by ID so that it does not have to be fetched again
+// when contacts switch back to an avatar that has been seen before or when the
+// same avatar is used by more than one contact.
+//
+// Caches are best effort, errors storing data are not reported.
+type Cache interface {
+	Get(id string) (data []byte, ok bool)
+	Put(id string, data []byte)
+}
+
+// MemCache is a Cache that holds a fixed number of images in memory and evicts
+// the least recently used when it is full.
+//
+// A MemCache is safe for concurrent use.
+type MemCache struct {
+	c *lru.Cache
+}
+
+// NewMemCache creates a cache that holds at most size images.
+// If size is less than 1 nothing is cached.
+func NewMemCache(size int) *MemCache {
+	return &MemCache{c: lru.New(size)}
+}
+
+// Get implements Cache.
+func (c *MemCache) Get(id string) ([]byte, bool) {
+	v, ok := c.c.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+// Put implements Cache.
+func (c *MemCache) Put(id string, data []byte) {
+	c.c.Add(id, data)
+}
+
+// DirCache is a Cache that stores each image as a file in a directory.
+// The directory is created when the first image is stored if it does not
+// exist.
+type DirCache string
+
+// Get implements Cache.
+func (dir DirCache) Get(id string) ([]byte, bool) {
+	if !validID(id) {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(string(dir), id))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put implements Cache.
+func (dir DirCache) Put(id string, data []byte) {
+	if !validID(id) {
+		return
+	}
+	err := os.MkdirAll(string(dir), 0700)
+	if err != nil {
+		return
+	}
+	/* #nosec */
+	ioutil.WriteFile(filepath.Join(string(dir), id), data, 0600)
+}
+
+// validID reports whether id looks like a hex encoded SHA-1 hash.
+// IDs come from other entities, so this must be checked before they are used
+// as file names.
+func validID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == 20
+}
+
+// kvPrefix is prepended to the keys used by KVCache so that the same KV can be
+// shared with other packages.
+const kvPrefix = "avatar/"
+
+// KVCache returns a Cache that stores images in kv under keys starting with
+// "avatar/".
+func KVCache(kv storage.KV) Cache {
+	return kvCache{kv: kv}
+}
+
+type kvCache struct {
+	kv storage.KV
+}
+
+func (c kvCache) Get(id string) ([]byte, bool) {
+	data, err := c.kv.Get(kvPrefix + id)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c kvCache) Put(id string, data []byte) {
+	/* #nosec */
+	c.kv.Put(kvPrefix+id, data)
+}
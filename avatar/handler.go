@@ -0,0 +1,63 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package avatar
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/pubsub"
+)
+
+// Handle returns an option that registers a Handler for avatar change
+// notifications.
+// Because it handles all pubsub event notifications it cannot be used along
+// with other handlers registered using pubsub.Handle.
+// To handle other notifications as well, use the Item method of the Handler
+// from the Item function of a pubsub.Handler.
+func Handle(h Handler) mux.Option {
+	return pubsub.Handle(pubsub.Handler{Item: h.Item})
+}
+
+// Handler receives notifications when the avatars of contacts change.
+type Handler struct {
+	// Update is called with the new images that make up the avatar of a
+	// contact.
+	// If the contact removed their avatar, info is empty.
+	Update func(from jid.JID, info []Info) error
+
+	// Bus, if set, has an Event published to it for every change.
+	Bus *xmpp.Bus
+}
+
+// Event is published to the bus of a Handler when the avatar of a contact
+// changes.
+type Event struct {
+	From jid.JID
+
+	// Info contains the new images that make up the avatar, or is empty if the
+	// avatar was removed.
+	Info []Info
+}
+
+// Item handles an item published to a pubsub node.
+// Items from nodes other than the avatar metadata node are ignored.
+func (h Handler) Item(from jid.JID, node, id string, payload xml.TokenReader) error {
+	if node != NSMetadata || (h.Update == nil && h.Bus == nil) {
+		return nil
+	}
+	var m metadata
+	err := xml.NewTokenDecoder(payload).Decode(&m)
+	if err != nil {
+		return err
+	}
+	h.Bus.Publish(Event{From: from, Info: m.Info})
+	if h.Update == nil {
+		return nil
+	}
+	return h.Update(from, m.Info)
+}
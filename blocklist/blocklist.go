@@ -0,0 +1,177 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package blocklist implements blocking and unblocking of contacts.
+//
+// Blocked contacts cannot send the user any stanzas and any stanzas that the
+// user tries to send to them are rejected by the server with an error for
+// which IsBlocked returns true.
+package blocklist // import "mellium.im/xmpp/blocklist"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/paging"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS       = "urn:xmpp:blocking"
+	NSErrors = "urn:xmpp:blocking:errors"
+)
+
+// IsBlocked returns true if err is a stanza error indicating that the stanza
+// was not delivered because the recipient is blocked.
+func IsBlocked(err error) bool {
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) {
+		return false
+	}
+	return stanzaErr.AppCondition == xml.Name{Space: NSErrors, Local: "blocked"}
+}
+
+// Iter is an iterator over blocked JIDs.
+type Iter struct {
+	*paging.ResultIter
+}
+
+// JID returns the last blocked JID parsed by the iterator.
+func (i *Iter) JID() jid.JID {
+	j, _ := i.Current().(jid.JID)
+	return j
+}
+
+// Fetch sends a request to the JID asking for the blocklist.
+//
+// The iterator must be closed before anything else is done on the session or it
+// will become invalid.
+// Any errors encountered while creating the iter are deferred until the iter is
+// used.
+func Fetch(ctx context.Context, s *xmpp.Session) *Iter {
+	return FetchIQ(ctx, stanza.IQ{}, s)
+}
+
+// FetchIQ is like Fetch except that it allows modifying the IQ.
+// Changes to the IQ type will have no effect.
+func FetchIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session) *Iter {
+	iq.Type = stanza.GetIQ
+	return &Iter{
+		ResultIter: paging.NewResultIter(ctx,
+			func(ctx context.Context, _ *paging.RequestNext) (*paging.Iter, error) {
+				iter, err := s.IterIQElement(ctx, payload("blocklist", nil), iq)
+				if err != nil {
+					return nil, err
+				}
+				return paging.WrapIter(iter, 0), nil
+			},
+			func(start xml.StartElement, _ xml.TokenReader) (interface{}, error) {
+				if start.Name.Local != "item" {
+					return nil, nil
+				}
+				_, j := attr.Get(start.Attr, "jid")
+				return jid.Parse(j)
+			},
+		),
+	}
+}
+
+// Block adds JIDs to the blocklist.
+func Block(ctx context.Context, s *xmpp.Session, j ...jid.JID) error {
+	return s.UnmarshalIQElement(ctx, payload("block", j), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+// Unblock removes JIDs from the blocklist.
+// If no JIDs are provided, the entire blocklist is cleared.
+func Unblock(ctx context.Context, s *xmpp.Session, j ...jid.JID) error {
+	return s.UnmarshalIQElement(ctx, payload("unblock", j), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+func payload(local string, j []jid.JID) xml.TokenReader {
+	var items []xml.TokenReader
+	for _, jj := range j {
+		items = append(items, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: jj.String()}},
+		}))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(items...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: local}},
+	)
+}
+
+// Handle returns an option that registers a Handler for blocklist pushes.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.IQ(stanza.SetIQ, xml.Name{Space: NS, Local: "block"}, h)(m)
+		mux.IQ(stanza.SetIQ, xml.Name{Space: NS, Local: "unblock"}, h)(m)
+	}
+}
+
+// Handler responds to blocklist pushes that are sent by the server when the
+// blocklist is changed by another client.
+// Any nil functions are ignored.
+type Handler struct {
+	// Block is called with the JIDs that were added to the blocklist.
+	Block func(j ...jid.JID) error
+
+	// Unblock is called with the JIDs that were removed from the blocklist.
+	// If no JIDs are passed, the entire blocklist was cleared.
+	Unblock func(j ...jid.JID) error
+}
+
+// HandleIQ implements mux.IQHandler.
+func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	// Pushes must come from our own account or they could be used to trick us
+	// into thinking that contacts were blocked.
+	if !iq.From.Equal(jid.JID{}) && !iq.From.Equal(iq.To.Bare()) {
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.Forbidden,
+		}))
+		return err
+	}
+
+	var j []jid.JID
+	iter := xmlstream.NewIter(t)
+	for iter.Next() {
+		item, _ := iter.Current()
+		if item == nil || item.Name.Local != "item" {
+			continue
+		}
+		_, raw := attr.Get(item.Attr, "jid")
+		jj, err := jid.Parse(raw)
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return err
+		}
+		j = append(j, jj)
+	}
+	err := iter.Err()
+	if err != nil {
+		return err
+	}
+
+	f := h.Block
+	if start.Name.Local == "unblock" {
+		f = h.Unblock
+	}
+	if f != nil {
+		err = f(j...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = xmlstream.Copy(t, iq.Result(nil))
+	return err
+}
@@ -0,0 +1,205 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blocklist_test
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/blocklist"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	iago    = jid.MustParse("iago@shakespeare.lit")
+	romeo   = jid.MustParse("romeo@montague.net")
+	juliet  = jid.MustParse("juliet@capulet.lit/balcony")
+	blocked = []jid.JID{iago, romeo}
+)
+
+func TestFetchBlockUnblock(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	record := func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var items []string
+		iter := xmlstream.NewIter(t)
+		for iter.Next() {
+			item, _ := iter.Current()
+			for _, a := range item.Attr {
+				if a.Name.Local == "jid" {
+					items = append(items, a.Value)
+				}
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		mu.Lock()
+		requests = append(requests, fmt.Sprintf("%s%v", start.Name.Local, items))
+		mu.Unlock()
+		_, err := xmlstream.Copy(t, iq.Result(nil))
+		return err
+	}
+	m := mux.New(
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: blocklist.NS, Local: "blocklist"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			d := xml.NewDecoder(strings.NewReader(`<blocklist xmlns="urn:xmpp:blocking"><item jid="iago@shakespeare.lit"/><item jid="romeo@montague.net"/></blocklist>`))
+			_, err := xmlstream.Copy(t, iq.Result(d))
+			return err
+		}),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: blocklist.NS, Local: "block"}, record),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: blocklist.NS, Local: "unblock"}, record),
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+	defer cs.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	iter := blocklist.Fetch(ctx, cs.Client)
+	var got []jid.JID
+	for iter.Next() {
+		got = append(got, iter.JID())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over blocklist: %v", err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatalf("error closing iter: %v", err)
+	}
+	if len(got) != len(blocked) {
+		t.Fatalf("wrong number of blocked JIDs: want=%d, got=%d", len(blocked), len(got))
+	}
+	for i, j := range got {
+		if !j.Equal(blocked[i]) {
+			t.Errorf("wrong JID at %d: want=%v, got=%v", i, blocked[i], j)
+		}
+	}
+
+	err := blocklist.Block(ctx, cs.Client, blocked...)
+	if err != nil {
+		t.Fatalf("error blocking: %v", err)
+	}
+	err = blocklist.Unblock(ctx, cs.Client, iago)
+	if err != nil {
+		t.Fatalf("error unblocking: %v", err)
+	}
+	err = blocklist.Unblock(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error unblocking all: %v", err)
+	}
+	want := []string{
+		"block[iago@shakespeare.lit romeo@montague.net]",
+		"unblock[iago@shakespeare.lit]",
+		"unblock[]",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("wrong requests: want=%v, got=%v", want, requests)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	pushes := make(chan string, 3)
+	h := blocklist.Handler{
+		Block: func(j ...jid.JID) error {
+			pushes <- fmt.Sprintf("block%v", j)
+			return nil
+		},
+		Unblock: func(j ...jid.JID) error {
+			pushes <- fmt.Sprintf("unblock%v", j)
+			return nil
+		},
+	}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(blocklist.Handle(h))),
+		xmpptest.ServerHandler(mux.New()),
+	)
+	defer cs.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		raw  string
+		want string
+	}{
+		{
+			raw:  `<iq type="set" id="push1"><block xmlns="urn:xmpp:blocking"><item jid="romeo@montague.net"/></block></iq>`,
+			want: "block[romeo@montague.net]",
+		},
+		{
+			raw:  `<iq type="set" id="push2"><unblock xmlns="urn:xmpp:blocking"/></iq>`,
+			want: "unblock[]",
+		},
+	} {
+		resp, err := cs.Server.SendIQ(ctx, xml.NewDecoder(strings.NewReader(tc.raw)))
+		if err != nil {
+			t.Fatalf("error sending push: %v", err)
+		}
+		/* #nosec */
+		resp.Close()
+		select {
+		case got := <-pushes:
+			if got != tc.want {
+				t.Errorf("wrong push: want=%q, got=%q", tc.want, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for push %q", tc.want)
+		}
+	}
+
+	// Pushes from other entities must be rejected.
+	resp, err := cs.Server.SendIQ(ctx, xml.NewDecoder(strings.NewReader(`<iq type="set" id="push3" from="mallory@example.net"><block xmlns="urn:xmpp:blocking"><item jid="juliet@capulet.lit"/></block></iq>`)))
+	if err != nil {
+		t.Fatalf("error sending spoofed push: %v", err)
+	}
+	defer resp.Close()
+	tok, err := resp.Token()
+	if err != nil {
+		t.Fatalf("error reading response: %v", err)
+	}
+	start, _ := tok.(xml.StartElement)
+	for _, a := range start.Attr {
+		if a.Name.Local == "type" && a.Value != string(stanza.ErrorIQ) {
+			t.Errorf("expected spoofed push to be rejected, got type=%q", a.Value)
+		}
+	}
+	select {
+	case got := <-pushes:
+		t.Errorf("spoofed push was handled: %q", got)
+	default:
+	}
+}
+
+func TestIsBlocked(t *testing.T) {
+	var resp struct {
+		stanza.Message
+		Err stanza.Error `xml:"error"`
+	}
+	err := xml.Unmarshal([]byte(`<message type="error" from="juliet@capulet.lit/balcony"><error type="cancel"><not-acceptable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/><blocked xmlns="urn:xmpp:blocking:errors"/></error></message>`), &resp)
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	if !resp.From.Equal(juliet) {
+		t.Errorf("wrong from: want=%v, got=%v", juliet, resp.From)
+	}
+	if !blocklist.IsBlocked(resp.Err) {
+		t.Errorf("expected error to be a blocked error: %+v", resp.Err)
+	}
+	if !blocklist.IsBlocked(fmt.Errorf("wrapped: %w", resp.Err)) {
+		t.Errorf("expected wrapped error to be a blocked error")
+	}
+	if blocklist.IsBlocked(stanza.Error{Condition: stanza.NotAcceptable}) {
+		t.Errorf("did not expect error without app condition to be a blocked error")
+	}
+}
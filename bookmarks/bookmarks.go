@@ -0,0 +1,217 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package bookmarks implements storing bookmarks to chat rooms.
+//
+// Bookmarks are stored as items in a personal eventing (PEP) node, one item
+// per chat room, so that they are synchronized between all of a users clients.
+// Older servers may not support storing bookmarks in PEP, in which case the
+// Legacy functions can be used to store them in private XML storage instead.
+// Servers that advertise the NSCompat feature (see Supported) keep the two
+// storage locations in sync.
+package bookmarks // import "mellium.im/xmpp/bookmarks"
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS       = "urn:xmpp:bookmarks:1"
+	NSCompat = NS + "#compat"
+	NSLegacy = "storage:bookmarks"
+
+	// NSNotify is the feature that must be advertised to receive notifications
+	// when bookmarks are changed by another client.
+	NSNotify = NS + "+notify"
+)
+
+// Bookmark is a chat room that the user has saved.
+type Bookmark struct {
+	JID      jid.JID
+	Name     string
+	Autojoin bool
+	Nick     string
+	Password string
+
+	// Extensions contains any extension elements that other clients may have
+	// added to the bookmark so that they are not lost when the bookmark is
+	// updated.
+	Extensions []xml.Token
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (b Bookmark) TokenReader() xml.TokenReader {
+	return b.conference(xml.Name{Space: NS, Local: "conference"}, false)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (b Bookmark) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, b.TokenReader())
+}
+
+func (b Bookmark) conference(name xml.Name, withJID bool) xml.TokenReader {
+	start := xml.StartElement{Name: name}
+	if b.Autojoin {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "autojoin"}, Value: strconv.FormatBool(b.Autojoin)})
+	}
+	if withJID {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "jid"}, Value: b.JID.String()})
+	}
+	if b.Name != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "name"}, Value: b.Name})
+	}
+
+	var child []xml.TokenReader
+	if b.Nick != "" {
+		child = append(child, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(b.Nick)),
+			xml.StartElement{Name: xml.Name{Local: "nick"}},
+		))
+	}
+	if b.Password != "" {
+		child = append(child, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(b.Password)),
+			xml.StartElement{Name: xml.Name{Local: "password"}},
+		))
+	}
+	if !withJID && len(b.Extensions) > 0 {
+		var ext []xml.TokenReader
+		for _, tok := range b.Extensions {
+			ext = append(ext, xmlstream.Token(tok))
+		}
+		child = append(child, xmlstream.Wrap(
+			xmlstream.MultiReader(ext...),
+			xml.StartElement{Name: xml.Name{Local: "extensions"}},
+		))
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(child...), start)
+}
+
+// conference is used to decode both the PEP and legacy formats.
+type conference struct {
+	JID        jid.JID    `xml:"jid,attr"`
+	Name       string     `xml:"name,attr"`
+	Autojoin   bool       `xml:"autojoin,attr"`
+	Nick       string     `xml:"nick"`
+	Password   string     `xml:"password"`
+	Extensions extensions `xml:"extensions"`
+}
+
+func (c conference) bookmark() Bookmark {
+	return Bookmark{
+		JID:        c.JID,
+		Name:       c.Name,
+		Autojoin:   c.Autojoin,
+		Nick:       c.Nick,
+		Password:   c.Password,
+		Extensions: []xml.Token(c.Extensions),
+	}
+}
+
+type extensions []xml.Token
+
+func (e *extensions) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	toks, err := xmlstream.ReadAll(xmlstream.Inner(d))
+	*e = toks
+	return err
+}
+
+// decodeItem decodes the payload of a bookmark item where id is the item ID.
+func decodeItem(id string, payload xml.TokenReader) (Bookmark, error) {
+	j, err := jid.Parse(id)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	var c conference
+	err = xml.NewTokenDecoder(payload).Decode(&c)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	b := c.bookmark()
+	b.JID = j
+	return b, nil
+}
+
+// Supported returns true if the disco info of the users account indicates
+// that bookmarks stored in PEP and in private XML storage are kept in sync by
+// the server.
+func Supported(info disco.Info) bool {
+	for _, f := range info.Features {
+		if f.Var == NSCompat {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns all of the users bookmarks.
+func List(ctx context.Context, s *xmpp.Session) ([]Bookmark, error) {
+	iter := pubsub.Fetch(ctx, s, jid.JID{}, pubsub.Query{Node: NS})
+	var bookmarks []Bookmark
+	for iter.Next() {
+		b, err := decodeItem(iter.ID(), iter.Item())
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return bookmarks, err
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	err := iter.Err()
+	if err != nil {
+		/* #nosec */
+		iter.Close()
+		return bookmarks, err
+	}
+	return bookmarks, iter.Close()
+}
+
+// publishOptions are the node configuration options required for bookmarks
+// to remain private and to allow one item per chat room.
+var publishOptions = pubsub.PublishOptions(
+	form.Boolean("pubsub#persist_items", form.Value("true")),
+	form.Text("pubsub#max_items", form.Value("max")),
+	form.Text("pubsub#send_last_published_item", form.Value("never")),
+	form.Text("pubsub#access_model", form.Value("whitelist")),
+)
+
+// Add stores a bookmark.
+// If a bookmark for the same chat room already exists it is replaced.
+func Add(ctx context.Context, s *xmpp.Session, b Bookmark) error {
+	_, err := pubsub.PublishWithOptions(ctx, s, jid.JID{}, NS, b.JID.Bare().String(), publishOptions, b.TokenReader())
+	return err
+}
+
+// Remove deletes the bookmark for the chat room j.
+func Remove(ctx context.Context, s *xmpp.Session, j jid.JID) error {
+	// This is like pubsub.Retract, but other clients must be notified when a
+	// bookmark is removed.
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: j.Bare().String()}},
+			}),
+			xml.StartElement{
+				Name: xml.Name{Local: "retract"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "node"}, Value: NS},
+					{Name: xml.Name{Local: "notify"}, Value: "true"},
+				},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: pubsub.NS, Local: "pubsub"}},
+	), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
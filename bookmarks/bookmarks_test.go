@@ -0,0 +1,207 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bookmarks_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+)
+
+var theatre = bookmarks.Bookmark{
+	JID:      jid.MustParse("theplay@conference.shakespeare.lit"),
+	Name:     "The Play's the Thing",
+	Autojoin: true,
+	Nick:     "JC",
+}
+
+func TestAddListRemove(t *testing.T) {
+	var req struct {
+		Publish struct {
+			Node string `xml:"node,attr"`
+			Item struct {
+				ID         string `xml:"id,attr"`
+				Conference struct {
+					Name     string `xml:"name,attr"`
+					Autojoin bool   `xml:"autojoin,attr"`
+					Nick     string `xml:"nick"`
+				} `xml:"urn:xmpp:bookmarks:1 conference"`
+			} `xml:"item"`
+		} `xml:"publish"`
+		Options struct {
+			Form form.Data `xml:"jabber:x:data x"`
+		} `xml:"publish-options"`
+		Retract struct {
+			Node   string `xml:"node,attr"`
+			Notify string `xml:"notify,attr"`
+			Item   struct {
+				ID string `xml:"id,attr"`
+			} `xml:"item"`
+		} `xml:"retract"`
+	}
+	m := mux.New(
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+			if err != nil {
+				return err
+			}
+			_, err = xmlstream.Copy(t, iq.Result(nil))
+			return err
+		}),
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			d := xml.NewDecoder(strings.NewReader(`<pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="urn:xmpp:bookmarks:1"><item id="theplay@conference.shakespeare.lit"><conference xmlns="urn:xmpp:bookmarks:1" name="The Play&apos;s the Thing" autojoin="true"><nick>JC</nick><extensions><state xmlns="http://myclient.example/bookmark/state" minimized="true"/></extensions></conference></item></items></pubsub>`))
+			_, err := xmlstream.Copy(t, iq.Result(d))
+			return err
+		}),
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+	ctx := context.Background()
+
+	err := bookmarks.Add(ctx, cs.Client, theatre)
+	if err != nil {
+		t.Fatalf("error adding bookmark: %v", err)
+	}
+	if req.Publish.Node != bookmarks.NS || req.Publish.Item.ID != theatre.JID.String() {
+		t.Errorf("wrong publish request: %+v", req.Publish)
+	}
+	if c := req.Publish.Item.Conference; c.Name != theatre.Name || !c.Autojoin || c.Nick != theatre.Nick {
+		t.Errorf("wrong conference published: %+v", c)
+	}
+	if access, _ := req.Options.Form.GetString("pubsub#access_model"); access != "whitelist" {
+		t.Errorf("wrong access model in publish options: want=whitelist, got=%q", access)
+	}
+
+	list, err := bookmarks.List(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error listing bookmarks: %v", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("wrong number of bookmarks: want=1, got=%d", len(list))
+	}
+	b := list[0]
+	if !b.JID.Equal(theatre.JID) || b.Name != theatre.Name || !b.Autojoin || b.Nick != theatre.Nick {
+		t.Errorf("wrong bookmark: want=%+v, got=%+v", theatre, b)
+	}
+	if len(b.Extensions) != 2 {
+		t.Errorf("expected extensions to be preserved, got %v", b.Extensions)
+	}
+
+	err = bookmarks.Remove(ctx, cs.Client, theatre.JID)
+	if err != nil {
+		t.Fatalf("error removing bookmark: %v", err)
+	}
+	if r := req.Retract; r.Node != bookmarks.NS || r.Notify != "true" || r.Item.ID != theatre.JID.String() {
+		t.Errorf("wrong retract request: %+v", r)
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	var stored []xml.Token
+	m := mux.New(
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: bookmarks.NSPrivate, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var err error
+			stored, err = xmlstream.ReadAll(xmlstream.Inner(t))
+			if err != nil {
+				return err
+			}
+			_, err = xmlstream.Copy(t, iq.Result(nil))
+			return err
+		}),
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: bookmarks.NSPrivate, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var payload []xml.TokenReader
+			for _, tok := range stored {
+				payload = append(payload, xmlstream.Token(tok))
+			}
+			_, err := xmlstream.Copy(t, iq.Result(xmlstream.Wrap(
+				xmlstream.MultiReader(payload...),
+				xml.StartElement{Name: xml.Name{Space: bookmarks.NSPrivate, Local: "query"}},
+			)))
+			return err
+		}),
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+	ctx := context.Background()
+
+	other := bookmarks.Bookmark{JID: jid.MustParse("orchard@conference.shakespeare.lit")}
+	for _, b := range []bookmarks.Bookmark{theatre, other, theatre} {
+		err := bookmarks.AddLegacy(ctx, cs.Client, b)
+		if err != nil {
+			t.Fatalf("error adding legacy bookmark: %v", err)
+		}
+	}
+	err := bookmarks.RemoveLegacy(ctx, cs.Client, other.JID)
+	if err != nil {
+		t.Fatalf("error removing legacy bookmark: %v", err)
+	}
+	list, err := bookmarks.ListLegacy(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error listing legacy bookmarks: %v", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("wrong number of legacy bookmarks: want=1, got=%d: %+v", len(list), list)
+	}
+	b := list[0]
+	if !b.JID.Equal(theatre.JID) || b.Name != theatre.Name || !b.Autojoin || b.Nick != theatre.Nick {
+		t.Errorf("wrong legacy bookmark: want=%+v, got=%+v", theatre, b)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	added := make(chan bookmarks.Bookmark, 1)
+	removed := make(chan jid.JID, 1)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(bookmarks.Handle(bookmarks.Handler{
+			Added: func(_ jid.JID, b bookmarks.Bookmark) error {
+				added <- b
+				return nil
+			},
+			Removed: func(_, j jid.JID) error {
+				removed <- j
+				return nil
+			},
+		}))),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, raw := range []string{
+		`<message xmlns="jabber:client" from="juliet@capulet.lit" to="juliet@capulet.lit/balcony" type="headline"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:bookmarks:1"><item id="theplay@conference.shakespeare.lit"><conference xmlns="urn:xmpp:bookmarks:1" name="The Play&apos;s the Thing" autojoin="true"><nick>JC</nick></conference></item></items></event></message>`,
+		`<message xmlns="jabber:client" from="juliet@capulet.lit" to="juliet@capulet.lit/balcony" type="headline"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:bookmarks:1"><retract id="theplay@conference.shakespeare.lit"/></items></event></message>`,
+	} {
+		err := cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("error sending notification: %v", err)
+		}
+	}
+
+	select {
+	case b := <-added:
+		if !b.JID.Equal(theatre.JID) || b.Name != theatre.Name || !b.Autojoin || b.Nick != theatre.Nick {
+			t.Errorf("wrong bookmark added: want=%+v, got=%+v", theatre, b)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for added bookmark")
+	}
+	select {
+	case j := <-removed:
+		if !j.Equal(theatre.JID) {
+			t.Errorf("wrong bookmark removed: want=%v, got=%v", theatre.JID, j)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for removed bookmark")
+	}
+}
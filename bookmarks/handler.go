@@ -0,0 +1,67 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bookmarks
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/pubsub"
+)
+
+// Handle returns an option that registers a Handler for bookmark change
+// notifications.
+// Because it handles all pubsub event notifications it cannot be used along
+// with other handlers registered using pubsub.Handle.
+// To handle other notifications as well, use the Item and Retract methods of
+// the Handler from a pubsub.Handler.
+func Handle(h Handler) mux.Option {
+	return pubsub.Handle(pubsub.Handler{
+		Item:    h.Item,
+		Retract: h.Retract,
+	})
+}
+
+// Handler receives notifications when bookmarks are changed by another client.
+//
+// Notifications should only ever come from the users own bare JID but anyone
+// can send them, so the functions are passed the address of the sender and
+// should ignore notifications from anyone else.
+type Handler struct {
+	// Added is called when a bookmark is added or updated.
+	Added func(from jid.JID, b Bookmark) error
+
+	// Removed is called with the address of the chat room when a bookmark is
+	// removed.
+	Removed func(from, j jid.JID) error
+}
+
+// Item handles an item published to a pubsub node.
+// Items from nodes other than the bookmarks node are ignored.
+func (h Handler) Item(from jid.JID, node, id string, payload xml.TokenReader) error {
+	if node != NS || h.Added == nil {
+		return nil
+	}
+	b, err := decodeItem(id, payload)
+	if err != nil {
+		return err
+	}
+	return h.Added(from, b)
+}
+
+// Retract handles an item being removed from a pubsub node.
+// Items from nodes other than the bookmarks node are ignored.
+func (h Handler) Retract(from jid.JID, node, id string) error {
+	if node != NS || h.Removed == nil {
+		return nil
+	}
+	j, err := jid.Parse(id)
+	if err != nil {
+		return err
+	}
+	return h.Removed(from, j)
+}
+
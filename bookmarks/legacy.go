@@ -0,0 +1,95 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bookmarks
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSPrivate is the namespace of private XML storage, which is used to store
+// legacy bookmarks.
+const NSPrivate = "jabber:iq:private"
+
+func storage(bookmarks []Bookmark) xml.TokenReader {
+	var conferences []xml.TokenReader
+	for _, b := range bookmarks {
+		conferences = append(conferences, b.conference(xml.Name{Local: "conference"}, true))
+	}
+	return xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.MultiReader(conferences...),
+			xml.StartElement{Name: xml.Name{Space: NSLegacy, Local: "storage"}},
+		),
+		xml.StartElement{Name: xml.Name{Space: NSPrivate, Local: "query"}},
+	)
+}
+
+// ListLegacy returns all of the bookmarks in private XML storage.
+// Extensions are not supported by the legacy format and will always be empty.
+func ListLegacy(ctx context.Context, s *xmpp.Session) ([]Bookmark, error) {
+	resp := struct {
+		XMLName xml.Name `xml:"jabber:iq:private query"`
+		Storage struct {
+			Conference []conference `xml:"conference"`
+		} `xml:"storage:bookmarks storage"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, storage(nil), stanza.IQ{Type: stanza.GetIQ}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	var bookmarks []Bookmark
+	for _, c := range resp.Storage.Conference {
+		bookmarks = append(bookmarks, c.bookmark())
+	}
+	return bookmarks, nil
+}
+
+// SetLegacy replaces all of the bookmarks in private XML storage.
+func SetLegacy(ctx context.Context, s *xmpp.Session, bookmarks []Bookmark) error {
+	return s.UnmarshalIQElement(ctx, storage(bookmarks), stanza.IQ{Type: stanza.SetIQ}, nil)
+}
+
+// AddLegacy stores a bookmark in private XML storage.
+// If a bookmark for the same chat room already exists it is replaced.
+//
+// Private XML storage can only be updated all at once, so the existing
+// bookmarks are fetched and then stored again with the new bookmark.
+// If another client updates the bookmarks at the same time, one of the
+// changes may be lost.
+func AddLegacy(ctx context.Context, s *xmpp.Session, b Bookmark) error {
+	bookmarks, err := ListLegacy(ctx, s)
+	if err != nil {
+		return err
+	}
+	bookmarks = removeBookmark(bookmarks, b.JID)
+	return SetLegacy(ctx, s, append(bookmarks, b))
+}
+
+// RemoveLegacy deletes the bookmark for the chat room j from private XML
+// storage.
+// It has the same limitations as AddLegacy.
+func RemoveLegacy(ctx context.Context, s *xmpp.Session, j jid.JID) error {
+	bookmarks, err := ListLegacy(ctx, s)
+	if err != nil {
+		return err
+	}
+	return SetLegacy(ctx, s, removeBookmark(bookmarks, j))
+}
+
+func removeBookmark(bookmarks []Bookmark, j jid.JID) []Bookmark {
+	filtered := bookmarks[:0]
+	for _, b := range bookmarks {
+		if !b.JID.Bare().Equal(j.Bare()) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package bosh implements the BOSH HTTP transport for XMPP.
+//
+// BOSH (Bidirectional-streams Over Synchronous HTTP) emulates a long lived
+// connection using a series of HTTP requests that are held open by a
+// connection manager until it has data to send, as described in XEP-0124 and
+// XEP-0206.
+// It is mostly useful in environments where only HTTP(S) traffic is allowed.
+//
+// The connections returned by this package translate the stream that is
+// written to them into BOSH requests and present the responses as a normal XMPP
+// stream, so they can be used with the normal session negotiation functions:
+//
+//     conn, err := bosh.Dial(ctx, addr)
+//     if err != nil {
+//         return err
+//     }
+//     session, err := bosh.NewSession(ctx, addr, conn, features...)
+package bosh // import "mellium.im/xmpp/bosh"
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/discover"
+	"mellium.im/xmpp/jid"
+)
+
+// Various constants used by this package, provided as a convenience.
+const (
+	// NS is the namespace of the BOSH body wrapper element.
+	NS = "http://jabber.org/protocol/httpbind"
+
+	// NSXBOSH is the namespace used for the XMPP specific BOSH attributes.
+	NSXBOSH = "urn:xmpp:xbosh"
+)
+
+// Defaults used for any Dialer options that are not set.
+const (
+	DefaultWait    = 60 * time.Second
+	DefaultHold    = 1
+	DefaultRetries = 3
+)
+
+// NewSession establishes an XMPP session from the perspective of the initiating
+// client on conn, which should normally have been created by Dial.
+// If conn is a BOSH connection using HTTPS the session is considered secure and
+// StartTLS will not be negotiated.
+func NewSession(ctx context.Context, addr jid.JID, conn net.Conn, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
+	n := xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return features
+		},
+	})
+	var mask xmpp.SessionState
+	if c, ok := conn.(*Conn); ok && c.secure() {
+		mask |= xmpp.Secure
+	}
+	return xmpp.NewSession(ctx, addr.Domain(), addr, conn, mask, n)
+}
+
+// DialSession uses a default dialer to create a BOSH connection and attempts to
+// negotiate an XMPP session over it.
+//
+// If the provided context is canceled after stream negotiation is complete it
+// has no effect on the session.
+func DialSession(ctx context.Context, addr jid.JID, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
+	conn, err := Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewSession(ctx, addr, conn, features...)
+}
+
+// Dial discovers BOSH endpoints associated with the given address and returns a
+// connection that will use the first suitable one.
+//
+// Calling Dial is the equivalent of creating a Dialer with no options set and
+// calling its Dial method.
+func Dial(ctx context.Context, addr jid.JID) (net.Conn, error) {
+	var d Dialer
+	return d.Dial(ctx, addr)
+}
+
+// DialDirect returns a connection that uses the provided BOSH endpoint without
+// performing any TXT or Web Host Metadata file lookup.
+//
+// Calling DialDirect is the equivalent of creating a Dialer with no options set
+// and calling its DialDirect method.
+func DialDirect(ctx context.Context, endpoint string) (net.Conn, error) {
+	var d Dialer
+	return d.DialDirect(ctx, endpoint)
+}
+
+// Dialer discovers BOSH endpoints and creates connections to them.
+// The zero value for each field is equivalent to dialing without that option.
+// Dialing with the zero value of Dialer is equivalent to calling the Dial
+// function.
+type Dialer struct {
+	// Allow falling back to BOSH endpoints that do not use TLS.
+	// If endpoint discovery is used and a secure endpoint is available it will
+	// still be prioritized.
+	//
+	// The BOSH transport does not support StartTLS so this value will fall back
+	// to using plain HTTP and is therefore insecure and should never be used.
+	InsecureNoTLS bool
+
+	// HTTP Client used for BOSH requests and to look up Web Host Metadata files.
+	// Its timeout, if any, should be longer than Wait.
+	Client *http.Client
+
+	// Resolver to use when looking up TXT records.
+	Resolver *net.Resolver
+
+	// Wait is the longest time that the connection manager may hold a request
+	// before responding.
+	// Longer waits mean fewer requests, but some proxies drop HTTP requests that
+	// are idle for too long.
+	// If Wait is zero, DefaultWait is used.
+	Wait time.Duration
+
+	// Hold is the number of requests that the connection manager may keep
+	// waiting at any one time.
+	// Increasing it may reduce latency when sending many stanzas at the cost of
+	// more open HTTP connections.
+	// If Hold is zero, DefaultHold is used.
+	Hold int
+
+	// Retries is the number of times a request is sent again, with the same
+	// request ID, if the HTTP request fails before a response is received.
+	// If Retries is zero, DefaultRetries is used and if it is negative failed
+	// requests are never retried.
+	Retries int
+}
+
+// Dial discovers BOSH endpoints for addr using DNS TXT records and Web Host
+// Metadata files and returns a connection that will use the first suitable one.
+// HTTPS endpoints are always preferred.
+//
+// No HTTP requests are made to the endpoint until the XMPP stream is opened, so
+// errors connecting to it will be reported by the session negotiation instead.
+func (d *Dialer) Dial(ctx context.Context, addr jid.JID) (net.Conn, error) {
+	httpClient := d.Client
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	netResolver := d.Resolver
+	if netResolver == nil {
+		netResolver = &net.Resolver{}
+	}
+
+	urls, err := discover.LookupBOSH(ctx, netResolver, httpClient, addr)
+	if err != nil {
+		return nil, err
+	}
+	discover.SortByScheme(urls, "https", "http")
+	for _, u := range urls {
+		conn, err := d.DialDirect(ctx, u)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("bosh: no usable BOSH endpoint found on %s", addr.Domainpart())
+}
+
+// DialDirect returns a connection that uses the provided BOSH endpoint without
+// performing any TXT or Web Host Metadata file lookup.
+//
+// Context is currently not used since no requests are made until the XMPP
+// stream is opened.
+func (d *Dialer) DialDirect(_ context.Context, endpoint string) (net.Conn, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !d.InsecureNoTLS {
+			return nil, fmt.Errorf("bosh: refusing to use insecure endpoint %s", endpoint)
+		}
+	default:
+		return nil, fmt.Errorf("bosh: unsupported scheme %q", u.Scheme)
+	}
+
+	c := newConn(u.String())
+	if d.Client != nil {
+		c.client = d.Client
+	}
+	if d.Wait > 0 {
+		c.wait = d.Wait
+	}
+	if d.Hold > 0 {
+		c.hold = d.Hold
+	}
+	switch {
+	case d.Retries > 0:
+		c.retries = d.Retries
+	case d.Retries < 0:
+		c.retries = 0
+	}
+	go c.writeLoop()
+	go c.run()
+	return c, nil
+}
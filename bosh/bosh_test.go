@@ -0,0 +1,300 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bosh_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/bosh"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+type testBody struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/httpbind body"`
+	RID     uint64   `xml:"rid,attr"`
+	SID     string   `xml:"sid,attr"`
+	Type    string   `xml:"type,attr"`
+	Restart string   `xml:"urn:xmpp:xbosh restart,attr"`
+	Inner   string   `xml:",innerxml"`
+}
+
+// manager is a minimal connection manager that echos any payloads back to the
+// client in the response to a waiting request.
+type manager struct {
+	condition string
+
+	mu   sync.Mutex
+	reqs []testBody
+	drop int
+	out  chan string
+	term chan struct{}
+}
+
+func newManager() *manager {
+	return &manager{
+		drop: -1,
+		out:  make(chan string, 10),
+		term: make(chan struct{}),
+	}
+}
+
+func (m *manager) requests() []testBody {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]testBody(nil), m.reqs...)
+}
+
+func (m *manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b testBody
+	if err := xml.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	drop := len(m.reqs) == m.drop
+	m.reqs = append(m.reqs, b)
+	m.mu.Unlock()
+
+	if drop {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	const features = `<body xmlns='` + bosh.NS + `' xmlns:stream='http://etherx.jabber.org/streams' sid='sid1' wait='60' hold='1' requests='2'><stream:features/></body>`
+	switch {
+	case b.SID == "" && m.condition != "":
+		fmt.Fprintf(w, `<body xmlns='%s' type='terminate' condition='%s'/>`, bosh.NS, m.condition)
+	case b.SID == "" || b.Restart == "true":
+		fmt.Fprint(w, features)
+	case b.Type == "terminate":
+		close(m.term)
+		fmt.Fprintf(w, `<body xmlns='%s' type='terminate'/>`, bosh.NS)
+	case b.Inner != "":
+		m.out <- b.Inner
+		fmt.Fprintf(w, `<body xmlns='%s'/>`, bosh.NS)
+	default:
+		select {
+		case s := <-m.out:
+			fmt.Fprintf(w, `<body xmlns='%s'>%s</body>`, bosh.NS, s)
+		case <-m.term:
+			fmt.Fprintf(w, `<body xmlns='%s'/>`, bosh.NS)
+		case <-time.After(time.Second):
+			fmt.Fprintf(w, `<body xmlns='%s'/>`, bosh.NS)
+		}
+	}
+}
+
+func dial(t *testing.T, m *manager) (*bosh.Conn, func()) {
+	srv := httptest.NewServer(m)
+	d := bosh.Dialer{
+		InsecureNoTLS: true,
+		Client:        srv.Client(),
+	}
+	conn, err := d.DialDirect(context.Background(), srv.URL)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("error dialing: %v", err)
+	}
+	return conn.(*bosh.Conn), srv.Close
+}
+
+func testSession(t *testing.T, m *manager) {
+	t.Helper()
+	conn, closeSrv := dial(t, m)
+	defer closeSrv()
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := bosh.NewSession(ctx, jid.MustParse("me@example.net"), conn)
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	if session.State()&xmpp.Secure != 0 {
+		t.Errorf("session over HTTP should not be secure")
+	}
+
+	msgs := make(chan stanza.Message, 1)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- session.Serve(xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			msg, err := stanza.NewMessage(*start)
+			if err == nil {
+				msgs <- msg
+			}
+			return nil
+		}))
+	}()
+
+	err = session.Send(ctx, stanza.Message{
+		ID:   "123",
+		To:   jid.MustParse("me@example.net"),
+		Type: stanza.ChatMessage,
+	}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.ID != "123" {
+			t.Errorf("wrong message echoed: want=123, got=%s", msg.ID)
+		}
+	case <-ctx.Done():
+		t.Fatalf("message was never echoed")
+	}
+
+	err = session.Close()
+	if err != nil {
+		t.Fatalf("error closing session: %v", err)
+	}
+	select {
+	case err = <-serveErr:
+		if err != nil {
+			t.Errorf("unexpected error from serve: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatalf("session was not terminated")
+	}
+}
+
+func TestSession(t *testing.T) {
+	m := newManager()
+	testSession(t, m)
+
+	reqs := m.requests()
+	if reqs[0].SID != "" {
+		t.Errorf("first request should create a session, got sid %q", reqs[0].SID)
+	}
+	first := reqs[0].RID
+	seen := make(map[uint64]bool)
+	for _, req := range reqs {
+		if req.RID < first || req.RID >= first+uint64(len(reqs)) || seen[req.RID] {
+			t.Errorf("unexpected request ID %d, first was %d", req.RID, first)
+		}
+		seen[req.RID] = true
+		if req.RID != first && req.SID != "sid1" {
+			t.Errorf("wrong session ID: want=sid1, got=%q", req.SID)
+		}
+	}
+	var terminated bool
+	for _, req := range reqs {
+		terminated = terminated || req.Type == "terminate"
+	}
+	if !terminated {
+		t.Errorf("session was never terminated")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	m := newManager()
+	m.drop = 1
+	testSession(t, m)
+
+	reqs := m.requests()
+	var n int
+	for _, req := range reqs {
+		if req.RID == reqs[1].RID {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("dropped request was sent %d times, want 2", n)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	m := newManager()
+	conn, closeSrv := dial(t, m)
+	defer closeSrv()
+	defer conn.Close()
+
+	const header = `<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0' to='example.net' xml:lang='en'>`
+	d := xml.NewDecoder(conn)
+	for i := 0; i < 2; i++ {
+		_, err := fmt.Fprint(conn, header)
+		if err != nil {
+			t.Fatalf("error writing header %d: %v", i, err)
+		}
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatalf("error reading header %d: %v", i, err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "stream" {
+			t.Fatalf("expected stream header, got %#v", tok)
+		}
+		for _, a := range start.Attr {
+			if a.Name.Local == "id" && a.Value != "sid1" {
+				t.Errorf("wrong stream ID: want=sid1, got=%s", a.Value)
+			}
+		}
+		tok, err = d.Token()
+		if start, ok := tok.(xml.StartElement); err != nil || !ok || start.Name.Local != "features" {
+			t.Fatalf("expected features, got %#v: %v", tok, err)
+		}
+		err = xmlstream.Skip(d)
+		if err != nil {
+			t.Fatalf("error reading features: %v", err)
+		}
+	}
+
+	var restarted bool
+	for _, req := range m.requests() {
+		if req.Restart == "true" {
+			restarted = true
+			if req.SID != "sid1" {
+				t.Errorf("wrong session ID on restart: want=sid1, got=%q", req.SID)
+			}
+		}
+	}
+	if !restarted {
+		t.Errorf("stream was never restarted")
+	}
+}
+
+func TestTerminateCondition(t *testing.T) {
+	m := newManager()
+	m.condition = "host-unknown"
+	conn, closeSrv := dial(t, m)
+	defer closeSrv()
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := bosh.NewSession(ctx, jid.MustParse("me@example.net"), conn)
+	var boshErr bosh.Error
+	if !errors.As(err, &boshErr) || boshErr.Condition != "host-unknown" {
+		t.Errorf("wrong error: want=host-unknown, got=%v", err)
+	}
+}
+
+func TestDialDirectScheme(t *testing.T) {
+	for _, endpoint := range []string{
+		"http://example.net/bosh",
+		"ws://example.net/bosh",
+		"://",
+	} {
+		t.Run(endpoint, func(t *testing.T) {
+			_, err := bosh.DialDirect(context.Background(), endpoint)
+			if err == nil {
+				t.Errorf("expected error dialing %s", endpoint)
+			}
+		})
+	}
+}
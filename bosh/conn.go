@@ -0,0 +1,685 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bosh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mellium.im/xmpp/stream"
+)
+
+const (
+	// requestMargin is added to the wait time to get the timeout for individual
+	// HTTP requests, giving the connection manager some time to respond after it
+	// stops holding the request.
+	requestMargin = 20 * time.Second
+
+	// retryDelay is multiplied by the number of the attempt to get the time to
+	// wait before sending a failed request again.
+	retryDelay = 250 * time.Millisecond
+)
+
+var errClosed = errors.New("bosh: use of closed connection")
+
+// Error is returned when reading from a connection after the connection manager
+// terminated the session with an error condition.
+type Error struct {
+	Condition string
+}
+
+// Error satisfies the error interface.
+func (e Error) Error() string {
+	return "bosh: session terminated by connection manager: " + e.Condition
+}
+
+// statusError is returned for HTTP responses that indicate that the request was
+// received and rejected, so sending it again is pointless.
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("bosh: unexpected HTTP status %d %s", int(e), http.StatusText(int(e)))
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "bosh: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type addr string
+
+func (addr) Network() string  { return "bosh" }
+func (a addr) String() string { return string(a) }
+
+type itemKind uint8
+
+const (
+	itemPayload itemKind = iota
+	itemOpen
+	itemClose
+	itemEOF
+)
+
+// item is something written to the connection that is handled by the request
+// loop.
+type item struct {
+	kind itemKind
+	b    []byte
+	attr []xml.Attr
+}
+
+type request struct {
+	rid  uint64
+	kind itemKind
+	body []byte
+}
+
+type result struct {
+	req  *request
+	body []byte
+	err  error
+}
+
+// Conn is a net.Conn that sends the XMPP stream written to it to a BOSH
+// connection manager and presents the responses as an XMPP stream.
+//
+// Writing a stream header creates a new BOSH session (or restarts the stream if
+// a session already exists), and writing the end of the stream terminates it.
+// Request IDs are managed automatically and requests that fail before a
+// response is received are sent again with the same request ID, letting the
+// connection manager recover without losing stanzas.
+//
+// Write deadlines are not supported and Write never blocks waiting on the
+// network.
+// Close does not terminate the BOSH session unless the end of the stream has
+// already been written (for example by closing the XMPP session).
+type Conn struct {
+	endpoint string
+	client   *http.Client
+	wait     time.Duration
+	hold     int
+	retries  int
+
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+	items chan item
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	closeOnce sync.Once
+
+	readMu   sync.Mutex
+	readCond *sync.Cond
+	readBuf  bytes.Buffer
+	readErr  error
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newConn(endpoint string) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	c := &Conn{
+		endpoint: endpoint,
+		client:   http.DefaultClient,
+		wait:     DefaultWait,
+		hold:     DefaultHold,
+		retries:  DefaultRetries,
+		pr:       pr,
+		pw:       pw,
+		items:    make(chan item),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	c.readCond = sync.NewCond(&c.readMu)
+	return c
+}
+
+func (c *Conn) secure() bool {
+	return strings.HasPrefix(c.endpoint, "https:")
+}
+
+// Read reads data from the stream sent by the connection manager.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for c.readBuf.Len() == 0 && c.readErr == nil {
+		if !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+			return 0, timeoutError{}
+		}
+		c.readCond.Wait()
+	}
+	if c.readBuf.Len() > 0 {
+		return c.readBuf.Read(p)
+	}
+	return 0, c.readErr
+}
+
+// Write writes data to the stream that is sent to the connection manager.
+// Top level elements are sent once they have been written completely.
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.pw.Write(p)
+}
+
+// Close closes the connection and cancels any outstanding requests, except that
+// if a request to terminate the session is in flight Close waits for it to
+// complete.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		/* #nosec */
+		c.pw.CloseWithError(errClosed)
+		c.readMu.Lock()
+		if c.readErr == nil {
+			c.readErr = errClosed
+		}
+		c.readCond.Broadcast()
+		c.readMu.Unlock()
+	})
+	<-c.done
+	return nil
+}
+
+// LocalAddr returns the address of the BOSH endpoint.
+func (c *Conn) LocalAddr() net.Addr {
+	return addr(c.endpoint)
+}
+
+// RemoteAddr returns the address of the BOSH endpoint.
+func (c *Conn) RemoteAddr() net.Addr {
+	return addr(c.endpoint)
+}
+
+// SetDeadline is the same as calling SetReadDeadline.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future and pending Read calls.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.deadline = t
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if !t.IsZero() {
+		c.timer = time.AfterFunc(time.Until(t), func() {
+			c.readMu.Lock()
+			c.readCond.Broadcast()
+			c.readMu.Unlock()
+		})
+	}
+	c.readCond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline does nothing since writes never block on the network.
+func (c *Conn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *Conn) deliver(b []byte, err error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readErr != nil {
+		return
+	}
+	/* #nosec */
+	c.readBuf.Write(b)
+	c.readErr = err
+	c.readCond.Broadcast()
+}
+
+func (c *Conn) send(it item) bool {
+	select {
+	case c.items <- it:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// writeLoop splits the stream written to the connection into stream headers,
+// top level elements, and the end of the stream.
+func (c *Conn) writeLoop() {
+	var (
+		buf   bytes.Buffer
+		base  int64
+		start int64
+		depth int
+	)
+	d := xml.NewDecoder(io.TeeReader(c.pr, &buf))
+	for {
+		off := d.InputOffset()
+		tok, err := d.RawToken()
+		if err != nil {
+			// Make sure any further writes fail instead of blocking forever.
+			/* #nosec */
+			c.pr.CloseWithError(err)
+			c.send(item{kind: itemEOF})
+			return
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == "stream" && t.Name.Local == "stream" {
+				depth = 0
+				end := d.InputOffset()
+				buf.Next(int(end - base))
+				base = end
+				if !c.send(item{kind: itemOpen, attr: t.Attr}) {
+					return
+				}
+				continue
+			}
+			if depth == 0 {
+				start = off
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				if !c.send(item{kind: itemClose}) {
+					return
+				}
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			end := d.InputOffset()
+			b := make([]byte, end-start)
+			copy(b, buf.Bytes()[start-base:end-base])
+			buf.Next(int(end - base))
+			base = end
+			if !c.send(item{kind: itemPayload, b: b}) {
+				return
+			}
+		}
+	}
+}
+
+// run sends requests to the connection manager and delivers responses in
+// order.
+// It is the only goroutine that touches the session state.
+func (c *Conn) run() {
+	defer func() {
+		c.cancel()
+		c.deliver(nil, errClosed)
+		close(c.done)
+	}()
+
+	var (
+		queue       []item
+		sid         string
+		rid         uint64
+		nextRID     uint64
+		to, lang    string
+		xmlns       string
+		hold        = c.hold
+		maxRequests = 1
+		outstanding int
+		created     bool
+		closing     bool
+		terminating bool
+		eof         bool
+		pending     = make(map[uint64]result)
+		results     = make(chan result)
+	)
+	rid = initialRID()
+	nextRID = rid
+
+	do := func(kind itemKind, body []byte) {
+		req := &request{rid: rid, kind: kind, body: body}
+		rid++
+		outstanding++
+		go c.do(req, results)
+	}
+
+	for {
+		// Send everything that we are allowed to send.
+	send:
+		for {
+			switch {
+			case len(queue) > 0 && queue[0].kind == itemOpen:
+				if outstanding >= maxRequests || (sid == "" && outstanding > 0) {
+					break send
+				}
+				it := queue[0]
+				queue = queue[1:]
+				xmlns = "jabber:client"
+				for _, a := range it.attr {
+					switch {
+					case a.Name.Space == "" && a.Name.Local == "to":
+						to = a.Value
+					case a.Name.Space == "xml" && a.Name.Local == "lang":
+						lang = a.Value
+					case a.Name.Space == "" && a.Name.Local == "xmlns":
+						xmlns = a.Value
+					}
+				}
+				if sid == "" {
+					do(itemOpen, c.createBody(rid, to, lang))
+				} else {
+					do(itemOpen, restartBody(rid, sid, to, lang))
+				}
+				continue
+			case !created:
+				break send
+			case len(queue) > 0 && queue[0].kind == itemClose:
+				if outstanding >= maxRequests {
+					break send
+				}
+				queue = queue[1:]
+				terminating = true
+				do(itemClose, terminateBody(rid, sid))
+				continue
+			case len(queue) > 0 && queue[0].kind == itemPayload:
+				if outstanding >= maxRequests {
+					break send
+				}
+				var payload [][]byte
+				for len(queue) > 0 && queue[0].kind == itemPayload {
+					payload = append(payload, queue[0].b)
+					queue = queue[1:]
+				}
+				do(itemPayload, payloadBody(rid, sid, payload))
+				continue
+			case len(queue) == 0 && !terminating && outstanding < hold:
+				// Keep requests waiting at the connection manager so that it can
+				// push stanzas to us.
+				do(itemPayload, payloadBody(rid, sid, nil))
+				continue
+			}
+			break send
+		}
+
+		if eof && !terminating && len(queue) == 0 {
+			return
+		}
+		if terminating && outstanding == 0 {
+			return
+		}
+
+		select {
+		case it := <-c.items:
+			switch it.kind {
+			case itemEOF:
+				eof = true
+				if !terminating {
+					return
+				}
+			case itemClose:
+				closing = true
+				queue = append(queue, it)
+			default:
+				if closing {
+					continue
+				}
+				queue = append(queue, it)
+			}
+		case res := <-results:
+			outstanding--
+			if res.err != nil {
+				c.deliver(nil, res.err)
+				return
+			}
+			pending[res.req.rid] = res
+			for {
+				res, ok := pending[nextRID]
+				if !ok {
+					break
+				}
+				delete(pending, nextRID)
+				nextRID++
+
+				b, err := parseBody(res.body)
+				if err != nil {
+					c.deliver(nil, err)
+					return
+				}
+				if !created {
+					if b.typ == "terminate" {
+						// There is no stream to close yet, so just report why.
+						var err error = io.EOF
+						if b.condition != "" {
+							err = Error{Condition: b.condition}
+						}
+						c.deliver(nil, err)
+						return
+					}
+					if b.sid == "" {
+						c.deliver(nil, fmt.Errorf("bosh: session creation response did not contain a session ID: %w", stream.BadFormat))
+						return
+					}
+					created = true
+					sid = b.sid
+					if b.hasHold {
+						hold = b.hold
+					}
+					maxRequests = hold + 1
+					if b.requests > 0 {
+						maxRequests = b.requests
+					}
+				}
+
+				var out bytes.Buffer
+				if res.req.kind == itemOpen {
+					from := b.from
+					if from == "" {
+						from = to
+					}
+					fmt.Fprintf(&out, `<stream:stream xmlns='%s' xmlns:stream='%s' version='1.0' id='%s' from='%s'`,
+						escape(xmlns), stream.NS, escape(sid), escape(from))
+					if lang != "" {
+						fmt.Fprintf(&out, ` xml:lang='%s'`, escape(lang))
+					}
+					out.WriteString(`>`)
+				}
+				out.Write(b.inner)
+				if b.typ != "terminate" {
+					c.deliver(out.Bytes(), nil)
+					continue
+				}
+				out.WriteString(`</stream:stream>`)
+				if b.condition != "" {
+					c.deliver(out.Bytes(), Error{Condition: b.condition})
+				} else {
+					c.deliver(out.Bytes(), io.EOF)
+				}
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// do sends a request to the connection manager, trying again with the same
+// request ID if it fails without a response.
+func (c *Conn) do(req *request, results chan<- result) {
+	var (
+		body []byte
+		err  error
+	)
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			t := time.NewTimer(time.Duration(attempt) * retryDelay)
+			select {
+			case <-t.C:
+			case <-c.ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+		body, err = c.post(req.body)
+		var se statusError
+		if err == nil || errors.As(err, &se) || c.ctx.Err() != nil {
+			break
+		}
+	}
+	select {
+	case results <- result{req: req, body: body, err: err}:
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *Conn) post(body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.wait+requestMargin)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	/* #nosec */
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *Conn) createBody(rid uint64, to, lang string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<body xmlns='%s' xmlns:xmpp='%s' content='text/xml; charset=utf-8' rid='%d' to='%s' ver='1.11' wait='%d' hold='%d' xmpp:version='1.0'`,
+		NS, NSXBOSH, rid, escape(to), int(c.wait/time.Second), c.hold)
+	if lang != "" {
+		fmt.Fprintf(&b, ` xml:lang='%s'`, escape(lang))
+	}
+	b.WriteString(`/>`)
+	return b.Bytes()
+}
+
+func restartBody(rid uint64, sid, to, lang string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<body xmlns='%s' xmlns:xmpp='%s' rid='%d' sid='%s' to='%s' xmpp:restart='true'`,
+		NS, NSXBOSH, rid, escape(sid), escape(to))
+	if lang != "" {
+		fmt.Fprintf(&b, ` xml:lang='%s'`, escape(lang))
+	}
+	b.WriteString(`/>`)
+	return b.Bytes()
+}
+
+func terminateBody(rid uint64, sid string) []byte {
+	return []byte(fmt.Sprintf(`<body xmlns='%s' rid='%d' sid='%s' type='terminate'/>`, NS, rid, escape(sid)))
+}
+
+func payloadBody(rid uint64, sid string, payload [][]byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<body xmlns='%s' rid='%d' sid='%s'>`, NS, rid, escape(sid))
+	for _, p := range payload {
+		b.Write(p)
+	}
+	b.WriteString(`</body>`)
+	return b.Bytes()
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	/* #nosec */
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// initialRID returns a random request ID that leaves plenty of room to be
+// incremented without exceeding the limit of 2^53 set by XEP-0124.
+func initialRID() uint64 {
+	var b [4]byte
+	/* #nosec */
+	rand.Read(b[:])
+	return uint64(binary.BigEndian.Uint32(b[:])) + 1
+}
+
+type respBody struct {
+	typ       string
+	condition string
+	sid       string
+	from      string
+	hold      int
+	hasHold   bool
+	requests  int
+	inner     []byte
+}
+
+// parseBody parses a response from the connection manager, returning its
+// attributes and the raw XML of its children.
+func parseBody(b []byte) (respBody, error) {
+	var (
+		out   respBody
+		depth int
+		start int64
+	)
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		off := d.InputOffset()
+		tok, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return out, fmt.Errorf("bosh: error parsing response: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				if t.Name.Space != "" || t.Name.Local != "body" {
+					return out, fmt.Errorf("bosh: expected body element in response, got %s: %w", t.Name.Local, stream.BadFormat)
+				}
+				for _, a := range t.Attr {
+					if a.Name.Space != "" {
+						continue
+					}
+					switch a.Name.Local {
+					case "type":
+						out.typ = a.Value
+					case "condition":
+						out.condition = a.Value
+					case "sid":
+						out.sid = a.Value
+					case "from":
+						out.from = a.Value
+					case "hold":
+						out.hasHold = true
+						out.hold, _ = strconv.Atoi(a.Value)
+					case "requests":
+						out.requests, _ = strconv.Atoi(a.Value)
+					}
+				}
+				start = d.InputOffset()
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				out.inner = b[start:off]
+				return out, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Bus delivers events published by extension packages to any number of
+// subscribers based on the Go type of the event.
+// This lets applications receive events from many packages in a uniform way
+// instead of wiring up a different callback for each one.
+//
+// The zero value is an empty bus ready for use, and a Bus is safe for
+// concurrent use.
+// Publishing to a nil Bus is a no-op, so packages that take an optional bus do
+// not need to check whether one was set.
+type Bus struct {
+	mu   sync.Mutex
+	subs []*subscription
+}
+
+type subscription struct {
+	typ reflect.Type
+	f   reflect.Value
+}
+
+// Subscribe registers f to be called for every event that is published to the
+// bus and can be assigned to its argument.
+// f must be a function that takes a single argument and returns nothing, for
+// example:
+//
+//     unsubscribe := session.Bus().Subscribe(func(p muc.Presence) {
+//         log.Printf("occupant presence from %s", p.From)
+//     })
+//
+// If the argument is an interface type, f is called for every event that
+// implements the interface (so func(interface{}) receives all events).
+// Subscribe panics if f is not a function with the correct signature.
+//
+// Subscribers are called synchronously from the goroutine that published the
+// event and in the order they were registered, so they should not block.
+// The returned function removes the subscription.
+func (b *Bus) Subscribe(f interface{}) (unsubscribe func()) {
+	v := reflect.ValueOf(f)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 0 || t.IsVariadic() {
+		panic("xmpp: bus subscriber must be a function with one argument and no results, got " + t.String())
+	}
+
+	sub := &subscription{typ: t.In(0), f: v}
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subs {
+			if s == sub {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish calls every subscriber that accepts the type of event.
+// Nil events are ignored.
+func (b *Bus) Publish(event interface{}) {
+	if b == nil || event == nil {
+		return
+	}
+
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+
+	v := reflect.ValueOf(event)
+	t := v.Type()
+	for _, sub := range subs {
+		if t.AssignableTo(sub.typ) {
+			sub.f.Call([]reflect.Value{v})
+		}
+	}
+}
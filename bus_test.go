@@ -0,0 +1,74 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"fmt"
+	"testing"
+
+	"mellium.im/xmpp"
+)
+
+type testEvent struct {
+	n int
+}
+
+func (e testEvent) String() string {
+	return fmt.Sprintf("event %d", e.n)
+}
+
+func TestBus(t *testing.T) {
+	var bus xmpp.Bus
+	var typed, stringers, all []interface{}
+	unsubTyped := bus.Subscribe(func(e testEvent) {
+		typed = append(typed, e)
+	})
+	bus.Subscribe(func(s fmt.Stringer) {
+		stringers = append(stringers, s)
+	})
+	bus.Subscribe(func(e interface{}) {
+		all = append(all, e)
+	})
+
+	bus.Publish(testEvent{n: 1})
+	bus.Publish("not a test event")
+	bus.Publish(nil)
+	unsubTyped()
+	unsubTyped()
+	bus.Publish(testEvent{n: 2})
+
+	if len(typed) != 1 || typed[0] != (testEvent{n: 1}) {
+		t.Errorf("wrong typed events: %v", typed)
+	}
+	if len(stringers) != 2 {
+		t.Errorf("wrong number of stringer events: want=2, got=%d", len(stringers))
+	}
+	if len(all) != 3 {
+		t.Errorf("wrong number of events: want=3, got=%d", len(all))
+	}
+}
+
+func TestBusNil(t *testing.T) {
+	var bus *xmpp.Bus
+	bus.Publish(testEvent{})
+}
+
+func TestBusBadSubscriber(t *testing.T) {
+	for i, f := range []interface{}{
+		func() {},
+		func(int) error { return nil },
+		func(...int) {},
+		"not a func",
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("expected subscribing %T to panic", f)
+				}
+			}()
+			new(xmpp.Bus).Subscribe(f)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package caps
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/internal/lru"
+	"mellium.im/xmpp/storage"
+)
+
+// Cache stores disco info by the capabilities hash that it was verified
+// against.
+// Keys are the name of the hash function and the base64 encoded hash separated
+// by a space, for example "sha-1 QgayPKawpkPSDYmwT/WM94uAlu0=".
+//
+// Caches are best effort, errors storing info are not reported.
+type Cache interface {
+	Get(key string) (info disco.Info, ok bool)
+	Put(key string, info disco.Info)
+}
+
+// MemCache is a Cache that holds a fixed number of responses in memory and
+// evicts the least recently used when it is full.
+//
+// A MemCache is safe for concurrent use.
+type MemCache struct {
+	c *lru.Cache
+}
+
+// NewMemCache creates a cache that holds at most size responses.
+// If size is less than 1 nothing is cached.
+func NewMemCache(size int) *MemCache {
+	return &MemCache{c: lru.New(size)}
+}
+
+// Get implements Cache.
+func (c *MemCache) Get(key string) (disco.Info, bool) {
+	v, ok := c.c.Get(key)
+	if !ok {
+		return disco.Info{}, false
+	}
+	return v.(disco.Info), true
+}
+
+// Put implements Cache.
+func (c *MemCache) Put(key string, info disco.Info) {
+	c.c.Add(key, info)
+}
+
+// kvPrefix is prepended to the keys used by KVCache so that the same KV can be
+// shared with other packages.
+const kvPrefix = "caps/"
+
+// KVCache returns a Cache that stores info in kv under keys starting with
+// "caps/".
+// Because verified info never changes for a given hash, a persistent KV lets
+// clients skip disco queries for most contacts even after a restart.
+func KVCache(kv storage.KV) Cache {
+	return kvCache{kv: kv}
+}
+
+type kvCache struct {
+	kv storage.KV
+}
+
+func (c kvCache) Get(key string) (disco.Info, bool) {
+	data, err := c.kv.Get(kvPrefix + key)
+	if err != nil {
+		return disco.Info{}, false
+	}
+	var info disco.Info
+	err = xml.Unmarshal(data, &info)
+	if err != nil {
+		return disco.Info{}, false
+	}
+	return info, true
+}
+
+func (c kvCache) Put(key string, info disco.Info) {
+	data, err := xml.Marshal(info)
+	if err != nil {
+		return
+	}
+	/* #nosec */
+	c.kv.Put(kvPrefix+key, data)
+}
@@ -0,0 +1,454 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package caps implements entity capabilities.
+//
+// Entity capabilities let an entity advertise the service discovery
+// identities, features, and extended information that it supports in its
+// presence in the form of a hash.
+// Because many entities on the network run the same software, and thus
+// advertise the same hash, the disco info for a hash only has to be queried
+// once and can then be cached and reused for every entity that advertises it.
+//
+// Both the legacy format from XEP-0115 (Caps) and the newer format from
+// XEP-0390 (Hashed) are supported.
+// Only the SHA-1, SHA-256, and SHA-512 hash functions are linked into programs
+// by this package; to support other hash functions import a package that
+// registers them with the crypto package.
+package caps // import "mellium.im/xmpp/caps"
+
+import (
+	"bytes"
+	"crypto"
+	/* #nosec */
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/hashes"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	// NS is the namespace used by the legacy entity capabilities format.
+	NS = "http://jabber.org/protocol/caps"
+
+	// NSHashed is the namespace used by the hashed entity capabilities format.
+	NSHashed = "urn:xmpp:caps"
+)
+
+// Errors returned by this package.
+var (
+	ErrMalformed   = errors.New("caps: disco info is ill-formed")
+	ErrMismatch    = errors.New("caps: hash does not match the disco info")
+	ErrUnknownHash = errors.New("caps: unsupported hash function")
+)
+
+var hashNames = []struct {
+	name string
+	h    crypto.Hash
+}{
+	{name: "sha-1", h: crypto.SHA1},
+	{name: hashes.SHA256, h: crypto.SHA256},
+	{name: hashes.SHA512, h: crypto.SHA512},
+	{name: hashes.SHA3256, h: crypto.SHA3_256},
+	{name: hashes.SHA3512, h: crypto.SHA3_512},
+	{name: hashes.BLAKE2b256, h: crypto.BLAKE2b_256},
+	{name: hashes.BLAKE2b512, h: crypto.BLAKE2b_512},
+}
+
+// hashByName returns the hash function with the given IANA name if it is
+// available.
+func hashByName(name string) (crypto.Hash, bool) {
+	for _, n := range hashNames {
+		if n.name == name {
+			return n.h, n.h.Available()
+		}
+	}
+	return 0, false
+}
+
+// nameOf returns the IANA name of the hash function if it is available.
+func nameOf(h crypto.Hash) (string, bool) {
+	if !h.Available() {
+		return "", false
+	}
+	for _, n := range hashNames {
+		if n.h == h {
+			return n.name, true
+		}
+	}
+	return "", false
+}
+
+// Caps is the legacy entity capabilities element.
+type Caps struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/caps c"`
+
+	// Hash is the name of the hash function used to create Ver.
+	Hash string `xml:"hash,attr"`
+
+	// Node identifies the software that created the hash, normally a URL.
+	Node string `xml:"node,attr"`
+
+	// Ver is the base64 encoded verification string.
+	Ver string `xml:"ver,attr"`
+}
+
+// New computes the legacy entity capabilities for info.
+func New(h crypto.Hash, node string, info disco.Info) (Caps, error) {
+	name, ok := nameOf(h)
+	if !ok {
+		return Caps{}, ErrUnknownHash
+	}
+	ver, err := Ver(h, info)
+	if err != nil {
+		return Caps{}, err
+	}
+	return Caps{
+		Hash: name,
+		Node: node,
+		Ver:  ver,
+	}, nil
+}
+
+// InfoNode is the node that should be queried to get the disco info that was
+// used to create the capabilities.
+func (c Caps) InfoNode() string {
+	return c.Node + "#" + c.Ver
+}
+
+// Verify checks that the capabilities were created from info.
+// If the hash function is unknown or not linked into the binary,
+// ErrUnknownHash is returned.
+func (c Caps) Verify(info disco.Info) error {
+	h, ok := hashByName(c.Hash)
+	if !ok {
+		return ErrUnknownHash
+	}
+	ver, err := Ver(h, info)
+	if err != nil {
+		return err
+	}
+	if ver != c.Ver {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (c Caps) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "c"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "hash"}, Value: c.Hash},
+			{Name: xml.Name{Local: "node"}, Value: c.Node},
+			{Name: xml.Name{Local: "ver"}, Value: c.Ver},
+		},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (c Caps) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Caps) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	return err
+}
+
+// Hashed is the hashed entity capabilities element.
+// It may contain several hashes of the same disco info created with different
+// hash functions.
+type Hashed struct {
+	XMLName xml.Name      `xml:"urn:xmpp:caps c"`
+	Hashes  []hashes.Hash `xml:"urn:xmpp:hashes:2 hash"`
+}
+
+// NewHashed computes the hashed entity capabilities for info using each of
+// the provided hash functions.
+func NewHashed(info disco.Info, h ...crypto.Hash) (Hashed, error) {
+	var c Hashed
+	for _, hash := range h {
+		name, ok := nameOf(hash)
+		if !ok {
+			return Hashed{}, ErrUnknownHash
+		}
+		sum, err := Sum(hash, info)
+		if err != nil {
+			return Hashed{}, err
+		}
+		c.Hashes = append(c.Hashes, hashes.Hash{Algo: name, Value: sum})
+	}
+	return c, nil
+}
+
+// InfoNode is the node that should be queried to get the disco info that was
+// used to create the given hash.
+func InfoNode(h hashes.Hash) string {
+	return NSHashed + "#" + h.Algo + "." + base64.StdEncoding.EncodeToString(h.Value)
+}
+
+// Verify checks that the capabilities were created from info.
+// Hashes created with unknown hash functions are ignored, but if none of the
+// hash functions are known ErrUnknownHash is returned.
+func (c Hashed) Verify(info disco.Info) error {
+	var verified bool
+	for _, hash := range c.Hashes {
+		h, ok := hashByName(hash.Algo)
+		if !ok {
+			continue
+		}
+		sum, err := Sum(h, info)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(sum, hash.Value) {
+			return ErrMismatch
+		}
+		verified = true
+	}
+	if !verified {
+		return ErrUnknownHash
+	}
+	return nil
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (c Hashed) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, h := range c.Hashes {
+		inner = append(inner, h.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NSHashed, Local: "c"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (c Hashed) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Hashed) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	return err
+}
+
+// Insert adds capabilities to every available presence read through the
+// transformer.
+// Capabilities should normally be included in all available presence that is
+// broadcast, and should not be included in presence of any other type.
+func Insert(c ...xmlstream.Marshaler) xmlstream.Transformer {
+	return xmlstream.InsertFunc(func(start xml.StartElement, level uint64, w xmlstream.TokenWriter) error {
+		if level != 1 || start.Name.Local != "presence" || (start.Name.Space != "" && !stanza.Is(start.Name)) {
+			return nil
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "type" && attr.Value != string(stanza.AvailablePresence) {
+				return nil
+			}
+		}
+		for _, m := range c {
+			_, err := xmlstream.Copy(w, m.TokenReader())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Ver computes the legacy verification string for info.
+// If info contains duplicate identities, duplicate features, more than one
+// extended information form with the same FORM_TYPE, or a FORM_TYPE field with
+// more than one value, it cannot be hashed safely and an error wrapping
+// ErrMalformed is returned.
+func Ver(h crypto.Hash, info disco.Info) (string, error) {
+	if !h.Available() {
+		return "", ErrUnknownHash
+	}
+	forms, err := extensions(info)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	idents := make([]string, 0, len(info.Identity))
+	for _, ident := range info.Identity {
+		idents = append(idents, ident.Category+"/"+ident.Type+"/"+ident.Lang+"/"+ident.Name)
+	}
+	sort.Strings(idents)
+	for i, ident := range idents {
+		if i > 0 && ident == idents[i-1] {
+			return "", fmt.Errorf("%w: duplicate identity %q", ErrMalformed, ident)
+		}
+		b.WriteString(ident)
+		b.WriteByte('<')
+	}
+	features := make([]string, 0, len(info.Features))
+	for _, f := range info.Features {
+		features = append(features, f.Var)
+	}
+	sort.Strings(features)
+	for i, f := range features {
+		if i > 0 && f == features[i-1] {
+			return "", fmt.Errorf("%w: duplicate feature %q", ErrMalformed, f)
+		}
+		b.WriteString(f)
+		b.WriteByte('<')
+	}
+	sort.Slice(forms, func(i, j int) bool {
+		return forms[i].formType < forms[j].formType
+	})
+	for _, f := range forms {
+		if f.formType == "" {
+			continue
+		}
+		b.WriteString(f.formType)
+		b.WriteByte('<')
+		for _, field := range f.fields {
+			if field.Var == "FORM_TYPE" {
+				continue
+			}
+			b.WriteString(field.Var)
+			b.WriteByte('<')
+			for _, v := range field.Values {
+				b.WriteString(v)
+				b.WriteByte('<')
+			}
+		}
+	}
+
+	hash := h.New()
+	/* #nosec */
+	hash.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Sum computes the hash of info used by the hashed entity capabilities format.
+func Sum(h crypto.Hash, info disco.Info) ([]byte, error) {
+	const (
+		unitSep   = "\x1f"
+		recordSep = "\x1e"
+		groupSep  = "\x1d"
+		fileSep   = "\x1c"
+	)
+	if !h.Available() {
+		return nil, ErrUnknownHash
+	}
+	forms, err := extensions(info)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	features := make([]string, 0, len(info.Features))
+	for _, f := range info.Features {
+		features = append(features, f.Var+unitSep)
+	}
+	sort.Strings(features)
+	b.WriteString(strings.Join(features, ""))
+	b.WriteString(fileSep)
+
+	idents := make([]string, 0, len(info.Identity))
+	for _, ident := range info.Identity {
+		idents = append(idents, ident.Category+unitSep+ident.Type+unitSep+ident.Lang+unitSep+ident.Name+unitSep+recordSep)
+	}
+	sort.Strings(idents)
+	b.WriteString(strings.Join(idents, ""))
+	b.WriteString(fileSep)
+
+	encodedForms := make([]string, 0, len(forms))
+	for _, f := range forms {
+		fields := make([]string, 0, len(f.fields))
+		for _, field := range f.fields {
+			values := make([]string, 0, len(field.Values))
+			for _, v := range field.Values {
+				values = append(values, v+unitSep)
+			}
+			sort.Strings(values)
+			fields = append(fields, field.Var+unitSep+strings.Join(values, "")+recordSep)
+		}
+		sort.Strings(fields)
+		encodedForms = append(encodedForms, strings.Join(fields, "")+groupSep)
+	}
+	sort.Strings(encodedForms)
+	b.WriteString(strings.Join(encodedForms, ""))
+	b.WriteString(fileSep)
+
+	hash := h.New()
+	/* #nosec */
+	hash.Write([]byte(b.String()))
+	return hash.Sum(nil), nil
+}
+
+type extField struct {
+	Var    string   `xml:"var,attr"`
+	Values []string `xml:"value"`
+}
+
+type extForm struct {
+	formType string
+	fields   []extField
+}
+
+// extensions returns the extended information forms in info with their fields
+// and values sorted.
+// Forms with a FORM_TYPE field that has more than one value and multiple forms
+// with the same FORM_TYPE are rejected.
+func extensions(info disco.Info) ([]extForm, error) {
+	forms := make([]extForm, 0, len(info.Form))
+	formTypes := make(map[string]struct{}, len(info.Form))
+	for _, data := range info.Form {
+		if data == nil {
+			continue
+		}
+		var raw struct {
+			Fields []extField `xml:"field"`
+		}
+		err := xml.NewTokenDecoder(data.TokenReader()).Decode(&raw)
+		if err != nil {
+			return nil, err
+		}
+		formType := data.Values("FORM_TYPE")
+		for _, v := range formType {
+			if v != formType[0] {
+				return nil, fmt.Errorf("%w: FORM_TYPE has more than one value", ErrMalformed)
+			}
+		}
+		f := extForm{formType: data.FormType()}
+		for _, field := range raw.Fields {
+			if field.Var == "" {
+				continue
+			}
+			sort.Strings(field.Values)
+			f.fields = append(f.fields, field)
+		}
+		sort.Slice(f.fields, func(i, j int) bool {
+			return f.fields[i].Var < f.fields[j].Var
+		})
+		if f.formType != "" {
+			if _, ok := formTypes[f.formType]; ok {
+				return nil, fmt.Errorf("%w: more than one form with FORM_TYPE %q", ErrMalformed, f.formType)
+			}
+			formTypes[f.formType] = struct{}{}
+		}
+		forms = append(forms, f)
+	}
+	return forms, nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package caps_test
+
+import (
+	"context"
+	"crypto"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/caps"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/storage"
+)
+
+// simpleInfo and complexInfo are the examples from XEP-0115.
+var (
+	simpleInfo = disco.Info{
+		Identity: []disco.Identity{{Category: "client", Type: "pc", Name: "Exodus 0.9.1"}},
+		Features: []disco.Feature{
+			{Var: "http://jabber.org/protocol/disco#info"},
+			{Var: "http://jabber.org/protocol/disco#items"},
+			{Var: "http://jabber.org/protocol/muc"},
+			{Var: "http://jabber.org/protocol/caps"},
+		},
+	}
+	complexInfo = disco.Info{
+		Identity: []disco.Identity{
+			{Category: "client", Type: "pc", Name: "Psi 0.11", Lang: "en"},
+			{Category: "client", Type: "pc", Name: "Ψ 0.11", Lang: "el"},
+		},
+		Features: []disco.Feature{
+			{Var: "http://jabber.org/protocol/caps"},
+			{Var: "http://jabber.org/protocol/disco#info"},
+			{Var: "http://jabber.org/protocol/disco#items"},
+			{Var: "http://jabber.org/protocol/muc"},
+		},
+		Form: []*form.Data{form.New(
+			form.FormType("urn:xmpp:dataforms:softwareinfo"),
+			form.ListMulti("ip_version", form.Value("ipv6"), form.Value("ipv4")),
+			form.Text("os", form.Value("Mac")),
+			form.Text("os_version", form.Value("10.5.1")),
+			form.Text("software", form.Value("Psi")),
+			form.Text("software_version", form.Value("0.11")),
+		)},
+	}
+)
+
+var verTestCases = []struct {
+	info disco.Info
+	ver  string
+}{
+	0: {info: simpleInfo, ver: "QgayPKawpkPSDYmwT/WM94uAlu0="},
+	1: {info: complexInfo, ver: "q07IKJEyjvHSyhy//CH0CxmKi8w="},
+}
+
+func TestVer(t *testing.T) {
+	for i, tc := range verTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			c, err := caps.New(crypto.SHA1, "http://example.net", tc.info)
+			if err != nil {
+				t.Fatalf("error creating caps: %v", err)
+			}
+			if c.Ver != tc.ver || c.Hash != "sha-1" {
+				t.Errorf("wrong caps: want=sha-1 %s, got=%s %s", tc.ver, c.Hash, c.Ver)
+			}
+			err = c.Verify(tc.info)
+			if err != nil {
+				t.Errorf("error verifying caps: %v", err)
+			}
+		})
+	}
+}
+
+func mustForm(s string) *form.Data {
+	f := &form.Data{}
+	err := xml.Unmarshal([]byte(s), f)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+var malformedTestCases = [...]disco.Info{
+	0: {
+		Identity: []disco.Identity{
+			{Category: "client", Type: "pc", Name: "Exodus 0.9.1"},
+			{Category: "client", Type: "pc", Name: "Exodus 0.9.1"},
+		},
+	},
+	1: {
+		Features: []disco.Feature{
+			{Var: "http://jabber.org/protocol/caps"},
+			{Var: "http://jabber.org/protocol/disco#info"},
+			{Var: "http://jabber.org/protocol/caps"},
+		},
+	},
+	2: {
+		Form: []*form.Data{mustForm(`<x xmlns="jabber:x:data" type="result"><field var="FORM_TYPE" type="hidden"><value>urn:xmpp:dataforms:softwareinfo</value><value>urn:example:other</value></field></x>`)},
+	},
+	3: {
+		Form: []*form.Data{
+			form.New(form.FormType("urn:xmpp:dataforms:softwareinfo"), form.Text("os", form.Value("Mac"))),
+			form.New(form.FormType("urn:xmpp:dataforms:softwareinfo"), form.Text("os", form.Value("Linux"))),
+		},
+	},
+}
+
+func TestMalformed(t *testing.T) {
+	for i, info := range malformedTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := caps.New(crypto.SHA1, "http://example.net", info)
+			if !errors.Is(err, caps.ErrMalformed) {
+				t.Errorf("wrong error creating caps: want=%v, got=%v", caps.ErrMalformed, err)
+			}
+			err = caps.Caps{Hash: "sha-1", Ver: "QgayPKawpkPSDYmwT/WM94uAlu0="}.Verify(info)
+			if !errors.Is(err, caps.ErrMalformed) {
+				t.Errorf("wrong error verifying caps: want=%v, got=%v", caps.ErrMalformed, err)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for i, info := range []disco.Info{simpleInfo, complexInfo} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			c, err := caps.NewHashed(info, crypto.SHA256, crypto.SHA512)
+			if err != nil {
+				t.Fatalf("error creating caps: %v", err)
+			}
+			if len(c.Hashes) != 2 || c.Hashes[0].Algo != "sha-256" || c.Hashes[1].Algo != "sha-512" {
+				t.Fatalf("wrong hashes: %+v", c.Hashes)
+			}
+			err = c.Verify(info)
+			if err != nil {
+				t.Errorf("error verifying caps: %v", err)
+			}
+
+			other := simpleInfo
+			if i == 0 {
+				other = complexInfo
+			}
+			err = c.Verify(other)
+			if err != caps.ErrMismatch {
+				t.Errorf("wrong error verifying other info: want=%v, got=%v", caps.ErrMismatch, err)
+			}
+
+			c.Hashes[0].Algo = "unknown"
+			c.Hashes = c.Hashes[:1]
+			err = c.Verify(info)
+			if err != caps.ErrUnknownHash {
+				t.Errorf("wrong error verifying unknown hash: want=%v, got=%v", caps.ErrUnknownHash, err)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	c, err := caps.New(crypto.SHA1, "http://example.net", simpleInfo)
+	if err != nil {
+		t.Fatalf("error creating caps: %v", err)
+	}
+	hashed, err := caps.NewHashed(simpleInfo, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("error creating hashed caps: %v", err)
+	}
+	var b strings.Builder
+	e := xml.NewEncoder(&b)
+	_, err = xmlstream.Copy(e, caps.Insert(c, hashed)(xmlstream.MultiReader(
+		stanza.Presence{}.Wrap(nil),
+		stanza.Presence{Type: stanza.UnavailablePresence}.Wrap(nil),
+	)))
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<presence><c xmlns="http://jabber.org/protocol/caps" hash="sha-1" node="http://example.net" ver="QgayPKawpkPSDYmwT/WM94uAlu0="></c><c xmlns="urn:xmpp:caps"><hash xmlns="urn:xmpp:hashes:2" algo="sha-256">`
+	if out := b.String(); !strings.HasPrefix(out, want) || !strings.HasSuffix(out, `</hash></c></presence><presence type="unavailable"></presence>`) {
+		t.Errorf("wrong output:\nwant prefix=%s\ngot=%s", want, out)
+	}
+
+	var decoded struct {
+		Caps   caps.Caps
+		Hashed caps.Hashed
+	}
+	err = xml.Unmarshal([]byte(b.String()[:strings.Index(b.String(), `<presence type`)]), &decoded)
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	if decoded.Caps.Ver != c.Ver || decoded.Caps.Node != c.Node || decoded.Caps.Hash != c.Hash {
+		t.Errorf("wrong caps decoded: want=%+v, got=%+v", c, decoded.Caps)
+	}
+	if err = decoded.Hashed.Verify(simpleInfo); err != nil {
+		t.Errorf("error verifying decoded hashed caps: %v", err)
+	}
+}
+
+func TestKVCache(t *testing.T) {
+	cache := caps.KVCache(&storage.Mem{})
+	cache.Put("sha-1 q07IKJEyjvHSyhy//CH0CxmKi8w=", complexInfo)
+	info, ok := cache.Get("sha-1 q07IKJEyjvHSyhy//CH0CxmKi8w=")
+	if !ok {
+		t.Fatalf("info not found in cache")
+	}
+	c := caps.Caps{Hash: "sha-1", Ver: "q07IKJEyjvHSyhy//CH0CxmKi8w="}
+	if err := c.Verify(info); err != nil {
+		t.Errorf("cached info does not match: %v", err)
+	}
+	if _, ok := cache.Get("sha-1 QgayPKawpkPSDYmwT/WM94uAlu0="); ok {
+		t.Errorf("expected missing key not to be found")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var mu sync.Mutex
+	var queried []string
+	changed := make(chan jid.JID, 2)
+	h := &caps.Handler{
+		Cache: caps.NewMemCache(10),
+		Changed: func(from jid.JID) {
+			changed <- from
+		},
+	}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(caps.Handle(h))),
+		xmpptest.ServerHandler(mux.New(mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var node string
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "node" {
+					node = attr.Value
+				}
+			}
+			mu.Lock()
+			queried = append(queried, node)
+			mu.Unlock()
+			info := simpleInfo
+			info.Node = node
+			_, err := xmlstream.Copy(t, iq.Result(info.TokenReader()))
+			return err
+		}))),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, from := range []string{"juliet@capulet.lit/balcony", "nurse@capulet.lit/kitchen"} {
+		err := cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(`<presence xmlns="jabber:client" from="`+from+`"><c xmlns="http://jabber.org/protocol/caps" hash="sha-1" node="http://code.google.com/p/exodus" ver="QgayPKawpkPSDYmwT/WM94uAlu0="/></presence>`)))
+		if err != nil {
+			t.Fatalf("error sending presence: %v", err)
+		}
+		select {
+		case j := <-changed:
+			if j.String() != from {
+				t.Errorf("wrong JID changed: want=%s, got=%s", from, j)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for caps")
+		}
+
+		info, err := h.Info(ctx, cs.Client, jid.MustParse(from))
+		if err != nil {
+			t.Fatalf("error fetching info: %v", err)
+		}
+		if len(info.Features) != len(simpleInfo.Features) {
+			t.Errorf("wrong info: want=%+v, got=%+v", simpleInfo, info)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queried) != 1 || queried[0] != "http://code.google.com/p/exodus#QgayPKawpkPSDYmwT/WM94uAlu0=" {
+		t.Errorf("expected a single query for the caps node, got %v", queried)
+	}
+}
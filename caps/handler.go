@@ -0,0 +1,228 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package caps
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Handle returns an option that registers a Handler for entity capabilities
+// in available presence.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{Space: NS, Local: "c"}, h.handleCaps)(m)
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{Space: NSHashed, Local: "c"}, h.handleHashed)(m)
+	}
+}
+
+// Handler keeps track of the capabilities advertised by entities and looks up
+// their disco info.
+//
+// Capabilities are tracked per full JID until Forget is called, which should
+// normally happen when unavailable presence is received from the entity.
+type Handler struct {
+	// Cache, if set, is used to store disco info that has been verified against
+	// a capabilities hash.
+	Cache Cache
+
+	// Changed, if set, is called when an entity advertises capabilities.
+	Changed func(from jid.JID)
+
+	mu    sync.Mutex
+	ents  map[string]advertised
+	calls map[string]*call
+}
+
+type advertised struct {
+	caps   Caps
+	hashed Hashed
+}
+
+type candidate struct {
+	key    string
+	node   string
+	verify func(disco.Info) error
+}
+
+type call struct {
+	done chan struct{}
+	info disco.Info
+	err  error
+}
+
+func (h *Handler) handleCaps(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+	var c Caps
+	found, err := decodeChild(t, xml.Name{Space: NS, Local: "c"}, &c)
+	if err != nil || !found {
+		return err
+	}
+	h.update(p.From, func(a *advertised) {
+		a.caps = c
+	})
+	return nil
+}
+
+func (h *Handler) handleHashed(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+	var c Hashed
+	found, err := decodeChild(t, xml.Name{Space: NSHashed, Local: "c"}, &c)
+	if err != nil || !found {
+		return err
+	}
+	h.update(p.From, func(a *advertised) {
+		a.hashed = c
+	})
+	return nil
+}
+
+// decodeChild decodes the first element read from r with the given name into
+// v.
+func decodeChild(r xml.TokenReader, name xml.Name, v interface{}) (bool, error) {
+	d := xml.NewTokenDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name != name {
+			continue
+		}
+		return true, d.DecodeElement(v, &start)
+	}
+}
+
+func (h *Handler) update(from jid.JID, f func(*advertised)) {
+	h.mu.Lock()
+	if h.ents == nil {
+		h.ents = make(map[string]advertised)
+	}
+	key := from.String()
+	a := h.ents[key]
+	f(&a)
+	h.ents[key] = a
+	h.mu.Unlock()
+
+	if h.Changed != nil {
+		h.Changed(from)
+	}
+}
+
+// Forget stops tracking the capabilities advertised by j.
+func (h *Handler) Forget(j jid.JID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.ents, j.String())
+}
+
+// Caps returns the legacy and hashed capabilities most recently advertised by
+// j.
+// If j has not advertised capabilities, ok is false.
+func (h *Handler) Caps(j jid.JID) (c Caps, hashed Hashed, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a, ok := h.ents[j.String()]
+	return a.caps, a.hashed, ok
+}
+
+// Info returns the disco info for j.
+//
+// If j has advertised capabilities with a known hash function, the cache is
+// checked first and responses are verified and cached before they are
+// returned.
+// Concurrent lookups of the same capabilities share a single query so that
+// entities running the same software are only queried once.
+// If the response does not match the advertised capabilities ErrMismatch is
+// returned.
+// If j has not advertised any known capabilities, it is queried directly and
+// the response is not cached.
+//
+// Info sends an IQ and waits for the response, so it must not be called from
+// within a handler.
+func (h *Handler) Info(ctx context.Context, s *xmpp.Session, j jid.JID) (disco.Info, error) {
+	c, hashed, _ := h.Caps(j)
+
+	var cands []candidate
+	for _, hash := range hashed.Hashes {
+		if _, ok := hashByName(hash.Algo); !ok {
+			continue
+		}
+		cands = append(cands, candidate{
+			key:    hash.Algo + " " + base64.StdEncoding.EncodeToString(hash.Value),
+			node:   InfoNode(hash),
+			verify: hashed.Verify,
+		})
+	}
+	if _, ok := hashByName(c.Hash); ok && c.Ver != "" {
+		cands = append(cands, candidate{
+			key:    c.Hash + " " + c.Ver,
+			node:   c.InfoNode(),
+			verify: c.Verify,
+		})
+	}
+	if len(cands) == 0 {
+		return disco.GetInfo(ctx, "", j, s)
+	}
+
+	if h.Cache != nil {
+		for _, cand := range cands {
+			if info, ok := h.Cache.Get(cand.key); ok {
+				return info, nil
+			}
+		}
+	}
+
+	h.mu.Lock()
+	if cl, ok := h.calls[cands[0].key]; ok {
+		h.mu.Unlock()
+		select {
+		case <-cl.done:
+			return cl.info, cl.err
+		case <-ctx.Done():
+			return disco.Info{}, ctx.Err()
+		}
+	}
+	if h.calls == nil {
+		h.calls = make(map[string]*call)
+	}
+	cl := &call{done: make(chan struct{})}
+	h.calls[cands[0].key] = cl
+	h.mu.Unlock()
+
+	cl.info, cl.err = disco.GetInfo(ctx, cands[0].node, j, s)
+	if cl.err == nil {
+		cl.err = cands[0].verify(cl.info)
+	}
+	if cl.err != nil {
+		cl.info = disco.Info{}
+	} else if h.Cache != nil {
+		// Only cache the response under hashes that it matches, an entity could
+		// advertise a legacy hash that does not match its hashed capabilities.
+		for _, cand := range cands {
+			if cand.verify(cl.info) == nil {
+				h.Cache.Put(cand.key, cl.info)
+			}
+		}
+	}
+
+	h.mu.Lock()
+	delete(h.calls, cands[0].key)
+	h.mu.Unlock()
+	close(cl.done)
+	return cl.info, cl.err
+}
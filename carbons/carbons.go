@@ -0,0 +1,221 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package carbons implements carbon copying messages to all interested
+// clients.
+//
+// When carbons are enabled the server sends a copy of every message received by
+// another of the user's resources, and every message sent by another of the
+// user's resources, to the session.
+// To receive them, enable carbons with Enable and register a Handler on the mux
+// that handles messages for the session.
+package carbons // import "mellium.im/xmpp/carbons"
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:carbons:2"
+
+// Direction indicates whether a carbon copy is of a message that was received
+// or sent by another resource.
+type Direction uint8
+
+// A list of directions.
+const (
+	Received Direction = iota
+	Sent
+)
+
+// String satisfies the fmt.Stringer interface.
+func (d Direction) String() string {
+	if d == Sent {
+		return "sent"
+	}
+	return "received"
+}
+
+// Enable requests that the server begin sending carbon copies to the session.
+func Enable(ctx context.Context, s *xmpp.Session) error {
+	return toggle(ctx, s, "enable")
+}
+
+// Disable requests that the server stop sending carbon copies to the session.
+func Disable(ctx context.Context, s *xmpp.Session) error {
+	return toggle(ctx, s, "disable")
+}
+
+func toggle(ctx context.Context, s *xmpp.Session, local string) error {
+	return s.UnmarshalIQElement(
+		ctx,
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NS, Local: local}}),
+		stanza.IQ{Type: stanza.SetIQ},
+		nil,
+	)
+}
+
+// Private is a type that can be added to messages to request that they not be
+// carbon copied to the user's other resources.
+type Private struct {
+	XMLName xml.Name `xml:"urn:xmpp:carbons:2 private"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (Private) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NS, Local: "private"}})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (p Private) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, p.TokenReader())
+}
+
+// Copy can be embedded in a struct that messages are decoded into to find out
+// whether a message passed on to a mux by Handle was a carbon copy.
+type Copy struct {
+	Received *struct{} `xml:"urn:xmpp:carbons:2 received"`
+	Sent     *struct{} `xml:"urn:xmpp:carbons:2 sent"`
+}
+
+// Direction returns the direction of the carbon copy and true, or false if the
+// message was not a carbon copy.
+func (c Copy) Direction() (Direction, bool) {
+	switch {
+	case c.Sent != nil:
+		return Sent, true
+	case c.Received != nil:
+		return Received, true
+	}
+	return Received, false
+}
+
+// Handle returns an option that registers a Handler for carbon copies.
+// If the handler's F field is nil, unwrapped messages are handled by the mux
+// that the option is registered on as if they had been received directly, except
+// that an empty received or sent element is added as the last child of the
+// message so that handlers can tell the direction of the copy (see Copy).
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		if h.F == nil {
+			h.F = func(dir Direction, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				marker := xml.StartElement{Name: xml.Name{Space: NS, Local: dir.String()}}
+				return m.HandleXMPP(struct {
+					xml.TokenReader
+					xmlstream.Encoder
+				}{
+					TokenReader: xmlstream.MultiReader(
+						xmlstream.Inner(t),
+						xmlstream.Wrap(nil, marker),
+						xmlstream.Token(start.End()),
+					),
+					Encoder: t,
+				}, start)
+			}
+		}
+		for _, local := range []string{"received", "sent"} {
+			name := xml.Name{Space: NS, Local: local}
+			for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage, stanza.HeadlineMessage, stanza.GroupChatMessage} {
+				mux.Message(typ, name, h)(m)
+			}
+		}
+	}
+}
+
+// Handler unwraps carbon copies and passes the copied message to F.
+type Handler struct {
+	// F is called with the direction of each carbon copy, a token stream
+	// starting after the start element of the copied message, and the start
+	// element itself.
+	// To handle carbon copies like other messages but still tell them apart, F
+	// may inspect the direction before passing the message on to a mux.
+	F func(dir Direction, t xmlstream.TokenReadEncoder, start *xml.StartElement) error
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (h Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	// Carbon copies must come from our own bare JID or they could be used to
+	// spoof messages from anyone.
+	if !msg.From.Equal(jid.JID{}) && !msg.From.Equal(msg.To.Bare()) {
+		return nil
+	}
+
+	// Pop the start message token
+	_, err := t.Token()
+	if err != nil {
+		return err
+	}
+
+	iter := xmlstream.NewIter(t)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, r := iter.Current()
+		if start == nil || start.Name.Space != NS {
+			continue
+		}
+		var dir Direction
+		switch start.Name.Local {
+		case "received":
+			dir = Received
+		case "sent":
+			dir = Sent
+		default:
+			continue
+		}
+		return h.unwrap(dir, t, r)
+	}
+	return iter.Err()
+}
+
+func (h Handler) unwrap(dir Direction, t xmlstream.TokenReadEncoder, r xml.TokenReader) error {
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil || start.Name.Space != forward.NS || start.Name.Local != "forwarded" {
+			continue
+		}
+		var f forward.Forwarded
+		msgReader, err := forward.Unwrap(&f, xmlstream.MultiReader(
+			xmlstream.Token(*start),
+			inner,
+			xmlstream.Token(start.End()),
+		))
+		if err != nil {
+			return err
+		}
+		tok, err := msgReader.Token()
+		if err != nil {
+			return err
+		}
+		msgStart, ok := tok.(xml.StartElement)
+		if !ok || !stanza.Is(msgStart.Name) || msgStart.Name.Local != "message" {
+			return nil
+		}
+		return h.F(dir, struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: msgReader,
+			Encoder:     t,
+		}, &msgStart)
+	}
+	return iter.Err()
+}
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "private"}, func() interface{} {
+		return &Private{}
+	})
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package carbons_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/carbons"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+type copied struct {
+	dir    carbons.Direction
+	msg    stanza.Message
+	body   string
+	copied bool
+}
+
+func decodeCopy(dir carbons.Direction, t xml.TokenReader, start *xml.StartElement) (copied, error) {
+	msg := struct {
+		stanza.Message
+		carbons.Copy
+		Body string `xml:"body"`
+	}{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&msg)
+	c := copied{dir: dir, msg: msg.Message, body: msg.Body}
+	if markerDir, ok := msg.Copy.Direction(); ok {
+		c.dir, c.copied = markerDir, true
+	}
+	return c, err
+}
+
+const (
+	sentCopy     = `<message xmlns="jabber:client" from="romeo@montague.example" to="romeo@montague.example/home"><sent xmlns="urn:xmpp:carbons:2"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" type="chat" to="juliet@capulet.example/balcony" from="romeo@montague.example/garden"><body>sent</body></message></forwarded></sent></message>`
+	receivedCopy = `<message xmlns="jabber:client" to="romeo@montague.example/home"><received xmlns="urn:xmpp:carbons:2"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" type="chat" from="juliet@capulet.example/balcony" to="romeo@montague.example/garden"><body>received</body></message></forwarded></received></message>`
+	spoofedCopy  = `<message xmlns="jabber:client" from="mallory@example.net" to="romeo@montague.example/home"><received xmlns="urn:xmpp:carbons:2"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" type="chat" from="juliet@capulet.example/balcony"><body>spoofed</body></message></forwarded></received></message>`
+)
+
+func sendRaw(ctx context.Context, t *testing.T, cs *xmpptest.ClientServer, raw string) {
+	t.Helper()
+	err := cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("error sending %s: %v", raw, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	copies := make(chan copied, 3)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(carbons.Handle(carbons.Handler{
+			F: func(dir carbons.Direction, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				c, err := decodeCopy(dir, t, start)
+				copies <- c
+				return err
+			},
+		}))),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sendRaw(ctx, t, cs, spoofedCopy)
+	sendRaw(ctx, t, cs, sentCopy)
+	sendRaw(ctx, t, cs, receivedCopy)
+
+	for _, want := range []struct {
+		dir  carbons.Direction
+		body string
+	}{{dir: carbons.Sent, body: "sent"}, {dir: carbons.Received, body: "received"}} {
+		select {
+		case c := <-copies:
+			if c.dir != want.dir || c.body != want.body || c.msg.Type != stanza.ChatMessage {
+				t.Errorf("wrong copy: want %s copy with body %q, got %+v", want.dir, want.body, c)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s copy", want.dir)
+		}
+	}
+}
+
+func TestHandlerMux(t *testing.T) {
+	copies := make(chan copied, 2)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(
+			carbons.Handle(carbons.Handler{}),
+			mux.MessageFunc(stanza.ChatMessage, xml.Name{Local: "body"}, func(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+				tok, err := t.Token()
+				if err != nil {
+					return err
+				}
+				start := tok.(xml.StartElement)
+				c, err := decodeCopy(0, t, &start)
+				copies <- c
+				return err
+			}),
+		)),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sendRaw(ctx, t, cs, sentCopy)
+	sendRaw(ctx, t, cs, receivedCopy)
+
+	for _, want := range []struct {
+		dir  carbons.Direction
+		body string
+		from string
+	}{
+		{dir: carbons.Sent, body: "sent", from: "romeo@montague.example/garden"},
+		{dir: carbons.Received, body: "received", from: "juliet@capulet.example/balcony"},
+	} {
+		select {
+		case c := <-copies:
+			if !c.copied || c.dir != want.dir || c.body != want.body || c.msg.From.String() != want.from {
+				t.Errorf("wrong message passed to mux: want %s copy with body %q, got %+v", want.dir, want.body, c)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s copy to be handled by the mux", want.dir)
+		}
+	}
+}
+
+func TestEnableDisable(t *testing.T) {
+	var got []string
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			iq, err := stanza.NewIQ(*start)
+			if err != nil {
+				return err
+			}
+			tok, err := t.Token()
+			if err != nil {
+				return err
+			}
+			if payload, ok := tok.(xml.StartElement); ok && payload.Name.Space == carbons.NS {
+				got = append(got, payload.Name.Local)
+			}
+			_, err = xmlstream.Copy(t, iq.Result(nil))
+			return err
+		}),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := carbons.Enable(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error enabling carbons: %v", err)
+	}
+	err = carbons.Disable(ctx, cs.Client)
+	if err != nil {
+		t.Fatalf("error disabling carbons: %v", err)
+	}
+	if len(got) != 2 || got[0] != "enable" || got[1] != "disable" {
+		t.Errorf("wrong requests: want=[enable disable], got=%v", got)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><private xmlns="urn:xmpp:carbons:2"/></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		&carbons.Private{XMLName: xml.Name{Space: carbons.NS, Local: "private"}},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=State -linecomment
+
+// Package chatstates implements chat state notifications.
+//
+// Chat states let the participants of a one-to-one chat know whether the other
+// participants are paying attention to the conversation, typing a message, or
+// have left the conversation.
+package chatstates // import "mellium.im/xmpp/chatstates"
+
+import (
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "http://jabber.org/protocol/chatstates"
+
+// State is a chat state that can be added to messages.
+// When marshaled it is encoded as an empty element with the name of the state.
+type State uint8
+
+// A list of chat states.
+const (
+	// Active means that the user is actively participating in the chat.
+	Active State = iota // active
+
+	// Composing means that the user is composing a message.
+	Composing // composing
+
+	// Paused means that the user had been composing a message but has stopped.
+	Paused // paused
+
+	// Inactive means that the user has not been actively participating in the
+	// chat for a short period of time.
+	Inactive // inactive
+
+	// Gone means that the user has effectively ended their participation in the
+	// chat.
+	Gone // gone
+)
+
+// TokenReader implements xmlstream.Marshaler.
+func (s State) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(
+		nil,
+		xml.StartElement{Name: xml.Name{Space: NS, Local: s.String()}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (s State) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s State) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// If start is not a chat state the element is skipped and s is not modified.
+func (s *State) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if state, ok := parse(start.Name); ok {
+		*s = state
+	}
+	return d.Skip()
+}
+
+func parse(name xml.Name) (State, bool) {
+	if name.Space != NS {
+		return 0, false
+	}
+	for s := Active; s <= Gone; s++ {
+		if s.String() == name.Local {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func isMessage(name xml.Name) bool {
+	return name.Local == "message" && (name.Space == ns.Client || name.Space == ns.Server)
+}
+
+func isBody(name xml.Name) bool {
+	return name.Local == "body" && (name.Space == ns.Client || name.Space == ns.Server)
+}
+
+// AddActive is an xmlstream.Transformer that inserts an active chat state into
+// any chat messages read through r that have a body and do not already contain
+// a chat state.
+// Messages of other types and messages without a body are not modified.
+func AddActive(r xml.TokenReader) xml.TokenReader {
+	var (
+		depth int
+		chat  bool
+		body  bool
+		state bool
+		inner xml.TokenReader
+	)
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+	start:
+		if inner != nil {
+			tok, err := inner.Token()
+			if err == io.EOF {
+				inner = nil
+				err = nil
+			}
+			return tok, err
+		}
+
+		tok, err := r.Token()
+		switch err {
+		case io.EOF:
+			if tok == nil {
+				return nil, err
+			}
+			err = nil
+		case nil:
+		default:
+			return tok, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case depth == 0 && isMessage(t.Name):
+				_, typ := attr.Get(t.Attr, "type")
+				chat = stanza.MessageType(typ) == stanza.ChatMessage
+				body = false
+				state = false
+			case depth == 1 && chat:
+				if _, ok := parse(t.Name); ok {
+					state = true
+				}
+				if isBody(t.Name) {
+					body = true
+				}
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 && chat && body && !state && isMessage(t.Name) {
+				chat = false
+				inner = xmlstream.MultiReader(Active.TokenReader(), xmlstream.Token(t))
+				goto start
+			}
+		}
+		return tok, err
+	})
+}
+
+func init() {
+	for s := Active; s <= Gone; s++ {
+		stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: s.String()}, func() interface{} {
+			return new(State)
+		})
+	}
+}
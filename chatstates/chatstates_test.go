@@ -0,0 +1,156 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package chatstates_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/chatstates"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler         = chatstates.Active
+	_ xmlstream.Marshaler   = chatstates.Active
+	_ xmlstream.WriterTo    = chatstates.Active
+	_ xml.Unmarshaler       = (*chatstates.State)(nil)
+	_ xmlstream.Transformer = chatstates.AddActive
+)
+
+var addActiveTestCases = [...]struct {
+	in  string
+	out string
+}{
+	0: {},
+	1: {
+		in:  `<message xmlns="jabber:client" type="chat"/>`,
+		out: `<message xmlns="jabber:client" type="chat"></message>`,
+	},
+	2: {
+		in:  `<message xmlns="jabber:server" type="chat"><body>test</body></message><message xmlns="jabber:client" type="chat"><body>test</body></message>`,
+		out: `<message xmlns="jabber:server" type="chat"><body xmlns="jabber:server">test</body><active xmlns="http://jabber.org/protocol/chatstates"></active></message><message xmlns="jabber:client" type="chat"><body xmlns="jabber:client">test</body><active xmlns="http://jabber.org/protocol/chatstates"></active></message>`,
+	},
+	3: {
+		in:  `<message xmlns="jabber:client" type="chat"><body>test</body><composing xmlns="http://jabber.org/protocol/chatstates"/></message>`,
+		out: `<message xmlns="jabber:client" type="chat"><body xmlns="jabber:client">test</body><composing xmlns="http://jabber.org/protocol/chatstates"></composing></message>`,
+	},
+	4: {
+		in:  `<message xmlns="jabber:client"/><message xmlns="jabber:client" type="groupchat"/><message xmlns="jabber:client" type="error"/>`,
+		out: `<message xmlns="jabber:client"></message><message xmlns="jabber:client" type="groupchat"></message><message xmlns="jabber:client" type="error"></message>`,
+	},
+	5: {
+		in:  `<message xmlns="jabber:badns" type="chat"/>`,
+		out: `<message xmlns="jabber:badns" type="chat"></message>`,
+	},
+	6: {
+		in:  `<message xmlns="jabber:client" type="chat"><body>test</body><x><gone xmlns="http://jabber.org/protocol/chatstates"/></x></message>`,
+		out: `<message xmlns="jabber:client" type="chat"><body xmlns="jabber:client">test</body><x xmlns="jabber:client"><gone xmlns="http://jabber.org/protocol/chatstates"></gone></x><active xmlns="http://jabber.org/protocol/chatstates"></active></message>`,
+	},
+	7: {
+		in:  `<iq xmlns="jabber:client" type="get"/>`,
+		out: `<iq xmlns="jabber:client" type="get"></iq>`,
+	},
+	8: {
+		in:  `<message xmlns="jabber:client" type="chat"><x><body>test</body></x></message>`,
+		out: `<message xmlns="jabber:client" type="chat"><x xmlns="jabber:client"><body xmlns="jabber:client">test</body></x></message>`,
+	},
+	9: {
+		in:  `<message xmlns="jabber:client" type="chat"><body>test</body></message><message xmlns="jabber:client" type="chat"/>`,
+		out: `<message xmlns="jabber:client" type="chat"><body xmlns="jabber:client">test</body><active xmlns="http://jabber.org/protocol/chatstates"></active></message><message xmlns="jabber:client" type="chat"></message>`,
+	},
+}
+
+func TestAddActive(t *testing.T) {
+	for i, tc := range addActiveTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := chatstates.AddActive(xml.NewDecoder(strings.NewReader(tc.in)))
+			// Prevent duplicate xmlns attributes. See https://mellium.im/issue/75
+			r = xmlstream.RemoveAttr(func(start xml.StartElement, attr xml.Attr) bool {
+				return attr.Name.Space == "" && attr.Name.Local == "xmlns"
+			})(r)
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, r)
+			if err != nil {
+				t.Fatalf("error encoding: %v", err)
+			}
+			if err = e.Flush(); err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+
+			if out := buf.String(); tc.out != out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, state := range []chatstates.State{
+		chatstates.Active,
+		chatstates.Composing,
+		chatstates.Paused,
+		chatstates.Inactive,
+		chatstates.Gone,
+	} {
+		t.Run(state.String(), func(t *testing.T) {
+			msg := struct {
+				stanza.Message
+				State chatstates.State `xml:",any"`
+			}{
+				Message: stanza.Message{Type: stanza.ChatMessage},
+				State:   state,
+			}
+			b, err := xml.Marshal(msg)
+			if err != nil {
+				t.Fatalf("error marshaling: %v", err)
+			}
+			if want := `<` + state.String() + ` xmlns="` + chatstates.NS + `">`; !strings.Contains(string(b), want) {
+				t.Errorf("expected output to contain %s, got=%s", want, b)
+			}
+
+			msg.State = chatstates.State(255)
+			err = xml.Unmarshal(b, &msg)
+			if err != nil {
+				t.Fatalf("error unmarshaling: %v", err)
+			}
+			if msg.State != state {
+				t.Errorf("wrong state: want=%v, got=%v", state, msg.State)
+			}
+		})
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><active xmlns="http://jabber.org/protocol/chatstates"/><composing xmlns="http://jabber.org/protocol/chatstates"/><paused xmlns="http://jabber.org/protocol/chatstates"/><inactive xmlns="http://jabber.org/protocol/chatstates"/><gone xmlns="http://jabber.org/protocol/chatstates"/></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		statePtr(chatstates.Active),
+		statePtr(chatstates.Composing),
+		statePtr(chatstates.Paused),
+		statePtr(chatstates.Inactive),
+		statePtr(chatstates.Gone),
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
+
+func statePtr(s chatstates.State) *chatstates.State {
+	return &s
+}
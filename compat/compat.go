@@ -0,0 +1,338 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package compat works around XML quirks produced by real world
+// implementations that encoding/xml does not handle.
+//
+// The encoding/xml package rejects some input that is commonly sent by buggy
+// servers and clients, such as a byte order mark (BOM) at the start of the
+// stream, invalid UTF-8, or numeric character references to characters that
+// are not allowed in XML.
+// It also does not track the language of elements, so stanzas that do not have
+// their own xml:lang attribute do not inherit the language of the stream.
+//
+// A Mode selects whether invalid input is reported using a clear error
+// (Strict) or repaired where possible (Lenient).
+// Byte order marks are always removed from the start of the input since they
+// are allowed by the XML specification.
+//
+// Services that accept connections from untrusted entities on the internet
+// should use the Hardened mode, which repairs input like Lenient but also
+// limits the size of tokens and the depth of nesting so that decoding arbitrary
+// input never uses an unbounded amount of memory.
+package compat // import "mellium.im/xmpp/compat"
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"unicode/utf8"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/ns"
+)
+
+// Errors returned by readers in Strict mode.
+var (
+	ErrInvalidUTF8  = errors.New("compat: invalid UTF-8")
+	ErrIllegalChar  = errors.New("compat: character not allowed in XML")
+	errNeedMoreData = errors.New("compat: need more data")
+)
+
+// Errors returned by readers in Hardened mode.
+var (
+	ErrTokenTooLarge = errors.New("compat: token exceeds maximum size")
+	ErrTooDeep       = errors.New("compat: elements exceed maximum depth")
+	ErrDirective     = errors.New("compat: DTDs and other directives are not allowed")
+)
+
+// Mode controls how invalid input is handled.
+// The zero value disables the compatibility layer entirely.
+type Mode uint8
+
+// A list of modes.
+const (
+	// Strict removes byte order marks but reports an error when invalid UTF-8 or
+	// disallowed characters are encountered.
+	Strict Mode = iota + 1
+
+	// Lenient removes byte order marks and replaces invalid UTF-8 and disallowed
+	// characters (including numeric character references to disallowed
+	// characters) with the Unicode replacement character, U+FFFD.
+	Lenient
+
+	// Hardened behaves like Lenient, but also reports an error if a token is
+	// larger than MaxTokenSize, if elements are nested more than MaxDepth deep,
+	// or if a DTD or other directive is encountered (these are not allowed in
+	// XMPP).
+	Hardened
+)
+
+// Limits enforced in Hardened mode.
+const (
+	// MaxTokenSize is the maximum number of bytes from the start of one tag to
+	// the start of the next, which bounds the size of every token (including
+	// the character data between tags).
+	MaxTokenSize = 256 << 10
+
+	// MaxDepth is the maximum depth of nested elements, including the stream
+	// header.
+	MaxDepth = 64
+)
+
+var (
+	bom         = []byte("\ufeff")
+	cdataStart  = []byte("<![CDATA[")
+	cdataEnd    = []byte("]]>")
+	replacement = []byte("\ufffd")
+	refRepl     = []byte("&#xFFFD;")
+)
+
+// maxRefLen is the maximum length of a numeric character reference that we
+// will attempt to parse including the leading "&#" and trailing ";".
+// It is longer than any valid reference needs to be to allow for leading
+// zeros.
+const maxRefLen = 32
+
+// NewReader returns a reader that removes any byte order mark from the start of
+// r and checks the remaining input according to m.
+// If m is the zero value, r is returned unchanged.
+func NewReader(r io.Reader, m Mode) io.Reader {
+	if m == 0 {
+		return r
+	}
+	cr := &reader{
+		r:    r,
+		mode: m,
+		buf:  make([]byte, 4096),
+	}
+	if m == Hardened {
+		return &limiter{r: cr}
+	}
+	return cr
+}
+
+type reader struct {
+	r       io.Reader
+	mode    Mode
+	buf     []byte
+	in      []byte
+	out     []byte
+	started bool
+	cdata   bool
+	err     error
+}
+
+// Read implements io.Reader.
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *reader) fill() {
+	n, err := r.r.Read(r.buf)
+	r.in = append(r.in, r.buf[:n]...)
+	consumed, procErr := r.process(err != nil)
+	r.in = append(r.in[:0], r.in[consumed:]...)
+	switch {
+	case procErr != nil:
+		r.err = procErr
+	case err != nil:
+		r.err = err
+	}
+}
+
+// process moves as much of the input as possible to the output and returns
+// the number of input bytes consumed.
+// If eof is false, incomplete sequences at the end of the input are left for
+// the next call.
+func (r *reader) process(eof bool) (int, error) {
+	data := r.in
+	i := 0
+	if !r.started {
+		if !eof && len(data) < len(bom) && bytes.HasPrefix(bom, data) {
+			return 0, nil
+		}
+		r.started = true
+		if bytes.HasPrefix(data, bom) {
+			i = len(bom)
+		}
+	}
+	for i < len(data) {
+		rest := data[i:]
+		c := rest[0]
+		switch {
+		case r.cdata && c == ']':
+			if !eof && len(rest) < len(cdataEnd) && bytes.HasPrefix(cdataEnd, rest) {
+				return i, nil
+			}
+			if bytes.HasPrefix(rest, cdataEnd) {
+				r.out = append(r.out, cdataEnd...)
+				i += len(cdataEnd)
+				r.cdata = false
+				continue
+			}
+		case !r.cdata && c == '<':
+			if !eof && len(rest) < len(cdataStart) && bytes.HasPrefix(cdataStart, rest) {
+				return i, nil
+			}
+			if bytes.HasPrefix(rest, cdataStart) {
+				r.out = append(r.out, cdataStart...)
+				i += len(cdataStart)
+				r.cdata = true
+				continue
+			}
+		case !r.cdata && c == '&':
+			n, valid, err := charRef(rest, eof)
+			if err == errNeedMoreData {
+				return i, nil
+			}
+			if n > 0 {
+				if valid {
+					r.out = append(r.out, rest[:n]...)
+				} else {
+					if r.mode == Strict {
+						return i, ErrIllegalChar
+					}
+					r.out = append(r.out, refRepl...)
+				}
+				i += n
+				continue
+			}
+		}
+
+		if c < utf8.RuneSelf {
+			if !isInCharacterRange(rune(c)) {
+				if r.mode == Strict {
+					return i, ErrIllegalChar
+				}
+				r.out = append(r.out, replacement...)
+			} else {
+				r.out = append(r.out, c)
+			}
+			i++
+			continue
+		}
+		if !eof && !utf8.FullRune(rest) {
+			return i, nil
+		}
+		ru, size := utf8.DecodeRune(rest)
+		switch {
+		case ru == utf8.RuneError && size == 1:
+			if r.mode == Strict {
+				return i, ErrInvalidUTF8
+			}
+			r.out = append(r.out, replacement...)
+		case !isInCharacterRange(ru):
+			if r.mode == Strict {
+				return i, ErrIllegalChar
+			}
+			r.out = append(r.out, replacement...)
+		default:
+			r.out = append(r.out, rest[:size]...)
+		}
+		i += size
+	}
+	return i, nil
+}
+
+// charRef parses a numeric character reference at the start of b.
+// It returns the length of the reference and whether it refers to a character
+// that is allowed in XML.
+// If b does not start with a numeric character reference, n is 0.
+func charRef(b []byte, eof bool) (n int, valid bool, err error) {
+	if len(b) < 2 {
+		if eof {
+			return 0, false, nil
+		}
+		return 0, false, errNeedMoreData
+	}
+	if b[1] != '#' {
+		return 0, false, nil
+	}
+	end := bytes.IndexByte(b, ';')
+	if end == -1 || end >= maxRefLen {
+		if !eof && end == -1 && len(b) < maxRefLen {
+			return 0, false, errNeedMoreData
+		}
+		return 0, false, nil
+	}
+	num := string(b[2:end])
+	base := 10
+	if len(num) > 0 && num[0] == 'x' {
+		num = num[1:]
+		base = 16
+	}
+	v, parseErr := strconv.ParseUint(num, base, 32)
+	if parseErr != nil {
+		// If the number is syntactically valid but too large it refers to an
+		// invalid character, otherwise this is not a character reference at all
+		// and we leave it to the XML decoder to report.
+		if ne, ok := parseErr.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return end + 1, false, nil
+		}
+		return 0, false, nil
+	}
+	return end + 1, isInCharacterRange(rune(v)), nil
+}
+
+// isInCharacterRange reports whether r is allowed in XML documents.
+// It is the same check performed by encoding/xml.
+func isInCharacterRange(r rune) bool {
+	return r == 0x09 ||
+		r == 0x0A ||
+		r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// InheritLang returns a transformer that adds an xml:lang attribute with the
+// value lang to every top level element that does not already have one.
+// It is meant to be used on a stream of stanzas so that the language of the
+// stream is inherited by stanzas as required by RFC 6120.
+// If lang is empty, the transformer does nothing.
+func InheritLang(lang string) xmlstream.Transformer {
+	return func(r xml.TokenReader) xml.TokenReader {
+		if lang == "" {
+			return r
+		}
+		var depth int
+		return xmlstream.ReaderFunc(func() (xml.Token, error) {
+			tok, err := r.Token()
+			switch t := tok.(type) {
+			case xml.StartElement:
+				depth++
+				if depth == 1 && !hasLang(t.Attr) {
+					t.Attr = append(t.Attr[:len(t.Attr):len(t.Attr)], xml.Attr{
+						Name:  xml.Name{Space: ns.XML, Local: "lang"},
+						Value: lang,
+					})
+					tok = t
+				}
+			case xml.EndElement:
+				depth--
+			}
+			return tok, err
+		})
+	}
+}
+
+func hasLang(attrs []xml.Attr) bool {
+	for _, attr := range attrs {
+		if attr.Name.Local == "lang" && (attr.Name.Space == ns.XML || attr.Name.Space == "xml") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package compat_test
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/compat"
+	"mellium.im/xmpp/internal/ns"
+)
+
+var readerTests = [...]struct {
+	in      string
+	mode    compat.Mode
+	out     string
+	err     error
+	lenient string
+}{
+	0: {
+		in:  "<a>test</a>",
+		out: "<a>test</a>",
+	},
+	1: {
+		in:  "\ufeff<?xml version='1.0'?><a/>",
+		out: "<?xml version='1.0'?><a/>",
+	},
+	2: {
+		in:      "<a>\xff\xfe</a>",
+		out:     "<a>",
+		err:     compat.ErrInvalidUTF8,
+		lenient: "<a>\ufffd\ufffd</a>",
+	},
+	3: {
+		in:      "<a>\x01</a>",
+		out:     "<a>",
+		err:     compat.ErrIllegalChar,
+		lenient: "<a>\ufffd</a>",
+	},
+	4: {
+		in:      "<a b='&#x0;'>&#1;&#65;&#x10FFFF;&#99999999999;</a>",
+		out:     "<a b='",
+		err:     compat.ErrIllegalChar,
+		lenient: "<a b='&#xFFFD;'>&#xFFFD;&#65;&#x10FFFF;&#xFFFD;</a>",
+	},
+	5: {
+		in:  "<a><![CDATA[&#0;]]>&amp;&#xZZ;</a>",
+		out: "<a><![CDATA[&#0;]]>&amp;&#xZZ;</a>",
+	},
+	6: {
+		in:  "<a>ü€😀</a>",
+		out: "<a>ü€😀</a>",
+	},
+	7: {
+		in:      "<a>\xef\xbf\xbe</a>",
+		out:     "<a>",
+		err:     compat.ErrIllegalChar,
+		lenient: "<a>\ufffd</a>",
+	},
+	8: {
+		in:      "<a>\xe2\x82</a>",
+		out:     "<a>",
+		err:     compat.ErrInvalidUTF8,
+		lenient: "<a>\ufffd\ufffd</a>",
+	},
+}
+
+func TestReader(t *testing.T) {
+	for i, tc := range readerTests {
+		for _, mode := range []compat.Mode{compat.Strict, compat.Lenient, compat.Hardened} {
+			for _, oneByte := range []bool{false, true} {
+				t.Run(strconv.Itoa(i), func(t *testing.T) {
+					out, err := tc.out, tc.err
+					if mode != compat.Strict && tc.lenient != "" {
+						out, err = tc.lenient, nil
+					}
+					var r = strings.NewReader(tc.in)
+					in := ioutil.NopCloser(r)
+					if oneByte {
+						in = ioutil.NopCloser(iotest.OneByteReader(r))
+					}
+					b, e := ioutil.ReadAll(compat.NewReader(in, mode))
+					if e != err {
+						t.Errorf("wrong error: want=%v, got=%v", err, e)
+					}
+					if string(b) != out {
+						t.Errorf("wrong output:\nwant=%q,\n got=%q", out, b)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestReaderDisabled(t *testing.T) {
+	r := strings.NewReader("\ufeff")
+	if compat.NewReader(r, 0) != r {
+		t.Errorf("expected reader to be returned unchanged with the zero mode")
+	}
+}
+
+func TestLenientDecode(t *testing.T) {
+	d := xml.NewDecoder(compat.NewReader(strings.NewReader("\ufeff<a>\xff&#1;</a>"), compat.Lenient))
+	var s string
+	err := d.Decode(&s)
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	if want := "\ufffd\ufffd"; s != want {
+		t.Errorf("wrong value decoded: want=%q, got=%q", want, s)
+	}
+}
+
+var hardenedTests = [...]struct {
+	in  string
+	err error
+}{
+	0: {in: "<?xml version='1.0'?><a b='>' c=\"/>\"><b/><!-- <c> --><![CDATA[<d>]]></a>"},
+	1: {in: "<!DOCTYPE a [<!ENTITY b 'c'>]><a/>", err: compat.ErrDirective},
+	2: {in: strings.Repeat("<a>", compat.MaxDepth+1), err: compat.ErrTooDeep},
+	3: {in: strings.Repeat("<a></a>", compat.MaxDepth+1)},
+	4: {in: strings.Repeat("<a/>", compat.MaxDepth+1)},
+	5: {in: "<a>" + strings.Repeat("b", compat.MaxTokenSize), err: compat.ErrTokenTooLarge},
+	6: {in: "<a b='" + strings.Repeat("<", compat.MaxTokenSize) + "'/>", err: compat.ErrTokenTooLarge},
+	7: {in: "<a><![CDATA[" + strings.Repeat("<", compat.MaxTokenSize) + "]]></a>", err: compat.ErrTokenTooLarge},
+	8: {in: "<a>" + strings.Repeat("<!-- -->", compat.MaxTokenSize/8) + "</a>"},
+}
+
+func TestHardened(t *testing.T) {
+	for i, tc := range hardenedTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := ioutil.ReadAll(compat.NewReader(strings.NewReader(tc.in), compat.Hardened))
+			if err != tc.err {
+				t.Errorf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+var langTests = [...]struct {
+	in   string
+	lang string
+	out  []string
+}{
+	0: {
+		in:   `<message><body/></message><iq xml:lang="de"/><presence/>`,
+		lang: "en",
+		out:  []string{"en", "", "de", "en"},
+	},
+	1: {
+		in:  `<message><body/></message>`,
+		out: []string{"", ""},
+	},
+}
+
+func TestInheritLang(t *testing.T) {
+	for i, tc := range langTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			toks, err := xmlstream.ReadAll(compat.InheritLang(tc.lang)(xml.NewDecoder(strings.NewReader(tc.in))))
+			if err != nil {
+				t.Fatalf("error reading tokens: %v", err)
+			}
+			var langs []string
+			for _, tok := range toks {
+				start, ok := tok.(xml.StartElement)
+				if !ok {
+					continue
+				}
+				var lang string
+				for _, attr := range start.Attr {
+					if attr.Name == (xml.Name{Space: ns.XML, Local: "lang"}) {
+						lang = attr.Value
+					}
+				}
+				langs = append(langs, lang)
+			}
+			if !reflect.DeepEqual(langs, tc.out) {
+				t.Errorf("wrong languages: want=%q, got=%q", tc.out, langs)
+			}
+		})
+	}
+}
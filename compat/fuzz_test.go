@@ -0,0 +1,70 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package compat_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"mellium.im/xmpp/compat"
+)
+
+// FuzzHardened checks that decoding arbitrary input in Hardened mode never
+// panics and never produces tokens that exceed the limits.
+// Along with the seeds added below, some known hostile inputs are kept in
+// testdata/fuzz/FuzzHardened and are run by go test.
+// To search for new problems run:
+//
+//     go test -run=^$ -fuzz=FuzzHardened ./compat
+func FuzzHardened(f *testing.F) {
+	for _, tc := range readerTests {
+		f.Add([]byte(tc.in))
+	}
+	for _, tc := range hardenedTests {
+		if len(tc.in) < 1024 {
+			f.Add([]byte(tc.in))
+		}
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		d := xml.NewTokenDecoder(compat.InheritLang("en")(
+			xml.NewDecoder(compat.NewReader(bytes.NewReader(b), compat.Hardened)),
+		))
+		var depth int
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				depth++
+				if depth > compat.MaxDepth {
+					t.Fatalf("depth %d exceeds maximum", depth)
+				}
+				for _, a := range tok.Attr {
+					if len(a.Value) > compat.MaxTokenSize {
+						t.Fatalf("attribute of length %d exceeds maximum", len(a.Value))
+					}
+				}
+			case xml.EndElement:
+				depth--
+			case xml.CharData:
+				if len(tok) > compat.MaxTokenSize {
+					t.Fatalf("chardata of length %d exceeds maximum", len(tok))
+				}
+			case xml.Comment:
+				if len(tok) > compat.MaxTokenSize {
+					t.Fatalf("comment of length %d exceeds maximum", len(tok))
+				}
+			case xml.Directive:
+				t.Fatalf("unexpected directive %q", tok)
+			}
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package compat
+
+import (
+	"io"
+)
+
+type lexState uint8
+
+const (
+	lexText lexState = iota
+	lexLT
+	lexBang
+	lexBangDash
+	lexComment
+	lexCDATA
+	lexPI
+	lexTag
+)
+
+// limiter is a reader that tracks just enough of the XML syntax to enforce the
+// limits used in Hardened mode before the input reaches the decoder.
+// It does not validate the input, anything that is not well formed is left for
+// the decoder to report.
+type limiter struct {
+	r   io.Reader
+	err error
+
+	state lexState
+	size  int
+	depth int
+
+	// run is the number of consecutive '-' or ']' characters seen in a comment
+	// or CDATA section, or 1 if the last character in a processing instruction
+	// was a '?'.
+	run     int
+	quote   byte
+	closing bool
+	slash   bool
+}
+
+// Read implements io.Reader.
+func (l *limiter) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	for i, c := range p[:n] {
+		if e := l.next(c); e != nil {
+			l.err = e
+			return i, e
+		}
+	}
+	return n, err
+}
+
+func (l *limiter) next(c byte) error {
+	if l.state == lexText && c == '<' {
+		l.size = 0
+		l.state = lexLT
+	}
+	l.size++
+	if l.size > MaxTokenSize {
+		return ErrTokenTooLarge
+	}
+
+	switch l.state {
+	case lexLT:
+		if c == '<' {
+			return nil
+		}
+		l.run = 0
+		switch c {
+		case '!':
+			l.state = lexBang
+		case '?':
+			l.state = lexPI
+		default:
+			l.state = lexTag
+			l.quote = 0
+			l.closing = c == '/'
+			l.slash = false
+		}
+	case lexBang:
+		switch c {
+		case '-':
+			l.state = lexBangDash
+		case '[':
+			l.state = lexCDATA
+		default:
+			return ErrDirective
+		}
+	case lexBangDash:
+		if c != '-' {
+			return ErrDirective
+		}
+		l.state = lexComment
+	case lexComment, lexCDATA:
+		end := byte('-')
+		if l.state == lexCDATA {
+			end = ']'
+		}
+		switch {
+		case c == end:
+			l.run++
+		case c == '>' && l.run >= 2:
+			l.state = lexText
+		default:
+			l.run = 0
+		}
+	case lexPI:
+		if c == '>' && l.run == 1 {
+			l.state = lexText
+		}
+		l.run = 0
+		if c == '?' {
+			l.run = 1
+		}
+	case lexTag:
+		switch {
+		case l.quote != 0:
+			if c == l.quote {
+				l.quote = 0
+			}
+		case c == '"' || c == '\'':
+			l.quote = c
+			l.slash = false
+		case c == '>':
+			l.state = lexText
+			switch {
+			case l.closing:
+				if l.depth > 0 {
+					l.depth--
+				}
+			case !l.slash:
+				l.depth++
+				if l.depth > MaxDepth {
+					return ErrTooDeep
+				}
+			}
+		default:
+			l.slash = c == '/'
+		}
+	}
+	return nil
+}
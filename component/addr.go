@@ -0,0 +1,127 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component
+
+import (
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/stanzaname"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// ErrForeignFrom is returned by the transformer created by Stamp if a stanza
+// has a from address that does not belong to the component.
+var ErrForeignFrom = errors.New("component: from address is not at the component domain")
+
+// Stamp returns a transformer that sets the from attribute of outgoing stanzas.
+// Servers require that stanzas sent by a component are from the component's
+// domain or an address at that domain (for example, a user of a gateway such as
+// user@gateway.example.net).
+// Stanzas without a from attribute are stamped with the domainpart of addr.
+// If a stanza already has a from address at another domain, ErrForeignFrom is
+// returned instead of forwarding a stanza that the server would reject.
+func Stamp(addr jid.JID) xmlstream.Transformer {
+	domain := addr.Domain()
+	return func(r xml.TokenReader) xml.TokenReader {
+		var depth int
+		return xmlstream.ReaderFunc(func() (xml.Token, error) {
+			tok, err := r.Token()
+			if tok == nil {
+				return tok, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				depth++
+				if depth > 1 || !stanzaname.Is(t.Name, NSAccept) {
+					return tok, err
+				}
+				idx, from := attr.Get(t.Attr, "from")
+				if idx == -1 {
+					t = t.Copy()
+					t.Attr = append(t.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: domain.String()})
+					return t, err
+				}
+				j, e := jid.Parse(from)
+				if e != nil {
+					return nil, e
+				}
+				if !j.Domain().Equal(domain) {
+					return nil, ErrForeignFrom
+				}
+			case xml.EndElement:
+				depth--
+			}
+			return tok, err
+		})
+	}
+}
+
+// Addressed reports whether the stanza started by start is addressed to the
+// domain of addr or an address at that domain.
+func Addressed(addr jid.JID, start xml.StartElement) bool {
+	_, to := attr.Get(start.Attr, "to")
+	j, err := jid.Parse(to)
+	if err != nil {
+		return false
+	}
+	return j.Domain().Equal(addr.Domain())
+}
+
+// Bounce writes an error reply to the stanza started by start to w.
+// The to and from attributes are swapped, the id is preserved, and the type is
+// set to "error".
+// Stanzas that are already of type "error" are never bounced, in which case
+// Bounce writes nothing and returns nil.
+func Bounce(w xmlstream.TokenWriter, start xml.StartElement, e stanza.Error) error {
+	_, typ := attr.Get(start.Attr, "type")
+	if typ == "error" {
+		return nil
+	}
+	_, id := attr.Get(start.Attr, "id")
+	_, from := attr.Get(start.Attr, "from")
+	_, to := attr.Get(start.Attr, "to")
+	var attrs []xml.Attr
+	if id != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "id"}, Value: id})
+	}
+	if from != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "to"}, Value: from})
+	}
+	if to != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "from"}, Value: to})
+	}
+	attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "type"}, Value: "error"})
+	_, err := xmlstream.Copy(w, xmlstream.Wrap(
+		e.TokenReader(),
+		xml.StartElement{Name: xml.Name{Local: start.Name.Local}, Attr: attrs},
+	))
+	return err
+}
+
+// Filter returns a handler that only passes stanzas addressed to the component
+// (see Addressed) to h.
+// Other stanzas are bounced with an item-not-found error, or a jid-malformed
+// error if the to address cannot be parsed.
+func Filter(addr jid.JID, h xmpp.Handler) xmpp.Handler {
+	return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		if !stanzaname.Is(start.Name, NSAccept) || Addressed(addr, *start) {
+			return h.HandleXMPP(t, start)
+		}
+		cond := stanza.ItemNotFound
+		_, to := attr.Get(start.Attr, "to")
+		if _, err := jid.Parse(to); err != nil {
+			cond = stanza.JIDMalformed
+		}
+		return Bounce(t, *start, stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: cond,
+		})
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/component"
+	"mellium.im/xmpp/jid"
+)
+
+var componentAddr = jid.MustParse("gateway.example.net")
+
+var stampTests = [...]struct {
+	in   string
+	from string
+	err  error
+}{
+	0: {
+		in:   `<message xmlns="jabber:component:accept" to="juliet@example.com"><body>hi</body></message>`,
+		from: "gateway.example.net",
+	},
+	1: {
+		in:   `<message xmlns="jabber:component:accept" from="romeo@gateway.example.net/a" to="juliet@example.com"></message>`,
+		from: "romeo@gateway.example.net/a",
+	},
+	2: {
+		in:  `<message xmlns="jabber:component:accept" from="romeo@example.net" to="juliet@example.com"></message>`,
+		err: component.ErrForeignFrom,
+	},
+	3: {
+		in: `<foo xmlns="urn:example"><message/></foo>`,
+	},
+}
+
+func TestStamp(t *testing.T) {
+	for i, tc := range stampTests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			toks, err := xmlstream.ReadAll(component.Stamp(componentAddr)(xml.NewDecoder(strings.NewReader(tc.in))))
+			if err != tc.err {
+				t.Fatalf("unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			var from string
+			for _, tok := range toks {
+				start, ok := tok.(xml.StartElement)
+				if !ok || start.Name.Local != "message" {
+					continue
+				}
+				for _, a := range start.Attr {
+					if a.Name.Local == "from" {
+						from = a.Value
+					}
+				}
+			}
+			if from != tc.from {
+				t.Errorf("wrong from: want=%q, got=%q", tc.from, from)
+			}
+		})
+	}
+}
+
+var filterTests = [...]struct {
+	in      string
+	out     string
+	handled bool
+}{
+	0: {
+		in:      `<message xmlns="jabber:component:accept" to="romeo@gateway.example.net" from="juliet@example.com"/>`,
+		handled: true,
+	},
+	1: {
+		in:  `<iq xmlns="jabber:component:accept" id="123" type="get" to="other.example.net" from="juliet@example.com"><ping xmlns="urn:xmpp:ping"/></iq>`,
+		out: `<iq id="123" to="juliet@example.com" from="other.example.net" type="error"><error type="cancel"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></item-not-found></error></iq>`,
+	},
+	2: {
+		in:  `<message xmlns="jabber:component:accept" to="@" from="juliet@example.com"/>`,
+		out: `<message to="juliet@example.com" from="@" type="error"><error type="cancel"><jid-malformed xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></jid-malformed></error></message>`,
+	},
+	3: {
+		in: `<message xmlns="jabber:component:accept" type="error" to="other.example.net" from="juliet@example.com"/>`,
+	},
+}
+
+func TestFilter(t *testing.T) {
+	for i, tc := range filterTests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var handled bool
+			h := component.Filter(componentAddr, xmpp.HandlerFunc(func(xmlstream.TokenReadEncoder, *xml.StartElement) error {
+				handled = true
+				return nil
+			}))
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error decoding start: %v", err)
+			}
+			start := tok.(xml.StartElement)
+			var b bytes.Buffer
+			e := xml.NewEncoder(&b)
+			err = h.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+				Encoder:     e,
+			}, &start)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if handled != tc.handled {
+				t.Errorf("wrong handled value: want=%t, got=%t", tc.handled, handled)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			if out := b.String(); out != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/labels"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/storage"
+	"mellium.im/xmpp/stream"
+)
+
+// Errors returned by Client.
+var (
+	ErrNotConnected = errors.New("component: not connected")
+	ErrQueueFull    = errors.New("component: outbound queue is full")
+)
+
+// stableSession is how long a session must stay up before the client stops
+// backing off between reconnects.
+// A server that accepts the handshake and then immediately drops the
+// connection is treated the same as one that cannot be reached.
+const stableSession = time.Minute
+
+// DefaultBackoff is the backoff used by Client if none is set.
+// It waits one second after the first failed attempt and doubles the delay
+// after each subsequent failure up to a maximum of one minute.
+func DefaultBackoff(failures int) time.Duration {
+	const maxDelay = time.Minute
+	if failures < 1 {
+		return 0
+	}
+	if failures > 7 {
+		return maxDelay
+	}
+	d := time.Second << uint(failures-1)
+	if d > maxDelay {
+		return maxDelay
+	}
+	return d
+}
+
+// Client maintains a component session, reconnecting whenever the connection is
+// lost (for example, because the server was restarted).
+// Outgoing stanzas sent while the connection is down are queued and sent in
+// order once a new session has been established.
+//
+// The exported fields must not be modified after Run has been called.
+type Client struct {
+	// Addr and Secret are used to authenticate with the server.
+	Addr   jid.JID
+	Secret []byte
+
+	// Dial opens a new connection to the server.
+	// It is called each time the client (re)connects and must be set.
+	Dial func(ctx context.Context) (net.Conn, error)
+
+	// Handler is used to serve each session.
+	// It has the same restrictions as a handler passed to xmpp.Session.Serve.
+	Handler xmpp.Handler
+
+	// KeepAlive is the interval at which the server is pinged.
+	// If the server does not respond to a ping before the next one is due the
+	// session is ended and the client reconnects.
+	// If KeepAlive is zero, no pings are sent.
+	KeepAlive time.Duration
+
+	// Backoff returns the delay before reconnecting after the given number of
+	// consecutive failed connection attempts.
+	// Sessions that end less than a minute after they were established also
+	// count as failures, otherwise the count is reset.
+	// If Backoff is nil, DefaultBackoff is used.
+	Backoff func(failures int) time.Duration
+
+	// QueueSize is the maximum number of stanzas that will be queued while the
+	// client is disconnected.
+	// If it is zero, Send returns ErrNotConnected while disconnected.
+	QueueSize int
+
+	// OnConnect, if set, is called every time a new session is established
+	// before any queued stanzas are sent.
+	OnConnect func(*xmpp.Session)
+
+	// Journal, if set, is a write-ahead log for the outbound queue that lets
+	// stanzas queued while the client was disconnected be sent after the process
+	// is restarted (for example, after a crash).
+	// Stanzas are written to the journal before they are queued and removed
+	// once they have been sent, and any stanzas left in the journal by a
+	// previous process are queued when Run is called.
+	// Stanzas may be sent more than once if the process exits after sending them
+	// but before they are removed from the journal.
+	Journal storage.Queue
+
+	mu      sync.Mutex
+	session *xmpp.Session
+	queue   [][]xml.Token
+}
+
+// Session returns the current session or nil if the client is not connected.
+func (c *Client) Session() *xmpp.Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// Run connects to the server and serves the session, reconnecting whenever it
+// ends, until ctx is canceled.
+// If the server rejects the handshake (for example, because the secret is
+// wrong) reconnecting would not help and the stream error is returned.
+func (c *Client) Run(ctx context.Context) error {
+	backoff := c.Backoff
+	if backoff == nil {
+		backoff = DefaultBackoff
+	}
+	if c.Journal != nil {
+		err := c.loadJournal()
+		if err != nil {
+			return err
+		}
+	}
+	var failures int
+	for {
+		if failures > 0 {
+			timer := time.NewTimer(backoff(failures))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		conn, err := c.Dial(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures++
+			continue
+		}
+		session, err := NewSession(ctx, c.Addr, c.Secret, conn)
+		if err != nil {
+			/* #nosec */
+			conn.Close()
+			var streamErr stream.Error
+			if errors.As(err, &streamErr) {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures++
+			continue
+		}
+		established := time.Now()
+		c.serve(ctx, conn, session)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if time.Since(established) < stableSession {
+			failures++
+		} else {
+			failures = 0
+		}
+	}
+}
+
+// serve runs a single session until it ends.
+func (c *Client) serve(ctx context.Context, conn net.Conn, session *xmpp.Session) {
+	if c.OnConnect != nil {
+		c.OnConnect(session)
+	}
+	err := c.flush(ctx, session)
+	if err != nil {
+		// Anything that was not sent is still queued and will be retried after
+		// reconnecting.
+		/* #nosec */
+		conn.Close()
+		return
+	}
+
+	done := make(chan struct{})
+	go pprof.Do(ctx, labels.Session(session.LocalAddr(), session.RemoteAddr(), "keepalive"), func(ctx context.Context) {
+		var tick <-chan time.Time
+		if c.KeepAlive > 0 {
+			ticker := time.NewTicker(c.KeepAlive)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				/* #nosec */
+				conn.Close()
+				return
+			case <-tick:
+				pingCtx, cancel := context.WithTimeout(ctx, c.KeepAlive)
+				err := ping.Send(pingCtx, session, session.RemoteAddr())
+				cancel()
+				if err != nil {
+					/* #nosec */
+					conn.Close()
+					return
+				}
+			}
+		}
+	})
+
+	/* #nosec */
+	session.Serve(c.Handler)
+	close(done)
+
+	c.mu.Lock()
+	c.session = nil
+	c.mu.Unlock()
+	/* #nosec */
+	conn.Close()
+}
+
+// flush sends the queued stanzas over session in order and then makes it the
+// current session.
+// Stanzas passed to Send while the queue is being flushed are queued behind
+// the others so that order is preserved.
+func (c *Client) flush(ctx context.Context, session *xmpp.Session) error {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 {
+			c.session = session
+			c.mu.Unlock()
+			return nil
+		}
+		toks := c.queue[0]
+		c.mu.Unlock()
+
+		err := session.Send(ctx, xmlstream.MultiReader(tokenReaders(toks)...))
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+		if c.Journal != nil {
+			// If this fails the stanza will be sent again after a restart, which is
+			// better than losing it.
+			/* #nosec */
+			c.Journal.Remove(1)
+		}
+	}
+}
+
+// Send transmits the element read from r over the current session.
+// If the client is not connected the element is queued to be sent after
+// reconnecting.
+// If the queue is full ErrQueueFull is returned.
+// Errors sending over a connected session are returned and the element is not
+// queued.
+//
+// Like the send methods on xmpp.Session, Send must not be called from the
+// client's handler.
+func (c *Client) Send(ctx context.Context, r xml.TokenReader) error {
+	toks, err := xmlstream.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	for i, tok := range toks {
+		toks[i] = xml.CopyToken(tok)
+	}
+
+	c.mu.Lock()
+	session := c.session
+	if session == nil {
+		defer c.mu.Unlock()
+		return c.enqueue(toks)
+	}
+	c.mu.Unlock()
+	return session.Send(ctx, xmlstream.MultiReader(tokenReaders(toks)...))
+}
+
+// enqueue adds toks to the end of the queue.
+// c.mu must be held so that the journal and queue stay in the same order.
+func (c *Client) enqueue(toks []xml.Token) error {
+	if c.QueueSize == 0 {
+		return ErrNotConnected
+	}
+	if len(c.queue) >= c.QueueSize {
+		return ErrQueueFull
+	}
+	if c.Journal != nil {
+		p, err := encodeTokens(toks)
+		if err != nil {
+			return err
+		}
+		err = c.Journal.Append(p)
+		if err != nil {
+			return err
+		}
+	}
+	c.queue = append(c.queue, toks)
+	return nil
+}
+
+// loadJournal queues any stanzas left in the journal by a previous process.
+func (c *Client) loadJournal() error {
+	stanzas, err := c.Journal.Load()
+	if err != nil {
+		return err
+	}
+	queue := make([][]xml.Token, 0, len(stanzas))
+	for _, p := range stanzas {
+		toks, err := decodeTokens(p)
+		if err != nil {
+			return err
+		}
+		queue = append(queue, toks)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queue = append(queue, c.queue...)
+	return nil
+}
+
+func tokenReaders(toks []xml.Token) []xml.TokenReader {
+	readers := make([]xml.TokenReader, 0, len(toks))
+	for _, tok := range toks {
+		readers = append(readers, xmlstream.Token(tok))
+	}
+	return readers
+}
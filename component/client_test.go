@@ -0,0 +1,193 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component_test
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/component"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// fakeServer accepts the component handshake on conn and then reports the
+// name of every top level element it receives on elems.
+func fakeServer(conn net.Conn, elems chan<- string) {
+	d := xml.NewDecoder(conn)
+	// Read the stream header and handshake.
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "handshake" {
+			err = d.Skip()
+			if err != nil {
+				return
+			}
+			break
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "stream" {
+			_, err = fmt.Fprint(conn, `<stream:stream xmlns='jabber:component:accept' xmlns:stream='http://etherx.jabber.org/streams' from='example.net' id='1234'>`)
+			if err != nil {
+				return
+			}
+		}
+	}
+	_, err := fmt.Fprint(conn, `<handshake/>`)
+	if err != nil {
+		return
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			elems <- start.Name.Local
+			err = d.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	elems := make(chan string, 10)
+	connected := make(chan net.Conn, 2)
+	redial := make(chan struct{})
+	var dials int
+	c := &component.Client{
+		Addr:   jid.MustParse("component.example.net"),
+		Secret: []byte("secret"),
+		Dial: func(ctx context.Context) (net.Conn, error) {
+			// Block reconnects until the test has queued a message.
+			if dials > 0 {
+				select {
+				case <-redial:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			dials++
+			client, server := net.Pipe()
+			go fakeServer(server, elems)
+			return client, nil
+		},
+		Backoff:   func(int) time.Duration { return 0 },
+		QueueSize: 1,
+		OnConnect: func(s *xmpp.Session) {
+			connected <- s.Conn()
+		},
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(ctx)
+	}()
+
+	wait := func() net.Conn {
+		select {
+		case conn := <-connected:
+			return conn
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for connection")
+		}
+		return nil
+	}
+	conn := wait()
+
+	// Drop the connection and queue a message while reconnecting.
+	err := conn.Close()
+	if err != nil {
+		t.Fatalf("error closing connection: %v", err)
+	}
+	for c.Session() != nil {
+		time.Sleep(time.Millisecond)
+	}
+	msg := stanza.Message{To: jid.MustParse("juliet@example.com"), From: c.Addr}
+	err = c.Send(ctx, msg.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error queuing message: %v", err)
+	}
+	err = c.Send(ctx, msg.Wrap(nil))
+	if err != component.ErrQueueFull {
+		t.Errorf("wrong error for full queue: want=%v, got=%v", component.ErrQueueFull, err)
+	}
+
+	close(redial)
+	wait()
+	select {
+	case name := <-elems:
+		if name != "message" {
+			t.Errorf("wrong element sent after reconnect: want=message, got=%s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for queued message")
+	}
+
+	cancel()
+	select {
+	case err = <-runErr:
+		if err != context.Canceled {
+			t.Errorf("wrong error from Run: want=%v, got=%v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	elems := make(chan string, 10)
+	c := &component.Client{
+		Addr:   jid.MustParse("component.example.net"),
+		Secret: []byte("secret"),
+		Dial: func(ctx context.Context) (net.Conn, error) {
+			client, server := net.Pipe()
+			go fakeServer(server, elems)
+			return client, nil
+		},
+		KeepAlive: 10 * time.Millisecond,
+	}
+	go func() {
+		/* #nosec */
+		c.Run(ctx)
+	}()
+	select {
+	case name := <-elems:
+		if name != "iq" {
+			t.Errorf("wrong element sent as keepalive: want=iq, got=%s", name)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for keepalive")
+	}
+}
+
+func TestDefaultBackoff(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		0:   0,
+		1:   time.Second,
+		2:   2 * time.Second,
+		6:   32 * time.Second,
+		7:   time.Minute,
+		100: time.Minute,
+	} {
+		if got := component.DefaultBackoff(failures); got != want {
+			t.Errorf("wrong backoff after %d failures: want=%v, got=%v", failures, want, got)
+		}
+	}
+}
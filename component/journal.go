@@ -0,0 +1,60 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component
+
+import (
+	"bytes"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/storage"
+)
+
+// Journal is a write-ahead log for the outbound queue of a Client.
+// It lets stanzas that were queued while the client was disconnected be sent
+// after the process is restarted, for example after a crash.
+//
+// Journal is an alias for storage.Queue, which should be used by new code.
+type Journal = storage.Queue
+
+// FileJournal is a Journal that stores stanzas in a file.
+//
+// FileJournal is an alias for storage.FileQueue, which should be used by new
+// code.
+type FileJournal = storage.FileQueue
+
+// OpenJournal opens the journal file with the given name, creating it if it
+// does not exist.
+// It is the same as storage.OpenQueue, which should be used by new code.
+func OpenJournal(name string) (*FileJournal, error) {
+	return storage.OpenQueue(name)
+}
+
+func encodeTokens(toks []xml.Token) ([]byte, error) {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, xmlstream.MultiReader(tokenReaders(toks)...))
+	if err != nil {
+		return nil, err
+	}
+	err = e.Flush()
+	return buf.Bytes(), err
+}
+
+func decodeTokens(p []byte) ([]xml.Token, error) {
+	// The decoder adds xmlns attributes that would be duplicated when the tokens
+	// are encoded again.
+	r := xmlstream.RemoveAttr(func(start xml.StartElement, attr xml.Attr) bool {
+		return start.Name.Space != "" && attr.Name.Space == "" && attr.Name.Local == "xmlns"
+	})(xml.NewDecoder(bytes.NewReader(p)))
+	toks, err := xmlstream.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for i, tok := range toks {
+		toks[i] = xml.CopyToken(tok)
+	}
+	return toks, nil
+}
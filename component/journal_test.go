@@ -0,0 +1,106 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package component_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/component"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/storage"
+)
+
+func loadJournal(t *testing.T, j storage.Queue) []string {
+	t.Helper()
+	stanzas, err := j.Load()
+	if err != nil {
+		t.Fatalf("error loading journal: %v", err)
+	}
+	var s []string
+	for _, p := range stanzas {
+		s = append(s, string(p))
+	}
+	return s
+}
+
+func TestClientJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	j, err := component.OpenJournal(filepath.Join(dir, "queue"))
+	if err != nil {
+		t.Fatalf("error opening journal: %v", err)
+	}
+	defer j.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addr := jid.MustParse("component.example.net")
+	elems := make(chan string, 10)
+	dial := make(chan struct{})
+	newClient := func() *component.Client {
+		return &component.Client{
+			Addr:   addr,
+			Secret: []byte("secret"),
+			Dial: func(ctx context.Context) (net.Conn, error) {
+				select {
+				case <-dial:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				client, server := net.Pipe()
+				go fakeServer(server, elems)
+				return client, nil
+			},
+			QueueSize: 10,
+			Journal:   j,
+		}
+	}
+
+	// Queue a message with a client that never connects, as if the process had
+	// crashed before it could be sent.
+	c := newClient()
+	msg := stanza.Message{To: jid.MustParse("juliet@example.com"), From: addr}
+	err = c.Send(ctx, msg.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error queuing message: %v", err)
+	}
+	if s := loadJournal(t, j); len(s) != 1 {
+		t.Fatalf("expected queued message to be journaled, got %v", s)
+	}
+
+	c = newClient()
+	runCtx, runCancel := context.WithCancel(ctx)
+	defer runCancel()
+	go func() {
+		/* #nosec */
+		c.Run(runCtx)
+	}()
+	close(dial)
+	select {
+	case name := <-elems:
+		if name != "message" {
+			t.Errorf("wrong element sent from journal: want=message, got=%s", name)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for journaled message")
+	}
+	for c.Session() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if s := loadJournal(t, j); len(s) != 0 {
+		t.Errorf("expected sent message to be removed from journal, got %v", s)
+	}
+}
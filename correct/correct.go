@@ -0,0 +1,77 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package correct implements last message correction.
+//
+// Corrections let users fix mistakes in a message they have already sent by
+// sending a new message that replaces it.
+// Receiving clients should only accept a correction if it is from the same
+// full JID as the original message.
+package correct // import "mellium.im/xmpp/correct"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:message-correct:0"
+
+// Replace indicates that a message is a correction of the message with the
+// given ID.
+// When unmarshaled into a message struct, an empty ID means that the message is
+// not a correction.
+type Replace struct {
+	XMLName xml.Name `xml:"urn:xmpp:message-correct:0 replace"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Replace) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "replace"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: r.ID}},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Replace) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Replace) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Message returns a message stanza with the given body that replaces the
+// message with ID id.
+// The message should be sent to the same recipient and with the same type as
+// the original message, but must have its own ID.
+// If msg does not have an ID, one is generated.
+func Message(msg stanza.Message, id, body string) xml.TokenReader {
+	if msg.ID == "" {
+		msg.ID = attr.RandomID()
+	}
+	return msg.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(body)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		),
+		Replace{ID: id}.TokenReader(),
+	))
+}
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "replace"}, func() interface{} {
+		return &Replace{}
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package correct_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/correct"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = correct.Replace{}
+	_ xmlstream.Marshaler = correct.Replace{}
+	_ xmlstream.WriterTo  = correct.Replace{}
+)
+
+func TestMessage(t *testing.T) {
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, correct.Message(stanza.Message{
+		ID:   "good1",
+		To:   jid.MustParse("juliet@capulet.net/balcony"),
+		Type: stanza.ChatMessage,
+	}, "bad1", "But soft, what light through yonder window breaks?"))
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	if err = e.Flush(); err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<message type="chat" id="good1" to="juliet@capulet.net/balcony"><body>But soft, what light through yonder window breaks?</body><replace xmlns="urn:xmpp:message-correct:0" id="bad1"></replace></message>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+
+	var msg struct {
+		stanza.Message
+		Body    string          `xml:"body"`
+		Replace correct.Replace `xml:"urn:xmpp:message-correct:0 replace"`
+	}
+	err = xml.Unmarshal([]byte(buf.String()), &msg)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if msg.Replace.ID != "bad1" {
+		t.Errorf("wrong replaced ID: want=bad1, got=%q", msg.Replace.ID)
+	}
+
+	var plain struct {
+		stanza.Message
+		Replace correct.Replace `xml:"urn:xmpp:message-correct:0 replace"`
+	}
+	err = xml.Unmarshal([]byte(`<message><body>test</body></message>`), &plain)
+	if err != nil {
+		t.Fatalf("error unmarshaling plain message: %v", err)
+	}
+	if plain.Replace.ID != "" {
+		t.Errorf("did not expect plain message to be a correction, got %+v", plain.Replace)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><replace xmlns="urn:xmpp:message-correct:0" id="123"/></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		&correct.Replace{XMLName: xml.Name{Space: correct.NS, Local: "replace"}, ID: "123"},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
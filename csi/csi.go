@@ -0,0 +1,79 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package csi implements client state indication.
+//
+// Client state indication lets a client tell the server when the user is not
+// actively using it (for example, when a mobile app is in the background) so
+// that the server can delay or drop unimportant traffic and save bandwidth and
+// battery.
+package csi // import "mellium.im/xmpp/csi"
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:csi:0"
+
+// Feature is a stream feature that advertises support for client state
+// indication on the server side of a connection and detects it on the client
+// side.
+// There is nothing to negotiate, so clients do not have to use Feature to
+// check for support, see Supported.
+func Feature() xmpp.StreamFeature {
+	return xmpp.StreamFeature{
+		Name:      xml.Name{Space: NS, Local: "csi"},
+		Necessary: xmpp.Authn,
+		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (req bool, err error) {
+			if err = e.EncodeToken(start); err != nil {
+				return req, err
+			}
+			return req, e.EncodeToken(start.End())
+		},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			return false, nil, d.Skip()
+		},
+		Negotiate: func(ctx context.Context, session *xmpp.Session, data interface{}) (xmpp.SessionState, io.ReadWriter, error) {
+			if (session.State() & xmpp.Received) != xmpp.Received {
+				return 0, nil, nil
+			}
+			// If a client sent its state before negotiation was complete, skip it.
+			r := session.TokenReader()
+			defer r.Close()
+			_, err := r.Token()
+			if err != nil {
+				return 0, nil, err
+			}
+			_, err = xmlstream.Copy(xmlstream.Discard(), xmlstream.Inner(r))
+			return 0, nil, err
+		},
+	}
+}
+
+// Supported reports whether the server advertised support for client state
+// indication when the session was negotiated.
+func Supported(s *xmpp.Session) bool {
+	_, ok := s.Feature(NS)
+	return ok
+}
+
+// Active tells the server that the user is actively using the client.
+func Active(ctx context.Context, s *xmpp.Session) error {
+	return send(ctx, s, "active")
+}
+
+// Inactive tells the server that the user is not actively using the client.
+func Inactive(ctx context.Context, s *xmpp.Session) error {
+	return send(ctx, s, "inactive")
+}
+
+func send(ctx context.Context, s *xmpp.Session, local string) error {
+	return s.Send(ctx, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NS, Local: local}}))
+}
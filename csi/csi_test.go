@@ -0,0 +1,47 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package csi_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/csi"
+	"mellium.im/xmpp/internal/xmpptest"
+)
+
+func TestSend(t *testing.T) {
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(xmpp.Ready, &buf)
+
+	err := csi.Inactive(context.Background(), s)
+	if err != nil {
+		t.Fatalf("error sending inactive: %v", err)
+	}
+	err = csi.Active(context.Background(), s)
+	if err != nil {
+		t.Fatalf("error sending active: %v", err)
+	}
+	const expected = `<inactive xmlns="urn:xmpp:csi:0"></inactive><active xmlns="urn:xmpp:csi:0"></active>`
+	if out := buf.String(); out != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, out)
+	}
+}
+
+func TestFeature(t *testing.T) {
+	xmpptest.RunFeatureTests(t, []xmpptest.FeatureTestCase{
+		0: {
+			State:   xmpp.Authn,
+			Feature: csi.Feature(),
+		},
+		1: {
+			State:   xmpp.Authn | xmpp.Received,
+			Feature: csi.Feature(),
+			In:      `<active xmlns="urn:xmpp:csi:0"/>`,
+		},
+	})
+}
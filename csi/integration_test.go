@@ -0,0 +1,59 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//+build integration
+
+package csi_test
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/csi"
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/internal/integration/prosody"
+)
+
+func TestIntegrationCSI(t *testing.T) {
+	prosodyRun := prosody.Test(context.TODO(), t,
+		integration.Log(),
+		prosody.ListenC2S(),
+		prosody.CSI(),
+	)
+	prosodyRun(integrationCSI)
+}
+
+func integrationCSI(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
+	j, pass := cmd.User()
+	session, err := cmd.DialClient(ctx, j, t,
+		xmpp.StartTLS(&tls.Config{
+			InsecureSkipVerify: true,
+		}),
+		xmpp.SASL("", pass, sasl.Plain),
+		xmpp.BindResource(),
+	)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	go func() {
+		err := session.Serve(nil)
+		if err != nil {
+			t.Logf("error from serve: %v", err)
+		}
+	}()
+	if !csi.Supported(session) {
+		t.Fatalf("expected server to advertise client state indication")
+	}
+	err = csi.Inactive(ctx, session)
+	if err != nil {
+		t.Errorf("error sending inactive state: %v", err)
+	}
+	err = csi.Active(ctx, session)
+	if err != nil {
+		t.Errorf("error sending active state: %v", err)
+	}
+}
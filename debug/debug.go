@@ -0,0 +1,201 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package debug mirrors the traffic on a connection to a local socket.
+//
+// It is meant to be used during development so that external tools can watch
+// (and inject stanzas into) a live session without having to decrypt TLS or
+// add logging to the application.
+// Each tool that connects to the socket receives a copy of all data read from
+// and written to the connection as a stream of frames (see Frame for the
+// format).
+// Frames that are sent to the socket by a tool are sent over the session that
+// was set using Inject.
+//
+// Anyone who can connect to the socket can read and send anything on the
+// session, including passwords sent during authentication, so this package
+// should never be used in production.
+package debug // import "mellium.im/xmpp/debug"
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net"
+	"sync"
+	"time"
+
+	"mellium.im/xmpp"
+)
+
+// clientBuffer is the number of frames that may be queued for a tool that is
+// not keeping up before frames start being dropped.
+const clientBuffer = 64
+
+// Conn is a net.Conn that mirrors all traffic to tools connected to a socket.
+type Conn struct {
+	net.Conn
+
+	l         net.Listener
+	closeOnce sync.Once
+	mu        sync.Mutex
+	closed    bool
+	clients   map[net.Conn]chan Frame
+	session   *xmpp.Session
+}
+
+// Listen creates a Unix domain socket at path and returns a connection that
+// mirrors its traffic to any tool that connects to the socket.
+func Listen(conn net.Conn, path string) (*Conn, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, l), nil
+}
+
+// New returns a connection that mirrors its traffic to any tool that connects
+// to l.
+// The listener is closed when the connection is closed.
+func New(conn net.Conn, l net.Listener) *Conn {
+	c := &Conn{
+		Conn:    conn,
+		l:       l,
+		clients: make(map[net.Conn]chan Frame),
+	}
+	go c.accept()
+	return c
+}
+
+// Inject sets the session that frames sent to the socket by tools will be sent
+// over.
+// Injected stanzas are sent using the session's Send method, so they will never
+// be interleaved with other stanzas being written to the session.
+// Before Inject is called, frames sent by tools are dropped.
+func (c *Conn) Inject(s *xmpp.Session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = s
+}
+
+// Read reads data from the underlying connection and mirrors it.
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mirror(In, p[:n])
+	}
+	return n, err
+}
+
+// Write writes data to the underlying connection and mirrors it.
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.mirror(Out, p[:n])
+	}
+	return n, err
+}
+
+// Close closes the listener, disconnects all tools, and closes the underlying
+// connection.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		/* #nosec */
+		c.l.Close()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.closed = true
+		for client, frames := range c.clients {
+			close(frames)
+			delete(c.clients, client)
+		}
+	})
+	return c.Conn.Close()
+}
+
+func (c *Conn) mirror(dir Direction, p []byte) {
+	f := Frame{
+		Dir:  dir,
+		Time: time.Now(),
+		Data: append([]byte(nil), p...),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, frames := range c.clients {
+		select {
+		case frames <- f:
+		default:
+			// The tool is not keeping up, drop the frame instead of blocking the
+			// session.
+		}
+	}
+}
+
+func (c *Conn) accept() {
+	for {
+		client, err := c.l.Accept()
+		if err != nil {
+			return
+		}
+		frames := make(chan Frame, clientBuffer)
+		c.mu.Lock()
+		if c.closed {
+			// The tool was accepted while the connection was being closed.
+			c.mu.Unlock()
+			/* #nosec */
+			client.Close()
+			return
+		}
+		c.clients[client] = frames
+		c.mu.Unlock()
+		go c.writeFrames(client, frames)
+		go c.readFrames(client)
+	}
+}
+
+// writeFrames sends mirrored frames to a single tool.
+func (c *Conn) writeFrames(client net.Conn, frames chan Frame) {
+	/* #nosec */
+	defer client.Close()
+	for f := range frames {
+		if err := WriteFrame(client, f); err != nil {
+			c.drop(client)
+			return
+		}
+	}
+}
+
+// readFrames injects frames sent by a single tool into the session.
+func (c *Conn) readFrames(client net.Conn) {
+	for {
+		f, err := ReadFrame(client)
+		if err != nil {
+			c.drop(client)
+			return
+		}
+		if f.Dir != Out {
+			continue
+		}
+		c.mu.Lock()
+		s := c.session
+		c.mu.Unlock()
+		if s == nil {
+			c.mirror(Info, []byte("no session to inject into"))
+			continue
+		}
+		err = s.Send(context.Background(), xml.NewDecoder(bytes.NewReader(f.Data)))
+		if err != nil {
+			c.mirror(Info, []byte("error injecting stanza: "+err.Error()))
+		}
+	}
+}
+
+func (c *Conn) drop(client net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if frames, ok := c.clients[client]; ok {
+		close(frames)
+		delete(c.clients, client)
+	}
+}
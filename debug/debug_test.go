@@ -0,0 +1,124 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package debug_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/debug"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	var buf bytes.Buffer
+	err := debug.WriteFrame(&buf, debug.Frame{Dir: debug.Out, Time: now, Data: []byte("<presence/>")})
+	if err != nil {
+		t.Fatalf("error writing frame: %v", err)
+	}
+	f, err := debug.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("error reading frame: %v", err)
+	}
+	if f.Dir != debug.Out || !f.Time.Equal(now) || string(f.Data) != "<presence/>" {
+		t.Errorf("wrong frame: want=%v, %v, %q, got=%v, %v, %q", debug.Out, now, "<presence/>", f.Dir, f.Time, f.Data)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "debug.sock")
+	local, remote := net.Pipe()
+	conn, err := debug.Listen(local, path)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	defer conn.Close()
+
+	tool, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("error dialing debug socket: %v", err)
+	}
+	defer tool.Close()
+	// Give the connection time to accept the tool before generating traffic.
+	time.Sleep(50 * time.Millisecond)
+
+	go func() {
+		/* #nosec */
+		remote.Write([]byte("<iq/>"))
+	}()
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	if err != nil {
+		t.Fatalf("error reading from conn: %v", err)
+	}
+	go func() {
+		/* #nosec */
+		remote.Read(buf)
+	}()
+	_, err = conn.Write([]byte("<p/>"))
+	if err != nil {
+		t.Fatalf("error writing to conn: %v", err)
+	}
+
+	for _, want := range []debug.Frame{
+		{Dir: debug.In, Data: []byte("<iq/>")},
+		{Dir: debug.Out, Data: []byte("<p/>")},
+	} {
+		f, err := debug.ReadFrame(tool)
+		if err != nil {
+			t.Fatalf("error reading frame: %v", err)
+		}
+		if f.Dir != want.Dir || !bytes.Equal(f.Data, want.Data) {
+			t.Errorf("wrong frame: want=%v %q, got=%v %q", want.Dir, want.Data, f.Dir, f.Data)
+		}
+	}
+}
+
+var errClosed = errors.New("listener closed")
+
+// lateListener is a listener that only returns a connection from Accept after
+// it has been closed.
+type lateListener struct {
+	net.Listener
+	closed chan struct{}
+	conn   net.Conn
+}
+
+func (l *lateListener) Accept() (net.Conn, error) {
+	<-l.closed
+	if l.conn == nil {
+		return nil, errClosed
+	}
+	conn := l.conn
+	l.conn = nil
+	return conn, nil
+}
+
+func (l *lateListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func TestAcceptAfterClose(t *testing.T) {
+	local, _ := net.Pipe()
+	client, tool := net.Pipe()
+	l := &lateListener{closed: make(chan struct{}), conn: client}
+	conn := debug.New(local, l)
+	/* #nosec */
+	conn.Close()
+
+	// If the late connection was not closed the read would block forever.
+	/* #nosec */
+	tool.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := tool.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("expected connection accepted after close to be closed, got err=%v", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MaxFrameSize is the largest payload that will be accepted by ReadFrame.
+const MaxFrameSize = 1 << 20
+
+const headerLen = 1 + 8 + 4
+
+// ErrFrameTooLarge is returned by ReadFrame if a frame is larger than
+// MaxFrameSize.
+var ErrFrameTooLarge = errors.New("debug: frame too large")
+
+// Direction indicates which way the data in a frame was flowing.
+type Direction byte
+
+// A list of directions.
+const (
+	// In is data that was read from the underlying connection.
+	In Direction = '<'
+
+	// Out is data that was written to the underlying connection.
+	// Frames sent to the debug socket by tools must use this direction and will
+	// be sent over the session.
+	Out Direction = '>'
+
+	// Info is an annotation that was added by the debug connection itself, such
+	// as an error injecting a stanza.
+	Info Direction = '#'
+)
+
+// String satisfies the fmt.Stringer interface.
+func (d Direction) String() string {
+	switch d {
+	case In:
+		return "in"
+	case Out:
+		return "out"
+	case Info:
+		return "info"
+	}
+	return fmt.Sprintf("Direction(%d)", byte(d))
+}
+
+// Frame is a unit of data sent to or received from a debug socket.
+//
+// On the wire a frame is encoded as the direction (1 byte), the time as Unix
+// nanoseconds (8 bytes, big endian), the length of the data (4 bytes, big
+// endian), and then the data itself.
+type Frame struct {
+	Dir  Direction
+	Time time.Time
+	Data []byte
+}
+
+// WriteFrame writes f to w.
+func WriteFrame(w io.Writer, f Frame) error {
+	buf := make([]byte, headerLen+len(f.Data))
+	buf[0] = byte(f.Dir)
+	binary.BigEndian.PutUint64(buf[1:9], uint64(f.Time.UnixNano()))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(f.Data)))
+	copy(buf[headerLen:], f.Data)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads a single frame from r.
+func ReadFrame(r io.Reader) (Frame, error) {
+	var header [headerLen]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return Frame{}, err
+	}
+	f := Frame{
+		Dir:  Direction(header[0]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9]))),
+	}
+	n := binary.BigEndian.Uint32(header[9:13])
+	if n > MaxFrameSize {
+		return f, ErrFrameTooLarge
+	}
+	f.Data = make([]byte, n)
+	_, err = io.ReadFull(r, f.Data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return f, err
+}
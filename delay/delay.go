@@ -8,6 +8,7 @@ package delay // import "mellium.im/xmpp/delay"
 import (
 	"encoding/xml"
 	"fmt"
+	"io"
 	"time"
 
 	"mellium.im/xmlstream"
@@ -125,3 +126,67 @@ func Insert(d Delay) xmlstream.Transformer {
 		return err
 	})
 }
+
+// Stamp inserts a delay with the current time into any stanza read through the
+// stream.
+// Unlike Stanza, the time is recorded separately for each stanza when it is
+// read.
+// It is meant to be used by servers and components that store stanzas for
+// later delivery, such as offline messages.
+func Stamp(from jid.JID, reason string) xmlstream.Transformer {
+	return xmlstream.InsertFunc(func(start xml.StartElement, level uint64, w xmlstream.TokenWriter) error {
+		if !stanza.Is(start.Name) || level != 1 {
+			return nil
+		}
+
+		_, err := xmlstream.Copy(w, Delay{
+			From:   from,
+			Time:   time.Now().UTC(),
+			Reason: reason,
+		}.TokenReader())
+		return err
+	})
+}
+
+// Unmarshal reads a stanza from r and returns any delays that are direct
+// children of it in the order they appear.
+// Since each entity that delays a stanza may add its own delay, there may be
+// more than one.
+func Unmarshal(r xml.TokenReader) ([]Delay, error) {
+	d := xml.NewTokenDecoder(r)
+	var delays []Delay
+	var level int
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return delays, nil
+		}
+		if err != nil {
+			return delays, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if level == 1 && t.Name.Space == NS && t.Name.Local == "delay" {
+				var delay Delay
+				err = d.DecodeElement(&delay, &t)
+				if err != nil {
+					return delays, err
+				}
+				delays = append(delays, delay)
+				continue
+			}
+			level++
+		case xml.EndElement:
+			level--
+			if level == 0 {
+				return delays, nil
+			}
+		}
+	}
+}
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "delay"}, func() interface{} {
+		return &Delay{}
+	})
+}
@@ -14,6 +14,7 @@ import (
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/delay"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
 )
 
 var (
@@ -151,3 +152,68 @@ func TestMarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestStamp(t *testing.T) {
+	from := jid.MustParse("example.net")
+	before := time.Now().Add(-time.Second)
+	r := delay.Stamp(from, "Offline Storage")(xml.NewDecoder(strings.NewReader(`<message xmlns="jabber:client"><body>test</body></message><foo/>`)))
+	delays, err := delay.Unmarshal(r)
+	if err != nil {
+		t.Fatalf("error unmarshaling delay: %v", err)
+	}
+	if len(delays) != 1 {
+		t.Fatalf("wrong number of delays: want=1, got=%d", len(delays))
+	}
+	d := delays[0]
+	if !d.From.Equal(from) || d.Reason != "Offline Storage" {
+		t.Errorf("wrong delay: %+v", d)
+	}
+	if d.Time.Before(before) || d.Time.After(time.Now().Add(time.Second)) {
+		t.Errorf("wrong delay time: %v", d.Time)
+	}
+	tok, err := r.Token()
+	if err != nil {
+		t.Fatalf("error reading next element: %v", err)
+	}
+	if start, ok := tok.(xml.StartElement); !ok || start.Name.Local != "foo" {
+		t.Errorf("unexpected token after stanza: %#v", tok)
+	}
+	tok, _ = r.Token()
+	if tok, ok := tok.(xml.EndElement); !ok || tok.Name.Local != "foo" {
+		t.Errorf("expected non-stanza not to be stamped, got %#v", tok)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	const in = `<message xmlns="jabber:client"><delay xmlns="urn:xmpp:delay" from="a.example.net" stamp="2002-09-10T23:08:25Z"/><x><delay xmlns="urn:xmpp:delay" from="nested.example.net" stamp="2002-09-10T23:08:25Z"/></x><delay xmlns="urn:xmpp:delay" from="b.example.net" stamp="2002-09-10T23:41:07Z">Resent</delay></message>`
+	delays, err := delay.Unmarshal(xml.NewDecoder(strings.NewReader(in)))
+	if err != nil {
+		t.Fatalf("error unmarshaling delays: %v", err)
+	}
+	if len(delays) != 2 {
+		t.Fatalf("wrong number of delays: want=2, got=%d", len(delays))
+	}
+	if s := delays[0].From.String(); s != "a.example.net" {
+		t.Errorf("wrong first delay: %+v", delays[0])
+	}
+	if s := delays[1].From.String(); s != "b.example.net" || delays[1].Reason != "Resent" {
+		t.Errorf("wrong second delay: %+v", delays[1])
+	}
+	want := time.Date(2002, 9, 10, 23, 41, 7, 0, time.UTC)
+	if !delays[1].Time.Equal(want) {
+		t.Errorf("wrong time: want=%v, got=%v", want, delays[1].Time)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(`<message xmlns="jabber:client"><delay xmlns="urn:xmpp:delay" stamp="2002-09-10T23:08:25Z"/></message>`)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("wrong number of payloads: want=1, got=%d", len(payloads))
+	}
+	if _, ok := payloads[0].Value.(*delay.Delay); !ok {
+		t.Errorf("expected delay to be registered, got %+v", payloads[0])
+	}
+}
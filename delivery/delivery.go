@@ -0,0 +1,266 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=Status -linecomment
+
+// Package delivery tracks the delivery status of sent messages.
+//
+// Several different mechanisms can tell a client what happened to a message
+// after it was sent: stream management acknowledgements tell it that the server
+// received the message, message delivery receipts and multi-user chat
+// reflections tell it that the message reached its recipient, and chat markers
+// tell it that the message was displayed to the user.
+// The Tracker in this package combines all of these into a single status for
+// each message that only ever moves forward:
+//
+//     Queued → Sent → Delivered → Displayed
+//
+// Not every status is reached for every message.
+// For example, if the recipient does not support chat markers a message may
+// never be marked as displayed, and if it is delivered before the server
+// acknowledges it the Sent status is skipped.
+package delivery // import "mellium.im/xmpp/delivery"
+
+import (
+	"context"
+	"encoding/xml"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/markers"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/receipts"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSMarkers is the namespace used by chat markers.
+const NSMarkers = markers.NS
+
+// Status is the delivery status of a message.
+type Status uint8
+
+// A list of delivery statuses in the order in which they are reached.
+const (
+	// Queued messages have been handed to the Tracker but have not been
+	// acknowledged by the server.
+	Queued Status = iota // queued
+
+	// Sent messages have been acknowledged by the server.
+	// If stream management is not being used, messages are considered sent as
+	// soon as they have been written to the session.
+	Sent // sent
+
+	// Delivered messages have been acknowledged by the recipient using a message
+	// delivery receipt or, for multi-user chat messages, have been reflected back
+	// to the sender by the room.
+	Delivered // delivered
+
+	// Displayed messages have been shown to the recipient.
+	Displayed // displayed
+)
+
+// Tracker keeps track of the delivery status of sent messages.
+// To receive updates from incoming receipts, markers, and reflections it must
+// be registered on the session's mux using Handle.
+//
+// The zero value is a Tracker that is ready to use.
+// A Tracker is safe for concurrent use by multiple goroutines.
+type Tracker struct {
+	// Out is the counter used by stream management to count outgoing stanzas.
+	// If it is set, messages are only considered sent once an acknowledgement
+	// that covers them is passed to Ack.
+	// If it is nil, messages are considered sent as soon as they are written to
+	// the session.
+	Out *stanza.Counter
+
+	// Update, if set, is called every time the status of a message changes.
+	// It is called from the goroutine that caused the change and should not
+	// block.
+	Update func(id string, status Status)
+
+	mu   sync.Mutex
+	msgs map[string]*tracked
+}
+
+type tracked struct {
+	to     jid.JID
+	status Status
+	seq    uint32
+	queued bool
+}
+
+// SendMessageElement sends a message with the provided payload and starts
+// tracking its delivery status.
+// If the message does not have an ID one is generated.
+// Unless the message is a groupchat message (where reflection is used instead)
+// a request for a message delivery receipt is added to the payload, and chat
+// messages are also marked as markable so that the recipient can send chat
+// markers.
+//
+// SendMessageElement returns the ID of the message once it has been written to
+// the session.
+// It does not wait for the message to be acknowledged.
+func (t *Tracker) SendMessageElement(ctx context.Context, s *xmpp.Session, payload xml.TokenReader, msg stanza.Message) (string, error) {
+	if msg.ID == "" {
+		msg.ID = attr.RandomID()
+	}
+
+	var extra []xml.TokenReader
+	if payload != nil {
+		extra = append(extra, payload)
+	}
+	if msg.Type != stanza.GroupChatMessage {
+		extra = append(extra, receipts.Requested{Value: true}.TokenReader())
+	}
+	if msg.Type == stanza.ChatMessage || msg.Type == stanza.GroupChatMessage {
+		extra = append(extra, markers.Markable{}.TokenReader())
+	}
+
+	t.mu.Lock()
+	if t.msgs == nil {
+		t.msgs = make(map[string]*tracked)
+	}
+	t.msgs[msg.ID] = &tracked{to: msg.To, status: Queued}
+	t.mu.Unlock()
+	t.notify(msg.ID, Queued)
+
+	err := s.SendElement(ctx, xmlstream.MultiReader(extra...), msg.StartElement())
+	if err != nil {
+		t.Forget(msg.ID)
+		return msg.ID, err
+	}
+
+	// The counter may have been incremented by other stanzas written after the
+	// message, but this means that the message may be marked as sent later than
+	// it could have been, never earlier.
+	if t.Out != nil {
+		seq := t.Out.Load()
+		t.mu.Lock()
+		if m, ok := t.msgs[msg.ID]; ok {
+			m.seq = seq
+			m.queued = true
+		}
+		t.mu.Unlock()
+		return msg.ID, nil
+	}
+	t.set(msg.ID, jid.JID{}, Sent, false)
+	return msg.ID, nil
+}
+
+// Ack marks all messages covered by a stream management acknowledgement as
+// sent.
+// The argument h is the number of stanzas that the server has acknowledged
+// handling.
+func (t *Tracker) Ack(h uint32) {
+	var acked []string
+	t.mu.Lock()
+	for id, m := range t.msgs {
+		// Sequence numbers wrap, so compare them using serial number arithmetic.
+		if m.queued && m.status == Queued && int32(h-m.seq) >= 0 {
+			acked = append(acked, id)
+		}
+	}
+	t.mu.Unlock()
+	for _, id := range acked {
+		t.set(id, jid.JID{}, Sent, false)
+	}
+}
+
+// Status returns the current delivery status of a message.
+// If the message is not being tracked, ok will be false.
+func (t *Tracker) Status(id string) (status Status, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.msgs[id]
+	if !ok {
+		return 0, false
+	}
+	return m.status, true
+}
+
+// Forget stops tracking the message with the given ID.
+// Messages are forgotten automatically once they are displayed, but messages
+// sent to entities that do not support receipts or markers must be forgotten
+// manually.
+func (t *Tracker) Forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.msgs, id)
+}
+
+// set moves a message forward to status.
+// If checkFrom is true, the status is only changed if from has the same bare
+// JID as the original recipient of the message.
+func (t *Tracker) set(id string, from jid.JID, status Status, checkFrom bool) {
+	t.mu.Lock()
+	m, ok := t.msgs[id]
+	if !ok || m.status >= status || (checkFrom && !from.Bare().Equal(m.to.Bare())) {
+		t.mu.Unlock()
+		return
+	}
+	m.status = status
+	if status == Displayed {
+		delete(t.msgs, id)
+	}
+	t.mu.Unlock()
+	t.notify(id, status)
+}
+
+func (t *Tracker) notify(id string, status Status) {
+	if t.Update != nil {
+		t.Update(id, status)
+	}
+}
+
+// Handle returns an option that registers a Tracker for incoming message
+// delivery receipts, chat markers, and multi-user chat reflections.
+//
+// Because it handles message delivery receipts and displayed markers it cannot
+// be registered on the same mux as receipts.Handle or markers.Handle.
+// To respond to requests for receipts sent by others, register the receipts
+// Handler for the "request" payload only.
+func Handle(t *Tracker) mux.Option {
+	return func(m *mux.ServeMux) {
+		received := xml.Name{Space: receipts.NS, Local: "received"}
+		displayed := xml.Name{Space: NSMarkers, Local: "displayed"}
+
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage, stanza.HeadlineMessage} {
+			mux.Message(typ, received, t)(m)
+		}
+		mux.Message(stanza.NormalMessage, displayed, t)(m)
+		mux.Message(stanza.ChatMessage, displayed, t)(m)
+		mux.Message(stanza.GroupChatMessage, displayed, t)(m)
+		mux.Message(stanza.GroupChatMessage, xml.Name{Local: "body"}, t)(m)
+	}
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (t *Tracker) HandleMessage(msg stanza.Message, r xmlstream.TokenReadEncoder) error {
+	// Pop the start message token.
+	_, err := r.Token()
+	if err != nil {
+		return err
+	}
+
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, _ := iter.Current()
+		switch {
+		case start.Name.Local == "received" && start.Name.Space == receipts.NS:
+			_, id := attr.Get(start.Attr, "id")
+			t.set(id, msg.From, Delivered, true)
+		case start.Name.Local == "displayed" && start.Name.Space == NSMarkers:
+			_, id := attr.Get(start.Attr, "id")
+			t.set(id, msg.From, Displayed, true)
+		case start.Name.Local == "body" && msg.Type == stanza.GroupChatMessage:
+			t.set(msg.ID, msg.From, Delivered, true)
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package delivery_test
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/delivery"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/receipts"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	juliet = jid.MustParse("juliet@capulet.lit/balcony")
+	coven  = jid.MustParse("coven@chat.shakespeare.lit")
+)
+
+func waitFor(ctx context.Context, t *testing.T, updates <-chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-updates:
+			if got != w {
+				t.Fatalf("wrong update: want=%q, got=%q", w, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for update %q", w)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates := make(chan string, 10)
+	var out stanza.Counter
+	tracker := &delivery.Tracker{
+		Out: &out,
+		Update: func(id string, status delivery.Status) {
+			updates <- fmt.Sprintf("%s:%s", id, status)
+		},
+	}
+	serverMux := mux.New(
+		mux.MessageFunc(stanza.ChatMessage, xml.Name{Space: receipts.NS, Local: "request"}, func(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+			_, err := xmlstream.Copy(t, xml.NewDecoder(strings.NewReader(fmt.Sprintf(
+				`<message xmlns="jabber:client" from="%s" type="chat"><received xmlns="urn:xmpp:receipts" id="%s"/></message><message from="%s" type="chat"><displayed xmlns="urn:xmpp:chat-markers:0" id="%s"/></message>`,
+				juliet, msg.ID, juliet, msg.ID,
+			))))
+			return err
+		}),
+		mux.MessageFunc(stanza.GroupChatMessage, xml.Name{Local: "body"}, func(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+			_, err := xmlstream.Copy(t, xml.NewDecoder(strings.NewReader(fmt.Sprintf(
+				`<message xmlns="jabber:client" from="%s/thirdwitch" id="%s" type="groupchat"><body>Thrice the brinded cat hath mew'd.</body></message>`,
+				coven, msg.ID,
+			))))
+			return err
+		}),
+	)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(serverMux),
+		xmpptest.ClientHandler(mux.New(delivery.Handle(tracker))),
+	)
+	defer cs.Close()
+
+	id, err := tracker.SendMessageElement(ctx, cs.Client, xmlstream.Wrap(
+		xmlstream.Token(xml.CharData("Thrice the brinded cat hath mew'd.")),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	), stanza.Message{To: coven, Type: stanza.GroupChatMessage})
+	if err != nil {
+		t.Fatalf("error sending groupchat message: %v", err)
+	}
+	if status, ok := tracker.Status(id); !ok || status > delivery.Delivered {
+		t.Errorf("expected message to be tracked, got status=%v, ok=%t", status, ok)
+	}
+	waitFor(ctx, t, updates, id+":queued", id+":delivered")
+	tracker.Forget(id)
+
+	id, err = tracker.SendMessageElement(ctx, cs.Client, nil, stanza.Message{
+		ID:   "chat1",
+		To:   juliet.Bare(),
+		Type: stanza.ChatMessage,
+	})
+	if err != nil {
+		t.Fatalf("error sending chat message: %v", err)
+	}
+	if id != "chat1" {
+		t.Errorf("existing message ID was not used: want=chat1, got=%s", id)
+	}
+	waitFor(ctx, t, updates, "chat1:queued", "chat1:delivered", "chat1:displayed")
+	if _, ok := tracker.Status(id); ok {
+		t.Errorf("expected displayed message to be forgotten")
+	}
+}
+
+func TestAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates := make(chan string, 10)
+	var out stanza.Counter
+	tracker := &delivery.Tracker{
+		Out: &out,
+		Update: func(id string, status delivery.Status) {
+			updates <- fmt.Sprintf("%s:%s", id, status)
+		},
+	}
+	cs := xmpptest.NewClientServer()
+	defer cs.Close()
+
+	// Pretend that the stream was resumed close to the point where sequence
+	// numbers wrap and that the counter is incremented as each message is
+	// written.
+	var start uint32 = 1<<32 - 1
+	out.Store(start)
+	for i := 0; i < 2; i++ {
+		out.Store(start + uint32(i) + 1)
+		_, err := tracker.SendMessageElement(ctx, cs.Client, nil, stanza.Message{
+			ID: fmt.Sprintf("msg%d", i),
+			To: juliet,
+		})
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+	waitFor(ctx, t, updates, "msg0:queued", "msg1:queued")
+
+	tracker.Ack(start)
+	select {
+	case u := <-updates:
+		t.Fatalf("no messages should be covered by the ack, got %q", u)
+	default:
+	}
+	tracker.Ack(start + 1)
+	waitFor(ctx, t, updates, "msg0:sent")
+	tracker.Ack(start + 2)
+	waitFor(ctx, t, updates, "msg1:sent")
+	if status, _ := tracker.Status("msg1"); status != delivery.Sent {
+		t.Errorf("wrong status: want=%v, got=%v", delivery.Sent, status)
+	}
+}
+
+func TestIgnoreOthers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tracker := &delivery.Tracker{}
+	cs := xmpptest.NewClientServer()
+	defer cs.Close()
+	m := mux.New(delivery.Handle(tracker))
+
+	_, err := tracker.SendMessageElement(ctx, cs.Client, nil, stanza.Message{
+		ID:   "groupchat1",
+		To:   coven,
+		Type: stanza.GroupChatMessage,
+	})
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+
+	for _, raw := range []string{
+		`<message xmlns="jabber:client" from="wrong@chat.shakespeare.lit/thirdwitch" id="groupchat1" type="groupchat"><body>spoof</body></message>`,
+		`<message xmlns="jabber:client" from="coven@chat.shakespeare.lit/firstwitch" id="other" type="groupchat"><body>other</body></message>`,
+		`<message xmlns="jabber:client" from="coven@chat.shakespeare.lit/firstwitch" id="groupchat1" type="groupchat"><subject>not a reflection</subject></message>`,
+		`<message xmlns="jabber:client" from="juliet@capulet.lit/balcony" type="chat"><displayed xmlns="urn:xmpp:chat-markers:0" id="groupchat1"/></message>`,
+	} {
+		d := xml.NewDecoder(strings.NewReader(raw))
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatalf("error popping start token: %v", err)
+		}
+		start := tok.(xml.StartElement)
+		err = m.HandleXMPP(struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: d,
+			Encoder:     xml.NewEncoder(ioutil.Discard),
+		}, &start)
+		if err != nil {
+			t.Fatalf("error handling %s: %v", raw, err)
+		}
+	}
+	if status, _ := tracker.Status("groupchat1"); status != delivery.Sent {
+		t.Errorf("message status was changed by another entity: want=%v, got=%v", delivery.Sent, status)
+	}
+}
@@ -10,10 +10,12 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"runtime/pprof"
 	"strconv"
 	"sync"
 
 	"mellium.im/xmpp/internal/discover"
+	"mellium.im/xmpp/internal/labels"
 	"mellium.im/xmpp/jid"
 )
 
@@ -101,27 +103,27 @@ func (d *Dialer) dial(ctx context.Context, network string, addr jid.JID) (net.Co
 		wg.Add(1)
 		if !d.NoTLS {
 			wg.Add(1)
-			go func() {
+			xmppsService := connType(true, d.S2S)
+			go pprof.Do(ctx, pprof.Labels(labels.Task, "lookup "+xmppsService, labels.Domain, domain), func(ctx context.Context) {
 				// Lookup xmpps-(client|server)
 				defer wg.Done()
-				xmppsService := connType(true, d.S2S)
 				addrs, err := discover.LookupService(ctx, d.Resolver, xmppsService, addr)
 				if err != nil {
 					xmppsErr = err
 				}
 				xmppsAddrs = addrs
-			}()
+			})
 		}
-		go func() {
+		xmppService := connType(false, d.S2S)
+		go pprof.Do(ctx, pprof.Labels(labels.Task, "lookup "+xmppService, labels.Domain, domain), func(ctx context.Context) {
 			// Lookup xmpp-(client|server)
 			defer wg.Done()
-			xmppService := connType(false, d.S2S)
 			addrs, err := discover.LookupService(ctx, d.Resolver, xmppService, addr)
 			if err != nil {
 				xmppErr = err
 			}
 			xmppAddrs = addrs
-		}()
+		})
 		wg.Wait()
 
 		// If both lookups failed, return one of the errors.
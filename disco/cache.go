@@ -0,0 +1,87 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package disco
+
+import (
+	"context"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/lru"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// CacheStats contains metrics about an InfoCache.
+type CacheStats struct {
+	// Len is the number of responses currently cached and Cap is the maximum
+	// number that will be kept before the least recently used are evicted.
+	Len, Cap int
+
+	// Hits and Misses count lookups that were and were not answered from the
+	// cache.
+	Hits, Misses uint64
+
+	// Evictions counts responses that were dropped to make room for new ones.
+	Evictions uint64
+}
+
+type infoKey struct {
+	jid  string
+	node string
+}
+
+// InfoCache remembers the responses to disco info queries.
+// It holds a fixed number of responses and evicts the least recently used when
+// it is full, so it may be used by services that talk to a large number of
+// entities without growing without bound.
+//
+// An InfoCache is safe for concurrent use.
+type InfoCache struct {
+	c *lru.Cache
+}
+
+// NewInfoCache creates a cache that holds at most size responses.
+// If size is less than 1 nothing is cached.
+func NewInfoCache(size int) *InfoCache {
+	return &InfoCache{c: lru.New(size)}
+}
+
+// GetInfo is like the package level GetInfo function except that cached
+// responses are returned if they exist and new responses are cached.
+func (c *InfoCache) GetInfo(ctx context.Context, node string, to jid.JID, s *xmpp.Session) (Info, error) {
+	return c.GetInfoIQ(ctx, node, stanza.IQ{To: to}, s)
+}
+
+// GetInfoIQ is like GetInfo but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func (c *InfoCache) GetInfoIQ(ctx context.Context, node string, iq stanza.IQ, s *xmpp.Session) (Info, error) {
+	key := infoKey{jid: iq.To.String(), node: node}
+	if v, ok := c.c.Get(key); ok {
+		return v.(Info), nil
+	}
+	info, err := GetInfoIQ(ctx, node, iq, s)
+	if err != nil {
+		return info, err
+	}
+	c.c.Add(key, info)
+	return info, nil
+}
+
+// Forget removes any cached response for the given JID and node.
+func (c *InfoCache) Forget(node string, to jid.JID) {
+	c.c.Remove(infoKey{jid: to.String(), node: node})
+}
+
+// Stats returns the current metrics for the cache.
+func (c *InfoCache) Stats() CacheStats {
+	s := c.c.Stats()
+	return CacheStats{
+		Len:       s.Len,
+		Cap:       s.Cap,
+		Hits:      s.Hits,
+		Misses:    s.Misses,
+		Evictions: s.Evictions,
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package disco_test
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestInfoCache(t *testing.T) {
+	var queries int
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			queries++
+			iq, err := stanza.NewIQ(*start)
+			if err != nil {
+				return err
+			}
+			return e.Encode(struct {
+				stanza.IQ
+				Info disco.Info
+			}{
+				IQ: stanza.IQ{ID: iq.ID, Type: stanza.ResultIQ},
+				Info: disco.Info{
+					Features: []disco.Feature{{Var: "urn:example"}},
+				},
+			})
+		}),
+	)
+	defer cs.Close()
+
+	cache := disco.NewInfoCache(1)
+	a := jid.MustParse("a@example.net")
+	b := jid.MustParse("b@example.net")
+	for _, to := range []jid.JID{a, a, b, a} {
+		info, err := cache.GetInfo(context.Background(), "", to, cs.Client)
+		if err != nil {
+			t.Fatalf("error querying %v: %v", to, err)
+		}
+		if len(info.Features) != 1 || info.Features[0].Var != "urn:example" {
+			t.Fatalf("wrong features: %+v", info.Features)
+		}
+	}
+	if queries != 3 {
+		t.Errorf("wrong number of queries sent: want=3, got=%d", queries)
+	}
+	want := disco.CacheStats{Len: 1, Cap: 1, Hits: 1, Misses: 3, Evictions: 2}
+	if s := cache.Stats(); s != want {
+		t.Errorf("wrong stats: want=%+v, got=%+v", want, s)
+	}
+
+	cache.Forget("", a)
+	if s := cache.Stats(); s.Len != 0 {
+		t.Errorf("expected cache to be empty after forgetting entry, got %d", s.Len)
+	}
+}
@@ -7,8 +7,12 @@
 // Package disco implements service discovery.
 package disco // import "mellium.im/xmpp/disco"
 
+import (
+	"mellium.im/xmpp/ns"
+)
+
 // Namespaces used by this package.
 const (
-	NSInfo  = `http://jabber.org/protocol/disco#info`
-	NSItems = `http://jabber.org/protocol/disco#items`
+	NSInfo  = ns.DiscoInfo
+	NSItems = ns.DiscoItems
 )
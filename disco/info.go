@@ -11,8 +11,8 @@ import (
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/form"
-	"mellium.im/xmpp/internal/ns"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/ns"
 	"mellium.im/xmpp/stanza"
 )
 
@@ -53,7 +53,7 @@ type Identity struct {
 // TokenReader implements xmlstream.Marshaler.
 func (i Identity) TokenReader() xml.TokenReader {
 	start := xml.StartElement{
-		Name: xml.Name{Space: NSInfo, Local: "query"},
+		Name: xml.Name{Space: NSInfo, Local: "identity"},
 		Attr: []xml.Attr{{
 			Name:  xml.Name{Local: "category"},
 			Value: i.Category,
@@ -97,6 +97,16 @@ func (f Feature) TokenReader() xml.TokenReader {
 	})
 }
 
+// String returns the feature namespace followed by the specification that
+// defines it, if known.
+// It is meant to be used when logging the features advertised by an entity.
+func (f Feature) String() string {
+	if spec, ok := ns.Lookup(f.Var); ok {
+		return f.Var + " (" + spec.String() + ")"
+	}
+	return f.Var
+}
+
 // WriteXML implements xmlstream.WriterTo.
 func (f Feature) WriteXML(w xmlstream.TokenWriter) (int, error) {
 	return xmlstream.Copy(w, f.TokenReader())
@@ -105,9 +115,9 @@ func (f Feature) WriteXML(w xmlstream.TokenWriter) (int, error) {
 // Info is a response to a disco info query.
 type Info struct {
 	InfoQuery
-	Identity []Identity `xml:"identity"`
-	Features []Feature  `xml:"feature"`
-	Form     *form.Data `xml:"jabber:x:data x,omitempty"`
+	Identity []Identity   `xml:"identity"`
+	Features []Feature    `xml:"feature"`
+	Form     []*form.Data `xml:"jabber:x:data x,omitempty"`
 }
 
 // TokenReader implements xmlstream.Marshaler.
@@ -119,6 +129,9 @@ func (i Info) TokenReader() xml.TokenReader {
 	for _, ident := range i.Identity {
 		payloads = append(payloads, ident.TokenReader())
 	}
+	for _, f := range i.Form {
+		payloads = append(payloads, f.TokenReader())
+	}
 	return i.InfoQuery.wrap(xmlstream.MultiReader(payloads...))
 }
 
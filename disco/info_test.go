@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
 )
 
 func TestMarshalQuery(t *testing.T) {
@@ -91,14 +92,65 @@ func TestUnmarshal(t *testing.T) {
 	if v := info.Features[0].Var; v != disco.NSInfo {
 		t.Errorf("wrong first feature: want=%s, got=%s", disco.NSInfo, v)
 	}
-	if info.Form == nil {
-		t.Errorf("form was not unmarshaled")
+	if len(info.Form) != 1 {
+		t.Fatalf("wrong number of forms: want=1, got=%d", len(info.Form))
 	}
 	const serverInfo = "http://jabber.org/network/serverinfo"
-	if s, ok := info.Form.GetString("FORM_TYPE"); !ok || s != serverInfo {
+	if s, ok := info.Form[0].GetString("FORM_TYPE"); !ok || s != serverInfo {
 		t.Errorf("wrong value for FORM_TYPE: want=%s, got=%s", serverInfo, s)
 	}
-	if s, ok := info.Form.GetString("c2s_port"); !ok || s != "5222" {
+	if s, ok := info.Form[0].GetString("c2s_port"); !ok || s != "5222" {
 		t.Errorf("wrong value for FORM_TYPE: want=5222, got=%s", s)
 	}
 }
+
+func TestMarshalInfo(t *testing.T) {
+	info := disco.Info{
+		InfoQuery: disco.InfoQuery{Node: "test"},
+		Identity:  []disco.Identity{{Category: "client", Type: "pc", Name: "Exodus 0.9.1"}},
+		Features:  []disco.Feature{{Var: disco.NSInfo}},
+		Form: []*form.Data{
+			form.New(form.FormType("urn:xmpp:dataforms:softwareinfo")),
+			form.New(form.FormType("urn:xmpp:dataforms:other")),
+		},
+	}
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := info.WriteXML(e)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling info: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("unexpected error flushing: %v", err)
+	}
+
+	var decoded disco.Info
+	err = xml.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error unmarshaling: %v", err)
+	}
+	if len(decoded.Identity) != 1 || decoded.Identity[0].Name != info.Identity[0].Name {
+		t.Errorf("wrong identities: want=%+v, got=%+v", info.Identity, decoded.Identity)
+	}
+	if len(decoded.Features) != 1 || decoded.Features[0].Var != disco.NSInfo {
+		t.Errorf("wrong features: want=%+v, got=%+v", info.Features, decoded.Features)
+	}
+	if len(decoded.Form) != 2 || decoded.Form[0].FormType() != "urn:xmpp:dataforms:softwareinfo" || decoded.Form[1].FormType() != "urn:xmpp:dataforms:other" {
+		t.Errorf("form did not round trip: %s", buf.String())
+	}
+}
+
+func TestFeatureString(t *testing.T) {
+	for _, tc := range []struct {
+		f    disco.Feature
+		want string
+	}{
+		{f: disco.Feature{Var: "urn:xmpp:ping"}, want: "urn:xmpp:ping (XEP-0199: XMPP Ping)"},
+		{f: disco.Feature{Var: "urn:example"}, want: "urn:example"},
+	} {
+		if got := tc.f.String(); got != tc.want {
+			t.Errorf("wrong string: want=%q, got=%q", tc.want, got)
+		}
+	}
+}
@@ -28,11 +28,17 @@ type ItemsQuery struct {
 
 // TokenReader implements xmlstream.Marshaler.
 func (q ItemsQuery) TokenReader() xml.TokenReader {
-	start := xml.StartElement{Name: xml.Name{Space: NSItems, Local: "query"}}
-	if q.Node != "" {
-		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "node"}, Value: q.Node})
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSItems, Local: "query"},
+		Attr: queryAttrs(q.Node),
+	})
+}
+
+func queryAttrs(node string) []xml.Attr {
+	if node == "" {
+		return nil
 	}
-	return xmlstream.Wrap(nil, start)
+	return []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}}
 }
 
 // WriteXML implements xmlstream.WriterTo.
@@ -72,78 +78,15 @@ func (i Item) WriteXML(w xmlstream.TokenWriter) (int, error) {
 }
 
 // ItemIter is an iterator over discovered items.
-// It supports paging
+// It supports paging.
 type ItemIter struct {
-	max     uint64
-	iter    *paging.Iter
-	current Item
-	err     error
-	ctx     context.Context
-	session *xmpp.Session
-}
-
-// Next returns true if there are more items to decode.
-func (i *ItemIter) Next() bool {
-	if i.err != nil {
-		return false
-	}
-	next := i.iter.Next()
-	nextPage := i.iter.NextPage()
-	if !next && nextPage == nil {
-		return false
-	}
-	// If there is a next element and we don't need to turn the page, just decode
-	// is like normal.
-	if next {
-		start, r := i.iter.Current()
-		// If we encounter a lone token that doesn't begin with a start element (eg.
-		// a comment) skip it. This should never happen with XMPP, but we don't want
-		// to panic in case this somehow happens so just skip it.
-		if start == nil {
-			return i.Next()
-		}
-		d := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r))
-		item := Item{}
-		i.err = d.Decode(&item)
-		if i.err != nil {
-			return false
-		}
-		i.current = item
-		return true
-	}
-
-	// Turn the page.
-	i.err = i.iter.Close()
-	if i.err != nil {
-		return false
-	}
-	// TODO: set context based on a deadline?
-	i.iter = FetchItems(i.ctx, i.current, i.session).iter
-	return i.Next()
-}
-
-// Err returns the last error encountered by the iterator (if any).
-func (i *ItemIter) Err() error {
-	if i.err != nil {
-		return i.err
-	}
-
-	return i.iter.Err()
+	*paging.ResultIter
 }
 
-// Item returns the last roster item parsed by the iterator.
+// Item returns the last item parsed by the iterator.
 func (i *ItemIter) Item() Item {
-	return i.current
-}
-
-// Close indicates that we are finished with the given iterator and processing
-// the stream may continue.
-// Calling it multiple times has no effect.
-func (i *ItemIter) Close() error {
-	if i.iter == nil {
-		return nil
-	}
-	return i.iter.Close()
+	item, _ := i.Current().(Item)
+	return item
 }
 
 // FetchItems discovers a set of items associated with a JID and optional node of
@@ -168,11 +111,36 @@ func FetchItemsIQ(ctx context.Context, node string, iq stanza.IQ, s *xmpp.Sessio
 	query := ItemsQuery{
 		Node: node,
 	}
-	iter, err := s.IterIQ(ctx, iq.Wrap(query.TokenReader()))
-	if err != nil {
-		return &ItemIter{err: err}
+	first := true
+	return &ItemIter{
+		ResultIter: paging.NewResultIter(ctx,
+			func(ctx context.Context, next *paging.RequestNext) (*paging.Iter, error) {
+				// Only the first request may use the provided ID, subsequent pages
+				// are requested with new IQs.
+				if !first {
+					iq.ID = ""
+				}
+				first = false
+				payload := query.TokenReader()
+				if next != nil {
+					payload = xmlstream.Wrap(next.TokenReader(), xml.StartElement{
+						Name: xml.Name{Space: NSItems, Local: "query"},
+						Attr: queryAttrs(node),
+					})
+				}
+				iter, err := s.IterIQ(ctx, iq.Wrap(payload))
+				if err != nil {
+					return nil, err
+				}
+				return paging.WrapIter(iter, defPageSize), nil
+			},
+			func(start xml.StartElement, r xml.TokenReader) (interface{}, error) {
+				item := Item{}
+				err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(start), r)).Decode(&item)
+				return item, err
+			},
+		),
 	}
-	return &ItemIter{iter: paging.WrapIter(iter, defPageSize), ctx: ctx, session: s}
 }
 
 // ErrSkipItem is used as a return value from WalkItemFuncs to indicate that the
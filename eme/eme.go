@@ -0,0 +1,96 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package eme implements explicit message encryption.
+//
+// Explicit message encryption hints let clients indicate that a message is
+// encrypted and which encryption method was used so that clients that do not
+// support the method can tell the user why the message cannot be displayed
+// instead of showing a fallback body.
+package eme // import "mellium.im/xmpp/eme"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:eme:0"
+
+// Namespaces of well known encryption methods, provided as a convenience.
+const (
+	NSOTR           = "urn:xmpp:otr:0"
+	NSLegacyOpenPGP = "jabber:x:encrypted"
+	NSOpenPGP       = "urn:xmpp:openpgp:0"
+	NSOMEMO         = "eu.siacs.conversations.axolotl"
+	NSOMEMO1        = "urn:xmpp:omemo:1"
+	NSOMEMO2        = "urn:xmpp:omemo:2"
+)
+
+var names = map[string]string{
+	NSOTR:           "OTR",
+	NSLegacyOpenPGP: "Legacy OpenPGP",
+	NSOpenPGP:       "OpenPGP for XMPP",
+	NSOMEMO:         "OMEMO",
+	NSOMEMO1:        "OMEMO",
+	NSOMEMO2:        "OMEMO",
+}
+
+// Encryption indicates that a message is encrypted using the method identified
+// by Namespace.
+// Name is a human readable name for the method and should only be set if the
+// method is not one of the well known methods listed in this package.
+type Encryption struct {
+	XMLName   xml.Name `xml:"urn:xmpp:eme:0 encryption"`
+	Namespace string   `xml:"namespace,attr"`
+	Name      string   `xml:"name,attr,omitempty"`
+}
+
+// String returns a human readable name for the encryption method.
+// If Name is set it is returned, otherwise the name of a well known method is
+// looked up using Namespace.
+// If the method is not known, the namespace is returned.
+func (e Encryption) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	if name, ok := names[e.Namespace]; ok {
+		return name
+	}
+	return e.Namespace
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (e Encryption) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "namespace"}, Value: e.Namespace}}
+	if e.Name != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "name"}, Value: e.Name})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "encryption"},
+		Attr: attrs,
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (e Encryption) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, e.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (e Encryption) MarshalXML(enc *xml.Encoder, _ xml.StartElement) error {
+	_, err := e.WriteXML(enc)
+	if err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "encryption"}, func() interface{} {
+		return &Encryption{}
+	})
+}
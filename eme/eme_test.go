@@ -0,0 +1,95 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package eme_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/eme"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = eme.Encryption{}
+	_ xmlstream.Marshaler = eme.Encryption{}
+	_ xmlstream.WriterTo  = eme.Encryption{}
+)
+
+var marshalTestCases = [...]struct {
+	e    eme.Encryption
+	xml  string
+	name string
+}{
+	0: {
+		e:    eme.Encryption{Namespace: eme.NSOMEMO},
+		xml:  `<encryption xmlns="urn:xmpp:eme:0" namespace="eu.siacs.conversations.axolotl"></encryption>`,
+		name: "OMEMO",
+	},
+	1: {
+		e:    eme.Encryption{Namespace: "urn:example:crypt", Name: "Example"},
+		xml:  `<encryption xmlns="urn:xmpp:eme:0" namespace="urn:example:crypt" name="Example"></encryption>`,
+		name: "Example",
+	},
+	2: {
+		e:    eme.Encryption{Namespace: "urn:example:crypt"},
+		xml:  `<encryption xmlns="urn:xmpp:eme:0" namespace="urn:example:crypt"></encryption>`,
+		name: "urn:example:crypt",
+	},
+}
+
+func TestMarshal(t *testing.T) {
+	for i, tc := range marshalTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			b, err := xml.Marshal(tc.e)
+			if err != nil {
+				t.Fatalf("error marshaling: %v", err)
+			}
+			if string(b) != tc.xml {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.xml, b)
+			}
+			if s := tc.e.String(); s != tc.name {
+				t.Errorf("wrong name: want=%q, got=%q", tc.name, s)
+			}
+
+			var msg struct {
+				stanza.Message
+				Encryption eme.Encryption
+			}
+			err = xml.Unmarshal([]byte(`<message xmlns="jabber:client"><body>encrypted</body>`+tc.xml+`</message>`), &msg)
+			if err != nil {
+				t.Fatalf("error unmarshaling: %v", err)
+			}
+			want := tc.e
+			want.XMLName = xml.Name{Space: eme.NS, Local: "encryption"}
+			if !reflect.DeepEqual(msg.Encryption, want) {
+				t.Errorf("wrong payload decoded: want=%+v, got=%+v", want, msg.Encryption)
+			}
+		})
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><encryption xmlns="urn:xmpp:eme:0" namespace="urn:xmpp:otr:0"/></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		&eme.Encryption{XMLName: xml.Name{Space: eme.NS, Local: "encryption"}, Namespace: "urn:xmpp:otr:0"},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+module mellium.im/xmpp/examples/selftest
+
+go 1.16
+
+require (
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897 // indirect
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b // indirect
+	golang.org/x/text v0.3.4 // indirect
+	mellium.im/sasl v0.2.1
+	mellium.im/xmpp v0.16.0
+)
+
+replace mellium.im/xmpp => ../../
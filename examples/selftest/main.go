@@ -0,0 +1,263 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// The selftest command logs in to an account and reports which features the
+// server supports.
+//
+// It is meant to help debug problems where a feature does not work with a
+// particular server by showing what the server advertises and which
+// negotiations succeed.
+// The address and password are read from the environment:
+//
+//     XMPP_ADDR=me@example.net XMPP_PASS=secret selftest
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/carbons"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/ping"
+)
+
+const (
+	envAddr = "XMPP_ADDR"
+	envPass = "XMPP_PASS"
+)
+
+// check is a single line in the report.
+type check struct {
+	name string
+	ns   string
+}
+
+// Stream features that are advertised during negotiation.
+var streamChecks = []check{
+	{name: "Stream Management", ns: "urn:xmpp:sm:3"},
+	{name: "Client State Indication", ns: "urn:xmpp:csi:0"},
+	{name: "Roster Versioning", ns: "urn:xmpp:features:rosterver"},
+	{name: "Subscription Pre-Approval", ns: "urn:xmpp:features:pre-approval"},
+	{name: "SASL2", ns: "urn:xmpp:sasl:2"},
+}
+
+// Features advertised by the server itself.
+var serverChecks = []check{
+	{name: "Message Carbons", ns: carbons.NS},
+	{name: "Ping", ns: ping.NS},
+	{name: "Blocking Command", ns: "urn:xmpp:blocking"},
+	{name: "Entity Time", ns: "urn:xmpp:time"},
+	{name: "Software Version", ns: "jabber:iq:version"},
+}
+
+// Features advertised by the users account.
+var accountChecks = []check{
+	{name: "Message Archive Management", ns: "urn:xmpp:mam:2"},
+	{name: "Personal Eventing Protocol", ns: "http://jabber.org/protocol/pubsub"},
+}
+
+// Features advertised by services on the server.
+var serviceChecks = []check{
+	{name: "HTTP File Upload", ns: "urn:xmpp:http:upload:0"},
+	{name: "Multi-User Chat", ns: "http://jabber.org/protocol/muc"},
+	{name: "Proxy Bytestreams", ns: "http://jabber.org/protocol/bytestreams"},
+}
+
+func main() {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	addr := os.Getenv(envAddr)
+	if addr == "" {
+		logger.Fatalf("Environment variable $%s unset", envAddr)
+	}
+	parsedAddr, err := jid.Parse(addr)
+	if err != nil {
+		logger.Fatalf("Error parsing address %q: %v", addr, err)
+	}
+	pass := os.Getenv(envPass)
+	if pass == "" {
+		logger.Fatalf("Environment variable $%s unset", envPass)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	report := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	/* #nosec */
+	defer report.Flush()
+
+	fmt.Fprintf(report, "Negotiation\t\n")
+	session, err := xmpp.DialClientSession(
+		ctx, parsedAddr,
+		xmpp.BindResource(),
+		xmpp.StartTLS(&tls.Config{
+			ServerName: parsedAddr.Domain().String(),
+		}),
+		xmpp.SASL("", pass, sasl.ScramSha256Plus, sasl.ScramSha1Plus, sasl.ScramSha256, sasl.ScramSha1, sasl.Plain),
+	)
+	if err != nil {
+		fmt.Fprintf(report, "  Login\tfailed: %v\n", err)
+		return
+	}
+	defer func() {
+		if err := session.Close(); err != nil {
+			logger.Printf("Error ending session: %v", err)
+		}
+		if err := session.Conn().Close(); err != nil {
+			logger.Printf("Error closing connection: %v", err)
+		}
+	}()
+	snap := session.Snapshot()
+	fmt.Fprintf(report, "  Login\tok (bound to %s)\n", snap.LocalAddr)
+	if snap.TLS != nil {
+		fmt.Fprintf(report, "  TLS\t%s\n", tlsVersion(snap.TLS.Version))
+	} else {
+		fmt.Fprintf(report, "  TLS\tnot used\n")
+	}
+	if _, ok := session.Feature(xmpp.BindResource().Name.Space); ok {
+		fmt.Fprintf(report, "  Resource Binding\tok\n")
+	}
+
+	go func() {
+		err := session.Serve(mux.New(ping.Handle()))
+		if err != nil {
+			logger.Printf("Error handling session responses: %v", err)
+		}
+	}()
+
+	// The session remembers every feature that was advertised during
+	// negotiation, even the ones that we do not support.
+	fmt.Fprintf(report, "\nStream Features\t\n")
+	for _, c := range streamChecks {
+		_, ok := session.Feature(c.ns)
+		fmt.Fprintf(report, "  %s\t%s\n", c.name, yesNo(ok))
+	}
+
+	domain := parsedAddr.Domain()
+	fmt.Fprintf(report, "\nServer (%s)\t\n", domain)
+	serverFeatures, err := features(ctx, session, domain)
+	if err != nil {
+		fmt.Fprintf(report, "  Service Discovery\tfailed: %v\n", err)
+	} else {
+		for _, c := range serverChecks {
+			_, ok := serverFeatures[c.ns]
+			fmt.Fprintf(report, "  %s\t%s\n", c.name, yesNo(ok))
+		}
+	}
+	if _, ok := serverFeatures[carbons.NS]; ok {
+		err = carbons.Enable(ctx, session)
+		if err != nil {
+			fmt.Fprintf(report, "  Enabling Carbons\tfailed: %v\n", err)
+		} else {
+			fmt.Fprintf(report, "  Enabling Carbons\tok\n")
+		}
+	}
+	err = ping.Send(ctx, session, domain)
+	if err != nil {
+		fmt.Fprintf(report, "  Sending Ping\tfailed: %v\n", err)
+	} else {
+		fmt.Fprintf(report, "  Sending Ping\tok\n")
+	}
+
+	account := parsedAddr.Bare()
+	fmt.Fprintf(report, "\nAccount (%s)\t\n", account)
+	accountFeatures, err := features(ctx, session, account)
+	if err != nil {
+		fmt.Fprintf(report, "  Service Discovery\tfailed: %v\n", err)
+	} else {
+		for _, c := range accountChecks {
+			_, ok := accountFeatures[c.ns]
+			fmt.Fprintf(report, "  %s\t%s\n", c.name, yesNo(ok))
+		}
+	}
+
+	fmt.Fprintf(report, "\nServices\t\n")
+	services, err := findServices(ctx, session, domain)
+	if err != nil {
+		fmt.Fprintf(report, "  Service Discovery\tfailed: %v\n", err)
+		return
+	}
+	for _, c := range serviceChecks {
+		addrs := services[c.ns]
+		if len(addrs) == 0 {
+			fmt.Fprintf(report, "  %s\tno\n", c.name)
+			continue
+		}
+		sort.Strings(addrs)
+		fmt.Fprintf(report, "  %s\t%v\n", c.name, addrs)
+	}
+}
+
+// features returns the set of features advertised by addr.
+func features(ctx context.Context, session *xmpp.Session, addr jid.JID) (map[string]struct{}, error) {
+	info, err := disco.GetInfo(ctx, "", addr, session)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]struct{}, len(info.Features))
+	for _, f := range info.Features {
+		m[f.Var] = struct{}{}
+	}
+	return m, nil
+}
+
+// findServices returns a map of features to the addresses of the services on
+// the server that advertise them.
+func findServices(ctx context.Context, session *xmpp.Session, domain jid.JID) (map[string][]string, error) {
+	iter := disco.FetchItems(ctx, disco.Item{JID: domain}, session)
+	var items []disco.Item
+	for iter.Next() {
+		items = append(items, iter.Item())
+	}
+	err := iter.Err()
+	/* #nosec */
+	iter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	services := make(map[string][]string)
+	for _, item := range items {
+		f, err := features(ctx, session, item.JID)
+		if err != nil {
+			// Some services may be offline or refuse to respond, that's fine.
+			continue
+		}
+		for ns := range f {
+			services[ns] = append(services[ns], item.JID.String())
+		}
+	}
+	return services, nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func tlsVersion(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("unknown (%#x)", v)
+}
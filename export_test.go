@@ -9,3 +9,26 @@ package xmpp
 var (
 	ErrNotStart = errNotStart
 )
+
+// PriorityLock is exported only for testing the order in which waiters acquire
+// the lock.
+type PriorityLock = priorityLock
+
+func (l *priorityLock) LockPriority(p Priority) {
+	l.lockPriority(p)
+}
+
+func (l *priorityLock) Waiting() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var n int
+	for _, w := range l.waiters {
+		n += len(w)
+	}
+	return n
+}
+
+// OutLock returns the lock on the output stream of s.
+func (s *Session) OutLock() *PriorityLock {
+	return s.out.Locker.(*priorityLock)
+}
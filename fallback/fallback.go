@@ -0,0 +1,131 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package fallback implements fallback indications.
+//
+// Fallback indications mark parts of a message body (or subject) that only
+// exist for the benefit of clients that do not support some other feature, for
+// example the quoted text at the start of a reply.
+// Clients that support the feature can remove the fallback text before
+// displaying the message.
+package fallback // import "mellium.im/xmpp/fallback"
+
+import (
+	"encoding/xml"
+	"strconv"
+	"unicode/utf8"
+
+	"mellium.im/xmlstream"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:fallback:0"
+
+// Range is a range of characters in a message body or subject.
+// Start is inclusive and End is exclusive, and both are counted in Unicode code
+// points (not bytes).
+type Range struct {
+	Start uint `xml:"start,attr"`
+	End   uint `xml:"end,attr"`
+}
+
+// Fallback indicates that some or all of a message is a fallback for the
+// feature with the namespace For.
+// If Body and Subject are both empty, the entire body is a fallback.
+type Fallback struct {
+	XMLName xml.Name `xml:"urn:xmpp:fallback:0 fallback"`
+	For     string   `xml:"for,attr"`
+	Body    []Range  `xml:"body"`
+	Subject []Range  `xml:"subject"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (f Fallback) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, r := range f.Body {
+		inner = append(inner, r.tokenReader("body"))
+	}
+	for _, r := range f.Subject {
+		inner = append(inner, r.tokenReader("subject"))
+	}
+	var attrs []xml.Attr
+	if f.For != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "for"}, Value: f.For})
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "fallback"}, Attr: attrs},
+	)
+}
+
+func (r Range) tokenReader(local string) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: local},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "start"}, Value: strconv.FormatUint(uint64(r.Start), 10)},
+			{Name: xml.Name{Local: "end"}, Value: strconv.FormatUint(uint64(r.End), 10)},
+		},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (f Fallback) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, f.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (f Fallback) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := f.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Strip returns the body with all of the body ranges in any fallback for the
+// namespace ns removed.
+// If ns is empty, ranges from all fallbacks are removed.
+// If a matching fallback does not contain any ranges, the entire body is a
+// fallback and the empty string is returned.
+// Ranges that extend past the end of the body are truncated.
+func Strip(body string, ns string, f ...Fallback) string {
+	var ranges []Range
+	for _, fb := range f {
+		if ns != "" && fb.For != ns {
+			continue
+		}
+		if len(fb.Body) == 0 && len(fb.Subject) == 0 {
+			return ""
+		}
+		ranges = append(ranges, fb.Body...)
+	}
+	if len(ranges) == 0 {
+		return body
+	}
+
+	out := make([]byte, 0, len(body))
+	var i uint
+	for _, c := range body {
+		if !inRange(i, ranges) {
+			out = append(out, string(c)...)
+		}
+		i++
+	}
+	return string(out)
+}
+
+func inRange(i uint, ranges []Range) bool {
+	for _, r := range ranges {
+		if i >= r.Start && i < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the length of s in Unicode code points.
+// It is provided as a convenience for constructing ranges.
+func Len(s string) uint {
+	return uint(utf8.RuneCountInString(s))
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package fallback_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/fallback"
+)
+
+var (
+	_ xml.Marshaler       = fallback.Fallback{}
+	_ xmlstream.Marshaler = fallback.Fallback{}
+	_ xmlstream.WriterTo  = fallback.Fallback{}
+)
+
+func TestRoundTrip(t *testing.T) {
+	f := fallback.Fallback{
+		XMLName: xml.Name{Space: fallback.NS, Local: "fallback"},
+		For:     "urn:xmpp:reply:0",
+		Body:    []fallback.Range{{Start: 0, End: 33}},
+		Subject: []fallback.Range{{Start: 2, End: 4}},
+	}
+	b, err := xml.Marshal(f)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	const want = `<fallback xmlns="urn:xmpp:fallback:0" for="urn:xmpp:reply:0"><body start="0" end="33"></body><subject start="2" end="4"></subject></fallback>`
+	if string(b) != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, b)
+	}
+	var got fallback.Fallback
+	err = xml.Unmarshal(b, &got)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("round trip failed: want=%+v, got=%+v", f, got)
+	}
+}
+
+var stripTestCases = [...]struct {
+	body string
+	ns   string
+	f    []fallback.Fallback
+	out  string
+}{
+	0: {body: "test", out: "test"},
+	1: {
+		body: "> quoted\nreply",
+		ns:   "urn:xmpp:reply:0",
+		f:    []fallback.Fallback{{For: "urn:xmpp:reply:0", Body: []fallback.Range{{Start: 0, End: 9}}}},
+		out:  "reply",
+	},
+	2: {
+		// Ranges are counted in code points, not bytes.
+		body: "> ☃ ünïcode\nreply",
+		ns:   "urn:xmpp:reply:0",
+		f:    []fallback.Fallback{{For: "urn:xmpp:reply:0", Body: []fallback.Range{{Start: 0, End: 12}}}},
+		out:  "reply",
+	},
+	3: {
+		body: "> quoted\nreply",
+		ns:   "urn:xmpp:reply:0",
+		f:    []fallback.Fallback{{For: "urn:example", Body: []fallback.Range{{Start: 0, End: 9}}}},
+		out:  "> quoted\nreply",
+	},
+	4: {
+		body: "entirely a fallback",
+		f:    []fallback.Fallback{{For: "urn:example"}},
+		out:  "",
+	},
+	5: {
+		body: "abcdef",
+		f: []fallback.Fallback{
+			{For: "urn:a", Body: []fallback.Range{{Start: 0, End: 1}, {Start: 4, End: 100}}},
+			{For: "urn:b", Body: []fallback.Range{{Start: 2, End: 3}}},
+		},
+		out: "bd",
+	},
+}
+
+func TestStrip(t *testing.T) {
+	for i, tc := range stripTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := fallback.Strip(tc.body, tc.ns, tc.f...)
+			if out != tc.out {
+				t.Errorf("wrong output: want=%q, got=%q", tc.out, out)
+			}
+		})
+	}
+}
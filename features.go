@@ -73,6 +73,16 @@ type StreamFeature struct {
 	// parameter might be the list of supported algorithms as a slice of strings
 	// (or in whatever format the feature implementation has decided upon).
 	Negotiate func(ctx context.Context, session *Session, data interface{}) (mask SessionState, rw io.ReadWriter, err error)
+
+	// Used to handle an updated features list that is received after the
+	// session has been negotiated, for example if the server changes the
+	// features it offers after a session is resumed.
+	// If the updated list contains this feature, Parse is called and the data
+	// it returns is passed to Update and replaces the data returned by the
+	// sessions Feature method.
+	// If Update is nil, the data is replaced but no other action is taken.
+	// Update is called from the goroutine running Serve.
+	Update func(ctx context.Context, session *Session, data interface{}) error
 }
 
 func containsStartTLS(features []StreamFeature) (startTLS StreamFeature, ok bool) {
@@ -97,7 +107,7 @@ func decodeStreamErr(start xml.StartElement, r xml.TokenReader) error {
 	return e
 }
 
-func negotiateFeatures(ctx context.Context, s *Session, first, ws bool, features []StreamFeature) (mask SessionState, rw io.ReadWriter, err error) {
+func negotiateFeatures(ctx context.Context, s *Session, first, ws bool, timeouts Timeouts, features []StreamFeature) (mask SessionState, rw io.ReadWriter, err error) {
 	server := (s.state & Received) == Received
 
 	// If we're the server, write the initial stream features.
@@ -180,6 +190,10 @@ func negotiateFeatures(ctx context.Context, s *Session, first, ws bool, features
 			if !ok {
 				return mask, nil, fmt.Errorf("xmpp: received invalid start to feature of type %T", t)
 			}
+			err = decodeStreamErr(start, s.in.d)
+			if err != nil {
+				return mask, nil, err
+			}
 			// If this is an IQ (used by legacy features such as resource binding),
 			// unwrap it and use the payload's namespace to determine which feature to
 			// select.
@@ -257,6 +271,10 @@ func negotiateFeatures(ctx context.Context, s *Session, first, ws bool, features
 			s.in.d = intstream.Reader(oldDecoder)
 		}
 
+		err = timeouts.apply(s.Conn(), timeouts.feature(data.feature.Name.Space))
+		if err != nil {
+			return mask, nil, err
+		}
 		mask, rw, err = data.feature.Negotiate(ctx, s, s.features[data.feature.Name.Space])
 		s.in.d = oldDecoder
 		if err == nil {
@@ -440,3 +458,64 @@ parsefeatures:
 		}
 	}
 }
+
+// updateFeatures parses a features list that was received after the session
+// was negotiated and replaces the list of advertised features.
+// Features are parsed using the features that were used during negotiation.
+func updateFeatures(ctx context.Context, s *Session, r xml.TokenReader) error {
+	advertised := make(map[string]interface{})
+	type update struct {
+		feature StreamFeature
+		data    interface{}
+	}
+	var updates []update
+	state := s.State()
+	for {
+		t, err := r.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		tok, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		limitDecoder := nextElementDecoder(r, tok)
+		advertised[tok.Name.Space] = nil
+
+		feature, ok := getFeature(tok.Name, s.streamFeatures)
+		if ok && feature.Parse != nil &&
+			state&feature.Necessary == feature.Necessary &&
+			state&feature.Prohibited == 0 {
+			_, data, err := feature.Parse(ctx, limitDecoder, &tok)
+			if err != nil {
+				return err
+			}
+			advertised[tok.Name.Space] = data
+			updates = append(updates, update{feature: feature, data: data})
+		}
+		// Advance to the end of the feature element in case the parse function did
+		// not consume the entire feature or we did not support the feature.
+		_, err = xmlstream.Copy(xmlstream.Discard(), limitDecoder)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.featuresMutex.Lock()
+	s.features = advertised
+	s.featuresMutex.Unlock()
+
+	for _, u := range updates {
+		if u.feature.Update == nil {
+			continue
+		}
+		err := u.feature.Update(ctx, s, u.data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
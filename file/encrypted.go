@@ -0,0 +1,237 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/url"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/hashes"
+	"mellium.im/xmpp/oob"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSEncrypted is the namespace used by encrypted sources.
+const NSEncrypted = `urn:xmpp:esfs:0`
+
+// A list of ciphers that may be used to encrypt files.
+// Only the AES-GCM ciphers are supported by Encrypt and Decrypt.
+const (
+	AES128GCM = "urn:xmpp:ciphers:aes-128-gcm-nopadding:0"
+	AES256GCM = "urn:xmpp:ciphers:aes-256-gcm-nopadding:0"
+	AES256CBC = "urn:xmpp:ciphers:aes-256-cbc-pkcs7:0"
+)
+
+// SchemeAESGCM is the URL scheme used for legacy encrypted uploads where the
+// key and IV are stored in the URL fragment.
+const SchemeAESGCM = "aesgcm"
+
+const (
+	keySize = 32
+	ivSize  = 12
+)
+
+// Errors returned by the encryption functions in this package.
+var (
+	ErrUnsupportedCipher = errors.New("file: unsupported cipher")
+	ErrBadFragment       = errors.New("file: aesgcm URL fragment must contain a hex encoded IV and key")
+	ErrBadKey            = errors.New("file: OMEMO media sharing requires a 32 byte key and a 12 byte IV")
+)
+
+// Encrypted is a source that indicates a file was encrypted before it was
+// uploaded.
+// The sources inside of Encrypted point to the encrypted file.
+// It should only be sent over end-to-end encrypted channels, otherwise anyone
+// who can read the stanza can decrypt the file.
+type Encrypted struct {
+	XMLName xml.Name      `xml:"urn:xmpp:esfs:0 encrypted"`
+	Cipher  string        `xml:"cipher,attr"`
+	Key     []byte        `xml:"-"`
+	IV      []byte        `xml:"-"`
+	Hashes  []hashes.Hash `xml:"urn:xmpp:hashes:2 hash"`
+	Sources Sources       `xml:"urn:xmpp:sfs:0 sources"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (e Encrypted) TokenReader() xml.TokenReader {
+	payloads := []xml.TokenReader{
+		elem("key", base64.StdEncoding.EncodeToString(e.Key)),
+		elem("iv", base64.StdEncoding.EncodeToString(e.IV)),
+	}
+	for _, h := range e.Hashes {
+		payloads = append(payloads, h.TokenReader())
+	}
+	payloads = append(payloads, e.Sources.TokenReader())
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(payloads...),
+		xml.StartElement{
+			Name: xml.Name{Space: NSEncrypted, Local: "encrypted"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "cipher"}, Value: e.Cipher}},
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (e Encrypted) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, e.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (e Encrypted) MarshalXML(enc *xml.Encoder, _ xml.StartElement) error {
+	_, err := e.WriteXML(enc)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (e *Encrypted) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		XMLName xml.Name      `xml:"urn:xmpp:esfs:0 encrypted"`
+		Cipher  string        `xml:"cipher,attr"`
+		Key     string        `xml:"key"`
+		IV      string        `xml:"iv"`
+		Hashes  []hashes.Hash `xml:"urn:xmpp:hashes:2 hash"`
+		Sources Sources       `xml:"urn:xmpp:sfs:0 sources"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	key, err := base64.StdEncoding.DecodeString(s.Key)
+	if err != nil {
+		return err
+	}
+	iv, err := base64.StdEncoding.DecodeString(s.IV)
+	if err != nil {
+		return err
+	}
+	*e = Encrypted{
+		XMLName: s.XMLName,
+		Cipher:  s.Cipher,
+		Key:     key,
+		IV:      iv,
+		Hashes:  s.Hashes,
+		Sources: s.Sources,
+	}
+	return nil
+}
+
+// Encrypt encrypts plaintext using AES-256-GCM with a newly generated key and
+// IV.
+// The returned Encrypted does not contain any sources; after the ciphertext is
+// uploaded the caller should add the location it was uploaded to.
+func Encrypt(plaintext []byte) (ciphertext []byte, e Encrypted, err error) {
+	e = Encrypted{
+		Cipher: AES256GCM,
+		Key:    make([]byte, keySize),
+		IV:     make([]byte, ivSize),
+	}
+	_, err = rand.Read(e.Key)
+	if err != nil {
+		return nil, e, err
+	}
+	_, err = rand.Read(e.IV)
+	if err != nil {
+		return nil, e, err
+	}
+	aead, err := newGCM(e)
+	if err != nil {
+		return nil, e, err
+	}
+	return aead.Seal(nil, e.IV, plaintext, nil), e, nil
+}
+
+// Decrypt decrypts ciphertext using the cipher, key, and IV from e.
+// The authentication tag is expected to be appended to the ciphertext.
+func Decrypt(ciphertext []byte, e Encrypted) ([]byte, error) {
+	aead, err := newGCM(e)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, e.IV, ciphertext, nil)
+}
+
+func newGCM(e Encrypted) (cipher.AEAD, error) {
+	switch e.Cipher {
+	case AES128GCM, AES256GCM:
+	default:
+		return nil, ErrUnsupportedCipher
+	}
+	block, err := aes.NewCipher(e.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, len(e.IV))
+}
+
+// AESGCM returns a copy of the HTTPS URL u that has been converted to an
+// aesgcm URL containing the key and IV from e.
+// This is the format used to share encrypted files in OMEMO conversations as
+// described in XEP-0454: OMEMO Media sharing.
+func AESGCM(u *url.URL, e Encrypted) *url.URL {
+	aesURL := *u
+	aesURL.Scheme = SchemeAESGCM
+	aesURL.Fragment = hex.EncodeToString(e.IV) + hex.EncodeToString(e.Key)
+	aesURL.RawFragment = ""
+	return &aesURL
+}
+
+// ParseAESGCM converts an aesgcm URL to an HTTPS URL from which the encrypted
+// file can be downloaded and the information required to decrypt it.
+// Both 12 and 16 byte IVs are supported, the latter being used by some older
+// clients.
+// The returned Encrypted contains the HTTPS URL as its only source.
+func ParseAESGCM(u *url.URL) (*url.URL, Encrypted, error) {
+	e := Encrypted{Cipher: AES256GCM}
+	if u.Scheme != SchemeAESGCM {
+		return nil, e, ErrUnsupportedCipher
+	}
+	frag, err := hex.DecodeString(u.Fragment)
+	if err != nil {
+		return nil, e, ErrBadFragment
+	}
+	switch len(frag) {
+	case ivSize + keySize, 16 + keySize:
+	default:
+		return nil, e, ErrBadFragment
+	}
+	ivLen := len(frag) - keySize
+	e.IV = frag[:ivLen]
+	e.Key = frag[ivLen:]
+
+	httpsURL := *u
+	httpsURL.Scheme = "https"
+	httpsURL.Fragment = ""
+	httpsURL.RawFragment = ""
+	e.Sources.URLs = []URLData{{Target: httpsURL.String()}}
+	return &httpsURL, e, nil
+}
+
+// MediaMessage returns a message that shares an encrypted file as described in
+// XEP-0454: OMEMO Media sharing.
+// u is the HTTPS URL that the ciphertext (as returned by Encrypt) was uploaded
+// to.
+// The aesgcm URL is used as the body of the message and an OOB data element is
+// added so that clients know to treat the body as an attachment.
+//
+// The message contains the key and must be encrypted with OMEMO before it is
+// sent.
+// Because XEP-0454 only supports AES-256-GCM with a 12 byte IV, e must use
+// those parameters or an error is returned.
+func MediaMessage(msg stanza.Message, u *url.URL, e Encrypted) (xml.TokenReader, error) {
+	if e.Cipher != AES256GCM {
+		return nil, ErrUnsupportedCipher
+	}
+	if len(e.Key) != keySize || len(e.IV) != ivSize {
+		return nil, ErrBadKey
+	}
+	return oob.Message(msg, oob.Data{URL: AESGCM(u, e).String()}), nil
+}
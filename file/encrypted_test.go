@@ -0,0 +1,139 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package file_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/file"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = file.Encrypted{}
+	_ xml.Unmarshaler     = (*file.Encrypted)(nil)
+	_ xmlstream.Marshaler = file.Encrypted{}
+	_ xmlstream.WriterTo  = file.Encrypted{}
+)
+
+func TestEncodeEncrypted(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &file.Encrypted{
+				XMLName: xml.Name{Space: file.NSEncrypted, Local: "encrypted"},
+				Cipher:  file.AES256GCM,
+				Key:     []byte("key"),
+				IV:      []byte("iv"),
+				Sources: file.Sources{
+					XMLName: xml.Name{Space: file.NSSharing, Local: "sources"},
+					URLs: []file.URLData{{
+						XMLName: xml.Name{Space: file.NSURLData, Local: "url-data"},
+						Target:  "https://example.net/test.txt",
+					}},
+				},
+			},
+			XML: `<encrypted xmlns="urn:xmpp:esfs:0" cipher="urn:xmpp:ciphers:aes-256-gcm-nopadding:0"><key>a2V5</key><iv>aXY=</iv><sources xmlns="urn:xmpp:sfs:0"><url-data xmlns="http://jabber.org/protocol/url-data" target="https://example.net/test.txt"></url-data></sources></encrypted>`,
+		},
+	})
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	plaintext := []byte("Now is the winter of our discontent")
+	ciphertext, e, err := file.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Fatalf("ciphertext contains plaintext")
+	}
+
+	u, err := url.Parse("https://example.net/upload/file.txt")
+	if err != nil {
+		t.Fatalf("error parsing URL: %v", err)
+	}
+	aesURL := file.AESGCM(u, e)
+	if aesURL.Scheme != file.SchemeAESGCM {
+		t.Errorf("wrong scheme: want=%q, got=%q", file.SchemeAESGCM, aesURL.Scheme)
+	}
+	httpsURL, parsed, err := file.ParseAESGCM(aesURL)
+	if err != nil {
+		t.Fatalf("error parsing aesgcm URL: %v", err)
+	}
+	if httpsURL.String() != u.String() {
+		t.Errorf("wrong HTTPS URL: want=%q, got=%q", u, httpsURL)
+	}
+	out, err := file.Decrypt(ciphertext, parsed)
+	if err != nil {
+		t.Fatalf("error decrypting: %v", err)
+	}
+	if !bytes.Equal(out, plaintext) {
+		t.Errorf("wrong plaintext: want=%q, got=%q", plaintext, out)
+	}
+}
+
+func TestParseAESGCMBadFragment(t *testing.T) {
+	u, err := url.Parse("aesgcm://example.net/file.txt#abcd")
+	if err != nil {
+		t.Fatalf("error parsing URL: %v", err)
+	}
+	_, _, err = file.ParseAESGCM(u)
+	if !errors.Is(err, file.ErrBadFragment) {
+		t.Errorf("wrong error: want=%v, got=%v", file.ErrBadFragment, err)
+	}
+}
+
+func TestMediaMessage(t *testing.T) {
+	u, err := url.Parse("https://example.net/upload/file.txt")
+	if err != nil {
+		t.Fatalf("error parsing URL: %v", err)
+	}
+	e := file.Encrypted{
+		Cipher: file.AES256GCM,
+		Key:    bytes.Repeat([]byte{0xff}, 32),
+		IV:     bytes.Repeat([]byte{0x01}, 12),
+	}
+	r, err := file.MediaMessage(stanza.Message{
+		To:   jid.MustParse("feste@example.net"),
+		Type: stanza.ChatMessage,
+	}, u, e)
+	if err != nil {
+		t.Fatalf("error creating message: %v", err)
+	}
+
+	var b strings.Builder
+	enc := xml.NewEncoder(&b)
+	_, err = xmlstream.Copy(enc, r)
+	if err != nil {
+		t.Fatalf("error encoding message: %v", err)
+	}
+	err = enc.Flush()
+	if err != nil {
+		t.Fatalf("error flushing message: %v", err)
+	}
+	aesURL := "aesgcm://example.net/upload/file.txt#" + strings.Repeat("01", 12) + strings.Repeat("ff", 32)
+	expected := `<message type="chat" to="feste@example.net"><body>` + aesURL + `</body><x xmlns="jabber:x:oob"><url>` + aesURL + `</url></x></message>`
+	if out := b.String(); out != expected {
+		t.Errorf("wrong encoding:\nwant=%s,\n got=%s", expected, out)
+	}
+
+	e.IV = e.IV[:8]
+	_, err = file.MediaMessage(stanza.Message{}, u, e)
+	if !errors.Is(err, file.ErrBadKey) {
+		t.Errorf("wrong error for short IV: want=%v, got=%v", file.ErrBadKey, err)
+	}
+	e.Cipher = file.AES128GCM
+	_, err = file.MediaMessage(stanza.Message{}, u, e)
+	if !errors.Is(err, file.ErrUnsupportedCipher) {
+		t.Errorf("wrong error for cipher: want=%v, got=%v", file.ErrUnsupportedCipher, err)
+	}
+}
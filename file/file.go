@@ -0,0 +1,279 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package file implements XEP-0446: File metadata element, XEP-0447:
+// Stateless file sharing, and XEP-0448: Encryption for stateless file sharing.
+package file // import "mellium.im/xmpp/file"
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/hashes"
+	"mellium.im/xmpp/jid"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS        = `urn:xmpp:file:metadata:0`
+	NSSharing = `urn:xmpp:sfs:0`
+	NSURLData = `http://jabber.org/protocol/url-data`
+	NSJingle  = `urn:xmpp:jinglepub:1`
+)
+
+// Metadata describes a file.
+// All fields are optional, but senders should include as much information as
+// they have available.
+type Metadata struct {
+	XMLName   xml.Name      `xml:"urn:xmpp:file:metadata:0 file"`
+	MediaType string        `xml:"media-type,omitempty"`
+	Name      string        `xml:"name,omitempty"`
+	Date      time.Time     `xml:"date,omitempty"`
+	Size      int64         `xml:"size,omitempty"`
+	Desc      string        `xml:"desc,omitempty"`
+	Hashes    []hashes.Hash `xml:"urn:xmpp:hashes:2 hash"`
+	Width     int           `xml:"width,omitempty"`
+	Height    int           `xml:"height,omitempty"`
+
+	// Length is the playing time of audio and video files.
+	// It is transmitted with millisecond precision.
+	Length time.Duration `xml:"-"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (m Metadata) TokenReader() xml.TokenReader {
+	var payloads []xml.TokenReader
+	if m.MediaType != "" {
+		payloads = append(payloads, elem("media-type", m.MediaType))
+	}
+	if m.Name != "" {
+		payloads = append(payloads, elem("name", m.Name))
+	}
+	if !m.Date.IsZero() {
+		payloads = append(payloads, elem("date", m.Date.UTC().Format(time.RFC3339)))
+	}
+	if m.Size > 0 {
+		payloads = append(payloads, elem("size", strconv.FormatInt(m.Size, 10)))
+	}
+	if m.Desc != "" {
+		payloads = append(payloads, elem("desc", m.Desc))
+	}
+	for _, h := range m.Hashes {
+		payloads = append(payloads, h.TokenReader())
+	}
+	if m.Width > 0 {
+		payloads = append(payloads, elem("width", strconv.Itoa(m.Width)))
+	}
+	if m.Height > 0 {
+		payloads = append(payloads, elem("height", strconv.Itoa(m.Height)))
+	}
+	if m.Length > 0 {
+		payloads = append(payloads, elem("length", strconv.FormatInt(int64(m.Length/time.Millisecond), 10)))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(payloads...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "file"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (m Metadata) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, m.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (m Metadata) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := m.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (m *Metadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		XMLName   xml.Name      `xml:"urn:xmpp:file:metadata:0 file"`
+		MediaType string        `xml:"media-type"`
+		Name      string        `xml:"name"`
+		Date      time.Time     `xml:"date"`
+		Size      int64         `xml:"size"`
+		Desc      string        `xml:"desc"`
+		Hashes    []hashes.Hash `xml:"urn:xmpp:hashes:2 hash"`
+		Width     int           `xml:"width"`
+		Height    int           `xml:"height"`
+		Length    int64         `xml:"length"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*m = Metadata{
+		XMLName:   s.XMLName,
+		MediaType: s.MediaType,
+		Name:      s.Name,
+		Date:      s.Date,
+		Size:      s.Size,
+		Desc:      s.Desc,
+		Hashes:    s.Hashes,
+		Width:     s.Width,
+		Height:    s.Height,
+		Length:    time.Duration(s.Length) * time.Millisecond,
+	}
+	return nil
+}
+
+// Disposition indicates whether a shared file should be displayed inline or
+// downloaded as an attachment.
+type Disposition string
+
+// A list of possible dispositions.
+const (
+	DispositionUnknown    Disposition = ""
+	DispositionInline     Disposition = "inline"
+	DispositionAttachment Disposition = "attachment"
+)
+
+// Sharing is used to share a file along with a list of sources from which it
+// can be retrieved.
+// It is the successor to XEP-0385: Stateless Inline Media Sharing (SIMS).
+type Sharing struct {
+	XMLName     xml.Name    `xml:"urn:xmpp:sfs:0 file-sharing"`
+	Disposition Disposition `xml:"disposition,attr,omitempty"`
+	ID          string      `xml:"id,attr,omitempty"`
+	File        Metadata    `xml:"urn:xmpp:file:metadata:0 file"`
+	Sources     Sources     `xml:"urn:xmpp:sfs:0 sources"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (s Sharing) TokenReader() xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Space: NSSharing, Local: "file-sharing"}}
+	if s.Disposition != DispositionUnknown {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "disposition"}, Value: string(s.Disposition)})
+	}
+	if s.ID != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "id"}, Value: s.ID})
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(
+			s.File.TokenReader(),
+			s.Sources.TokenReader(),
+		),
+		start,
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (s Sharing) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s Sharing) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	return err
+}
+
+// Sources is a list of locations from which a shared file can be retrieved.
+// It may be sent inside of a Sharing element, or on its own to attach
+// additional sources to a file that was previously shared with the same ID.
+type Sources struct {
+	XMLName   xml.Name    `xml:"urn:xmpp:sfs:0 sources"`
+	ID        string      `xml:"id,attr,omitempty"`
+	URLs      []URLData   `xml:"http://jabber.org/protocol/url-data url-data"`
+	Jingle    []JinglePub `xml:"urn:xmpp:jinglepub:1 jinglepub"`
+	Encrypted []Encrypted `xml:"urn:xmpp:esfs:0 encrypted"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (s Sources) TokenReader() xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Space: NSSharing, Local: "sources"}}
+	if s.ID != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "id"}, Value: s.ID})
+	}
+	var payloads []xml.TokenReader
+	for _, u := range s.URLs {
+		payloads = append(payloads, u.TokenReader())
+	}
+	for _, j := range s.Jingle {
+		payloads = append(payloads, j.TokenReader())
+	}
+	for _, e := range s.Encrypted {
+		payloads = append(payloads, e.TokenReader())
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(payloads...), start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (s Sources) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s Sources) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	return err
+}
+
+// URLData is a source that indicates a file can be downloaded from a URL,
+// normally over HTTP.
+type URLData struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/url-data url-data"`
+	Target  string   `xml:"target,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (u URLData) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSURLData, Local: "url-data"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "target"}, Value: u.Target}},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (u URLData) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, u.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (u URLData) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := u.WriteXML(e)
+	return err
+}
+
+// JinglePub is a source that indicates a file can be requested from an entity
+// using Jingle File Transfer.
+type JinglePub struct {
+	XMLName xml.Name `xml:"urn:xmpp:jinglepub:1 jinglepub"`
+	From    jid.JID  `xml:"from,attr"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (j JinglePub) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSJingle, Local: "jinglepub"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "from"}, Value: j.From.String()},
+			{Name: xml.Name{Local: "id"}, Value: j.ID},
+		},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (j JinglePub) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, j.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (j JinglePub) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := j.WriteXML(e)
+	return err
+}
+
+func elem(local, text string) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(text)),
+		xml.StartElement{Name: xml.Name{Local: local}},
+	)
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package file_test
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/file"
+	"mellium.im/xmpp/hashes"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+)
+
+var (
+	_ xml.Marshaler       = file.Metadata{}
+	_ xml.Unmarshaler     = (*file.Metadata)(nil)
+	_ xmlstream.Marshaler = file.Metadata{}
+	_ xmlstream.WriterTo  = file.Metadata{}
+	_ xml.Marshaler       = file.Sharing{}
+	_ xmlstream.Marshaler = file.Sharing{}
+	_ xmlstream.WriterTo  = file.Sharing{}
+	_ xml.Marshaler       = file.Sources{}
+	_ xmlstream.Marshaler = file.Sources{}
+	_ xmlstream.WriterTo  = file.Sources{}
+	_ xml.Marshaler       = file.URLData{}
+	_ xmlstream.Marshaler = file.URLData{}
+	_ xmlstream.WriterTo  = file.URLData{}
+	_ xml.Marshaler       = file.JinglePub{}
+	_ xmlstream.Marshaler = file.JinglePub{}
+	_ xmlstream.WriterTo  = file.JinglePub{}
+)
+
+var metadata = file.Metadata{
+	XMLName:   xml.Name{Space: file.NS, Local: "file"},
+	MediaType: "text/plain",
+	Name:      "test.txt",
+	Date:      time.Date(2015, time.July, 26, 21, 46, 0, 0, time.UTC),
+	Size:      6144,
+	Desc:      "A test file",
+	Hashes: []hashes.Hash{{
+		XMLName: xml.Name{Space: hashes.NS, Local: "hash"},
+		Algo:    hashes.SHA256,
+		Value:   []byte("test"),
+	}},
+	Width:  640,
+	Height: 480,
+	Length: 63 * time.Second,
+}
+
+const metadataXML = `<file xmlns="urn:xmpp:file:metadata:0"><media-type>text/plain</media-type><name>test.txt</name><date>2015-07-26T21:46:00Z</date><size>6144</size><desc>A test file</desc><hash xmlns="urn:xmpp:hashes:2" algo="sha-256">dGVzdA==</hash><width>640</width><height>480</height><length>63000</length></file>`
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &file.Metadata{XMLName: xml.Name{Space: file.NS, Local: "file"}},
+			XML:   `<file xmlns="urn:xmpp:file:metadata:0"></file>`,
+		},
+		1: {
+			Value: &metadata,
+			XML:   metadataXML,
+		},
+		2: {
+			Value: &file.Sharing{
+				XMLName:     xml.Name{Space: file.NSSharing, Local: "file-sharing"},
+				Disposition: file.DispositionInline,
+				ID:          "123",
+				File:        metadata,
+				Sources: file.Sources{
+					XMLName: xml.Name{Space: file.NSSharing, Local: "sources"},
+					URLs: []file.URLData{{
+						XMLName: xml.Name{Space: file.NSURLData, Local: "url-data"},
+						Target:  "https://example.net/test.txt",
+					}},
+					Jingle: []file.JinglePub{{
+						XMLName: xml.Name{Space: file.NSJingle, Local: "jinglepub"},
+						From:    jid.MustParse("juliet@example.net/orchard"),
+						ID:      "9559976B-3FBF-4E7E-B457-2DAA225972BB",
+					}},
+				},
+			},
+			XML: `<file-sharing xmlns="urn:xmpp:sfs:0" disposition="inline" id="123">` + metadataXML + `<sources xmlns="urn:xmpp:sfs:0"><url-data xmlns="http://jabber.org/protocol/url-data" target="https://example.net/test.txt"></url-data><jinglepub xmlns="urn:xmpp:jinglepub:1" from="juliet@example.net/orchard" id="9559976B-3FBF-4E7E-B457-2DAA225972BB"></jinglepub></sources></file-sharing>`,
+		},
+	})
+}
@@ -104,10 +104,13 @@ func (f *field) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 }
 
 func (f *field) TokenReader() xml.TokenReader {
-	attr := []xml.Attr{{
-		Name:  xml.Name{Local: "type"},
-		Value: string(f.typ),
-	}}
+	var attr []xml.Attr
+	if f.typ != "" {
+		attr = append(attr, xml.Attr{
+			Name:  xml.Name{Local: "type"},
+			Value: string(f.typ),
+		})
+	}
 	if f.varName != "" {
 		attr = append(attr, xml.Attr{
 			Name:  xml.Name{Local: "var"},
@@ -55,10 +55,15 @@ type Data struct {
 	instructions string
 	typ          Type
 
-	fields []field
-	values map[string]interface{}
+	fields   []field
+	reported []field
+	items    [][]field
+	values   map[string]interface{}
 }
 
+// ErrRequired is returned by Validate when a required field has no value.
+var ErrRequired = errors.New("form: required field not set")
+
 // Title returns the title of the form.
 func (d *Data) Title() string {
 	return d.title
@@ -83,6 +88,54 @@ func (d *Data) ForFields(f func(FieldData)) {
 	}
 }
 
+// ForReported iterates over the fields in the header of a result table and calls
+// a function for each one, passing it information about the field.
+func (d *Data) ForReported(f func(FieldData)) {
+	for _, field := range d.reported {
+		f(FieldData{
+			Type:     field.typ,
+			Var:      field.varName,
+			Label:    field.label,
+			Desc:     field.desc,
+			Required: field.required,
+		})
+	}
+}
+
+// ForItems iterates over the rows of a result table and calls a function for
+// each one.
+// Each row is passed as a result form so that its values can be read using Get
+// and the other getter methods.
+// Fields in the row take their types from the header of the table.
+func (d *Data) ForItems(f func(*Data)) {
+	for _, item := range d.items {
+		row := &Data{typ: TypeResult}
+		for _, field := range item {
+			if field.typ == "" {
+				field.typ = TypeText
+				for _, r := range d.reported {
+					if r.varName == field.varName {
+						field.typ = r.typ
+						break
+					}
+				}
+			}
+			row.fields = append(row.fields, field)
+		}
+		f(row)
+	}
+}
+
+// FormType returns the value of the hidden FORM_TYPE field, if any.
+func (d *Data) FormType() string {
+	for _, field := range d.fields {
+		if field.varName == formTypeVar && field.typ == TypeHidden && len(field.value) > 0 {
+			return field.value[0]
+		}
+	}
+	return ""
+}
+
 // UnmarshalXML satisfies the xml.Unmarshaler interface for *Data.
 func (d *Data) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
 	for _, attr := range start.Attr {
@@ -151,6 +204,23 @@ func (d *Data) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error
 				f.typ = TypeText
 			}
 			d.fields = append(d.fields, f)
+		case "reported":
+			fields, err := decodeFields(decoder, start)
+			if err != nil {
+				return err
+			}
+			for i, f := range fields {
+				if f.typ == "" {
+					fields[i].typ = TypeText
+				}
+			}
+			d.reported = fields
+		case "item":
+			fields, err := decodeFields(decoder, start)
+			if err != nil {
+				return err
+			}
+			d.items = append(d.items, fields)
 		default:
 			return fmt.Errorf("unexpected element %v", start.Name)
 		}
@@ -162,6 +232,14 @@ func (d *Data) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error
 	return decoder.Skip()
 }
 
+func decodeFields(decoder *xml.Decoder, start xml.StartElement) ([]field, error) {
+	s := struct {
+		Fields []field `xml:"field"`
+	}{}
+	err := decoder.DecodeElement(&s, &start)
+	return s.Fields, err
+}
+
 // Get looks up the value submitted for a form field.
 // If the value has not been set yet and no default value exists, ok will be
 // false.
@@ -235,6 +313,21 @@ func (d *Data) Get(id string) (v interface{}, ok bool) {
 	return nil, false
 }
 
+// Values returns the raw values of the field with the given name as they
+// appear in the form.
+// Unlike Get, values submitted using Set are not included, and the values are
+// not normalized based on the type of the field.
+// This makes it possible to detect fields with more values than their type
+// permits.
+func (d *Data) Values(id string) []string {
+	for _, field := range d.fields {
+		if field.varName == id {
+			return append([]string(nil), field.value...)
+		}
+	}
+	return nil
+}
+
 // GetJID is like Get except that it asserts that the form submission is a JID.
 // If the form submission was not a JID or is not set, ok will be false.
 func (d *Data) GetJID(id string) (j jid.JID, ok bool) {
@@ -339,6 +432,9 @@ func (d *Data) Set(id string, v interface{}) (ok bool, err error) {
 			return false, fmt.Errorf("expected %T, got %T", vv, v)
 		}
 	}
+	if d.values == nil {
+		d.values = make(map[string]interface{})
+	}
 	d.values[id] = v
 	return ok, err
 }
@@ -366,6 +462,49 @@ func (d *Data) Submit() (submission xml.TokenReader, ok bool) {
 	return submissionData.TokenReader(), ok
 }
 
+// Validate checks that all required fields have a value and that the values of
+// list fields are among the options of the list.
+// If a required field has no value the error wraps ErrRequired.
+//
+// Submissions do not contain the options or requirements of the fields so to
+// validate a submission the values must be set on the original form first.
+func (d *Data) Validate() error {
+	for _, f := range d.fields {
+		if f.typ == TypeFixed {
+			continue
+		}
+		v, ok := d.Get(f.varName)
+		if !ok {
+			if f.required {
+				return fmt.Errorf("%w: %s", ErrRequired, f.varName)
+			}
+			continue
+		}
+		if len(f.option) == 0 {
+			continue
+		}
+		var vals []string
+		switch f.typ {
+		case TypeList:
+			if s, ok := v.(string); ok {
+				vals = []string{s}
+			}
+		case TypeListMulti:
+			vals, _ = v.([]string)
+		}
+	vals:
+		for _, val := range vals {
+			for _, opt := range f.option {
+				if opt.Value == val {
+					continue vals
+				}
+			}
+			return fmt.Errorf("form: value %q is not an option for field %s", val, f.varName)
+		}
+	}
+	return nil
+}
+
 // TokenReader implements xmlstream.Marshaler for Data.
 func (d *Data) TokenReader() xml.TokenReader {
 	var child []xml.TokenReader
@@ -448,6 +587,29 @@ func (d *Data) TokenReader() xml.TokenReader {
 		child = append(child, f.TokenReader())
 	}
 
+	if len(d.reported) > 0 {
+		var fields []xml.TokenReader
+		for _, f := range d.reported {
+			fields = append(fields, f.TokenReader())
+		}
+		child = append(child, xmlstream.Wrap(
+			xmlstream.MultiReader(fields...),
+			xml.StartElement{Name: xml.Name{Local: "reported"}},
+		))
+	}
+	for _, item := range d.items {
+		var fields []xml.TokenReader
+		for _, f := range item {
+			// The type of fields in an item is given by the reported fields.
+			f.typ = ""
+			fields = append(fields, f.TokenReader())
+		}
+		child = append(child, xmlstream.Wrap(
+			xmlstream.MultiReader(fields...),
+			xml.StartElement{Name: xml.Name{Local: "item"}},
+		))
+	}
+
 	return xmlstream.Wrap(
 		xmlstream.MultiReader(child...),
 		xml.StartElement{
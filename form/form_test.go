@@ -7,6 +7,8 @@ package form_test
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
+	"reflect"
 	"strconv"
 	"testing"
 
@@ -227,6 +229,16 @@ var marshalTestCases = [...]struct {
 		),
 		Expected: `<x xmlns="jabber:x:data" type="result"><field type="text-single" var="t"><value>one</value></field></x>`,
 	},
+	15: {
+		Data: form.New(
+			form.Result,
+			form.FormType("urn:example"),
+			form.Reported(form.Text("name", form.Label("Name")), form.JID("jid")),
+			form.Item(form.Text("name", form.Value("Juliet")), form.JID("jid", form.Value("juliet@example.net"))),
+			form.Item(form.Text("name", form.Value("Romeo")), form.JID("jid", form.Value("romeo@example.net"))),
+		),
+		Expected: `<x xmlns="jabber:x:data" type="result"><field type="hidden" var="FORM_TYPE"><value>urn:example</value></field><reported><field type="text-single" var="name" label="Name"></field><field type="jid-single" var="jid"></field></reported><item><field var="name"><value>Juliet</value></field><field var="jid"><value>juliet@example.net</value></field></item><item><field var="name"><value>Romeo</value></field><field var="jid"><value>romeo@example.net</value></field></item></x>`,
+	},
 }
 
 func TestMarshal(t *testing.T) {
@@ -509,3 +521,110 @@ func TestUnmarshalInvalidToken(t *testing.T) {
 		t.Fatalf("expected error when unmarshaling disallowed token type")
 	}
 }
+
+func TestSetUnmarshaled(t *testing.T) {
+	const formData = `<x xmlns="jabber:x:data" type="form"><field type="text-single" var="foo"/></x>`
+	data := &form.Data{}
+	err := xml.Unmarshal([]byte(formData), data)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	ok, err := data.Set("foo", "bar")
+	if err != nil || !ok {
+		t.Fatalf("error setting value on unmarshaled form: %v (%t)", err, ok)
+	}
+	if s, _ := data.GetString("foo"); s != "bar" {
+		t.Errorf("wrong value: want=bar, got=%q", s)
+	}
+}
+
+func TestValues(t *testing.T) {
+	const formData = `<x xmlns="jabber:x:data" type="result"><field type="hidden" var="FORM_TYPE"><value>urn:example</value><value>urn:other</value></field></x>`
+	data := &form.Data{}
+	err := xml.Unmarshal([]byte(formData), data)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if v := data.Values("FORM_TYPE"); !reflect.DeepEqual(v, []string{"urn:example", "urn:other"}) {
+		t.Errorf("wrong values: want=[urn:example urn:other], got=%v", v)
+	}
+	if v := data.Values("missing"); v != nil {
+		t.Errorf("expected no values for missing field, got %v", v)
+	}
+}
+
+func TestItems(t *testing.T) {
+	const formData = `<x xmlns="jabber:x:data" type="result">
+	<field type="hidden" var="FORM_TYPE"><value>urn:example</value></field>
+	<reported><field var="name" label="Name"/><field var="jid" type="jid-single"/></reported>
+	<item><field var="name"><value>Juliet</value></field><field var="jid"><value>juliet@example.net</value></field></item>
+	<item><field var="name"><value>Romeo</value></field><field var="jid"><value>romeo@example.net</value></field></item>
+</x>`
+	data := &form.Data{}
+	err := xml.Unmarshal([]byte(formData), data)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if ft := data.FormType(); ft != "urn:example" {
+		t.Errorf("wrong form type: want=urn:example, got=%q", ft)
+	}
+	var reported []form.FieldData
+	data.ForReported(func(f form.FieldData) {
+		reported = append(reported, f)
+	})
+	if len(reported) != 2 || reported[0].Type != form.TypeText || reported[0].Label != "Name" || reported[1].Type != form.TypeJID {
+		t.Errorf("wrong reported fields: %+v", reported)
+	}
+	var rows []string
+	data.ForItems(func(item *form.Data) {
+		name, _ := item.GetString("name")
+		j, ok := item.GetJID("jid")
+		if !ok {
+			t.Errorf("expected jid to be decoded as a JID for %s", name)
+		}
+		rows = append(rows, name+" "+j.String())
+	})
+	if len(rows) != 2 || rows[0] != "Juliet juliet@example.net" || rows[1] != "Romeo romeo@example.net" {
+		t.Errorf("wrong items: %q", rows)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	data := form.New(
+		form.Text("name", form.Required),
+		form.List("color", form.ListItem("Red", "red"), form.ListItem("Blue", "blue")),
+		form.ListMulti("size", form.ListItem("Small", "s"), form.ListItem("Large", "l")),
+	)
+	err := data.Validate()
+	if !errors.Is(err, form.ErrRequired) {
+		t.Errorf("wrong error for missing field: want=%v, got=%v", form.ErrRequired, err)
+	}
+	_, err = data.Set("name", "Juliet")
+	if err != nil {
+		t.Fatalf("error setting name: %v", err)
+	}
+	err = data.Validate()
+	if err != nil {
+		t.Errorf("unexpected error validating form: %v", err)
+	}
+	_, err = data.Set("color", "green")
+	if err != nil {
+		t.Fatalf("error setting color: %v", err)
+	}
+	err = data.Validate()
+	if err == nil {
+		t.Errorf("expected error for value that is not an option")
+	}
+	_, err = data.Set("color", "red")
+	if err != nil {
+		t.Fatalf("error setting color: %v", err)
+	}
+	_, err = data.Set("size", []string{"s", "m"})
+	if err != nil {
+		t.Fatalf("error setting size: %v", err)
+	}
+	err = data.Validate()
+	if err == nil {
+		t.Errorf("expected error for multi value that is not an option")
+	}
+}
@@ -21,6 +21,31 @@ func Instructions(s string) Field {
 	}
 }
 
+// FormType adds a hidden FORM_TYPE field with the provided value to the form.
+// The form type is a namespace that scopes the names of the other fields in the
+// form.
+// For more information see Registry.
+func FormType(ns string) Field {
+	return Hidden(formTypeVar, Value(ns))
+}
+
+// Reported adds fields to the header of a result table.
+// The header describes the fields that appear in each item added with Item.
+func Reported(f ...Field) Field {
+	return func(data *Data) {
+		data.reported = append(data.reported, New(f...).fields...)
+	}
+}
+
+// Item adds a row to a result table.
+// The fields of an item should be the same as the fields added with Reported
+// and only their values are used.
+func Item(f ...Field) Field {
+	return func(data *Data) {
+		data.items = append(data.items, New(f...).fields)
+	}
+}
+
 var (
 	// Result marks a form as the result type.
 	// For more information see TypeResult.
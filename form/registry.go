@@ -0,0 +1,109 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	formTypeVar  = "FORM_TYPE"
+	customPrefix = "x-"
+)
+
+// Registry maps form types (the value of the hidden FORM_TYPE field) to the
+// fields that have been standardized for them.
+// Fields that are not standardized for a form type must have names that begin
+// with "x-".
+//
+// The zero value is an empty registry ready to use.
+// A Registry is safe for concurrent use by multiple goroutines.
+type Registry struct {
+	mu sync.RWMutex
+	m  map[string][]FieldData
+}
+
+// DefaultRegistry is the default Registry.
+// Packages that define forms with a form type may register their fields with
+// the default registry.
+var DefaultRegistry = &Registry{}
+
+// Register adds the fields that are standardized for formType.
+// Only the Var and Type of each field are used, and fields with an empty Type
+// may be of any type.
+// If formType is empty or was already registered, Register panics.
+func (r *Registry) Register(formType string, fields ...FieldData) {
+	if formType == "" {
+		panic("form: empty form type")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.m[formType]; ok {
+		panic("form: multiple registrations for " + formType)
+	}
+	if r.m == nil {
+		r.m = make(map[string][]FieldData)
+	}
+	r.m[formType] = append([]FieldData(nil), fields...)
+}
+
+// Fields returns the fields that are standardized for formType.
+// If formType has not been registered, ok is false.
+func (r *Registry) Fields(formType string) (fields []FieldData, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fields, ok = r.m[formType]
+	return append([]FieldData(nil), fields...), ok
+}
+
+// Check reports whether the fields of d follow the standard for its form type.
+// Each field must either be registered for the form type, with the same type if
+// one was registered, or have a name that begins with "x-".
+// Forms without a form type or with a form type that has not been registered
+// are not checked.
+func (r *Registry) Check(d *Data) error {
+	formType := d.FormType()
+	if formType == "" {
+		return nil
+	}
+	fields, ok := r.Fields(formType)
+	if !ok {
+		return nil
+	}
+
+	check := func(f field) error {
+		if f.typ == TypeFixed || f.varName == formTypeVar {
+			return nil
+		}
+		for _, std := range fields {
+			if std.Var != f.varName {
+				continue
+			}
+			if std.Type != "" && f.typ != "" && std.Type != f.typ {
+				return fmt.Errorf("form: field %s of form type %s must be of type %s, got %s", f.varName, formType, std.Type, f.typ)
+			}
+			return nil
+		}
+		if !strings.HasPrefix(f.varName, customPrefix) {
+			return fmt.Errorf("form: field %s is not registered for form type %s and does not begin with %q", f.varName, formType, customPrefix)
+		}
+		return nil
+	}
+	for _, f := range d.fields {
+		err := check(f)
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range d.reported {
+		err := check(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
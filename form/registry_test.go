@@ -0,0 +1,80 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form_test
+
+import (
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/form"
+)
+
+var checkTestCases = [...]struct {
+	Data *form.Data
+	Err  bool
+}{
+	0: {
+		// Forms without a form type are not checked.
+		Data: form.New(form.Text("anything")),
+	},
+	1: {
+		// Forms with an unregistered form type are not checked.
+		Data: form.New(form.FormType("urn:unregistered"), form.Text("anything")),
+	},
+	2: {
+		Data: form.New(
+			form.FormType("urn:example"),
+			form.Fixed(form.Label("Section")),
+			form.Text("example#name"),
+			form.Boolean("example#enabled"),
+			form.Text("x-custom"),
+		),
+	},
+	3: {
+		Data: form.New(form.FormType("urn:example"), form.Text("custom")),
+		Err:  true,
+	},
+	4: {
+		Data: form.New(form.FormType("urn:example"), form.Text("example#enabled")),
+		Err:  true,
+	},
+	5: {
+		Data: form.New(
+			form.FormType("urn:example"),
+			form.Reported(form.Text("example#name"), form.JID("jid")),
+		),
+		Err: true,
+	},
+}
+
+func TestCheck(t *testing.T) {
+	r := &form.Registry{}
+	r.Register("urn:example",
+		form.FieldData{Var: "example#name"},
+		form.FieldData{Var: "example#enabled", Type: form.TypeBoolean},
+	)
+	for i, tc := range checkTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := r.Check(tc.Data)
+			switch {
+			case tc.Err && err == nil:
+				t.Errorf("expected error checking form")
+			case !tc.Err && err != nil:
+				t.Errorf("unexpected error checking form: %v", err)
+			}
+		})
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	r := &form.Registry{}
+	r.Register("urn:example")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected duplicate registration to panic")
+		}
+	}()
+	r.Register("urn:example")
+}
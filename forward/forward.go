@@ -7,6 +7,9 @@ package forward // import "mellium.im/xmpp/forward"
 
 import (
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"mellium.im/xmlstream"
@@ -19,8 +22,13 @@ const (
 	NS = "urn:xmpp:forward:0"
 )
 
+// ErrNoStanza is returned by Unwrap if the forwarded element ends before a
+// stanza is found.
+var ErrNoStanza = errors.New("forward: no stanza found in forwarded element")
+
 // Forwarded can be embedded into another struct along with a stanza to wrap the
 // stanza for forwarding.
+// If the time of the delay is the zero value, the delay is omitted.
 type Forwarded struct {
 	XMLName xml.Name    `xml:"urn:xmpp:forward:0 forwarded"`
 	Delay   delay.Delay `xml:"urn:xmpp:delay delay"`
@@ -28,13 +36,19 @@ type Forwarded struct {
 
 // Wrap wraps the provided token reader (which should be a stanza, but this is
 // not enforced) to prepare it for forwarding.
+// The inner stream is not buffered, so large stanzas may be forwarded without
+// reading them into memory first.
 func (f Forwarded) Wrap(r xml.TokenReader) xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Space: NS, Local: "forwarded"}}
+	if f.Delay.Time.IsZero() {
+		return xmlstream.Wrap(r, start)
+	}
 	return xmlstream.Wrap(
 		xmlstream.MultiReader(
 			f.Delay.TokenReader(),
 			r,
 		),
-		xml.StartElement{Name: xml.Name{Space: NS, Local: "forwarded"}},
+		start,
 	)
 }
 
@@ -64,3 +78,55 @@ func Wrap(msg stanza.Message, body string, received time.Time, r xml.TokenReader
 		}.Wrap(r),
 	))
 }
+
+// Unwrap reads the start of a forwarded element from r, decodes any delay into
+// f, and returns a token reader over the forwarded stanza.
+//
+// The returned reader streams the stanza directly from r, so it must be
+// consumed before r is used again.
+// Once it has been consumed, the end of the forwarded element (and anything
+// after the stanza) remains to be read from r.
+// If no stanza is found before the end of the forwarded element, ErrNoStanza is
+// returned.
+func Unwrap(f *Forwarded, r xml.TokenReader) (xml.TokenReader, error) {
+	tok, err := r.Token()
+	if err != nil {
+		return nil, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name.Space != NS || start.Name.Local != "forwarded" {
+		return nil, fmt.Errorf("forward: expected forwarded element, got %T", tok)
+	}
+	f.XMLName = start.Name
+
+	for {
+		tok, err = r.Token()
+		switch {
+		case err == io.EOF:
+			return nil, ErrNoStanza
+		case err != nil:
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == delay.NS && t.Name.Local == "delay" {
+				err = xml.NewTokenDecoder(xmlstream.MultiReader(
+					xmlstream.Token(t),
+					xmlstream.Inner(r),
+					xmlstream.Token(t.End()),
+				)).Decode(&f.Delay)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return xmlstream.MultiReader(
+				xmlstream.Token(t),
+				xmlstream.Inner(r),
+				xmlstream.Token(t.End()),
+			), nil
+		case xml.EndElement:
+			return nil, ErrNoStanza
+		}
+	}
+}
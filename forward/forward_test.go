@@ -6,11 +6,13 @@ package forward_test
 
 import (
 	"encoding/xml"
+	"errors"
 	"strings"
 	"testing"
 	"time"
 
 	"mellium.im/xmlstream"
+	"mellium.im/xmpp/delay"
 	"mellium.im/xmpp/forward"
 	"mellium.im/xmpp/stanza"
 )
@@ -31,14 +33,16 @@ func TestWrap(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error flushing: %v", err)
 	}
-	const expected = `<message type="normal"><body>foo</body><forwarded xmlns="urn:xmpp:forward:0"><delay xmlns="urn:xmpp:delay" stamp="0001-01-01T00:00:00Z"></delay><foo></foo></forwarded></message>`
+	const expected = `<message type="normal"><body>foo</body><forwarded xmlns="urn:xmpp:forward:0"><foo></foo></forwarded></message>`
 	if out := buf.String(); out != expected {
 		t.Fatalf("wrong output:\nwant=%s,\n got=%s", expected, out)
 	}
 }
 
 func TestMarshal(t *testing.T) {
-	f := forward.Forwarded{}
+	f := forward.Forwarded{
+		Delay: delay.Delay{Time: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
 	var buf strings.Builder
 	e := xml.NewEncoder(&buf)
 	_, err := f.WriteXML(e)
@@ -49,8 +53,52 @@ func TestMarshal(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error flushing: %v", err)
 	}
-	const expected = `<forwarded xmlns="urn:xmpp:forward:0"><delay xmlns="urn:xmpp:delay" stamp="0001-01-01T00:00:00Z"></delay></forwarded>`
+	const expected = `<forwarded xmlns="urn:xmpp:forward:0"><delay xmlns="urn:xmpp:delay" stamp="2021-01-02T03:04:05Z"></delay></forwarded>`
 	if out := buf.String(); out != expected {
 		t.Fatalf("wrong output:\nwant=%s,\n got=%s", expected, out)
 	}
 }
+
+func TestUnwrap(t *testing.T) {
+	const input = `<forwarded xmlns="urn:xmpp:forward:0"> <delay xmlns="urn:xmpp:delay" stamp="2021-01-02T03:04:05Z">reason</delay><message xmlns="jabber:client" type="chat"><body>hi</body></message></forwarded><next/>`
+	d := xml.NewDecoder(strings.NewReader(input))
+	var f forward.Forwarded
+	// Hide the concrete type of the decoder so that Unwrap can't reuse it.
+	r, err := forward.Unwrap(&f, struct{ xml.TokenReader }{d})
+	if err != nil {
+		t.Fatalf("error unwrapping: %v", err)
+	}
+	if want := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC); !f.Delay.Time.Equal(want) || f.Delay.Reason != "reason" {
+		t.Errorf("wrong delay: want time=%v, reason=reason, got=%+v", want, f.Delay)
+	}
+
+	msg := struct {
+		stanza.Message
+		Body string `xml:"body"`
+	}{}
+	err = xml.NewTokenDecoder(r).Decode(&msg)
+	if err != nil {
+		t.Fatalf("error decoding forwarded stanza: %v", err)
+	}
+	if msg.Type != stanza.ChatMessage || msg.Body != "hi" {
+		t.Errorf("wrong forwarded message: %+v", msg)
+	}
+
+	// The end of the forwarded element should be left on the stream.
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("error reading remaining tokens: %v", err)
+	}
+	if end, ok := tok.(xml.EndElement); !ok || end.Name.Local != "forwarded" {
+		t.Errorf("expected end of forwarded element, got %#v", tok)
+	}
+}
+
+func TestUnwrapNoStanza(t *testing.T) {
+	const input = `<forwarded xmlns="urn:xmpp:forward:0"><delay xmlns="urn:xmpp:delay" stamp="2021-01-02T03:04:05Z"/></forwarded>`
+	var f forward.Forwarded
+	_, err := forward.Unwrap(&f, xml.NewDecoder(strings.NewReader(input)))
+	if !errors.Is(err, forward.ErrNoStanza) {
+		t.Errorf("wrong error: want=%v, got=%v", forward.ErrNoStanza, err)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package gateway contains helpers for writing gateways (also called
+// transports) between XMPP and other networks as described in XEP-0100:
+// Gateway Interaction.
+//
+// Gateways are normally written as components (see the component package) and
+// map contacts on the remote network to addresses at the component's domain,
+// for example the remote user "romeo" might become romeo@gateway.example.net.
+// Because these contacts do not exist on an XMPP server, the gateway has to
+// emulate the parts of a server that XMPP users expect their contacts to have,
+// such as answering presence probes and keeping track of subscriptions.
+package gateway // import "mellium.im/xmpp/gateway"
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/storage"
+)
+
+// Subscription is the state of the presence subscription between an XMPP user
+// and a contact on the remote network from the point of view of the user's
+// roster.
+type Subscription uint8
+
+// A list of subscription states.
+const (
+	// None means neither the user nor the contact receive each others presence.
+	None Subscription = 0
+
+	// To means the user receives the contact's presence.
+	To Subscription = 1 << iota
+
+	// From means the contact receives the user's presence.
+	From
+
+	// Both means the user and the contact receive each others presence.
+	Both = To | From
+)
+
+// String returns the subscription state as it would appear in the
+// subscription attribute of a roster item.
+func (s Subscription) String() string {
+	switch s & Both {
+	case To:
+		return "to"
+	case From:
+		return "from"
+	case Both:
+		return "both"
+	}
+	return "none"
+}
+
+// Store stores the subscription state of every user and mapped contact.
+// User and contact addresses are always bare JIDs.
+type Store interface {
+	// Subscription returns the current subscription state between user and
+	// contact.
+	// Unknown pairs should be reported as None.
+	Subscription(user, contact jid.JID) (Subscription, error)
+
+	// SetSubscription changes the subscription state between user and contact.
+	SetSubscription(user, contact jid.JID, sub Subscription) error
+}
+
+// Roster emulates the roster of remote contacts on behalf of a gateway.
+// It handles presence probes and subscription requests sent by XMPP users to
+// contacts at the gateway's domain.
+// Presence sent to the gateway itself and non-subscription related presence is
+// ignored so that it can be handled by the gateway.
+type Roster struct {
+	// Store is used to record subscription states.
+	Store Store
+
+	// Approve reports whether the user may subscribe to the contact's presence.
+	// If Approve is nil all requests are approved.
+	Approve func(user, contact jid.JID) bool
+
+	// Status returns the current presence of the contact on the remote network
+	// as it should be sent to user (normally available or unavailable) and an
+	// optional payload such as a show or status element.
+	// If Status is nil, contacts are always reported as unavailable.
+	Status func(user, contact jid.JID) (stanza.PresenceType, xml.TokenReader)
+}
+
+// HandlePresence implements mux.PresenceHandler.
+func (r Roster) HandlePresence(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+	if p.To.Localpart() == "" {
+		return nil
+	}
+	user := p.From.Bare()
+	contact := p.To.Bare()
+
+	switch p.Type {
+	case stanza.ProbePresence:
+		sub, err := r.Store.Subscription(user, contact)
+		if err != nil {
+			return err
+		}
+		if sub&To == 0 {
+			return r.send(t, p, stanza.UnsubscribedPresence, nil)
+		}
+		return r.sendStatus(t, p)
+	case stanza.SubscribePresence:
+		sub, err := r.Store.Subscription(user, contact)
+		if err != nil {
+			return err
+		}
+		if sub&To == 0 {
+			if r.Approve != nil && !r.Approve(user, contact) {
+				return r.send(t, p, stanza.UnsubscribedPresence, nil)
+			}
+			err = r.Store.SetSubscription(user, contact, sub|To)
+			if err != nil {
+				return err
+			}
+		}
+		err = r.send(t, p, stanza.SubscribedPresence, nil)
+		if err != nil {
+			return err
+		}
+		err = r.sendStatus(t, p)
+		if err != nil {
+			return err
+		}
+		// Ask for the users presence in return so that it can be relayed to the
+		// remote network.
+		if sub&From == 0 {
+			return r.send(t, p, stanza.SubscribePresence, nil)
+		}
+		return nil
+	case stanza.SubscribedPresence:
+		return r.update(user, contact, From, true)
+	case stanza.UnsubscribePresence:
+		err := r.update(user, contact, To, false)
+		if err != nil {
+			return err
+		}
+		return r.send(t, p, stanza.UnavailablePresence, nil)
+	case stanza.UnsubscribedPresence:
+		return r.update(user, contact, From, false)
+	}
+	return nil
+}
+
+func (r Roster) update(user, contact jid.JID, bit Subscription, set bool) error {
+	sub, err := r.Store.Subscription(user, contact)
+	if err != nil {
+		return err
+	}
+	newSub := sub &^ bit
+	if set {
+		newSub = sub | bit
+	}
+	if newSub == sub {
+		return nil
+	}
+	return r.Store.SetSubscription(user, contact, newSub)
+}
+
+func (r Roster) sendStatus(t xmlstream.TokenWriter, p stanza.Presence) error {
+	if r.Status == nil {
+		return r.send(t, p, stanza.UnavailablePresence, nil)
+	}
+	typ, payload := r.Status(p.From.Bare(), p.To.Bare())
+	return r.send(t, p, typ, payload)
+}
+
+// send replies to p with a presence of the given type from the contact.
+func (Roster) send(t xmlstream.TokenWriter, p stanza.Presence, typ stanza.PresenceType, payload xml.TokenReader) error {
+	from := p.To.Bare()
+	to := p.From
+	// Subscription related presence is always addressed to the bare JID.
+	if typ != stanza.AvailablePresence && typ != stanza.UnavailablePresence {
+		to = to.Bare()
+	}
+	_, err := xmlstream.Copy(t, stanza.Presence{
+		From: from,
+		To:   to,
+		Type: typ,
+	}.Wrap(payload))
+	return err
+}
+
+// kvPrefix is prepended to the keys used by KVStore so that the same KV can be
+// shared with other packages.
+const kvPrefix = "gateway/subscription/"
+
+// KVStore returns a Store that stores subscription states in kv under keys
+// starting with "gateway/subscription/".
+func KVStore(kv storage.KV) Store {
+	return kvStore{kv: kv}
+}
+
+type kvStore struct {
+	kv storage.KV
+}
+
+func (s kvStore) key(user, contact jid.JID) string {
+	// Bare JIDs cannot contain spaces, so this is unambiguous.
+	return kvPrefix + user.Bare().String() + " " + contact.Bare().String()
+}
+
+func (s kvStore) Subscription(user, contact jid.JID) (Subscription, error) {
+	v, err := s.kv.Get(s.key(user, contact))
+	switch {
+	case err == storage.ErrNotFound:
+		return None, nil
+	case err != nil:
+		return None, err
+	case len(v) != 1:
+		return None, fmt.Errorf("gateway: invalid stored subscription for %v", contact)
+	}
+	return Subscription(v[0]), nil
+}
+
+func (s kvStore) SetSubscription(user, contact jid.JID, sub Subscription) error {
+	if sub == None {
+		return s.kv.Delete(s.key(user, contact))
+	}
+	return s.kv.Put(s.key(user, contact), []byte{byte(sub)})
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package gateway_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/gateway"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/storage"
+)
+
+const (
+	user    = "juliet@example.com"
+	contact = "romeo@gateway.example.net"
+)
+
+var rosterTests = [...]struct {
+	typ     stanza.PresenceType
+	to      string
+	sub     gateway.Subscription
+	deny    bool
+	out     string
+	wantSub gateway.Subscription
+}{
+	0: {
+		typ: stanza.ProbePresence,
+		to:  contact,
+		out: `<presence to="juliet@example.com" from="romeo@gateway.example.net" type="unsubscribed"></presence>`,
+	},
+	1: {
+		typ:     stanza.ProbePresence,
+		to:      contact,
+		sub:     gateway.To,
+		out:     `<presence to="juliet@example.com/balcony" from="romeo@gateway.example.net"><show>away</show></presence>`,
+		wantSub: gateway.To,
+	},
+	2: {
+		typ:     stanza.SubscribePresence,
+		to:      contact,
+		out:     `<presence to="juliet@example.com" from="romeo@gateway.example.net" type="subscribed"></presence><presence to="juliet@example.com/balcony" from="romeo@gateway.example.net"><show>away</show></presence><presence to="juliet@example.com" from="romeo@gateway.example.net" type="subscribe"></presence>`,
+		wantSub: gateway.To,
+	},
+	3: {
+		typ:     stanza.SubscribePresence,
+		to:      contact,
+		sub:     gateway.Both,
+		out:     `<presence to="juliet@example.com" from="romeo@gateway.example.net" type="subscribed"></presence><presence to="juliet@example.com/balcony" from="romeo@gateway.example.net"><show>away</show></presence>`,
+		wantSub: gateway.Both,
+	},
+	4: {
+		typ:  stanza.SubscribePresence,
+		to:   contact,
+		deny: true,
+		out:  `<presence to="juliet@example.com" from="romeo@gateway.example.net" type="unsubscribed"></presence>`,
+	},
+	5: {
+		typ:     stanza.SubscribedPresence,
+		to:      contact,
+		sub:     gateway.To,
+		wantSub: gateway.Both,
+	},
+	6: {
+		typ:     stanza.UnsubscribePresence,
+		to:      contact,
+		sub:     gateway.Both,
+		out:     `<presence to="juliet@example.com/balcony" from="romeo@gateway.example.net" type="unavailable"></presence>`,
+		wantSub: gateway.From,
+	},
+	7: {
+		typ:     stanza.UnsubscribedPresence,
+		to:      contact,
+		sub:     gateway.Both,
+		wantSub: gateway.To,
+	},
+	8: {
+		typ:     stanza.AvailablePresence,
+		to:      contact,
+		sub:     gateway.Both,
+		wantSub: gateway.Both,
+	},
+	9: {
+		typ: stanza.ProbePresence,
+		to:  "gateway.example.net",
+	},
+}
+
+func TestRoster(t *testing.T) {
+	for i, tc := range rosterTests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			userJID := jid.MustParse(user)
+			contactJID := jid.MustParse(contact)
+			store := gateway.KVStore(&storage.Mem{})
+			if tc.sub != gateway.None {
+				/* #nosec */
+				store.SetSubscription(userJID, contactJID, tc.sub)
+			}
+			r := gateway.Roster{
+				Store: store,
+				Approve: func(jid.JID, jid.JID) bool {
+					return !tc.deny
+				},
+				Status: func(jid.JID, jid.JID) (stanza.PresenceType, xml.TokenReader) {
+					return stanza.AvailablePresence, xmlstream.Wrap(
+						xmlstream.Token(xml.CharData("away")),
+						xml.StartElement{Name: xml.Name{Local: "show"}},
+					)
+				},
+			}
+			var b bytes.Buffer
+			e := xml.NewEncoder(&b)
+			err := r.HandlePresence(stanza.Presence{
+				From: jid.MustParse(user + "/balcony"),
+				To:   jid.MustParse(tc.to),
+				Type: tc.typ,
+			}, struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: xml.NewDecoder(strings.NewReader("")),
+				Encoder:     e,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			if out := b.String(); out != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+			sub, _ := store.Subscription(userJID, contactJID)
+			if sub != tc.wantSub {
+				t.Errorf("wrong subscription: want=%v, got=%v", tc.wantSub, sub)
+			}
+		})
+	}
+}
+
+func TestSubscriptionString(t *testing.T) {
+	for sub, want := range map[gateway.Subscription]string{
+		gateway.None: "none",
+		gateway.To:   "to",
+		gateway.From: "from",
+		gateway.Both: "both",
+	} {
+		if s := sub.String(); s != want {
+			t.Errorf("wrong string for %d: want=%q, got=%q", sub, want, s)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package groupchat contains an interface for group chats that is independent
+// of the underlying protocol.
+//
+// Both Multi-User Chat rooms and MIX channels implement Channel.
+// The muc and mix packages each provide an Opener that can be passed to Open,
+// which uses service discovery to pick the protocol supported by the service
+// that hosts the channel:
+//
+//     ch, err := groupchat.Open(ctx, s, addr, []string{mix.NS, muc.NS}, map[string]groupchat.Opener{
+//         mix.NS: mix.Open,
+//         muc.NS: muc.Open,
+//     })
+//
+// Protocol specific functionality remains available by type asserting the
+// Channel to *muc.Channel or *mix.Channel.
+//
+// Both protocols keep the history of a channel in an archive that is queried
+// using Message Archive Management, so history is fetched the same way for
+// either protocol with the mam package:
+//
+//     iter := archive.Fetch(ctx, mam.Query{Start: since, Limit: 50}, ch.Addr(), s)
+package groupchat // import "mellium.im/xmpp/groupchat"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sort"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/jid"
+)
+
+// ErrUnsupported is returned by Open if the service does not advertise support
+// for any of the protocols it was given.
+var ErrUnsupported = errors.New("groupchat: no supported protocol")
+
+// Member is a participant in a channel.
+type Member struct {
+	// Addr is the address of the member inside the channel (the occupant JID for
+	// MUC or the proxy JID for MIX).
+	Addr jid.JID
+
+	// JID is the real address of the member if it is known.
+	JID  jid.JID
+	Nick string
+}
+
+// Channel is a group chat that the session has joined or can join.
+type Channel interface {
+	// Addr returns the bare address of the channel.
+	Addr() jid.JID
+
+	// Join joins the channel using the provided nickname.
+	Join(ctx context.Context, nick string) error
+
+	// Leave leaves the channel.
+	// The status may be shown to other members if the protocol supports it.
+	Leave(ctx context.Context, status string) error
+
+	// Send sends a message with the provided payload to all members of the
+	// channel.
+	// The message type and address are set by the implementation.
+	Send(ctx context.Context, payload xml.TokenReader) error
+
+	// Members returns the current members of the channel.
+	Members(ctx context.Context) ([]Member, error)
+
+	// Subject returns the current subject of the channel.
+	Subject(ctx context.Context) (string, error)
+
+	// SetSubject changes the subject of the channel.
+	SetSubject(ctx context.Context, subject string) error
+}
+
+// Opener returns a Channel for the provided address using a specific
+// protocol.
+type Opener func(s *xmpp.Session, addr jid.JID) Channel
+
+// Open queries the service that hosts addr for its features and uses the first
+// opener whose feature is advertised.
+// The keys of openers are service discovery features (for example, muc.NS and
+// mix.NS).
+// If multiple features are supported, prefer gives the order to try them in.
+// Features that are not in prefer are tried afterwards in lexical order.
+func Open(ctx context.Context, s *xmpp.Session, addr jid.JID, prefer []string, openers map[string]Opener) (Channel, error) {
+	addr = addr.Bare()
+	info, err := disco.GetInfo(ctx, "", addr, s)
+	if err != nil {
+		return nil, err
+	}
+	features := make(map[string]struct{}, len(info.Features))
+	for _, f := range info.Features {
+		features[f.Var] = struct{}{}
+	}
+
+	order := append([]string(nil), prefer...)
+	rest := make([]string, 0, len(openers))
+	for feature := range openers {
+		rest = append(rest, feature)
+	}
+	sort.Strings(rest)
+	order = append(order, rest...)
+	for _, feature := range order {
+		open, ok := openers[feature]
+		if !ok {
+			continue
+		}
+		if _, ok := features[feature]; ok {
+			return open(s, addr), nil
+		}
+	}
+	return nil, ErrUnsupported
+}
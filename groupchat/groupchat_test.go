@@ -0,0 +1,105 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package groupchat_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/groupchat"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var openTestCases = [...]struct {
+	features []string
+	prefer   []string
+	want     string
+	err      error
+}{
+	0: {
+		features: []string{"muc", "mix"},
+		prefer:   []string{"mix", "muc"},
+		want:     "mix",
+	},
+	1: {
+		features: []string{"muc", "mix"},
+		prefer:   []string{"muc"},
+		want:     "muc",
+	},
+	2: {
+		features: []string{"muc", "mix"},
+		want:     "mix",
+	},
+	3: {
+		features: []string{"muc"},
+		prefer:   []string{"mix", "muc"},
+		want:     "muc",
+	},
+	4: {
+		features: []string{"muc"},
+		prefer:   []string{"other"},
+		want:     "muc",
+	},
+	5: {
+		features: []string{"other"},
+		err:      groupchat.ErrUnsupported,
+	},
+}
+
+type namedChannel struct {
+	groupchat.Channel
+	name string
+	addr jid.JID
+}
+
+func TestOpen(t *testing.T) {
+	for i, tc := range openTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m := mux.New(mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				var b strings.Builder
+				for _, f := range tc.features {
+					b.WriteString(`<feature var="` + f + `"/>`)
+				}
+				d := xml.NewDecoder(strings.NewReader(`<query xmlns="http://jabber.org/protocol/disco#info">` + b.String() + `</query>`))
+				_, err := xmlstream.Copy(t, iq.Result(d))
+				return err
+			}))
+			cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+			defer cs.Close()
+
+			opener := func(name string) groupchat.Opener {
+				return func(_ *xmpp.Session, addr jid.JID) groupchat.Channel {
+					return namedChannel{name: name, addr: addr}
+				}
+			}
+			ch, err := groupchat.Open(context.Background(), cs.Client, jid.MustParse("coven@example.net/thirdwitch"), tc.prefer, map[string]groupchat.Opener{
+				"muc": opener("muc"),
+				"mix": opener("mix"),
+			})
+			if err != tc.err {
+				t.Fatalf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			if tc.err != nil {
+				return
+			}
+			named := ch.(namedChannel)
+			if named.name != tc.want {
+				t.Errorf("wrong opener used: want=%s, got=%s", tc.want, named.name)
+			}
+			if s := named.addr.String(); s != "coven@example.net" {
+				t.Errorf("expected opener to be passed the bare address, got %s", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package hashes implements XEP-0300: Use of Cryptographic Hash Functions in
+// XMPP.
+package hashes // import "mellium.im/xmpp/hashes"
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+)
+
+// NS is the namespace used by this package.
+const NS = `urn:xmpp:hashes:2`
+
+// A list of hash function names from the IANA Hash Function Textual Names
+// registry that are recommended for use in XMPP.
+const (
+	SHA256     = "sha-256"
+	SHA512     = "sha-512"
+	SHA3256    = "sha3-256"
+	SHA3512    = "sha3-512"
+	BLAKE2b256 = "blake2b-256"
+	BLAKE2b512 = "blake2b-512"
+)
+
+// Hash is the output of a hash function along with the name of the function
+// that was used to create it.
+type Hash struct {
+	XMLName xml.Name `xml:"urn:xmpp:hashes:2 hash"`
+	Algo    string   `xml:"algo,attr"`
+	Value   []byte   `xml:"-"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (h Hash) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(h.Value))),
+		xml.StartElement{
+			Name: xml.Name{Space: NS, Local: "hash"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "algo"}, Value: h.Algo}},
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (h Hash) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, h.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (h Hash) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := h.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (h *Hash) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		XMLName xml.Name `xml:"urn:xmpp:hashes:2 hash"`
+		Algo    string   `xml:"algo,attr"`
+		Value   string   `xml:",chardata"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	h.XMLName = s.XMLName
+	h.Algo = s.Algo
+	h.Value, err = base64.StdEncoding.DecodeString(s.Value)
+	return err
+}
@@ -0,0 +1,42 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package hashes_test
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/hashes"
+	"mellium.im/xmpp/internal/xmpptest"
+)
+
+var (
+	_ xml.Marshaler       = hashes.Hash{}
+	_ xml.Unmarshaler     = (*hashes.Hash)(nil)
+	_ xmlstream.Marshaler = hashes.Hash{}
+	_ xmlstream.WriterTo  = hashes.Hash{}
+)
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &hashes.Hash{
+				XMLName: xml.Name{Space: hashes.NS, Local: "hash"},
+				Algo:    hashes.SHA256,
+				Value:   []byte("test"),
+			},
+			XML: `<hash xmlns="urn:xmpp:hashes:2" algo="sha-256">dGVzdA==</hash>`,
+		},
+		1: {
+			Value: &hashes.Hash{
+				XMLName: xml.Name{Space: hashes.NS, Local: "hash"},
+				Algo:    hashes.BLAKE2b256,
+				Value:   []byte{},
+			},
+			XML: `<hash xmlns="urn:xmpp:hashes:2" algo="blake2b-256"></hash>`,
+		},
+	})
+}
@@ -0,0 +1,299 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package hints implements XEP-0334: Message Processing Hints.
+//
+// Hints let the sender of a message tell servers and other entities that handle
+// it whether it should be stored or copied to other resources.
+// Besides adding hints to outgoing messages, this package can be used by
+// entities that store messages for later delivery (for example, components
+// that implement offline storage) to decide whether a message may be stored.
+// The decision combines the hints in the message with its type and the rules
+// from RFC 6121 and XEP-0160: Best Practices for Handling Offline Messages.
+package hints // import "mellium.im/xmpp/hints"
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by message processing hints.
+// It is provided as a convenience.
+const NS = "urn:xmpp:hints"
+
+// Hint is a bitmask of processing hints.
+type Hint uint8
+
+// A list of possible hints.
+const (
+	// NoPermanentStore indicates that the message may be stored temporarily for
+	// later delivery but must not be archived.
+	NoPermanentStore Hint = 1 << iota
+
+	// NoStore indicates that the message must not be stored at all, not even
+	// temporarily for later delivery.
+	NoStore
+
+	// NoCopy indicates that the message must not be copied to other resources,
+	// for example using message carbons.
+	NoCopy
+
+	// Store indicates that the message should be stored even if it would not be
+	// stored otherwise, for example because it has no body.
+	Store
+)
+
+var hintNames = [...]string{
+	"no-permanent-store",
+	"no-store",
+	"no-copy",
+	"store",
+}
+
+// TokenReader implements xmlstream.Marshaler.
+// It returns an element for each hint that is set.
+func (h Hint) TokenReader() xml.TokenReader {
+	var readers []xml.TokenReader
+	for i, name := range hintNames {
+		if h&(1<<uint(i)) == 0 {
+			continue
+		}
+		readers = append(readers, xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Space: NS, Local: name}},
+		))
+	}
+	return xmlstream.MultiReader(readers...)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (h Hint) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, h.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (h Hint) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := h.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// The hint named by start is added to h.
+// If start is not a hint the element is skipped and h is not modified.
+func (h *Hint) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if start.Name.Space == NS {
+		for i, name := range hintNames {
+			if name == start.Name.Local {
+				*h |= 1 << uint(i)
+				break
+			}
+		}
+	}
+	return d.Skip()
+}
+
+// Storage is how a message may be stored by an entity that is unable to deliver
+// it immediately.
+type Storage uint8
+
+// A list of possible storage decisions, from most to least restrictive.
+const (
+	// Discard messages must not be stored.
+	Discard Storage = iota
+
+	// Offline messages may be stored temporarily and delivered later, but must
+	// not be archived.
+	Offline
+
+	// Archive messages may be stored for later delivery and archived.
+	Archive
+)
+
+// Message contains the parts of a message stanza that affect how it may be
+// stored.
+type Message struct {
+	stanza.Message
+
+	// Hints contains all processing hints found in the message.
+	Hints Hint
+
+	// Body is true if the message has a body.
+	Body bool
+}
+
+// Read reads a message stanza from r, including the start element, and
+// returns the parts of it that affect how it may be stored.
+// The message is consumed.
+func Read(r xml.TokenReader) (Message, error) {
+	var m Message
+	tok, err := r.Token()
+	if err != nil {
+		return m, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name.Local != "message" {
+		return m, errors.New("hints: expected message start element")
+	}
+	m.Message, err = stanza.NewMessage(start)
+	if err != nil {
+		return m, err
+	}
+
+	for {
+		tok, err := r.Token()
+		switch {
+		case err == io.EOF && tok == nil:
+			return m, io.ErrUnexpectedEOF
+		case err != nil && err != io.EOF:
+			return m, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Space {
+			case NS:
+				for i, name := range hintNames {
+					if t.Name.Local == name {
+						m.Hints |= 1 << uint(i)
+					}
+				}
+			case "", ns.Client, ns.Server:
+				if t.Name.Local == "body" {
+					m.Body = true
+				}
+			}
+			err = xmlstream.Skip(r)
+			if err != nil {
+				return m, err
+			}
+		case xml.EndElement:
+			return m, nil
+		}
+	}
+}
+
+// Storage reports how m may be stored.
+//
+// Error messages and messages with the NoStore hint are never stored.
+// Otherwise, messages with the Store hint or that are normal or chat messages
+// with a body may be stored, and may also be archived unless they have the
+// NoPermanentStore hint.
+// Groupchat and headline messages, and messages without a body (such as chat
+// state notifications), are only stored if they have the Store hint.
+func (m Message) Storage() Storage {
+	switch {
+	case m.Type == stanza.ErrorMessage || m.Hints&NoStore != 0:
+		return Discard
+	case m.Hints&Store == 0 && (m.Type == stanza.GroupChatMessage || m.Type == stanza.HeadlineMessage || !m.Body):
+		return Discard
+	case m.Hints&NoPermanentStore != 0:
+		return Offline
+	}
+	return Archive
+}
+
+// bounce reports whether the sender of m should be told that it could not be
+// delivered if it is not stored.
+// RFC 6121 requires this for normal, chat, and groupchat messages, but
+// messages without a body are silently dropped since they are usually
+// notifications that the sender does not expect to be stored.
+func (m Message) bounce() bool {
+	switch m.Type {
+	case stanza.ErrorMessage, stanza.HeadlineMessage:
+		return false
+	case stanza.GroupChatMessage:
+		return true
+	}
+	return m.Body
+}
+
+// Handler is an xmpp.Handler for entities that store messages for later
+// delivery, such as components that implement offline storage.
+// It should only be used for messages that cannot be delivered immediately.
+//
+// Each message that may be stored is passed to Store.
+// Messages that must not be stored are dropped and, if required by RFC 6121, a
+// service-unavailable error is returned to the sender.
+type Handler struct {
+	// Store is called with each message that may be stored, how it may be
+	// stored, and a token reader containing the entire message.
+	Store func(m Message, s Storage, r xml.TokenReader) error
+}
+
+// HandleXMPP implements xmpp.Handler.
+func (h Handler) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if start.Name.Local != "message" {
+		return nil
+	}
+
+	var toks []xml.Token
+	for {
+		tok, err := t.Token()
+		if tok != nil {
+			toks = append(toks, xml.CopyToken(tok))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	full := func() xml.TokenReader {
+		return xmlstream.MultiReader(
+			xmlstream.Token(*start),
+			xmlstream.ReaderFunc(tokenReader(toks)),
+		)
+	}
+
+	m, err := Read(full())
+	if err != nil {
+		return err
+	}
+	storage := m.Storage()
+	if storage != Discard {
+		if h.Store == nil {
+			return nil
+		}
+		return h.Store(m, storage, full())
+	}
+	if !m.bounce() {
+		return nil
+	}
+
+	msg := m.Message
+	msg.To, msg.From = msg.From, msg.To
+	msg.Type = stanza.ErrorMessage
+	_, err = xmlstream.Copy(t, msg.Wrap(stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.ServiceUnavailable,
+	}.TokenReader()))
+	return err
+}
+
+func tokenReader(toks []xml.Token) func() (xml.Token, error) {
+	return func() (xml.Token, error) {
+		if len(toks) == 0 {
+			return nil, io.EOF
+		}
+		var tok xml.Token
+		tok, toks = toks[0], toks[1:]
+		return tok, nil
+	}
+}
+
+func init() {
+	for _, name := range hintNames {
+		stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: name}, func() interface{} {
+			return new(Hint)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package hints_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/hints"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestMarshal(t *testing.T) {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := (hints.NoStore | hints.NoCopy).WriteXML(e)
+	if err != nil {
+		t.Fatalf("error encoding hints: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<no-store xmlns="urn:xmpp:hints"></no-store><no-copy xmlns="urn:xmpp:hints"></no-copy>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+}
+
+var storageTests = [...]struct {
+	in      string
+	hints   hints.Hint
+	body    bool
+	storage hints.Storage
+	bounce  bool
+}{
+	0: {
+		in:      `<message type="chat"><body>hi</body></message>`,
+		body:    true,
+		storage: hints.Archive,
+	},
+	1: {
+		in:      `<message><body>hi</body><no-permanent-store xmlns="urn:xmpp:hints"/></message>`,
+		hints:   hints.NoPermanentStore,
+		body:    true,
+		storage: hints.Offline,
+	},
+	2: {
+		in:      `<message type="chat"><body>hi</body><no-store xmlns="urn:xmpp:hints"/><store xmlns="urn:xmpp:hints"/></message>`,
+		hints:   hints.NoStore | hints.Store,
+		body:    true,
+		storage: hints.Discard,
+		bounce:  true,
+	},
+	3: {
+		in:      `<message type="error"><body>hi</body><store xmlns="urn:xmpp:hints"/></message>`,
+		hints:   hints.Store,
+		body:    true,
+		storage: hints.Discard,
+	},
+	4: {
+		in:      `<message type="groupchat"><body>hi</body></message>`,
+		body:    true,
+		storage: hints.Discard,
+		bounce:  true,
+	},
+	5: {
+		in:      `<message type="groupchat"><body>hi</body><store xmlns="urn:xmpp:hints"/></message>`,
+		hints:   hints.Store,
+		body:    true,
+		storage: hints.Archive,
+	},
+	6: {
+		in:      `<message type="headline"><body>hi</body></message>`,
+		body:    true,
+		storage: hints.Discard,
+	},
+	7: {
+		in:      `<message type="chat"><active xmlns="http://jabber.org/protocol/chatstates"/></message>`,
+		storage: hints.Discard,
+	},
+	8: {
+		in:      `<message type="chat"><displayed xmlns="urn:xmpp:chat-markers:0" id="1"/><store xmlns="urn:xmpp:hints"/></message>`,
+		hints:   hints.Store,
+		storage: hints.Archive,
+	},
+	9: {
+		in:      `<message type="chat"><x xmlns="urn:example"><body>nested</body><no-store xmlns="urn:xmpp:hints"/></x></message>`,
+		storage: hints.Discard,
+	},
+}
+
+func TestStorage(t *testing.T) {
+	for i, tc := range storageTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m, err := hints.Read(xml.NewDecoder(strings.NewReader(tc.in)))
+			if err != nil {
+				t.Fatalf("error reading message: %v", err)
+			}
+			if m.Hints != tc.hints {
+				t.Errorf("wrong hints: want=%b, got=%b", tc.hints, m.Hints)
+			}
+			if m.Body != tc.body {
+				t.Errorf("wrong body: want=%t, got=%t", tc.body, m.Body)
+			}
+			if s := m.Storage(); s != tc.storage {
+				t.Errorf("wrong storage: want=%d, got=%d", tc.storage, s)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	for i, tc := range storageTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error reading start: %v", err)
+			}
+			start := tok.(xml.StartElement)
+
+			var stored bool
+			h := hints.Handler{
+				Store: func(m hints.Message, s hints.Storage, r xml.TokenReader) error {
+					stored = true
+					if s != tc.storage {
+						t.Errorf("wrong storage: want=%d, got=%d", tc.storage, s)
+					}
+					var buf bytes.Buffer
+					e := xml.NewEncoder(&buf)
+					_, err := xmlstream.Copy(e, r)
+					if err != nil {
+						return err
+					}
+					err = e.Flush()
+					if err != nil {
+						return err
+					}
+					if !strings.HasPrefix(buf.String(), "<message") || !strings.HasSuffix(buf.String(), "</message>") {
+						t.Errorf("stored message was incomplete: %s", &buf)
+					}
+					return nil
+				},
+			}
+
+			var out bytes.Buffer
+			e := xml.NewEncoder(&out)
+			err = h.HandleXMPP(struct {
+				xml.TokenReader
+				xmlstream.Encoder
+			}{
+				TokenReader: d,
+				Encoder:     e,
+			}, &start)
+			if err != nil {
+				t.Fatalf("error handling message: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+
+			if stored != (tc.storage != hints.Discard) {
+				t.Errorf("wrong stored value: want=%t, got=%t", !stored, stored)
+			}
+			bounced := strings.Contains(out.String(), "service-unavailable")
+			if bounced != tc.bounce {
+				t.Errorf("wrong bounce value: want=%t, got=%t: %s", tc.bounce, bounced, &out)
+			}
+		})
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><no-permanent-store xmlns="urn:xmpp:hints"/><no-store xmlns="urn:xmpp:hints"/><no-copy xmlns="urn:xmpp:hints"/><store xmlns="urn:xmpp:hints"/></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		hintPtr(hints.NoPermanentStore),
+		hintPtr(hints.NoStore),
+		hintPtr(hints.NoCopy),
+		hintPtr(hints.Store),
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
+
+func hintPtr(h hints.Hint) *hints.Hint {
+	return &h
+}
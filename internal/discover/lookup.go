@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 
@@ -192,6 +193,24 @@ func LookupBOSH(ctx context.Context, resolver *net.Resolver, client *http.Client
 	return lookupEndpoint(ctx, resolver, client, addr, boshConnType)
 }
 
+// SortByScheme sorts urls so that those using the secure scheme come first,
+// followed by those using the insecure scheme, followed by anything else.
+// The order of urls with the same scheme is preserved.
+func SortByScheme(urls []string, secure, insecure string) {
+	rank := func(u string) int {
+		switch {
+		case strings.HasPrefix(u, secure+":"):
+			return 0
+		case strings.HasPrefix(u, insecure+":"):
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(urls, func(i, j int) bool {
+		return rank(urls[i]) < rank(urls[j])
+	})
+}
+
 func lookupEndpoint(ctx context.Context, resolver *net.Resolver, client *http.Client, addr jid.JID, conntype string) (urls []string, err error) {
 	if conntype != wsConnType && conntype != boshConnType {
 		panic("xmpp.lookupEndpoint: Invalid conntype specified")
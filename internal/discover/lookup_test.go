@@ -7,6 +7,7 @@ package discover
 import (
 	"context"
 	"encoding/xml"
+	"reflect"
 	"testing"
 
 	"mellium.im/xmpp/jid"
@@ -80,3 +81,24 @@ func TestLookupMethodsDoNotRunIfContextIsDone(t *testing.T) {
 		t.Error("lookupHostMeta should not run if the context is canceled.")
 	}
 }
+
+func TestSortByScheme(t *testing.T) {
+	urls := []string{
+		"http://example.net/ws",
+		"ws://example.net/ws",
+		"wss://example.net/a",
+		"ws://example.net/b",
+		"wss://example.net/b",
+	}
+	SortByScheme(urls, "wss", "ws")
+	want := []string{
+		"wss://example.net/a",
+		"wss://example.net/b",
+		"ws://example.net/ws",
+		"ws://example.net/b",
+		"http://example.net/ws",
+	}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("wrong order:\nwant=%v,\n got=%v", want, urls)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Environment variables used by the socket activation protocol.
+const (
+	listenFDsEnv     = "LISTEN_FDS"
+	listenFDNamesEnv = "LISTEN_FDNAMES"
+	listenPIDEnv     = "LISTEN_PID"
+
+	// listenFDStart is the first file descriptor passed to the child process.
+	// 0, 1, and 2 are stdin, stdout, and stderr.
+	listenFDStart = 3
+)
+
+// SocketActivation is an option that passes the listeners reserved by
+// C2SListen, S2SListen, ComponentListen, HTTPListen, and HTTPSListen to the
+// command as inherited file descriptors using the systemd socket activation
+// protocol.
+// This lets servers that support socket activation accept connections on the
+// exact listener we created instead of closing it and letting the server
+// re-bind the same address, which is racy.
+//
+// Listeners are passed starting at file descriptor 3 and are named "c2s",
+// "s2s", "component", "http", and "https" in LISTEN_FDNAMES.
+// Listeners that were closed before the command started (for instance, by
+// packages that configure a server that does not support socket activation)
+// are skipped so that those servers continue to bind the port themselves.
+//
+// Because LISTEN_PID must match the PID of the process that receives the file
+// descriptors, the command is run through /bin/sh which sets LISTEN_PID to its
+// own PID before replacing itself with the command.
+// SocketActivation cannot be used with the Container option.
+// On Windows listeners cannot be inherited by the command, so this option has
+// no effect and servers continue to bind the port themselves.
+func SocketActivation() Option {
+	return func(cmd *Cmd) error {
+		cmd.activate = true
+		return nil
+	}
+}
+
+// activateSockets adds any open listeners to the commands extra files and
+// rewrites the command to set the socket activation environment variables.
+func (cmd *Cmd) activateSockets() error {
+	listeners := []struct {
+		name string
+		l    net.Listener
+	}{
+		{name: "c2s", l: cmd.c2sListener},
+		{name: "s2s", l: cmd.s2sListener},
+		{name: "c2s_direct_tls", l: cmd.c2sTLSListener},
+		{name: "s2s_direct_tls", l: cmd.s2sTLSListener},
+		{name: "component", l: cmd.compListener},
+		{name: "http", l: cmd.httpListener},
+		{name: "https", l: cmd.httpsListener},
+	}
+	var names []string
+	for _, l := range listeners {
+		if l.l == nil {
+			continue
+		}
+		filer, ok := l.l.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			continue
+		}
+		fd, err := filer.File()
+		if err != nil {
+			// The listener was most likely closed so that the command could bind
+			// the address itself.
+			continue
+		}
+		cmd.Cmd.ExtraFiles = append(cmd.Cmd.ExtraFiles, fd)
+		cmd.activated = append(cmd.activated, fd)
+		names = append(names, l.name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return err
+	}
+	env := cmd.Cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Cmd.Env = append(env,
+		listenFDsEnv+"="+strconv.Itoa(len(names)),
+		listenFDNamesEnv+"="+strings.Join(names, ":"),
+	)
+	cmd.Cmd.Args = append([]string{
+		"sh", "-c", listenPIDEnv + `=$$ exec "$@"`, "sh", cmd.Cmd.Path,
+	}, cmd.Cmd.Args[1:]...)
+	cmd.Cmd.Path = sh
+	return nil
+}
+
+// closeActivated closes the parents copies of any file descriptors that were
+// passed to the child process.
+func (cmd *Cmd) closeActivated() error {
+	var err error
+	for _, fd := range cmd.activated {
+		e := fd.Close()
+		if err == nil {
+			err = e
+		}
+	}
+	cmd.activated = nil
+	return err
+}
+
+// InheritedListeners returns any listeners passed to the current process using
+// the socket activation protocol keyed by their names.
+// Listeners without a name are keyed by their index.
+// It is meant to be used by commands that are started with the
+// SocketActivation option, such as test servers written in Go.
+func InheritedListeners() (map[string]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv(listenPIDEnv))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("integration: no listeners passed to this process")
+	}
+	n, err := strconv.Atoi(os.Getenv(listenFDsEnv))
+	if err != nil {
+		return nil, fmt.Errorf("integration: bad value for %s: %w", listenFDsEnv, err)
+	}
+	names := strings.Split(os.Getenv(listenFDNamesEnv), ":")
+
+	listeners := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		fd := os.NewFile(uintptr(listenFDStart+i), name)
+		l, err := net.FileListener(fd)
+		/* #nosec */
+		fd.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrStarted is returned by Apply when an option cannot be applied because the
+// command has already started.
+var ErrStarted = errors.New("integration: command already started")
+
+// Apply applies options to the command.
+//
+// Before the command is started Apply is the same as passing the options to
+// New.
+// After it has started, options that use Defer (such as those that call a
+// server's control tool to create users) are run immediately, and files
+// created with TempFile (such as certificates created by Cert) are written
+// immediately.
+// It is up to the test to restart or reload the command if the new files
+// must be read by it.
+// Options that change the arguments, environment, working directory, or config
+// of a running command would have no effect, so Apply returns an error that
+// wraps ErrStarted without writing any files or running any deferred
+// functions.
+func (cmd *Cmd) Apply(opts ...Option) error {
+	if cmd.Process == nil {
+		for _, opt := range opts {
+			err := opt(cmd)
+			if err != nil {
+				return fmt.Errorf("error applying option: %v", err)
+			}
+		}
+		return nil
+	}
+
+	prevCfgF, prevDeferF := cmd.cfgF, cmd.deferF
+	prevArgs := append([]string(nil), cmd.Cmd.Args...)
+	prevEnv := append([]string(nil), cmd.Cmd.Env...)
+	prevDir := cmd.Cmd.Dir
+	prevConfig := cmd.Config
+	// Options may modify maps in the config in place, so compare the formatted
+	// config instead of the value (fmt prints maps in sorted key order).
+	prevConfigStr := fmt.Sprintf("%#v", cmd.Config)
+	cmd.cfgF, cmd.deferF = nil, nil
+	defer func() {
+		cmd.cfgF, cmd.deferF = prevCfgF, prevDeferF
+	}()
+
+	for _, opt := range opts {
+		err := opt(cmd)
+		if err != nil {
+			return fmt.Errorf("error applying option: %v", err)
+		}
+	}
+
+	var changed string
+	switch {
+	case !reflect.DeepEqual(prevArgs, cmd.Cmd.Args):
+		changed = "arguments"
+	case !reflect.DeepEqual(prevEnv, cmd.Cmd.Env):
+		changed = "environment"
+	case prevDir != cmd.Cmd.Dir:
+		changed = "working directory"
+	case prevConfigStr != fmt.Sprintf("%#v", cmd.Config):
+		changed = "config"
+	}
+	if changed != "" {
+		cmd.Cmd.Args, cmd.Cmd.Env, cmd.Cmd.Dir = prevArgs, prevEnv, prevDir
+		cmd.Config = prevConfig
+		return fmt.Errorf("%w: cannot change the %s of a running command", ErrStarted, changed)
+	}
+
+	if cmd.cfgF != nil {
+		err := cmd.cfgF()
+		if err != nil {
+			return fmt.Errorf("error running config func: %w", err)
+		}
+	}
+	if cmd.deferF != nil {
+		return cmd.deferF(cmd)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"path/filepath"
+	"time"
+)
+
+// caFileName is the name of the file in the config directory that the root
+// certificate created by CertChain is written to.
+const caFileName = "ca.crt"
+
+// KeyType is the type of private key generated for certificates.
+type KeyType uint8
+
+// A list of supported key types.
+const (
+	// RSAKey is a 2048-bit RSA key.
+	// It is the default if the CertKey option is not used.
+	RSAKey KeyType = iota
+
+	// ECDSAKey is an ECDSA key using the NIST P-256 curve.
+	ECDSAKey
+
+	// Ed25519Key is an Ed25519 key.
+	Ed25519Key
+)
+
+// CertKey sets the type of private key generated by any Cert, ClientCert, or
+// CertChain options that come after it.
+func CertKey(t KeyType) Option {
+	return func(cmd *Cmd) error {
+		cmd.keyType = t
+		return nil
+	}
+}
+
+type certAuthority struct {
+	root     *x509.Certificate
+	rootDER  []byte
+	inter    *x509.Certificate
+	interDER []byte
+	interKey crypto.Signer
+}
+
+// CertChain creates a root certificate authority (CA), an intermediate CA
+// signed by the root, and a private key and certificate with the given name
+// signed by the intermediate CA.
+// The root and intermediate CAs are only created once per command and are
+// shared by all CertChain options.
+//
+// Like Cert, the key is written to name+".key" and the certificate is written
+// to name+".crt" followed by the intermediate CA so that servers present the
+// full chain.
+// The root CA is written to "ca.crt" in the config directory and can be
+// retrieved with RootCAs.
+func CertChain(name string) Option {
+	return func(cmd *Cmd) error {
+		if cmd.ca == nil {
+			err := newCA(cmd)
+			if err != nil {
+				return err
+			}
+		}
+		key, err := generateKey(cmd.keyType)
+		if err != nil {
+			return err
+		}
+		serial, err := serialNumber()
+		if err != nil {
+			return err
+		}
+		keyUsage := x509.KeyUsageDigitalSignature
+		if cmd.keyType == RSAKey {
+			keyUsage |= x509.KeyUsageKeyEncipherment
+		}
+		leaf, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: filepath.Base(name)},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+			DNSNames:     []string{filepath.Base(name)},
+			KeyUsage:     keyUsage,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}, cmd.ca.inter, key.Public(), cmd.ca.interKey)
+		if err != nil {
+			return err
+		}
+		err = writeKey(name, key)(cmd)
+		if err != nil {
+			return err
+		}
+		return writeCerts(name+".crt", leaf, cmd.ca.interDER)(cmd)
+	}
+}
+
+// RootCAs returns a pool containing the root CA created by CertChain.
+// It is meant to be used as the RootCAs field of a tls.Config so that the
+// certificate chain presented by the server can be verified.
+// If CertChain was not used, RootCAs returns nil.
+func (cmd *Cmd) RootCAs() *x509.CertPool {
+	if cmd.ca == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cmd.ca.root)
+	return pool
+}
+
+func newCA(cmd *Cmd) error {
+	rootKey, err := generateKey(cmd.keyType)
+	if err != nil {
+		return err
+	}
+	rootTmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Mellium Integration Test Root CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootTmpl.SerialNumber, err = serialNumber()
+	if err != nil {
+		return err
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, rootKey.Public(), rootKey)
+	if err != nil {
+		return err
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		return err
+	}
+
+	interKey, err := generateKey(cmd.keyType)
+	if err != nil {
+		return err
+	}
+	interTmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Mellium Integration Test Intermediate CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	interTmpl.SerialNumber, err = serialNumber()
+	if err != nil {
+		return err
+	}
+	interDER, err := x509.CreateCertificate(rand.Reader, interTmpl, root, interKey.Public(), rootKey)
+	if err != nil {
+		return err
+	}
+	inter, err := x509.ParseCertificate(interDER)
+	if err != nil {
+		return err
+	}
+
+	cmd.ca = &certAuthority{
+		root:     root,
+		rootDER:  rootDER,
+		inter:    inter,
+		interDER: interDER,
+		interKey: interKey,
+	}
+	return writeCerts(caFileName, rootDER)(cmd)
+}
+
+func generateKey(t KeyType) (crypto.Signer, error) {
+	switch t {
+	case RSAKey:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case ECDSAKey:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519Key:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, errors.New("integration: unknown key type")
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// writeKey writes the PEM encoded private key to name+".key".
+// RSA and ECDSA keys are written in their traditional formats for
+// compatibility with older software, other keys are written in PKCS #8 form.
+func writeKey(name string, key crypto.Signer) Option {
+	return TempFile(name+".key", func(_ *Cmd, w io.Writer) error {
+		var block *pem.Block
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			block = &pem.Block{
+				Type:  "RSA PRIVATE KEY",
+				Bytes: x509.MarshalPKCS1PrivateKey(k),
+			}
+		case *ecdsa.PrivateKey:
+			der, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return err
+			}
+			block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+		default:
+			der, err := x509.MarshalPKCS8PrivateKey(k)
+			if err != nil {
+				return err
+			}
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+		return pem.Encode(w, block)
+	})
+}
+
+// writeCerts writes the PEM encoded certificates to a file in the order they
+// are provided.
+func writeCerts(fileName string, ders ...[]byte) Option {
+	return TempFile(fileName, func(_ *Cmd, w io.Writer) error {
+		for _, der := range ders {
+			err := pem.Encode(w, &pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: der,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
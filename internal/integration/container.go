@@ -0,0 +1,149 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+
+	"mellium.im/xmpp/internal/attr"
+)
+
+// containerRuntimes is a list of supported container runtimes in order of
+// preference.
+var containerRuntimes = []string{"docker", "podman"}
+
+// Container is an option that runs the command inside a container created from
+// the provided image instead of on the host.
+// The image must contain the command being run.
+//
+// The temporary config directory is mounted into the container at the same
+// path as on the host and the container shares the hosts network so that any
+// ports reserved by the various listen methods are reachable on the loopback
+// interface and DialClient, DialServer, etc. continue to work as if the command
+// were running on the host.
+// Extra files (see exec.Cmd.ExtraFiles) cannot be passed to commands running
+// in a container.
+//
+// Docker is used if it is available, otherwise Podman is tried.
+// If neither is installed, tests using this option are skipped.
+func Container(image string) Option {
+	return func(cmd *Cmd) error {
+		cmd.container = image
+		return nil
+	}
+}
+
+func containerRuntime() (string, error) {
+	var err error
+	for _, runtime := range containerRuntimes {
+		var path string
+		path, err = exec.LookPath(runtime)
+		if err == nil {
+			return path, nil
+		}
+	}
+	return "", err
+}
+
+// Command returns an exec.Cmd that runs name with the given arguments in the
+// same environment as cmd.
+// If cmd is running in a container (see the Container option) the command is
+// executed inside the container, otherwise it is run on the host using any
+// name set with the CommandName option.
+// This is meant to be used by packages like prosody and ejabberd to run the
+// servers control commands.
+func (cmd *Cmd) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if cmd.containerName == "" {
+		if alt, ok := cmd.commandNames[name]; ok {
+			name = alt
+		}
+		/* #nosec */
+		return exec.CommandContext(ctx, name, args...)
+	}
+	/* #nosec */
+	return exec.CommandContext(ctx, cmd.runtime, append([]string{"exec", "-i", cmd.containerName, name}, args...)...)
+}
+
+// containerize replaces the underlying exec.Cmd with one that starts the
+// original command in a container.
+func (cmd *Cmd) containerize() error {
+	if len(cmd.Cmd.ExtraFiles) > 0 {
+		return errors.New("integration: extra files cannot be passed to a container")
+	}
+	var err error
+	cmd.runtime, err = containerRuntime()
+	if err != nil {
+		return err
+	}
+
+	cmd.containerName = "mellium-" + attr.RandomID()
+	workDir := cmd.cfgDir
+	if cmd.Cmd.Dir != "" {
+		workDir = cmd.Cmd.Dir
+	}
+	args := []string{
+		"run", "--rm", "-i",
+		"--name", cmd.containerName,
+		"-v", cmd.cfgDir + ":" + cmd.cfgDir,
+		"-w", workDir,
+		// Servers are configured to listen on the loopback interface, so the
+		// container shares the hosts network instead of publishing ports (which
+		// would not reach services bound to the containers loopback interface and
+		// would expose them on all of the hosts interfaces).
+		"--network=host",
+	}
+	// Only pass through environment variables that were explicitly added to the
+	// command, not those it inherited from the host.
+	hostEnv := make(map[string]struct{})
+	for _, env := range os.Environ() {
+		hostEnv[env] = struct{}{}
+	}
+	for _, env := range cmd.Cmd.Env {
+		if _, ok := hostEnv[env]; ok {
+			continue
+		}
+		args = append(args, "-e", env)
+	}
+	args = append(args, cmd.container)
+	args = append(args, cmd.Cmd.Args...)
+
+	/* #nosec */
+	c := exec.CommandContext(cmd.killCtx, cmd.runtime, args...)
+	c.Dir = cmd.Cmd.Dir
+	c.Stdout = cmd.Cmd.Stdout
+	c.Stderr = cmd.Cmd.Stderr
+
+	// The original commands stdin pipe was never started so it is safe to
+	// replace it.
+	/* #nosec */
+	cmd.stdinPipe.Close()
+	cmd.stdinPipe, err = c.StdinPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Cmd = c
+	return nil
+}
+
+// removeContainer forcibly removes the container that the command was started
+// in, if any.
+// Killing the container runtime's client does not always stop the container,
+// which would then keep running and hold on to its ports on the host network.
+// The container is removed even if the commands context is done, and errors
+// are ignored since the container has normally already been removed by the
+// time this is called.
+func (cmd *Cmd) removeContainer() {
+	if cmd.containerName == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	/* #nosec */
+	exec.CommandContext(ctx, cmd.runtime, "rm", "-f", cmd.containerName).Run()
+}
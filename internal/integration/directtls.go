@@ -0,0 +1,109 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"mellium.im/xmpp/jid"
+)
+
+// C2STLSListen returns a listener with a random port for client-to-server
+// (c2s) connections that use direct TLS as described in XEP-0368: SRV records
+// for XMPP over TLS.
+// The listener is created on the first call to C2STLSListen.
+// Subsequent calls ignore the arguments and return the existing listener.
+//
+// If a direct TLS listener is configured, DialClient connects to it and
+// performs the TLS handshake before negotiating a stream instead of using the
+// listener created by C2SListen.
+func (cmd *Cmd) C2STLSListen(network, addr string) (net.Listener, error) {
+	if cmd.c2sTLSListener != nil {
+		return cmd.c2sTLSListener, nil
+	}
+
+	var err error
+	cmd.c2sTLSListener, err = net.Listen(network, addr)
+	cmd.c2sTLSNetwork = network
+	return cmd.c2sTLSListener, err
+}
+
+// S2STLSListen returns a listener with a random port for server-to-server
+// (s2s) connections that use direct TLS.
+// The listener is created on the first call to S2STLSListen.
+// Subsequent calls ignore the arguments and return the existing listener.
+//
+// If a direct TLS listener is configured, DialServer connects to it and
+// performs the TLS handshake before negotiating a stream instead of using the
+// listener created by S2SListen.
+func (cmd *Cmd) S2STLSListen(network, addr string) (net.Listener, error) {
+	if cmd.s2sTLSListener != nil {
+		return cmd.s2sTLSListener, nil
+	}
+
+	var err error
+	cmd.s2sTLSListener, err = net.Listen(network, addr)
+	cmd.s2sTLSNetwork = network
+	return cmd.s2sTLSListener, err
+}
+
+// TLSConn dials a direct TLS connection, performs the TLS handshake using cfg,
+// and returns it without negotiating a session.
+// To verify certificates created by CertChain, set the RootCAs field of cfg to
+// the result of cmd.RootCAs().
+func (cmd *Cmd) TLSConn(ctx context.Context, s2s bool, cfg *tls.Config) (*tls.Conn, error) {
+	switch {
+	case s2s && cmd.s2sTLSListener == nil:
+		return nil, errors.New("direct TLS s2s not configured, please configure an s2s TLS listener")
+	case !s2s && cmd.c2sTLSListener == nil:
+		return nil, errors.New("direct TLS c2s not configured, please configure a c2s TLS listener")
+	}
+
+	var addr, network string
+	if s2s {
+		addr = cmd.s2sTLSListener.Addr().String()
+		network = cmd.s2sTLSNetwork
+	} else {
+		addr = cmd.c2sTLSListener.Addr().String()
+		network = cmd.c2sTLSNetwork
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing %s: %w", addr, err)
+	}
+	tlsConn := tls.Client(conn, cfg)
+	err = tlsConn.Handshake()
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return nil, fmt.Errorf("error performing TLS handshake with %s: %w", addr, err)
+	}
+	return tlsConn, nil
+}
+
+// tlsConfig returns the config used by DialClient and DialServer to dial direct
+// TLS connections to location.
+func (cmd *Cmd) tlsConfig(s2s bool, location jid.JID) *tls.Config {
+	proto := "xmpp-client"
+	if s2s {
+		proto = "xmpp-server"
+	}
+	rootCAs := cmd.RootCAs()
+	/* #nosec */
+	return &tls.Config{
+		ServerName: location.Domainpart(),
+		RootCAs:    rootCAs,
+		// Servers normally use self-signed certificates created by Cert which
+		// can't be verified.
+		InsecureSkipVerify: rootCAs == nil,
+		NextProtos:         []string{proto},
+	}
+}
@@ -17,6 +17,7 @@ type Config struct {
 	CompSocket string
 	HTTPSocket string
 	Component  map[string]string
+	MUC        []string
 }
 
 const inetrc = `{lookup,["file","native"]}.
@@ -135,6 +136,12 @@ modules:
     assume_mam_usage: true
     default: always
   mod_muc:
+{{- if .MUC }}
+    hosts:
+{{- range .MUC }}
+      - {{.}}
+{{- end }}
+{{- end }}
     access:
       - allow
     access_admin:
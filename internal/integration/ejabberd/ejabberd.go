@@ -10,8 +10,9 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"mellium.im/xmpp/internal/integration"
@@ -178,11 +179,14 @@ func Ctl(ctx context.Context, args ...string) integration.Option {
 func ctlFunc(ctx context.Context, args ...string) func(*integration.Cmd) error {
 	return func(cmd *integration.Cmd) error {
 		cfgFilePath := cmd.ConfigDir()
-		/* #nosec */
-		ejabberdCtl := exec.CommandContext(ctx, "ejabberdctl",
+		ejabberdCtl := cmd.Command(ctx, "ejabberdctl",
 			configFlag, cfgFilePath, logsFlag, cfgFilePath, spoolFlag, cfgFilePath)
 		ejabberdCtl.Args = append(ejabberdCtl.Args, args...)
-		return ejabberdCtl.Run()
+		out, err := ejabberdCtl.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ejabberd: error running ejabberdctl %s: %w\n%s", strings.Join(args, " "), err, out)
+		}
+		return nil
 	}
 }
 
@@ -206,6 +210,17 @@ func Component(domain, secret string) integration.Option {
 	}
 }
 
+// RegisterComponent is an option that adds an external component with the
+// given domain and secret to the server.
+// Ejabberd only reads external components from its config file so, unlike the
+// other provisioning helpers, it does not call ejabberdctl and is equivalent to
+// Component.
+// It exists so that integration tests that provision the same accounts, rooms,
+// and components against several servers can be written the same way for each.
+func RegisterComponent(domain, secret string) integration.Option {
+	return Component(domain, secret)
+}
+
 // WebSocket listens for WebSocket connections.
 func WebSocket() integration.Option {
 	return func(cmd *integration.Cmd) error {
@@ -239,13 +254,99 @@ func CreateUser(ctx context.Context, addr, pass string) integration.Option {
 	}
 }
 
+// MUC adds a multi-user chat service with the given domain to the config
+// file.
+// If it is not used the service is available at "conference." followed by the
+// name of each virtual host.
+func MUC(domain string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		for _, d := range cfg.MUC {
+			if d == domain {
+				return nil
+			}
+		}
+		cfg.MUC = append(cfg.MUC, domain)
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// CreateRoom returns an option that calls ejabberdctl to create a room after the
+// server has started.
+// The domainpart of room is configured as a MUC service using the MUC option.
+//
+// The keys in config are the names of Ejabberd's room options (eg. "title",
+// "description", "members_only", "moderated", "password") and each is set using
+// "ejabberdctl change_room_option" with the value formatted using fmt.Sprint.
+// Unless config contains a value for "persistent" it is set to true.
+func CreateRoom(ctx context.Context, room string, config map[string]interface{}) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		j, err := jid.Parse(room)
+		if err != nil {
+			return err
+		}
+		name, service := j.Localpart(), j.Domainpart()
+		err = MUC(service)(cmd)
+		if err != nil {
+			return err
+		}
+
+		roomCfg := make(map[string]interface{}, len(config)+1)
+		roomCfg["persistent"] = true
+		for k, v := range config {
+			roomCfg[k] = v
+		}
+		keys := make([]string, 0, len(roomCfg))
+		for k := range roomCfg {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return integration.Defer(func(cmd *integration.Cmd) error {
+			cfg := getConfig(cmd)
+			if len(cfg.VHosts) == 0 {
+				return fmt.Errorf("ejabberd: no virtual host configured for room %s", j)
+			}
+			err := ctlFunc(ctx, "create_room", name, service, cfg.VHosts[0])(cmd)
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				err = ctlFunc(ctx, "change_room_option", name, service, k, fmt.Sprint(roomCfg[k]))(cmd)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})(cmd)
+	}
+}
+
 // Test starts an Ejabberd instance and returns a function that runs f as a
 // subtest using t.Run.
 // Multiple calls to the returned function will result in uniquely named
 // subtests.
 // When all subtests have completed, the daemon is stopped.
 func Test(ctx context.Context, t *testing.T, opts ...integration.Option) integration.SubtestRunner {
+	s := Server(ctx, opts...)
+	return integration.Test(ctx, s.Name, t, s.Opts...)
+}
+
+// Benchmark starts an Ejabberd instance and returns a function that runs
+// sub-benchmarks using b.Run.
+// Multiple calls to the returned function will result in uniquely named
+// sub-benchmarks.
+// When all sub-benchmarks have completed, the daemon is stopped.
+func Benchmark(ctx context.Context, b *testing.B, opts ...integration.Option) integration.SubbenchRunner {
+	s := Server(ctx, opts...)
+	return integration.Benchmark(ctx, s.Name, b, s.Opts...)
+}
+
+// Server returns an Ejabberd server configured with the same defaults used by
+// Test.
+// It is meant to be passed to integration.Federate.
+func Server(ctx context.Context, opts ...integration.Option) integration.Server {
 	opts = append(opts, defaultConfig, inetrcFile, foreground,
 		integration.Shutdown(ctlFunc(ctx, "stop")))
-	return integration.Test(ctx, cmdName, t, opts...)
+	return integration.Server{Name: cmdName, Opts: opts}
 }
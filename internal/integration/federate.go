@@ -0,0 +1,123 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Server is the name of a server command and the options used to configure it.
+// It is used to start multiple servers that federate with one another.
+// Packages such as prosody and ejabberd provide functions that return a Server
+// with the same defaults that their Test function uses.
+type Server struct {
+	Name string
+	Opts []Option
+}
+
+// FederatedRunner is the signature of a function that can be used to start
+// subtests that require two servers.
+type FederatedRunner func(func(ctx context.Context, t *testing.T, a, b *Cmd)) bool
+
+// Peer returns the other server that was started alongside cmd by Federate, or
+// nil if cmd was not started by Federate.
+func (cmd *Cmd) Peer() *Cmd {
+	return cmd.peer
+}
+
+// PeerConfig is an option that calls f after both servers started by Federate
+// have been created and have reserved their listeners, but before either of
+// their config files are written.
+// It can be used to point each server at the others s2s listener (see
+// S2SAddr).
+// Config files must be rendered when they are written (not when the option that
+// creates them is applied) to see any changes made by f.
+// If the command is not started by Federate, f is never called.
+// If multiple PeerConfig options are passed they are called in order until an
+// error is encountered.
+func PeerConfig(f func(cmd, peer *Cmd) error) Option {
+	return func(cmd *Cmd) error {
+		if cmd.peerF != nil {
+			prev := cmd.peerF
+			cmd.peerF = func(cmd, peer *Cmd) error {
+				err := prev(cmd, peer)
+				if err != nil {
+					return err
+				}
+				return f(cmd, peer)
+			}
+			return nil
+		}
+		cmd.peerF = f
+		return nil
+	}
+}
+
+// Federate starts two servers and returns a function that runs tests requiring
+// both of them as subtests using t.Run.
+// Before either servers config is written each one is made aware of the other
+// (see Peer and PeerConfig) so that their config files can reference the
+// others s2s listener.
+// Multiple calls to the returned function will result in uniquely named
+// subtests.
+// When all subtests have completed, both servers are stopped.
+// If either server cannot be run on this system the subtests are skipped.
+func Federate(ctx context.Context, t *testing.T, a, b Server) FederatedRunner {
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+
+	name := filepath.Base(a.Name) + "+" + filepath.Base(b.Name)
+	skip := func(err error) FederatedRunner {
+		i := -1
+		return func(f func(context.Context, *testing.T, *Cmd, *Cmd)) bool {
+			i++
+			return t.Run(fmt.Sprintf("%s/%d", name, i), func(t *testing.T) {
+				t.Skip(err.Error())
+			})
+		}
+	}
+
+	cmdA, err := testCmd(ctx, a.Name, t, a.Opts...)
+	if err != nil {
+		return skip(err)
+	}
+	cmdB, err := testCmd(ctx, b.Name, t, b.Opts...)
+	if err != nil {
+		return skip(err)
+	}
+	cmdA.peer = cmdB
+	cmdB.peer = cmdA
+	// Both servers must be configured before either config is written so that
+	// a PeerConfig option can change the config of either of them.
+	for _, cmd := range []*Cmd{cmdA, cmdB} {
+		if cmd.peerF != nil {
+			err = cmd.peerF(cmd, cmd.peer)
+			if err != nil {
+				t.Fatalf("error configuring peer: %v", err)
+			}
+		}
+	}
+	for _, cmd := range []*Cmd{cmdA, cmdB} {
+		err = cmd.writeConfig()
+		if err != nil {
+			t.Fatalf("error creating command: %v", err)
+		}
+	}
+	cmdA.start(t)
+	cmdB.start(t)
+
+	i := -1
+	return func(f func(context.Context, *testing.T, *Cmd, *Cmd)) bool {
+		i++
+		return t.Run(fmt.Sprintf("%s/%d", name, i), func(t *testing.T) {
+			cmdA.update(t)
+			cmdB.update(t)
+			f(ctx, t, cmdA, cmdB)
+		})
+	}
+}
@@ -12,7 +12,6 @@ package integration // import "mellium.im/xmpp/internal/integration"
 import (
 	"context"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -25,7 +24,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -65,6 +66,31 @@ type Cmd struct {
 	clientCrtKey  interface{}
 	stdinPipe     io.WriteCloser
 	closed        chan error
+	container     string
+	containerName string
+	runtime       string
+	peer          *Cmd
+	peerF         func(cmd, peer *Cmd) error
+	activate      bool
+	activated     []*os.File
+	ready         []func(context.Context, *Cmd) error
+	recordDir     string
+	recordN       uint32
+	keyType       KeyType
+	ca            *certAuthority
+	unix          bool
+	restartEach   bool
+	parallel      bool
+	origPath      string
+	origArgs      []string
+	origEnv       []string
+	group         procGroup
+	commandNames  map[string]string
+
+	c2sTLSListener net.Listener
+	s2sTLSListener net.Listener
+	c2sTLSNetwork  string
+	s2sTLSNetwork  string
 
 	// Config is meant to be used by internal packages like prosody and ejabberd
 	// to store their internal representation of the config before writing it out.
@@ -75,7 +101,22 @@ type Cmd struct {
 //
 // The provided context is used to kill the process (by calling os.Process.Kill)
 // if the context becomes done before the command completes on its own.
+// On Windows any processes started by the command are also killed when the
+// command exits or is closed.
 func New(ctx context.Context, name string, opts ...Option) (*Cmd, error) {
+	cmd, err := newCmd(ctx, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.writeConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// newCmd is like New except that it does not write the config files.
+func newCmd(ctx context.Context, name string, opts ...Option) (*Cmd, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	cmd := &Cmd{
 		/* #nosec */
@@ -102,31 +143,78 @@ func New(ctx context.Context, name string, opts ...Option) (*Cmd, error) {
 			return nil, fmt.Errorf("error applying option: %v", err)
 		}
 	}
-	if cmd.cfgF != nil {
-		err = cmd.cfgF()
-		if err != nil {
-			return nil, fmt.Errorf("error running config func: %w", err)
-		}
-	}
-
 	return cmd, nil
 }
 
+func (cmd *Cmd) writeConfig() error {
+	if cmd.cfgF == nil {
+		return nil
+	}
+	err := cmd.cfgF()
+	if err != nil {
+		return fmt.Errorf("error running config func: %w", err)
+	}
+	return nil
+}
+
 // Start runs the command.
 func (cmd *Cmd) Start() error {
 	_, err := fmt.Fprintf(cmd.stdoutWriter, "starting command: %s", cmd)
 	if err != nil {
 		return err
 	}
+	// Remember the command as configured by the options so that it can be
+	// recreated by Restart after Start modifies it.
+	if cmd.origPath == "" {
+		if name, ok := cmd.commandNames[cmd.name]; ok && cmd.container == "" {
+			cmd.Cmd.Path, err = exec.LookPath(name)
+			if err != nil {
+				return err
+			}
+		}
+		cmd.origPath = cmd.Cmd.Path
+		cmd.origArgs = append([]string(nil), cmd.Cmd.Args...)
+		if cmd.Cmd.Env != nil {
+			cmd.origEnv = append([]string(nil), cmd.Cmd.Env...)
+		}
+	}
+	if cmd.activate {
+		err = cmd.activateSockets()
+		if err != nil {
+			return err
+		}
+	}
+	if cmd.container != "" {
+		err = cmd.containerize()
+		if err != nil {
+			return err
+		}
+	}
+	prepareProc(cmd.Cmd)
 	err = cmd.Cmd.Start()
+	if e := cmd.closeActivated(); err == nil {
+		err = e
+	}
+	if cmd.Process != nil {
+		var groupErr error
+		cmd.group, groupErr = newProcGroup(cmd.Process)
+		if err == nil {
+			err = groupErr
+		}
+	}
+	group := cmd.group
 	go func() {
 		cmd.closed <- cmd.Cmd.Wait()
 		close(cmd.closed)
+		/* #nosec */
+		group.release()
 	}()
 	return err
 }
 
 // Done returns a channel that's closed when the commands process terminates.
+// If the command is restarted, channels returned by previous calls to Done
+// will be closed and a new channel is returned by subsequent calls.
 func (cmd *Cmd) Done() <-chan error {
 	return cmd.closed
 }
@@ -232,6 +320,7 @@ func (cmd *Cmd) ConfigDir() string {
 // resources that were created.
 func (cmd *Cmd) Close() error {
 	defer cmd.kill()
+	defer cmd.removeContainer()
 
 	err := cmd.stdinPipe.Close()
 	if err != nil {
@@ -246,6 +335,8 @@ func (cmd *Cmd) Close() error {
 	defer cancel()
 	select {
 	case <-ctx.Done():
+		/* #nosec */
+		cmd.group.kill(cmd.Process)
 		return fmt.Errorf("command did not exit in time: %v", ctx.Err())
 	case err = <-cmd.closed:
 		if err != nil {
@@ -262,6 +353,87 @@ func (cmd *Cmd) Close() error {
 	return e
 }
 
+// Restart gracefully stops the command and starts it again with the same
+// config dir, arguments, and listeners.
+// Deferred options are not run again, but Restart waits for the command to
+// begin listening and for any readiness probes to succeed before returning.
+//
+// If the command does not exit within 5 seconds or before ctx is done it is
+// killed.
+// Any sessions connected to the command will be disconnected.
+func (cmd *Cmd) Restart(ctx context.Context) error {
+	if cmd.Process == nil {
+		return errors.New("integration: cannot restart a command that was never started")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	/* #nosec */
+	cmd.stdinPipe.Close()
+	var shutdownErr error
+	if cmd.shutdown != nil {
+		shutdownErr = cmd.shutdown(cmd)
+	} else {
+		shutdownErr = cmd.group.interrupt(cmd.Process)
+	}
+	select {
+	case <-cmd.closed:
+	case <-ctx.Done():
+		/* #nosec */
+		cmd.group.kill(cmd.Process)
+		<-cmd.closed
+		cmd.removeContainer()
+		if shutdownErr != nil {
+			return fmt.Errorf("command did not exit in time: %v", shutdownErr)
+		}
+		return fmt.Errorf("command did not exit in time: %v", ctx.Err())
+	}
+
+	/* #nosec */
+	c := exec.CommandContext(cmd.killCtx, cmd.origPath)
+	c.Args = append([]string(nil), cmd.origArgs...)
+	if cmd.origEnv != nil {
+		c.Env = append([]string(nil), cmd.origEnv...)
+	}
+	c.Dir = cmd.Cmd.Dir
+	c.Stdout = cmd.Cmd.Stdout
+	c.Stderr = cmd.Cmd.Stderr
+	var err error
+	cmd.stdinPipe, err = c.StdinPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Cmd = c
+	cmd.closed = make(chan error)
+	// Make sure the old container is gone before Start creates a new one.
+	cmd.removeContainer()
+	return cmd.startWait()
+}
+
+// RestartEach restarts the command using Restart before every subtest except
+// the first so that each subtest runs against a freshly started server.
+// Data stored by the server (such as users created by options) persists across
+// restarts.
+func RestartEach() Option {
+	return func(cmd *Cmd) error {
+		cmd.restartEach = true
+		return nil
+	}
+}
+
+// Parallel configures Test to start a separate command for each subtest and to
+// run the subtests in parallel with one another and with other parallel tests
+// using t.Parallel.
+// All options are applied again to each command, so each subtest gets its own
+// config, listeners, and users.
+// Parallel has no effect on Benchmark.
+func Parallel() Option {
+	return func(cmd *Cmd) error {
+		cmd.parallel = true
+		return nil
+	}
+}
+
 // User returns the address and password of a user created on the server (if
 // any).
 func (cmd *Cmd) User() (jid.JID, string) {
@@ -272,14 +444,14 @@ func (cmd *Cmd) User() (jid.JID, string) {
 // connection by dialing the address reserved by C2SListen and then negotiating
 // a stream with the location set to the domainpart of j and the origin set to
 // j.
-func (cmd *Cmd) DialClient(ctx context.Context, j jid.JID, t *testing.T, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
+func (cmd *Cmd) DialClient(ctx context.Context, j jid.JID, t testing.TB, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
 	return cmd.dial(ctx, false, j.Domain(), j, t, features...)
 }
 
 // DialServer attempts to connect to the server with a server-to-server (s2s)
 // connection by dialing the address reserved by S2SListen and then negotiating
 // a stream.
-func (cmd *Cmd) DialServer(ctx context.Context, location, origin jid.JID, t *testing.T, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
+func (cmd *Cmd) DialServer(ctx context.Context, location, origin jid.JID, t testing.TB, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
 	return cmd.dial(ctx, true, location, origin, t, features...)
 }
 
@@ -289,6 +461,7 @@ func (cmd *Cmd) C2SAddr() (net.Addr, string) {
 }
 
 // C2SPort returns the port on which the C2S listener is running (if any).
+// If the listener is a Unix domain socket, C2SPort returns an empty string.
 func (cmd *Cmd) C2SPort() string {
 	addr, _ := cmd.C2SAddr()
 	/* #nosec */
@@ -313,7 +486,11 @@ func (cmd *Cmd) HTTPPort() string {
 }
 
 // S2SAddr returns the server-to-server address and network.
+// If no s2s listener was created, the address is nil.
 func (cmd *Cmd) S2SAddr() (net.Addr, string) {
+	if cmd.s2sListener == nil {
+		return nil, ""
+	}
 	return cmd.s2sListener.Addr(), cmd.s2sNetwork
 }
 
@@ -388,8 +565,25 @@ func (cmd *Cmd) HTTPConn(ctx context.Context, secure bool) (net.Conn, error) {
 	return conn, nil
 }
 
-func (cmd *Cmd) dial(ctx context.Context, s2s bool, location, origin jid.JID, t *testing.T, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
-	conn, err := cmd.Conn(ctx, s2s)
+func (cmd *Cmd) dial(ctx context.Context, s2s bool, location, origin jid.JID, t testing.TB, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
+	// Each session logs to the test that dialed it so that sessions created by
+	// subtests running in parallel do not log to one another.
+	var teeIn, teeOut io.Writer = cmd.in.scoped(t), cmd.out.scoped(t)
+	if cmd.recordDir != "" {
+		in, out, err := cmd.recordFiles(t)
+		if err != nil {
+			return nil, err
+		}
+		teeIn = io.MultiWriter(teeIn, in)
+		teeOut = io.MultiWriter(teeOut, out)
+	}
+	var conn net.Conn
+	var err error
+	if (s2s && cmd.s2sTLSListener != nil) || (!s2s && cmd.c2sTLSListener != nil) {
+		conn, err = cmd.TLSConn(ctx, s2s, cmd.tlsConfig(s2s, location))
+	} else {
+		conn, err = cmd.Conn(ctx, s2s)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -397,8 +591,8 @@ func (cmd *Cmd) dial(ctx context.Context, s2s bool, location, origin jid.JID, t
 		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
 			return features
 		},
-		TeeIn:  cmd.in,
-		TeeOut: cmd.out,
+		TeeIn:  teeIn,
+		TeeOut: teeOut,
 	})
 	var mask xmpp.SessionState
 	if s2s {
@@ -462,7 +656,35 @@ func Args(f ...string) Option {
 	}
 }
 
-// Cert creates a private key and certificate with the given name.
+// Env sets an environment variable for the command.
+// The command also inherits the environment of the test process.
+// If the same key is set more than once, the last value is used.
+func Env(key, value string) Option {
+	return func(cmd *Cmd) error {
+		if cmd.Cmd.Env == nil {
+			cmd.Cmd.Env = os.Environ()
+		}
+		cmd.Cmd.Env = append(cmd.Cmd.Env, key+"="+value)
+		return nil
+	}
+}
+
+// Dir sets the working directory of the command.
+// If path is relative, it is relative to the config directory.
+// If this option is not provided, the command runs in the working directory of
+// the test process.
+func Dir(path string) Option {
+	return func(cmd *Cmd) error {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cmd.cfgDir, path)
+		}
+		cmd.Cmd.Dir = path
+		return nil
+	}
+}
+
+// Cert creates a private key and self-signed certificate with the given name.
+// The type of the key can be configured with CertKey.
 func Cert(name string) Option {
 	return cert(name, &x509.Certificate{
 		SerialNumber: big.NewInt(1),
@@ -486,16 +708,11 @@ func ClientCert(name string) Option {
 
 func cert(name string, crt *x509.Certificate) Option {
 	return func(cmd *Cmd) error {
-		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		key, err := generateKey(cmd.keyType)
 		if err != nil {
 			return err
 		}
-		err = TempFile(name+".key", func(_ *Cmd, w io.Writer) error {
-			return pem.Encode(w, &pem.Block{
-				Type:  "RSA PRIVATE KEY",
-				Bytes: x509.MarshalPKCS1PrivateKey(key),
-			})
-		})(cmd)
+		err = writeKey(name, key)(cmd)
 		if err != nil {
 			return err
 		}
@@ -560,9 +777,13 @@ func TempFile(cfgFileName string, f func(*Cmd, io.Writer) error) Option {
 	}
 }
 
+// testWriter logs to the most recently started test that is still running.
+// Each test is registered using Update and forgotten when it completes so that
+// output is never logged to a finished test, even when subtests run in
+// parallel.
 type testWriter struct {
 	sync.Mutex
-	t   *testing.T
+	t   []testing.TB
 	tag string
 }
 
@@ -573,22 +794,43 @@ func (w *testWriter) Write(p []byte) (int, error) {
 	w.Lock()
 	defer w.Unlock()
 
-	if w.t != nil {
-		w.t.Logf("%s%s", w.tag, p)
+	if n := len(w.t); n > 0 {
+		w.t[n-1].Logf("%s%s", w.tag, p)
 	}
 	return len(p), nil
 }
 
-func (w *testWriter) Update(t *testing.T) {
+func (w *testWriter) Update(t testing.TB) {
 	if w == nil {
 		return
 	}
 	w.Lock()
-	w.t = t
+	w.t = append(w.t, t)
 	w.Unlock()
+	t.Cleanup(func() {
+		w.Lock()
+		defer w.Unlock()
+		for i, tt := range w.t {
+			if tt == t {
+				w.t = append(w.t[:i], w.t[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+// scoped returns a new writer with the same tag as w that only logs to t.
+// If w is nil, scoped returns nil.
+func (w *testWriter) scoped(t testing.TB) *testWriter {
+	if w == nil {
+		return nil
+	}
+	sw := &testWriter{tag: w.tag}
+	sw.Update(t)
+	return sw
 }
 
-// Log configures the command to copy stdout to the current testing.T.
+// Log configures the command to copy stdout to the current test or benchmark.
 // This should not be used for CLI or TUI clients.
 func Log() Option {
 	return func(cmd *Cmd) error {
@@ -631,7 +873,7 @@ func LogFile(f string, tee io.Writer) Option {
 }
 
 // LogXML configures the command to log sent and received XML to the current
-// testing.T.
+// test or benchmark.
 func LogXML() Option {
 	return func(cmd *Cmd) error {
 		cmd.in = &testWriter{tag: "RECV"}
@@ -640,6 +882,55 @@ func LogXML() Option {
 	}
 }
 
+// RecordXML configures the command to write all XML sent and received by
+// sessions created with DialClient or DialServer to files in dir.
+// Each session gets one file per direction named after the test, the time the
+// session was created, and the direction, for example:
+//
+//     TestIntegrationSendPing_prosody_0-20210301T150405.000000000Z-0-sent.xml
+//
+// The directory is created if it does not exist and is not removed when the
+// command is closed so that the files can be collected after a test fails.
+// RecordXML may be combined with LogXML.
+func RecordXML(dir string) Option {
+	return func(cmd *Cmd) error {
+		cmd.recordDir = dir
+		return nil
+	}
+}
+
+func (cmd *Cmd) recordFiles(t testing.TB) (in, out io.Writer, err error) {
+	err = os.MkdirAll(cmd.recordDir, 0700)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := atomic.AddUint32(&cmd.recordN, 1) - 1
+	prefix := filepath.Join(cmd.recordDir, fmt.Sprintf("%s-%s-%d",
+		strings.ReplaceAll(t.Name(), "/", "_"),
+		time.Now().UTC().Format("20060102T150405.000000000Z"),
+		n,
+	))
+	inFile, err := os.Create(prefix + "-recv.xml")
+	if err != nil {
+		return nil, nil, err
+	}
+	outFile, err := os.Create(prefix + "-sent.xml")
+	if err != nil {
+		/* #nosec */
+		inFile.Close()
+		return nil, nil, err
+	}
+	t.Cleanup(func() {
+		for _, f := range []*os.File{inFile, outFile} {
+			err := f.Close()
+			if err != nil {
+				t.Logf("error closing XML recording: %v", err)
+			}
+		}
+	})
+	return inFile, outFile, nil
+}
+
 // Defer is an option that calls f after the command is started.
 // If multiple Defer options are passed they are called in order until an error
 // is encountered.
@@ -670,68 +961,222 @@ func Test(ctx context.Context, name string, t *testing.T, opts ...Option) Subtes
 	ctx, cancel := context.WithCancel(ctx)
 	t.Cleanup(cancel)
 
-	_, err := exec.LookPath(name)
+	cmd, err := testCmd(ctx, name, t, opts...)
+	if err != nil {
+		return skip(name, t, err)
+	}
+	if cmd.parallel {
+		// The command is never started, it is only created to find out whether
+		// the Parallel option was set and is cleaned up with t.
+		return parallel(ctx, name, t, opts...)
+	}
+	err = cmd.writeConfig()
+	if err != nil {
+		t.Fatalf("error creating command: %v", err)
+	}
+	cmd.start(t)
+
+	i := -1
+	return func(f func(context.Context, *testing.T, *Cmd)) bool {
+		i++
+		n := i
+		return t.Run(fmt.Sprintf("%s/%d", filepath.Base(name), i), func(t *testing.T) {
+			cmd.update(t)
+			if cmd.restartEach && n > 0 {
+				err := cmd.Restart(ctx)
+				if err != nil {
+					t.Fatalf("error restarting command: %v", err)
+				}
+			}
+			f(ctx, t, cmd)
+		})
+	}
+}
+
+// parallel returns a SubtestRunner that starts a new command for each subtest
+// and runs the subtests in parallel.
+func parallel(ctx context.Context, name string, t *testing.T, opts ...Option) SubtestRunner {
+	i := -1
+	return func(f func(context.Context, *testing.T, *Cmd)) bool {
+		i++
+		return t.Run(fmt.Sprintf("%s/%d", filepath.Base(name), i), func(t *testing.T) {
+			t.Parallel()
+			cmd, err := testCmd(ctx, name, t, opts...)
+			if err != nil {
+				t.Skip(err.Error())
+			}
+			err = cmd.writeConfig()
+			if err != nil {
+				t.Fatalf("error creating command: %v", err)
+			}
+			cmd.start(t)
+			f(ctx, t, cmd)
+		})
+	}
+}
+
+// skip returns a SubtestRunner that skips every subtest with the provided
+// error as the reason.
+func skip(name string, t *testing.T, err error) SubtestRunner {
+	i := -1
+	return func(f func(context.Context, *testing.T, *Cmd)) bool {
+		i++
+		return t.Run(fmt.Sprintf("%s/%d", filepath.Base(name), i), func(t *testing.T) {
+			t.Skip(err.Error())
+		})
+	}
+}
+
+// Benchmark starts a command and returns a function that runs benchmarks as
+// sub-benchmarks using b.Run.
+// It accepts the same options as Test.
+// Multiple calls to the returned function will result in uniquely named
+// sub-benchmarks.
+// The time spent starting the command is not included in the results, but any
+// setup done inside the sub-benchmark (such as dialing a session) is unless
+// the benchmark calls b.ResetTimer.
+// When all sub-benchmarks have completed, the daemon is stopped.
+func Benchmark(ctx context.Context, name string, b *testing.B, opts ...Option) SubbenchRunner {
+	ctx, cancel := context.WithCancel(ctx)
+	b.Cleanup(cancel)
+
+	cmd, err := testCmd(ctx, name, b, opts...)
 	if err != nil {
 		i := -1
-		return func(f func(context.Context, *testing.T, *Cmd)) bool {
+		return func(f func(context.Context, *testing.B, *Cmd)) bool {
 			i++
-			return t.Run(fmt.Sprintf("%s/%d", filepath.Base(name), i), func(t *testing.T) {
-				t.Skip(err.Error())
+			return b.Run(fmt.Sprintf("%s/%d", filepath.Base(name), i), func(b *testing.B) {
+				b.Skip(err.Error())
 			})
 		}
 	}
-
-	cmd, err := New(ctx, name, opts...)
+	err = cmd.writeConfig()
 	if err != nil {
+		b.Fatalf("error creating command: %v", err)
+	}
+	cmd.start(b)
+
+	i := -1
+	return func(f func(context.Context, *testing.B, *Cmd)) bool {
+		i++
+		n := i
+		return b.Run(fmt.Sprintf("%s/%d", filepath.Base(name), i), func(b *testing.B) {
+			cmd.update(b)
+			if cmd.restartEach && n > 0 {
+				err := cmd.Restart(ctx)
+				if err != nil {
+					b.Fatalf("error restarting command: %v", err)
+				}
+			}
+			f(ctx, b, cmd)
+		})
+	}
+}
+
+// testCmd creates a command for use by t without writing its config.
+// If the command cannot be run on this system an error is returned and the
+// test should be skipped.
+func testCmd(ctx context.Context, name string, t testing.TB, opts ...Option) (*Cmd, error) {
+	// If the command does not exist on the host it may still be run in a
+	// container, so don't skip until we know whether that option was set.
+	_, lookErr := exec.LookPath(name)
+	cmd, err := newCmd(ctx, name, opts...)
+	if lookErr != nil && err == nil {
+		if alt, ok := cmd.commandNames[name]; ok {
+			_, lookErr = exec.LookPath(alt)
+		}
+	}
+	switch {
+	case lookErr != nil && (err != nil || cmd.container == ""):
+		if cmd != nil {
+			/* #nosec */
+			os.RemoveAll(cmd.cfgDir)
+		}
+		return nil, lookErr
+	case err != nil:
 		t.Fatalf("error creating command: %v", err)
 	}
+	if cmd.container != "" {
+		_, err = containerRuntime()
+		if err != nil {
+			/* #nosec */
+			os.RemoveAll(cmd.cfgDir)
+			return nil, err
+		}
+	}
 
 	t.Cleanup(func() {
+		// If the test failed or was skipped before the command was started there
+		// is nothing to shut down.
+		if cmd.Process == nil {
+			cmd.kill()
+			/* #nosec */
+			os.RemoveAll(cmd.cfgDir)
+			return
+		}
 		err := cmd.Close()
 		if err != nil {
 			t.Logf("error cleaning up test: %v", err)
 		}
 	})
+	return cmd, nil
+}
+
+// start starts the command, waits for it to begin listening and for any
+// readiness probes to succeed, and runs any deferred options, failing t if any
+// step returns an error.
+func (cmd *Cmd) start(t testing.TB) {
 	cmd.stdoutWriter.Update(t)
-	cmd.in.Update(t)
-	cmd.out.Update(t)
-	err = cmd.Start()
+	err := cmd.startWait()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if cmd.c2sListener != nil {
-		err = waitSocket(cmd.c2sNetwork, cmd.c2sListener.Addr().String())
+	if cmd.deferF != nil {
+		err = cmd.deferF(cmd)
 		if err != nil {
 			t.Fatal(err)
 		}
 	}
-	if cmd.s2sListener != nil {
-		err = waitSocket(cmd.s2sNetwork, cmd.s2sListener.Addr().String())
-		if err != nil {
-			t.Fatal(err)
-		}
+}
+
+// startWait starts the command and waits for it to begin listening and for any
+// readiness probes to succeed.
+func (cmd *Cmd) startWait() error {
+	err := cmd.Start()
+	if err != nil {
+		return err
 	}
-	if cmd.deferF != nil {
-		err = cmd.deferF(cmd)
+	for _, l := range []struct {
+		network string
+		l       net.Listener
+	}{
+		{network: cmd.c2sNetwork, l: cmd.c2sListener},
+		{network: cmd.s2sNetwork, l: cmd.s2sListener},
+		{network: cmd.c2sTLSNetwork, l: cmd.c2sTLSListener},
+		{network: cmd.s2sTLSNetwork, l: cmd.s2sTLSListener},
+	} {
+		if l.l == nil {
+			continue
+		}
+		err = waitSocket(l.network, l.l.Addr().String())
 		if err != nil {
-			t.Fatal(err)
+			return err
 		}
 	}
+	return waitReady(cmd.killCtx, cmd)
+}
 
-	i := -1
-	return func(f func(context.Context, *testing.T, *Cmd)) bool {
-		i++
-		return t.Run(fmt.Sprintf("%s/%d", filepath.Base(name), i), func(t *testing.T) {
-			if tw, ok := cmd.Cmd.Stdout.(*testWriter); ok {
-				tw.Update(t)
-			}
-			cmd.in.Update(t)
-			cmd.out.Update(t)
-			f(ctx, t, cmd)
-		})
+// update points the commands logs at t.
+func (cmd *Cmd) update(t testing.TB) {
+	if tw, ok := cmd.Cmd.Stdout.(*testWriter); ok {
+		tw.Update(t)
 	}
 }
 
 // SubtestRunner is the signature of a function that can be used to start
 // subtests.
 type SubtestRunner func(func(context.Context, *testing.T, *Cmd)) bool
+
+// SubbenchRunner is the signature of a function that can be used to start
+// sub-benchmarks.
+type SubbenchRunner func(func(context.Context, *testing.B, *Cmd)) bool
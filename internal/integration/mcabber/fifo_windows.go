@@ -0,0 +1,17 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//+build windows
+
+package mcabber
+
+import (
+	"errors"
+)
+
+// mkfifo always returns an error on Windows since mcabber is configured using
+// named pipes in its config directory, which are not supported.
+func mkfifo(path string, mode uint32) error {
+	return errors.New("mcabber: named pipes are not supported on windows")
+}
@@ -16,8 +16,6 @@ import (
 	"strings"
 	"testing"
 
-	"golang.org/x/sys/unix"
-
 	"mellium.im/xmpp/internal/integration"
 	"mellium.im/xmpp/jid"
 )
@@ -52,7 +50,7 @@ func ConfigFile(cfg Config) integration.Option {
 	return func(cmd *integration.Cmd) error {
 		if cfg.FIFO == nil {
 			fifoPath := filepath.Join(cmd.ConfigDir(), controlFIFO)
-			err := unix.Mkfifo(fifoPath, 0600)
+			err := mkfifo(fifoPath, 0600)
 			if err != nil {
 				return err
 			}
@@ -96,7 +94,7 @@ func defaultConfig(cmd *integration.Cmd) error {
 	}
 	logFIFOPath := filepath.Join(cmd.ConfigDir(), logFIFO)
 	logFilePath := filepath.Join(cmd.ConfigDir(), logFile)
-	err = unix.Mkfifo(logFIFOPath, 0600)
+	err = mkfifo(logFIFOPath, 0600)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,79 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package openfire
+
+import (
+	"text/template"
+)
+
+// Config contains options that can be written to an Openfire config file.
+type Config struct {
+	Domain    string
+	C2SPort   int
+	S2SPort   int
+	AdminPort int
+	AdminPass string
+	Secret    string
+}
+
+const cfgBase = `<?xml version="1.0" encoding="UTF-8"?>
+<jive>
+  <adminConsole>
+    <port>{{ .AdminPort }}</port>
+    <securePort>-1</securePort>
+    <interface>127.0.0.1</interface>
+  </adminConsole>
+  <locale>en</locale>
+  <connectionProvider>
+    <className>org.jivesoftware.database.EmbeddedConnectionProvider</className>
+  </connectionProvider>
+  <autosetup>
+    <run>true</run>
+    <locale>en</locale>
+    <xmpp>
+      <domain>{{ .Domain }}</domain>
+      <fqdn>{{ .Domain }}</fqdn>
+      <auth>
+        <anonymous>false</anonymous>
+      </auth>
+      <socket>
+        <ssl>
+          <active>false</active>
+        </ssl>
+      </socket>
+    </xmpp>
+    <database>
+      <mode>embedded</mode>
+    </database>
+    <admin>
+      <email>admin@{{ .Domain }}</email>
+      <password>{{ .AdminPass }}</password>
+    </admin>
+    <authprovider>
+      <mode>default</mode>
+    </authprovider>
+    <properties>
+      {{- if .C2SPort }}
+      <xmpp.socket.plain.port>{{ .C2SPort }}</xmpp.socket.plain.port>
+      {{- end }}
+      <xmpp.socket.ssl.active>false</xmpp.socket.ssl.active>
+      {{- if .S2SPort }}
+      <xmpp.server.socket.active>true</xmpp.server.socket.active>
+      <xmpp.server.socket.port>{{ .S2SPort }}</xmpp.server.socket.port>
+      {{- else }}
+      <xmpp.server.socket.active>false</xmpp.server.socket.active>
+      {{- end }}
+      <xmpp.server.certificate.verify>false</xmpp.server.certificate.verify>
+      <xmpp.server.certificate.accept-selfsigned>true</xmpp.server.certificate.accept-selfsigned>
+      <xmpp.component.socket.active>false</xmpp.component.socket.active>
+      <xmpp.httpbind.enabled>false</xmpp.httpbind.enabled>
+      <plugin.restapi.enabled>true</plugin.restapi.enabled>
+      <plugin.restapi.httpAuth>secret</plugin.restapi.httpAuth>
+      <plugin.restapi.secret>{{ .Secret }}</plugin.restapi.secret>
+    </properties>
+  </autosetup>
+</jive>`
+
+var cfgTmpl = template.Must(template.New("cfg").Parse(cfgBase))
@@ -0,0 +1,320 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package openfire facilitates integration testing against Openfire.
+//
+// Openfire is configured using its autosetup mechanism so that the web based
+// setup wizard is skipped, and users are provisioned after the server starts
+// using the REST API plugin.
+// For CreateUser to work the REST API plugin must be installed in the plugins
+// directory of the Openfire installation.
+package openfire // import "mellium.im/xmpp/internal/integration/openfire"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/jid"
+)
+
+const (
+	cfgFileName = "conf/openfire.xml"
+	cmdName     = "openfire.sh"
+	homeEnv     = "OPENFIRE_HOME"
+	usersPath   = "/plugins/restapi/v1/users"
+)
+
+// New creates a new, unstarted, openfire daemon.
+//
+// The provided context is used to kill the process (by calling os.Process.Kill)
+// if the context becomes done before the command completes on its own.
+func New(ctx context.Context, opts ...integration.Option) (*integration.Cmd, error) {
+	return integration.New(
+		ctx, cmdName,
+		opts...,
+	)
+}
+
+// ConfigFile is an option that can be used to write a temporary Openfire
+// config file.
+// This will overwrite the existing config file and make most of the other
+// options in this package noops.
+// This option only exists for the rare occasion that you need complete control
+// over the config file.
+func ConfigFile(cfg Config) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cmd.Config = cfg
+		return integration.TempFile(cfgFileName, func(cmd *integration.Cmd, w io.Writer) error {
+			return cfgTmpl.Execute(w, cfg)
+		})(cmd)
+	}
+}
+
+func getConfig(cmd *integration.Cmd) Config {
+	if cmd.Config == nil {
+		cmd.Config = Config{}
+	}
+	return cmd.Config.(Config)
+}
+
+// ListenC2S listens for client-to-server (c2s) connections on a random port.
+func ListenC2S() integration.Option {
+	return func(cmd *integration.Cmd) error {
+		c2sListener, err := cmd.C2SListen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		// Openfire creates its own sockets and doesn't provide us with a way of
+		// handing the filehandle for the TCP connection to it on start, so we're
+		// effectively just listening to get a random port that we'll use to
+		// configure Openfire, then we need to close the connection and let
+		// Openfire listen on that port.
+		// Technically this is racey, but it's not likely to be a problem in
+		// practice.
+		c2sPort, err := integration.Port(c2sListener)
+		if err != nil {
+			return err
+		}
+		err = c2sListener.Close()
+		if err != nil {
+			return err
+		}
+
+		cfg := getConfig(cmd)
+		cfg.C2SPort = c2sPort
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// ListenS2S listens for server-to-server (s2s) connections on a random port.
+func ListenS2S() integration.Option {
+	return func(cmd *integration.Cmd) error {
+		s2sListener, err := cmd.S2SListen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		// See the comment in ListenC2S.
+		s2sPort, err := integration.Port(s2sListener)
+		if err != nil {
+			return err
+		}
+		err = s2sListener.Close()
+		if err != nil {
+			return err
+		}
+
+		cfg := getConfig(cmd)
+		cfg.S2SPort = s2sPort
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// Domain sets the XMPP domain served by Openfire.
+// Unlike Prosody, Openfire only supports a single domain per server.
+// The default if this option is not provided is "localhost".
+func Domain(domain string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		cfg.Domain = domain
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// CreateUser returns an option that calls the Openfire REST API to create a
+// user after the server has started.
+// It also configures the underlying Cmd to know about the user.
+func CreateUser(ctx context.Context, addr, pass string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		j, err := jid.Parse(addr)
+		if err != nil {
+			return err
+		}
+		err = integration.Defer(func(cmd *integration.Cmd) error {
+			return restCreateUser(ctx, cmd, j.Localpart(), pass)
+		})(cmd)
+		if err != nil {
+			return err
+		}
+		return integration.User(j, pass)(cmd)
+	}
+}
+
+func restCreateUser(ctx context.Context, cmd *integration.Cmd, username, pass string) error {
+	cfg := getConfig(cmd)
+	body, err := json.Marshal(struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{
+		Username: username,
+		Password: pass,
+	})
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("http://127.0.0.1:%d%s", cfg.AdminPort, usersPath)
+
+	// The REST API plugin is loaded some time after the XMPP ports start
+	// accepting connections, so retry until it becomes available.
+	timeout := time.Second
+	for attempts := 10; attempts > 0; attempts-- {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", cfg.Secret)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			/* #nosec */
+			io.Copy(ioutil.Discard, resp.Body)
+			/* #nosec */
+			resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusCreated, http.StatusOK:
+				return nil
+			case http.StatusNotFound, http.StatusServiceUnavailable:
+			default:
+				return fmt.Errorf("openfire: error creating user %q: %s", username, resp.Status)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(timeout):
+		}
+		timeout += 500 * time.Millisecond
+	}
+	return fmt.Errorf("openfire: REST API not available, is the plugin installed?")
+}
+
+// home creates an Openfire home directory in the commands config dir by linking
+// in the libraries and plugins from the Openfire installation.
+// This lets us use a temporary configuration and database without modifying
+// the global installation.
+func home(cmd *integration.Cmd) error {
+	binPath, err := exec.LookPath(cmdName)
+	if err != nil {
+		return err
+	}
+	binPath, err = filepath.EvalSymlinks(binPath)
+	if err != nil {
+		return err
+	}
+	installDir := filepath.Dir(filepath.Dir(binPath))
+
+	err = os.Symlink(filepath.Join(installDir, "lib"), filepath.Join(cmd.ConfigDir(), "lib"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"plugins", "resources/security", "logs"} {
+		err = os.MkdirAll(filepath.Join(cmd.ConfigDir(), dir), 0700)
+		if err != nil {
+			return err
+		}
+	}
+	plugins, err := ioutil.ReadDir(filepath.Join(installDir, "plugins"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, plugin := range plugins {
+		err = os.Symlink(
+			filepath.Join(installDir, "plugins", plugin.Name()),
+			filepath.Join(cmd.ConfigDir(), "plugins", plugin.Name()),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return integration.Env(homeEnv, cmd.ConfigDir())(cmd)
+}
+
+func defaultConfig(cmd *integration.Cmd) error {
+	cfg := getConfig(cmd)
+	if cfg.Domain == "" {
+		cfg.Domain = "localhost"
+	}
+	if cfg.AdminPass == "" {
+		cfg.AdminPass = "password"
+	}
+	if cfg.Secret == "" {
+		cfg.Secret = attr.RandomID()
+	}
+	if cfg.AdminPort == 0 {
+		httpListener, err := cmd.HTTPListen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		// See the comment in ListenC2S.
+		cfg.AdminPort, err = integration.Port(httpListener)
+		if err != nil {
+			return err
+		}
+		err = httpListener.Close()
+		if err != nil {
+			return err
+		}
+	}
+	cmd.Config = cfg
+	if j, _ := cmd.User(); j.Equal(jid.JID{}) {
+		err := CreateUser(context.TODO(), "me@"+cfg.Domain, "password")(cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ConfigFile(cfg)(cmd)
+}
+
+func shutdown(cmd *integration.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// Test starts an Openfire instance and returns a function that runs subtests
+// using t.Run.
+// Multiple calls to the returned function will result in uniquely named
+// subtests.
+// When all subtests have completed, the daemon is stopped.
+func Test(ctx context.Context, t *testing.T, opts ...integration.Option) integration.SubtestRunner {
+	s := Server(ctx, opts...)
+	return integration.Test(ctx, s.Name, t, s.Opts...)
+}
+
+// Benchmark starts an Openfire instance and returns a function that runs
+// sub-benchmarks using b.Run.
+// Multiple calls to the returned function will result in uniquely named
+// sub-benchmarks.
+// When all sub-benchmarks have completed, the daemon is stopped.
+func Benchmark(ctx context.Context, b *testing.B, opts ...integration.Option) integration.SubbenchRunner {
+	s := Server(ctx, opts...)
+	return integration.Benchmark(ctx, s.Name, b, s.Opts...)
+}
+
+// Server returns an Openfire server configured with the same defaults used by
+// Test.
+// It is meant to be passed to integration.Federate.
+func Server(ctx context.Context, opts ...integration.Option) integration.Server {
+	opts = append(opts, home, defaultConfig,
+		integration.Shutdown(shutdown))
+	return integration.Server{Name: cmdName, Opts: opts}
+}
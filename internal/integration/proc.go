@@ -0,0 +1,27 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+// CommandName is an option that runs the command path whenever the command
+// name would have been run.
+// It applies both to the main command and to helper commands run using Command,
+// such as "prosodyctl" or "ejabberdctl".
+// It has no effect on commands run in a container.
+// This lets tests run against servers that are installed under a different
+// name or in a location that is not in the PATH, for example on Windows where
+// the control scripts may be batch files.
+//
+// Because exec.LookPath already tries the extensions in PATHEXT on Windows,
+// this option is not required if the command is in the PATH under its normal
+// name.
+func CommandName(name, path string) Option {
+	return func(cmd *Cmd) error {
+		if cmd.commandNames == nil {
+			cmd.commandNames = make(map[string]string)
+		}
+		cmd.commandNames[name] = path
+		return nil
+	}
+}
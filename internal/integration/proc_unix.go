@@ -0,0 +1,39 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//+build !windows
+
+package integration
+
+import (
+	"os"
+	"os/exec"
+)
+
+// procGroup tracks a running command and any processes it spawns.
+// On Unix like systems no extra tracking is required.
+type procGroup struct{}
+
+// prepareProc configures c before it is started.
+func prepareProc(c *exec.Cmd) {}
+
+// newProcGroup starts tracking the process p.
+func newProcGroup(p *os.Process) (procGroup, error) {
+	return procGroup{}, nil
+}
+
+// interrupt asks the process to shut down gracefully.
+func (procGroup) interrupt(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
+
+// kill stops the process immediately.
+func (procGroup) kill(p *os.Process) error {
+	return p.Kill()
+}
+
+// release frees any resources used to track the process.
+func (procGroup) release() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//+build windows
+
+package integration
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// procGroup tracks a running command and any processes it spawns.
+// On Windows killing a process does not kill its children, so servers that are
+// started using a wrapper script (such as prosodyctl or ejabberdctl) are
+// assigned to a job object that lets us terminate the entire process tree.
+type procGroup struct {
+	job windows.Handle
+}
+
+// prepareProc configures c before it is started.
+// The command is started in a new process group so that a console control
+// event can be sent to it without also interrupting the tests.
+func prepareProc(c *exec.Cmd) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+}
+
+// newProcGroup creates a job object that will kill p and any processes that it
+// spawns when the job is terminated or released.
+// Processes spawned by p before it was assigned to the job are not tracked.
+func newProcGroup(p *os.Process) (procGroup, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return procGroup{}, err
+	}
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	_, err = windows.SetInformationJobObject(
+		job,
+		windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)),
+		uint32(unsafe.Sizeof(info)),
+	)
+	if err != nil {
+		/* #nosec */
+		windows.CloseHandle(job)
+		return procGroup{}, err
+	}
+	h, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(p.Pid))
+	if err != nil {
+		/* #nosec */
+		windows.CloseHandle(job)
+		return procGroup{}, err
+	}
+	/* #nosec */
+	defer windows.CloseHandle(h)
+	err = windows.AssignProcessToJobObject(job, h)
+	if err != nil {
+		/* #nosec */
+		windows.CloseHandle(job)
+		return procGroup{}, err
+	}
+	return procGroup{job: job}, nil
+}
+
+// interrupt asks the process to shut down gracefully by sending it a
+// CTRL_BREAK_EVENT.
+// If the event cannot be delivered (for example, because the process does not
+// share our console), taskkill is used to ask the process tree to close
+// instead.
+func (procGroup) interrupt(p *os.Process) error {
+	err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(p.Pid))
+	if err == nil {
+		return nil
+	}
+	/* #nosec */
+	return exec.Command("taskkill", "/T", "/PID", strconv.Itoa(p.Pid)).Run()
+}
+
+// kill terminates the process and every process in its job.
+func (g procGroup) kill(p *os.Process) error {
+	if g.job == 0 {
+		/* #nosec */
+		return exec.Command("taskkill", "/F", "/T", "/PID", strconv.Itoa(p.Pid)).Run()
+	}
+	return windows.TerminateJobObject(g.job, 1)
+}
+
+// release closes the job object, killing any processes that are still running
+// in it.
+func (g procGroup) release() error {
+	if g.job == 0 {
+		return nil
+	}
+	return windows.CloseHandle(g.job)
+}
@@ -5,24 +5,229 @@
 package prosody
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"text/template"
+
+	"mellium.im/xmpp/jid"
 )
 
 // Config contains options that can be written to a Prosody config file.
 type Config struct {
-	C2SPort   int
-	S2SPort   int
-	CompPort  int
-	HTTPPort  int
-	HTTPSPort int
-	Admins    []string
-	Modules   []string
-	VHosts    []string
-	Options   map[string]interface{}
-	Component map[string]string
+	C2SPort          int
+	S2SPort          int
+	C2SDirectTLSPort int
+	S2SDirectTLSPort int
+	CompPort         int
+	HTTPPort         int
+	HTTPSPort        int
+	Admins           []string
+	Modules          []string
+	VHosts           []string
+	Options          map[string]interface{}
+	Component        map[string]string
+	MUC              []string
+	Upload           string
+
+	// Storage is the storage backend, one of "internal" (the default),
+	// "memory", "sql", "xep0227", or "null".
+	Storage string
+
+	// LogLevel is the minimum level of messages logged to the console, one of
+	// "debug", "info" (the default), "warn", or "error".
+	// Debug messages are always written to the log file.
+	LogLevel string
+
+	// SSL contains the global TLS options.
+	SSL SSLOptions
+
+	// HostOptions contains options that are written in the section of the given
+	// virtual host or component instead of in the global section.
+	HostOptions map[string]map[string]interface{}
+
+	// HostModules contains modules that are only enabled for the given virtual
+	// host or component in addition to the global modules.
+	HostModules map[string][]string
+
+	// Peers maps the domains of remote servers to the address of their s2s
+	// listener.
+	// Outgoing s2s connections to these domains are made to the given address
+	// instead of the one found using DNS, and the remote servers are not required
+	// to present a valid certificate.
+	Peers map[string]*net.TCPAddr
+}
+
+// SSLOptions contains TLS options for Prosody.
+// The zero value uses Prosody's defaults.
+type SSLOptions struct {
+	// Protocol is the minimum TLS version, for example "tlsv1_2+".
+	Protocol string
+
+	// Ciphers is an OpenSSL cipher list.
+	Ciphers string
+
+	// Options are extra OpenSSL options, for example "no_ticket".
+	Options []string
+}
+
+var (
+	luaIdent    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	sslProtocol = regexp.MustCompile(`^(sslv23|tlsv1(_[123])?)\+?$`)
+)
+
+// reservedOptions are keys that are written by the config template and may not
+// be changed with Set, mapped to the option that should be used instead.
+var reservedOptions = map[string]string{
+	"admins":                "the Admins field",
+	"c2s_direct_tls_ports":  "ListenC2SDirectTLS",
+	"c2s_ports":             "ListenC2S",
+	"certificates":          "integration.Cert",
+	"component_ports":       "Component",
+	"daemonize":             "",
+	"data_path":             "",
+	"http_ports":            "HTTPS",
+	"https_ports":           "HTTPS",
+	"log":                   "LogLevel",
+	"modules_enabled":       "Modules",
+	"pidfile":               "",
+	"plugin_paths":          "",
+	"s2s_connect_overrides": "the Peers field",
+	"s2s_direct_tls_ports":  "ListenS2SDirectTLS",
+	"s2s_insecure_domains":  "the Peers field",
+	"s2s_ports":             "ListenS2S",
+	"ssl":                   "SSL",
+	"storage":               "Storage",
+}
+
+// Validate reports errors in the config that would prevent Prosody from
+// starting or that would result in settings being silently ignored.
+func (cfg Config) Validate() error {
+	if len(cfg.VHosts) == 0 {
+		return errors.New("prosody: no virtual hosts configured")
+	}
+
+	hosts := make(map[string]struct{})
+	addHost := func(typ, host string) error {
+		if _, err := jid.New("", host, ""); err != nil {
+			return fmt.Errorf("prosody: invalid %s %q: %w", typ, host, err)
+		}
+		if _, ok := hosts[host]; ok {
+			return fmt.Errorf("prosody: host %q is configured more than once", host)
+		}
+		hosts[host] = struct{}{}
+		return nil
+	}
+	for _, host := range cfg.VHosts {
+		if err := addHost("virtual host", host); err != nil {
+			return err
+		}
+	}
+	for _, host := range cfg.MUC {
+		if err := addHost("MUC component", host); err != nil {
+			return err
+		}
+	}
+	for host := range cfg.Component {
+		if err := addHost("component", host); err != nil {
+			return err
+		}
+	}
+	if cfg.Upload != "" {
+		if err := addHost("upload component", cfg.Upload); err != nil {
+			return err
+		}
+	}
+
+	ports := make(map[int]string)
+	for _, p := range []struct {
+		name string
+		port int
+	}{
+		{name: "c2s", port: cfg.C2SPort},
+		{name: "s2s", port: cfg.S2SPort},
+		{name: "c2s direct TLS", port: cfg.C2SDirectTLSPort},
+		{name: "s2s direct TLS", port: cfg.S2SDirectTLSPort},
+		{name: "component", port: cfg.CompPort},
+		{name: "HTTP", port: cfg.HTTPPort},
+		{name: "HTTPS", port: cfg.HTTPSPort},
+	} {
+		switch {
+		case p.port == 0:
+			continue
+		case p.port < 0 || p.port > 65535:
+			return fmt.Errorf("prosody: %s port %d out of range", p.name, p.port)
+		}
+		if other, ok := ports[p.port]; ok {
+			return fmt.Errorf("prosody: %s and %s ports are both %d", other, p.name, p.port)
+		}
+		ports[p.port] = p.name
+	}
+
+	switch cfg.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("prosody: unknown log level %q", cfg.LogLevel)
+	}
+	switch cfg.Storage {
+	case "", "internal", "memory", "sql", "xep0227", "null":
+	default:
+		return fmt.Errorf("prosody: unknown storage backend %q", cfg.Storage)
+	}
+	if cfg.SSL.Protocol != "" && !sslProtocol.MatchString(cfg.SSL.Protocol) {
+		return fmt.Errorf("prosody: unknown TLS protocol %q", cfg.SSL.Protocol)
+	}
+
+	for k, v := range cfg.Options {
+		if use, ok := reservedOptions[k]; ok {
+			if use == "" {
+				return fmt.Errorf("prosody: option %q is managed by the integration package and cannot be set", k)
+			}
+			return fmt.Errorf("prosody: option %q cannot be set, use %s instead", k, use)
+		}
+		// Keys with no value are written verbatim (for example to add a section),
+		// so only check the keys of options with a value.
+		if v != nil && !luaIdent.MatchString(k) {
+			return fmt.Errorf("prosody: invalid option name %q", k)
+		}
+	}
+	for host, opts := range cfg.HostOptions {
+		if _, ok := hosts[host]; !ok {
+			return fmt.Errorf("prosody: options set for unknown host %q", host)
+		}
+		for k, v := range opts {
+			if k == "modules_enabled" {
+				return fmt.Errorf("prosody: option %q cannot be set for host %q, use HostModules instead", k, host)
+			}
+			if !luaIdent.MatchString(k) {
+				return fmt.Errorf("prosody: invalid option name %q for host %q", k, host)
+			}
+			if v == nil {
+				return fmt.Errorf("prosody: option %q for host %q has no value", k, host)
+			}
+		}
+	}
+	for host := range cfg.HostModules {
+		if _, ok := hosts[host]; !ok {
+			return fmt.Errorf("prosody: modules enabled for unknown host %q", host)
+		}
+	}
+	for domain, addr := range cfg.Peers {
+		if _, err := jid.New("", domain, ""); err != nil {
+			return fmt.Errorf("prosody: invalid peer %q: %w", domain, err)
+		}
+		if _, ok := hosts[domain]; ok {
+			return fmt.Errorf("prosody: peer %q is also configured locally", domain)
+		}
+		if addr == nil || addr.Port == 0 {
+			return fmt.Errorf("prosody: peer %q has no s2s address", domain)
+		}
+	}
+	return nil
 }
 
 const cfgBase = `daemonize = false
@@ -34,17 +239,12 @@ http_interfaces = { "::1", "127.0.0.1" }
 https_interfaces = { "::1", "127.0.0.1" }
 {{ if .C2SPort }}c2s_ports = { {{ .C2SPort }} }{{ end }}
 {{ if .S2SPort }}s2s_ports = { {{ .S2SPort }} }{{ end }}
+{{ if .C2SDirectTLSPort }}c2s_direct_tls_ports = { {{ .C2SDirectTLSPort }} }{{ end }}
+{{ if .S2SDirectTLSPort }}s2s_direct_tls_ports = { {{ .S2SDirectTLSPort }} }{{ end }}
 {{ if .CompPort }}component_ports = { {{.CompPort}} }{{ end }}
 {{ if .HTTPPort }}http_ports = { {{.HTTPPort}} }{{ end }}
 {{ if .HTTPSPort }}https_ports = { {{.HTTPSPort}} }{{ end }}
 
--- Settings added with prosody.Set:
-{{ range $k, $opt := .Options }}
-{{ $k }}{{ if $opt }} = {{ quoteOrPrint $opt }}{{ end }}
-{{ else }}
--- Set not called.
-{{ end }}
-
 cross_domain_websocket = true
 consider_websocket_secure = true
 
@@ -78,8 +278,8 @@ modules_enabled = {
 }
 
 modules_disabled = {
-  {{ if not .C2SPort }}"c2s";{{ end }}
-  {{ if not .S2SPort }}"s2s";{{ end }}
+  {{ if not (or .C2SPort .C2SDirectTLSPort) }}"c2s";{{ end }}
+  {{ if not (or .S2SPort .S2SDirectTLSPort) }}"s2s";{{ end }}
 }
 
 plugin_paths = { "{{ .ConfigDir }}" }
@@ -87,27 +287,63 @@ allow_registration = false
 c2s_require_encryption = true
 s2s_require_encryption = true
 s2s_secure_auth = false
-s2s_insecure_domains = { {{ joinQuote .VHosts }} }
+s2s_insecure_domains = { {{ joinQuote .VHosts }}{{ range $domain, $_ := .Peers }},{{ printf "%q" $domain }}{{ end }} }
+{{ if .Peers }}s2s_connect_overrides = {
+{{- range $domain, $addr := .Peers }}
+	[{{ printf "%q" $domain }}] = { {{ printf "%q" $addr.IP.String }}, {{ $addr.Port }} };
+{{- end }}
+}{{ end }}
 authentication = "internal_plain"
-storage = "internal"
+storage = "{{ or .Storage "internal" }}"
 
 log = {
-	{ levels = { min = "info" }, to = "console" };
+	{ levels = { min = "{{ or .LogLevel "info" }}" }, to = "console" };
 	{ levels = { min = "debug" }, to = "file", filename = "{{ filepathJoin .ConfigDir "prosody.log" }}" };
 }
 
 statistics = "internal"
 certificates = "{{ .ConfigDir }}"
 {{ if .HTTPSPort }}https_certificate = "{{ filepathJoin .ConfigDir "localhost:" }}{{ .HTTPSPort }}.crt"{{ end }}
+{{ if or .SSL.Protocol .SSL.Ciphers .SSL.Options }}ssl = {
+	{{- if .SSL.Protocol }}
+	protocol = {{ printf "%q" .SSL.Protocol }};
+	{{- end }}
+	{{- if .SSL.Ciphers }}
+	ciphers = {{ printf "%q" .SSL.Ciphers }};
+	{{- end }}
+	{{- if .SSL.Options }}
+	options = { {{ joinQuote .SSL.Options }} };
+	{{- end }}
+}{{ end }}
+
+-- Settings added with prosody.Set:
+{{ range $k, $opt := .Options }}
+{{ $k }}{{ if $opt }} = {{ quoteOrPrint $opt }}{{ end }}
+{{ else }}
+-- Set not called.
+{{ end }}
 
 {{- range .VHosts }}
 VirtualHost "{{ . }}"
+{{- hostSection $.Config . }}
 {{- end }}
 
 {{ range $domain, $secret := .Component }}
 Component "{{$domain}}"
          component_secret = "{{$secret}}"
-{{ end }}`
+{{- hostSection $.Config $domain }}
+{{ end }}
+
+{{- range .MUC }}
+Component "{{ . }}" "muc"
+{{- hostSection $.Config . }}
+{{- end }}
+
+{{- if .Upload }}
+Component "{{ .Upload }}" "http_file_share"
+         http_external_url = "http://localhost:{{ .HTTPPort }}/"
+{{- hostSection $.Config .Upload }}
+{{- end }}`
 
 var cfgTmpl = template.Must(template.New("cfg").Funcs(template.FuncMap{
 	"filepathJoin": filepath.Join,
@@ -129,12 +365,34 @@ var cfgTmpl = template.Must(template.New("cfg").Funcs(template.FuncMap{
 		}
 		return strings.Join(s, ";\n") + end
 	},
-	"quoteOrPrint": func(v interface{}) string {
-		switch vv := v.(type) {
-		case string:
-			return fmt.Sprintf("%q", vv)
-		default:
-			return fmt.Sprintf("%v", vv)
+	"quoteOrPrint": quoteOrPrint,
+	"hostSection": func(cfg Config, host string) string {
+		var b strings.Builder
+		if mods := cfg.HostModules[host]; len(mods) > 0 {
+			quoted := make([]string, 0, len(mods))
+			for _, mod := range mods {
+				quoted = append(quoted, fmt.Sprintf("%q", mod))
+			}
+			fmt.Fprintf(&b, "\n         modules_enabled = { %s }", strings.Join(quoted, ", "))
+		}
+		opts := cfg.HostOptions[host]
+		keys := make([]string, 0, len(opts))
+		for k := range opts {
+			keys = append(keys, k)
 		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "\n         %s = %s", k, quoteOrPrint(opts[k]))
+		}
+		return b.String()
 	},
 }).Parse(cfgBase))
+
+func quoteOrPrint(v interface{}) string {
+	switch vv := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", vv)
+	default:
+		return fmt.Sprintf("%v", vv)
+	}
+}
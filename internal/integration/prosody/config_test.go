@@ -0,0 +1,303 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package prosody
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp/internal/integration"
+)
+
+var peerAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5269}
+
+var validateTestCases = [...]struct {
+	cfg Config
+	err string
+}{
+	0: {
+		cfg: Config{VHosts: []string{"localhost"}},
+	},
+	1: {
+		err: "no virtual hosts",
+	},
+	2: {
+		cfg: Config{VHosts: []string{"localhost", ""}},
+		err: `invalid virtual host ""`,
+	},
+	3: {
+		cfg: Config{VHosts: []string{"localhost"}, MUC: []string{"localhost"}},
+		err: `host "localhost" is configured more than once`,
+	},
+	4: {
+		cfg: Config{VHosts: []string{"localhost"}, C2SPort: 65536},
+		err: "c2s port 65536 out of range",
+	},
+	5: {
+		cfg: Config{VHosts: []string{"localhost"}, C2SPort: 5222, HTTPSPort: 5222},
+		err: "c2s and HTTPS ports are both 5222",
+	},
+	6: {
+		cfg: Config{VHosts: []string{"localhost"}, LogLevel: "trace"},
+		err: `unknown log level "trace"`,
+	},
+	7: {
+		cfg: Config{VHosts: []string{"localhost"}, Storage: "redis"},
+		err: `unknown storage backend "redis"`,
+	},
+	8: {
+		cfg: Config{VHosts: []string{"localhost"}, SSL: SSLOptions{Protocol: "tlsv1_4+"}},
+		err: `unknown TLS protocol "tlsv1_4+"`,
+	},
+	9: {
+		cfg: Config{VHosts: []string{"localhost"}, SSL: SSLOptions{Protocol: "tlsv1_2+"}},
+	},
+	10: {
+		cfg: Config{VHosts: []string{"localhost"}, Options: map[string]interface{}{"s2s_connect_overrides": "x"}},
+		err: `option "s2s_connect_overrides" cannot be set, use the Peers field instead`,
+	},
+	11: {
+		cfg: Config{VHosts: []string{"localhost"}, Options: map[string]interface{}{"s2s_insecure_domains": "x"}},
+		err: `option "s2s_insecure_domains" cannot be set, use the Peers field instead`,
+	},
+	12: {
+		cfg: Config{VHosts: []string{"localhost"}, Options: map[string]interface{}{"daemonize": true}},
+		err: `option "daemonize" is managed by the integration package`,
+	},
+	13: {
+		cfg: Config{VHosts: []string{"localhost"}, Options: map[string]interface{}{"foo bar": 1}},
+		err: `invalid option name "foo bar"`,
+	},
+	14: {
+		cfg: Config{VHosts: []string{"localhost"}, Options: map[string]interface{}{`Component "muc.localhost" "muc"`: nil}},
+	},
+	15: {
+		cfg: Config{VHosts: []string{"localhost"}, HostOptions: map[string]map[string]interface{}{"muc.localhost": {"foo": 1}}},
+		err: `options set for unknown host "muc.localhost"`,
+	},
+	16: {
+		cfg: Config{VHosts: []string{"localhost"}, HostOptions: map[string]map[string]interface{}{"localhost": {"modules_enabled": "x"}}},
+		err: "use HostModules instead",
+	},
+	17: {
+		cfg: Config{VHosts: []string{"localhost"}, HostOptions: map[string]map[string]interface{}{"localhost": {"foo": nil}}},
+		err: `option "foo" for host "localhost" has no value`,
+	},
+	18: {
+		cfg: Config{VHosts: []string{"localhost"}, HostModules: map[string][]string{"muc.localhost": {"muc_mam"}}},
+		err: `modules enabled for unknown host "muc.localhost"`,
+	},
+	19: {
+		cfg: Config{VHosts: []string{"localhost"}, Peers: map[string]*net.TCPAddr{"peer.localhost": peerAddr}},
+	},
+	20: {
+		cfg: Config{VHosts: []string{"localhost"}, Peers: map[string]*net.TCPAddr{"localhost": peerAddr}},
+		err: `peer "localhost" is also configured locally`,
+	},
+	21: {
+		cfg: Config{VHosts: []string{"localhost"}, Peers: map[string]*net.TCPAddr{"peer.localhost": nil}},
+		err: `peer "peer.localhost" has no s2s address`,
+	},
+	22: {
+		cfg: Config{VHosts: []string{"localhost"}, Peers: map[string]*net.TCPAddr{"": peerAddr}},
+		err: `invalid peer ""`,
+	},
+}
+
+func TestValidate(t *testing.T) {
+	for i, tc := range validateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := tc.cfg.Validate()
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.err != "" && err == nil:
+				t.Errorf("expected error containing %q", tc.err)
+			case err != nil && !strings.Contains(err.Error(), tc.err):
+				t.Errorf("wrong error: want=%q, got=%q", tc.err, err)
+			}
+		})
+	}
+}
+
+func writeConfig(t *testing.T, cfg Config) string {
+	t.Helper()
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	var b strings.Builder
+	err = cfgTmpl.Execute(&b, struct {
+		Config
+		ConfigDir string
+	}{
+		Config:    cfg,
+		ConfigDir: "/tmp/prosody",
+	})
+	if err != nil {
+		t.Fatalf("error executing template: %v", err)
+	}
+	return b.String()
+}
+
+// fullConfig sets every field that results in a reserved option being written
+// to the config file.
+var fullConfig = Config{
+	C2SPort:          5222,
+	S2SPort:          5269,
+	C2SDirectTLSPort: 5223,
+	S2SDirectTLSPort: 5270,
+	CompPort:         5347,
+	HTTPPort:         5280,
+	HTTPSPort:        5281,
+	Admins:           []string{"admin@localhost"},
+	Modules:          []string{"mam"},
+	VHosts:           []string{"localhost"},
+	Storage:          "memory",
+	LogLevel:         "debug",
+	SSL:              SSLOptions{Protocol: "tlsv1_2+"},
+	Peers:            map[string]*net.TCPAddr{"peer.localhost": peerAddr},
+}
+
+func TestReservedOptions(t *testing.T) {
+	out := writeConfig(t, fullConfig)
+	for k := range reservedOptions {
+		t.Run(k, func(t *testing.T) {
+			if !regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(k) + ` = `).MatchString(out) {
+				t.Errorf("reserved option is not written by the template")
+			}
+			cfg := fullConfig
+			cfg.Options = map[string]interface{}{k: "x"}
+			if err := cfg.Validate(); err == nil {
+				t.Errorf("expected setting reserved option to be an error")
+			}
+		})
+	}
+}
+
+var templateTestCases = [...]struct {
+	cfg  Config
+	want []string
+}{
+	0: {
+		cfg: Config{VHosts: []string{"localhost"}},
+		want: []string{
+			`s2s_insecure_domains = { "localhost" }`,
+			`storage = "internal"`,
+			"-- Set not called.",
+			`VirtualHost "localhost"`,
+		},
+	},
+	1: {
+		cfg: Config{
+			VHosts: []string{"localhost"},
+			Peers: map[string]*net.TCPAddr{
+				"b.localhost": {IP: net.IPv6loopback, Port: 5270},
+				"a.localhost": peerAddr,
+			},
+		},
+		want: []string{
+			`s2s_insecure_domains = { "localhost","a.localhost","b.localhost" }`,
+			"s2s_connect_overrides = {\n" +
+				"\t[\"a.localhost\"] = { \"127.0.0.1\", 5269 };\n" +
+				"\t[\"b.localhost\"] = { \"::1\", 5270 };\n" +
+				"}",
+		},
+	},
+	2: {
+		cfg: Config{
+			VHosts:      []string{"localhost"},
+			MUC:         []string{"muc.localhost"},
+			HostModules: map[string][]string{"muc.localhost": {"muc_mam"}},
+			HostOptions: map[string]map[string]interface{}{
+				"muc.localhost": {"restrict_room_creation": true, "name": "Rooms"},
+			},
+		},
+		want: []string{
+			"Component \"muc.localhost\" \"muc\"\n" +
+				"         modules_enabled = { \"muc_mam\" }\n" +
+				"         name = \"Rooms\"\n" +
+				"         restrict_room_creation = true",
+		},
+	},
+	3: {
+		cfg: Config{
+			VHosts: []string{"localhost"},
+			Options: map[string]interface{}{
+				"foo":                           "bar",
+				"num":                           123,
+				`Component "x.localhost" "muc"`: nil,
+			},
+		},
+		want: []string{
+			"foo = \"bar\"\n",
+			"num = 123\n",
+			"\nComponent \"x.localhost\" \"muc\"\n",
+		},
+	},
+}
+
+func TestTemplate(t *testing.T) {
+	for i, tc := range templateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := writeConfig(t, tc.cfg)
+			for _, want := range tc.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("expected output to contain %q, got:\n%s", want, out)
+				}
+			}
+			if len(tc.cfg.Peers) == 0 && strings.Contains(out, "s2s_connect_overrides") {
+				t.Errorf("did not expect connect overrides without peers, got:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	cmd := &integration.Cmd{}
+	for _, opt := range []integration.Option{
+		VHost("localhost"),
+		MUC("muc.localhost"),
+		Set("foo", "bar"),
+		HostSet("muc.localhost", "restrict_room_creation", true),
+		HostSet("localhost", "foo", 1),
+	} {
+		err := opt(cmd)
+		if err != nil {
+			t.Fatalf("error applying option: %v", err)
+		}
+	}
+	cfg := getConfig(cmd)
+	if v := cfg.Options["foo"]; v != "bar" {
+		t.Errorf("wrong global option: want=bar, got=%v", v)
+	}
+	if v := cfg.HostOptions["muc.localhost"]["restrict_room_creation"]; v != true {
+		t.Errorf("wrong host option: want=true, got=%v", v)
+	}
+	if v := cfg.HostOptions["localhost"]["foo"]; v != 1 {
+		t.Errorf("wrong host option: want=1, got=%v", v)
+	}
+	out := writeConfig(t, cfg)
+	for _, want := range []string{
+		"foo = \"bar\"\n",
+		"VirtualHost \"localhost\"\n         foo = 1",
+		"Component \"muc.localhost\" \"muc\"\n         restrict_room_creation = true",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	err := Set("storage", "sql")(cmd)
+	if err != nil {
+		t.Fatalf("error applying option: %v", err)
+	}
+	if err = getConfig(cmd).Validate(); err == nil {
+		t.Errorf("expected setting a reserved option to be rejected")
+	}
+}
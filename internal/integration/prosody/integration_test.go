@@ -0,0 +1,54 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//+build integration
+
+package prosody_test
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/internal/integration/prosody"
+	"mellium.im/xmpp/ping"
+)
+
+func TestIntegrationFederate(t *testing.T) {
+	run := integration.Federate(context.TODO(), t,
+		prosody.Server(context.TODO(), integration.Log(), prosody.ListenC2S()),
+		prosody.Server(context.TODO(), integration.Log(), prosody.ListenC2S()),
+	)
+	run(func(ctx context.Context, t *testing.T, a, b *integration.Cmd) {
+		j, pass := a.User()
+		peer, _ := b.User()
+		if j.Domain().Equal(peer.Domain()) {
+			t.Fatalf("federated servers have the same domain: %v", j.Domain())
+		}
+		session, err := a.DialClient(ctx, j, t,
+			xmpp.StartTLS(&tls.Config{
+				InsecureSkipVerify: true,
+			}),
+			xmpp.SASL("", pass, sasl.Plain),
+			xmpp.BindResource(),
+		)
+		if err != nil {
+			t.Fatalf("error connecting: %v", err)
+		}
+		go func() {
+			err := session.Serve(nil)
+			if err != nil {
+				t.Logf("error from serve: %v", err)
+			}
+		}()
+		// The remote server can only respond if the two servers federate.
+		err = ping.Send(ctx, session, peer.Domain())
+		if err != nil {
+			t.Errorf("error pinging remote server: %v", err)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package prosody
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/jid"
+)
+
+// ArchivePolicy controls which messages are archived by default for users who
+// have not configured their own archiving preferences.
+type ArchivePolicy uint8
+
+// A list of archive policies.
+const (
+	// ArchiveAlways archives all messages.
+	ArchiveAlways ArchivePolicy = iota
+
+	// ArchiveRoster archives messages from contacts in the users roster.
+	ArchiveRoster
+
+	// ArchiveNever does not archive messages unless the user requests it.
+	ArchiveNever
+)
+
+// archiveMarker is printed at the start of every line of output that contains
+// an archived message so that it can be told apart from other shell output.
+const archiveMarker = "mellium-archive"
+
+// MAM enables Prosody's message archive (mod_mam) with the provided default
+// archive policy.
+// It also enables the admin shell so that the archive can later be inspected
+// using Archive.
+//
+//     -- MAM(ArchiveRoster)
+//     default_archive_policy = "roster"
+func MAM(policy ArchivePolicy) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		var v interface{}
+		switch policy {
+		case ArchiveAlways:
+			v = true
+		case ArchiveRoster:
+			v = "roster"
+		case ArchiveNever:
+			v = false
+		default:
+			return fmt.Errorf("prosody: unknown archive policy %d", policy)
+		}
+		err := Set("default_archive_policy", v)(cmd)
+		if err != nil {
+			return err
+		}
+		err = shell()(cmd)
+		if err != nil {
+			return err
+		}
+		cfg := getConfig(cmd)
+		for _, mod := range cfg.Modules {
+			if mod == "mam" {
+				return nil
+			}
+		}
+		return Modules("mam")(cmd)
+	}
+}
+
+// ArchivedMessage is a message that was found in a users archive.
+type ArchivedMessage struct {
+	// ID is the archive ID of the message.
+	ID string
+
+	// With is the address of the other party in the conversation.
+	With jid.JID
+
+	// When is the time at which the message was archived.
+	When time.Time
+
+	// Stanza is the archived message as it was serialized by Prosody.
+	Stanza string
+}
+
+// Archive returns the messages stored in the archive of user on a running
+// server using "prosodyctl shell".
+// The MAM option must have been used when creating the command.
+//
+// Unlike querying the archive over XMPP, this shows what the server actually
+// persisted.
+func Archive(ctx context.Context, cmd *integration.Cmd, user jid.JID) ([]ArchivedMessage, error) {
+	script := fmt.Sprintf(`> local archive = require"core.storagemanager".open(%q, "archive"); local iter, err = archive:find(%q, {}); if not iter then print(%q .. "\terror\t" .. tostring(err)); return end; for id, item, when, with in iter do print(%q .. "\t" .. id .. "\t" .. string.format("%%.6f", when) .. "\t" .. tostring(with) .. "\t" .. tostring(item):gsub("\n", "&#10;")) end`+"\n",
+		user.Domainpart(), user.Localpart(), archiveMarker, archiveMarker)
+	out, err := runShell(ctx, cmd, script)
+	if err != nil {
+		return nil, fmt.Errorf("prosody: error reading archive for %s: %w\n%s", user, err, out)
+	}
+	return parseArchive(out)
+}
+
+func parseArchive(out []byte) ([]ArchivedMessage, error) {
+	var msgs []ArchivedMessage
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), "| ")
+		if !strings.HasPrefix(line, archiveMarker+"\t") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) == 3 && fields[1] == "error" {
+			return nil, fmt.Errorf("prosody: error reading archive: %s", fields[2])
+		}
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("prosody: unexpected archive output: %q", line)
+		}
+		secs, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("prosody: bad archive timestamp: %w", err)
+		}
+		with, err := jid.Parse(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("prosody: bad archive address: %w", err)
+		}
+		whole, frac := math.Modf(secs)
+		msgs = append(msgs, ArchivedMessage{
+			ID:     fields[1],
+			With:   with,
+			When:   time.Unix(int64(whole), int64(frac*1e9)).UTC(),
+			Stanza: fields[4],
+		})
+	}
+	return msgs, scanner.Err()
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package prosody
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/jid"
+)
+
+const shellModule = "admin_shell"
+
+// MUC adds a multi-user chat component with the given domain to the config
+// file.
+//
+//     -- MUC("muc.localhost")
+//     Component "muc.localhost" "muc"
+func MUC(domain string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		for _, d := range cfg.MUC {
+			if d == domain {
+				return nil
+			}
+		}
+		cfg.MUC = append(cfg.MUC, domain)
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// CreateRoom returns an option that creates a persistent room after the server
+// has started using "prosodyctl shell".
+// The domainpart of room must have been configured as a MUC component using
+// the MUC option.
+//
+// The keys in config are the names of Prosody's internal room settings (eg.
+// "name", "description", "members_only", "moderated", "password") and the
+// values are written to the shell using the same rules as Set.
+// Unless config contains a value for "persistent" it is set to true.
+func CreateRoom(ctx context.Context, room string, config map[string]interface{}) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		j, err := jid.Parse(room)
+		if err != nil {
+			return err
+		}
+		j = j.Bare()
+		err = MUC(j.Domainpart())(cmd)
+		if err != nil {
+			return err
+		}
+		err = shell()(cmd)
+		if err != nil {
+			return err
+		}
+
+		roomCfg := make(map[string]interface{}, len(config)+1)
+		roomCfg["persistent"] = true
+		for k, v := range config {
+			roomCfg[k] = v
+		}
+		script := fmt.Sprintf("muc:create(%q, %s)\n", j.String(), luaTable(roomCfg))
+		return integration.Defer(func(cmd *integration.Cmd) error {
+			out, err := runShell(ctx, cmd, script)
+			if err != nil {
+				return fmt.Errorf("prosody: error creating room %s: %w\n%s", j, err, out)
+			}
+			return nil
+		})(cmd)
+	}
+}
+
+// shell returns an option that enables the admin shell module if it is not
+// already enabled.
+func shell() integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		for _, mod := range cfg.Modules {
+			if mod == shellModule {
+				return nil
+			}
+		}
+		return Modules(shellModule)(cmd)
+	}
+}
+
+// runShell runs script using "prosodyctl shell" and returns its combined
+// output.
+// The shell module must have been enabled using the shell option before the
+// server was started.
+func runShell(ctx context.Context, cmd *integration.Cmd, script string) ([]byte, error) {
+	cfgFilePath := filepath.Join(cmd.ConfigDir(), cfgFileName)
+	sh := cmd.Command(ctx, "prosodyctl", configFlag, cfgFilePath, "shell")
+	sh.Stdin = strings.NewReader(script)
+	return sh.CombinedOutput()
+}
+
+// luaTable formats m as a Lua table constructor with its keys in sorted order.
+func luaTable(m map[string]interface{}) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("{ ")
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case string:
+			fmt.Fprintf(&b, "%s = %q; ", k, v)
+		default:
+			fmt.Fprintf(&b, "%s = %v; ", k, v)
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
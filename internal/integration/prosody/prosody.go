@@ -7,13 +7,18 @@ package prosody // import "mellium.im/xmpp/internal/integration/prosody"
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
-	"os/exec"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"mellium.im/xmpp/internal/attr"
 	"mellium.im/xmpp/internal/integration"
 	"mellium.im/xmpp/jid"
 )
@@ -53,10 +58,32 @@ func WebSocket() integration.Option {
 // options in this package noops.
 // This option only exists for the rare occasion that you need complete control
 // over the config file.
+// If the config is not valid an error is returned before the daemon is
+// started (see Config.Validate).
 func ConfigFile(cfg Config) integration.Option {
 	return func(cmd *integration.Cmd) error {
+		err := cfg.Validate()
+		if err != nil {
+			return err
+		}
 		cmd.Config = cfg
+		return configFile(func(*integration.Cmd) (Config, error) {
+			return cfg, nil
+		})(cmd)
+	}
+}
+
+// configFile writes the config returned by f to the config file when the
+// commands config files are written (after all options have been applied and,
+// for commands started by integration.Federate, after their peer has been
+// configured).
+func configFile(f func(*integration.Cmd) (Config, error)) integration.Option {
+	return func(cmd *integration.Cmd) error {
 		err := integration.TempFile(cfgFileName, func(cmd *integration.Cmd, w io.Writer) error {
+			cfg, err := f(cmd)
+			if err != nil {
+				return err
+			}
 			return cfgTmpl.Execute(w, struct {
 				Config
 				ConfigDir string
@@ -83,13 +110,20 @@ func Ctl(ctx context.Context, args ...string) integration.Option {
 func ctlFunc(ctx context.Context, args ...string) func(*integration.Cmd) error {
 	return func(cmd *integration.Cmd) error {
 		cfgFilePath := filepath.Join(cmd.ConfigDir(), cfgFileName)
-		/* #nosec */
-		prosodyCtl := exec.CommandContext(ctx, "prosodyctl", configFlag, cfgFilePath)
+		prosodyCtl := cmd.Command(ctx, "prosodyctl", configFlag, cfgFilePath)
 		prosodyCtl.Args = append(prosodyCtl.Args, args...)
 		return prosodyCtl.Run()
 	}
 }
 
+// Ready is a readiness probe that waits until "prosodyctl status" reports that
+// Prosody is running.
+func Ready() integration.Option {
+	return integration.Ready(func(ctx context.Context, cmd *integration.Cmd) error {
+		return ctlFunc(ctx, "status")(cmd)
+	})
+}
+
 func getConfig(cmd *integration.Cmd) Config {
 	if cmd.Config == nil {
 		cmd.Config = Config{}
@@ -111,7 +145,10 @@ func ListenC2S() integration.Option {
 		// we need to close the connection and let Prosody listen on that port.
 		// Technically this is racey, but it's not likely to be a problem in
 		// practice.
-		c2sPort := c2sListener.Addr().(*net.TCPAddr).Port
+		c2sPort, err := integration.Port(c2sListener)
+		if err != nil {
+			return err
+		}
 		err = c2sListener.Close()
 		if err != nil {
 			return err
@@ -137,7 +174,10 @@ func ListenS2S() integration.Option {
 		// get a random port that we'll use to configure Prosody, then we need to
 		// close the connection and let Prosody listen on that port.
 		// Technically this is racey, but it's not likely to be a problem in practice.
-		s2sPort := s2sListener.Addr().(*net.TCPAddr).Port
+		s2sPort, err := integration.Port(s2sListener)
+		if err != nil {
+			return err
+		}
 		err = s2sListener.Close()
 		if err != nil {
 			return err
@@ -150,6 +190,62 @@ func ListenS2S() integration.Option {
 	}
 }
 
+// ListenC2SDirectTLS listens for client-to-server (c2s) connections that use
+// direct TLS on a random port.
+// Once this option is used, Cmd.DialClient connects to this port and
+// performs the TLS handshake immediately instead of using STARTTLS.
+// Direct TLS requires Prosody 0.12 or later.
+func ListenC2SDirectTLS() integration.Option {
+	return func(cmd *integration.Cmd) error {
+		l, err := cmd.C2STLSListen("tcp", ":0")
+		if err != nil {
+			return err
+		}
+		// See the comment in ListenC2S.
+		port, err := integration.Port(l)
+		if err != nil {
+			return err
+		}
+		err = l.Close()
+		if err != nil {
+			return err
+		}
+
+		cfg := getConfig(cmd)
+		cfg.C2SDirectTLSPort = port
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// ListenS2SDirectTLS listens for server-to-server (s2s) connections that use
+// direct TLS on a random port.
+// Once this option is used, Cmd.DialServer connects to this port and
+// performs the TLS handshake immediately instead of using STARTTLS.
+// Direct TLS requires Prosody 0.12 or later.
+func ListenS2SDirectTLS() integration.Option {
+	return func(cmd *integration.Cmd) error {
+		l, err := cmd.S2STLSListen("tcp", "[::1]:0")
+		if err != nil {
+			return err
+		}
+		// See the comment in ListenC2S.
+		port, err := integration.Port(l)
+		if err != nil {
+			return err
+		}
+		err = l.Close()
+		if err != nil {
+			return err
+		}
+
+		cfg := getConfig(cmd)
+		cfg.S2SDirectTLSPort = port
+		cmd.Config = cfg
+		return nil
+	}
+}
+
 // VHost configures one or more virtual hosts.
 // The default if this option is not provided is to create a single vhost called
 // "localhost" and create a self-signed cert for it (if VHost is specified certs
@@ -178,7 +274,10 @@ func Component(domain, secret string) integration.Option {
 		// we need to close the connection and let Prosody listen on that port.
 		// Technically this is racey, but it's not likely to be a problem in
 		// practice.
-		compPort := compListener.Addr().(*net.TCPAddr).Port
+		compPort, err := integration.Port(compListener)
+		if err != nil {
+			return err
+		}
 		err = compListener.Close()
 		if err != nil {
 			return err
@@ -215,8 +314,14 @@ func HTTPS() integration.Option {
 		// we need to close the connection and let Prosody listen on that port.
 		// Technically this is racey, but it's not likely to be a problem in
 		// practice.
-		httpPort := httpListener.Addr().(*net.TCPAddr).Port
-		httpsPort := httpsListener.Addr().(*net.TCPAddr).Port
+		httpPort, err := integration.Port(httpListener)
+		if err != nil {
+			return err
+		}
+		httpsPort, err := integration.Port(httpsListener)
+		if err != nil {
+			return err
+		}
 		err = httpListener.Close()
 		if err != nil {
 			return err
@@ -252,7 +357,102 @@ func CreateUser(ctx context.Context, addr, pass string) integration.Option {
 	}
 }
 
+// DeleteUser returns an option that calls prosodyctl to delete a user.
+// It is equivalent to calling:
+// Ctl(ctx, "deluser", "localpart@domainpart") except that if the user was the
+// one returned by the underlying Cmd's User method it is forgotten.
+func DeleteUser(ctx context.Context, addr string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		j, err := jid.Parse(addr)
+		if err != nil {
+			return err
+		}
+		err = Ctl(ctx, "deluser", j.Bare().String())(cmd)
+		if err != nil {
+			return err
+		}
+		if user, _ := cmd.User(); user.Equal(j) {
+			return integration.User(jid.JID{}, "")(cmd)
+		}
+		return nil
+	}
+}
+
+// ChangePassword returns an option that calls prosodyctl to change the password
+// of an existing user.
+// If the user is the one returned by the underlying Cmd's User method, the
+// password is also updated there.
+func ChangePassword(ctx context.Context, addr, pass string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		j, err := jid.Parse(addr)
+		if err != nil {
+			return err
+		}
+		err = integration.Defer(func(cmd *integration.Cmd) error {
+			cfgFilePath := filepath.Join(cmd.ConfigDir(), cfgFileName)
+			prosodyCtl := cmd.Command(ctx, "prosodyctl", configFlag, cfgFilePath, "passwd", j.Bare().String())
+			// prosodyctl prompts for the new password twice.
+			prosodyCtl.Stdin = strings.NewReader(pass + "\n" + pass + "\n")
+			return prosodyCtl.Run()
+		})(cmd)
+		if err != nil {
+			return err
+		}
+		if user, _ := cmd.User(); user.Equal(j) {
+			return integration.User(user, pass)(cmd)
+		}
+		return nil
+	}
+}
+
+// ListUsers returns the addresses of all users on the provided host.
+// It reads Prosody's data directory directly so it is only accurate when the
+// default internal storage is in use.
+func ListUsers(cmd *integration.Cmd, host string) ([]jid.JID, error) {
+	accounts, err := ioutil.ReadDir(filepath.Join(cmd.ConfigDir(), storeEncode(host), "accounts"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	users := make([]jid.JID, 0, len(accounts))
+	for _, account := range accounts {
+		name := account.Name()
+		if account.IsDir() || filepath.Ext(name) != ".dat" {
+			continue
+		}
+		localpart, err := url.PathUnescape(strings.TrimSuffix(name, ".dat"))
+		if err != nil {
+			return nil, err
+		}
+		j, err := jid.New(localpart, host, "")
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, j)
+	}
+	return users, nil
+}
+
+// storeEncode encodes s in the same way as Prosody's datamanager when creating
+// file and directory names: every byte that is not a letter or a digit is
+// replaced by a percent sign followed by its hex value.
+func storeEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02x", c)
+	}
+	return b.String()
+}
+
 // Modules adds custom modules to the enabled modules list.
+// To enable modules for a single virtual host or component use HostModules.
 func Modules(mod ...string) integration.Option {
 	return func(cmd *integration.Cmd) error {
 		cfg := getConfig(cmd)
@@ -262,12 +462,46 @@ func Modules(mod ...string) integration.Option {
 	}
 }
 
+// HostModules enables modules for a single virtual host or component in
+// addition to the global modules.
+// The host must be configured by another option such as VHost or MUC before
+// the config file is written.
+//
+//     -- HostModules("conference.localhost", "muc_mam")
+//     Component "conference.localhost" "muc"
+//              modules_enabled = { "muc_mam" }
+func HostModules(host string, mod ...string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		if cfg.HostModules == nil {
+			cfg.HostModules = make(map[string][]string)
+		}
+		cfg.HostModules[host] = append(cfg.HostModules[host], mod...)
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// CSI enables client state indication.
+//
+//     -- CSI()
+//     modules_enabled = { "csi"; "csi_simple" }
+func CSI() integration.Option {
+	return Modules("csi", "csi_simple")
+}
+
 // Set adds an extra key/value pair to the global section of the config file.
 // If v is a string it will be quoted, otherwise it is marshaled using the %v
 // formatting directive (see the fmt package for details).
 // As a special case, if v is nil the key is written to the file directly with
 // no equals sign.
 //
+// Set is an escape hatch for settings that do not have their own option.
+// It cannot be used to change the settings written by this package: keys that
+// have their own option or field on Config (such as "storage" or "log") are
+// reserved and result in an error before the server is started (see
+// Config.Validate).
+//
 //     -- Set("foo", "bar")
 //     foo = "bar"
 //
@@ -288,6 +522,62 @@ func Set(key string, v interface{}) integration.Option {
 	}
 }
 
+// HostSet is like Set except that the key/value pair is written in the
+// section of the given virtual host or component.
+// The host must be configured by another option such as VHost or MUC before
+// the config file is written.
+//
+//     -- HostSet("conference.localhost", "restrict_room_creation", true)
+//     Component "conference.localhost" "muc"
+//              restrict_room_creation = true
+func HostSet(host, key string, v interface{}) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		if cfg.HostOptions == nil {
+			cfg.HostOptions = make(map[string]map[string]interface{})
+		}
+		if cfg.HostOptions[host] == nil {
+			cfg.HostOptions[host] = make(map[string]interface{})
+		}
+		cfg.HostOptions[host][key] = v
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// Storage sets the storage backend.
+// For a list of valid backends see Config.
+func Storage(backend string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		cfg.Storage = backend
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// LogLevel sets the minimum level of messages that Prosody logs to the console
+// (and therefore to the test output).
+// For a list of valid levels see Config.
+func LogLevel(level string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		cfg.LogLevel = level
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// SSL sets the global TLS options.
+func SSL(opts SSLOptions) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		cfg.SSL = opts
+		cmd.Config = cfg
+		return nil
+	}
+}
+
 // Bidi enables bidirectional S2S connections.
 func Bidi() integration.Option {
 	// TODO: Once Prosody 0.12 is out this module can be replaced with the builtin
@@ -465,31 +755,45 @@ end);`)
 	}
 }
 
-func defaultConfig(cmd *integration.Cmd) error {
-	for _, arg := range cmd.Cmd.Args {
-		if arg == configFlag {
-			return nil
+// defaultConfig returns an option that configures a single vhost with a
+// self-signed certificate and a user if they were not configured by other
+// options, and then writes the config file unless ConfigFile was used.
+func defaultConfig(vhost string) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		for _, arg := range cmd.Cmd.Args {
+			if arg == configFlag {
+				return nil
+			}
 		}
-	}
 
-	cfg := getConfig(cmd)
-	if len(cfg.VHosts) == 0 {
-		const vhost = "localhost"
-		cfg.VHosts = append(cfg.VHosts, vhost)
-		err := integration.Cert(vhost)(cmd)
-		if err != nil {
-			return err
+		cfg := getConfig(cmd)
+		if len(cfg.VHosts) == 0 {
+			cfg.VHosts = append(cfg.VHosts, vhost)
+			err := integration.Cert(vhost)(cmd)
+			if err != nil {
+				return err
+			}
 		}
-	}
-	cmd.Config = cfg
-	if j, _ := cmd.User(); j.Equal(jid.JID{}) {
-		err := CreateUser(context.TODO(), "me@"+cfg.VHosts[0], "password")(cmd)
+		cmd.Config = cfg
+		if j, _ := cmd.User(); j.Equal(jid.JID{}) {
+			err := CreateUser(context.TODO(), "me@"+cfg.VHosts[0], "password")(cmd)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Validate early so that errors are reported before anything else is
+		// done, and then again in case the config was changed by a PeerConfig
+		// option before it is written.
+		err := cfg.Validate()
 		if err != nil {
 			return err
 		}
+		return configFile(func(cmd *integration.Cmd) (Config, error) {
+			cfg := getConfig(cmd)
+			return cfg, cfg.Validate()
+		})(cmd)
 	}
-
-	return ConfigFile(cfg)(cmd)
 }
 
 // Test starts a Prosody instance and returns a function that runs subtests
@@ -498,7 +802,99 @@ func defaultConfig(cmd *integration.Cmd) error {
 // subtests.
 // When all subtests have completed, the daemon is stopped.
 func Test(ctx context.Context, t *testing.T, opts ...integration.Option) integration.SubtestRunner {
-	opts = append(opts, defaultConfig,
+	opts = append(opts, defaultConfig("localhost"),
 		integration.Shutdown(ctlFunc(ctx, "stop")))
 	return integration.Test(ctx, cmdName, t, opts...)
 }
+
+// Benchmark starts a Prosody instance and returns a function that runs
+// sub-benchmarks using b.Run.
+// Multiple calls to the returned function will result in uniquely named
+// sub-benchmarks.
+// When all sub-benchmarks have completed, the daemon is stopped.
+func Benchmark(ctx context.Context, b *testing.B, opts ...integration.Option) integration.SubbenchRunner {
+	opts = append(opts, defaultConfig("localhost"),
+		integration.Shutdown(ctlFunc(ctx, "stop")))
+	return integration.Benchmark(ctx, cmdName, b, opts...)
+}
+
+// Server returns a Prosody server configured with the same defaults used by
+// Test that can federate with the other server started by
+// integration.Federate.
+//
+// Unless the VHost option is used the server is given a unique vhost (instead
+// of "localhost") so that the two servers can be told apart.
+// It listens for s2s connections, trusts any certificate, and connects directly
+// to the peers s2s listener instead of looking up its address using DNS (see
+// Config.Peers).
+// The peer must be listening for s2s connections and have a user (see
+// integration.Cmd.User) from which its domain is taken.
+func Server(ctx context.Context, opts ...integration.Option) integration.Server {
+	opts = append(opts, federate,
+		defaultConfig(attr.RandomLen(8)+".localhost"),
+		integration.Shutdown(ctlFunc(ctx, "stop")))
+	return integration.Server{Name: cmdName, Opts: opts}
+}
+
+func federate(cmd *integration.Cmd) error {
+	if getConfig(cmd).S2SPort == 0 {
+		err := ListenS2S()(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	err := TrustAll()(cmd)
+	if err != nil {
+		return err
+	}
+	err = connectOverrides()(cmd)
+	if err != nil {
+		return err
+	}
+	return integration.PeerConfig(func(cmd, peer *integration.Cmd) error {
+		j, _ := peer.User()
+		if j.Equal(jid.JID{}) {
+			return errors.New("prosody: peer has no user to take the domain from")
+		}
+		addr, _ := peer.S2SAddr()
+		tcpAddr, ok := addr.(*net.TCPAddr)
+		if !ok {
+			return fmt.Errorf("prosody: peer s2s listener %v is not a TCP listener", addr)
+		}
+		cfg := getConfig(cmd)
+		if cfg.Peers == nil {
+			cfg.Peers = make(map[string]*net.TCPAddr)
+		}
+		cfg.Peers[j.Domain().String()] = tcpAddr
+		cmd.Config = cfg
+		return nil
+	})(cmd)
+}
+
+// connectOverrides enables a module that connects to the s2s listener of any
+// domain in Config.Peers directly.
+func connectOverrides() integration.Option {
+	const modName = "s2s_peers"
+	return func(cmd *integration.Cmd) error {
+		err := Modules(modName)(cmd)
+		if err != nil {
+			return err
+		}
+		return integration.TempFile("mod_"+modName+".lua", func(_ *integration.Cmd, w io.Writer) error {
+			_, err := io.WriteString(w, `
+local new_resolver = require "net.resolvers.basic".new;
+
+local overrides = module:get_option("s2s_connect_overrides", {});
+
+module:hook("s2sout-pre-connect", function(event)
+	local to_host = event.session.to_host;
+	local override = overrides[to_host];
+	if override then
+		module:log("info", "connecting to %s at [%s]:%d", to_host, override[1], override[2]);
+		event.resolver = new_resolver(override[1], override[2], "tcp", { servername = to_host });
+	end
+end, 10);`)
+			return err
+		})(cmd)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package prosody
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/roster"
+)
+
+// seedMarker is printed by seeding scripts once they have completed so that
+// failures that do not cause prosodyctl to exit with an error are detected.
+const seedMarker = "mellium-seeded"
+
+// SeedRoster returns an option that adds contacts to the roster of user after
+// the server has started using "prosodyctl shell".
+// Each contact is added with a separate roster update so that the roster
+// version changes the same way it would if the contacts had been added by the
+// user over XMPP.
+// If a contact does not have a subscription it is set to "none".
+func SeedRoster(ctx context.Context, user string, contacts ...roster.Item) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		j, err := jid.Parse(user)
+		if err != nil {
+			return err
+		}
+		j = j.Bare()
+		err = shell()(cmd)
+		if err != nil {
+			return err
+		}
+
+		var script strings.Builder
+		script.WriteString(`> local rm = require"core.rostermanager"`)
+		for _, item := range contacts {
+			sub := item.Subscription
+			if sub == "" {
+				sub = "none"
+			}
+			groups := make(map[string]interface{}, len(item.Group))
+			for _, g := range item.Group {
+				groups[g] = true
+			}
+			fmt.Fprintf(&script, "; assert(rm.add_to_roster(%q, %q, %q, { subscription = %q; name = %s; groups = %s; }))",
+				j.Localpart(), j.Domainpart(), item.JID.Bare().String(), sub, luaString(item.Name), luaKeyedTable(groups))
+		}
+		fmt.Fprintf(&script, "; print(%q)\n", seedMarker)
+		return integration.Defer(func(cmd *integration.Cmd) error {
+			return runSeed(ctx, cmd, "roster of "+j.String(), script.String())
+		})(cmd)
+	}
+}
+
+// SeedMAM returns an option that appends messages to the archive of user after
+// the server has started using "prosodyctl shell".
+// The MAM option must also be used when creating the command.
+//
+// The Stanza field of each message must contain the serialized message as it
+// should be returned from the archive.
+// If the ID of a message is empty the server generates one and if its When
+// field is the zero time the current time is used.
+// Messages are appended in order so they should be sorted by time.
+func SeedMAM(ctx context.Context, user string, messages ...ArchivedMessage) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		j, err := jid.Parse(user)
+		if err != nil {
+			return err
+		}
+		j = j.Bare()
+		err = shell()(cmd)
+		if err != nil {
+			return err
+		}
+
+		var script strings.Builder
+		fmt.Fprintf(&script, `> local archive = require"core.storagemanager".open(%q, "archive"); local parse = require"util.xml".parse`, j.Domainpart())
+		for _, msg := range messages {
+			when := "nil"
+			if !msg.When.IsZero() {
+				when = strconv.FormatFloat(float64(msg.When.UnixNano())/1e9, 'f', 6, 64)
+			}
+			fmt.Fprintf(&script, "; assert(archive:append(%q, %s, assert(parse(%q)), %s, %q))",
+				j.Localpart(), luaString(msg.ID), msg.Stanza, when, msg.With.String())
+		}
+		fmt.Fprintf(&script, "; print(%q)\n", seedMarker)
+		return integration.Defer(func(cmd *integration.Cmd) error {
+			return runSeed(ctx, cmd, "archive of "+j.String(), script.String())
+		})(cmd)
+	}
+}
+
+// runSeed runs a seeding script and checks that it completed.
+func runSeed(ctx context.Context, cmd *integration.Cmd, what, script string) error {
+	out, err := runShell(ctx, cmd, script)
+	if err != nil {
+		return fmt.Errorf("prosody: error seeding %s: %w\n%s", what, err, out)
+	}
+	if !bytes.Contains(out, []byte(seedMarker)) {
+		return fmt.Errorf("prosody: error seeding %s:\n%s", what, out)
+	}
+	return nil
+}
+
+// luaString formats s as a quoted Lua string or nil if it is empty.
+func luaString(s string) string {
+	if s == "" {
+		return "nil"
+	}
+	return strconv.Quote(s)
+}
+
+// luaKeyedTable is like luaTable except that the keys are quoted so that they
+// may contain any string.
+func luaKeyedTable(m map[string]interface{}) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("{ ")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "[%q] = %v; ", k, m[k])
+	}
+	b.WriteString("}")
+	return b.String()
+}
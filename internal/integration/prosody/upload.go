@@ -0,0 +1,48 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package prosody
+
+import (
+	"fmt"
+
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/jid"
+)
+
+const uploadDomain = "upload.localhost"
+
+// HTTPUpload adds an HTTP File Upload component using Prosody's
+// mod_http_file_share to the config file.
+// If the HTTP ports have not already been configured using the HTTPS option,
+// it is added automatically.
+//
+//     -- HTTPUpload()
+//     Component "upload.localhost" "http_file_share"
+func HTTPUpload() integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cfg := getConfig(cmd)
+		if cfg.HTTPPort == 0 {
+			err := HTTPS()(cmd)
+			if err != nil {
+				return err
+			}
+			cfg = getConfig(cmd)
+		}
+		cfg.Upload = uploadDomain
+		cmd.Config = cfg
+		return nil
+	}
+}
+
+// UploadService returns the address of the upload component and the base URL
+// that uploaded files will be served from.
+// If the HTTPUpload option was not used, the zero value of both is returned.
+func UploadService(cmd *integration.Cmd) (jid.JID, string) {
+	cfg, ok := cmd.Config.(Config)
+	if !ok || cfg.Upload == "" {
+		return jid.JID{}, ""
+	}
+	return jid.MustParse(cfg.Upload), fmt.Sprintf("http://localhost:%d/", cfg.HTTPPort)
+}
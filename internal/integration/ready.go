@@ -0,0 +1,93 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Ready is an option that registers a readiness probe.
+// After the command is started and any sockets it listens on are accepting
+// connections, f is called repeatedly with a backoff until it returns nil or
+// the probe times out.
+// Subtests, and functions registered with Defer, are not run until all
+// readiness probes have succeeded.
+// This can be used to wait until a server has finished loading its
+// configuration (virtual hosts, components, HTTP modules, etc.) instead of
+// only waiting for it to accept connections.
+// If multiple Ready options are passed they are polled in order.
+func Ready(f func(context.Context, *Cmd) error) Option {
+	return func(cmd *Cmd) error {
+		cmd.ready = append(cmd.ready, f)
+		return nil
+	}
+}
+
+// HTTPReady is a readiness probe that makes a GET request to the provided path
+// on the listener reserved by HTTPListen and waits for a 2xx response.
+func HTTPReady(path string) Option {
+	return Ready(func(ctx context.Context, cmd *Cmd) error {
+		if cmd.httpListener == nil {
+			return fmt.Errorf("HTTP not configured, please configure an HTTP listener")
+		}
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return cmd.HTTPConn(ctx, false)
+				},
+			},
+		}
+		// The host is ignored by the custom dialer, which also makes this work for
+		// HTTP served over Unix domain sockets.
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		/* #nosec */
+		io.Copy(ioutil.Discard, resp.Body)
+		/* #nosec */
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("health check returned %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// waitReady polls each readiness probe until it succeeds.
+func waitReady(ctx context.Context, cmd *Cmd) error {
+	for _, f := range cmd.ready {
+		var err error
+		timeout := 250 * time.Millisecond
+		for attempts := 10; attempts > 0; attempts-- {
+			err = f(ctx, cmd)
+			if err == nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case err := <-cmd.closed:
+				return fmt.Errorf("command exited before becoming ready: %v", err)
+			case <-time.After(timeout):
+			}
+			timeout += 250 * time.Millisecond
+		}
+		if err != nil {
+			return fmt.Errorf("command did not become ready: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"mellium.im/xmlstream"
+)
+
+// Step is a single action taken by the server started by Script.
+type Step struct {
+	send   string
+	expect func(start xml.StartElement, r xml.TokenReader) error
+	tls    string
+}
+
+// SendXML returns a step that writes raw XML to the client.
+// The XML is not validated, so it may be used to send truncated or otherwise
+// invalid input.
+func SendXML(raw string) Step {
+	return Step{send: raw}
+}
+
+// Expect returns a step that reads the next element sent by the client and
+// passes it to f.
+// If f returns an error the test is failed and the connection is closed.
+// Any part of the element not consumed by f is skipped.
+// A stream header is treated as an element without any children.
+func Expect(f func(start xml.StartElement, r xml.TokenReader) error) Step {
+	return Step{expect: f}
+}
+
+// ExpectName returns a step that fails the test if the next element sent by
+// the client does not have the provided name.
+func ExpectName(name xml.Name) Step {
+	return Expect(func(start xml.StartElement, _ xml.TokenReader) error {
+		if start.Name != name {
+			return fmt.Errorf("expected element %v, got %v", name, start.Name)
+		}
+		return nil
+	})
+}
+
+// AcceptTLS returns a step that performs a TLS handshake as the server, for
+// example after sending a StartTLS proceed element.
+// The certificate and key created by the Cert option with the same name are
+// used for the handshake.
+func AcceptTLS(name string) Step {
+	return Step{tls: name}
+}
+
+// Script reserves a c2s listener and returns a function that runs tests as
+// subtests using t.Run, similar to Test.
+// Instead of starting an external server, every connection made to the c2s
+// listener is handled in process by playing steps in order, which makes it
+// possible to test negotiation edge cases that real servers will never produce
+// (bad SASL challenges, truncated features, invalid restarts, etc.).
+// When all steps have been played the connection is closed.
+// Each subtest waits for any connections made during the subtest to finish
+// before returning.
+func Script(ctx context.Context, t *testing.T, steps []Step, opts ...Option) SubtestRunner {
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+
+	cmd, err := newCmd(ctx, "script", opts...)
+	if err != nil {
+		t.Fatalf("error creating script: %v", err)
+	}
+	l, err := cmd.C2SListen(cmd.ListenAddr("c2s", "127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("error listening for script: %v", err)
+	}
+	err = cmd.writeConfig()
+	if err != nil {
+		t.Fatalf("error creating script: %v", err)
+	}
+	t.Cleanup(func() {
+		/* #nosec */
+		l.Close()
+		cmd.kill()
+		cmd.removeContainer()
+		/* #nosec */
+		os.RemoveAll(cmd.cfgDir)
+	})
+
+	var (
+		mu      sync.Mutex
+		current testing.TB
+		wg      sync.WaitGroup
+	)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			tb := current
+			if tb == nil {
+				mu.Unlock()
+				/* #nosec */
+				conn.Close()
+				continue
+			}
+			wg.Add(1)
+			mu.Unlock()
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				err := playScript(ctx, cmd, conn, steps)
+				if err != nil {
+					tb.Errorf("script: %v", err)
+				}
+			}()
+		}
+	}()
+
+	i := -1
+	return func(f func(context.Context, *testing.T, *Cmd)) bool {
+		i++
+		return t.Run(fmt.Sprintf("script/%d", i), func(t *testing.T) {
+			mu.Lock()
+			current = t
+			mu.Unlock()
+			cmd.update(t)
+			f(ctx, t, cmd)
+			mu.Lock()
+			current = nil
+			mu.Unlock()
+			wg.Wait()
+		})
+	}
+}
+
+func playScript(ctx context.Context, cmd *Cmd, conn net.Conn, steps []Step) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			/* #nosec */
+			conn.Close()
+		}
+	}()
+
+	d := xml.NewDecoder(conn)
+	for i, step := range steps {
+		switch {
+		case step.send != "":
+			_, err := io.WriteString(conn, step.send)
+			if err != nil {
+				return fmt.Errorf("step %d: error sending: %w", i, err)
+			}
+		case step.tls != "":
+			cert, err := tls.LoadX509KeyPair(
+				filepath.Join(cmd.cfgDir, step.tls+".crt"),
+				filepath.Join(cmd.cfgDir, step.tls+".key"),
+			)
+			if err != nil {
+				return fmt.Errorf("step %d: error loading certificate: %w", i, err)
+			}
+			tlsConn := tls.Server(conn, &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   tls.VersionTLS12,
+			})
+			err = tlsConn.Handshake()
+			if err != nil {
+				return fmt.Errorf("step %d: error negotiating TLS: %w", i, err)
+			}
+			conn = tlsConn
+			d = xml.NewDecoder(conn)
+		case step.expect != nil:
+			start, err := nextStart(d)
+			if err != nil {
+				return fmt.Errorf("step %d: error reading element: %w", i, err)
+			}
+			if start.Name.Local == "stream" {
+				err = step.expect(start, xmlstream.MultiReader())
+			} else {
+				inner := xmlstream.Inner(d)
+				err = step.expect(start, inner)
+				if err == nil {
+					_, err = xmlstream.Copy(xmlstream.Discard(), inner)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// nextStart returns the next start element from d, skipping any whitespace,
+// proc insts, and end elements (such as the end of a previous stream).
+func nextStart(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return xml.StartElement{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Copy(), nil
+		}
+	}
+}
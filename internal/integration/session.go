@@ -0,0 +1,87 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+)
+
+// DialUser connects to the server as the user returned by cmd.User and
+// negotiates the standard client features: StartTLS (unless the connection
+// already uses Direct TLS), SASL PLAIN, and resource binding.
+// Any extra features are negotiated after resource binding.
+func (cmd *Cmd) DialUser(ctx context.Context, t testing.TB, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
+	j, pass := cmd.User()
+	if j.Equal(jid.JID{}) {
+		return nil, errors.New("integration: no user configured")
+	}
+	var std []xmpp.StreamFeature
+	if cmd.c2sTLSListener == nil {
+		std = append(std, xmpp.StartTLS(cmd.tlsConfig(false, j.Domain())))
+	}
+	std = append(std,
+		xmpp.SASL("", pass, sasl.Plain),
+		xmpp.BindResource(),
+	)
+	return cmd.DialClient(ctx, j, t, append(std, features...)...)
+}
+
+// SessionTest is a subtest that is passed a client session for the user
+// returned by cmd.User.
+type SessionTest func(context.Context, *testing.T, *Cmd, *xmpp.Session)
+
+// SessionBench is a sub-benchmark that is passed a client session for the user
+// returned by cmd.User.
+type SessionBench func(context.Context, *testing.B, *Cmd, *xmpp.Session)
+
+// WithSession runs f as a subtest after dialing a new session using DialUser.
+// The session serves h (which may be nil) in a separate goroutine so that
+// responses to IQs are handled, and it is closed when the subtest completes.
+func (r SubtestRunner) WithSession(h xmpp.Handler, f SessionTest) bool {
+	return r(func(ctx context.Context, t *testing.T, cmd *Cmd) {
+		session := serveUser(ctx, t, cmd, h)
+		f(ctx, t, cmd, session)
+	})
+}
+
+// WithSession runs f as a sub-benchmark after dialing a new session using
+// DialUser.
+// The time spent dialing the session is not included in the results.
+// For more information see SubtestRunner.WithSession.
+func (r SubbenchRunner) WithSession(h xmpp.Handler, f SessionBench) bool {
+	return r(func(ctx context.Context, b *testing.B, cmd *Cmd) {
+		session := serveUser(ctx, b, cmd, h)
+		b.ResetTimer()
+		f(ctx, b, cmd, session)
+	})
+}
+
+func serveUser(ctx context.Context, t testing.TB, cmd *Cmd, h xmpp.Handler) *xmpp.Session {
+	t.Helper()
+	session, err := cmd.DialUser(ctx, t)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		/* #nosec */
+		session.Close()
+		<-done
+	})
+	go func() {
+		defer close(done)
+		err := session.Serve(h)
+		if err != nil {
+			t.Logf("error from serve: %v", err)
+		}
+	}()
+	return session
+}
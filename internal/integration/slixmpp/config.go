@@ -0,0 +1,58 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package slixmpp
+
+import (
+	"text/template"
+
+	"mellium.im/xmpp/jid"
+)
+
+// Config contains options that can be written to the client script.
+type Config struct {
+	JID      jid.JID
+	Password string
+	Port     string
+}
+
+// The client reads raw XML from stdin (one element per line) and sends it
+// after the session has been established.
+// XML and other debugging information is logged to stderr.
+const cfgBase = `import asyncio
+import logging
+import ssl
+import sys
+
+import slixmpp
+
+
+class Client(slixmpp.ClientXMPP):
+    def __init__(self, jid, password):
+        super().__init__(jid, password)
+        self.add_event_handler("session_start", self.start)
+
+    async def start(self, event):
+        self.send_presence()
+        loop = asyncio.get_event_loop()
+        while True:
+            line = await loop.run_in_executor(None, sys.stdin.readline)
+            if not line:
+                break
+            line = line.strip()
+            if line:
+                self.send_raw(line)
+        self.disconnect()
+
+
+logging.basicConfig(level=logging.DEBUG, format="%(levelname)-8s %(message)s")
+xmpp = Client({{ printf "%q" .JID.String }}, {{ printf "%q" .Password }})
+xmpp.register_plugin("xep_0199")
+xmpp.ssl_context.check_hostname = False
+xmpp.ssl_context.verify_mode = ssl.CERT_NONE
+xmpp.connect(("localhost", {{ .Port }}))
+xmpp.process(forever=False)
+`
+
+var cfgTmpl = template.Must(template.New("cfg").Parse(cfgBase))
@@ -0,0 +1,111 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package slixmpp facilitates integration testing with the slixmpp Python
+// library.
+//
+// It is meant for testing the receiving side of this library: the client is
+// pointed at a listener controlled by the test (for example, a server started
+// by the mellium package) so that the test can make assertions about the
+// stream that a well known third party client sends.
+package slixmpp // import "mellium.im/xmpp/internal/integration/slixmpp"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/jid"
+)
+
+const (
+	cfgFileName = "client.py"
+	cmdName     = "python3"
+)
+
+// Send transmits the given raw XML over the clients session.
+// The XML must not contain any newlines.
+func Send(cmd *integration.Cmd, s string) error {
+	_, err := fmt.Fprintln(cmd.Stdin(), s)
+	return err
+}
+
+// Ping sends an XMPP ping.
+func Ping(cmd *integration.Cmd, to jid.JID) error {
+	return Send(cmd, fmt.Sprintf(
+		`<iq to="%s" id="123" type="get"><ping xmlns='urn:xmpp:ping'/></iq>`, to,
+	))
+}
+
+// New creates a new, unstarted, slixmpp client.
+//
+// The provided context is used to kill the process (by calling os.Process.Kill)
+// if the context becomes done before the command completes on its own.
+func New(ctx context.Context, opts ...integration.Option) (*integration.Cmd, error) {
+	return integration.New(
+		ctx, cmdName,
+		opts...,
+	)
+}
+
+// ConfigFile is an option that can be used to write the temporary client
+// script.
+// This will overwrite the existing script.
+func ConfigFile(cfg Config) integration.Option {
+	return func(cmd *integration.Cmd) error {
+		cmd.Config = cfg
+		err := integration.TempFile(cfgFileName, func(cmd *integration.Cmd, w io.Writer) error {
+			return cfgTmpl.Execute(w, cfg)
+		})(cmd)
+		if err != nil {
+			return err
+		}
+		cfgFilePath := filepath.Join(cmd.ConfigDir(), cfgFileName)
+		return integration.Args(cfgFilePath)(cmd)
+	}
+}
+
+func getConfig(cmd *integration.Cmd) Config {
+	if cmd.Config == nil {
+		cmd.Config = Config{}
+	}
+	return cmd.Config.(Config)
+}
+
+func defaultConfig(cmd *integration.Cmd) error {
+	for _, arg := range cmd.Cmd.Args {
+		if filepath.Base(arg) == cfgFileName {
+			return nil
+		}
+	}
+
+	cfg := getConfig(cmd)
+	return ConfigFile(cfg)(cmd)
+}
+
+// Test starts a slixmpp client and returns a function that runs subtests using
+// t.Run.
+// Multiple calls to the returned function will result in uniquely named
+// subtests.
+// When all subtests have completed, the client is stopped.
+// If Python or slixmpp are not installed the subtests are skipped.
+func Test(ctx context.Context, t *testing.T, opts ...integration.Option) integration.SubtestRunner {
+	/* #nosec */
+	err := exec.CommandContext(ctx, cmdName, "-c", "import slixmpp").Run()
+	if err != nil {
+		i := -1
+		return func(f func(context.Context, *testing.T, *integration.Cmd)) bool {
+			i++
+			return t.Run(fmt.Sprintf("slixmpp/%d", i), func(t *testing.T) {
+				t.Skipf("slixmpp is not available: %v", err)
+			})
+		}
+	}
+	opts = append(opts, defaultConfig)
+	return integration.Test(ctx, cmdName, t, opts...)
+}
@@ -0,0 +1,47 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package integration
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+)
+
+// UnixSockets is an option that makes packages that support it listen on Unix
+// domain sockets in the config directory instead of on TCP ports.
+// This lets tests run in sandboxes where binding to TCP ports is restricted.
+// Packages for servers that cannot listen on Unix domain sockets (such as
+// prosody and openfire) ignore this option and continue to use TCP.
+//
+// UnixSockets must come before any options that create listeners.
+func UnixSockets() Option {
+	return func(cmd *Cmd) error {
+		cmd.unix = true
+		return nil
+	}
+}
+
+// ListenAddr returns the network and address that a listener with the given
+// name should be created on.
+// If the UnixSockets option was used the network is "unix" and the address is a
+// socket named name+".socket" in the config directory, otherwise the network
+// is "tcp" and tcpAddr is returned unchanged.
+func (cmd *Cmd) ListenAddr(name, tcpAddr string) (network, addr string) {
+	if cmd.unix {
+		return "unix", filepath.Join(cmd.cfgDir, name+".socket")
+	}
+	return "tcp", tcpAddr
+}
+
+// Port returns the port that l is listening on.
+// If l is not listening on a TCP port an error is returned.
+func Port(l net.Listener) (int, error) {
+	tcpAddr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		return 0, fmt.Errorf("integration: %s listener has no port", l.Addr().Network())
+	}
+	return tcpAddr.Port, nil
+}
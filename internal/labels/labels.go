@@ -0,0 +1,52 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package labels provides the pprof labels that are applied to goroutines
+// started by the xmpp package and other packages in this module.
+//
+// Labels are used so that profiles of programs that manage many sessions can
+// be attributed to the session that was responsible for the work.
+package labels // import "mellium.im/xmpp/internal/labels"
+
+import (
+	"context"
+	"runtime/pprof"
+
+	"mellium.im/xmpp/jid"
+)
+
+// Keys of the labels applied to goroutines.
+const (
+	Local  = "xmpp.local"
+	Remote = "xmpp.remote"
+	Domain = "xmpp.domain"
+	Task   = "xmpp.task"
+)
+
+// Session returns labels that identify task as being run on behalf of the
+// session with the provided local and remote addresses.
+// Empty addresses are omitted.
+func Session(local, remote jid.JID, task string) pprof.LabelSet {
+	args := []string{Task, task}
+	if s := local.String(); s != "" {
+		args = append(args, Local, s)
+	}
+	if s := remote.String(); s != "" {
+		args = append(args, Remote, s)
+	}
+	return pprof.Labels(args...)
+}
+
+// From returns the labels set on ctx.
+// It is used to apply the labels of a caller to work that outlives the context
+// it was given, such as a session that is served after the context used to
+// create it has been canceled.
+func From(ctx context.Context) pprof.LabelSet {
+	var args []string
+	pprof.ForLabels(ctx, func(key, value string) bool {
+		args = append(args, key, value)
+		return true
+	})
+	return pprof.Labels(args...)
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package labels_test
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"mellium.im/xmpp/internal/labels"
+	"mellium.im/xmpp/jid"
+)
+
+func TestSession(t *testing.T) {
+	ctx := pprof.WithLabels(context.Background(), labels.Session(jid.MustParse("me@example.net"), jid.JID{}, "serve"))
+	if v, ok := pprof.Label(ctx, labels.Task); !ok || v != "serve" {
+		t.Errorf("wrong task label: want=serve, got=%q (%t)", v, ok)
+	}
+	if v, ok := pprof.Label(ctx, labels.Local); !ok || v != "me@example.net" {
+		t.Errorf("wrong local label: want=me@example.net, got=%q (%t)", v, ok)
+	}
+	if v, ok := pprof.Label(ctx, labels.Remote); ok {
+		t.Errorf("expected empty remote address to be omitted, got %q", v)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("app", "test", labels.Task, "dial"))
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	ctx = pprof.WithLabels(context.Background(), labels.From(ctx))
+	if v, ok := pprof.Label(ctx, "app"); !ok || v != "test" {
+		t.Errorf("wrong app label: want=test, got=%q (%t)", v, ok)
+	}
+	if v, ok := pprof.Label(ctx, labels.Task); !ok || v != "dial" {
+		t.Errorf("wrong task label: want=dial, got=%q (%t)", v, ok)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package lru implements a size bounded least recently used cache.
+//
+// It is shared by the various caches in this module so that long running
+// services that talk to many entities do not grow without bound.
+package lru // import "mellium.im/xmpp/internal/lru"
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Stats contains metrics about a cache.
+type Stats struct {
+	// Len is the number of entries currently in the cache and Cap is the maximum
+	// number of entries that it will hold before evicting old ones.
+	Len, Cap int
+
+	// Hits and Misses are the number of lookups that did and did not find an
+	// entry respectively.
+	Hits, Misses uint64
+
+	// Evictions is the number of entries that were removed to make room for new
+	// ones.
+	// Entries that are removed explicitly are not counted.
+	Evictions uint64
+}
+
+type entry struct {
+	key interface{}
+	val interface{}
+}
+
+// Cache is a least recently used cache that holds a fixed number of entries.
+// It is safe for concurrent use.
+type Cache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[interface{}]*list.Element
+	stats Stats
+}
+
+// New creates a cache that holds at most size entries.
+// If size is less than 1 the cache never stores anything.
+func New(size int) *Cache {
+	if size < 0 {
+		size = 0
+	}
+	return &Cache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[interface{}]*list.Element),
+	}
+}
+
+// Get looks up the value for key and marks it as recently used.
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.ll.MoveToFront(e)
+	return e.Value.(*entry).val, true
+}
+
+// Add sets the value for key, evicting the least recently used entry if the
+// cache is full.
+func (c *Cache) Add(key, val interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size == 0 {
+		return
+	}
+	if e, ok := c.items[key]; ok {
+		e.Value.(*entry).val = val
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, val: val})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+		c.stats.Evictions++
+	}
+}
+
+// Remove deletes the value for key if it exists.
+func (c *Cache) Remove(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Stats returns the current metrics for the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Len = c.ll.Len()
+	s.Cap = c.size
+	return s
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package lru_test
+
+import (
+	"testing"
+
+	"mellium.im/xmpp/internal/lru"
+)
+
+func TestEviction(t *testing.T) {
+	c := lru.New(2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	// Touch a so that b is the least recently used entry.
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("wrong value for a: want=1, got=%v (%t)", v, ok)
+	}
+	c.Add("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Errorf("wrong value for c: want=3, got=%v (%t)", v, ok)
+	}
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected a to be removed")
+	}
+
+	want := lru.Stats{Len: 1, Cap: 2, Hits: 2, Misses: 2, Evictions: 1}
+	if s := c.Stats(); s != want {
+		t.Errorf("wrong stats: want=%+v, got=%+v", want, s)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	c := lru.New(1)
+	c.Add("a", 1)
+	c.Add("a", 2)
+	if v, ok := c.Get("a"); !ok || v != 2 {
+		t.Errorf("wrong value for a: want=2, got=%v (%t)", v, ok)
+	}
+	if s := c.Stats(); s.Evictions != 0 || s.Len != 1 {
+		t.Errorf("updating an entry should not evict: %+v", s)
+	}
+}
+
+func TestZeroSize(t *testing.T) {
+	c := lru.New(0)
+	c.Add("a", 1)
+	if l := c.Len(); l != 0 {
+		t.Errorf("expected zero size cache to stay empty, got %d entries", l)
+	}
+}
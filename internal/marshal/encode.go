@@ -9,12 +9,21 @@ package marshal // import "mellium.im/xmpp/internal/marshal"
 import (
 	"bytes"
 	"encoding/xml"
+	"reflect"
+	"strings"
 
 	"mellium.im/xmlstream"
 )
 
 // BUG(ssw): This package is very inefficient, see https://mellium.im/issue/38.
 
+// ErrReader returns a token reader that always returns err.
+func ErrReader(err error) xml.TokenReader {
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		return nil, err
+	})
+}
+
 // TokenReader returns a reader for the XML encoding of v.
 func TokenReader(v interface{}) (xml.TokenReader, error) {
 	// If the payload is itself a marshaler, let it create its own token reader.
@@ -110,3 +119,66 @@ func EncodeXMLElement(w xmlstream.TokenWriter, v interface{}, start xml.StartEle
 	}
 	return nil
 }
+
+// Name returns the name of the outermost element in the XML encoding of v
+// without encoding it.
+// If the name cannot be determined without consuming v (for example, because v
+// is an xml.TokenReader), the zero value is returned.
+func Name(v interface{}) xml.Name {
+	if m, ok := v.(xmlstream.Marshaler); ok {
+		tok, err := m.TokenReader().Token()
+		if start, ok := tok.(xml.StartElement); ok && err == nil {
+			return start.Name
+		}
+		return xml.Name{}
+	}
+	if _, ok := v.(xml.TokenReader); ok {
+		return xml.Name{}
+	}
+
+	// Find the name the same way encoding/xml does: the value of an XMLName
+	// field, then the tag on that field, then the name of the type.
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return xml.Name{}
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return xml.Name{Local: val.Type().Name()}
+	}
+	if f, ok := val.Type().FieldByName("XMLName"); ok && f.Type == nameType {
+		if name, ok := fieldByIndex(val, f.Index); ok && name.Interface().(xml.Name).Local != "" {
+			return name.Interface().(xml.Name)
+		}
+		tag := f.Tag.Get("xml")
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			tag = tag[:i]
+		}
+		if i := strings.LastIndexByte(tag, ' '); i >= 0 {
+			return xml.Name{Space: tag[:i], Local: tag[i+1:]}
+		}
+		if tag != "" {
+			return xml.Name{Local: tag}
+		}
+	}
+	return xml.Name{Local: val.Type().Name()}
+}
+
+var nameType = reflect.TypeOf(xml.Name{})
+
+// fieldByIndex is like reflect.Value.FieldByIndex except that it reports false
+// instead of panicking if an embedded pointer is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"reflect"
+	"strconv"
 	"testing"
 
 	"mellium.im/xmlstream"
@@ -189,3 +190,45 @@ func TestEncodeXMLNS(t *testing.T) {
 		t.Errorf("wrong output: want=%s, got=%s", expected, s)
 	}
 }
+
+type namedMessage struct {
+	stanza.Message
+	Body string `xml:"body"`
+}
+
+type unnamed struct {
+	Body string `xml:"body"`
+}
+
+var nameTestCases = [...]struct {
+	v    interface{}
+	name xml.Name
+}{
+	0: {v: simpleIn, name: xml.Name{Space: "space", Local: "local"}},
+	1: {v: stanza.Message{}, name: xml.Name{Local: "message"}},
+	2: {v: &stanza.Presence{}, name: xml.Name{Local: "presence"}},
+	3: {v: namedMessage{}, name: xml.Name{Local: "message"}},
+	4: {
+		v:    stanza.IQ{XMLName: xml.Name{Space: "jabber:client", Local: "iq"}},
+		name: xml.Name{Space: "jabber:client", Local: "iq"},
+	},
+	5: {v: unnamed{}, name: xml.Name{Local: "unnamed"}},
+	6: {v: stanza.Message{}.Wrap(nil)},
+	7: {v: errMarshaler{}},
+	8: {v: (*stanza.Message)(nil)},
+	9: {
+		v:    stanza.Error{Condition: stanza.BadRequest},
+		name: xml.Name{Local: "error"},
+	},
+}
+
+func TestName(t *testing.T) {
+	for i, tc := range nameTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			name := marshal.Name(tc.v)
+			if name != tc.name {
+				t.Errorf("wrong name: want=%v, got=%v", tc.name, name)
+			}
+		})
+	}
+}
@@ -4,16 +4,23 @@
 
 // Package ns provides namespace constants that are used by the xmpp package and
 // other internal packages.
+//
+// The constants are aliases for those in the public mellium.im/xmpp/ns
+// package, which should be used for any new namespaces.
 package ns // import "mellium.im/xmpp/internal/ns"
 
+import (
+	xmppns "mellium.im/xmpp/ns"
+)
+
 // List of commonly used namespaces.
 const (
-	Bind     = "urn:ietf:params:xml:ns:xmpp-bind"
-	Client   = "jabber:client"
-	SASL     = "urn:ietf:params:xml:ns:xmpp-sasl"
-	Server   = "jabber:server"
-	Stanza   = "urn:ietf:params:xml:ns:xmpp-stanzas"
-	StartTLS = "urn:ietf:params:xml:ns:xmpp-tls"
-	WS       = "urn:ietf:params:xml:ns:xmpp-framing"
-	XML      = "http://www.w3.org/XML/1998/namespace"
+	Bind     = xmppns.Bind
+	Client   = xmppns.Client
+	SASL     = xmppns.SASL
+	Server   = xmppns.Server
+	Stanza   = xmppns.Stanza
+	StartTLS = xmppns.StartTLS
+	WS       = xmppns.Framing
+	XML      = xmppns.XML
 )
@@ -0,0 +1,29 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package stanzaname checks whether elements are stanzas.
+package stanzaname // import "mellium.im/xmpp/internal/stanzaname"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmpp/internal/ns"
+)
+
+// Is reports whether name is an IQ, message, or presence in the client or
+// server namespace, in one of the additional namespaces, or with no namespace.
+func Is(name xml.Name, namespaces ...string) bool {
+	if name.Local != "iq" && name.Local != "message" && name.Local != "presence" {
+		return false
+	}
+	if name.Space == "" || name.Space == ns.Client || name.Space == ns.Server {
+		return true
+	}
+	for _, space := range namespaces {
+		if name.Space == space {
+			return true
+		}
+	}
+	return false
+}
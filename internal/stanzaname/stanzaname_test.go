@@ -0,0 +1,38 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanzaname_test
+
+import (
+	"encoding/xml"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/internal/stanzaname"
+)
+
+var isTestCases = [...]struct {
+	name       xml.Name
+	namespaces []string
+	is         bool
+}{
+	0: {name: xml.Name{Local: "iq"}, is: true},
+	1: {name: xml.Name{Space: ns.Client, Local: "message"}, is: true},
+	2: {name: xml.Name{Space: ns.Server, Local: "presence"}, is: true},
+	3: {name: xml.Name{Space: "jabber:component:accept", Local: "iq"}},
+	4: {name: xml.Name{Space: "jabber:component:accept", Local: "iq"}, namespaces: []string{"jabber:component:accept"}, is: true},
+	5: {name: xml.Name{Space: ns.Client, Local: "body"}},
+	6: {name: xml.Name{Space: "jabber:x:oob", Local: "message"}},
+}
+
+func TestIs(t *testing.T) {
+	for i, tc := range isTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if is := stanzaname.Is(tc.name, tc.namespaces...); is != tc.is {
+				t.Errorf("wrong result for %v: want=%t, got=%t", tc.name, tc.is, is)
+			}
+		})
+	}
+}
@@ -47,6 +47,10 @@ func (r reader) Token() (xml.Token, error) {
 		case "stream":
 			// Special case returning a nice error here.
 			return nil, ErrUnexpectedRestart
+		case "features":
+			// Features may be updated after the stream is negotiated, so pass them
+			// through for the session to handle.
+			return tok, err
 		default:
 			return nil, ErrUnknownStreamElement
 		}
@@ -59,6 +63,9 @@ func (r reader) Token() (xml.Token, error) {
 		if t.Name.Local == "stream" {
 			return nil, io.EOF
 		}
+		if t.Name.Local == "features" {
+			return tok, err
+		}
 
 		// If this is a stream level end element but not </stream:stream>,
 		// something is really weird…
@@ -83,6 +83,9 @@ var readerTestCases = [...]struct {
 		in:   `<stream:stream xmlns:stream='http://etherx.jabber.org/streams'></stream:stream>`,
 		skip: 1,
 	},
+	12: {
+		in: `<stream:features xmlns:stream='http://etherx.jabber.org/streams'><sm xmlns='urn:xmpp:sm:3'/></stream:features>`,
+	},
 }
 
 func TestReader(t *testing.T) {
@@ -0,0 +1,137 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package file implements XEP-0234: Jingle File Transfer along with the
+// XEP-0261: Jingle In-Band Bytestreams and XEP-0260: Jingle SOCKS5 Bytestreams
+// transport methods.
+//
+// Files are offered by initiating a Jingle session with a content containing
+// the file description and the transport that should be used to send the file.
+// Once the peer accepts the session, the file is sent over the transport and
+// the session is terminated.
+package file // import "mellium.im/xmpp/jingle/file"
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	xmppfile "mellium.im/xmpp/file"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/jingle"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS       = `urn:xmpp:jingle:apps:file-transfer:5`
+	NSIBB    = `urn:xmpp:jingle:transports:ibb:1`
+	NSS5B    = `urn:xmpp:jingle:transports:s5b:1`
+	NSInBand = `http://jabber.org/protocol/ibb`
+)
+
+// Description is the application description of a file transfer.
+type Description struct {
+	File xmppfile.Metadata
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (d Description) TokenReader() xml.TokenReader {
+	// The file element uses the same children as the file metadata element, but
+	// is in the file transfer namespace.
+	r := d.File.TokenReader()
+	/* #nosec */
+	r.Token()
+	return xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Inner(r),
+			xml.StartElement{Name: xml.Name{Space: NS, Local: "file"}},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "description"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (d Description) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, d.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (d Description) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := d.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (d *Description) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			if t.Name.Space == NS && t.Name.Local == "file" {
+				t.Name.Space = xmppfile.NS
+				err = dec.DecodeElement(&d.File, &t)
+			} else {
+				err = dec.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Content returns a content offering the file using the provided transport.
+func Content(meta xmppfile.Metadata, t jingle.Transport) jingle.Content {
+	return jingle.Content{
+		Creator:     jingle.CreatorInitiator,
+		Name:        "file",
+		Senders:     jingle.SendersInitiator,
+		Description: Description{File: meta},
+		Transport:   t,
+	}
+}
+
+// Offer initiates a session offering a file to the peer.
+// The file should be sent over the transport once the peer accepts the
+// session.
+func Offer(ctx context.Context, s *xmpp.Session, m *jingle.Manager, to jid.JID, meta xmppfile.Metadata, t jingle.Transport) (*jingle.Session, error) {
+	return m.Initiate(ctx, s, to, Content(meta, t))
+}
+
+// Decode decodes the file description and transport from a content received
+// from the peer.
+// If the transport is not one of the transports implemented by this package it
+// is returned as is.
+// If the content is not a file transfer, ok is false.
+func Decode(c jingle.Content) (desc Description, t jingle.Transport, ok bool, err error) {
+	el, isEl := c.Description.(jingle.Element)
+	if !isEl || el.Name() != (xml.Name{Space: NS, Local: "description"}) {
+		return desc, c.Transport, false, nil
+	}
+	err = el.Decode(&desc)
+	if err != nil {
+		return desc, c.Transport, true, err
+	}
+
+	t = c.Transport
+	if el, isEl := t.(jingle.Element); isEl {
+		switch el.Namespace() {
+		case NSIBB:
+			ibb := InBand{}
+			err = el.Decode(&ibb)
+			t = ibb
+		case NSS5B:
+			s5b := SOCKS5{}
+			err = el.Decode(&s5b)
+			t = s5b
+		}
+	}
+	return desc, t, true, err
+}
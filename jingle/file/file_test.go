@@ -0,0 +1,308 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package file_test
+
+import (
+	"bytes"
+	"context"
+	/* #nosec */
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	xmppfile "mellium.im/xmpp/file"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/jingle"
+	"mellium.im/xmpp/jingle/file"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ jingle.Transport    = file.InBand{}
+	_ jingle.Transport    = file.SOCKS5{}
+	_ xmlstream.Marshaler = file.Description{}
+	_ xml.Unmarshaler     = (*file.Description)(nil)
+	_ mux.IQHandler       = (*file.InBandHandler)(nil)
+)
+
+func TestDecode(t *testing.T) {
+	c := file.Content(xmppfile.Metadata{
+		Name: "test.txt",
+		Size: 1022,
+	}, file.SOCKS5{
+		SID:     "vj3hs98y",
+		DstAddr: "972b7bf47291ca609517f67f86b5081086052dad",
+		Candidates: []file.Candidate{{
+			CID:      "hft54dqy",
+			Host:     "192.168.4.1",
+			JID:      jid.MustParse("romeo@montague.example/dr4hcr0st3lup4c"),
+			Port:     5086,
+			Priority: 8257636,
+			Type:     file.CandidateDirect,
+		}},
+	})
+	out, err := xml.Marshal(c)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	const want = `<content xmlns="urn:xmpp:jingle:1" creator="initiator" name="file" senders="initiator"><description xmlns="urn:xmpp:jingle:apps:file-transfer:5"><file xmlns="urn:xmpp:jingle:apps:file-transfer:5"><name>test.txt</name><size>1022</size></file></description><transport xmlns="urn:xmpp:jingle:transports:s5b:1" sid="vj3hs98y" dstaddr="972b7bf47291ca609517f67f86b5081086052dad"><candidate xmlns="urn:xmpp:jingle:transports:s5b:1" cid="hft54dqy" host="192.168.4.1" jid="romeo@montague.example/dr4hcr0st3lup4c" port="5086" priority="8257636" type="direct"></candidate></transport></content>`
+	if string(out) != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+
+	var decoded jingle.Content
+	err = xml.Unmarshal(out, &decoded)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	desc, transport, ok, err := file.Decode(decoded)
+	if err != nil || !ok {
+		t.Fatalf("error decoding content: ok=%t, err=%v", ok, err)
+	}
+	if desc.File.Name != "test.txt" || desc.File.Size != 1022 {
+		t.Errorf("wrong file decoded: %+v", desc.File)
+	}
+	s5b, isS5B := transport.(file.SOCKS5)
+	if !isS5B {
+		t.Fatalf("wrong transport type: %T", transport)
+	}
+	if s5b.SID != "vj3hs98y" || len(s5b.Candidates) != 1 || s5b.Candidates[0].Port != 5086 || s5b.Candidates[0].Type != file.CandidateDirect {
+		t.Errorf("wrong transport decoded: %+v", s5b)
+	}
+
+	_, _, ok, err = file.Decode(jingle.Content{Description: jingle.Element{}})
+	if ok || err != nil {
+		t.Errorf("expected non-file content to be ignored, got ok=%t, err=%v", ok, err)
+	}
+}
+
+func TestSOCKS5Info(t *testing.T) {
+	const in = `<transport xmlns="urn:xmpp:jingle:transports:s5b:1" sid="vj3hs98y"><candidate-used cid="hr65dqyd"/><proxy-error/></transport>`
+	var s5b file.SOCKS5
+	err := xml.Unmarshal([]byte(in), &s5b)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if s5b.CandidateUsed != "hr65dqyd" || !s5b.ProxyError || s5b.CandidateError || s5b.Activated != "" {
+		t.Errorf("wrong transport info decoded: %+v", s5b)
+	}
+}
+
+func TestDstAddr(t *testing.T) {
+	const (
+		sid       = "vj3hs98y"
+		requester = "romeo@montague.example/dr4hcr0st3lup4c"
+		target    = "juliet@capulet.example/yn0cl4bnw0yr3vym"
+	)
+	addr := file.DstAddr(sid, jid.MustParse(requester), jid.MustParse(target))
+	/* #nosec */
+	sum := sha1.Sum([]byte(sid + requester + target))
+	if want := hex.EncodeToString(sum[:]); addr != want {
+		t.Errorf("wrong dstaddr: want=%s, got=%s", want, addr)
+	}
+}
+
+func TestSOCKS5Handshake(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+
+	const dst = "972b7bf47291ca609517f67f86b5081086052dad"
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		err = file.Accept(ctx, conn, dst)
+		if err == nil {
+			_, err = io.WriteString(conn, "test data")
+		}
+		errs <- err
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	conn, err := file.Dial(ctx, file.Candidate{Host: addr.IP.String(), Port: uint16(addr.Port)}, dst)
+	if err != nil {
+		t.Fatalf("error dialing candidate: %v", err)
+	}
+	defer conn.Close()
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("error reading data: %v", err)
+	}
+	if string(b) != "test data" {
+		t.Errorf("wrong data received: %q", b)
+	}
+	if err = <-errs; err != nil {
+		t.Fatalf("error accepting connection: %v", err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		errs <- file.Accept(ctx, conn, dst)
+	}()
+	_, err = file.Dial(ctx, file.Candidate{Host: addr.IP.String(), Port: uint16(addr.Port)}, "wrong")
+	if err == nil {
+		t.Errorf("expected error dialing with wrong dstaddr")
+	}
+	if err = <-errs; err == nil {
+		t.Errorf("expected error accepting wrong dstaddr")
+	}
+}
+
+type writeCloser struct {
+	bytes.Buffer
+	closed chan struct{}
+}
+
+func (w *writeCloser) Close() error {
+	close(w.closed)
+	return nil
+}
+
+func TestInBand(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	w := &writeCloser{closed: make(chan struct{})}
+	h := &file.InBandHandler{
+		Open: func(sid string, _ jid.JID) io.WriteCloser {
+			if sid != "ch3d9s71" {
+				return nil
+			}
+			return w
+		},
+	}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(file.HandleInBand(h))),
+	)
+	defer cs.Close()
+
+	const data = "Through the forest have I gone."
+	err := file.InBand{SID: "ch3d9s71", BlockSize: 4}.Send(ctx, cs.Client, jid.JID{}, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("error sending data: %v", err)
+	}
+	select {
+	case <-w.closed:
+	case <-ctx.Done():
+		t.Fatalf("writer was not closed")
+	}
+	if got := w.String(); got != data {
+		t.Errorf("wrong data received: want=%q, got=%q", data, got)
+	}
+
+	err = file.InBand{SID: "unknown"}.Send(ctx, cs.Client, jid.JID{}, strings.NewReader(data))
+	if err == nil {
+		t.Errorf("expected rejected bytestream to return an error")
+	}
+}
+
+// blockingWriter records everything written to it, but blocks all writes until
+// release is closed.
+// The started channel is closed when the first write begins.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Read([]byte) (int, error) {
+	select {}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestInBandPriority(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	s := xmpptest.NewSession(0, w)
+
+	// Block the output stream so that the bytestream and message are queued.
+	sent := make(chan error, 2)
+	go func() {
+		sent <- s.Send(ctx, stanza.Message{ID: "first"}.Wrap(nil))
+	}()
+	<-w.started
+	go func() {
+		/* #nosec */
+		file.InBand{SID: "ch3d9s71"}.Send(ctx, s, jid.JID{}, strings.NewReader("data"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		sent <- s.Send(ctx, stanza.Message{ID: "second"}.Wrap(nil))
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Unblock all writes.
+	close(w.release)
+	for i := 0; i < 2; i++ {
+		if err := <-sent; err != nil {
+			t.Fatalf("error sending message: %v", err)
+		}
+	}
+	for !strings.Contains(w.String(), "<open") {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("bytestream was never opened")
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+	out := w.String()
+	if msg, open := strings.Index(out, `id="second"`), strings.Index(out, "<open"); msg == -1 || msg > open {
+		t.Errorf("expected queued message to be written before the bytestream, got: %s", out)
+	}
+}
+
+func TestInBandTransport(t *testing.T) {
+	out, err := xml.Marshal(file.InBand{SID: "ch3d9s71"})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	const want = `<transport xmlns="urn:xmpp:jingle:transports:ibb:1" block-size="4096" sid="ch3d9s71"></transport>`
+	if string(out) != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+}
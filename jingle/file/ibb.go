@@ -0,0 +1,293 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// DefaultBlockSize is the block size used by in-band bytestreams if none is
+// specified.
+const DefaultBlockSize = 4096
+
+// InBand is the in-band bytestreams transport.
+// Data is sent base64 encoded in IQs over the XMPP connection, which makes it
+// slow but means that it works whenever the peer can be reached at all.
+type InBand struct {
+	SID       string
+	BlockSize uint16
+}
+
+// Namespace implements jingle.Transport.
+func (t InBand) Namespace() string {
+	return NSIBB
+}
+
+func (t InBand) blockSize() int {
+	if t.BlockSize == 0 {
+		return DefaultBlockSize
+	}
+	return int(t.BlockSize)
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (t InBand) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSIBB, Local: "transport"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "block-size"}, Value: strconv.Itoa(t.blockSize())},
+			{Name: xml.Name{Local: "sid"}, Value: t.SID},
+		},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (t InBand) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, t.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (t InBand) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := t.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (t *InBand) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		XMLName   xml.Name `xml:"urn:xmpp:jingle:transports:ibb:1 transport"`
+		SID       string   `xml:"sid,attr"`
+		BlockSize uint16   `xml:"block-size,attr"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	t.SID = s.SID
+	t.BlockSize = s.BlockSize
+	return nil
+}
+
+// Send opens an in-band bytestream to the peer, sends all data read from r, and
+// then closes the bytestream.
+// The bytestream is sent with xmpp.BulkPriority so that other stanzas waiting to
+// be sent on s are not delayed by the transfer.
+func (t InBand) Send(ctx context.Context, s *xmpp.Session, to jid.JID, r io.Reader) error {
+	ctx = xmpp.WithPriority(ctx, xmpp.BulkPriority)
+	sidAttr := xml.Attr{Name: xml.Name{Local: "sid"}, Value: t.SID}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSInBand, Local: "open"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "block-size"}, Value: strconv.Itoa(t.blockSize())},
+			sidAttr,
+			{Name: xml.Name{Local: "stanza"}, Value: "iq"},
+		},
+	}), stanza.IQ{To: to, Type: stanza.SetIQ}, nil)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, t.blockSize())
+	var seq uint16
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			err = s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+				xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(buf[:n]))),
+				xml.StartElement{
+					Name: xml.Name{Space: NSInBand, Local: "data"},
+					Attr: []xml.Attr{
+						{Name: xml.Name{Local: "seq"}, Value: strconv.FormatUint(uint64(seq), 10)},
+						sidAttr,
+					},
+				},
+			), stanza.IQ{To: to, Type: stanza.SetIQ}, nil)
+			if err != nil {
+				return err
+			}
+			seq++
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			err = readErr
+			break
+		}
+	}
+
+	closeErr := s.UnmarshalIQElement(ctx, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSInBand, Local: "close"},
+		Attr: []xml.Attr{sidAttr},
+	}), stanza.IQ{To: to, Type: stanza.SetIQ}, nil)
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// HandleInBand returns an option that registers an InBandHandler for in-band
+// bytestreams.
+func HandleInBand(h *InBandHandler) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.IQ(stanza.SetIQ, xml.Name{Space: NSInBand, Local: "open"}, h)(m)
+		mux.IQ(stanza.SetIQ, xml.Name{Space: NSInBand, Local: "data"}, h)(m)
+		mux.IQ(stanza.SetIQ, xml.Name{Space: NSInBand, Local: "close"}, h)(m)
+	}
+}
+
+type inBandStream struct {
+	w         io.WriteCloser
+	peer      jid.JID
+	blockSize int
+	seq       uint16
+}
+
+// InBandHandler receives in-band bytestreams.
+type InBandHandler struct {
+	// Open is called when the peer opens a bytestream and returns the writer
+	// that received data is written to.
+	// The writer is closed when the bytestream is closed.
+	// If Open is nil or returns nil the bytestream is rejected.
+	Open func(sid string, from jid.JID) io.WriteCloser
+
+	mu      sync.Mutex
+	streams map[string]*inBandStream
+}
+
+var errUnknownStream = stanza.Error{Type: stanza.Cancel, Condition: stanza.ItemNotFound}
+
+// HandleIQ implements mux.IQHandler.
+func (h *InBandHandler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	s := struct {
+		SID       string `xml:"sid,attr"`
+		Seq       uint16 `xml:"seq,attr"`
+		BlockSize int    `xml:"block-size,attr"`
+		Stanza    string `xml:"stanza,attr"`
+		Data      string `xml:",chardata"`
+	}{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&s)
+	if err != nil {
+		return err
+	}
+
+	var respErr error
+	switch start.Name.Local {
+	case "open":
+		respErr = h.open(iq.From, s.SID, s.BlockSize, s.Stanza)
+	case "data":
+		respErr = h.data(iq.From, s.SID, s.Seq, s.Data)
+	case "close":
+		respErr = h.close(iq.From, s.SID)
+	}
+
+	var stanzaErr stanza.Error
+	if errors.As(respErr, &stanzaErr) {
+		_, err = xmlstream.Copy(t, iq.Error(stanzaErr))
+		return err
+	}
+	_, err = xmlstream.Copy(t, iq.Result(nil))
+	return err
+}
+
+func (h *InBandHandler) open(from jid.JID, sid string, blockSize int, stanzaType string) error {
+	switch {
+	case sid == "" || blockSize <= 0:
+		return stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}
+	case stanzaType != "" && stanzaType != "iq":
+		return stanza.Error{Type: stanza.Cancel, Condition: stanza.FeatureNotImplemented}
+	}
+
+	h.mu.Lock()
+	_, exists := h.streams[sid]
+	h.mu.Unlock()
+	if exists || h.Open == nil {
+		return stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAcceptable}
+	}
+	w := h.Open(sid, from)
+	if w == nil {
+		return stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAcceptable}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.streams == nil {
+		h.streams = make(map[string]*inBandStream)
+	}
+	h.streams[sid] = &inBandStream{
+		w:         w,
+		peer:      from,
+		blockSize: blockSize,
+	}
+	return nil
+}
+
+// stream returns the stream with the given ID if it was opened by from.
+// If remove is true the stream is also forgotten.
+func (h *InBandHandler) stream(from jid.JID, sid string, remove bool) *inBandStream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stream, ok := h.streams[sid]
+	if !ok || !stream.peer.Equal(from) {
+		return nil
+	}
+	if remove {
+		delete(h.streams, sid)
+	}
+	return stream
+}
+
+func (h *InBandHandler) data(from jid.JID, sid string, seq uint16, data string) error {
+	stream := h.stream(from, sid, false)
+	if stream == nil {
+		return errUnknownStream
+	}
+	if seq != stream.seq {
+		// Out of order data means something has gone wrong and the stream can no
+		// longer be trusted, so close it.
+		h.stream(from, sid, true)
+		/* #nosec */
+		stream.w.Close()
+		return stanza.Error{Type: stanza.Cancel, Condition: stanza.UnexpectedRequest}
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(b) > stream.blockSize {
+		h.stream(from, sid, true)
+		/* #nosec */
+		stream.w.Close()
+		return stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}
+	}
+	stream.seq++
+	_, err = stream.w.Write(b)
+	if err != nil {
+		h.stream(from, sid, true)
+		/* #nosec */
+		stream.w.Close()
+		return stanza.Error{Type: stanza.Cancel, Condition: stanza.InternalServerError}
+	}
+	return nil
+}
+
+func (h *InBandHandler) close(from jid.JID, sid string) error {
+	stream := h.stream(from, sid, true)
+	if stream == nil {
+		return errUnknownStream
+	}
+	/* #nosec */
+	stream.w.Close()
+	return nil
+}
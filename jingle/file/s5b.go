@@ -0,0 +1,387 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"context"
+	/* #nosec */
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+)
+
+// CandidateType indicates how a candidate can be reached.
+type CandidateType string
+
+// A list of candidate types.
+const (
+	CandidateAssisted CandidateType = "assisted"
+	CandidateDirect   CandidateType = "direct"
+	CandidateProxy    CandidateType = "proxy"
+	CandidateTunnel   CandidateType = "tunnel"
+)
+
+// Candidate is a host that can be connected to in order to exchange data using
+// the SOCKS5 bytestreams transport.
+type Candidate struct {
+	CID      string
+	Host     string
+	JID      jid.JID
+	Port     uint16
+	Priority uint32
+	Type     CandidateType
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (c Candidate) TokenReader() xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Space: NSS5B, Local: "candidate"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "cid"}, Value: c.CID},
+			{Name: xml.Name{Local: "host"}, Value: c.Host},
+			{Name: xml.Name{Local: "jid"}, Value: c.JID.String()},
+		},
+	}
+	if c.Port != 0 {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "port"}, Value: strconv.FormatUint(uint64(c.Port), 10)})
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "priority"}, Value: strconv.FormatUint(uint64(c.Priority), 10)})
+	if c.Type != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: string(c.Type)})
+	}
+	return xmlstream.Wrap(nil, start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (c Candidate) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Candidate) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (c *Candidate) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		CID      string        `xml:"cid,attr"`
+		Host     string        `xml:"host,attr"`
+		JID      jid.JID       `xml:"jid,attr"`
+		Port     uint16        `xml:"port,attr"`
+		Priority uint32        `xml:"priority,attr"`
+		Type     CandidateType `xml:"type,attr"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*c = Candidate(s)
+	return nil
+}
+
+// SOCKS5 is the SOCKS5 bytestreams transport.
+// Data is sent over a direct TCP connection to the peer or through a proxy.
+//
+// The same type is used to offer candidates in session-initiate and
+// session-accept requests and to report the outcome of connecting to them in
+// transport-info requests.
+type SOCKS5 struct {
+	SID        string
+	DstAddr    string
+	Candidates []Candidate
+
+	// CandidateUsed is the ID of the peer's candidate that we connected to.
+	CandidateUsed string
+
+	// CandidateError is set if none of the peer's candidates could be reached.
+	CandidateError bool
+
+	// Activated is the ID of the proxy candidate that was activated.
+	Activated string
+
+	// ProxyError is set if the proxy could not be activated.
+	ProxyError bool
+}
+
+// Namespace implements jingle.Transport.
+func (t SOCKS5) Namespace() string {
+	return NSS5B
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (t SOCKS5) TokenReader() xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Space: NSS5B, Local: "transport"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "sid"}, Value: t.SID}},
+	}
+	if t.DstAddr != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "dstaddr"}, Value: t.DstAddr})
+	}
+	var inner []xml.TokenReader
+	for _, c := range t.Candidates {
+		inner = append(inner, c.TokenReader())
+	}
+	cidElem := func(local, cid string) xml.TokenReader {
+		return xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: local},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "cid"}, Value: cid}},
+		})
+	}
+	if t.CandidateUsed != "" {
+		inner = append(inner, cidElem("candidate-used", t.CandidateUsed))
+	}
+	if t.CandidateError {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "candidate-error"}}))
+	}
+	if t.Activated != "" {
+		inner = append(inner, cidElem("activated", t.Activated))
+	}
+	if t.ProxyError {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "proxy-error"}}))
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (t SOCKS5) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, t.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (t SOCKS5) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := t.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (t *SOCKS5) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type cid struct {
+		CID string `xml:"cid,attr"`
+	}
+	s := struct {
+		XMLName        xml.Name    `xml:"urn:xmpp:jingle:transports:s5b:1 transport"`
+		SID            string      `xml:"sid,attr"`
+		DstAddr        string      `xml:"dstaddr,attr"`
+		Candidates     []Candidate `xml:"urn:xmpp:jingle:transports:s5b:1 candidate"`
+		CandidateUsed  *cid        `xml:"urn:xmpp:jingle:transports:s5b:1 candidate-used"`
+		CandidateError *struct{}   `xml:"urn:xmpp:jingle:transports:s5b:1 candidate-error"`
+		Activated      *cid        `xml:"urn:xmpp:jingle:transports:s5b:1 activated"`
+		ProxyError     *struct{}   `xml:"urn:xmpp:jingle:transports:s5b:1 proxy-error"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*t = SOCKS5{
+		SID:            s.SID,
+		DstAddr:        s.DstAddr,
+		Candidates:     s.Candidates,
+		CandidateError: s.CandidateError != nil,
+		ProxyError:     s.ProxyError != nil,
+	}
+	if s.CandidateUsed != nil {
+		t.CandidateUsed = s.CandidateUsed.CID
+	}
+	if s.Activated != nil {
+		t.Activated = s.Activated.CID
+	}
+	return nil
+}
+
+// DstAddr returns the destination address used during the SOCKS5 handshake
+// with a candidate.
+// The requester is the party that connects to the candidate and the target is
+// the party that offered it.
+func DstAddr(sid string, requester, target jid.JID) string {
+	/* #nosec */
+	h := sha1.New()
+	/* #nosec */
+	io.WriteString(h, sid+requester.String()+target.String())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SOCKS5 protocol constants.
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksNoAcceptable = 0xff
+	socksConnect      = 1
+	socksDomain       = 3
+	socksIPv4         = 1
+	socksIPv6         = 4
+	socksSucceeded    = 0
+	socksFailure      = 1
+)
+
+var errSOCKS5 = errors.New("file: invalid SOCKS5 handshake")
+
+// Dial connects to the candidate and performs the SOCKS5 handshake using the
+// provided destination address.
+// The returned connection is ready to send or receive the file.
+func Dial(ctx context.Context, c Candidate, dstAddr string) (net.Conn, error) {
+	port := c.Port
+	if port == 0 {
+		port = 1080
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(c.Host, strconv.FormatUint(uint64(port), 10)))
+	if err != nil {
+		return nil, err
+	}
+	err = withDeadline(ctx, conn, func() error {
+		return socksConnectHandshake(conn, dstAddr)
+	})
+	if err != nil {
+		/* #nosec */
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Accept performs the server side of the SOCKS5 handshake on a connection
+// accepted by a direct candidate.
+// If the peer requests a destination address other than dstAddr the
+// connection is rejected and an error is returned.
+func Accept(ctx context.Context, conn net.Conn, dstAddr string) error {
+	return withDeadline(ctx, conn, func() error {
+		return socksAcceptHandshake(conn, dstAddr)
+	})
+}
+
+func withDeadline(ctx context.Context, conn net.Conn, f func() error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		err := conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+		/* #nosec */
+		defer conn.SetDeadline(time.Time{})
+	}
+	return f()
+}
+
+func socksConnectHandshake(rw io.ReadWriter, dstAddr string) error {
+	_, err := rw.Write([]byte{socksVersion, 1, socksNoAuth})
+	if err != nil {
+		return err
+	}
+	var buf [4]byte
+	_, err = io.ReadFull(rw, buf[:2])
+	if err != nil {
+		return err
+	}
+	if buf[0] != socksVersion || buf[1] != socksNoAuth {
+		return errSOCKS5
+	}
+
+	_, err = rw.Write(socksRequest(socksConnect, dstAddr))
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(rw, buf[:4])
+	if err != nil {
+		return err
+	}
+	if buf[0] != socksVersion || buf[1] != socksSucceeded {
+		return errSOCKS5
+	}
+	// Discard the bound address and port.
+	var addrLen int
+	switch buf[3] {
+	case socksIPv4:
+		addrLen = net.IPv4len
+	case socksIPv6:
+		addrLen = net.IPv6len
+	case socksDomain:
+		_, err = io.ReadFull(rw, buf[:1])
+		if err != nil {
+			return err
+		}
+		addrLen = int(buf[0])
+	default:
+		return errSOCKS5
+	}
+	_, err = io.CopyN(ioutil.Discard, rw, int64(addrLen+2))
+	return err
+}
+
+func socksAcceptHandshake(rw io.ReadWriter, dstAddr string) error {
+	var buf [255]byte
+	_, err := io.ReadFull(rw, buf[:2])
+	if err != nil {
+		return err
+	}
+	if buf[0] != socksVersion {
+		return errSOCKS5
+	}
+	methods := buf[:buf[1]]
+	_, err = io.ReadFull(rw, methods)
+	if err != nil {
+		return err
+	}
+	noAuth := false
+	for _, m := range methods {
+		if m == socksNoAuth {
+			noAuth = true
+			break
+		}
+	}
+	if !noAuth {
+		/* #nosec */
+		rw.Write([]byte{socksVersion, socksNoAcceptable})
+		return errSOCKS5
+	}
+	_, err = rw.Write([]byte{socksVersion, socksNoAuth})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.ReadFull(rw, buf[:5])
+	if err != nil {
+		return err
+	}
+	if buf[0] != socksVersion || buf[1] != socksConnect || buf[3] != socksDomain {
+		return errSOCKS5
+	}
+	addr := buf[:buf[4]]
+	_, err = io.ReadFull(rw, addr)
+	if err != nil {
+		return err
+	}
+	got := string(addr)
+	// Discard the port, which is always zero.
+	_, err = io.ReadFull(rw, buf[:2])
+	if err != nil {
+		return err
+	}
+	if got != dstAddr {
+		/* #nosec */
+		rw.Write(socksRequest(socksFailure, got))
+		return errSOCKS5
+	}
+	_, err = rw.Write(socksRequest(socksSucceeded, dstAddr))
+	return err
+}
+
+// socksRequest returns a SOCKS5 request or reply with the given command or
+// reply code and a domain name address type.
+func socksRequest(cmd byte, addr string) []byte {
+	b := make([]byte, 0, 7+len(addr))
+	b = append(b, socksVersion, cmd, 0, socksDomain, byte(len(addr)))
+	b = append(b, addr...)
+	return append(b, 0, 0)
+}
@@ -0,0 +1,426 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package jingle implements XEP-0166: Jingle.
+//
+// Jingle is a framework for negotiating peer-to-peer sessions between two
+// entities.
+// Each session contains one or more contents, each of which pairs an
+// application description (for example a file transfer) with a transport
+// method used to exchange the application's data (for example in-band
+// bytestreams).
+// Applications and transports are pluggable: this package only implements the
+// session state machine and leaves the details of the application and
+// transport to other packages such as mellium.im/xmpp/jingle/file.
+//
+// To handle incoming sessions and track the state of outgoing sessions,
+// register a Manager on the mux using Handle:
+//
+//     m := &jingle.Manager{
+//         Incoming: func(sess *jingle.Session) {
+//             go sess.Accept(ctx, s)
+//         },
+//     }
+//     h := mux.New(jingle.Handle(m))
+package jingle // import "mellium.im/xmpp/jingle"
+
+import (
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS       = `urn:xmpp:jingle:1`
+	NSErrors = `urn:xmpp:jingle:errors:1`
+)
+
+// Action is the type of a Jingle request.
+type Action string
+
+// A list of actions supported by this package.
+const (
+	ActionSessionInitiate  Action = "session-initiate"
+	ActionSessionAccept    Action = "session-accept"
+	ActionSessionTerminate Action = "session-terminate"
+	ActionSessionInfo      Action = "session-info"
+	ActionTransportInfo    Action = "transport-info"
+)
+
+// Creator indicates which party originally generated a content.
+type Creator string
+
+// A list of possible creators.
+const (
+	CreatorInitiator Creator = "initiator"
+	CreatorResponder Creator = "responder"
+)
+
+// Senders indicates which parties will be generating content.
+type Senders string
+
+// A list of possible senders.
+// If no senders are specified both parties send content.
+const (
+	SendersBoth      Senders = "both"
+	SendersInitiator Senders = "initiator"
+	SendersNone      Senders = "none"
+	SendersResponder Senders = "responder"
+)
+
+// Condition is the reason a session was terminated.
+type Condition string
+
+// A list of reason conditions.
+const (
+	AlternativeSession      Condition = "alternative-session"
+	Busy                    Condition = "busy"
+	Cancel                  Condition = "cancel"
+	ConnectivityError       Condition = "connectivity-error"
+	Decline                 Condition = "decline"
+	Expired                 Condition = "expired"
+	FailedApplication       Condition = "failed-application"
+	FailedTransport         Condition = "failed-transport"
+	GeneralError            Condition = "general-error"
+	Gone                    Condition = "gone"
+	IncompatibleParameters  Condition = "incompatible-parameters"
+	MediaError              Condition = "media-error"
+	SecurityError           Condition = "security-error"
+	Success                 Condition = "success"
+	Timeout                 Condition = "timeout"
+	UnsupportedApplications Condition = "unsupported-applications"
+	UnsupportedTransports   Condition = "unsupported-transports"
+)
+
+// Reason is included when a session is terminated to explain why.
+type Reason struct {
+	Condition Condition
+	Text      string
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Reason) TokenReader() xml.TokenReader {
+	inner := []xml.TokenReader{
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: string(r.Condition)}}),
+	}
+	if r.Text != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(r.Text)),
+			xml.StartElement{Name: xml.Name{Local: "text"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "reason"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Reason) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Reason) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (r *Reason) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			if t.Name.Local == "text" {
+				err = d.DecodeElement(&r.Text, &t)
+			} else {
+				r.Condition = Condition(t.Name.Local)
+				err = d.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Transport is implemented by transport methods that can be negotiated as part
+// of a content.
+type Transport interface {
+	xmlstream.Marshaler
+
+	// Namespace returns the namespace of the transport element, which
+	// identifies the transport method.
+	Namespace() string
+}
+
+// Element is an application description or transport received from the peer.
+//
+// Because applications and transports are pluggable, contents that are
+// received from the peer contain an Element which can then be decoded into a
+// concrete type by the package that implements the application or transport.
+type Element struct {
+	start xml.StartElement
+	inner []xml.Token
+}
+
+// Name returns the name of the element.
+func (e Element) Name() xml.Name {
+	return e.start.Name
+}
+
+// Namespace returns the namespace of the element.
+// It is the same as e.Name().Space and lets Element be used as a Transport.
+func (e Element) Namespace() string {
+	return e.start.Name.Space
+}
+
+// Decode unmarshals the element into v.
+func (e Element) Decode(v interface{}) error {
+	return xml.NewTokenDecoder(xmlstream.Wrap(e.tokens(), e.start)).Decode(v)
+}
+
+func (e Element) tokens() xml.TokenReader {
+	inner := e.inner
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		if len(inner) == 0 {
+			return nil, io.EOF
+		}
+		tok := inner[0]
+		inner = inner[1:]
+		return tok, nil
+	})
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (e Element) TokenReader() xml.TokenReader {
+	// Children in the same namespace as their parent are returned without a
+	// namespace so that it is inherited from the parent when they are encoded
+	// instead of being redeclared on every element.
+	inner := e.tokens()
+	spaces := []string{e.start.Name.Space}
+	return xmlstream.Wrap(xmlstream.ReaderFunc(func() (xml.Token, error) {
+		tok, err := inner.Token()
+		switch t := tok.(type) {
+		case xml.StartElement:
+			spaces = append(spaces, t.Name.Space)
+			if t.Name.Space == spaces[len(spaces)-2] {
+				t.Name.Space = ""
+			}
+			tok = t
+		case xml.EndElement:
+			spaces = spaces[:len(spaces)-1]
+			if t.Name.Space == spaces[len(spaces)-1] {
+				t.Name.Space = ""
+			}
+			tok = t
+		}
+		return tok, err
+	}), e.start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (e Element) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, e.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (e Element) MarshalXML(enc *xml.Encoder, _ xml.StartElement) error {
+	_, err := e.WriteXML(enc)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (e *Element) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	e.start = withoutXMLNS(start)
+	e.inner = e.inner[:0]
+	for depth := 1; ; {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			tok = withoutXMLNS(t)
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+		e.inner = append(e.inner, xml.CopyToken(tok))
+	}
+}
+
+// withoutXMLNS returns a copy of start without any default namespace
+// declaration, which is already reflected in the element name and would
+// otherwise be duplicated when the element is encoded again.
+func withoutXMLNS(start xml.StartElement) xml.StartElement {
+	start = start.Copy()
+	attrs := start.Attr[:0]
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	start.Attr = attrs
+	return start
+}
+
+// Content pairs an application description with a transport.
+type Content struct {
+	Creator Creator
+	Name    string
+	Senders Senders
+
+	// Description is the application description.
+	// In contents received from the peer it is an Element.
+	Description xmlstream.Marshaler
+
+	// Transport is the transport method used to exchange the application data.
+	// In contents received from the peer it is an Element.
+	Transport Transport
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (c Content) TokenReader() xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "content"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "creator"}, Value: string(c.Creator)},
+			{Name: xml.Name{Local: "name"}, Value: c.Name},
+		},
+	}
+	if c.Senders != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "senders"}, Value: string(c.Senders)})
+	}
+	var inner []xml.TokenReader
+	if c.Description != nil {
+		inner = append(inner, c.Description.TokenReader())
+	}
+	if c.Transport != nil {
+		inner = append(inner, c.Transport.TokenReader())
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (c Content) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Content) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (c *Content) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "creator":
+			c.Creator = Creator(attr.Value)
+		case "name":
+			c.Name = attr.Value
+		case "senders":
+			c.Senders = Senders(attr.Value)
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			var el Element
+			err = d.DecodeElement(&el, &t)
+			if err != nil {
+				return err
+			}
+			switch t.Name.Local {
+			case "description":
+				c.Description = el
+			case "transport":
+				c.Transport = el
+			}
+		}
+	}
+}
+
+// Jingle is the payload of all Jingle requests.
+type Jingle struct {
+	Action    Action
+	Initiator jid.JID
+	Responder jid.JID
+	SID       string
+	Contents  []Content
+	Reason    *Reason
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (j Jingle) TokenReader() xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "jingle"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "action"}, Value: string(j.Action)}},
+	}
+	if !j.Initiator.Equal(jid.JID{}) {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "initiator"}, Value: j.Initiator.String()})
+	}
+	if !j.Responder.Equal(jid.JID{}) {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "responder"}, Value: j.Responder.String()})
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "sid"}, Value: j.SID})
+	var inner []xml.TokenReader
+	for _, c := range j.Contents {
+		inner = append(inner, c.TokenReader())
+	}
+	if j.Reason != nil {
+		inner = append(inner, j.Reason.TokenReader())
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (j Jingle) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, j.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (j Jingle) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := j.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (j *Jingle) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Action    Action    `xml:"action,attr"`
+		Initiator jid.JID   `xml:"initiator,attr"`
+		Responder jid.JID   `xml:"responder,attr"`
+		SID       string    `xml:"sid,attr"`
+		Contents  []Content `xml:"urn:xmpp:jingle:1 content"`
+		Reason    *Reason   `xml:"urn:xmpp:jingle:1 reason"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*j = Jingle(s)
+	return nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package jingle_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/jingle"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xmlstream.Marshaler = jingle.Jingle{}
+	_ xmlstream.WriterTo  = jingle.Jingle{}
+	_ xml.Marshaler       = jingle.Jingle{}
+	_ xml.Unmarshaler     = (*jingle.Jingle)(nil)
+	_ jingle.Transport    = jingle.Element{}
+	_ mux.IQHandler       = (*jingle.Manager)(nil)
+)
+
+const initiateXML = `<jingle xmlns="urn:xmpp:jingle:1" action="session-initiate" initiator="romeo@montague.example/orchard" sid="a73sjjvkla37jfea"><content xmlns="urn:xmpp:jingle:1" creator="initiator" name="a-file-offer" senders="initiator"><description xmlns="urn:xmpp:jingle:apps:file-transfer:5"><file><name>test.txt</name></file></description><transport xmlns="urn:xmpp:jingle:transports:ibb:1" block-size="4096" sid="ch3d9s71"></transport></content></jingle>`
+
+func TestRoundTrip(t *testing.T) {
+	var j jingle.Jingle
+	err := xml.Unmarshal([]byte(initiateXML), &j)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if j.Action != jingle.ActionSessionInitiate || j.SID != "a73sjjvkla37jfea" || j.Initiator.String() != "romeo@montague.example/orchard" {
+		t.Errorf("wrong session attributes: %+v", j)
+	}
+	if len(j.Contents) != 1 {
+		t.Fatalf("wrong number of contents: want=1, got=%d", len(j.Contents))
+	}
+	c := j.Contents[0]
+	if c.Creator != jingle.CreatorInitiator || c.Name != "a-file-offer" || c.Senders != jingle.SendersInitiator {
+		t.Errorf("wrong content attributes: %+v", c)
+	}
+	if ns := c.Transport.Namespace(); ns != "urn:xmpp:jingle:transports:ibb:1" {
+		t.Errorf("wrong transport namespace: %q", ns)
+	}
+	var transport struct {
+		SID string `xml:"sid,attr"`
+	}
+	err = c.Transport.(jingle.Element).Decode(&transport)
+	if err != nil {
+		t.Fatalf("error decoding transport: %v", err)
+	}
+	if transport.SID != "ch3d9s71" {
+		t.Errorf("wrong transport sid: %q", transport.SID)
+	}
+
+	out, err := xml.Marshal(j)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if string(out) != initiateXML {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", initiateXML, out)
+	}
+}
+
+func TestReason(t *testing.T) {
+	const want = `<reason xmlns="urn:xmpp:jingle:1"><decline></decline><text>Not now</text></reason>`
+	out, err := xml.Marshal(jingle.Reason{Condition: jingle.Decline, Text: "Not now"})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if string(out) != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+	var r jingle.Reason
+	err = xml.Unmarshal(out, &r)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if r.Condition != jingle.Decline || r.Text != "Not now" {
+		t.Errorf("wrong reason: %+v", r)
+	}
+}
+
+func TestSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	accepted := make(chan error, 1)
+	incoming := make(chan *jingle.Session, 1)
+	var cs *xmpptest.ClientServer
+	serverM := &jingle.Manager{
+		Incoming: func(sess *jingle.Session) {
+			incoming <- sess
+			go func() {
+				accepted <- sess.Accept(ctx, cs.Server)
+			}()
+		},
+	}
+	updates := make(chan jingle.Action, 1)
+	clientM := &jingle.Manager{
+		Update: func(_ *jingle.Session, j jingle.Jingle) {
+			updates <- j.Action
+		},
+	}
+	cs = xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(jingle.Handle(clientM))),
+		xmpptest.ServerHandler(mux.New(jingle.Handle(serverM))),
+	)
+	defer cs.Close()
+
+	sess, err := clientM.Initiate(ctx, cs.Client, jid.JID{}, jingle.Content{
+		Creator: jingle.CreatorInitiator,
+		Name:    "test",
+	})
+	if err != nil {
+		t.Fatalf("error initiating session: %v", err)
+	}
+	remote := <-incoming
+	if remote.SID() != sess.SID() || remote.Local() || !sess.Local() {
+		t.Fatalf("wrong remote session: want sid=%q, got sid=%q local=%t", sess.SID(), remote.SID(), remote.Local())
+	}
+	if c := remote.Contents(); len(c) != 1 || c[0].Name != "test" {
+		t.Errorf("wrong contents received: %+v", c)
+	}
+	if err = <-accepted; err != nil {
+		t.Fatalf("error accepting session: %v", err)
+	}
+	if action := <-updates; action != jingle.ActionSessionAccept {
+		t.Errorf("wrong update: want=%s, got=%s", jingle.ActionSessionAccept, action)
+	}
+	if state := sess.State(); state != jingle.Active {
+		t.Errorf("wrong local state: want=%v, got=%v", jingle.Active, state)
+	}
+	if state := remote.State(); state != jingle.Active {
+		t.Errorf("wrong remote state: want=%v, got=%v", jingle.Active, state)
+	}
+	if err = remote.Accept(ctx, cs.Server); err == nil {
+		t.Errorf("expected error accepting active session")
+	}
+
+	err = sess.Terminate(ctx, cs.Client, jingle.Reason{Condition: jingle.Success})
+	if err != nil {
+		t.Fatalf("error terminating session: %v", err)
+	}
+	select {
+	case <-remote.Done():
+	case <-ctx.Done():
+		t.Fatalf("remote session was not ended")
+	}
+	if r := remote.Reason(); r == nil || r.Condition != jingle.Success {
+		t.Errorf("wrong reason: %+v", r)
+	}
+	if _, ok := serverM.Session(remote.Peer(), sess.SID()); ok {
+		t.Errorf("ended session should have been forgotten")
+	}
+	err = sess.TransportInfo(ctx, cs.Client)
+	if err == nil {
+		t.Errorf("expected error sending transport-info on ended session")
+	}
+}
+
+func TestUnknownSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(jingle.Handle(&jingle.Manager{}))),
+	)
+	defer cs.Close()
+
+	err := cs.Client.UnmarshalIQElement(ctx, jingle.Jingle{
+		Action: jingle.ActionSessionAccept,
+		SID:    "unknown",
+	}.TokenReader(), stanza.IQ{Type: stanza.SetIQ}, nil)
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.ItemNotFound {
+		t.Fatalf("wrong error: want=%v, got=%v", stanza.ItemNotFound, err)
+	}
+	if stanzaErr.AppCondition.Local != "unknown-session" {
+		t.Errorf("wrong app condition: %v", stanzaErr.AppCondition)
+	}
+
+	err = cs.Client.UnmarshalIQElement(ctx, jingle.Jingle{
+		Action: jingle.ActionSessionInitiate,
+		SID:    "new",
+	}.TokenReader(), stanza.IQ{Type: stanza.SetIQ}, nil)
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.ServiceUnavailable {
+		t.Errorf("expected incoming sessions to be rejected, got %v", err)
+	}
+}
+
+func TestInitiated(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	incoming := make(chan *jingle.Session, 2)
+	m := &jingle.Manager{
+		Incoming: func(sess *jingle.Session) {
+			incoming <- sess
+		},
+	}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(jingle.Handle(m))),
+	)
+	defer cs.Close()
+
+	initiate := func(from, initiator string) error {
+		return cs.Client.UnmarshalIQElement(ctx, jingle.Jingle{
+			Action:    jingle.ActionSessionInitiate,
+			SID:       "a73sjjvkla37jfea",
+			Initiator: jid.MustParse(initiator),
+		}.TokenReader(), stanza.IQ{From: jid.MustParse(from), Type: stanza.SetIQ}, nil)
+	}
+
+	err := initiate("mallory@example.net/a", "romeo@montague.example/orchard")
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.BadRequest {
+		t.Errorf("expected spoofed initiator to be rejected, got %v", err)
+	}
+
+	for _, from := range []string{"romeo@montague.example/orchard", "juliet@capulet.example/balcony"} {
+		err = initiate(from, from)
+		if err != nil {
+			t.Fatalf("error initiating session from %s: %v", from, err)
+		}
+		sess := <-incoming
+		if !sess.Peer().Equal(jid.MustParse(from)) {
+			t.Errorf("wrong peer: want=%s, got=%s", from, sess.Peer())
+		}
+		if s, ok := m.Session(jid.MustParse(from), "a73sjjvkla37jfea"); !ok || s != sess {
+			t.Errorf("session from %s not found", from)
+		}
+	}
+	err = initiate("juliet@capulet.example/balcony", "juliet@capulet.example/balcony")
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.Conflict {
+		t.Errorf("expected duplicate session to be rejected, got %v", err)
+	}
+}
+
+func TestState(t *testing.T) {
+	var b strings.Builder
+	for _, s := range []jingle.State{jingle.Pending, jingle.Active, jingle.Ended, 5} {
+		b.WriteString(s.String())
+		b.WriteByte(' ')
+	}
+	const want = "pending active ended State(5) "
+	if got := b.String(); got != want {
+		t.Errorf("wrong states: want=%q, got=%q", want, got)
+	}
+}
@@ -0,0 +1,403 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=State -linecomment
+
+package jingle
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// State is the state of a session.
+type State uint8
+
+// A list of session states.
+// Sessions start out pending, become active when the responder accepts them,
+// and end when either party terminates them.
+const (
+	Pending State = iota // pending
+	Active               // active
+	Ended                // ended
+)
+
+var (
+	errNotPending = errors.New("jingle: session is not pending")
+	errNotRemote  = errors.New("jingle: only the responder may accept a session")
+	errEnded      = errors.New("jingle: session has ended")
+)
+
+// Session is a Jingle session with a peer.
+type Session struct {
+	sid       string
+	initiator jid.JID
+	responder jid.JID
+	peer      jid.JID
+	local     bool
+	m         *Manager
+
+	mu       sync.Mutex
+	state    State
+	contents []Content
+	reason   *Reason
+	done     chan struct{}
+}
+
+// SID returns the session ID.
+func (sess *Session) SID() string {
+	return sess.sid
+}
+
+// Initiator returns the address of the entity that initiated the session.
+func (sess *Session) Initiator() jid.JID {
+	return sess.initiator
+}
+
+// Responder returns the address of the entity that the session was initiated
+// with.
+func (sess *Session) Responder() jid.JID {
+	return sess.responder
+}
+
+// Peer returns the address of the other party in the session.
+func (sess *Session) Peer() jid.JID {
+	return sess.peer
+}
+
+// Local returns true if the session was initiated by us.
+func (sess *Session) Local() bool {
+	return sess.local
+}
+
+// State returns the current state of the session.
+func (sess *Session) State() State {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.state
+}
+
+// Contents returns the contents of the session.
+// Once the session is accepted these are the contents from the
+// session-accept request.
+func (sess *Session) Contents() []Content {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.contents
+}
+
+// Reason returns the reason the session was terminated, if any.
+func (sess *Session) Reason() *Reason {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.reason
+}
+
+// Done returns a channel that is closed when the session ends.
+func (sess *Session) Done() <-chan struct{} {
+	return sess.done
+}
+
+// Accept accepts a session that was initiated by the peer.
+// If no contents are provided, the contents offered by the initiator are
+// accepted as is.
+//
+// Accept sends an IQ and waits for the response, so it must not be called
+// directly from a Manager callback.
+func (sess *Session) Accept(ctx context.Context, s *xmpp.Session, c ...Content) error {
+	if sess.local {
+		return errNotRemote
+	}
+	sess.mu.Lock()
+	if sess.state != Pending {
+		sess.mu.Unlock()
+		return errNotPending
+	}
+	if len(c) == 0 {
+		c = sess.contents
+	}
+	sess.mu.Unlock()
+
+	err := sess.send(ctx, s, Jingle{
+		Action:    ActionSessionAccept,
+		Responder: sess.responder,
+		Contents:  c,
+	})
+	if err != nil {
+		return err
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.state == Pending {
+		sess.state = Active
+		sess.contents = c
+	}
+	return nil
+}
+
+// TransportInfo sends transport specific information about the contents to the
+// peer, for example the candidate that was selected.
+func (sess *Session) TransportInfo(ctx context.Context, s *xmpp.Session, c ...Content) error {
+	if sess.State() == Ended {
+		return errEnded
+	}
+	return sess.send(ctx, s, Jingle{
+		Action:   ActionTransportInfo,
+		Contents: c,
+	})
+}
+
+// Terminate ends the session.
+// The session is considered ended even if an error is returned while notifying
+// the peer.
+func (sess *Session) Terminate(ctx context.Context, s *xmpp.Session, reason Reason) error {
+	if !sess.end(&reason) {
+		return errEnded
+	}
+	return sess.send(ctx, s, Jingle{
+		Action: ActionSessionTerminate,
+		Reason: &reason,
+	})
+}
+
+// end moves the session to the ended state and removes it from the manager.
+// It returns false if the session had already ended.
+func (sess *Session) end(reason *Reason) bool {
+	sess.mu.Lock()
+	if sess.state == Ended {
+		sess.mu.Unlock()
+		return false
+	}
+	sess.state = Ended
+	sess.reason = reason
+	close(sess.done)
+	sess.mu.Unlock()
+
+	if sess.m != nil {
+		sess.m.remove(sess)
+	}
+	return true
+}
+
+func (sess *Session) send(ctx context.Context, s *xmpp.Session, j Jingle) error {
+	j.SID = sess.sid
+	j.Initiator = sess.initiator
+	return s.UnmarshalIQElement(ctx, j.TokenReader(), stanza.IQ{
+		To:   sess.peer,
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+// Handle returns an option that registers a Manager for Jingle requests.
+func Handle(m *Manager) mux.Option {
+	return mux.IQ(stanza.SetIQ, xml.Name{Space: NS, Local: "jingle"}, m)
+}
+
+// Manager keeps track of sessions and responds to Jingle requests.
+// The zero value is a Manager that rejects all incoming sessions.
+//
+// Callbacks are called from the handler goroutine and must not block, in
+// particular they must not wait on responses to requests sent over the same
+// session.
+type Manager struct {
+	// Incoming is called when a peer initiates a new session.
+	// The session may be accepted or terminated from another goroutine.
+	// If Incoming is nil, new sessions are rejected.
+	Incoming func(*Session)
+
+	// Update is called when any other request is received for a known session
+	// after the session's state has been updated.
+	Update func(*Session, Jingle)
+
+	mu       sync.Mutex
+	sessions map[sessionKey]*Session
+}
+
+// sessionKey identifies a session.
+// Session IDs are chosen by the initiator so they are only unique for a given
+// peer.
+type sessionKey struct {
+	peer string
+	sid  string
+}
+
+func (sess *Session) key() sessionKey {
+	return sessionKey{peer: sess.peer.String(), sid: sess.sid}
+}
+
+// Initiate starts a new session with the provided contents.
+// The returned session is pending until the peer accepts it.
+func (m *Manager) Initiate(ctx context.Context, s *xmpp.Session, to jid.JID, c ...Content) (*Session, error) {
+	sess := &Session{
+		sid:       attr.RandomID(),
+		initiator: s.LocalAddr(),
+		responder: to,
+		peer:      to,
+		local:     true,
+		m:         m,
+		contents:  c,
+		done:      make(chan struct{}),
+	}
+	m.mu.Lock()
+	if m.sessions == nil {
+		m.sessions = make(map[sessionKey]*Session)
+	}
+	m.sessions[sess.key()] = sess
+	m.mu.Unlock()
+
+	err := sess.send(ctx, s, Jingle{
+		Action:   ActionSessionInitiate,
+		Contents: c,
+	})
+	if err != nil {
+		sess.end(nil)
+		return nil, err
+	}
+	return sess, nil
+}
+
+// Session returns the session with peer that has the given ID, if it is known.
+func (m *Manager) Session(peer jid.JID, sid string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[sessionKey{peer: peer.String(), sid: sid}]
+	return sess, ok
+}
+
+func (m *Manager) remove(sess *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key := sess.key(); m.sessions[key] == sess {
+		delete(m.sessions, key)
+	}
+}
+
+func jingleErr(cond stanza.Condition, appCond string) stanza.Error {
+	return stanza.Error{
+		Type:         stanza.Cancel,
+		Condition:    cond,
+		AppCondition: xml.Name{Space: NSErrors, Local: appCond},
+	}
+}
+
+// HandleIQ implements mux.IQHandler.
+func (m *Manager) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var j Jingle
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&j)
+	if err != nil {
+		return err
+	}
+
+	if j.Action == ActionSessionInitiate {
+		return m.initiated(iq, t, j)
+	}
+
+	m.mu.Lock()
+	sess, ok := m.sessions[sessionKey{peer: iq.From.String(), sid: j.SID}]
+	m.mu.Unlock()
+	if !ok {
+		_, err = xmlstream.Copy(t, iq.Error(jingleErr(stanza.ItemNotFound, "unknown-session")))
+		return err
+	}
+
+	switch j.Action {
+	case ActionSessionAccept:
+		sess.mu.Lock()
+		ok = sess.local && sess.state == Pending
+		if ok {
+			sess.state = Active
+			sess.contents = j.Contents
+		}
+		sess.mu.Unlock()
+		if !ok {
+			_, err = xmlstream.Copy(t, iq.Error(jingleErr(stanza.UnexpectedRequest, "out-of-order")))
+			return err
+		}
+	case ActionSessionTerminate:
+		sess.end(j.Reason)
+	}
+
+	_, err = xmlstream.Copy(t, iq.Result(nil))
+	if err != nil {
+		return err
+	}
+	if m.Update != nil {
+		m.Update(sess, j)
+	}
+	return nil
+}
+
+func (m *Manager) initiated(iq stanza.IQ, t xmlstream.TokenReadEncoder, j Jingle) error {
+	if m.Incoming == nil {
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ServiceUnavailable,
+		}))
+		return err
+	}
+	if j.SID == "" {
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.BadRequest,
+		}))
+		return err
+	}
+
+	// A peer must not be able to start a session on behalf of another entity.
+	// Stanzas without a from address come from our own server or account and
+	// are trusted.
+	initiator := j.Initiator
+	switch {
+	case initiator.Equal(jid.JID{}):
+		initiator = iq.From
+	case !iq.From.Equal(jid.JID{}) && !initiator.Bare().Equal(iq.From.Bare()):
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.BadRequest,
+		}))
+		return err
+	}
+	sess := &Session{
+		sid:       j.SID,
+		initiator: initiator,
+		responder: iq.To,
+		peer:      iq.From,
+		m:         m,
+		contents:  j.Contents,
+		done:      make(chan struct{}),
+	}
+	m.mu.Lock()
+	if m.sessions == nil {
+		m.sessions = make(map[sessionKey]*Session)
+	}
+	_, exists := m.sessions[sess.key()]
+	if !exists {
+		m.sessions[sess.key()] = sess
+	}
+	m.mu.Unlock()
+	if exists {
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.Conflict,
+		}))
+		return err
+	}
+
+	_, err := xmlstream.Copy(t, iq.Result(nil))
+	if err != nil {
+		return err
+	}
+	m.Incoming(sess)
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp/internal/labels"
+	"mellium.im/xmpp/internal/xmpptest"
+)
+
+// parkLabeled blocks until c is closed.
+// It is started from a goroutine so that the labels of that goroutine (which
+// are inherited by the new goroutine) can be found in a goroutine profile.
+func parkLabeled(c chan struct{}) {
+	<-c
+}
+
+// goroutineLabels returns the labels line from the goroutine profile for the
+// goroutines blocked in parkLabeled.
+func goroutineLabels(t *testing.T) string {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		var buf bytes.Buffer
+		err := pprof.Lookup("goroutine").WriteTo(&buf, 1)
+		if err != nil {
+			t.Fatalf("error writing goroutine profile: %v", err)
+		}
+		for _, record := range strings.Split(buf.String(), "\n\n") {
+			if !strings.Contains(record, "parkLabeled") {
+				continue
+			}
+			for _, line := range strings.Split(record, "\n") {
+				if strings.HasPrefix(line, "# labels: ") {
+					return line
+				}
+			}
+			return ""
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("parked goroutine not found in profile")
+	return ""
+}
+
+func TestServeLabels(t *testing.T) {
+	pprof.Do(context.Background(), pprof.Labels("caller", "test"), func(context.Context) {
+		s := xmpptest.NewSession(0, &bytes.Buffer{})
+		/* #nosec */
+		s.Serve(nil)
+
+		c := make(chan struct{})
+		defer close(c)
+		go parkLabeled(c)
+		got := goroutineLabels(t)
+		if !strings.Contains(got, `"caller":"test"`) {
+			t.Errorf("caller labels were lost after Serve returned, got %q", got)
+		}
+		if strings.Contains(got, labels.Task) {
+			t.Errorf("session labels remain on caller after Serve returned, got %q", got)
+		}
+	})
+}
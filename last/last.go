@@ -0,0 +1,151 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package last implements XEP-0012: Last Activity.
+//
+// The meaning of the reported time depends on the address that is queried.
+// When querying a client (a full JID) it is the time since the user last
+// interacted with the client, when querying an account (a bare JID) it is the
+// time since the user was last logged in, and when querying a server or
+// component it is how long it has been running.
+package last // import "mellium.im/xmpp/last"
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the XML namespace used by last activity queries.
+// It is provided as a convenience.
+const NS = "jabber:iq:last"
+
+// Query is the payload of a last activity query or response.
+type Query struct {
+	// Idle is the time since the last activity.
+	// It is transmitted with second precision.
+	Idle time.Duration
+
+	// Status is the status message of the last unavailable presence sent by the
+	// user, if any.
+	Status string
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (q Query) TokenReader() xml.TokenReader {
+	var inner xml.TokenReader
+	if q.Status != "" {
+		inner = xmlstream.Token(xml.CharData(q.Status))
+	}
+	return xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "query"},
+		Attr: []xml.Attr{{
+			Name:  xml.Name{Local: "seconds"},
+			Value: strconv.FormatInt(int64(q.Idle/time.Second), 10),
+		}},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (q Query) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, q.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (q Query) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := q.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (q *Query) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		XMLName xml.Name `xml:"jabber:iq:last query"`
+		Seconds int64    `xml:"seconds,attr"`
+		Status  string   `xml:",chardata"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	q.Idle = time.Duration(s.Seconds) * time.Second
+	q.Status = s.Status
+	return nil
+}
+
+// Get requests the last activity of the provided entity.
+// It blocks until a response is received.
+func Get(ctx context.Context, s *xmpp.Session, to jid.JID) (Query, error) {
+	return GetIQ(ctx, stanza.IQ{To: to}, s)
+}
+
+// GetIQ is like Get but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func GetIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session) (Query, error) {
+	if iq.Type != stanza.GetIQ {
+		iq.Type = stanza.GetIQ
+	}
+
+	query := Query{}
+	err := s.UnmarshalIQ(ctx, iq.Wrap(xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "query"},
+	})), &query)
+	return query, err
+}
+
+// Handle returns an option that registers a Handler for last activity queries.
+//
+// If h.Idle is nil, the time since Handle was called is reported which matches
+// the uptime semantics expected of servers and components.
+func Handle(h Handler) mux.Option {
+	if h.Idle == nil {
+		start := time.Now()
+		h.Idle = func() time.Duration {
+			return time.Since(start)
+		}
+	}
+	return mux.IQ(stanza.GetIQ, xml.Name{Space: NS, Local: "query"}, h)
+}
+
+// Handler responds to last activity queries.
+type Handler struct {
+	// Idle returns the time since the last activity.
+	// If it is nil when the handler is registered with Handle, the time since
+	// registration is reported.
+	Idle func() time.Duration
+
+	// Allow is called with the address of the entity requesting our last
+	// activity.
+	// If it is not nil and returns false, the request is refused with a
+	// service-unavailable error as if last activity were not supported.
+	Allow func(jid.JID) bool
+}
+
+// HandleIQ implements mux.IQHandler.
+func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if iq.Type != stanza.GetIQ || start.Name.Local != "query" || start.Name.Space != NS {
+		return nil
+	}
+	if h.Allow != nil && !h.Allow(iq.From) {
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ServiceUnavailable,
+		}))
+		return err
+	}
+
+	var q Query
+	if h.Idle != nil {
+		q.Idle = h.Idle()
+	}
+	_, err := xmlstream.Copy(t, iq.Result(q.TokenReader()))
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package last_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/last"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = last.Query{}
+	_ xml.Unmarshaler     = (*last.Query)(nil)
+	_ xmlstream.Marshaler = last.Query{}
+	_ xmlstream.WriterTo  = last.Query{}
+	_ mux.IQHandler       = last.Handler{}
+)
+
+func TestMarshal(t *testing.T) {
+	const want = `<query xmlns="jabber:iq:last" seconds="903">Heading Home</query>`
+	out, err := xml.Marshal(last.Query{
+		Idle:   903*time.Second + 500*time.Millisecond,
+		Status: "Heading Home",
+	})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if string(out) != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+
+	var q last.Query
+	err = xml.Unmarshal(out, &q)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if q.Idle != 903*time.Second || q.Status != "Heading Home" {
+		t.Errorf("wrong query decoded: %+v", q)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(last.Handle(last.Handler{
+			Idle: func() time.Duration { return time.Minute },
+		}))),
+	)
+	defer cs.Close()
+
+	q, err := last.Get(ctx, cs.Client, jid.JID{})
+	if err != nil {
+		t.Fatalf("error querying last activity: %v", err)
+	}
+	if q.Idle != time.Minute {
+		t.Errorf("wrong idle time: want=%v, got=%v", time.Minute, q.Idle)
+	}
+}
+
+func TestUptime(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(last.Handle(last.Handler{}))),
+	)
+	defer cs.Close()
+
+	q, err := last.Get(ctx, cs.Client, jid.JID{})
+	if err != nil {
+		t.Fatalf("error querying last activity: %v", err)
+	}
+	if q.Idle < 0 || q.Idle > time.Minute {
+		t.Errorf("unexpected uptime: %v", q.Idle)
+	}
+}
+
+func TestDisallowed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(last.Handle(last.Handler{
+			Allow: func(jid.JID) bool { return false },
+		}))),
+	)
+	defer cs.Close()
+
+	_, err := last.Get(ctx, cs.Client, jid.JID{})
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.ServiceUnavailable {
+		t.Errorf("wrong error: want=%v, got=%v", stanza.ServiceUnavailable, err)
+	}
+}
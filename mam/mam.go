@@ -0,0 +1,398 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package mam implements querying message archives.
+//
+// Archived messages are not returned in the response to a query, instead they
+// are sent as individual messages before the response.
+// To collect them a Handler must be registered with the multiplexer that
+// handles messages on the session, and the session must be serving.
+// Queries are then made with the methods on Handler.
+package mam // import "mellium.im/xmpp/mam"
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/paging"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:mam:2"
+
+const nsData = "jabber:x:data"
+
+// Query is a request for messages from an archive.
+//
+// The zero value of each filter field means that results are not filtered on
+// that field.
+type Query struct {
+	// ID is the query ID used to match results to the query.
+	// If it is empty, a random ID is generated when the query is made.
+	ID string
+
+	// With only returns messages to or from the given address.
+	With jid.JID
+	// Start and End only return messages that were archived at or after Start
+	// and at or before End respectively.
+	Start time.Time
+	End   time.Time
+	// IDs only returns the messages with the given archive IDs.
+	IDs []string
+
+	// Limit is the maximum number of messages to return in each page of results.
+	// If it is zero the server picks a default.
+	Limit uint64
+	// After requests the page after the message with the given archive ID.
+	After string
+	// Reverse requests pages starting at the end of the archive (or before the
+	// message with archive ID Before if it is set) and working backwards.
+	Reverse bool
+	Before  string
+	// Flip reverses the order of the messages in each page.
+	Flip bool
+}
+
+func formField(name string, values ...string) xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, v := range values {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(v)),
+			xml.StartElement{Name: xml.Name{Local: "value"}},
+		))
+	}
+	start := xml.StartElement{
+		Name: xml.Name{Local: "field"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "var"}, Value: name}},
+	}
+	if name == "FORM_TYPE" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: "hidden"})
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (q Query) TokenReader() xml.TokenReader {
+	fields := []xml.TokenReader{formField("FORM_TYPE", NS)}
+	if !q.With.Equal(jid.JID{}) {
+		fields = append(fields, formField("with", q.With.String()))
+	}
+	if !q.Start.IsZero() {
+		fields = append(fields, formField("start", q.Start.UTC().Format(time.RFC3339Nano)))
+	}
+	if !q.End.IsZero() {
+		fields = append(fields, formField("end", q.End.UTC().Format(time.RFC3339Nano)))
+	}
+	if len(q.IDs) > 0 {
+		fields = append(fields, formField("ids", q.IDs...))
+	}
+
+	var set xml.TokenReader
+	if q.Reverse {
+		set = (&paging.RequestPrev{Max: q.Limit, Before: q.Before}).TokenReader()
+	} else {
+		set = (&paging.RequestNext{Max: q.Limit, After: q.After}).TokenReader()
+	}
+	payload := []xml.TokenReader{
+		xmlstream.Wrap(
+			xmlstream.MultiReader(fields...),
+			xml.StartElement{
+				Name: xml.Name{Space: nsData, Local: "x"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "type"}, Value: "submit"}},
+			},
+		),
+		set,
+	}
+	if q.Flip {
+		payload = append(payload, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "flip-page"}}))
+	}
+
+	start := xml.StartElement{Name: xml.Name{Space: NS, Local: "query"}}
+	if q.ID != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "queryid"}, Value: q.ID})
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(payload...), start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (q Query) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, q.TokenReader())
+}
+
+// Result is a message that was returned from the archive.
+type Result struct {
+	// QueryID is the ID of the query that the result was returned for and ID is
+	// the ID of the message in the archive.
+	QueryID string
+	ID      string
+
+	// Delay contains the time at which the message was archived.
+	Delay delay.Delay
+
+	// Message is the archived message.
+	Message stanza.Message
+
+	toks []xml.Token
+}
+
+// TokenReader returns a token reader over the entire archived message
+// including its payload.
+func (r Result) TokenReader() xml.TokenReader {
+	return xmlstream.ReaderFunc(tokenReader(r.toks))
+}
+
+func tokenReader(toks []xml.Token) func() (xml.Token, error) {
+	var i int
+	return func() (xml.Token, error) {
+		if i >= len(toks) {
+			return nil, io.EOF
+		}
+		tok := toks[i]
+		i++
+		return tok, nil
+	}
+}
+
+// Decode unmarshals the archived message into v.
+func (r Result) Decode(v interface{}) error {
+	return xml.NewTokenDecoder(r.TokenReader()).Decode(v)
+}
+
+// decodeResult decodes a result from r which should be positioned after the
+// start element of the result payload.
+func decodeResult(start xml.StartElement, r xml.TokenReader) (Result, error) {
+	var res Result
+	_, res.QueryID = attr.Get(start.Attr, "queryid")
+	_, res.ID = attr.Get(start.Attr, "id")
+
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		child, inner := iter.Current()
+		if child == nil || child.Name.Space != forward.NS || child.Name.Local != "forwarded" {
+			continue
+		}
+		var f forward.Forwarded
+		msgReader, err := forward.Unwrap(&f, xmlstream.MultiReader(
+			xmlstream.Token(*child),
+			inner,
+			xmlstream.Token(child.End()),
+		))
+		if err != nil {
+			return res, err
+		}
+		res.Delay = f.Delay
+		for {
+			tok, err := msgReader.Token()
+			if tok != nil {
+				res.toks = append(res.toks, xml.CopyToken(tok))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return res, err
+			}
+		}
+		if len(res.toks) > 0 {
+			if msgStart, ok := res.toks[0].(xml.StartElement); ok {
+				res.Message, err = stanza.NewMessage(msgStart)
+				if err != nil {
+					return res, err
+				}
+			}
+		}
+		break
+	}
+	return res, iter.Err()
+}
+
+// Handle returns an option that registers a Handler for archived messages.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		result := xml.Name{Space: NS, Local: "result"}
+		// Archives normally send results without a type attribute.
+		mux.Message("", result, h)(m)
+		mux.Message(stanza.NormalMessage, result, h)(m)
+		mux.Message(stanza.ChatMessage, result, h)(m)
+		mux.Message(stanza.HeadlineMessage, result, h)(m)
+		mux.Message(stanza.GroupChatMessage, result, h)(m)
+	}
+}
+
+type pending struct {
+	from    jid.JID
+	results [][]xml.Token
+}
+
+// Handler collects archived messages and matches them to queries made with
+// Fetch.
+// The zero value is ready to use.
+type Handler struct {
+	queries map[string]*pending
+	m       sync.Mutex
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (h *Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	// Pop the start message token
+	_, err := t.Token()
+	if err != nil {
+		return err
+	}
+
+	iter := xmlstream.NewIter(t)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, r := iter.Current()
+		if start == nil || start.Name.Space != NS || start.Name.Local != "result" {
+			continue
+		}
+		_, queryID := attr.Get(start.Attr, "queryid")
+		h.m.Lock()
+		p, ok := h.queries[queryID]
+		h.m.Unlock()
+		// Ignore results for queries we did not make and spoofed results that
+		// did not come from the archive we queried.
+		if !ok || (!msg.From.Equal(jid.JID{}) && !msg.From.Equal(p.from)) {
+			return nil
+		}
+		// Results are decoded as they are iterated over, so keep a copy of the
+		// entire result element.
+		toks := []xml.Token{start.Copy()}
+		for {
+			tok, err := r.Token()
+			if tok != nil {
+				toks = append(toks, xml.CopyToken(tok))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		h.m.Lock()
+		p.results = append(p.results, toks)
+		h.m.Unlock()
+		return nil
+	}
+	return iter.Err()
+}
+
+type fin struct {
+	XMLName  xml.Name   `xml:"urn:xmpp:mam:2 fin"`
+	Complete bool       `xml:"complete,attr"`
+	Set      paging.Set `xml:"http://jabber.org/protocol/rsm set"`
+}
+
+// Fetch queries the archive at the address to and returns an iterator over the
+// results.
+// If to is the zero value, the user's own archive is queried.
+// When the results from one page have been consumed the next page is requested
+// until the archive reports that the query is complete.
+// Fetch blocks until the first page of results has been received and any errors
+// encountered while fetching it are deferred until the iterator is used.
+//
+// The Handler must be registered with the mux that handles messages on the
+// session and the session must be serving, or no results will be received.
+func (h *Handler) Fetch(ctx context.Context, q Query, to jid.JID, s *xmpp.Session) *Iter {
+	if q.ID == "" {
+		q.ID = attr.RandomID()
+	}
+	from := to
+	if from.Equal(jid.JID{}) {
+		from = s.LocalAddr().Bare()
+	}
+	iter := &Iter{}
+	first := paging.Page{
+		Max:     q.Limit,
+		After:   q.After,
+		Before:  q.Before,
+		Reverse: q.Reverse,
+	}
+	iter.ResultIter = paging.NewPageIter(ctx, first,
+		func(ctx context.Context, page *paging.Page) (*paging.Iter, error) {
+			q.After = page.After
+			q.Before = page.Before
+			results, f, err := h.fetchPage(ctx, s, q, to, from)
+			if err != nil {
+				return nil, err
+			}
+			set := f.Set
+			iter.page = &set
+			payload := make([]xml.TokenReader, 0, len(results)+1)
+			for _, toks := range results {
+				payload = append(payload, xmlstream.ReaderFunc(tokenReader(toks)))
+			}
+			// Once the archive says that the query is complete there are no more
+			// pages to request, so leave out the paging information.
+			if !f.Complete {
+				payload = append(payload, set.TokenReader())
+			}
+			return paging.NewIter(xmlstream.MultiReader(payload...), q.Limit), nil
+		},
+		func(start xml.StartElement, r xml.TokenReader) (interface{}, error) {
+			return decodeResult(start, r)
+		},
+	)
+	return iter
+}
+
+func (h *Handler) fetchPage(ctx context.Context, s *xmpp.Session, q Query, to, from jid.JID) ([][]xml.Token, fin, error) {
+	h.m.Lock()
+	if h.queries == nil {
+		h.queries = make(map[string]*pending)
+	}
+	p := &pending{from: from}
+	h.queries[q.ID] = p
+	h.m.Unlock()
+	defer func() {
+		h.m.Lock()
+		delete(h.queries, q.ID)
+		h.m.Unlock()
+	}()
+
+	var f fin
+	err := s.UnmarshalIQElement(ctx, q.TokenReader(), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   to,
+	}, &f)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	return p.results, f, err
+}
+
+// Iter is an iterator over messages returned from an archive.
+type Iter struct {
+	*paging.ResultIter
+
+	page *paging.Set
+}
+
+// Result returns the last message read by the iterator.
+func (i *Iter) Result() Result {
+	res, _ := i.Current().(Result)
+	return res
+}
+
+// CurrentPage returns information about the last page of results that was
+// fetched (if any).
+func (i *Iter) CurrentPage() *paging.Set {
+	return i.page
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mam_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mam"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/paging"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestMarshalQuery(t *testing.T) {
+	q := mam.Query{
+		ID:      "f27",
+		With:    jid.MustParse("juliet@capulet.lit"),
+		Start:   time.Date(2010, 6, 7, 0, 0, 0, 0, time.UTC),
+		IDs:     []string{"a", "b"},
+		Limit:   10,
+		Reverse: true,
+		Flip:    true,
+	}
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err := q.WriteXML(e)
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const expected = `<query xmlns="urn:xmpp:mam:2" queryid="f27"><x xmlns="jabber:x:data" type="submit"><field var="FORM_TYPE" type="hidden"><value>urn:xmpp:mam:2</value></field><field var="with"><value>juliet@capulet.lit</value></field><field var="start"><value>2010-06-07T00:00:00Z</value></field><field var="ids"><value>a</value><value>b</value></field></x><set xmlns="http://jabber.org/protocol/rsm"><before></before><max>10</max></set><flip-page></flip-page></query>`
+	if out := buf.String(); out != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, out)
+	}
+}
+
+// archive is a fake archive that serves one message per page.
+var archive = []string{"one", "two", "three"}
+
+func serveArchive(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	iq, err := stanza.NewIQ(*start)
+	if err != nil {
+		return err
+	}
+	var queryID, after string
+	var inAfter bool
+	for {
+		tok, err := t.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch tt := tok.(type) {
+		case xml.StartElement:
+			if tt.Name.Local == "query" {
+				_, queryID = attr.Get(tt.Attr, "queryid")
+			}
+			inAfter = tt.Name.Local == "after"
+		case xml.CharData:
+			if inAfter {
+				after = string(tt)
+			}
+		case xml.EndElement:
+			inAfter = false
+		}
+	}
+
+	idx := 0
+	for i, id := range archive {
+		if id == after {
+			idx = i + 1
+		}
+	}
+	id := archive[idx]
+	_, err = xmlstream.Copy(t, stanza.Message{}.Wrap(xmlstream.Wrap(
+		forward.Forwarded{
+			Delay: delay.Delay{Time: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)},
+		}.Wrap(stanza.Message{
+			From: jid.MustParse("juliet@capulet.lit/balcony"),
+			Type: stanza.ChatMessage,
+		}.Wrap(xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(id)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		))),
+		xml.StartElement{
+			Name: xml.Name{Space: mam.NS, Local: "result"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "queryid"}, Value: queryID},
+				{Name: xml.Name{Local: "id"}, Value: id},
+			},
+		},
+	)))
+	if err != nil {
+		return err
+	}
+
+	finStart := xml.StartElement{Name: xml.Name{Space: mam.NS, Local: "fin"}}
+	if idx == len(archive)-1 {
+		finStart.Attr = append(finStart.Attr, xml.Attr{Name: xml.Name{Local: "complete"}, Value: "true"})
+	}
+	set := &paging.Set{Last: id}
+	set.First.ID = id
+	_, err = xmlstream.Copy(t, stanza.IQ{
+		ID:   iq.ID,
+		Type: stanza.ResultIQ,
+	}.Wrap(xmlstream.Wrap(set.TokenReader(), finStart)))
+	return err
+}
+
+func TestFetch(t *testing.T) {
+	h := &mam.Handler{}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(mam.Handle(h))),
+		xmpptest.ServerHandlerFunc(serveArchive),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	iter := h.Fetch(ctx, mam.Query{Limit: 1}, jid.JID{}, cs.Client)
+	var ids, bodies []string
+	for iter.Next() {
+		res := iter.Result()
+		if res.Message.Type != stanza.ChatMessage {
+			t.Errorf("wrong message type: want=%s, got=%s", stanza.ChatMessage, res.Message.Type)
+		}
+		if want := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC); !res.Delay.Time.Equal(want) {
+			t.Errorf("wrong delay: want=%v, got=%v", want, res.Delay.Time)
+		}
+		msg := struct {
+			stanza.Message
+			Body string `xml:"body"`
+		}{}
+		err := res.Decode(&msg)
+		if err != nil {
+			t.Fatalf("error decoding result: %v", err)
+		}
+		ids = append(ids, res.ID)
+		bodies = append(bodies, msg.Body)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating: %v", err)
+	}
+	if !reflect.DeepEqual(ids, archive) {
+		t.Errorf("wrong archive IDs: want=%v, got=%v", archive, ids)
+	}
+	if !reflect.DeepEqual(bodies, archive) {
+		t.Errorf("wrong bodies: want=%v, got=%v", archive, bodies)
+	}
+	if page := iter.CurrentPage(); page == nil || page.Last != "three" {
+		t.Errorf("wrong last page: %+v", page)
+	}
+}
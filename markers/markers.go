@@ -0,0 +1,370 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=Marker -linecomment
+
+// Package markers implements chat markers.
+//
+// Chat markers let the sender of a message know that it was received,
+// displayed, or acknowledged by the recipient.
+// Unlike message delivery receipts, markers are only sent for messages that
+// were marked as markable by their sender, and a marker for a message implies
+// that all earlier messages in the conversation have been marked as well.
+package markers // import "mellium.im/xmpp/markers"
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:chat-markers:0"
+
+const nsHints = "urn:xmpp:hints"
+
+// Marker is the type of a chat marker.
+// Markers are ordered, a message that has been displayed has also been
+// received and so on.
+type Marker uint8
+
+// A list of chat markers in the order in which they are reached.
+const (
+	// ReceivedMarker indicates that the message was received by the recipient's
+	// client.
+	ReceivedMarker Marker = iota + 1 // received
+
+	// DisplayedMarker indicates that the message was displayed to the recipient.
+	DisplayedMarker // displayed
+
+	// AcknowledgedMarker indicates that the recipient has acknowledged the
+	// message, for example by performing some action that the message requested.
+	AcknowledgedMarker // acknowledged
+)
+
+// TokenReader returns a marker element for the message with ID id.
+func (m Marker) TokenReader(id string) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: m.String()},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+	})
+}
+
+func parse(name xml.Name) (Marker, bool) {
+	if name.Space != NS {
+		return 0, false
+	}
+	for m := ReceivedMarker; m <= AcknowledgedMarker; m++ {
+		if m.String() == name.Local {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+// Markable indicates that the sender of a message would like to receive chat
+// markers for it.
+type Markable struct {
+	XMLName xml.Name `xml:"urn:xmpp:chat-markers:0 markable"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (Markable) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NS, Local: "markable"}})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (m Markable) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, m.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (m Markable) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := m.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Received marks the message with the given ID as received.
+// For group chat messages the ID is the stanza ID assigned by the room, for all
+// other messages it is the ID of the message stanza.
+type Received struct {
+	XMLName xml.Name `xml:"urn:xmpp:chat-markers:0 received"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Received) TokenReader() xml.TokenReader {
+	return ReceivedMarker.TokenReader(r.ID)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Received) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Received) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Displayed marks the message with the given ID as displayed.
+// For more information about the ID see Received.
+type Displayed struct {
+	XMLName xml.Name `xml:"urn:xmpp:chat-markers:0 displayed"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (d Displayed) TokenReader() xml.TokenReader {
+	return DisplayedMarker.TokenReader(d.ID)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (d Displayed) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, d.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (d Displayed) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := d.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Acknowledged marks the message with the given ID as acknowledged.
+// For more information about the ID see Received.
+type Acknowledged struct {
+	XMLName xml.Name `xml:"urn:xmpp:chat-markers:0 acknowledged"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (a Acknowledged) TokenReader() xml.TokenReader {
+	return AcknowledgedMarker.TokenReader(a.ID)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (a Acknowledged) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, a.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (a Acknowledged) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := a.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+func isMessage(name xml.Name) bool {
+	return name.Local == "message" && (name.Space == ns.Client || name.Space == ns.Server)
+}
+
+// AddMarkable is an xmlstream.Transformer that marks any chat or group chat
+// messages read through r that have a body as markable.
+// Messages that are already markable and messages of other types are not
+// modified.
+func AddMarkable(r xml.TokenReader) xml.TokenReader {
+	var (
+		depth    int
+		inject   bool
+		hasBody  bool
+		markable bool
+		inner    xml.TokenReader
+	)
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+	start:
+		if inner != nil {
+			tok, err := inner.Token()
+			if err == io.EOF {
+				inner = nil
+				err = nil
+			}
+			return tok, err
+		}
+
+		tok, err := r.Token()
+		switch err {
+		case io.EOF:
+			if tok == nil {
+				return nil, err
+			}
+			err = nil
+		case nil:
+		default:
+			return tok, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case depth == 0 && isMessage(t.Name):
+				_, typ := attr.Get(t.Attr, "type")
+				inject = stanza.MessageType(typ) == stanza.ChatMessage || stanza.MessageType(typ) == stanza.GroupChatMessage
+				hasBody = false
+				markable = false
+			case depth == 1 && t.Name.Local == "body":
+				hasBody = true
+			case depth == 1 && t.Name.Space == NS && t.Name.Local == "markable":
+				markable = true
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 && isMessage(t.Name) && inject && hasBody && !markable {
+				inject = false
+				inner = xmlstream.MultiReader(Markable{}.TokenReader(), xmlstream.Token(t))
+				goto start
+			}
+		}
+		return tok, err
+	})
+}
+
+// Message returns a message stanza that marks the message with ID id.
+// The message should be sent to the sender of the original message and with
+// the same type.
+// It includes a hint asking the server to store the marker in the archive so
+// that other clients can synchronize the read state.
+func Message(msg stanza.Message, m Marker, id string) xml.TokenReader {
+	if msg.ID == "" {
+		msg.ID = attr.RandomID()
+	}
+	return msg.Wrap(xmlstream.MultiReader(
+		m.TokenReader(id),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsHints, Local: "store"}}),
+	))
+}
+
+// Send marks the message with ID id.
+// For more information see Message.
+func Send(ctx context.Context, s *xmpp.Session, msg stanza.Message, m Marker, id string) error {
+	return s.Send(ctx, Message(msg, m, id))
+}
+
+// Handle returns an option that registers a Handler for chat markers.
+//
+// Because delivery.Handle also handles displayed markers, the two cannot be
+// registered on the same mux.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		for marker := ReceivedMarker; marker <= AcknowledgedMarker; marker++ {
+			name := xml.Name{Space: NS, Local: marker.String()}
+			mux.Message(stanza.NormalMessage, name, h)(m)
+			mux.Message(stanza.ChatMessage, name, h)(m)
+			mux.Message(stanza.GroupChatMessage, name, h)(m)
+		}
+	}
+}
+
+// Handler keeps track of the latest marker received for each message.
+// Because markers only ever move forward, a marker that is older than the one
+// already seen for a message is ignored.
+//
+// In group chats markers may be received from many occupants, the Handler only
+// keeps track of the furthest marker received from any of them.
+type Handler struct {
+	// Update, if set, is called after a marker moves a message forward with the
+	// ID of the message, the sender of the marker, and the new marker.
+	Update func(id string, from jid.JID, m Marker)
+
+	mu   sync.Mutex
+	msgs map[string]Marker
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (h *Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	// Pop the start message token.
+	_, err := t.Token()
+	if err != nil {
+		return err
+	}
+
+	iter := xmlstream.NewIter(t)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, _ := iter.Current()
+		if start == nil {
+			continue
+		}
+		m, ok := parse(start.Name)
+		if !ok {
+			continue
+		}
+		_, id := attr.Get(start.Attr, "id")
+		if id == "" {
+			continue
+		}
+		if h.set(id, m) && h.Update != nil {
+			h.Update(id, msg.From, m)
+		}
+	}
+	return iter.Err()
+}
+
+func (h *Handler) set(id string, m Marker) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.msgs[id] >= m {
+		return false
+	}
+	if h.msgs == nil {
+		h.msgs = make(map[string]Marker)
+	}
+	h.msgs[id] = m
+	return true
+}
+
+// Marker returns the latest marker received for the message with ID id.
+// If no marker has been received for the message, ok will be false.
+func (h *Handler) Marker(id string) (m Marker, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok = h.msgs[id]
+	return m, ok
+}
+
+// Forget stops tracking the message with ID id.
+// Markers for messages are tracked until they are forgotten, so Forget should
+// be called once the message is no longer being displayed.
+func (h *Handler) Forget(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.msgs, id)
+}
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "markable"}, func() interface{} {
+		return &Markable{}
+	})
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: ReceivedMarker.String()}, func() interface{} {
+		return &Received{}
+	})
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: DisplayedMarker.String()}, func() interface{} {
+		return &Displayed{}
+	})
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: AcknowledgedMarker.String()}, func() interface{} {
+		return &Acknowledged{}
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package markers_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/markers"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = markers.Markable{}
+	_ xmlstream.Marshaler = markers.Markable{}
+	_ xmlstream.WriterTo  = markers.Markable{}
+	_ xml.Marshaler       = markers.Received{}
+	_ xmlstream.Marshaler = markers.Received{}
+	_ xmlstream.WriterTo  = markers.Received{}
+	_ xml.Marshaler       = markers.Displayed{}
+	_ xmlstream.Marshaler = markers.Displayed{}
+	_ xmlstream.WriterTo  = markers.Displayed{}
+	_ xml.Marshaler       = markers.Acknowledged{}
+	_ xmlstream.Marshaler = markers.Acknowledged{}
+	_ xmlstream.WriterTo  = markers.Acknowledged{}
+
+	_ xmlstream.Transformer = markers.AddMarkable
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, v := range []interface{}{
+		&markers.Received{ID: "1"},
+		&markers.Displayed{ID: "2"},
+		&markers.Acknowledged{ID: "3"},
+	} {
+		b, err := xml.Marshal(v)
+		if err != nil {
+			t.Fatalf("error marshaling %T: %v", v, err)
+		}
+		got := reflect.New(reflect.TypeOf(v).Elem()).Interface()
+		err = xml.Unmarshal(b, got)
+		if err != nil {
+			t.Fatalf("error unmarshaling %s: %v", b, err)
+		}
+		// The XMLName is set when unmarshaling.
+		reflect.ValueOf(v).Elem().Field(0).Set(reflect.ValueOf(got).Elem().Field(0))
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("round trip failed: want=%+v, got=%+v", v, got)
+		}
+	}
+}
+
+var addMarkableTestCases = [...]struct {
+	in  string
+	out string
+}{
+	0: {
+		in:  `<message xmlns="jabber:client" type="chat"><body>hi</body></message>`,
+		out: `<message xmlns="jabber:client" type="chat"><body xmlns="jabber:client">hi</body><markable xmlns="urn:xmpp:chat-markers:0"></markable></message>`,
+	},
+	1: {
+		in:  `<message xmlns="jabber:client" type="groupchat"><body>hi</body></message>`,
+		out: `<message xmlns="jabber:client" type="groupchat"><body xmlns="jabber:client">hi</body><markable xmlns="urn:xmpp:chat-markers:0"></markable></message>`,
+	},
+	2: {
+		in:  `<message xmlns="jabber:client" type="chat"><body>hi</body><markable xmlns="urn:xmpp:chat-markers:0"></markable></message>`,
+		out: `<message xmlns="jabber:client" type="chat"><body xmlns="jabber:client">hi</body><markable xmlns="urn:xmpp:chat-markers:0"></markable></message>`,
+	},
+	3: {
+		in:  `<message xmlns="jabber:client" type="chat"><active xmlns="http://jabber.org/protocol/chatstates"></active></message>`,
+		out: `<message xmlns="jabber:client" type="chat"><active xmlns="http://jabber.org/protocol/chatstates"></active></message>`,
+	},
+	4: {
+		in:  `<message xmlns="jabber:client" type="headline"><body>hi</body></message>`,
+		out: `<message xmlns="jabber:client" type="headline"><body xmlns="jabber:client">hi</body></message>`,
+	},
+	5: {
+		in:  `<message xmlns="jabber:client" type="chat"><body>hi</body></message><message xmlns="jabber:client" type="chat"><body>hi</body></message>`,
+		out: `<message xmlns="jabber:client" type="chat"><body xmlns="jabber:client">hi</body><markable xmlns="urn:xmpp:chat-markers:0"></markable></message><message xmlns="jabber:client" type="chat"><body xmlns="jabber:client">hi</body><markable xmlns="urn:xmpp:chat-markers:0"></markable></message>`,
+	},
+}
+
+func TestAddMarkable(t *testing.T) {
+	for i, tc := range addMarkableTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := markers.AddMarkable(xml.NewDecoder(strings.NewReader(tc.in)))
+			// Prevent duplicate xmlns attributes. See https://mellium.im/issue/75
+			r = xmlstream.RemoveAttr(func(start xml.StartElement, attr xml.Attr) bool {
+				return attr.Name.Space == "" && attr.Name.Local == "xmlns"
+			})(r)
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, r)
+			if err != nil {
+				t.Fatalf("error encoding: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			if out := buf.String(); out != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, markers.Message(stanza.Message{
+		ID:   "marker1",
+		To:   jid.MustParse("northumberland@shakespeare.lit/westminster"),
+		Type: stanza.ChatMessage,
+	}, markers.DisplayedMarker, "message-1"))
+	if err != nil {
+		t.Fatalf("error encoding message: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<message type="chat" id="marker1" to="northumberland@shakespeare.lit/westminster"><displayed xmlns="urn:xmpp:chat-markers:0" id="message-1"></displayed><store xmlns="urn:xmpp:hints"></store></message>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var updates []string
+	h := &markers.Handler{
+		Update: func(id string, from jid.JID, m markers.Marker) {
+			updates = append(updates, fmt.Sprintf("%s:%s:%s", id, from, m))
+		},
+	}
+	m := mux.New(markers.Handle(h))
+
+	for _, raw := range []string{
+		`<message xmlns="jabber:client" from="kingrichard@royalty.england.lit/throne" type="chat"><received xmlns="urn:xmpp:chat-markers:0" id="1"/></message>`,
+		`<message xmlns="jabber:client" from="kingrichard@royalty.england.lit/throne" type="chat"><displayed xmlns="urn:xmpp:chat-markers:0" id="1"/></message>`,
+		`<message xmlns="jabber:client" from="kingrichard@royalty.england.lit/throne" type="chat"><received xmlns="urn:xmpp:chat-markers:0" id="1"/></message>`,
+		`<message xmlns="jabber:client" from="kingrichard@royalty.england.lit/throne" type="chat"><acknowledged xmlns="urn:xmpp:chat-markers:0" id="2"/></message>`,
+		`<message xmlns="jabber:client" from="coven@chat.shakespeare.lit/firstwitch" type="groupchat"><displayed xmlns="urn:xmpp:chat-markers:0" id="3"/></message>`,
+	} {
+		d := xml.NewDecoder(strings.NewReader(raw))
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatalf("error popping start token: %v", err)
+		}
+		start := tok.(xml.StartElement)
+		err = m.HandleXMPP(struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: d,
+			Encoder:     xml.NewEncoder(ioutil.Discard),
+		}, &start)
+		if err != nil {
+			t.Fatalf("error handling %s: %v", raw, err)
+		}
+	}
+
+	wantUpdates := []string{
+		"1:kingrichard@royalty.england.lit/throne:received",
+		"1:kingrichard@royalty.england.lit/throne:displayed",
+		"2:kingrichard@royalty.england.lit/throne:acknowledged",
+		"3:coven@chat.shakespeare.lit/firstwitch:displayed",
+	}
+	if !reflect.DeepEqual(updates, wantUpdates) {
+		t.Errorf("wrong updates:\nwant=%v,\n got=%v", wantUpdates, updates)
+	}
+	if marker, ok := h.Marker("1"); !ok || marker != markers.DisplayedMarker {
+		t.Errorf("wrong marker for message 1: %v, %t", marker, ok)
+	}
+	h.Forget("1")
+	if _, ok := h.Marker("1"); ok {
+		t.Errorf("expected message 1 to be forgotten")
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><markable xmlns="urn:xmpp:chat-markers:0"/><received xmlns="urn:xmpp:chat-markers:0" id="1"/><displayed xmlns="urn:xmpp:chat-markers:0" id="2"/><acknowledged xmlns="urn:xmpp:chat-markers:0" id="3"/></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		&markers.Markable{XMLName: xml.Name{Space: markers.NS, Local: "markable"}},
+		&markers.Received{XMLName: xml.Name{Space: markers.NS, Local: "received"}, ID: "1"},
+		&markers.Displayed{XMLName: xml.Name{Space: markers.NS, Local: "displayed"}, ID: "2"},
+		&markers.Acknowledged{XMLName: xml.Name{Space: markers.NS, Local: "acknowledged"}, ID: "3"},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
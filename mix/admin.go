@@ -0,0 +1,112 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mix
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+)
+
+// Fields of the channel configuration form.
+// Fields ending in "Subscription" or "Rights" take one of the Permission values.
+const (
+	FieldOwner                    = "Owner"
+	FieldAdministrator            = "Administrator"
+	FieldEndOfLife                = "End of Life"
+	FieldNodesPresent             = "Nodes Present"
+	FieldMessagesSubscription     = "Messages Node Subscription"
+	FieldPresenceSubscription     = "Presence Node Subscription"
+	FieldParticipantsSubscription = "Participants Node Subscription"
+	FieldInfoSubscription         = "Information Node Subscription"
+	FieldInfoUpdateRights         = "Information Node Update Rights"
+	FieldAvatarUpdateRights       = "Avatar Nodes Update Rights"
+	FieldOpenPresence             = "Open Presence"
+	FieldMustProvidePresence      = "Participants Must Provide Presence"
+	FieldPrivateMessages          = "Private Messages"
+	FieldMandatoryNicks           = "Mandatory Nicks"
+)
+
+// Permission is a value that controls who may access or modify a node.
+type Permission string
+
+// A list of permissions, not all of which are valid for every field.
+const (
+	PermAnyone       Permission = "anyone"
+	PermParticipants Permission = "participants"
+	PermAllowed      Permission = "allowed"
+	PermAdmins       Permission = "admins"
+	PermOwners       Permission = "owners"
+	PermNobody       Permission = "nobody"
+)
+
+// FetchConfig returns the configuration form of a channel.
+// Only administrators and owners of the channel may fetch its configuration.
+func FetchConfig(ctx context.Context, s *xmpp.Session, channel jid.JID) (*form.Data, error) {
+	resp := struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Items   struct {
+			Item struct {
+				Form form.Data `xml:"jabber:x:data x"`
+			} `xml:"item"`
+		} `xml:"items"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "items"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: NodeConfig}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: pubsub.NS, Local: "pubsub"}},
+	), stanza.IQ{To: channel, Type: stanza.GetIQ}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Items.Item.Form, nil
+}
+
+// SetConfig updates the configuration of a channel.
+// Normally cfg will be the result of FetchConfig after any values that need to
+// be changed have been set.
+// Only owners of the channel may change its configuration.
+func SetConfig(ctx context.Context, s *xmpp.Session, channel jid.JID, cfg *form.Data) error {
+	submission, ok := cfg.Submit()
+	if !ok {
+		return fmt.Errorf("mix: cannot submit channel configuration: %w", form.ErrRequired)
+	}
+	_, err := pubsub.Publish(ctx, s, channel, NodeConfig, "", submission)
+	return err
+}
+
+// Allow adds a JID to the list of entities allowed to join a channel.
+// The JID may be a bare JID or a domain, in which case all users of that domain
+// are allowed.
+func Allow(ctx context.Context, s *xmpp.Session, channel, j jid.JID) error {
+	_, err := pubsub.Publish(ctx, s, channel, NodeAllowed, j.String(), nil)
+	return err
+}
+
+// Disallow removes a JID from the list of entities allowed to join a channel.
+func Disallow(ctx context.Context, s *xmpp.Session, channel, j jid.JID) error {
+	return pubsub.Retract(ctx, s, channel, NodeAllowed, j.String())
+}
+
+// Ban adds a JID to the list of entities that are banned from a channel,
+// removing them from the channel if they are currently a participant.
+func Ban(ctx context.Context, s *xmpp.Session, channel, j jid.JID) error {
+	_, err := pubsub.Publish(ctx, s, channel, NodeBanned, j.String(), nil)
+	return err
+}
+
+// Unban removes a JID from the list of entities that are banned from a channel.
+func Unban(ctx context.Context, s *xmpp.Session, channel, j jid.JID) error {
+	return pubsub.Retract(ctx, s, channel, NodeBanned, j.String())
+}
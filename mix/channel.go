@@ -0,0 +1,179 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mix
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sync"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/groupchat"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+)
+
+// Channel is a MIX channel that implements groupchat.Channel.
+type Channel struct {
+	s       *xmpp.Session
+	channel jid.JID
+
+	mu          sync.Mutex
+	participant Participant
+}
+
+// Open returns the channel at addr as a groupchat.Channel.
+// It can be used as a groupchat.Opener.
+func Open(s *xmpp.Session, addr jid.JID) groupchat.Channel {
+	return &Channel{s: s, channel: addr.Bare()}
+}
+
+// Addr returns the bare address of the channel.
+func (c *Channel) Addr() jid.JID {
+	return c.channel
+}
+
+// Participant returns the result of joining the channel.
+// If the channel has not been joined using c, the zero value is returned.
+func (c *Channel) Participant() Participant {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.participant
+}
+
+// Join joins the channel and subscribes to messages, presence, participants,
+// and channel information.
+func (c *Channel) Join(ctx context.Context, nick string) error {
+	p, err := Join(ctx, c.s, c.channel, nick, NodeMessages, NodePresence, NodeParticipants, NodeInfo)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.participant = p
+	c.mu.Unlock()
+	return nil
+}
+
+// Leave leaves the channel.
+// MIX has no way to tell other participants why we left so status is ignored.
+func (c *Channel) Leave(ctx context.Context, status string) error {
+	err := Leave(ctx, c.s, c.channel)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.participant = Participant{}
+	c.mu.Unlock()
+	return nil
+}
+
+// Send sends a groupchat message with the provided payload to the channel.
+func (c *Channel) Send(ctx context.Context, payload xml.TokenReader) error {
+	return c.s.Send(ctx, stanza.Message{
+		To:   c.channel,
+		Type: stanza.GroupChatMessage,
+	}.Wrap(payload))
+}
+
+// Members returns the participants of the channel.
+// The address of each member is its proxy JID.
+// Real JIDs are only known if the channel shows them.
+func (c *Channel) Members(ctx context.Context) ([]groupchat.Member, error) {
+	iter := pubsub.Fetch(ctx, c.s, c.channel, pubsub.Query{Node: NodeParticipants})
+	var members []groupchat.Member
+	for iter.Next() {
+		var p struct {
+			Nick string  `xml:"nick"`
+			JID  jid.JID `xml:"jid"`
+		}
+		err := xml.NewTokenDecoder(iter.Item()).Decode(&p)
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return members, err
+		}
+		addr, err := jid.New(iter.ID()+"#"+c.channel.Localpart(), c.channel.Domainpart(), "")
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return members, err
+		}
+		members = append(members, groupchat.Member{
+			Addr: addr,
+			JID:  p.JID,
+			Nick: p.Nick,
+		})
+	}
+	err := iter.Err()
+	if e := iter.Close(); err == nil {
+		err = e
+	}
+	return members, err
+}
+
+// fetchInfo returns the most recent item published to the information node of
+// the channel or nil if there is none.
+func (c *Channel) fetchInfo(ctx context.Context) (*form.Data, error) {
+	iter := pubsub.Fetch(ctx, c.s, c.channel, pubsub.Query{Node: NodeInfo, MaxItems: 1})
+	var info *form.Data
+	for iter.Next() {
+		info = &form.Data{}
+		err := xml.NewTokenDecoder(iter.Item()).Decode(info)
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return nil, err
+		}
+	}
+	err := iter.Err()
+	if e := iter.Close(); err == nil {
+		err = e
+	}
+	return info, err
+}
+
+// Subject returns the description of the channel.
+// MIX channels do not have a subject so the description, which is shown in the
+// same place by most clients, is used instead.
+func (c *Channel) Subject(ctx context.Context) (string, error) {
+	info, err := c.fetchInfo(ctx)
+	if err != nil || info == nil {
+		return "", err
+	}
+	desc, _ := info.GetString("Description")
+	return desc, nil
+}
+
+// SetSubject changes the description of the channel.
+// Other information about the channel is kept.
+// Only administrators of the channel may change its description.
+func (c *Channel) SetSubject(ctx context.Context, subject string) error {
+	info, err := c.fetchInfo(ctx)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	if info != nil {
+		ok, err = info.Set("Description", subject)
+		if err != nil {
+			return err
+		}
+	}
+	if !ok {
+		info = form.New(
+			form.FormType(NS),
+			form.Text("Description", form.Value(subject)),
+		)
+	}
+	submission, ok := info.Submit()
+	if !ok {
+		return fmt.Errorf("mix: cannot submit channel information: %w", form.ErrRequired)
+	}
+	_, err = pubsub.Publish(ctx, c.s, c.channel, NodeInfo, "", submission)
+	return err
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mix_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/groupchat"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mix"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+)
+
+var _ groupchat.Opener = mix.Open
+
+func TestChannel(t *testing.T) {
+	items := map[string]string{
+		mix.NodeParticipants: `<item id="123456"><participant xmlns="urn:xmpp:mix:core:1"><nick>thirdwitch</nick><jid>hag66@shakespeare.example</jid></participant></item><item id="987654"><participant xmlns="urn:xmpp:mix:core:1"><nick>hecate</nick></participant></item>`,
+		mix.NodeInfo:         `<item id="2016-05-30T09:00:00"><x xmlns="jabber:x:data" type="result"><field var="FORM_TYPE" type="hidden"><value>urn:xmpp:mix:core:1</value></field><field var="Name"><value>Witches Coven</value></field><field var="Description"><value>A location not far from the blasted heath</value></field></x></item>`,
+	}
+	var published form.Data
+	var publishedNode string
+	m := mux.New(
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var req struct {
+				Items struct {
+					Node string `xml:"node,attr"`
+				} `xml:"items"`
+			}
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+			if err != nil {
+				return err
+			}
+			d := xml.NewDecoder(strings.NewReader(`<pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="` + req.Items.Node + `">` + items[req.Items.Node] + `</items></pubsub>`))
+			_, err = xmlstream.Copy(t, iq.Result(d))
+			return err
+		}),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var req struct {
+				Publish struct {
+					Node string    `xml:"node,attr"`
+					Form form.Data `xml:"item>x"`
+				} `xml:"publish"`
+			}
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+			if err != nil {
+				return err
+			}
+			publishedNode = req.Publish.Node
+			published = req.Publish.Form
+			_, err = xmlstream.Copy(t, iq.Result(nil))
+			return err
+		}),
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+	ctx := context.Background()
+	ch := mix.Open(cs.Client, jid.MustParse("coven@mix.shakespeare.example/ignored"))
+
+	if addr := ch.Addr().String(); addr != "coven@mix.shakespeare.example" {
+		t.Errorf("wrong address: want=coven@mix.shakespeare.example, got=%s", addr)
+	}
+	members, err := ch.Members(ctx)
+	if err != nil {
+		t.Fatalf("error fetching members: %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("wrong number of members: want=2, got=%d", len(members))
+	}
+	if m := members[0]; m.Addr.String() != "123456#coven@mix.shakespeare.example" || m.JID.String() != "hag66@shakespeare.example" || m.Nick != "thirdwitch" {
+		t.Errorf("wrong member: %+v", m)
+	}
+	if m := members[1]; m.Addr.String() != "987654#coven@mix.shakespeare.example" || !m.JID.Equal(jid.JID{}) || m.Nick != "hecate" {
+		t.Errorf("wrong member: %+v", m)
+	}
+
+	subject, err := ch.Subject(ctx)
+	if err != nil {
+		t.Fatalf("error fetching subject: %v", err)
+	}
+	if subject != "A location not far from the blasted heath" {
+		t.Errorf("wrong subject: %q", subject)
+	}
+	err = ch.SetSubject(ctx, "Toil and trouble")
+	if err != nil {
+		t.Fatalf("error setting subject: %v", err)
+	}
+	if publishedNode != mix.NodeInfo {
+		t.Errorf("subject published to wrong node: want=%s, got=%s", mix.NodeInfo, publishedNode)
+	}
+	if desc, _ := published.GetString("Description"); desc != "Toil and trouble" {
+		t.Errorf("wrong description published: %q", desc)
+	}
+	if name, _ := published.GetString("Name"); name != "Witches Coven" {
+		t.Errorf("expected name to be kept, got %q", name)
+	}
+}
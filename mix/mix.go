@@ -0,0 +1,84 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package mix implements XEP-0369: Mediated Information eXchange (MIX),
+// XEP-0405: MIX Participant Server Requirements, and XEP-0406: MIX
+// Administration.
+package mix // import "mellium.im/xmpp/mix"
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS      = `urn:xmpp:mix:core:1`
+	NSAdmin = `urn:xmpp:mix:admin:0`
+	NSPAM   = `urn:xmpp:mix:pam:2`
+)
+
+// Nodes used by MIX channels.
+const (
+	NodeAllowed      = `urn:xmpp:mix:nodes:allowed`
+	NodeBanned       = `urn:xmpp:mix:nodes:banned`
+	NodeConfig       = `urn:xmpp:mix:nodes:config`
+	NodeInfo         = `urn:xmpp:mix:nodes:info`
+	NodeMessages     = `urn:xmpp:mix:nodes:messages`
+	NodeParticipants = `urn:xmpp:mix:nodes:participants`
+	NodePresence     = `urn:xmpp:mix:nodes:presence`
+)
+
+// Create creates a new channel on the provided MIX service and returns its
+// address.
+// If channel is empty an ad-hoc channel is created and the service picks the
+// name.
+func Create(ctx context.Context, s *xmpp.Session, service jid.JID, channel string) (jid.JID, error) {
+	return CreateIQ(ctx, s, stanza.IQ{To: service.Domain()}, channel)
+}
+
+// CreateIQ is like Create but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func CreateIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, channel string) (jid.JID, error) {
+	iq.Type = stanza.SetIQ
+	resp := struct {
+		XMLName xml.Name `xml:"urn:xmpp:mix:core:1 create"`
+		Channel string   `xml:"channel,attr"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, channelElem("create", channel), iq, &resp)
+	if err != nil {
+		return jid.JID{}, err
+	}
+	if resp.Channel != "" {
+		channel = resp.Channel
+	}
+	return jid.New(channel, iq.To.Domainpart(), "")
+}
+
+// Destroy destroys a channel.
+// Only owners of the channel may destroy it.
+func Destroy(ctx context.Context, s *xmpp.Session, channel jid.JID) error {
+	return DestroyIQ(ctx, s, stanza.IQ{To: channel.Domain()}, channel.Localpart())
+}
+
+// DestroyIQ is like Destroy but it allows you to customize the IQ.
+// The IQ should be addressed to the MIX service, not the channel.
+// Changing the type of the provided IQ has no effect.
+func DestroyIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, channel string) error {
+	iq.Type = stanza.SetIQ
+	return s.UnmarshalIQElement(ctx, channelElem("destroy", channel), iq, nil)
+}
+
+func channelElem(local, channel string) xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Space: NS, Local: local}}
+	if channel != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "channel"}, Value: channel})
+	}
+	return xmlstream.Wrap(nil, start)
+}
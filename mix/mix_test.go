@@ -0,0 +1,156 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mix_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mix"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestCreate(t *testing.T) {
+	m := mux.New(mux.IQFunc(stanza.SetIQ, xml.Name{Space: mix.NS, Local: "create"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		_, err := xmlstream.Copy(t, iq.Result(xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: mix.NS, Local: "create"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "channel"}, Value: "A1B2C345"}},
+		})))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	channel, err := mix.Create(context.Background(), cs.Client, jid.MustParse("mix.shakespeare.example"), "")
+	if err != nil {
+		t.Fatalf("error creating channel: %v", err)
+	}
+	const expected = "a1b2c345@mix.shakespeare.example"
+	if s := channel.String(); s != expected {
+		t.Errorf("wrong channel: want=%s, got=%s", expected, s)
+	}
+}
+
+func TestBan(t *testing.T) {
+	var req struct {
+		Publish struct {
+			Node string `xml:"node,attr"`
+			Item struct {
+				ID string `xml:"id,attr"`
+			} `xml:"item"`
+		} `xml:"publish"`
+	}
+	m := mux.New(mux.IQFunc(stanza.SetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+		if err != nil {
+			return err
+		}
+		_, err = xmlstream.Copy(t, iq.Result(nil))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	err := mix.Ban(context.Background(), cs.Client, jid.MustParse("coven@mix.shakespeare.example"), jid.MustParse("lear@shakespeare.example"))
+	if err != nil {
+		t.Fatalf("error banning user: %v", err)
+	}
+	if req.Publish.Node != mix.NodeBanned {
+		t.Errorf("wrong node: want=%s, got=%s", mix.NodeBanned, req.Publish.Node)
+	}
+	if req.Publish.Item.ID != "lear@shakespeare.example" {
+		t.Errorf("wrong item ID: want=lear@shakespeare.example, got=%s", req.Publish.Item.ID)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	var req struct {
+		To      string `xml:"to,attr"`
+		Channel string `xml:"channel,attr"`
+		Join    struct {
+			Subscribe []struct {
+				Node string `xml:"node,attr"`
+			} `xml:"subscribe"`
+			Nick string `xml:"nick"`
+		} `xml:"urn:xmpp:mix:core:1 join"`
+	}
+	m := mux.New(mux.IQFunc(stanza.SetIQ, xml.Name{Space: mix.NSPAM, Local: "client-join"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+		if err != nil {
+			return err
+		}
+		req.To = iq.To.String()
+		d := xml.NewDecoder(strings.NewReader(`<client-join xmlns="urn:xmpp:mix:pam:2"><join xmlns="urn:xmpp:mix:core:1" jid="123456#coven@mix.shakespeare.example"><subscribe node="urn:xmpp:mix:nodes:messages"/><nick>third witch</nick></join></client-join>`))
+		_, err = xmlstream.Copy(t, iq.Result(d))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	p, err := mix.Join(context.Background(), cs.Client, jid.MustParse("coven@mix.shakespeare.example"), "thirdwitch", mix.NodeMessages, mix.NodePresence)
+	if err != nil {
+		t.Fatalf("error joining channel: %v", err)
+	}
+	if want := cs.Client.LocalAddr().Bare().String(); req.To != want {
+		t.Errorf("join sent to wrong address: want=%s, got=%s", want, req.To)
+	}
+	if req.Channel != "coven@mix.shakespeare.example" {
+		t.Errorf("wrong channel: want=coven@mix.shakespeare.example, got=%s", req.Channel)
+	}
+	if len(req.Join.Subscribe) != 2 || req.Join.Subscribe[0].Node != mix.NodeMessages || req.Join.Subscribe[1].Node != mix.NodePresence || req.Join.Nick != "thirdwitch" {
+		t.Errorf("wrong join request: %+v", req)
+	}
+	if p.ID != "123456" || p.Nick != "third witch" || len(p.Nodes) != 1 || p.Nodes[0] != mix.NodeMessages {
+		t.Errorf("wrong participant: %+v", p)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	var msg struct {
+		stanza.Message
+		Mix mix.Metadata
+	}
+	const raw = `<message xmlns="jabber:client" from="coven@mix.shakespeare.example/123456" type="groupchat"><body>Harpier cries</body><mix xmlns="urn:xmpp:mix:core:1"><nick>thirdwitch</nick><jid>hag66@shakespeare.example</jid></mix></message>`
+	err := xml.NewDecoder(strings.NewReader(raw)).Decode(&msg)
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	if msg.Mix.Nick != "thirdwitch" || msg.Mix.JID.String() != "hag66@shakespeare.example" {
+		t.Errorf("wrong metadata: %+v", msg.Mix)
+	}
+
+	var roundTrip mix.Metadata
+	err = xml.NewTokenDecoder(msg.Mix.TokenReader()).Decode(&roundTrip)
+	if err != nil {
+		t.Fatalf("error decoding marshaled metadata: %v", err)
+	}
+	if roundTrip.Nick != msg.Mix.Nick || !roundTrip.JID.Equal(msg.Mix.JID) {
+		t.Errorf("metadata did not round trip: want=%+v, got=%+v", msg.Mix, roundTrip)
+	}
+}
+
+func TestSetConfigRequired(t *testing.T) {
+	var cfg form.Data
+	err := xml.NewDecoder(strings.NewReader(`<x xmlns="jabber:x:data" type="form"><field var="Owner" type="jid-multi"><required/></field></x>`)).Decode(&cfg)
+	if err != nil {
+		t.Fatalf("error decoding form: %v", err)
+	}
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	err = mix.SetConfig(context.Background(), s, jid.MustParse("coven@mix.shakespeare.example"), &cfg)
+	if !errors.Is(err, form.ErrRequired) {
+		t.Errorf("wrong error: want=%v, got=%v", form.ErrRequired, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("did not expect incomplete form to be sent, got %s", buf.String())
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mix
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Metadata is added to messages and presence by a channel to identify the
+// participant that sent them.
+type Metadata struct {
+	XMLName xml.Name `xml:"urn:xmpp:mix:core:1 mix"`
+	Nick    string   `xml:"nick,omitempty"`
+
+	// JID is the real address of the participant, which is only included if the
+	// channel shows JIDs.
+	JID jid.JID `xml:"jid,omitempty"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (m Metadata) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	if m.Nick != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(m.Nick)),
+			xml.StartElement{Name: xml.Name{Local: "nick"}},
+		))
+	}
+	if !m.JID.Equal(jid.JID{}) {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(m.JID.String())),
+			xml.StartElement{Name: xml.Name{Local: "jid"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "mix"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (m Metadata) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, m.TokenReader())
+}
+
+// Participant is the result of joining a channel.
+type Participant struct {
+	// ID is the stable participant identifier assigned by the channel.
+	ID   string
+	Nick string
+
+	// Nodes is the list of nodes that the channel actually subscribed us to,
+	// which may be different than the list that was requested.
+	Nodes []string
+}
+
+type subscribeNode struct {
+	Node string `xml:"node,attr"`
+}
+
+type joinResp struct {
+	XMLName xml.Name `xml:"urn:xmpp:mix:pam:2 client-join"`
+	Join    struct {
+		ID        string          `xml:"id,attr"`
+		JID       string          `xml:"jid,attr"`
+		Nick      string          `xml:"nick"`
+		Subscribe []subscribeNode `xml:"subscribe"`
+	} `xml:"urn:xmpp:mix:core:1 join"`
+}
+
+// participantID returns the stable participant identifier from the join
+// response.
+// Channels may return it as the id attribute or as the localpart of the
+// participant's proxy JID before the "#" separator.
+func (r joinResp) participantID() string {
+	if r.Join.ID != "" || r.Join.JID == "" {
+		return r.Join.ID
+	}
+	id := r.Join.JID
+	if idx := strings.IndexByte(id, '#'); idx != -1 {
+		return id[:idx]
+	}
+	return ""
+}
+
+// clientElem wraps a MIX core request in the MIX-PAM element used to send it
+// through the user's server.
+func clientElem(local string, channel jid.JID, inner xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Space: NSPAM, Local: local},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "channel"}, Value: channel.Bare().String()}},
+	})
+}
+
+func nodeNames(subs []subscribeNode) []string {
+	nodes := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		nodes = append(nodes, sub.Node)
+	}
+	return nodes
+}
+
+func nodeElems(local string, nodes []string) []xml.TokenReader {
+	r := make([]xml.TokenReader, 0, len(nodes))
+	for _, node := range nodes {
+		r = append(r, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: local},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+		}))
+	}
+	return r
+}
+
+// Join joins a channel and subscribes to the provided nodes.
+// Normally nodes will include at least NodeMessages and NodePresence.
+// If nick is not empty, it is requested as our nickname in the channel.
+//
+// The request is sent to the user's server as described in XEP-0405: MIX
+// Participant Server Requirements, which joins the channel on our behalf so
+// that messages from the channel are delivered to all of our clients.
+func Join(ctx context.Context, s *xmpp.Session, channel jid.JID, nick string, nodes ...string) (Participant, error) {
+	return JoinIQ(ctx, s, stanza.IQ{To: s.LocalAddr().Bare()}, channel, nick, nodes...)
+}
+
+// JoinIQ is like Join but it allows you to customize the IQ.
+// The IQ should be addressed to the user's own bare JID.
+// Changing the type of the provided IQ has no effect.
+func JoinIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, channel jid.JID, nick string, nodes ...string) (Participant, error) {
+	iq.Type = stanza.SetIQ
+	inner := nodeElems("subscribe", nodes)
+	if nick != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(nick)),
+			xml.StartElement{Name: xml.Name{Local: "nick"}},
+		))
+	}
+	var resp joinResp
+	err := s.UnmarshalIQElement(ctx, clientElem("client-join", channel, xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "join"}},
+	)), iq, &resp)
+	if err != nil {
+		return Participant{}, err
+	}
+	return Participant{
+		ID:    resp.participantID(),
+		Nick:  resp.Join.Nick,
+		Nodes: nodeNames(resp.Join.Subscribe),
+	}, nil
+}
+
+// Leave leaves a channel.
+// Like Join, the request is sent through the user's server.
+func Leave(ctx context.Context, s *xmpp.Session, channel jid.JID) error {
+	return LeaveIQ(ctx, s, stanza.IQ{To: s.LocalAddr().Bare()}, channel)
+}
+
+// LeaveIQ is like Leave but it allows you to customize the IQ.
+// The IQ should be addressed to the user's own bare JID.
+// Changing the type of the provided IQ has no effect.
+func LeaveIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, channel jid.JID) error {
+	iq.Type = stanza.SetIQ
+	return s.UnmarshalIQElement(ctx, clientElem("client-leave", channel, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "leave"},
+	})), iq, nil)
+}
+
+// UpdateSubscription subscribes to and unsubscribes from nodes of a channel
+// that we have already joined.
+// It returns the list of nodes that were subscribed to.
+func UpdateSubscription(ctx context.Context, s *xmpp.Session, channel jid.JID, subscribe, unsubscribe []string) ([]string, error) {
+	return UpdateSubscriptionIQ(ctx, s, stanza.IQ{To: channel.Bare()}, subscribe, unsubscribe)
+}
+
+// UpdateSubscriptionIQ is like UpdateSubscription but it allows you to
+// customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func UpdateSubscriptionIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, subscribe, unsubscribe []string) ([]string, error) {
+	iq.Type = stanza.SetIQ
+	inner := append(nodeElems("subscribe", subscribe), nodeElems("unsubscribe", unsubscribe)...)
+	resp := struct {
+		XMLName   xml.Name        `xml:"urn:xmpp:mix:core:1 update-subscription"`
+		Subscribe []subscribeNode `xml:"subscribe"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "update-subscription"}},
+	), iq, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return nodeNames(resp.Subscribe), nil
+}
+
+// SetNick changes our nickname in a channel and returns the nickname that was
+// assigned by the channel.
+func SetNick(ctx context.Context, s *xmpp.Session, channel jid.JID, nick string) (string, error) {
+	resp := struct {
+		XMLName xml.Name `xml:"urn:xmpp:mix:core:1 setnick"`
+		Nick    string   `xml:"nick"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(nick)),
+			xml.StartElement{Name: xml.Name{Local: "nick"}},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "setnick"}},
+	), stanza.IQ{To: channel.Bare(), Type: stanza.SetIQ}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Nick == "" {
+		return nick, nil
+	}
+	return resp.Nick, nil
+}
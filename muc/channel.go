@@ -0,0 +1,152 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/groupchat"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// ErrNotJoined is returned when leaving a Channel that has not been joined.
+var ErrNotJoined = errors.New("muc: room has not been joined")
+
+// Channel is a room that implements groupchat.Channel.
+type Channel struct {
+	s     *xmpp.Session
+	room  jid.JID
+	rooms *Rooms
+
+	mu       sync.Mutex
+	occupant jid.JID
+}
+
+// Open returns the room at addr as a groupchat.Channel.
+// It can be used as a groupchat.Opener.
+//
+// Like the package level Join function, joining the channel does not wait for
+// the room to respond.
+func Open(s *xmpp.Session, addr jid.JID) groupchat.Channel {
+	return &Channel{s: s, room: addr.Bare()}
+}
+
+// Open is like the package level Open function except that the room is joined
+// and left using r so that it is tracked and joining it waits for the room to
+// respond.
+func (r *Rooms) Open(s *xmpp.Session, addr jid.JID) groupchat.Channel {
+	return &Channel{s: s, room: addr.Bare(), rooms: r}
+}
+
+// Addr returns the bare address of the room.
+func (c *Channel) Addr() jid.JID {
+	return c.room
+}
+
+// Join joins the room using nick as the resourcepart of the occupant JID.
+func (c *Channel) Join(ctx context.Context, nick string) error {
+	occupant, err := c.room.WithResource(nick)
+	if err != nil {
+		return err
+	}
+	if c.rooms != nil {
+		err = c.rooms.Join(ctx, c.s, occupant, JoinOptions{})
+	} else {
+		err = Join(ctx, c.s, occupant, JoinOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.occupant = occupant
+	c.mu.Unlock()
+	return nil
+}
+
+// Leave exits the room.
+// If the room has not been joined using c, ErrNotJoined is returned.
+func (c *Channel) Leave(ctx context.Context, status string) error {
+	c.mu.Lock()
+	occupant := c.occupant
+	c.mu.Unlock()
+	if occupant.Equal(jid.JID{}) {
+		return ErrNotJoined
+	}
+	var err error
+	if c.rooms != nil {
+		err = c.rooms.Leave(ctx, c.s, occupant, status)
+	} else {
+		err = Leave(ctx, c.s, occupant, status)
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.occupant = jid.JID{}
+	c.mu.Unlock()
+	return nil
+}
+
+// Send sends a groupchat message with the provided payload to the room.
+func (c *Channel) Send(ctx context.Context, payload xml.TokenReader) error {
+	return c.s.Send(ctx, stanza.Message{
+		To:   c.room,
+		Type: stanza.GroupChatMessage,
+	}.Wrap(payload))
+}
+
+// Members returns the occupants of the room.
+// Rooms may choose not to list their occupants, in which case the list will
+// be empty.
+// The real JIDs of the occupants are never known.
+func (c *Channel) Members(ctx context.Context) ([]groupchat.Member, error) {
+	iter := disco.FetchItemsIQ(ctx, "", stanza.IQ{To: c.room}, c.s)
+	var members []groupchat.Member
+	for iter.Next() {
+		item := iter.Item()
+		members = append(members, groupchat.Member{
+			Addr: item.JID,
+			Nick: item.JID.Resourcepart(),
+		})
+	}
+	err := iter.Err()
+	if e := iter.Close(); err == nil {
+		err = e
+	}
+	return members, err
+}
+
+// Subject returns the subject of the room as advertised in its service
+// discovery information.
+// If the room does not advertise a subject, an empty string is returned.
+func (c *Channel) Subject(ctx context.Context) (string, error) {
+	info, err := disco.GetInfo(ctx, "", c.room, c.s)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range info.Form {
+		if subject, ok := f.GetString("muc#roominfo_subject"); ok {
+			return subject, nil
+		}
+	}
+	return "", nil
+}
+
+// SetSubject changes the subject of the room.
+// Depending on the room configuration, only moderators may be allowed to
+// change the subject.
+func (c *Channel) SetSubject(ctx context.Context, subject string) error {
+	return c.Send(ctx, xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(subject)),
+		xml.StartElement{Name: xml.Name{Local: "subject"}},
+	))
+}
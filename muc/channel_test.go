@@ -0,0 +1,127 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/groupchat"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var _ groupchat.Opener = muc.Open
+
+func TestChannel(t *testing.T) {
+	stanzas := make(chan string, 4)
+	m := mux.New(
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{Space: muc.NS, Local: "x"}, func(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+			stanzas <- "join " + p.To.String()
+			_, err := xmlstream.Copy(t, selfPresence(p))
+			return err
+		}),
+		mux.PresenceFunc(stanza.UnavailablePresence, xml.Name{Local: "status"}, func(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+			stanzas <- "leave " + p.To.String()
+			return nil
+		}),
+		mux.MessageFunc(stanza.GroupChatMessage, xml.Name{Local: "subject"}, func(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+			var m struct {
+				Subject string `xml:"subject"`
+			}
+			err := xml.NewTokenDecoder(t).Decode(&m)
+			if err != nil {
+				return err
+			}
+			stanzas <- "subject " + msg.To.String() + " " + m.Subject
+			return nil
+		}),
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			d := xml.NewDecoder(strings.NewReader(`<query xmlns="http://jabber.org/protocol/disco#info"><feature var="http://jabber.org/protocol/muc"/><x xmlns="jabber:x:data" type="result"><field var="FORM_TYPE" type="hidden"><value>http://jabber.org/protocol/muc#roominfo</value></field><field var="muc#roominfo_subject" type="text-single"><value>Spells</value></field></x></query>`))
+			_, err := xmlstream.Copy(t, iq.Result(d))
+			return err
+		}),
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSItems, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			d := xml.NewDecoder(strings.NewReader(`<query xmlns="http://jabber.org/protocol/disco#items"><item jid="coven@chat.shakespeare.lit/firstwitch"/><item jid="coven@chat.shakespeare.lit/secondwitch"/></query>`))
+			_, err := xmlstream.Copy(t, iq.Result(d))
+			return err
+		}),
+	)
+	rooms := &muc.Rooms{Timeout: 5 * time.Second}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(m),
+		xmpptest.ClientHandler(mux.New(rooms.Handle(muc.Handler{}))),
+	)
+	defer cs.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ch, err := groupchat.Open(ctx, cs.Client, jid.MustParse("coven@chat.shakespeare.lit"), nil, map[string]groupchat.Opener{
+		muc.NS: rooms.Open,
+	})
+	if err != nil {
+		t.Fatalf("error opening channel: %v", err)
+	}
+	if _, ok := ch.(*muc.Channel); !ok {
+		t.Fatalf("wrong channel type: %T", ch)
+	}
+	err = ch.Leave(ctx, "")
+	if err != muc.ErrNotJoined {
+		t.Errorf("wrong error leaving before joining: want=%v, got=%v", muc.ErrNotJoined, err)
+	}
+
+	err = ch.Join(ctx, "thirdwitch")
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	if joined := rooms.Joined(); len(joined) != 1 || joined[0].String() != "coven@chat.shakespeare.lit/thirdwitch" {
+		t.Errorf("expected room to be tracked, got %v", joined)
+	}
+	err = ch.SetSubject(ctx, "Toil and trouble")
+	if err != nil {
+		t.Fatalf("error setting subject: %v", err)
+	}
+	err = ch.Leave(ctx, "Fair is foul")
+	if err != nil {
+		t.Fatalf("error leaving: %v", err)
+	}
+	for _, want := range []string{
+		"join coven@chat.shakespeare.lit/thirdwitch",
+		"subject coven@chat.shakespeare.lit Toil and trouble",
+		"leave coven@chat.shakespeare.lit/thirdwitch",
+	} {
+		select {
+		case got := <-stanzas:
+			if got != want {
+				t.Errorf("wrong stanza: want=%q, got=%q", want, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	subject, err := ch.Subject(ctx)
+	if err != nil {
+		t.Fatalf("error fetching subject: %v", err)
+	}
+	if subject != "Spells" {
+		t.Errorf("wrong subject: want=Spells, got=%q", subject)
+	}
+	members, err := ch.Members(ctx)
+	if err != nil {
+		t.Fatalf("error fetching members: %v", err)
+	}
+	if len(members) != 2 || members[0].Nick != "firstwitch" || members[1].Addr.String() != "coven@chat.shakespeare.lit/secondwitch" {
+		t.Errorf("wrong members: %+v", members)
+	}
+}
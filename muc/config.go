@@ -0,0 +1,56 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// FetchConfig returns the configuration form of a room.
+// Only owners of the room may fetch its configuration.
+func FetchConfig(ctx context.Context, s *xmpp.Session, room jid.JID) (*form.Data, error) {
+	resp := struct {
+		XMLName xml.Name  `xml:"http://jabber.org/protocol/muc#owner query"`
+		Form    form.Data `xml:"jabber:x:data x"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, ownerQuery(nil), stanza.IQ{
+		To:   room.Bare(),
+		Type: stanza.GetIQ,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Form, nil
+}
+
+// SetConfig updates the configuration of a room.
+// Normally cfg will be the result of FetchConfig after any values that need to
+// be changed have been set.
+// If cfg is nil, an empty form is submitted which accepts the default
+// configuration (this is how "instant rooms" are created after joining a room
+// that did not exist).
+// Only owners of the room may change its configuration.
+func SetConfig(ctx context.Context, s *xmpp.Session, room jid.JID, cfg *form.Data) error {
+	submission, ok := cfg.Submit()
+	if !ok {
+		return fmt.Errorf("muc: cannot submit room configuration: %w", form.ErrRequired)
+	}
+	return s.UnmarshalIQElement(ctx, ownerQuery(submission), stanza.IQ{
+		To:   room.Bare(),
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+func ownerQuery(payload xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(payload, xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "query"}})
+}
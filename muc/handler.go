@@ -0,0 +1,186 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Item contains information about an occupant's affiliation and role.
+type Item struct {
+	XMLName     xml.Name    `xml:"http://jabber.org/protocol/muc#user item"`
+	Affiliation Affiliation `xml:"affiliation,attr,omitempty"`
+	Role        Role        `xml:"role,attr,omitempty"`
+
+	// JID is the real address of the occupant, which is only included if the
+	// room is non-anonymous or we are a moderator.
+	JID jid.JID `xml:"jid,attr,omitempty"`
+
+	// Nick is set to the new nickname of the occupant when the StatusNickChanged
+	// status code is present.
+	Nick   string `xml:"nick,attr,omitempty"`
+	Reason string `xml:"reason,omitempty"`
+}
+
+// Presence is presence sent by a room on behalf of an occupant.
+type Presence struct {
+	stanza.Presence
+
+	Item   Item
+	Status []int
+}
+
+// HasStatus returns true if the presence contains the status code.
+func (p Presence) HasStatus(code int) bool {
+	for _, c := range p.Status {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (p *Presence) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		stanza.Presence
+		X struct {
+			Item   Item `xml:"item"`
+			Status []struct {
+				Code int `xml:"code,attr"`
+			} `xml:"status"`
+		} `xml:"http://jabber.org/protocol/muc#user x"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	p.Presence = s.Presence
+	p.Item = s.X.Item
+	p.Status = p.Status[:0]
+	for _, status := range s.X.Status {
+		p.Status = append(p.Status, status.Code)
+	}
+	return nil
+}
+
+// Invitation is a mediated invitation to join a room.
+type Invitation struct {
+	// Room is the room that we were invited to and From is the user that invited
+	// us.
+	Room jid.JID
+	From jid.JID
+
+	Reason   string
+	Password string
+}
+
+// Handle returns an option that registers a Handler for occupant presence and
+// mediated invitations.
+// If h.Error is set, presence errors are also handled.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		x := xml.Name{Space: NSUser, Local: "x"}
+		for _, typ := range []stanza.PresenceType{stanza.AvailablePresence, stanza.UnavailablePresence} {
+			mux.Presence(typ, x, h)(m)
+		}
+		if h.Error != nil {
+			mux.Presence(stanza.ErrorPresence, xml.Name{Local: "error"}, h)(m)
+		}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage} {
+			mux.Message(typ, x, h)(m)
+		}
+	}
+}
+
+// Handler handles occupant presence and invitations.
+// Any nil functions are ignored.
+type Handler struct {
+	Presence func(Presence) error
+	Invite   func(Invitation) error
+
+	// Error is called with the address and error of any presence of type error,
+	// for example the error sent by a room that could not be joined.
+	// Presence errors do not contain anything that identifies them as coming
+	// from a room so Error may also be called for errors sent by other
+	// entities.
+	Error func(from jid.JID, err stanza.Error) error
+
+	// Bus, if set, has every Presence and Invitation published to it before the
+	// corresponding function is called.
+	Bus *xmpp.Bus
+}
+
+// HandlePresence implements mux.PresenceHandler.
+func (h Handler) HandlePresence(presence stanza.Presence, t xmlstream.TokenReadEncoder) error {
+	if presence.Type == stanza.ErrorPresence {
+		if h.Error == nil {
+			return nil
+		}
+		e := struct {
+			stanza.Presence
+			Err stanza.Error `xml:"error"`
+		}{}
+		err := xml.NewTokenDecoder(t).Decode(&e)
+		if err != nil {
+			return err
+		}
+		return h.Error(presence.From, e.Err)
+	}
+	if h.Presence == nil && h.Bus == nil {
+		return nil
+	}
+	var p Presence
+	err := xml.NewTokenDecoder(t).Decode(&p)
+	if err != nil {
+		return err
+	}
+	h.Bus.Publish(p)
+	if h.Presence == nil {
+		return nil
+	}
+	return h.Presence(p)
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (h Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	if h.Invite == nil && h.Bus == nil {
+		return nil
+	}
+	m := struct {
+		stanza.Message
+		X struct {
+			Invite *struct {
+				From   jid.JID `xml:"from,attr"`
+				Reason string  `xml:"reason"`
+			} `xml:"invite"`
+			Password string `xml:"password"`
+		} `xml:"http://jabber.org/protocol/muc#user x"`
+	}{}
+	err := xml.NewTokenDecoder(t).Decode(&m)
+	if err != nil {
+		return err
+	}
+	if m.X.Invite == nil {
+		return nil
+	}
+	invite := Invitation{
+		Room:     msg.From.Bare(),
+		From:     m.X.Invite.From,
+		Reason:   m.X.Invite.Reason,
+		Password: m.X.Password,
+	}
+	h.Bus.Publish(invite)
+	if h.Invite == nil {
+		return nil
+	}
+	return h.Invite(invite)
+}
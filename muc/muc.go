@@ -0,0 +1,201 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package muc implements Multi-User Chat.
+//
+// Multi-user chat rooms are joined by sending presence to the address of the
+// room with the desired nickname as the resourcepart (the "occupant JID").
+// Once joined the room sends presence for every occupant, including the user
+// that joined, which can be handled by registering a Handler on the mux.
+// Messages sent to the room are then reflected to all occupants using the
+// groupchat message type.
+//
+//     room := jid.MustParse("coven@chat.shakespeare.lit/thirdwitch")
+//     err := muc.Join(ctx, s, room, muc.JoinOptions{
+//         History: &muc.History{MaxStanzas: 20},
+//     })
+package muc // import "mellium.im/xmpp/muc"
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS      = `http://jabber.org/protocol/muc`
+	NSUser  = `http://jabber.org/protocol/muc#user`
+	NSOwner = `http://jabber.org/protocol/muc#owner`
+	NSAdmin = `http://jabber.org/protocol/muc#admin`
+)
+
+// Affiliation is a long lived association between a user and a room.
+type Affiliation string
+
+// A list of affiliations.
+const (
+	AffiliationOwner   Affiliation = "owner"
+	AffiliationAdmin   Affiliation = "admin"
+	AffiliationMember  Affiliation = "member"
+	AffiliationOutcast Affiliation = "outcast"
+	AffiliationNone    Affiliation = "none"
+)
+
+// Role is a temporary association between an occupant and a room that only
+// lasts as long as the occupant is in the room.
+type Role string
+
+// A list of roles.
+const (
+	RoleModerator   Role = "moderator"
+	RoleParticipant Role = "participant"
+	RoleVisitor     Role = "visitor"
+	RoleNone        Role = "none"
+)
+
+// Some common status codes that may be included in occupant presence.
+// For a full list see the XMPP Registrar.
+const (
+	StatusNonAnonymous = 100
+	StatusSelf         = 110
+	StatusLogged       = 170
+	StatusCreated      = 201
+	StatusNickAssigned = 210
+	StatusBanned       = 301
+	StatusNickChanged  = 303
+	StatusKicked       = 307
+	StatusShutdown     = 332
+)
+
+// History limits the amount of discussion history that is sent by the room
+// when it is joined.
+// Fields with a zero value are not sent.
+type History struct {
+	MaxChars   int
+	MaxStanzas int
+	Seconds    int
+	Since      time.Time
+
+	// NoHistory requests that no history be sent at all and overrides all other
+	// fields.
+	NoHistory bool
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (h History) TokenReader() xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Local: "history"}}
+	if h.NoHistory {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "maxstanzas"}, Value: "0"})
+		return xmlstream.Wrap(nil, start)
+	}
+	if h.MaxChars > 0 {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "maxchars"}, Value: strconv.Itoa(h.MaxChars)})
+	}
+	if h.MaxStanzas > 0 {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "maxstanzas"}, Value: strconv.Itoa(h.MaxStanzas)})
+	}
+	if h.Seconds > 0 {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "seconds"}, Value: strconv.Itoa(h.Seconds)})
+	}
+	if !h.Since.IsZero() {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "since"}, Value: h.Since.UTC().Format(time.RFC3339)})
+	}
+	return xmlstream.Wrap(nil, start)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (h History) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, h.TokenReader())
+}
+
+// JoinOptions are optional parameters used when joining a room.
+type JoinOptions struct {
+	// Password is sent to password protected rooms.
+	Password string
+
+	// History limits the discussion history sent when joining the room.
+	// If it is nil the room's default is used.
+	History *History
+}
+
+// TokenReader returns the MUC payload of the join presence.
+func (o JoinOptions) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	if o.Password != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(o.Password)),
+			xml.StartElement{Name: xml.Name{Local: "password"}},
+		))
+	}
+	if o.History != nil {
+		inner = append(inner, o.History.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "x"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (o JoinOptions) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, o.TokenReader())
+}
+
+// Join requests to enter a room.
+// The occupant JID is the address of the room with the nickname to use in the
+// room as the resourcepart.
+//
+// Join does not wait for the room to respond.
+// Once the user has joined, the room will send the occupant presence of every
+// occupant followed by the user's own presence which will contain the
+// StatusSelf status code.
+// If the user cannot join, the room responds with a presence of type error
+// which is passed to the Error function of a registered Handler.
+// To wait for the room to respond and receive the error as the return value,
+// use the Join method of Rooms instead.
+func Join(ctx context.Context, s *xmpp.Session, occupant jid.JID, opts JoinOptions) error {
+	return s.Send(ctx, stanza.Presence{To: occupant}.Wrap(opts.TokenReader()))
+}
+
+// Leave exits a room.
+// The status is optional and may be shown to other occupants.
+func Leave(ctx context.Context, s *xmpp.Session, occupant jid.JID, status string) error {
+	var inner xml.TokenReader
+	if status != "" {
+		inner = xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(status)),
+			xml.StartElement{Name: xml.Name{Local: "status"}},
+		)
+	}
+	return s.Send(ctx, stanza.Presence{
+		To:   occupant,
+		Type: stanza.UnavailablePresence,
+	}.Wrap(inner))
+}
+
+// Invite sends a mediated invitation to join a room.
+// The invitation is sent to the room, which forwards it to the invitee.
+func Invite(ctx context.Context, s *xmpp.Session, room, to jid.JID, reason string) error {
+	var inner xml.TokenReader
+	if reason != "" {
+		inner = xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(reason)),
+			xml.StartElement{Name: xml.Name{Local: "reason"}},
+		)
+	}
+	return s.Send(ctx, stanza.Message{To: room.Bare(), Type: stanza.NormalMessage}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(inner, xml.StartElement{
+			Name: xml.Name{Local: "invite"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "to"}, Value: to.String()}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}},
+	)))
+}
@@ -0,0 +1,248 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestJoin(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := xmpptest.NewSession(0, buf)
+	occupant := jid.MustParse("coven@chat.shakespeare.lit/thirdwitch")
+	err := muc.Join(context.Background(), s, occupant, muc.JoinOptions{
+		Password: "cauldronburn",
+		History:  &muc.History{MaxStanzas: 20, Since: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatalf("error joining room: %v", err)
+	}
+
+	var p struct {
+		stanza.Presence
+		X struct {
+			Password string `xml:"password"`
+			History  struct {
+				MaxStanzas string  `xml:"maxstanzas,attr"`
+				MaxChars   *string `xml:"maxchars,attr"`
+				Since      string  `xml:"since,attr"`
+			} `xml:"history"`
+		} `xml:"http://jabber.org/protocol/muc x"`
+	}
+	err = xml.NewDecoder(buf).Decode(&p)
+	if err != nil {
+		t.Fatalf("error decoding join presence: %v", err)
+	}
+	if !p.To.Equal(occupant) || p.Type != stanza.AvailablePresence {
+		t.Errorf("wrong presence: want=%v (available), got=%v (%q)", occupant, p.To, p.Type)
+	}
+	if p.X.Password != "cauldronburn" {
+		t.Errorf("wrong password: want=cauldronburn, got=%q", p.X.Password)
+	}
+	if h := p.X.History; h.MaxStanzas != "20" || h.MaxChars != nil || h.Since != "1970-01-01T00:00:00Z" {
+		t.Errorf("wrong history: %+v", h)
+	}
+}
+
+func TestNoHistory(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e := xml.NewEncoder(buf)
+	_, err := muc.History{NoHistory: true, MaxChars: 100}.WriteXML(e)
+	if err != nil {
+		t.Fatalf("error encoding history: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<history maxstanzas="0"></history>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output: want=%s, got=%s", want, out)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	presences := make(chan muc.Presence, 1)
+	invites := make(chan muc.Invitation, 1)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(muc.Handle(muc.Handler{
+			Presence: func(p muc.Presence) error {
+				presences <- p
+				return nil
+			},
+			Invite: func(inv muc.Invitation) error {
+				invites <- inv
+				return nil
+			},
+		}))),
+	)
+	defer cs.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, raw := range []string{
+		`<presence xmlns="jabber:client" from="coven@chat.shakespeare.lit/thirdwitch" to="hag66@shakespeare.lit/pda"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" role="participant" jid="hag66@shakespeare.lit/pda"/><status code="110"/><status code="210"/></x></presence>`,
+		`<message xmlns="jabber:client" from="coven@chat.shakespeare.lit" to="hecate@shakespeare.lit"><x xmlns="http://jabber.org/protocol/muc#user"><invite from="crone1@shakespeare.lit/desktop"><reason>Hey Hecate, this is the place for all good witches!</reason></invite><password>cauldronburn</password></x></message>`,
+	} {
+		err := cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("error sending %s: %v", raw, err)
+		}
+	}
+
+	select {
+	case p := <-presences:
+		if p.From.String() != "coven@chat.shakespeare.lit/thirdwitch" {
+			t.Errorf("wrong from: %v", p.From)
+		}
+		if p.Item.Affiliation != muc.AffiliationMember || p.Item.Role != muc.RoleParticipant || p.Item.JID.String() != "hag66@shakespeare.lit/pda" {
+			t.Errorf("wrong item: %+v", p.Item)
+		}
+		if !p.HasStatus(muc.StatusSelf) || !p.HasStatus(muc.StatusNickAssigned) || p.HasStatus(muc.StatusKicked) {
+			t.Errorf("wrong status codes: %v", p.Status)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for presence")
+	}
+	select {
+	case inv := <-invites:
+		if inv.Room.String() != "coven@chat.shakespeare.lit" || inv.From.String() != "crone1@shakespeare.lit/desktop" || inv.Password != "cauldronburn" || inv.Reason == "" {
+			t.Errorf("wrong invitation: %+v", inv)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for invitation")
+	}
+}
+
+func TestHandlerBus(t *testing.T) {
+	events := make(chan interface{}, 2)
+	bus := &xmpp.Bus{}
+	bus.Subscribe(func(p muc.Presence) { events <- p })
+	bus.Subscribe(func(inv muc.Invitation) { events <- inv })
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(muc.Handle(muc.Handler{Bus: bus}))),
+	)
+	defer cs.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, raw := range []string{
+		`<presence xmlns="jabber:client" from="coven@chat.shakespeare.lit/thirdwitch"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="member" role="participant"/></x></presence>`,
+		`<message xmlns="jabber:client" from="coven@chat.shakespeare.lit"><x xmlns="http://jabber.org/protocol/muc#user"><invite from="crone1@shakespeare.lit/desktop"/></x></message>`,
+	} {
+		err := cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("error sending %s: %v", raw, err)
+		}
+	}
+
+	for _, want := range []string{"muc.Presence", "muc.Invitation"} {
+		select {
+		case e := <-events:
+			if got := fmt.Sprintf("%T", e); got != want {
+				t.Errorf("wrong event type: want=%s, got=%s", want, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+}
+
+func TestInvite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := xmpptest.NewSession(0, buf)
+	err := muc.Invite(context.Background(), s, jid.MustParse("coven@chat.shakespeare.lit/thirdwitch"), jid.MustParse("hecate@shakespeare.lit"), "join us")
+	if err != nil {
+		t.Fatalf("error sending invite: %v", err)
+	}
+	var msg struct {
+		stanza.Message
+		Invite struct {
+			To     string `xml:"to,attr"`
+			Reason string `xml:"reason"`
+		} `xml:"http://jabber.org/protocol/muc#user x>invite"`
+	}
+	err = xml.NewDecoder(buf).Decode(&msg)
+	if err != nil {
+		t.Fatalf("error decoding invite: %v", err)
+	}
+	if msg.To.String() != "coven@chat.shakespeare.lit" || msg.Invite.To != "hecate@shakespeare.lit" || msg.Invite.Reason != "join us" {
+		t.Errorf("wrong invite: %+v", msg)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	var submitted form.Data
+	m := mux.New(
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: muc.NSOwner, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			d := xml.NewDecoder(strings.NewReader(`<query xmlns="http://jabber.org/protocol/muc#owner"><x xmlns="jabber:x:data" type="form"><field var="FORM_TYPE" type="hidden"><value>http://jabber.org/protocol/muc#roomconfig</value></field><field var="muc#roomconfig_roomname" type="text-single"/></x></query>`))
+			_, err := xmlstream.Copy(t, iq.Result(d))
+			return err
+		}),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: muc.NSOwner, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var q struct {
+				Form form.Data `xml:"jabber:x:data x"`
+			}
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&q)
+			if err != nil {
+				return err
+			}
+			submitted = q.Form
+			_, err = xmlstream.Copy(t, iq.Result(nil))
+			return err
+		}),
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+	room := jid.MustParse("coven@chat.shakespeare.lit")
+
+	cfg, err := muc.FetchConfig(context.Background(), cs.Client, room)
+	if err != nil {
+		t.Fatalf("error fetching config: %v", err)
+	}
+	_, err = cfg.Set("muc#roomconfig_roomname", "A Dark Cave")
+	if err != nil {
+		t.Fatalf("error setting room name: %v", err)
+	}
+	err = muc.SetConfig(context.Background(), cs.Client, room, cfg)
+	if err != nil {
+		t.Fatalf("error setting config: %v", err)
+	}
+	if name, _ := submitted.GetString("muc#roomconfig_roomname"); name != "A Dark Cave" {
+		t.Errorf("wrong room name submitted: want=%q, got=%q", "A Dark Cave", name)
+	}
+}
+
+func TestSetConfigRequired(t *testing.T) {
+	var cfg form.Data
+	err := xml.NewDecoder(strings.NewReader(`<x xmlns="jabber:x:data" type="form"><field var="muc#roomconfig_roomname" type="text-single"><required/></field></x>`)).Decode(&cfg)
+	if err != nil {
+		t.Fatalf("error decoding form: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	s := xmpptest.NewSession(0, buf)
+	err = muc.SetConfig(context.Background(), s, jid.MustParse("coven@chat.shakespeare.lit"), &cfg)
+	if !errors.Is(err, form.ErrRequired) {
+		t.Errorf("wrong error: want=%v, got=%v", form.ErrRequired, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("did not expect incomplete form to be sent, got %s", buf)
+	}
+}
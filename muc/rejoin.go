@@ -0,0 +1,368 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/stanza"
+)
+
+// ErrJoinTimeout is reported when a room does not respond to a join request
+// before the timeout is reached.
+var ErrJoinTimeout = errors.New("muc: timed out waiting to join room")
+
+const defaultJoinTimeout = 30 * time.Second
+
+// defaultBackoff waits one second after the first failed attempt and doubles
+// the delay after each subsequent failure up to a maximum of one minute.
+func defaultBackoff(failures int) time.Duration {
+	const maxDelay = time.Minute
+	if failures < 1 {
+		return 0
+	}
+	if failures > 7 {
+		return maxDelay
+	}
+	return time.Second << uint(failures-1)
+}
+
+// RejoinEvent is the outcome of an attempt to rejoin a room.
+type RejoinEvent struct {
+	Occupant jid.JID
+
+	// Attempt counts the attempts to rejoin the room starting at 1.
+	Attempt int
+
+	// Err is nil if the room was rejoined.
+	Err error
+
+	// Retry is true if another attempt will be made after a delay.
+	Retry bool
+}
+
+type room struct {
+	occupant jid.JID
+	opts     JoinOptions
+	seen     time.Time
+	joined   chan error
+}
+
+// Rooms keeps track of the rooms that the user has joined so that they can be
+// rejoined after reconnecting or after being removed from the room without
+// being notified (for example, because the room was restarted).
+// Rooms are rejoined with a history request for the discussion since the last
+// presence was received from the room.
+//
+// To keep track of rooms, join and leave them with the methods on Rooms
+// instead of the package level functions and register the option returned by
+// Handle instead of the package level Handle.
+//
+// The exported fields must not be modified after rooms have been joined.
+// A Rooms is safe for concurrent use.
+type Rooms struct {
+	// Backoff returns the delay before trying to rejoin a room again after the
+	// given number of consecutive failed attempts.
+	// If Backoff is nil, the delay starts at one second and doubles after each
+	// failure up to a maximum of one minute.
+	Backoff func(failures int) time.Duration
+
+	// MaxAttempts is the number of times to attempt to rejoin a room before
+	// giving up.
+	// If it is zero, attempts are made until the context is canceled.
+	MaxAttempts int
+
+	// Timeout is how long to wait for a room to respond to each attempt.
+	// If it is zero, a timeout of 30 seconds is used.
+	Timeout time.Duration
+
+	// OnRejoin, if set, is called after each attempt to rejoin a room.
+	OnRejoin func(RejoinEvent)
+
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+// Join is like the package level Join function except that the room is
+// tracked so that it can be rejoined later.
+//
+// Unlike the package level Join, it waits for the room to respond.
+// If the room responds with an error, the error is returned as a stanza.Error
+// and the room is not tracked.
+// If the room does not respond before the timeout, ErrJoinTimeout is returned.
+// For the response to be seen, the option returned by r.Handle must be
+// registered on the session's mux.
+func (r *Rooms) Join(ctx context.Context, s *xmpp.Session, occupant jid.JID, opts JoinOptions) error {
+	r.mu.Lock()
+	if r.rooms == nil {
+		r.rooms = make(map[string]*room)
+	}
+	rm := &room{occupant: occupant, opts: opts}
+	r.rooms[occupant.Bare().String()] = rm
+	r.mu.Unlock()
+	err := r.join(ctx, s, rm, occupant, opts)
+	if err != nil {
+		r.mu.Lock()
+		if r.rooms[occupant.Bare().String()] == rm {
+			delete(r.rooms, occupant.Bare().String())
+		}
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// join sends a join request for a tracked room and waits for the room to
+// respond.
+func (r *Rooms) join(ctx context.Context, s *xmpp.Session, rm *room, occupant jid.JID, opts JoinOptions) error {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultJoinTimeout
+	}
+	result := make(chan error, 1)
+	r.mu.Lock()
+	rm.joined = result
+	r.mu.Unlock()
+
+	err := Join(ctx, s, occupant, opts)
+	if err == nil {
+		timer := time.NewTimer(timeout)
+		select {
+		case err = <-result:
+		case <-timer.C:
+			err = ErrJoinTimeout
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		timer.Stop()
+	}
+
+	r.mu.Lock()
+	if rm.joined == result {
+		rm.joined = nil
+	}
+	r.mu.Unlock()
+	return err
+}
+
+// Leave is like the package level Leave function except that the room is no
+// longer tracked.
+func (r *Rooms) Leave(ctx context.Context, s *xmpp.Session, occupant jid.JID, status string) error {
+	r.forget(occupant)
+	return Leave(ctx, s, occupant, status)
+}
+
+func (r *Rooms) forget(occupant jid.JID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.rooms, occupant.Bare().String())
+}
+
+// Joined returns the occupant JIDs of all tracked rooms.
+func (r *Rooms) Joined() []jid.JID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	occupants := make([]jid.JID, 0, len(r.rooms))
+	for _, rm := range r.rooms {
+		occupants = append(occupants, rm.occupant)
+	}
+	sort.Slice(occupants, func(i, j int) bool {
+		return occupants[i].String() < occupants[j].String()
+	})
+	return occupants
+}
+
+// Handle returns an option that registers h like the package level Handle
+// function but that also lets r see the presence and presence errors sent by
+// tracked rooms.
+func (r *Rooms) Handle(h Handler) mux.Option {
+	next := h.Presence
+	h.Presence = func(p Presence) error {
+		r.presence(p)
+		if next == nil {
+			return nil
+		}
+		return next(p)
+	}
+	nextErr := h.Error
+	h.Error = func(from jid.JID, err stanza.Error) error {
+		r.presenceError(from, err)
+		if nextErr == nil {
+			return nil
+		}
+		return nextErr(from, err)
+	}
+	return Handle(h)
+}
+
+func (r *Rooms) presence(p Presence) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := p.From.Bare().String()
+	rm, ok := r.rooms[key]
+	if !ok {
+		return
+	}
+	rm.seen = time.Now()
+	if !p.HasStatus(StatusSelf) {
+		return
+	}
+	switch p.Type {
+	case stanza.AvailablePresence:
+		// The room may have assigned us a different nickname.
+		rm.occupant = p.From
+		if rm.joined != nil {
+			rm.joined <- nil
+			rm.joined = nil
+		}
+	case stanza.UnavailablePresence:
+		// If we were removed from the room on purpose (kicked, banned, etc.)
+		// rejoining would not help.
+		// If the room is shutting down we will try again after reconnecting.
+		if !p.HasStatus(StatusShutdown) && !p.HasStatus(StatusNickChanged) {
+			delete(r.rooms, key)
+		}
+	}
+}
+
+func (r *Rooms) presenceError(from jid.JID, err stanza.Error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rm, ok := r.rooms[from.Bare().String()]
+	if ok && rm.joined != nil {
+		rm.joined <- err
+		rm.joined = nil
+	}
+}
+
+// Rejoin joins all tracked rooms again.
+// It should be called after a new session has been established, for example
+// from the OnConnect function of a client that reconnects automatically.
+//
+// Rejoin waits until every room has been joined, all attempts to join a room
+// have failed, or ctx is canceled.
+// The outcome of each attempt is reported to OnRejoin.
+func (r *Rooms) Rejoin(ctx context.Context, s *xmpp.Session) {
+	var wg sync.WaitGroup
+	for _, occupant := range r.Joined() {
+		wg.Add(1)
+		go func(occupant jid.JID) {
+			defer wg.Done()
+			r.rejoin(ctx, s, occupant)
+		}(occupant)
+	}
+	wg.Wait()
+}
+
+// Check sends a self-ping to every tracked room to find out whether the user
+// is still an occupant and rejoins any rooms that the user has been removed
+// from.
+// It is meant to be called periodically, for example after some time has
+// passed without receiving any traffic from a room.
+//
+// Like Rejoin, Check waits for all rooms to be rejoined.
+func (r *Rooms) Check(ctx context.Context, s *xmpp.Session) {
+	var wg sync.WaitGroup
+	for _, occupant := range r.Joined() {
+		wg.Add(1)
+		go func(occupant jid.JID) {
+			defer wg.Done()
+			if !joined(ping.Send(ctx, s, occupant)) {
+				r.rejoin(ctx, s, occupant)
+			}
+		}(occupant)
+	}
+	wg.Wait()
+}
+
+// joined reports whether the result of a self-ping indicates that the user may
+// still be in the room.
+// If the room could not be reached we don't know, but rejoining would not
+// help either.
+func joined(err error) bool {
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) {
+		return true
+	}
+	switch stanzaErr.Condition {
+	case stanza.FeatureNotImplemented, stanza.RemoteServerNotFound, stanza.RemoteServerTimeout:
+		return true
+	}
+	return false
+}
+
+func (r *Rooms) rejoin(ctx context.Context, s *xmpp.Session, occupant jid.JID) {
+	key := occupant.Bare().String()
+	backoff := r.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		r.mu.Lock()
+		rm, ok := r.rooms[key]
+		if !ok {
+			r.mu.Unlock()
+			return
+		}
+		occupant = rm.occupant
+		opts := rm.opts
+		if !rm.seen.IsZero() && (opts.History == nil || !opts.History.NoHistory) {
+			var history History
+			if opts.History != nil {
+				history = *opts.History
+			}
+			history.Since = rm.seen
+			opts.History = &history
+		}
+		r.mu.Unlock()
+
+		err := r.join(ctx, s, rm, occupant, opts)
+
+		retry := err != nil && ctx.Err() == nil && temporary(err) &&
+			(r.MaxAttempts == 0 || attempt < r.MaxAttempts)
+		if err != nil && !temporary(err) {
+			r.forget(occupant)
+		}
+		if r.OnRejoin != nil {
+			r.OnRejoin(RejoinEvent{
+				Occupant: occupant,
+				Attempt:  attempt,
+				Err:      err,
+				Retry:    retry,
+			})
+		}
+		if !retry {
+			return
+		}
+
+		timer := time.NewTimer(backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// temporary reports whether an attempt to join a room that failed with err is
+// worth retrying.
+// Stanza errors are only temporary if the room says so, anything else (for
+// example, a timeout) may be.
+func temporary(err error) bool {
+	var stanzaErr stanza.Error
+	if errors.As(err, &stanzaErr) {
+		return stanzaErr.Type == stanza.Wait
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/stanza"
+)
+
+func selfPresence(p stanza.Presence) xml.TokenReader {
+	return stanza.Presence{From: p.To}.Wrap(xmlstream.Wrap(
+		xmlstream.MultiReader(
+			xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: []xml.Attr{
+					{Name: xml.Name{Local: "affiliation"}, Value: "member"},
+					{Name: xml.Name{Local: "role"}, Value: "participant"},
+				},
+			}),
+			xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "status"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "code"}, Value: "110"}},
+			}),
+		),
+		xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+	))
+}
+
+func TestRejoin(t *testing.T) {
+	occupant := jid.MustParse("coven@chat.shakespeare.lit/thirdwitch")
+
+	var mu sync.Mutex
+	var since []string
+	m := mux.New(
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{Space: muc.NS, Local: "x"}, func(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+			join := struct {
+				stanza.Presence
+				History struct {
+					Since string `xml:"since,attr"`
+				} `xml:"http://jabber.org/protocol/muc x>history"`
+			}{}
+			err := xml.NewTokenDecoder(t).Decode(&join)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			since = append(since, join.History.Since)
+			attempt := len(since)
+			mu.Unlock()
+			// Fail the first attempt to rejoin with a temporary error.
+			if attempt == 2 {
+				_, err = xmlstream.Copy(t, stanza.Presence{From: p.To, Type: stanza.ErrorPresence}.Wrap(stanza.Error{
+					Type:      stanza.Wait,
+					Condition: stanza.ResourceConstraint,
+				}.TokenReader()))
+				return err
+			}
+			_, err = xmlstream.Copy(t, selfPresence(p))
+			return err
+		}),
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: ping.NS, Local: "ping"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+				Type:      stanza.Cancel,
+				Condition: stanza.NotAcceptable,
+			}))
+			return err
+		}),
+	)
+
+	joined := make(chan muc.Presence, 1)
+	var events []muc.RejoinEvent
+	rooms := &muc.Rooms{
+		Backoff: func(int) time.Duration { return time.Millisecond },
+		Timeout: 5 * time.Second,
+		OnRejoin: func(e muc.RejoinEvent) {
+			events = append(events, e)
+		},
+	}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(m),
+		xmpptest.ClientHandler(mux.New(rooms.Handle(muc.Handler{
+			Presence: func(p muc.Presence) error {
+				select {
+				case joined <- p:
+				default:
+				}
+				return nil
+			},
+		}))),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := rooms.Join(ctx, cs.Client, occupant, muc.JoinOptions{})
+	if err != nil {
+		t.Fatalf("error joining room: %v", err)
+	}
+	select {
+	case <-joined:
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting to join room")
+	}
+
+	rooms.Rejoin(ctx, cs.Client)
+	if len(events) != 2 {
+		t.Fatalf("wrong number of rejoin events: want=2, got=%d: %+v", len(events), events)
+	}
+	if e := events[0]; e.Attempt != 1 || e.Err == nil || !e.Retry {
+		t.Errorf("expected first attempt to fail and be retried, got %+v", e)
+	}
+	if e := events[1]; e.Attempt != 2 || e.Err != nil || !e.Occupant.Equal(occupant) {
+		t.Errorf("expected second attempt to succeed, got %+v", e)
+	}
+
+	rooms.Check(ctx, cs.Client)
+	if len(events) != 3 || events[2].Err != nil {
+		t.Errorf("expected failed self-ping to rejoin the room, got %+v", events)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if since[0] != "" {
+		t.Errorf("did not expect history to be limited on first join, got since=%q", since[0])
+	}
+	for i, s := range since[1:] {
+		if s == "" {
+			t.Errorf("expected rejoin %d to request history since the last presence", i+1)
+		}
+	}
+	if joined := rooms.Joined(); len(joined) != 1 || !joined[0].Equal(occupant) {
+		t.Errorf("wrong joined rooms: want=[%v], got=%v", occupant, joined)
+	}
+}
+
+func TestRoomsJoinError(t *testing.T) {
+	occupant := jid.MustParse("coven@chat.shakespeare.lit/thirdwitch")
+	m := mux.New(
+		mux.PresenceFunc(stanza.AvailablePresence, xml.Name{Space: muc.NS, Local: "x"}, func(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+			_, err := xmlstream.Copy(t, stanza.Presence{From: p.To, Type: stanza.ErrorPresence}.Wrap(stanza.Error{
+				Type:      stanza.Auth,
+				Condition: stanza.RegistrationRequired,
+			}.TokenReader()))
+			return err
+		}),
+	)
+	errs := make(chan stanza.Error, 1)
+	rooms := &muc.Rooms{Timeout: 5 * time.Second}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(m),
+		xmpptest.ClientHandler(mux.New(rooms.Handle(muc.Handler{
+			Error: func(from jid.JID, err stanza.Error) error {
+				if !from.Equal(occupant) {
+					t.Errorf("wrong error from: want=%v, got=%v", occupant, from)
+				}
+				errs <- err
+				return nil
+			},
+		}))),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := rooms.Join(ctx, cs.Client, occupant, muc.JoinOptions{})
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.RegistrationRequired {
+		t.Errorf("wrong error joining room: want=%v, got=%v", stanza.RegistrationRequired, err)
+	}
+	if joined := rooms.Joined(); len(joined) != 0 {
+		t.Errorf("did not expect room that could not be joined to be tracked, got %v", joined)
+	}
+	select {
+	case err := <-errs:
+		if err.Condition != stanza.RegistrationRequired {
+			t.Errorf("wrong error passed to handler: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for error to be handled")
+	}
+}
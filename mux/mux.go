@@ -17,7 +17,7 @@ import (
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
-	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/ns"
 	"mellium.im/xmpp/stanza"
 )
 
@@ -47,11 +47,19 @@ func (p pattern) String() string {
 // localname will be matched.
 // Full XML names take precedence, followed by wildcard localnames, followed by
 // wildcard namespaces.
+//
+// Stanzas are routed to IQ, message, and presence handlers based on their type
+// and payload.
+// All other top level elements (nonzas) are routed to nonza handlers based on
+// their name, and nonza handlers are never called for stanzas.
 type ServeMux struct {
 	patterns         map[xml.Name]xmpp.Handler
+	nonzaPatterns    map[xml.Name]NonzaHandler
 	iqPatterns       map[pattern]IQHandler
 	msgPatterns      map[pattern]MessageHandler
 	presencePatterns map[pattern]PresenceHandler
+	unhandledIQ      IQHandler
+	unhandledMsg     MessageHandler
 }
 
 // New allocates and returns a new ServeMux.
@@ -98,12 +106,53 @@ func (m *ServeMux) Handler(name xml.Name) (h xmpp.Handler, ok bool) {
 		}
 	}
 
+	if nh, ok := m.NonzaHandler(name); ok {
+		return xmpp.HandlerFunc(nh.HandleNonza), true
+	}
+
+	return nopHandler{}, false
+}
+
+// NonzaHandler returns the handler to use for a top level element that is not
+// a stanza with the provided XML name.
+// If no exact match or wildcard handler exists, a default handler is returned
+// (h is always non-nil) and ok will be false.
+func (m *ServeMux) NonzaHandler(name xml.Name) (h NonzaHandler, ok bool) {
+	if stanza.Is(name) {
+		return nopHandler{}, false
+	}
+
+	h = m.nonzaPatterns[name]
+	if h != nil {
+		return h, true
+	}
+
+	n := name
+	n.Space = ""
+	h = m.nonzaPatterns[n]
+	if h != nil {
+		return h, true
+	}
+
+	n = name
+	n.Local = ""
+	h = m.nonzaPatterns[n]
+	if h != nil {
+		return h, true
+	}
+
+	h = m.nonzaPatterns[xml.Name{}]
+	if h != nil {
+		return h, true
+	}
+
 	return nopHandler{}, false
 }
 
 // IQHandler returns the handler to use for an IQ payload with the given type
 // and payload name.
-// If no handler exists, a default handler is returned (h is always non-nil).
+// If no handler exists, the handler set with UnhandledIQ (or ServiceUnavailable
+// if none was set) is returned (h is always non-nil).
 func (m *ServeMux) IQHandler(typ stanza.IQType, payload xml.Name) (h IQHandler, ok bool) {
 	pattern := pattern{Stanza: iqStanza, Payload: payload, Type: string(typ)}
 	h = m.iqPatterns[pattern]
@@ -132,12 +181,18 @@ func (m *ServeMux) IQHandler(typ stanza.IQType, payload xml.Name) (h IQHandler,
 		return h, true
 	}
 
-	return IQHandlerFunc(iqFallback), false
+	if m.unhandledIQ != nil {
+		return m.unhandledIQ, false
+	}
+	return ServiceUnavailable, false
 }
 
 // MessageHandler returns the handler to use for a message with the given type
 // and payload.
 // If no handler exists, a default handler is returned (h is always non-nil).
+// Unlike IQHandler the default handler is always a no-op, messages where no
+// payload has a handler are passed to the handler set with UnhandledMessage
+// once by the mux when it routes the message.
 func (m *ServeMux) MessageHandler(typ stanza.MessageType, payload xml.Name) (h MessageHandler, ok bool) {
 	pattern := pattern{Stanza: msgStanza, Payload: payload, Type: string(typ)}
 	h = m.msgPatterns[pattern]
@@ -212,6 +267,7 @@ func (m *ServeMux) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElem
 type nopHandler struct{}
 
 func (nopHandler) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error { return nil }
+func (nopHandler) HandleNonza(xmlstream.TokenReadEncoder, *xml.StartElement) error        { return nil }
 func (nopHandler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error   { return nil }
 func (nopHandler) HandlePresence(p stanza.Presence, t xmlstream.TokenReadEncoder) error   { return nil }
 
@@ -307,6 +363,7 @@ func forChildren(m *ServeMux, stanzaVal interface{}, t xmlstream.TokenReadEncode
 
 	// TODO: figure out a good buffer size
 	errs := make([]error, 0, 10)
+	var handled bool
 
 	iterator := xmlstream.NewIter(r)
 	/* #nosec */
@@ -330,7 +387,8 @@ func forChildren(m *ServeMux, stanzaVal interface{}, t xmlstream.TokenReadEncode
 			r.buf = br.buf
 		case stanza.Message:
 			br := &bufReader{r: t, buf: r.buf}
-			h, _ := m.MessageHandler(s.Type, start.Name)
+			h, ok := m.MessageHandler(s.Type, start.Name)
+			handled = handled || ok
 			err = h.HandleMessage(s, struct {
 				xml.TokenReader
 				xmlstream.Encoder
@@ -365,7 +423,10 @@ func forChildren(m *ServeMux, stanzaVal interface{}, t xmlstream.TokenReadEncode
 				Encoder:     t,
 			})
 		case stanza.Message:
-			h, _ := m.MessageHandler(s.Type, xml.Name{})
+			h, ok := m.MessageHandler(s.Type, xml.Name{})
+			if !ok && m.unhandledMsg != nil {
+				h = m.unhandledMsg
+			}
 			return h.HandleMessage(s, struct {
 				xml.TokenReader
 				xmlstream.Encoder
@@ -375,21 +436,17 @@ func forChildren(m *ServeMux, stanzaVal interface{}, t xmlstream.TokenReadEncode
 			})
 		}
 	}
-	return nil
-}
-
-func iqFallback(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
-	if iq.Type == stanza.ErrorIQ {
-		return nil
-	}
-
-	iq.To, iq.From = iq.From, iq.To
-	iq.Type = "error"
-
-	e := stanza.Error{
-		Type:      stanza.Cancel,
-		Condition: stanza.ServiceUnavailable,
+	// If none of the payloads of a message had a handler, let the unhandled
+	// message handler see the whole thing once.
+	if msg, ok := stanzaVal.(stanza.Message); ok && !handled && m.unhandledMsg != nil {
+		r.offset = 0
+		return m.unhandledMsg.HandleMessage(msg, struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: r,
+			Encoder:     t,
+		})
 	}
-	_, err := xmlstream.Copy(t, iq.Wrap(e.TokenReader()))
-	return err
+	return nil
 }
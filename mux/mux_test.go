@@ -43,6 +43,9 @@ func (passHandler) HandlePresence(stanza.Presence, xmlstream.TokenReadEncoder) e
 func (passHandler) HandleIQ(stanza.IQ, xmlstream.TokenReadEncoder, *xml.StartElement) error {
 	return errPassTest
 }
+func (passHandler) HandleNonza(xmlstream.TokenReadEncoder, *xml.StartElement) error {
+	return errPassTest
+}
 
 type multiHandler struct{}
 
@@ -102,6 +105,9 @@ func (failHandler) HandlePresence(p stanza.Presence, t xmlstream.TokenReadEncode
 func (failHandler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
 	return errFailTest
 }
+func (failHandler) HandleNonza(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	return errFailTest
+}
 
 type decodeHandler struct {
 	Body string
@@ -478,6 +484,78 @@ var testCases = [...]struct {
 		x:   `<iq xml:lang="en-us" type="get" xmlns="jabber:client"></iq>`,
 		err: io.EOF,
 	},
+	43: {
+		// Nonzas can be routed with an exact match.
+		m: []mux.Option{
+			mux.Nonza(xml.Name{Local: "r"}, failHandler{}),
+			mux.Nonza(xml.Name{Space: "urn:xmpp:sm:3"}, failHandler{}),
+			mux.NonzaFunc(xml.Name{Space: "urn:xmpp:sm:3", Local: "r"}, passHandler{}.HandleNonza),
+		},
+		x:   `<r xmlns="urn:xmpp:sm:3"/>`,
+		err: errPassTest,
+	},
+	44: {
+		// Nonzas fall back to the namespace wildcard and then the localname
+		// wildcard.
+		m: []mux.Option{
+			mux.Nonza(xml.Name{Local: "active"}, passHandler{}),
+			mux.Nonza(xml.Name{Space: "urn:xmpp:csi:0"}, failHandler{}),
+		},
+		x:   `<active xmlns="urn:xmpp:csi:0"/>`,
+		err: errPassTest,
+	},
+	45: {
+		m: []mux.Option{
+			mux.Nonza(xml.Name{Space: "urn:xmpp:csi:0"}, passHandler{}),
+			mux.Nonza(xml.Name{}, failHandler{}),
+		},
+		x:   `<inactive xmlns="urn:xmpp:csi:0"/>`,
+		err: errPassTest,
+	},
+	46: {
+		// A wildcard nonza handler matches any nonza.
+		m:   []mux.Option{mux.Nonza(xml.Name{}, passHandler{})},
+		x:   `<a xmlns="urn:xmpp:sm:3" h="1"/>`,
+		err: errPassTest,
+	},
+	47: {
+		// A wildcard nonza handler is never used for stanzas.
+		m: []mux.Option{
+			mux.Nonza(xml.Name{}, failHandler{}),
+			mux.Message(stanza.ChatMessage, xml.Name{}, passHandler{}),
+		},
+		x:   `<message type="chat" xmlns="jabber:client"/>`,
+		err: errPassTest,
+	},
+	48: {
+		// Handle takes precedence over nonza handlers.
+		m: []mux.Option{
+			mux.Nonza(xml.Name{Local: "test", Space: "summertime"}, failHandler{}),
+			mux.Handle(xml.Name{Local: "test", Space: "summertime"}, passHandler{}),
+		},
+		x:   `<test xmlns="summertime"/>`,
+		err: errPassTest,
+	},
+	49: {
+		// Expect nil nonza handler to panic.
+		m:           []mux.Option{mux.Nonza(xml.Name{Local: "test"}, nil)},
+		expectPanic: true,
+	},
+	50: {
+		// Expect duplicate nonza handler to panic.
+		m: []mux.Option{
+			mux.Nonza(xml.Name{Local: "test"}, failHandler{}),
+			mux.Nonza(xml.Name{Local: "test"}, failHandler{}),
+		},
+		expectPanic: true,
+	},
+	51: {
+		// Expect stanza registration with Nonza to panic.
+		m: []mux.Option{
+			mux.Nonza(xml.Name{Space: ns.Client, Local: "message"}, failHandler{}),
+		},
+		expectPanic: true,
+	},
 }
 
 type nopEncoder struct {
@@ -573,7 +651,91 @@ func TestLazyServeMuxMapInitialization(t *testing.T) {
 
 	// This will panic if the map isn't initialized lazily.
 	mux.Handle(xml.Name{}, failHandler{})(m)
+	mux.Nonza(xml.Name{}, failHandler{})(m)
 	mux.IQ(stanza.GetIQ, xml.Name{}, failHandler{})(m)
 	mux.Message(stanza.NormalMessage, xml.Name{}, failHandler{})(m)
 	mux.Presence(stanza.SubscribePresence, xml.Name{}, failHandler{})(m)
 }
+
+var unhandledTestCases = [...]struct {
+	in   string
+	opts []mux.Option
+	out  string
+}{
+	0: {
+		in:   `<iq xmlns="jabber:client" type="get" to="romeo@example.com" from="juliet@example.com" id="123"><test xmlns="com.example"/></iq>`,
+		opts: []mux.Option{mux.UnhandledIQ(mux.FeatureNotImplemented)},
+		out:  `<iq xmlns="jabber:client" type="error" to="juliet@example.com" from="romeo@example.com" id="123"><error type="cancel"><feature-not-implemented xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></feature-not-implemented><text xmlns="urn:ietf:params:xml:ns:xmpp-stanzas">no handler for namespace com.example</text></error></iq>`,
+	},
+	1: {
+		in: `<message xmlns="jabber:client" type="chat" to="romeo@example.com" from="juliet@example.com" id="123"><test xmlns="com.example"/></message>`,
+	},
+	2: {
+		in:   `<message xmlns="jabber:client" type="chat" to="romeo@example.com" from="juliet@example.com" id="123"><test xmlns="com.example"/></message>`,
+		opts: []mux.Option{mux.UnhandledMessage(mux.FeatureNotImplemented)},
+		out:  `<message xmlns="jabber:client" type="error" id="123" to="juliet@example.com" from="romeo@example.com"><error type="cancel"><feature-not-implemented xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></feature-not-implemented><text xmlns="urn:ietf:params:xml:ns:xmpp-stanzas">no handler for namespace com.example</text></error></message>`,
+	},
+	3: {
+		in: `<message xmlns="jabber:client" type="chat" to="romeo@example.com" from="juliet@example.com" id="123"><body>hi</body><test xmlns="com.example"/></message>`,
+		opts: []mux.Option{
+			mux.UnhandledMessage(mux.FeatureNotImplemented),
+			mux.Message(stanza.ChatMessage, xml.Name{Local: "body"}, mux.Drop),
+		},
+	},
+	4: {
+		in:   `<message xmlns="jabber:client" type="error" to="romeo@example.com" from="juliet@example.com" id="123"><test xmlns="com.example"/></message>`,
+		opts: []mux.Option{mux.UnhandledMessage(mux.FeatureNotImplemented)},
+	},
+	5: {
+		in: `<message xmlns="jabber:client" type="chat" to="romeo@example.com" from="juliet@example.com" id="123"><test xmlns="com.example"/></message>`,
+		opts: []mux.Option{mux.UnhandledMessageFunc(func(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+			_, err := xmlstream.Copy(t, stanza.Message{ID: msg.ID, Type: stanza.ChatMessage}.Wrap(nil))
+			return err
+		})},
+		out: `<message xmlns="jabber:client" type="chat" id="123"></message>`,
+	},
+	6: {
+		in:   `<iq xmlns="jabber:client" type="get" to="romeo@example.com" from="juliet@example.com" id="123"><ping xmlns="urn:xmpp:ping"/></iq>`,
+		opts: []mux.Option{mux.UnhandledIQ(mux.FeatureNotImplemented)},
+		out:  `<iq xmlns="jabber:client" type="error" to="juliet@example.com" from="romeo@example.com" id="123"><error type="cancel"><feature-not-implemented xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></feature-not-implemented><text xmlns="urn:ietf:params:xml:ns:xmpp-stanzas">no handler for namespace urn:xmpp:ping (XEP-0199: XMPP Ping)</text></error></iq>`,
+	},
+}
+
+func TestUnhandled(t *testing.T) {
+	for i, tc := range unhandledTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			s := xmpptest.NewSession(0, struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: strings.NewReader(tc.in),
+				Writer: buf,
+			})
+
+			r := s.TokenReader()
+			defer r.Close()
+			tok, err := r.Token()
+			if err != nil {
+				t.Fatalf("bad start token read: %v", err)
+			}
+			start := tok.(xml.StartElement)
+			w := s.TokenWriter()
+			defer w.Close()
+			err = mux.New(tc.opts...).HandleXMPP(testEncoder{
+				TokenReader: r,
+				TokenWriter: w,
+			}, &start)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Errorf("unexpected error flushing token writer: %v", err)
+			}
+
+			if out := buf.String(); out != tc.out {
+				t.Errorf("bad output:\nwant=%s\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+)
+
+// NonzaHandler responds to top level elements that are not stanzas such as
+// stream management acknowledgements or client state indications.
+//
+// The token stream is limited to the inside of the element and does not
+// include the start element or its end element.
+type NonzaHandler interface {
+	HandleNonza(xmlstream.TokenReadEncoder, *xml.StartElement) error
+}
+
+// The NonzaHandlerFunc type is an adapter to allow the use of ordinary
+// functions as nonza handlers.
+// If f is a function with the appropriate signature, NonzaHandlerFunc(f) is a
+// NonzaHandler that calls f.
+type NonzaHandlerFunc func(xmlstream.TokenReadEncoder, *xml.StartElement) error
+
+// HandleNonza calls f(t, start).
+func (f NonzaHandlerFunc) HandleNonza(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	return f(t, start)
+}
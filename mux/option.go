@@ -84,6 +84,48 @@ func PresenceFunc(typ stanza.PresenceType, payload xml.Name, h PresenceHandlerFu
 	return Presence(typ, payload, h)
 }
 
+// UnhandledIQ returns an option that sets the handler used for IQs that do not
+// match any other handler.
+// The default is ServiceUnavailable.
+// If h does not write a response, the session will reply with a
+// service-unavailable error anyways, so IQs cannot be dropped.
+func UnhandledIQ(h IQHandler) Option {
+	return func(m *ServeMux) {
+		if h == nil {
+			panic("mux: nil unhandled IQ handler")
+		}
+		m.unhandledIQ = h
+	}
+}
+
+// UnhandledIQFunc returns an option that sets the handler used for IQs that do
+// not match any other handler.
+// For more information see UnhandledIQ.
+func UnhandledIQFunc(h IQHandlerFunc) Option {
+	return UnhandledIQ(h)
+}
+
+// UnhandledMessage returns an option that sets the handler used for messages
+// where none of the payloads match any other handler.
+// It is called at most once for each message with a token stream that starts
+// with the message start element.
+// The default is Drop.
+func UnhandledMessage(h MessageHandler) Option {
+	return func(m *ServeMux) {
+		if h == nil {
+			panic("mux: nil unhandled message handler")
+		}
+		m.unhandledMsg = h
+	}
+}
+
+// UnhandledMessageFunc returns an option that sets the handler used for
+// messages where none of the payloads match any other handler.
+// For more information see UnhandledMessage.
+func UnhandledMessageFunc(h MessageHandlerFunc) Option {
+	return UnhandledMessage(h)
+}
+
 // Handle returns an option that matches on the provided XML name.
 // If a handler already exists for n when the option is applied, the option
 // panics.
@@ -109,3 +151,34 @@ func Handle(n xml.Name, h xmpp.Handler) Option {
 func HandleFunc(n xml.Name, h xmpp.HandlerFunc) Option {
 	return Handle(n, h)
 }
+
+// Nonza returns an option that matches top level elements that are not
+// stanzas on the provided XML name.
+// Unlike Handle, nonza handlers are never consulted for stanzas, even if n
+// contains a wildcard, and they only have access to the inside of the element.
+// If n is a stanza name or a handler already exists for n when the option is
+// applied, the option panics.
+func Nonza(n xml.Name, h NonzaHandler) Option {
+	return func(m *ServeMux) {
+		if h == nil {
+			panic("mux: nil nonza handler")
+		}
+		if stanza.Is(n) {
+			panic("mux: tried to register stanza handler with Nonza, use IQ, Message, or Presence instead")
+		}
+		if _, ok := m.nonzaPatterns[n]; ok {
+			panic("mux: multiple registrations for nonza {" + n.Space + "}" + n.Local)
+		}
+		if m.nonzaPatterns == nil {
+			m.nonzaPatterns = make(map[xml.Name]NonzaHandler)
+		}
+		m.nonzaPatterns[n] = h
+	}
+}
+
+// NonzaFunc returns an option that matches top level elements that are not
+// stanzas.
+// For more information see Nonza.
+func NonzaFunc(n xml.Name, h NonzaHandlerFunc) Option {
+	return Nonza(n, h)
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mux
+
+import (
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/ns"
+	"mellium.im/xmpp/stanza"
+)
+
+// Handlers that can be used with UnhandledIQ and UnhandledMessage.
+var (
+	// ServiceUnavailable replies with a service-unavailable error.
+	// It is the default for unhandled IQs.
+	ServiceUnavailable = Reply{Condition: stanza.ServiceUnavailable}
+
+	// FeatureNotImplemented replies with a feature-not-implemented error that
+	// names the namespace of the unhandled payload, and the specification that
+	// defines it if known, in its text.
+	FeatureNotImplemented = Reply{Condition: stanza.FeatureNotImplemented, IncludeNS: true}
+
+	// Drop silently ignores messages.
+	// It is the default for unhandled messages.
+	Drop MessageHandler = nopHandler{}
+)
+
+// Reply is an IQ and message handler that responds to stanzas with an error.
+// It never responds to stanzas of type error.
+type Reply struct {
+	Condition stanza.Condition
+
+	// IncludeNS adds the namespace of the payload that was not handled to the
+	// text of the error to make debugging easier for the remote entity.
+	// If the namespace is well known, the specification that defines it is
+	// also included.
+	IncludeNS bool
+}
+
+func (r Reply) stanzaErr(payload xml.Name) stanza.Error {
+	e := stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: r.Condition,
+	}
+	if r.IncludeNS && payload.Space != "" {
+		text := "no handler for namespace " + payload.Space
+		if spec, ok := ns.Lookup(payload.Space); ok {
+			text += " (" + spec.String() + ")"
+		}
+		e.Text = map[string]string{
+			"": text,
+		}
+	}
+	return e
+}
+
+// HandleIQ implements IQHandler.
+func (r Reply) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if iq.Type == stanza.ErrorIQ {
+		return nil
+	}
+
+	var payload xml.Name
+	if start != nil {
+		payload = start.Name
+	}
+	_, err := xmlstream.Copy(t, iq.Error(r.stanzaErr(payload)))
+	return err
+}
+
+// HandleMessage implements MessageHandler.
+func (r Reply) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	if msg.Type == stanza.ErrorMessage {
+		return nil
+	}
+
+	// Find the name of the first payload.
+	var payload xml.Name
+	var level int
+	for {
+		tok, err := t.Token()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			level++
+			if level == 2 {
+				payload = start.Name
+				break
+			}
+		}
+		if _, ok := tok.(xml.EndElement); ok {
+			level--
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	msg.To, msg.From = msg.From, msg.To
+	msg.Type = stanza.ErrorMessage
+	_, err := xmlstream.Copy(t, msg.Wrap(r.stanzaErr(payload).TokenReader()))
+	return err
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//+build integration
+
+package xmpp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"testing"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stream"
+)
+
+const scriptHeader = `<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' id='1234' from='localhost' version='1.0'>`
+
+var streamName = xml.Name{Space: stream.NS, Local: "stream"}
+
+func TestIntegrationScriptTruncatedFeatures(t *testing.T) {
+	run := integration.Script(context.TODO(), t, []integration.Step{
+		integration.ExpectName(streamName),
+		integration.SendXML(scriptHeader + `<stream:features><mechanisms`),
+	})
+	run(func(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
+		j := jid.MustParse("me@localhost")
+		_, err := cmd.DialClient(ctx, j, t, xmpp.BindResource())
+		if err == nil {
+			t.Errorf("expected error negotiating truncated features")
+		}
+	})
+}
+
+func TestIntegrationScriptBadChallenge(t *testing.T) {
+	run := integration.Script(context.TODO(), t, []integration.Step{
+		integration.ExpectName(streamName),
+		integration.SendXML(scriptHeader + `<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>`),
+		integration.ExpectName(xml.Name{Space: ns.StartTLS, Local: "starttls"}),
+		integration.SendXML(`<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>`),
+		integration.AcceptTLS("localhost"),
+		integration.ExpectName(streamName),
+		integration.SendXML(scriptHeader + `<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms></stream:features>`),
+		integration.ExpectName(xml.Name{Space: ns.SASL, Local: "auth"}),
+		integration.SendXML(`<challenge xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>!not base64!</challenge>`),
+	}, integration.Cert("localhost"))
+	run(func(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
+		j := jid.MustParse("me@localhost")
+		_, err := cmd.DialClient(ctx, j, t,
+			xmpp.StartTLS(&tls.Config{
+				InsecureSkipVerify: true,
+			}),
+			xmpp.SASL("", "pass", sasl.Plain),
+		)
+		if err == nil {
+			t.Errorf("expected error negotiating SASL with invalid challenge")
+		}
+	})
+}
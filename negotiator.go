@@ -6,10 +6,15 @@ package xmpp
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"net"
+	"time"
 
+	"mellium.im/xmpp/compat"
 	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/ns"
 	intstream "mellium.im/xmpp/internal/stream"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/stream"
@@ -37,6 +42,9 @@ type Negotiator func(ctx context.Context, in, out *stream.Info, session *Session
 // StreamConfig contains options for configuring the default Negotiator.
 type StreamConfig struct {
 	// The native language of the stream.
+	// If set, it is sent in the stream header and added to any outgoing stanzas
+	// that do not already have an xml:lang attribute once the session has been
+	// negotiated.
 	Lang string
 
 	// A list of stream features to attempt to negotiate.
@@ -70,6 +78,63 @@ type StreamConfig struct {
 	// since this bypasses TLS and could expose passwords and other sensitive
 	// data.
 	TeeIn, TeeOut io.Writer
+
+	// Deadlines to apply to the underlying connection during each phase of
+	// stream negotiation.
+	// Once negotiation is complete the deadlines are cleared so that idle
+	// sessions do not time out.
+	// If no timeouts are set, the deadlines are never modified.
+	Timeouts Timeouts
+
+	// Compat enables workarounds for XML quirks sent by other implementations
+	// such as byte order marks, invalid UTF-8, and stanzas that do not inherit
+	// the language of the stream.
+	// For more information see the compat package.
+	Compat compat.Mode
+}
+
+// Timeouts configures the read and write deadlines used while negotiating a
+// session.
+// A zero value for any individual phase means that phase will not time out.
+type Timeouts struct {
+	// Greeting is the maximum time allowed for exchanging stream headers and
+	// stream feature lists.
+	Greeting time.Duration
+
+	// TLS is the maximum time allowed for negotiating StartTLS.
+	TLS time.Duration
+
+	// SASL is the maximum time allowed for SASL authentication.
+	SASL time.Duration
+
+	// Feature is the maximum time allowed for negotiating any other stream
+	// feature.
+	Feature time.Duration
+}
+
+// feature returns the timeout to use when negotiating a stream feature with the
+// provided namespace.
+func (t Timeouts) feature(space string) time.Duration {
+	switch space {
+	case ns.StartTLS:
+		return t.TLS
+	case ns.SASL:
+		return t.SASL
+	}
+	return t.Feature
+}
+
+// apply sets the read and write deadlines on c to d from now.
+// If d is zero the deadlines are cleared, and if no timeouts are configured it
+// does nothing so that we don't interfere with deadlines set by the user.
+func (t Timeouts) apply(c net.Conn, d time.Duration) error {
+	if t == (Timeouts{}) {
+		return nil
+	}
+	if d == 0 {
+		return c.SetDeadline(time.Time{})
+	}
+	return c.SetDeadline(time.Now().Add(d))
 }
 
 // NewNegotiator creates a Negotiator that uses a collection of StreamFeatures
@@ -99,6 +164,13 @@ func negotiator(cfg StreamConfig) Negotiator {
 			}
 		}
 
+		// The compat reader is applied above the connection when the decoder is
+		// created so that it always sees the plain text, even after StartTLS.
+		if s.compat != cfg.Compat {
+			s.compat = cfg.Compat
+			s.in.d = xml.NewDecoder(compat.NewReader(s.conn, s.compat))
+		}
+
 		c := s.Conn()
 		// If the session is not already using a tee conn, but we're configured to
 		// use one, return the new teeConn and don't set any state bits.
@@ -117,6 +189,11 @@ func negotiator(cfg StreamConfig) Negotiator {
 			return mask, c, nState, err
 		}
 
+		err = cfg.Timeouts.apply(s.Conn(), cfg.Timeouts.Greeting)
+		if err != nil {
+			return mask, nil, nState, err
+		}
+
 		// Loop for as long as we're not done negotiating features or a stream
 		// restart is still required.
 		if nState.doRestart {
@@ -189,8 +266,13 @@ func negotiator(cfg StreamConfig) Negotiator {
 		if cfg.Features != nil {
 			features = cfg.Features(s, features...)
 		}
-		mask, rw, err = negotiateFeatures(ctx, s, data == nil, cfg.WebSocket, features)
+		s.streamFeatures = features
+		mask, rw, err = negotiateFeatures(ctx, s, data == nil, cfg.WebSocket, cfg.Timeouts, features)
 		nState.doRestart = rw != nil
+		if err == nil && mask&Ready == Ready {
+			// We're moving into the steady state, clear any negotiation deadlines.
+			err = cfg.Timeouts.apply(s.Conn(), 0)
+		}
 		return mask, rw, nState, err
 	}
 }
@@ -0,0 +1,234 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package ns is a registry of the well-known namespaces used by this module.
+//
+// The packages that implement each extension also export the namespaces that
+// they use, so most users will not need this package.
+// It is useful when working with namespaces from many extensions at once, for
+// example to describe a namespace that was advertised by a remote entity in
+// logs or error messages:
+//
+//     if spec, ok := ns.Lookup(feature.Var); ok {
+//         log.Printf("%s supports %s", j, spec)
+//     }
+//
+// This package does not import any other package in the module so that it can
+// be used anywhere without creating import cycles.
+package ns // import "mellium.im/xmpp/ns"
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Namespaces defined by RFCs.
+const (
+	Bind        = "urn:ietf:params:xml:ns:xmpp-bind"
+	Client      = "jabber:client"
+	Framing     = "urn:ietf:params:xml:ns:xmpp-framing"
+	Roster      = "jabber:iq:roster"
+	SASL        = "urn:ietf:params:xml:ns:xmpp-sasl"
+	Server      = "jabber:server"
+	Stanza      = "urn:ietf:params:xml:ns:xmpp-stanzas"
+	StartTLS    = "urn:ietf:params:xml:ns:xmpp-tls"
+	Stream      = "http://etherx.jabber.org/streams"
+	StreamError = "urn:ietf:params:xml:ns:xmpp-streams"
+	XML         = "http://www.w3.org/XML/1998/namespace"
+)
+
+// Namespaces defined by XEPs.
+const (
+	ATM                  = "urn:xmpp:atm:1"
+	AvatarData           = "urn:xmpp:avatar:data"
+	AvatarMetadata       = "urn:xmpp:avatar:metadata"
+	Bidi                 = "urn:xmpp:bidi"
+	BidiFeature          = "urn:xmpp:features:bidi"
+	Blocking             = "urn:xmpp:blocking"
+	BlockingErrors       = "urn:xmpp:blocking:errors"
+	Bookmarks            = "urn:xmpp:bookmarks:1"
+	BookmarksLegacy      = "storage:bookmarks"
+	Caps                 = "http://jabber.org/protocol/caps"
+	CapsHashed           = "urn:xmpp:caps"
+	Carbons              = "urn:xmpp:carbons:2"
+	ChatMarkers          = "urn:xmpp:chat-markers:0"
+	ChatStates           = "http://jabber.org/protocol/chatstates"
+	ComponentAccept      = "jabber:component:accept"
+	Compress             = "http://jabber.org/protocol/compress"
+	CompressFeature      = "http://jabber.org/features/compress"
+	Correct              = "urn:xmpp:message-correct:0"
+	CSI                  = "urn:xmpp:csi:0"
+	Delay                = "urn:xmpp:delay"
+	DiscoInfo            = "http://jabber.org/protocol/disco#info"
+	DiscoItems           = "http://jabber.org/protocol/disco#items"
+	EME                  = "urn:xmpp:eme:0"
+	EncryptedFileSharing = "urn:xmpp:esfs:0"
+	Fallback             = "urn:xmpp:fallback:0"
+	FileMetadata         = "urn:xmpp:file:metadata:0"
+	FileSharing          = "urn:xmpp:sfs:0"
+	Form                 = "jabber:x:data"
+	Forward              = "urn:xmpp:forward:0"
+	Hashes               = "urn:xmpp:hashes:2"
+	Hints                = "urn:xmpp:hints"
+	IBB                  = "http://jabber.org/protocol/ibb"
+	IBR2                 = "urn:xmpp:register:0"
+	Jingle               = "urn:xmpp:jingle:1"
+	JingleErrors         = "urn:xmpp:jingle:errors:1"
+	JingleFileTransfer   = "urn:xmpp:jingle:apps:file-transfer:5"
+	JingleIBB            = "urn:xmpp:jingle:transports:ibb:1"
+	JingleS5B            = "urn:xmpp:jingle:transports:s5b:1"
+	Last                 = "jabber:iq:last"
+	MAM                  = "urn:xmpp:mam:2"
+	MIX                  = "urn:xmpp:mix:core:1"
+	MIXAdmin             = "urn:xmpp:mix:admin:0"
+	MUC                  = "http://jabber.org/protocol/muc"
+	MUCAdmin             = "http://jabber.org/protocol/muc#admin"
+	MUCOwner             = "http://jabber.org/protocol/muc#owner"
+	MUCUser              = "http://jabber.org/protocol/muc#user"
+	OOB                  = "jabber:x:oob"
+	OOBQuery             = "jabber:iq:oob"
+	PIE                  = "urn:xmpp:pie:0"
+	Ping                 = "urn:xmpp:ping"
+	PubSub               = "http://jabber.org/protocol/pubsub"
+	PubSubOwner          = "http://jabber.org/protocol/pubsub#owner"
+	Push                 = "urn:xmpp:push:0"
+	Reactions            = "urn:xmpp:reactions:0"
+	Receipts             = "urn:xmpp:receipts"
+	Register             = "jabber:iq:register"
+	RegisterFeature      = "http://jabber.org/features/iq-register"
+	Reply                = "urn:xmpp:reply:0"
+	RosterVer            = "urn:xmpp:features:rosterver"
+	RSM                  = "http://jabber.org/protocol/rsm"
+	StanzaID             = "urn:xmpp:sid:0"
+	Styling              = "urn:xmpp:styling:0"
+	Time                 = "urn:xmpp:time"
+	TrustMessages        = "urn:xmpp:tm:1"
+	Upload               = "urn:xmpp:http:upload:0"
+	URLData              = "http://jabber.org/protocol/url-data"
+	Version              = "jabber:iq:version"
+)
+
+// Spec is the specification that defines a namespace.
+type Spec struct {
+	// Number is the number of the XEP or RFC.
+	Number int
+
+	// Name is the title of the specification.
+	Name string
+
+	// RFC is true if the specification is an RFC instead of a XEP.
+	RFC bool
+}
+
+// String returns the number and title of the specification, for example
+// "XEP-0199: XMPP Ping".
+func (s Spec) String() string {
+	if s.RFC {
+		return "RFC " + strconv.Itoa(s.Number) + ": " + s.Name
+	}
+	num := strconv.Itoa(s.Number)
+	if len(num) < 4 {
+		num = strings.Repeat("0", 4-len(num)) + num
+	}
+	return "XEP-" + num + ": " + s.Name
+}
+
+var (
+	rfc6120 = Spec{Number: 6120, Name: "Extensible Messaging and Presence Protocol (XMPP): Core", RFC: true}
+	rfc6121 = Spec{Number: 6121, Name: "Extensible Messaging and Presence Protocol (XMPP): Instant Messaging and Presence", RFC: true}
+	rfc7395 = Spec{Number: 7395, Name: "An Extensible Messaging and Presence Protocol (XMPP) Subprotocol for WebSocket", RFC: true}
+)
+
+func xep(n int, name string) Spec {
+	return Spec{Number: n, Name: name}
+}
+
+var specs = map[string]Spec{
+	Bind:        rfc6120,
+	Client:      rfc6120,
+	Framing:     rfc7395,
+	Roster:      rfc6121,
+	SASL:        rfc6120,
+	Server:      rfc6120,
+	Stanza:      rfc6120,
+	StartTLS:    rfc6120,
+	Stream:      rfc6120,
+	StreamError: rfc6120,
+
+	ATM:                  xep(450, "Automatic Trust Management (ATM)"),
+	AvatarData:           xep(84, "User Avatar"),
+	AvatarMetadata:       xep(84, "User Avatar"),
+	Bidi:                 xep(288, "Bidirectional Server-to-Server Connections"),
+	BidiFeature:          xep(288, "Bidirectional Server-to-Server Connections"),
+	Blocking:             xep(191, "Blocking Command"),
+	BlockingErrors:       xep(191, "Blocking Command"),
+	Bookmarks:            xep(402, "PEP Native Bookmarks"),
+	BookmarksLegacy:      xep(48, "Bookmarks"),
+	Caps:                 xep(115, "Entity Capabilities"),
+	CapsHashed:           xep(390, "Entity Capabilities 2.0"),
+	Carbons:              xep(280, "Message Carbons"),
+	ChatMarkers:          xep(333, "Chat Markers"),
+	ChatStates:           xep(85, "Chat State Notifications"),
+	ComponentAccept:      xep(114, "Jabber Component Protocol"),
+	Compress:             xep(138, "Stream Compression"),
+	CompressFeature:      xep(138, "Stream Compression"),
+	Correct:              xep(308, "Last Message Correction"),
+	CSI:                  xep(352, "Client State Indication"),
+	Delay:                xep(203, "Delayed Delivery"),
+	DiscoInfo:            xep(30, "Service Discovery"),
+	DiscoItems:           xep(30, "Service Discovery"),
+	EME:                  xep(380, "Explicit Message Encryption"),
+	EncryptedFileSharing: xep(448, "Encryption for stateless file sharing"),
+	Fallback:             xep(428, "Fallback Indication"),
+	FileMetadata:         xep(446, "File metadata element"),
+	FileSharing:          xep(447, "Stateless file sharing"),
+	Form:                 xep(4, "Data Forms"),
+	Forward:              xep(297, "Stanza Forwarding"),
+	Hashes:               xep(300, "Use of Cryptographic Hash Functions in XMPP"),
+	Hints:                xep(334, "Message Processing Hints"),
+	IBB:                  xep(47, "In-Band Bytestreams"),
+	IBR2:                 xep(389, "Extensible In-Band Registration"),
+	Jingle:               xep(166, "Jingle"),
+	JingleErrors:         xep(166, "Jingle"),
+	JingleFileTransfer:   xep(234, "Jingle File Transfer"),
+	JingleIBB:            xep(261, "Jingle In-Band Bytestreams Transport Method"),
+	JingleS5B:            xep(260, "Jingle SOCKS5 Bytestreams Transport Method"),
+	Last:                 xep(12, "Last Activity"),
+	MAM:                  xep(313, "Message Archive Management"),
+	MIX:                  xep(369, "Mediated Information eXchange (MIX)"),
+	MIXAdmin:             xep(406, "Mediated Information eXchange (MIX): MIX Administration"),
+	MUC:                  xep(45, "Multi-User Chat"),
+	MUCAdmin:             xep(45, "Multi-User Chat"),
+	MUCOwner:             xep(45, "Multi-User Chat"),
+	MUCUser:              xep(45, "Multi-User Chat"),
+	OOB:                  xep(66, "Out of Band Data"),
+	OOBQuery:             xep(66, "Out of Band Data"),
+	PIE:                  xep(227, "Portable Import/Export Format for XMPP-IM Servers"),
+	Ping:                 xep(199, "XMPP Ping"),
+	PubSub:               xep(60, "Publish-Subscribe"),
+	PubSubOwner:          xep(60, "Publish-Subscribe"),
+	Push:                 xep(357, "Push Notifications"),
+	Reactions:            xep(444, "Message Reactions"),
+	Receipts:             xep(184, "Message Delivery Receipts"),
+	Register:             xep(77, "In-Band Registration"),
+	RegisterFeature:      xep(77, "In-Band Registration"),
+	Reply:                xep(461, "Message Replies"),
+	RosterVer:            rfc6121,
+	RSM:                  xep(59, "Result Set Management"),
+	StanzaID:             xep(359, "Unique and Stable Stanza IDs"),
+	Styling:              xep(393, "Message Styling"),
+	Time:                 xep(202, "Entity Time"),
+	TrustMessages:        xep(434, "Trust Messages (TM)"),
+	Upload:               xep(363, "HTTP File Upload"),
+	URLData:              xep(103, "URL Address Information"),
+	Version:              xep(92, "Software Version"),
+}
+
+// Lookup returns the specification that defines a namespace.
+// Namespaces with a "+notify" suffix, which are used to subscribe to
+// notifications for a PEP node, are looked up without the suffix.
+func Lookup(namespace string) (Spec, bool) {
+	spec, ok := specs[strings.TrimSuffix(namespace, "+notify")]
+	return spec, ok
+}
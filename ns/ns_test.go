@@ -0,0 +1,173 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ns_test
+
+import (
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/avatar"
+	"mellium.im/xmpp/blocklist"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/caps"
+	"mellium.im/xmpp/carbons"
+	"mellium.im/xmpp/chatstates"
+	"mellium.im/xmpp/component"
+	"mellium.im/xmpp/compress"
+	"mellium.im/xmpp/correct"
+	"mellium.im/xmpp/csi"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/eme"
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/file"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/hashes"
+	"mellium.im/xmpp/hints"
+	"mellium.im/xmpp/ibr2"
+	"mellium.im/xmpp/jingle"
+	jinglefile "mellium.im/xmpp/jingle/file"
+	"mellium.im/xmpp/last"
+	"mellium.im/xmpp/mam"
+	"mellium.im/xmpp/markers"
+	"mellium.im/xmpp/mix"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/ns"
+	"mellium.im/xmpp/oob"
+	"mellium.im/xmpp/paging"
+	"mellium.im/xmpp/pie"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/push"
+	"mellium.im/xmpp/reactions"
+	"mellium.im/xmpp/receipts"
+	"mellium.im/xmpp/register"
+	"mellium.im/xmpp/reply"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/s2s"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/stream"
+	"mellium.im/xmpp/styling"
+	"mellium.im/xmpp/trust"
+	"mellium.im/xmpp/upload"
+	"mellium.im/xmpp/version"
+	"mellium.im/xmpp/websocket"
+	"mellium.im/xmpp/xtime"
+)
+
+// The namespaces in the registry must match those exported by the packages
+// that implement them.
+var consistencyTestCases = [...]struct {
+	registry string
+	pkg      string
+}{
+	{ns.ATM, trust.NSATM},
+	{ns.AvatarData, avatar.NSData},
+	{ns.AvatarMetadata, avatar.NSMetadata},
+	{ns.Bidi, s2s.NSBidi},
+	{ns.BidiFeature, s2s.NSBidiFeature},
+	{ns.Blocking, blocklist.NS},
+	{ns.BlockingErrors, blocklist.NSErrors},
+	{ns.Bookmarks, bookmarks.NS},
+	{ns.BookmarksLegacy, bookmarks.NSLegacy},
+	{ns.Caps, caps.NS},
+	{ns.CapsHashed, caps.NSHashed},
+	{ns.Carbons, carbons.NS},
+	{ns.ChatMarkers, markers.NS},
+	{ns.ChatStates, chatstates.NS},
+	{ns.ComponentAccept, component.NSAccept},
+	{ns.Compress, compress.NSProtocol},
+	{ns.CompressFeature, compress.NSFeatures},
+	{ns.Correct, correct.NS},
+	{ns.CSI, csi.NS},
+	{ns.Delay, delay.NS},
+	{ns.EME, eme.NS},
+	{ns.Fallback, fallback.NS},
+	{ns.FileMetadata, file.NS},
+	{ns.FileSharing, file.NSSharing},
+	{ns.Form, form.NS},
+	{ns.Forward, forward.NS},
+	{ns.Framing, websocket.NS},
+	{ns.Hashes, hashes.NS},
+	{ns.Hints, hints.NS},
+	{ns.IBB, jinglefile.NSInBand},
+	{ns.IBR2, ibr2.NS},
+	{ns.Jingle, jingle.NS},
+	{ns.JingleErrors, jingle.NSErrors},
+	{ns.JingleFileTransfer, jinglefile.NS},
+	{ns.JingleIBB, jinglefile.NSIBB},
+	{ns.JingleS5B, jinglefile.NSS5B},
+	{ns.Last, last.NS},
+	{ns.MAM, mam.NS},
+	{ns.MIX, mix.NS},
+	{ns.MIXAdmin, mix.NSAdmin},
+	{ns.MUC, muc.NS},
+	{ns.MUCAdmin, muc.NSAdmin},
+	{ns.MUCOwner, muc.NSOwner},
+	{ns.MUCUser, muc.NSUser},
+	{ns.OOB, oob.NS},
+	{ns.OOBQuery, oob.NSQuery},
+	{ns.PIE, pie.NS},
+	{ns.Ping, ping.NS},
+	{ns.PubSub, pubsub.NS},
+	{ns.PubSubOwner, pubsub.NSOwner},
+	{ns.Push, push.NS},
+	{ns.Reactions, reactions.NS},
+	{ns.Receipts, receipts.NS},
+	{ns.Register, register.NS},
+	{ns.RegisterFeature, register.NSFeature},
+	{ns.Reply, reply.NS},
+	{ns.Roster, roster.NS},
+	{ns.RosterVer, roster.NSFeatures},
+	{ns.RSM, paging.NS},
+	{ns.StanzaID, stanza.NSSid},
+	{ns.Stream, stream.NS},
+	{ns.StreamError, stream.NSError},
+	{ns.Styling, styling.NS},
+	{ns.Time, xtime.NS},
+	{ns.TrustMessages, trust.NS},
+	{ns.Upload, upload.NS},
+	{ns.URLData, file.NSURLData},
+	{ns.Version, version.NS},
+}
+
+func TestConsistency(t *testing.T) {
+	for i, tc := range consistencyTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if tc.registry != tc.pkg {
+				t.Errorf("registry namespace %q does not match package namespace %q", tc.registry, tc.pkg)
+			}
+			if _, ok := ns.Lookup(tc.registry); !ok {
+				t.Errorf("no specification registered for %q", tc.registry)
+			}
+		})
+	}
+}
+
+var lookupTestCases = [...]struct {
+	namespace string
+	spec      string
+	ok        bool
+}{
+	0: {namespace: ns.Ping, spec: "XEP-0199: XMPP Ping", ok: true},
+	1: {namespace: ns.Form, spec: "XEP-0004: Data Forms", ok: true},
+	2: {namespace: ns.Bind, spec: "RFC 6120: Extensible Messaging and Presence Protocol (XMPP): Core", ok: true},
+	3: {namespace: ns.AvatarMetadata + "+notify", spec: "XEP-0084: User Avatar", ok: true},
+	4: {namespace: "urn:example"},
+}
+
+func TestLookup(t *testing.T) {
+	for i, tc := range lookupTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			spec, ok := ns.Lookup(tc.namespace)
+			if ok != tc.ok {
+				t.Fatalf("wrong result for %q: want=%t, got=%t", tc.namespace, tc.ok, ok)
+			}
+			if ok && spec.String() != tc.spec {
+				t.Errorf("wrong spec: want=%q, got=%q", tc.spec, spec)
+			}
+		})
+	}
+}
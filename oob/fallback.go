@@ -0,0 +1,150 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package oob
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/caps"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/ns"
+	"mellium.im/xmpp/stanza"
+)
+
+// Mechanism is a method that can be used to offer a file to another entity.
+type Mechanism uint8
+
+// A list of supported file transfer mechanisms in order from least to most
+// preferred.
+const (
+	// MessageMechanism sends the URL in the body of a message along with an OOB
+	// data element.
+	// Because the URL is also in the body, clients that do not support OOB will
+	// still display a link to the file.
+	MessageMechanism Mechanism = iota
+
+	// IQMechanism offers the file using an OOB IQ and waits for the recipient
+	// to download it.
+	IQMechanism
+
+	// JingleMechanism offers the file using Jingle File Transfer.
+	JingleMechanism
+)
+
+// Select picks the most preferred mechanism supported by an entity based on
+// its advertised features.
+// The info will normally be the result of looking up the capabilities that
+// the entity advertised in its presence using caps.Handler.Info, but the
+// result of a direct disco#info query may also be used.
+// If the entity does not advertise support for any other mechanism,
+// MessageMechanism is returned since it degrades gracefully to a plain text
+// link.
+func Select(info disco.Info) Mechanism {
+	switch {
+	case supports(info, ns.JingleFileTransfer):
+		return JingleMechanism
+	case supports(info, NSQuery):
+		return IQMechanism
+	}
+	return MessageMechanism
+}
+
+func supports(info disco.Info, feature string) bool {
+	for _, f := range info.Features {
+		if f.Var == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Message returns a message stanza that contains the URL from d as its body
+// and d as an OOB data element.
+func Message(msg stanza.Message, d Data) xml.TokenReader {
+	return msg.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(d.URL)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		),
+		d.TokenReader(),
+	))
+}
+
+// Offer offers the file at the URL in d to the provided JID.
+// It is meant to be used when no HTTP upload service is available and the file
+// is being hosted elsewhere.
+//
+// Offer looks up the features of the recipient and uses Select to pick a
+// mechanism.
+// If c is not nil, the features are looked up using the capabilities
+// advertised by the recipient (see caps.Handler.Info) so that the response is
+// verified and recipients running the same software are only queried once.
+// Otherwise the recipient is queried directly.
+// If the recipient supports Jingle File Transfer and jingle is not nil, jingle
+// is called to negotiate the transfer.
+// If jingle is nil, or if jingle or the OOB IQ fail with a service-unavailable
+// or feature-not-implemented error (indicating that the recipient does not
+// support the mechanism after all), the other mechanisms are tried in turn,
+// ending with a message containing the URL that any client can display.
+// Any other error (for example, a not-acceptable error because the recipient
+// declined the file) is returned without trying other mechanisms so that the
+// recipient does not receive a file that they rejected.
+// Recipients should be addressed by their full JID, otherwise the server will
+// respond to the feature query on their behalf.
+func Offer(ctx context.Context, s *xmpp.Session, c *caps.Handler, to jid.JID, d Data, jingle func(context.Context) error) error {
+	// If the recipient doesn't respond to disco#info we can still send the
+	// message, so ignore the error and fall back to the least preferred
+	// mechanism.
+	var info disco.Info
+	if c != nil {
+		/* #nosec */
+		info, _ = c.Info(ctx, s, to)
+	} else {
+		/* #nosec */
+		info, _ = disco.GetInfo(ctx, "", to, s)
+	}
+
+	if Select(info) == JingleMechanism && jingle != nil {
+		err := jingle(ctx)
+		if !unsupported(err) {
+			return err
+		}
+	}
+	if supports(info, NSQuery) {
+		err := s.UnmarshalIQ(ctx, IQ{
+			IQ: stanza.IQ{
+				To:   to,
+				Type: stanza.SetIQ,
+			},
+			Query: Query{
+				URL:  d.URL,
+				Desc: d.Desc,
+			},
+		}.TokenReader(), nil)
+		if !unsupported(err) {
+			return err
+		}
+	}
+
+	return s.Send(ctx, Message(stanza.Message{
+		To:   to,
+		Type: stanza.ChatMessage,
+	}, d))
+}
+
+// unsupported reports whether err indicates that the recipient does not
+// support the mechanism that was used to offer the file.
+func unsupported(err error) bool {
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) {
+		return false
+	}
+	return stanzaErr.Condition == stanza.ServiceUnavailable || stanzaErr.Condition == stanza.FeatureNotImplemented
+}
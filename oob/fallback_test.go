@@ -0,0 +1,260 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package oob_test
+
+import (
+	"context"
+	"crypto"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/caps"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	jinglefile "mellium.im/xmpp/jingle/file"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/oob"
+	"mellium.im/xmpp/stanza"
+)
+
+var selectTestCases = [...]struct {
+	features []string
+	m        oob.Mechanism
+}{
+	0: {},
+	1: {features: []string{oob.NS}},
+	2: {features: []string{oob.NSQuery}, m: oob.IQMechanism},
+	3: {features: []string{oob.NSQuery, jinglefile.NS}, m: oob.JingleMechanism},
+	4: {features: []string{jinglefile.NS, oob.NSQuery}, m: oob.JingleMechanism},
+}
+
+func TestSelect(t *testing.T) {
+	for i, tc := range selectTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var info disco.Info
+			for _, f := range tc.features {
+				info.Features = append(info.Features, disco.Feature{Var: f})
+			}
+			if m := oob.Select(info); m != tc.m {
+				t.Errorf("wrong mechanism: want=%d, got=%d", tc.m, m)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	var b strings.Builder
+	e := xml.NewEncoder(&b)
+	_, err := xmlstream.Copy(e, oob.Message(stanza.Message{
+		To:   jid.MustParse("feste@example.net"),
+		Type: stanza.ChatMessage,
+	}, oob.Data{URL: "url", Desc: "desc"}))
+	if err != nil {
+		t.Fatalf("error encoding message: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing message: %v", err)
+	}
+	const expected = `<message type="chat" to="feste@example.net"><body>url</body><x xmlns="jabber:x:oob"><url>url</url><desc>desc</desc></x></message>`
+	if out := b.String(); out != expected {
+		t.Errorf("wrong encoding:\nwant=%s,\n got=%s", expected, out)
+	}
+}
+
+func TestOfferCaps(t *testing.T) {
+	info := disco.Info{
+		Identity: []disco.Identity{{Category: "client", Type: "pc", Name: "Feste"}},
+		Features: []disco.Feature{{Var: disco.NSInfo}, {Var: oob.NSQuery}},
+	}
+	ver, err := caps.Ver(crypto.SHA1, info)
+	if err != nil {
+		t.Fatalf("error calculating caps: %v", err)
+	}
+	const node = "https://example.net/feste"
+
+	queried := make(chan string, 2)
+	offered := make(chan string, 1)
+	changed := make(chan jid.JID, 1)
+	h := &caps.Handler{
+		Changed: func(from jid.JID) {
+			changed <- from
+		},
+	}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(caps.Handle(h))),
+		xmpptest.ServerHandler(mux.New(
+			mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				resp := info
+				for _, attr := range start.Attr {
+					if attr.Name.Local == "node" {
+						resp.Node = attr.Value
+					}
+				}
+				queried <- resp.Node
+				_, err := xmlstream.Copy(t, iq.Result(resp.TokenReader()))
+				return err
+			}),
+			mux.IQFunc(stanza.SetIQ, xml.Name{Space: oob.NSQuery, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				var q oob.Query
+				err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&q)
+				if err != nil {
+					return err
+				}
+				offered <- q.URL
+				_, err = xmlstream.Copy(t, iq.Result(nil))
+				return err
+			}),
+		)),
+	)
+	defer cs.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	to := jid.MustParse("feste@example.net/phone")
+	err = cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(`<presence xmlns="jabber:client" from="`+to.String()+`"><c xmlns="http://jabber.org/protocol/caps" hash="sha-1" node="`+node+`" ver="`+ver+`"/></presence>`)))
+	if err != nil {
+		t.Fatalf("error sending presence: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for caps")
+	}
+
+	err = oob.Offer(ctx, cs.Client, h, to, oob.Data{URL: "https://example.net/file"}, nil)
+	if err != nil {
+		t.Fatalf("error offering file: %v", err)
+	}
+	if q := <-queried; q != node+"#"+ver {
+		t.Errorf("expected caps node to be queried, got %q", q)
+	}
+	select {
+	case url := <-offered:
+		if url != "https://example.net/file" {
+			t.Errorf("wrong URL offered: %s", url)
+		}
+	default:
+		t.Errorf("expected file to be offered using an IQ")
+	}
+}
+
+var errDeclined = errors.New("declined")
+
+var offerFallbackTestCases = [...]struct {
+	jingle  error
+	iq      stanza.Condition
+	err     error
+	offered bool
+	message bool
+}{
+	0: {
+		jingle:  stanza.Error{Type: stanza.Cancel, Condition: stanza.FeatureNotImplemented},
+		offered: true,
+	},
+	1: {
+		jingle:  stanza.Error{Type: stanza.Cancel, Condition: stanza.ServiceUnavailable},
+		iq:      stanza.FeatureNotImplemented,
+		offered: true,
+		message: true,
+	},
+	2: {
+		jingle:  stanza.Error{Type: stanza.Cancel, Condition: stanza.ServiceUnavailable},
+		iq:      stanza.NotAcceptable,
+		err:     stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAcceptable},
+		offered: true,
+	},
+	3: {
+		jingle: errDeclined,
+		err:    errDeclined,
+	},
+	4: {
+		jingle: stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAcceptable},
+		err:    stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAcceptable},
+	},
+}
+
+func TestOfferFallback(t *testing.T) {
+	info := disco.Info{
+		Features: []disco.Feature{{Var: disco.NSInfo}, {Var: oob.NSQuery}, {Var: jinglefile.NS}},
+	}
+	for i, tc := range offerFallbackTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			offered := make(chan struct{}, 1)
+			messages := make(chan struct{}, 1)
+			cs := xmpptest.NewClientServer(
+				xmpptest.ServerHandler(mux.New(
+					mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+						_, err := xmlstream.Copy(t, iq.Result(info.TokenReader()))
+						return err
+					}),
+					mux.IQFunc(stanza.SetIQ, xml.Name{Space: oob.NSQuery, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+						offered <- struct{}{}
+						if tc.iq != "" {
+							_, err := xmlstream.Copy(t, iq.Error(stanza.Error{Type: stanza.Cancel, Condition: tc.iq}))
+							return err
+						}
+						_, err := xmlstream.Copy(t, iq.Result(nil))
+						return err
+					}),
+					mux.MessageFunc(stanza.ChatMessage, xml.Name{Local: "body"}, func(stanza.Message, xmlstream.TokenReadEncoder) error {
+						messages <- struct{}{}
+						return nil
+					}),
+				)),
+			)
+			defer cs.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := oob.Offer(ctx, cs.Client, nil, jid.MustParse("feste@example.net/phone"), oob.Data{URL: "https://example.net/file"}, func(context.Context) error {
+				return tc.jingle
+			})
+			var stanzaErr stanza.Error
+			switch {
+			case tc.err == nil && err != nil:
+				t.Fatalf("unexpected error offering file: %v", err)
+			case tc.err == errDeclined && err != errDeclined:
+				t.Fatalf("wrong error: want=%v, got=%v", tc.err, err)
+			case tc.err != nil && tc.err != errDeclined && (!errors.As(err, &stanzaErr) || stanzaErr.Condition != tc.err.(stanza.Error).Condition):
+				t.Fatalf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			if tc.message {
+				select {
+				case <-messages:
+				case <-ctx.Done():
+					t.Fatalf("expected fallback message to be sent")
+				}
+			}
+			// Make sure anything else that was sent has been handled before checking
+			// that it was not sent.
+			_, err = disco.GetInfo(ctx, "", jid.JID{}, cs.Client)
+			if err != nil {
+				t.Fatalf("error flushing stream: %v", err)
+			}
+			select {
+			case <-messages:
+				t.Errorf("fallback message sent after mechanism failed")
+			default:
+			}
+			select {
+			case <-offered:
+				if !tc.offered {
+					t.Errorf("file offered using IQ after mechanism failed")
+				}
+			default:
+				if tc.offered {
+					t.Errorf("expected file to be offered using an IQ")
+				}
+			}
+		})
+	}
+}
@@ -99,3 +99,12 @@ func getPayload(url, desc string) xml.TokenReader {
 
 	return payload
 }
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "x"}, func() interface{} {
+		return &Data{}
+	})
+	stanza.DefaultRegistry.Register(xml.Name{Space: NSQuery, Local: "query"}, func() interface{} {
+		return &Query{}
+	})
+}
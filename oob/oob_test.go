@@ -6,6 +6,7 @@ package oob_test
 
 import (
 	"encoding/xml"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -136,3 +137,37 @@ func TestEncodeQuery(t *testing.T) {
 		}
 	})
 }
+
+func TestDecodeMessage(t *testing.T) {
+	const in = `<message xmlns="jabber:client" to="romeo@example.net"><body>https://example.net/r.jpg</body><x xmlns="jabber:x:oob"><url>https://example.net/r.jpg</url><desc>Balcony</desc></x></message>`
+	var msg struct {
+		stanza.Message
+		Data oob.Data
+	}
+	err := xml.Unmarshal([]byte(in), &msg)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if msg.Data.URL != "https://example.net/r.jpg" || msg.Data.Desc != "Balcony" {
+		t.Errorf("wrong data decoded: %+v", msg.Data)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><x xmlns="jabber:x:oob"><url>https://example.net/</url></x></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		&oob.Data{XMLName: xml.Name{Space: oob.NS, Local: "x"}, URL: "https://example.net/"},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
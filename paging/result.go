@@ -0,0 +1,194 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package paging
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+// DecodeFunc decodes a single result from the start element of a child of the
+// response payload and a reader over the remainder of the child.
+// If it returns a nil result and no error the child is skipped, which allows
+// unknown elements in the response to be ignored.
+type DecodeFunc func(start xml.StartElement, r xml.TokenReader) (interface{}, error)
+
+// FetchFunc queries for a page of results and returns an iterator over the
+// children of the response payload.
+// The first time it is called next is nil and the first page should be
+// requested.
+// Subsequent calls should request the page described by next.
+// Queries that do not support paging may ignore next since they will only be
+// called again if the response contained paging information.
+type FetchFunc func(ctx context.Context, next *RequestNext) (*Iter, error)
+
+// PageFunc queries for the provided page of results and returns an iterator
+// over the children of the response payload.
+type PageFunc func(ctx context.Context, page *Page) (*Iter, error)
+
+// ResultIter is an iterator over the results of queries that return a list of
+// elements such as roster items, service discovery items, or pubsub items.
+//
+// Each child of the response payload is decoded using the DecodeFunc that the
+// iterator was created with.
+// If the response contains paging information, the next page is requested
+// automatically when the current page is exhausted.
+// If the context that the iterator was created with is canceled, iteration
+// stops and Err returns the context's error.
+//
+// Because the results are read directly from the stream, the iterator must be
+// closed before anything else is done on the session or it will become
+// invalid.
+type ResultIter struct {
+	ctx     context.Context
+	fetch   PageFunc
+	decode  DecodeFunc
+	reverse bool
+	iter    *Iter
+	current interface{}
+	page    uint64
+	err     error
+}
+
+// NewResultIter calls fetch to get the first page of results and returns an
+// iterator over the results.
+// Any errors encountered while fetching the first page are deferred until the
+// iterator is used.
+func NewResultIter(ctx context.Context, fetch FetchFunc, decode DecodeFunc) *ResultIter {
+	i := &ResultIter{
+		ctx: ctx,
+		fetch: func(ctx context.Context, page *Page) (*Iter, error) {
+			if page == nil {
+				return fetch(ctx, nil)
+			}
+			return fetch(ctx, &RequestNext{Max: page.Max, After: page.After})
+		},
+		decode: decode,
+	}
+	i.iter, i.err = fetch(ctx, nil)
+	return i
+}
+
+// NewPageIter calls fetch to get the page of results described by first and
+// returns an iterator over the results.
+// If first.Reverse is true, the iterator walks backward through the result set
+// by requesting the previous page each time the current page is exhausted.
+// The order of the results within each page is not changed so, for example, a
+// reverse iterator over a message archive returns the most recent page of
+// messages in chronological order followed by the page before it.
+// Any errors encountered while fetching the first page are deferred until the
+// iterator is used.
+func NewPageIter(ctx context.Context, first Page, fetch PageFunc, decode DecodeFunc) *ResultIter {
+	i := &ResultIter{
+		ctx:     ctx,
+		fetch:   fetch,
+		decode:  decode,
+		reverse: first.Reverse,
+	}
+	i.iter, i.err = fetch(ctx, &first)
+	return i
+}
+
+// Next returns true if there are more results to decode.
+func (i *ResultIter) Next() bool {
+	for {
+		if i.err != nil || i.iter == nil {
+			return false
+		}
+		if err := i.ctx.Err(); err != nil {
+			i.err = err
+			return false
+		}
+		if i.iter.Next() {
+			start, r := i.iter.Current()
+			// If we encounter a lone token that doesn't begin with a start element
+			// (eg. a comment) skip it. This should never happen with XMPP, but we
+			// don't want to panic in case this somehow happens so just skip it.
+			if start == nil {
+				continue
+			}
+			current, err := i.decode(*start, r)
+			if err != nil {
+				i.err = err
+				return false
+			}
+			if current == nil {
+				continue
+			}
+			i.current = current
+			i.page++
+			return true
+		}
+		if i.iter.Err() != nil || !i.morePages() {
+			return false
+		}
+
+		// Turn the page.
+		var page *Page
+		if i.reverse {
+			prev := i.iter.PreviousPage()
+			page = &Page{Max: prev.Max, Before: prev.Before, Reverse: true}
+		} else {
+			next := i.iter.NextPage()
+			page = &Page{Max: next.Max, After: next.After}
+		}
+		i.err = i.iter.Close()
+		if i.err != nil {
+			return false
+		}
+		i.page = 0
+		i.iter, i.err = i.fetch(i.ctx, page)
+	}
+}
+
+// morePages reports whether the current page indicates that there are more
+// results to fetch.
+func (i *ResultIter) morePages() bool {
+	// An empty page means we've reached the end, even if the other side returned
+	// information that says otherwise.
+	if i.page == 0 {
+		return false
+	}
+	set := i.iter.CurrentPage()
+	if i.reverse {
+		if i.iter.PreviousPage() == nil {
+			return false
+		}
+		return set == nil || set.First.Index == nil || *set.First.Index > 0
+	}
+	if i.iter.NextPage() == nil {
+		return false
+	}
+	if set != nil && set.Count != nil && set.First.Index != nil {
+		return *set.First.Index+i.page < *set.Count
+	}
+	return true
+}
+
+// Current returns the last result decoded by the iterator.
+func (i *ResultIter) Current() interface{} {
+	return i.current
+}
+
+// Err returns the last error encountered by the iterator (if any).
+func (i *ResultIter) Err() error {
+	if i.err != nil {
+		return i.err
+	}
+	if i.iter == nil {
+		return nil
+	}
+	return i.iter.Err()
+}
+
+// Close indicates that we are finished with the given iterator and processing
+// the stream may continue.
+// Calling it multiple times has no effect.
+func (i *ResultIter) Close() error {
+	if i.iter == nil {
+		return nil
+	}
+	return i.iter.Close()
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package paging_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/paging"
+)
+
+var resultIterTests = [...]struct {
+	pages   map[string]string
+	out     []string
+	fetches []string
+	err     error
+}{
+	0: {
+		pages:   map[string]string{"": `<query></query>`},
+		fetches: []string{""},
+	},
+	1: {
+		pages:   map[string]string{"": `<query><item>a</item><!-- comment --><item>b</item></query>`},
+		out:     []string{"a", "b"},
+		fetches: []string{""},
+	},
+	2: {
+		pages: map[string]string{
+			"":  `<query><item>a</item><item>b</item><set xmlns="http://jabber.org/protocol/rsm"><first>a</first><last>b</last></set></query>`,
+			"b": `<query><item>c</item><set xmlns="http://jabber.org/protocol/rsm"><first>c</first><last>c</last></set></query>`,
+			"c": `<query><set xmlns="http://jabber.org/protocol/rsm"/></query>`,
+		},
+		out:     []string{"a", "b", "c"},
+		fetches: []string{"", "b", "c"},
+	},
+	3: {
+		pages: map[string]string{
+			"":  `<query><item>a</item><item>b</item><set xmlns="http://jabber.org/protocol/rsm"><first index="0">a</first><last>b</last><count>3</count></set></query>`,
+			"b": `<query><item>c</item><set xmlns="http://jabber.org/protocol/rsm"><first index="2">c</first><last>c</last><count>3</count></set></query>`,
+		},
+		out:     []string{"a", "b", "c"},
+		fetches: []string{"", "b"},
+	},
+	4: {
+		pages: map[string]string{
+			"": `<query><item>a</item><set xmlns="http://jabber.org/protocol/rsm"><first>a</first><last>a</last></set></query>`,
+		},
+		out:     []string{"a"},
+		fetches: []string{"", "a"},
+		err:     errors.New("no such page"),
+	},
+}
+
+func TestResultIter(t *testing.T) {
+	for i, tc := range resultIterTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var fetches []string
+			iter := paging.NewResultIter(context.Background(),
+				func(_ context.Context, next *paging.RequestNext) (*paging.Iter, error) {
+					var after string
+					if next != nil {
+						after = next.After
+					}
+					fetches = append(fetches, after)
+					page, ok := tc.pages[after]
+					if !ok {
+						return nil, tc.err
+					}
+					d := xml.NewDecoder(strings.NewReader(page))
+					_, err := d.Token()
+					if err != nil {
+						return nil, err
+					}
+					return paging.NewIter(d, 2), nil
+				},
+				func(start xml.StartElement, r xml.TokenReader) (interface{}, error) {
+					var s string
+					err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(start), r)).Decode(&s)
+					return s, err
+				},
+			)
+			var out []string
+			for iter.Next() {
+				out = append(out, iter.Current().(string))
+			}
+			if err := iter.Err(); err != tc.err {
+				t.Errorf("wrong error: want=%v, got=%v", tc.err, err)
+			}
+			if err := iter.Close(); err != nil {
+				t.Errorf("error closing iter: %v", err)
+			}
+			if !reflect.DeepEqual(out, tc.out) {
+				t.Errorf("wrong results: want=%v, got=%v", tc.out, out)
+			}
+			if !reflect.DeepEqual(fetches, tc.fetches) {
+				t.Errorf("wrong fetches: want=%q, got=%q", tc.fetches, fetches)
+			}
+		})
+	}
+}
+
+func TestResultIterCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	iter := paging.NewResultIter(ctx,
+		func(context.Context, *paging.RequestNext) (*paging.Iter, error) {
+			d := xml.NewDecoder(strings.NewReader(`<query><item/></query>`))
+			_, err := d.Token()
+			return paging.NewIter(d, 0), err
+		},
+		func(xml.StartElement, xml.TokenReader) (interface{}, error) {
+			return nil, nil
+		},
+	)
+	cancel()
+	if iter.Next() {
+		t.Errorf("expected iteration to stop after context was canceled")
+	}
+	if err := iter.Err(); err != context.Canceled {
+		t.Errorf("wrong error: want=%v, got=%v", context.Canceled, err)
+	}
+}
+
+func TestPageIterReverse(t *testing.T) {
+	pages := map[string]string{
+		"":  `<query><item>c</item><item>d</item><set xmlns="http://jabber.org/protocol/rsm"><first index="2">c</first><last>d</last><count>4</count></set></query>`,
+		"c": `<query><item>a</item><item>b</item><set xmlns="http://jabber.org/protocol/rsm"><first index="0">a</first><last>b</last><count>4</count></set></query>`,
+	}
+	var fetches []string
+	iter := paging.NewPageIter(context.Background(), paging.Page{Max: 2, Reverse: true},
+		func(_ context.Context, page *paging.Page) (*paging.Iter, error) {
+			if !page.Reverse || page.Max != 2 {
+				t.Errorf("wrong page requested: %+v", page)
+			}
+			fetches = append(fetches, page.Before)
+			d := xml.NewDecoder(strings.NewReader(pages[page.Before]))
+			_, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			return paging.NewIter(d, page.Max), nil
+		},
+		func(start xml.StartElement, r xml.TokenReader) (interface{}, error) {
+			var s string
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(start), r)).Decode(&s)
+			return s, err
+		},
+	)
+	var out []string
+	for iter.Next() {
+		out = append(out, iter.Current().(string))
+	}
+	if err := iter.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Errorf("error closing iter: %v", err)
+	}
+	if want := []string{"c", "d", "a", "b"}; !reflect.DeepEqual(out, want) {
+		t.Errorf("wrong results: want=%v, got=%v", want, out)
+	}
+	if want := []string{"", "c"}; !reflect.DeepEqual(fetches, want) {
+		t.Errorf("wrong fetches: want=%q, got=%q", want, fetches)
+	}
+}
+
+func TestPage(t *testing.T) {
+	index := uint64(10)
+	for i, tc := range []struct {
+		page paging.Page
+		out  string
+	}{
+		0: {out: `<set xmlns="http://jabber.org/protocol/rsm"></set>`},
+		1: {
+			page: paging.Page{Max: 5, After: "a"},
+			out:  `<set xmlns="http://jabber.org/protocol/rsm"><max>5</max><after>a</after></set>`,
+		},
+		2: {
+			page: paging.Page{Max: 5, After: "a", Reverse: true},
+			out:  `<set xmlns="http://jabber.org/protocol/rsm"><max>5</max><before></before></set>`,
+		},
+		3: {
+			page: paging.Page{Before: "b", Reverse: true},
+			out:  `<set xmlns="http://jabber.org/protocol/rsm"><before>b</before></set>`,
+		},
+		4: {
+			page: paging.Page{Max: 5, Index: &index},
+			out:  `<set xmlns="http://jabber.org/protocol/rsm"><max>5</max><index>10</index></set>`,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out, err := xml.Marshal(&tc.page)
+			if err != nil {
+				t.Fatalf("error marshaling page: %v", err)
+			}
+			if string(out) != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestResultIterSkip(t *testing.T) {
+	iter := paging.NewResultIter(context.Background(),
+		func(context.Context, *paging.RequestNext) (*paging.Iter, error) {
+			d := xml.NewDecoder(strings.NewReader(`<query><item>a</item><unknown/><item>b</item></query>`))
+			_, err := d.Token()
+			return paging.NewIter(d, 0), err
+		},
+		func(start xml.StartElement, r xml.TokenReader) (interface{}, error) {
+			if start.Name.Local != "item" {
+				return nil, nil
+			}
+			var s string
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(start), r)).Decode(&s)
+			return s, err
+		},
+	)
+	var out []string
+	for iter.Next() {
+		out = append(out, iter.Current().(string))
+	}
+	if err := iter.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(out, want) {
+		t.Errorf("wrong results: want=%v, got=%v", want, out)
+	}
+}
@@ -30,6 +30,9 @@ var (
 	_ xmlstream.Marshaler = (*paging.Set)(nil)
 	_ xmlstream.WriterTo  = (*paging.Set)(nil)
 	_ xml.Marshaler       = (*paging.Set)(nil)
+	_ xmlstream.Marshaler = (*paging.Page)(nil)
+	_ xmlstream.WriterTo  = (*paging.Page)(nil)
+	_ xml.Marshaler       = (*paging.Page)(nil)
 )
 
 var iterTests = [...]struct {
@@ -142,3 +145,18 @@ func TestIter(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshalFirstIndex(t *testing.T) {
+	const in = `<set xmlns="http://jabber.org/protocol/rsm"><first index="10">a</first><last>b</last><count>20</count></set>`
+	var set paging.Set
+	err := xml.Unmarshal([]byte(in), &set)
+	if err != nil {
+		t.Fatalf("error unmarshaling set: %v", err)
+	}
+	if set.First.ID != "a" {
+		t.Errorf("wrong first ID: want=a, got=%q", set.First.ID)
+	}
+	if set.First.Index == nil || *set.First.Index != 10 {
+		t.Errorf("wrong first index: want=10, got=%v", set.First.Index)
+	}
+}
@@ -150,12 +150,72 @@ func (req *RequestIndex) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
 	return err
 }
 
+// Page can be added to a query to request any page.
+// It combines RequestNext, RequestPrev, and RequestIndex and is used by
+// iterators that can page in either direction.
+//
+// If Reverse is true, the page before Before is requested, or the last page if
+// Before is empty.
+// Otherwise the page after After is requested, or the first page if After is
+// empty.
+// If Index is not nil the page starting at that index is requested instead.
+type Page struct {
+	Max     uint64
+	Before  string
+	After   string
+	Index   *uint64
+	Reverse bool
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (p *Page) TokenReader() xml.TokenReader {
+	var payloads []xml.TokenReader
+	if p.Max > 0 {
+		payloads = append(payloads, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(strconv.FormatUint(p.Max, 10))),
+			xml.StartElement{Name: xml.Name{Local: "max"}},
+		))
+	}
+	switch {
+	case p.Index != nil:
+		payloads = append(payloads, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(strconv.FormatUint(*p.Index, 10))),
+			xml.StartElement{Name: xml.Name{Local: "index"}},
+		))
+	case p.Reverse:
+		payloads = append(payloads, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(p.Before)),
+			xml.StartElement{Name: xml.Name{Local: "before"}},
+		))
+	case p.After != "":
+		payloads = append(payloads, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(p.After)),
+			xml.StartElement{Name: xml.Name{Local: "after"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(payloads...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "set"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (p *Page) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, p.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (p *Page) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := p.WriteXML(e)
+	return err
+}
+
 // Set describes a page from a returned result set.
 type Set struct {
 	XMLName xml.Name `xml:"http://jabber.org/protocol/rsm set"`
 	First   struct {
 		ID    string  `xml:",cdata"`
-		Index *uint64 `xml:"index,omitempty"`
+		Index *uint64 `xml:"index,attr,omitempty"`
 	} `xml:"first"`
 	Last  string  `xml:"last"`
 	Count *uint64 `xml:"count,omitempty"`
@@ -0,0 +1,348 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package pie implements the portable import/export format for server data.
+//
+// The format is defined in XEP-0227 and is supported by several servers
+// including Prosody and ejabberd.
+// It can be used to migrate accounts between servers by exporting them from
+// one server, decoding the export into a Server, and then storing the users,
+// rosters, offline messages, and personal eventing (PEP) nodes it contains
+// however the new server stores them (or the other way around to export
+// accounts).
+//
+// Elements in the export that are not understood by this package (for example,
+// vCards or private XML storage) are ignored when decoding.
+package pie // import "mellium.im/xmpp/pie"
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/marshal"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:pie:0"
+
+// Server is the root element of an export file.
+type Server struct {
+	XMLName xml.Name `xml:"urn:xmpp:pie:0 server-data"`
+	Hosts   []Host   `xml:"host"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (s Server) TokenReader() xml.TokenReader {
+	var hosts []xml.TokenReader
+	for _, h := range s.Hosts {
+		hosts = append(hosts, h.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(hosts...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "server-data"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (s Server) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// Host is a virtual host on the server and the users that belong to it.
+type Host struct {
+	JID   jid.JID `xml:"jid,attr"`
+	Users []User  `xml:"user"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (h Host) TokenReader() xml.TokenReader {
+	var users []xml.TokenReader
+	for _, u := range h.Users {
+		users = append(users, u.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(users...),
+		xml.StartElement{
+			Name: xml.Name{Local: "host"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: h.JID.String()}},
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (h Host) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, h.TokenReader())
+}
+
+// User is a single account and the data stored for it.
+type User struct {
+	// Name is the localpart of the users JID.
+	Name string
+
+	// Password is the users plaintext password.
+	// Many servers only store hashed credentials and will leave it empty.
+	Password string
+
+	Roster  []roster.Item
+	Offline []Message
+	PEP     []Node
+}
+
+// Message is an offline message that is waiting to be delivered to a user.
+type Message struct {
+	stanza.Message
+
+	// Payload is the raw XML of the children of the message.
+	Payload []byte
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (m *Message) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	msg, err := stanza.NewMessage(start)
+	if err != nil {
+		return err
+	}
+	m.Message = msg
+	m.Payload, err = innerXML(d)
+	return err
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (m Message) TokenReader() xml.TokenReader {
+	msg := m.Message
+	if msg.XMLName.Space == "" {
+		msg.XMLName.Space = ns.Client
+	}
+	// Messages without a type are normal messages, but the stanza package always
+	// writes the type attribute so make it explicit.
+	if msg.Type == "" {
+		msg.Type = stanza.NormalMessage
+	}
+	return msg.Wrap(rawReader(m.Payload))
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (m Message) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, m.TokenReader())
+}
+
+// Node is a PEP node owned by a user.
+type Node struct {
+	Name string
+
+	// Config is the configuration of the node.
+	// If it is nil, the node is created with the servers default configuration
+	// on import.
+	// If any required fields are not set, encoding the user that owns the node
+	// fails with an error wrapping form.ErrRequired.
+	Config *form.Data
+
+	Items []Item
+}
+
+// Item is an item published to a PEP node.
+type Item struct {
+	ID string
+
+	// Payload is the raw XML of the children of the item.
+	Payload []byte
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (i *Item) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	_, i.ID = attr.Get(start.Attr, "id")
+	var err error
+	i.Payload, err = innerXML(d)
+	return err
+}
+
+type pepConfig struct {
+	Node string     `xml:"node,attr"`
+	Form *form.Data `xml:"jabber:x:data x"`
+}
+
+type pepItems struct {
+	Node string `xml:"node,attr"`
+	Item []Item `xml:"item"`
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (u *User) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Name     string `xml:"name,attr"`
+		Password string `xml:"password,attr"`
+		Roster   struct {
+			Items []roster.Item `xml:"item"`
+		} `xml:"jabber:iq:roster query"`
+		Offline struct {
+			Messages []Message `xml:"jabber:client message"`
+		} `xml:"offline-messages"`
+		Owner []struct {
+			Configure []pepConfig `xml:"configure"`
+		} `xml:"http://jabber.org/protocol/pubsub#owner pubsub"`
+		Pubsub []struct {
+			Items []pepItems `xml:"items"`
+		} `xml:"http://jabber.org/protocol/pubsub pubsub"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+
+	u.Name = s.Name
+	u.Password = s.Password
+	u.Roster = s.Roster.Items
+	u.Offline = s.Offline.Messages
+	u.PEP = nil
+	nodes := make(map[string]int)
+	node := func(name string) *Node {
+		idx, ok := nodes[name]
+		if !ok {
+			idx = len(u.PEP)
+			nodes[name] = idx
+			u.PEP = append(u.PEP, Node{Name: name})
+		}
+		return &u.PEP[idx]
+	}
+	for _, owner := range s.Owner {
+		for _, cfg := range owner.Configure {
+			node(cfg.Node).Config = cfg.Form
+		}
+	}
+	for _, p := range s.Pubsub {
+		for _, items := range p.Items {
+			n := node(items.Node)
+			n.Items = append(n.Items, items.Item...)
+		}
+	}
+	return nil
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (u User) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "name"}, Value: u.Name}}
+	if u.Password != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "password"}, Value: u.Password})
+	}
+
+	var children []xml.TokenReader
+	if len(u.Roster) > 0 {
+		var items []xml.TokenReader
+		for _, item := range u.Roster {
+			items = append(items, item.TokenReader())
+		}
+		children = append(children, xmlstream.Wrap(
+			xmlstream.MultiReader(items...),
+			xml.StartElement{Name: xml.Name{Space: roster.NS, Local: "query"}},
+		))
+	}
+	if len(u.Offline) > 0 {
+		var msgs []xml.TokenReader
+		for _, msg := range u.Offline {
+			msgs = append(msgs, msg.TokenReader())
+		}
+		children = append(children, xmlstream.Wrap(
+			xmlstream.MultiReader(msgs...),
+			xml.StartElement{Name: xml.Name{Local: "offline-messages"}},
+		))
+	}
+
+	var configs, items []xml.TokenReader
+	for _, n := range u.PEP {
+		nodeAttr := []xml.Attr{{Name: xml.Name{Local: "node"}, Value: n.Name}}
+		if n.Config != nil {
+			submission, ok := n.Config.Submit()
+			if !ok {
+				submission = marshal.ErrReader(fmt.Errorf("pie: cannot export configuration of node %q: %w", n.Name, form.ErrRequired))
+			}
+			configs = append(configs, xmlstream.Wrap(
+				submission,
+				xml.StartElement{Name: xml.Name{Local: "configure"}, Attr: nodeAttr},
+			))
+		}
+		var pepItems []xml.TokenReader
+		for _, item := range n.Items {
+			pepItems = append(pepItems, xmlstream.Wrap(
+				rawReader(item.Payload),
+				xml.StartElement{
+					Name: xml.Name{Local: "item"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: item.ID}},
+				},
+			))
+		}
+		items = append(items, xmlstream.Wrap(
+			xmlstream.MultiReader(pepItems...),
+			xml.StartElement{Name: xml.Name{Local: "items"}, Attr: nodeAttr},
+		))
+	}
+	if len(configs) > 0 {
+		children = append(children, xmlstream.Wrap(
+			xmlstream.MultiReader(configs...),
+			xml.StartElement{Name: xml.Name{Space: pubsub.NSOwner, Local: "pubsub"}},
+		))
+	}
+	if len(items) > 0 {
+		children = append(children, xmlstream.Wrap(
+			xmlstream.MultiReader(items...),
+			xml.StartElement{Name: xml.Name{Space: pubsub.NS, Local: "pubsub"}},
+		))
+	}
+
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(children...),
+		xml.StartElement{Name: xml.Name{Local: "user"}, Attr: attrs},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (u User) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, u.TokenReader())
+}
+
+// removeNSAttr removes namespace declarations from elements that already have
+// a namespace.
+// Decoders report namespace declarations as attributes in addition to setting
+// the namespace of the element, so they must be removed before re-encoding the
+// tokens to prevent them from being written twice.
+var removeNSAttr = xmlstream.RemoveAttr(func(start xml.StartElement, attr xml.Attr) bool {
+	return attr.Name.Space == "" && attr.Name.Local == "xmlns" && start.Name.Space != ""
+})
+
+// rawReader decodes the raw XML p.
+func rawReader(p []byte) xml.TokenReader {
+	if len(p) == 0 {
+		return nil
+	}
+	return removeNSAttr(xml.NewDecoder(bytes.NewReader(p)))
+}
+
+// innerXML consumes the remainder of the current element from d and returns
+// the raw XML of its children.
+// Unlike the innerxml struct tag this works even if d was created with
+// xml.NewTokenDecoder.
+func innerXML(d *xml.Decoder) ([]byte, error) {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, removeNSAttr(xmlstream.Inner(d)))
+	if err != nil {
+		return nil, err
+	}
+	err = e.Flush()
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
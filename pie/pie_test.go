@@ -0,0 +1,115 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pie_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/pie"
+	"mellium.im/xmpp/stanza"
+)
+
+const export = `<server-data xmlns="urn:xmpp:pie:0">
+<host jid="capulet.lit">
+<user name="juliet" password="s3crEt">
+<query xmlns="jabber:iq:roster"><item jid="romeo@montague.lit" name="Romeo" subscription="both"><group>Friends</group></item></query>
+<offline-messages><message xmlns="jabber:client" from="romeo@montague.lit/orchard" to="juliet@capulet.lit" type="chat"><body>Neither, fair saint, if either thee dislike.</body></message></offline-messages>
+<vcard xmlns="vcard-temp"><FN>Juliet Capulet</FN></vcard>
+<pubsub xmlns="http://jabber.org/protocol/pubsub#owner"><configure node="urn:xmpp:microblog:0"><x xmlns="jabber:x:data" type="submit"><field var="FORM_TYPE" type="hidden"><value>http://jabber.org/protocol/pubsub#node_config</value></field><field var="pubsub#max_items"><value>10</value></field></x></configure></pubsub>
+<pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="urn:xmpp:microblog:0"><item id="1"><entry xmlns="http://www.w3.org/2005/Atom">Wherefore art thou</entry></item></items></pubsub>
+</user>
+</host>
+</server-data>`
+
+func checkServer(t *testing.T, s pie.Server) {
+	t.Helper()
+	if len(s.Hosts) != 1 || s.Hosts[0].JID.String() != "capulet.lit" || len(s.Hosts[0].Users) != 1 {
+		t.Fatalf("wrong hosts: %+v", s.Hosts)
+	}
+	u := s.Hosts[0].Users[0]
+	if u.Name != "juliet" || u.Password != "s3crEt" {
+		t.Errorf("wrong user: want=juliet:s3crEt, got=%s:%s", u.Name, u.Password)
+	}
+	if len(u.Roster) != 1 || u.Roster[0].Name != "Romeo" || u.Roster[0].Subscription != "both" || len(u.Roster[0].Group) != 1 {
+		t.Errorf("wrong roster: %+v", u.Roster)
+	}
+	if len(u.Offline) != 1 || u.Offline[0].Type != stanza.ChatMessage || u.Offline[0].From.String() != "romeo@montague.lit/orchard" {
+		t.Fatalf("wrong offline messages: %+v", u.Offline)
+	}
+	body := struct {
+		Body string `xml:"body"`
+	}{}
+	err := xml.Unmarshal([]byte("<message>"+string(u.Offline[0].Payload)+"</message>"), &body)
+	if err != nil {
+		t.Fatalf("error decoding offline message payload: %v", err)
+	}
+	if body.Body != "Neither, fair saint, if either thee dislike." {
+		t.Errorf("wrong offline message body: %q", body.Body)
+	}
+	if len(u.PEP) != 1 || u.PEP[0].Name != "urn:xmpp:microblog:0" || u.PEP[0].Config == nil {
+		t.Fatalf("wrong PEP nodes: %+v", u.PEP)
+	}
+	if maxItems, _ := u.PEP[0].Config.GetString("pubsub#max_items"); maxItems != "10" {
+		t.Errorf("wrong node config: want max items 10, got=%q", maxItems)
+	}
+	if items := u.PEP[0].Items; len(items) != 1 || items[0].ID != "1" || string(items[0].Payload) != `<entry xmlns="http://www.w3.org/2005/Atom">Wherefore art thou</entry>` {
+		t.Errorf("wrong PEP items: %+v", items)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var s pie.Server
+	err := xml.NewDecoder(strings.NewReader(export)).Decode(&s)
+	if err != nil {
+		t.Fatalf("error decoding export: %v", err)
+	}
+	checkServer(t, s)
+
+	var fromTokens pie.Server
+	err = xml.NewTokenDecoder(xml.NewDecoder(strings.NewReader(export))).Decode(&fromTokens)
+	if err != nil {
+		t.Fatalf("error decoding export from token stream: %v", err)
+	}
+	checkServer(t, fromTokens)
+
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err = s.WriteXML(e)
+	if err != nil {
+		t.Fatalf("error encoding export: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing export: %v", err)
+	}
+
+	var out pie.Server
+	err = xml.NewDecoder(&buf).Decode(&out)
+	if err != nil {
+		t.Fatalf("error decoding re-encoded export: %v\n%s", err, buf.String())
+	}
+	checkServer(t, out)
+}
+
+func TestRequiredConfig(t *testing.T) {
+	u := pie.User{
+		Name: "juliet",
+		PEP: []pie.Node{{
+			Name:   "urn:xmpp:microblog:0",
+			Config: form.New(form.Text("pubsub#title", form.Required)),
+		}},
+	}
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := u.WriteXML(e)
+	if !errors.Is(err, form.ErrRequired) {
+		t.Errorf("wrong error: want=%v, got=%v", form.ErrRequired, err)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"mellium.im/xmpp/internal/integration"
 	"mellium.im/xmpp/internal/integration/ejabberd"
 	"mellium.im/xmpp/internal/integration/mcabber"
+	"mellium.im/xmpp/internal/integration/openfire"
 	"mellium.im/xmpp/internal/integration/prosody"
 	"mellium.im/xmpp/internal/integration/sendxmpp"
 	"mellium.im/xmpp/mux"
@@ -39,6 +40,12 @@ func TestIntegrationSendPing(t *testing.T) {
 		ejabberd.ListenC2S(),
 	)
 	ejabberdRun(integrationSendPing)
+
+	openfireRun := openfire.Test(context.TODO(), t,
+		integration.Log(),
+		openfire.ListenC2S(),
+	)
+	openfireRun(integrationSendPing)
 }
 
 func integrationRecvPing(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
@@ -140,3 +147,35 @@ func integrationSendPing(ctx context.Context, t *testing.T, cmd *integration.Cmd
 		t.Errorf("error pinging: %v", err)
 	}
 }
+
+func BenchmarkIntegrationPing(b *testing.B) {
+	prosodyRun := prosody.Benchmark(context.TODO(), b,
+		prosody.ListenC2S(),
+	)
+	prosodyRun(func(ctx context.Context, b *testing.B, cmd *integration.Cmd) {
+		j, pass := cmd.User()
+		session, err := cmd.DialClient(ctx, j, b,
+			xmpp.StartTLS(&tls.Config{
+				InsecureSkipVerify: true,
+			}),
+			xmpp.SASL("", pass, sasl.Plain),
+			xmpp.BindResource(),
+		)
+		if err != nil {
+			b.Fatalf("error connecting: %v", err)
+		}
+		go func() {
+			err := session.Serve(nil)
+			if err != nil {
+				b.Logf("error from serve: %v", err)
+			}
+		}()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			err = ping.Send(ctx, session, session.RemoteAddr())
+			if err != nil {
+				b.Fatalf("error pinging: %v", err)
+			}
+		}
+	})
+}
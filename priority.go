@@ -0,0 +1,123 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"context"
+	"encoding/xml"
+	"sync"
+)
+
+// Priority is the class of an outgoing stanza.
+// When several goroutines are waiting to write to a session, the one sending
+// the stanza with the highest priority goes first.
+//
+// By default IQs (including pings) have the highest priority followed by
+// presence and then messages.
+// Writes that are not stanzas, such as stream errors or tokens written using
+// TokenWriter, are treated as IQs.
+// Large transfers (such as in-band bytestreams) should set BulkPriority using
+// WithPriority so that they do not delay other traffic.
+//
+// Priorities only affect the order in which waiting writes are performed.
+// A stanza that is being written is never interrupted, and a constant stream of
+// high priority stanzas may starve lower priority ones.
+type Priority uint8
+
+// A list of priorities from lowest to highest.
+const (
+	BulkPriority Priority = iota
+	MessagePriority
+	PresencePriority
+	IQPriority
+
+	numPriorities = int(IQPriority) + 1
+)
+
+type priorityCtxKey struct{}
+
+// WithPriority returns a context that overrides the priority of stanzas sent
+// using it.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	if p > IQPriority {
+		p = IQPriority
+	}
+	return context.WithValue(ctx, priorityCtxKey{}, p)
+}
+
+// priorityFor returns the priority set on ctx, or the default priority for a
+// stanza with the given name if none has been set.
+func priorityFor(ctx context.Context, name xml.Name) Priority {
+	if p, ok := ctx.Value(priorityCtxKey{}).(Priority); ok {
+		return p
+	}
+	switch name.Local {
+	case "message":
+		return MessagePriority
+	case "presence":
+		return PresencePriority
+	}
+	return IQPriority
+}
+
+// priorityLock is a mutex that hands off ownership to the waiter with the
+// highest priority when it is unlocked.
+// Waiters with the same priority are served in the order they arrived.
+// Like sync.Mutex it may be unlocked by a different goroutine than the one that
+// locked it.
+type priorityLock struct {
+	mu      sync.Mutex
+	locked  bool
+	waiters [numPriorities][]chan struct{}
+}
+
+// Lock locks l with the highest priority.
+func (l *priorityLock) Lock() {
+	l.lockPriority(IQPriority)
+}
+
+func (l *priorityLock) lockPriority(p Priority) {
+	l.mu.Lock()
+	if !l.locked {
+		l.locked = true
+		l.mu.Unlock()
+		return
+	}
+	c := make(chan struct{})
+	l.waiters[p] = append(l.waiters[p], c)
+	l.mu.Unlock()
+	// The lock is still held when we are woken up, ownership is transferred
+	// directly to us by Unlock.
+	<-c
+}
+
+// Unlock unlocks l or transfers ownership to the highest priority waiter.
+func (l *priorityLock) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.locked {
+		panic("xmpp: unlock of unlocked priority lock")
+	}
+	for p := numPriorities - 1; p >= 0; p-- {
+		if len(l.waiters[p]) > 0 {
+			c := l.waiters[p][0]
+			l.waiters[p][0] = nil
+			l.waiters[p] = l.waiters[p][1:]
+			close(c)
+			return
+		}
+	}
+	l.locked = false
+}
+
+// lockOut locks the output stream with the priority of a stanza with the given
+// name.
+func (s *Session) lockOut(ctx context.Context, name xml.Name) {
+	if l, ok := s.out.Locker.(*priorityLock); ok {
+		l.lockPriority(priorityFor(ctx, name))
+		return
+	}
+	s.out.Lock()
+}
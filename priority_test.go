@@ -0,0 +1,92 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestPriorityLock(t *testing.T) {
+	l := &xmpp.PriorityLock{}
+	l.Lock()
+
+	order := make(chan xmpp.Priority, 4)
+	for i, p := range []xmpp.Priority{xmpp.BulkPriority, xmpp.MessagePriority, xmpp.IQPriority, xmpp.MessagePriority} {
+		go func(p xmpp.Priority) {
+			l.LockPriority(p)
+			order <- p
+			l.Unlock()
+		}(p)
+		// Make sure the goroutines queue up in order.
+		for l.Waiting() != i+1 {
+			runtime.Gosched()
+		}
+	}
+	l.Unlock()
+
+	var got []xmpp.Priority
+	for i := 0; i < 4; i++ {
+		got = append(got, <-order)
+	}
+	want := []xmpp.Priority{xmpp.IQPriority, xmpp.MessagePriority, xmpp.MessagePriority, xmpp.BulkPriority}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong order: want=%v, got=%v", want, got)
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	// Make sure that sending still works with an explicit priority.
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	ctx := xmpp.WithPriority(context.Background(), xmpp.BulkPriority)
+	err := s.Send(ctx, stanza.Message{Type: stanza.NormalMessage}.Wrap(nil))
+	if err != nil {
+		t.Errorf("unexpected error sending with priority: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Errorf("nothing was written")
+	}
+}
+
+func TestEncodePriority(t *testing.T) {
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	l := s.OutLock()
+	l.Lock()
+
+	errs := make(chan error, 2)
+	for i, v := range []interface{}{
+		stanza.Message{Type: stanza.ChatMessage},
+		stanza.IQ{ID: "123", Type: stanza.ResultIQ},
+	} {
+		go func(v interface{}) {
+			errs <- s.Encode(context.Background(), v)
+		}(v)
+		for l.Waiting() != i+1 {
+			runtime.Gosched()
+		}
+	}
+	l.Unlock()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("error encoding: %v", err)
+		}
+	}
+
+	out := buf.String()
+	iq, msg := strings.Index(out, "<iq"), strings.Index(out, "<message")
+	if iq == -1 || msg == -1 || iq > msg {
+		t.Errorf("expected IQ to be written before the message, got: %s", out)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSEvent is the namespace used by event notifications.
+const NSEvent = NS + "#event"
+
+// Handle returns an option that registers a Handler for event notifications.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		name := xml.Name{Space: NSEvent, Local: "event"}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.HeadlineMessage} {
+			mux.Message(typ, name, h)(m)
+		}
+	}
+}
+
+// Handler unwraps event notifications and passes published and retracted
+// items to the appropriate function.
+// Notifications of other kinds (such as node deletion or configuration
+// changes) are ignored.
+type Handler struct {
+	// Item is called for each item published to a node.
+	// The payload is only valid until Item returns and will be empty if the node
+	// is configured not to deliver payloads.
+	Item func(from jid.JID, node, id string, payload xml.TokenReader) error
+
+	// Retract is called for each item that is removed from a node.
+	Retract func(from jid.JID, node, id string) error
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (h Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	// Pop the start message token
+	_, err := t.Token()
+	if err != nil {
+		return err
+	}
+
+	iter := xmlstream.NewIter(t)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, r := iter.Current()
+		if start == nil || start.Name.Space != NSEvent || start.Name.Local != "event" {
+			continue
+		}
+		err = h.handleEvent(msg.From, r)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (h Handler) handleEvent(from jid.JID, r xml.TokenReader) error {
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil || start.Name.Local != "items" {
+			continue
+		}
+		_, node := attr.Get(start.Attr, "node")
+		err := h.handleItems(from, node, inner)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (h Handler) handleItems(from jid.JID, node string, r xml.TokenReader) error {
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil {
+			continue
+		}
+		_, id := attr.Get(start.Attr, "id")
+		var err error
+		switch start.Name.Local {
+		case "item":
+			if h.Item != nil {
+				err = h.Item(from, node, id, xmlstream.Inner(inner))
+			}
+		case "retract":
+			if h.Retract != nil {
+				err = h.Retract(from, node, id)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
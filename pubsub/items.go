@@ -0,0 +1,183 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/paging"
+	"mellium.im/xmpp/stanza"
+)
+
+// Query selects items to fetch from a node.
+type Query struct {
+	Node string
+
+	// Item limits the results to items with the given IDs.
+	Item []string
+
+	// MaxItems limits the results to the most recent items.
+	// If it is zero, all items are requested.
+	MaxItems uint64
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (q Query) TokenReader() xml.TokenReader {
+	return q.tokenReader(nil)
+}
+
+// tokenReader returns the query followed by set, which may be nil or a request
+// for a page of results.
+func (q Query) tokenReader(set xml.TokenReader) xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Local: "items"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: q.Node}},
+	}
+	if q.MaxItems > 0 {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "max_items"},
+			Value: strconv.FormatUint(q.MaxItems, 10),
+		})
+	}
+	var items []xml.TokenReader
+	for _, id := range q.Item {
+		items = append(items, xmlstream.Wrap(nil, itemStart(id)))
+	}
+	payload := xmlstream.Wrap(xmlstream.MultiReader(items...), start)
+	if set != nil {
+		payload = xmlstream.MultiReader(payload, set)
+	}
+	return xmlstream.Wrap(
+		payload,
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (q Query) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, q.TokenReader())
+}
+
+// Iter is an iterator over items in a node.
+// Items are read from the response as they are needed instead of being
+// buffered.
+// If the node returns paged results, the next page is requested when the
+// current page is exhausted.
+type Iter struct {
+	*paging.ResultIter
+}
+
+type iterItem struct {
+	id   string
+	item xml.TokenReader
+}
+
+// Fetch requests items from a node and returns an iterator over the results.
+// Any errors encountered while creating the iter are deferred until the iter is
+// used.
+//
+// The iterator must be closed before anything else is done on the session or it
+// will become invalid.
+func Fetch(ctx context.Context, s *xmpp.Session, to jid.JID, q Query) *Iter {
+	return FetchIQ(ctx, s, stanza.IQ{To: to}, q)
+}
+
+// FetchIQ is like Fetch but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func FetchIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, q Query) *Iter {
+	iq.Type = stanza.GetIQ
+	first := true
+	return &Iter{
+		ResultIter: paging.NewResultIter(ctx,
+			func(ctx context.Context, next *paging.RequestNext) (*paging.Iter, error) {
+				// Only the first request may use the provided ID, subsequent pages
+				// are requested with new IQs.
+				if !first {
+					iq.ID = ""
+				}
+				first = false
+				var set xml.TokenReader
+				if next != nil {
+					set = next.TokenReader()
+				}
+				iter, err := s.IterIQElement(ctx, q.tokenReader(set), iq)
+				if err != nil {
+					return nil, err
+				}
+				return paging.NewIter(&itemsReader{outer: iter}, 0), nil
+			},
+			func(start xml.StartElement, r xml.TokenReader) (interface{}, error) {
+				if start.Name.Local != "item" {
+					return nil, nil
+				}
+				_, id := attr.Get(start.Attr, "id")
+				return iterItem{id: id, item: xmlstream.Inner(r)}, nil
+			},
+		),
+	}
+}
+
+// itemsReader flattens the children of a pubsub response so that the items
+// and the result set that is a sibling of the items element are read as a
+// single list.
+type itemsReader struct {
+	outer *xmlstream.Iter
+	cur   xml.TokenReader
+}
+
+func (r *itemsReader) Token() (xml.Token, error) {
+	for {
+		if r.cur != nil {
+			tok, err := r.cur.Token()
+			if err == io.EOF {
+				r.cur = nil
+				if tok == nil {
+					continue
+				}
+				err = nil
+			}
+			return tok, err
+		}
+		if !r.outer.Next() {
+			if err := r.outer.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		start, inner := r.outer.Current()
+		switch {
+		case start == nil:
+		case start.Name.Local == "items":
+			r.cur = xmlstream.Inner(inner)
+		default:
+			r.cur = xmlstream.MultiReader(xmlstream.Token(*start), inner)
+		}
+	}
+}
+
+// Close closes the underlying iterator.
+func (r *itemsReader) Close() error {
+	return r.outer.Close()
+}
+
+// ID returns the ID of the current item.
+func (i *Iter) ID() string {
+	item, _ := i.Current().(iterItem)
+	return item.id
+}
+
+// Item returns a reader over the payload of the current item.
+// It is only valid until the next call to Next.
+func (i *Iter) Item() xml.TokenReader {
+	item, _ := i.Current().(iterItem)
+	return item.item
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Publish publishes an item to a node on the provided pubsub service.
+// If id is empty, the service assigns an ID to the item.
+// The ID of the published item is returned.
+func Publish(ctx context.Context, s *xmpp.Session, to jid.JID, node, id string, payload xml.TokenReader) (string, error) {
+	return PublishIQ(ctx, s, stanza.IQ{To: to}, node, id, payload)
+}
+
+// PublishIQ is like Publish but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func PublishIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node, id string, payload xml.TokenReader) (string, error) {
+	return PublishWithOptionsIQ(ctx, s, iq, node, id, nil, payload)
+}
+
+// PublishWithOptions is like Publish except that it also includes publish
+// options.
+// Publish options are preconditions that the node's configuration must match
+// for the item to be published.
+// If the node does not exist it is created with a configuration that matches
+// the options.
+// If opts is nil, it behaves exactly like Publish.
+func PublishWithOptions(ctx context.Context, s *xmpp.Session, to jid.JID, node, id string, opts *form.Data, payload xml.TokenReader) (string, error) {
+	return PublishWithOptionsIQ(ctx, s, stanza.IQ{To: to}, node, id, opts, payload)
+}
+
+// PublishWithOptionsIQ is like PublishWithOptions but it allows you to
+// customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func PublishWithOptionsIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node, id string, opts *form.Data, payload xml.TokenReader) (string, error) {
+	iq.Type = stanza.SetIQ
+	resp := struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Publish struct {
+			Item struct {
+				ID string `xml:"id,attr"`
+			} `xml:"item"`
+		} `xml:"publish"`
+	}{}
+	inner := []xml.TokenReader{xmlstream.Wrap(
+		xmlstream.Wrap(payload, itemStart(id)),
+		xml.StartElement{
+			Name: xml.Name{Local: "publish"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+		},
+	)}
+	if opts != nil {
+		submission, ok := opts.Submit()
+		if !ok {
+			return "", fmt.Errorf("pubsub: cannot submit publish options: %w", form.ErrRequired)
+		}
+		inner = append(inner, xmlstream.Wrap(
+			submission,
+			xml.StartElement{Name: xml.Name{Local: "publish-options"}},
+		))
+	}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	), iq, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Publish.Item.ID != "" {
+		return resp.Publish.Item.ID, nil
+	}
+	return id, nil
+}
+
+// PublishOptions returns a form containing publish options that can be used
+// with PublishWithOptions.
+// The FORM_TYPE field is added automatically.
+func PublishOptions(f ...form.Field) *form.Data {
+	return form.New(append([]form.Field{
+		form.Hidden("FORM_TYPE", form.Value(NSPublishOptions)),
+	}, f...)...)
+}
+
+// Retract deletes an item from a node on the provided pubsub service.
+func Retract(ctx context.Context, s *xmpp.Session, to jid.JID, node, id string) error {
+	return RetractIQ(ctx, s, stanza.IQ{To: to}, node, id)
+}
+
+// RetractIQ is like Retract but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func RetractIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node, id string) error {
+	iq.Type = stanza.SetIQ
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Wrap(nil, itemStart(id)),
+			xml.StartElement{
+				Name: xml.Name{Local: "retract"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	), iq, nil)
+}
+
+func itemStart(id string) xml.StartElement {
+	start := xml.StartElement{Name: xml.Name{Local: "item"}}
+	if id != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "id"}, Value: id})
+	}
+	return start
+}
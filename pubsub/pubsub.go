@@ -0,0 +1,122 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package pubsub implements XEP-0060: Publish-Subscribe.
+package pubsub // import "mellium.im/xmpp/pubsub"
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS               = `http://jabber.org/protocol/pubsub`
+	NSOwner          = `http://jabber.org/protocol/pubsub#owner`
+	NSMetadata       = `http://jabber.org/protocol/pubsub#meta-data`
+	NSNodeConfig     = `http://jabber.org/protocol/pubsub#node_config`
+	NSPublishOptions = `http://jabber.org/protocol/pubsub#publish-options`
+)
+
+// FetchMetadata returns the metadata form for a node which is returned as part
+// of the nodes disco#info response.
+// If the node does not include metadata in its response, the form will be nil.
+func FetchMetadata(ctx context.Context, s *xmpp.Session, to jid.JID, node string) (*form.Data, error) {
+	info, err := disco.GetInfo(ctx, node, to, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range info.Form {
+		if f.FormType() == NSMetadata {
+			return f, nil
+		}
+	}
+	return nil, nil
+}
+
+// FetchDefaultConfig returns the default configuration form for new nodes
+// created on the provided pubsub service.
+// If to is the zero value, the default configuration of the users own PEP
+// service is requested.
+func FetchDefaultConfig(ctx context.Context, s *xmpp.Session, to jid.JID) (*form.Data, error) {
+	return FetchDefaultConfigIQ(ctx, s, stanza.IQ{To: to})
+}
+
+// FetchDefaultConfigIQ is like FetchDefaultConfig but it allows you to
+// customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func FetchDefaultConfigIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ) (*form.Data, error) {
+	iq.Type = stanza.GetIQ
+	resp := struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub#owner pubsub"`
+		Default struct {
+			Form form.Data `xml:"jabber:x:data x"`
+		} `xml:"default"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "default"}}),
+		xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "pubsub"}},
+	), iq, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Default.Form, nil
+}
+
+// CreateNode creates a new node on the provided pubsub service and configures
+// it in a single round trip.
+// If cfg is nil, the node is created with the services default configuration.
+// Normally cfg will be the result of FetchDefaultConfig after any values that
+// need to be changed have been set.
+// If node is empty an instant node is requested and the name of the node
+// assigned by the service is returned.
+func CreateNode(ctx context.Context, s *xmpp.Session, to jid.JID, node string, cfg *form.Data) (string, error) {
+	return CreateNodeIQ(ctx, s, stanza.IQ{To: to}, node, cfg)
+}
+
+// CreateNodeIQ is like CreateNode but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func CreateNodeIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node string, cfg *form.Data) (string, error) {
+	iq.Type = stanza.SetIQ
+	create := xml.StartElement{Name: xml.Name{Local: "create"}}
+	if node != "" {
+		create.Attr = append(create.Attr, xml.Attr{Name: xml.Name{Local: "node"}, Value: node})
+	}
+	payload := []xml.TokenReader{xmlstream.Wrap(nil, create)}
+	if cfg != nil {
+		submission, ok := cfg.Submit()
+		if !ok {
+			return "", fmt.Errorf("pubsub: cannot submit node configuration: %w", form.ErrRequired)
+		}
+		payload = append(payload, xmlstream.Wrap(
+			submission,
+			xml.StartElement{Name: xml.Name{Local: "configure"}},
+		))
+	}
+	resp := struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Create  struct {
+			Node string `xml:"node,attr"`
+		} `xml:"create"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.MultiReader(payload...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}},
+	), iq, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Create.Node != "" {
+		return resp.Create.Node, nil
+	}
+	return node, nil
+}
@@ -0,0 +1,341 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestFetchDefaultConfig(t *testing.T) {
+	m := mux.New(mux.IQFunc(stanza.GetIQ, xml.Name{Space: pubsub.NSOwner, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		d := xml.NewDecoder(strings.NewReader(`<pubsub xmlns="http://jabber.org/protocol/pubsub#owner"><default><x xmlns="jabber:x:data" type="form"><field var="pubsub#max_items"><value>10</value></field></x></default></pubsub>`))
+		_, err := xmlstream.Copy(t, iq.Result(d))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	cfg, err := pubsub.FetchDefaultConfig(context.Background(), cs.Client, cs.Server.LocalAddr())
+	if err != nil {
+		t.Fatalf("error fetching default config: %v", err)
+	}
+	maxItems, ok := cfg.GetString("pubsub#max_items")
+	if !ok || maxItems != "10" {
+		t.Errorf("wrong value for max items: want=10, got=%q (%t)", maxItems, ok)
+	}
+}
+
+func TestCreateNode(t *testing.T) {
+	var req struct {
+		Create struct {
+			Node *string `xml:"node,attr"`
+		} `xml:"create"`
+		Configure struct {
+			Form form.Data `xml:"jabber:x:data x"`
+		} `xml:"configure"`
+	}
+	m := mux.New(mux.IQFunc(stanza.SetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+		if err != nil {
+			return err
+		}
+		_, err = xmlstream.Copy(t, iq.Result(xmlstream.Wrap(
+			xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "create"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: "generated"}},
+			}),
+			xml.StartElement{Name: xml.Name{Space: pubsub.NS, Local: "pubsub"}},
+		)))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	cfg := form.New(form.Text("pubsub#max_items"))
+	_, err := cfg.Set("pubsub#max_items", "5")
+	if err != nil {
+		t.Fatalf("error setting form value: %v", err)
+	}
+	node, err := pubsub.CreateNode(context.Background(), cs.Client, cs.Server.LocalAddr(), "", cfg)
+	if err != nil {
+		t.Fatalf("error creating node: %v", err)
+	}
+	if node != "generated" {
+		t.Errorf("wrong node name: want=generated, got=%q", node)
+	}
+	if req.Create.Node != nil {
+		t.Errorf("expected instant node request, got node=%q", *req.Create.Node)
+	}
+	if maxItems, _ := req.Configure.Form.GetString("pubsub#max_items"); maxItems != "5" {
+		t.Errorf("wrong value for max items: want=5, got=%q", maxItems)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	var req struct {
+		Items struct {
+			Node     string `xml:"node,attr"`
+			MaxItems string `xml:"max_items,attr"`
+		} `xml:"items"`
+	}
+	m := mux.New(mux.IQFunc(stanza.GetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+		if err != nil {
+			return err
+		}
+		d := xml.NewDecoder(strings.NewReader(`<pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="princely_musings"><item id="1"><entry xmlns="http://www.w3.org/2005/Atom">one</entry></item><item id="2"><entry xmlns="http://www.w3.org/2005/Atom">two</entry></item></items></pubsub>`))
+		_, err = xmlstream.Copy(t, iq.Result(d))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	iter := pubsub.Fetch(context.Background(), cs.Client, cs.Server.LocalAddr(), pubsub.Query{
+		Node:     "princely_musings",
+		MaxItems: 2,
+	})
+	var ids, entries []string
+	for iter.Next() {
+		var entry string
+		err := xml.NewTokenDecoder(iter.Item()).Decode(&entry)
+		if err != nil {
+			t.Fatalf("error decoding item payload: %v", err)
+		}
+		ids = append(ids, iter.ID())
+		entries = append(entries, entry)
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over items: %v", err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatalf("error closing iter: %v", err)
+	}
+	if req.Items.Node != "princely_musings" || req.Items.MaxItems != "2" {
+		t.Errorf("wrong request: %+v", req)
+	}
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "2" {
+		t.Errorf("wrong item IDs: want=[1 2], got=%v", ids)
+	}
+	if len(entries) != 2 || entries[0] != "one" || entries[1] != "two" {
+		t.Errorf("wrong item payloads: want=[one two], got=%v", entries)
+	}
+}
+
+func TestFetchPaged(t *testing.T) {
+	pages := map[string]string{
+		"":  `<pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="princely_musings"><item id="1"/></items><set xmlns="http://jabber.org/protocol/rsm"><first index="0">1</first><last>1</last><count>2</count></set></pubsub>`,
+		"1": `<pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="princely_musings"><item id="2"/></items><set xmlns="http://jabber.org/protocol/rsm"><first index="1">2</first><last>2</last><count>2</count></set></pubsub>`,
+	}
+	var requests []string
+	m := mux.New(mux.IQFunc(stanza.GetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var req struct {
+			After string `xml:"http://jabber.org/protocol/rsm set>after"`
+		}
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, req.After)
+		_, err = xmlstream.Copy(t, iq.Result(xml.NewDecoder(strings.NewReader(pages[req.After]))))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	iter := pubsub.Fetch(context.Background(), cs.Client, cs.Server.LocalAddr(), pubsub.Query{
+		Node: "princely_musings",
+	})
+	var ids []string
+	for iter.Next() {
+		ids = append(ids, iter.ID())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over items: %v", err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatalf("error closing iter: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "2" {
+		t.Errorf("wrong item IDs: want=[1 2], got=%v", ids)
+	}
+	if len(requests) != 2 || requests[0] != "" || requests[1] != "1" {
+		t.Errorf("wrong pages requested: want=[\"\" 1], got=%q", requests)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var got []string
+	m := mux.New(mux.IQFunc(stanza.SetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		tok, err := t.Token()
+		if err != nil {
+			return err
+		}
+		payload := tok.(xml.StartElement)
+		got = append(got, payload.Name.Local)
+		if payload.Name.Local != "subscribe" {
+			_, err = xmlstream.Copy(t, iq.Result(nil))
+			return err
+		}
+		d := xml.NewDecoder(strings.NewReader(`<pubsub xmlns="http://jabber.org/protocol/pubsub"><subscription node="princely_musings" jid="francisco@denmark.lit" subid="ba49252aaa4f5d320c24d3766f0bdcade78c78d3" subscription="subscribed"/></pubsub>`))
+		_, err = xmlstream.Copy(t, iq.Result(d))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	ctx := context.Background()
+	j := cs.Client.LocalAddr().Bare()
+	sub, err := pubsub.Subscribe(ctx, cs.Client, cs.Server.LocalAddr(), "princely_musings", j)
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+	if sub.State != pubsub.SubSubscribed || sub.SubID != "ba49252aaa4f5d320c24d3766f0bdcade78c78d3" || sub.Node != "princely_musings" {
+		t.Errorf("wrong subscription: %+v", sub)
+	}
+	err = pubsub.Unsubscribe(ctx, cs.Client, cs.Server.LocalAddr(), "princely_musings", j, sub.SubID)
+	if err != nil {
+		t.Fatalf("error unsubscribing: %v", err)
+	}
+	if len(got) != 2 || got[0] != "subscribe" || got[1] != "unsubscribe" {
+		t.Errorf("wrong requests: want=[subscribe unsubscribe], got=%v", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	var got []string
+	m := mux.New(
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: pubsub.NSOwner, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			got = append(got, "get")
+			d := xml.NewDecoder(strings.NewReader(`<pubsub xmlns="http://jabber.org/protocol/pubsub#owner"><configure node="princely_musings"><x xmlns="jabber:x:data" type="form"><field var="pubsub#max_items"><value>10</value></field></x></configure></pubsub>`))
+			_, err := xmlstream.Copy(t, iq.Result(d))
+			return err
+		}),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: pubsub.NSOwner, Local: "pubsub"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			tok, err := t.Token()
+			if err != nil {
+				return err
+			}
+			got = append(got, tok.(xml.StartElement).Name.Local)
+			_, err = xmlstream.Copy(t, iq.Result(nil))
+			return err
+		}),
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	ctx := context.Background()
+	cfg, err := pubsub.FetchConfig(ctx, cs.Client, cs.Server.LocalAddr(), "princely_musings")
+	if err != nil {
+		t.Fatalf("error fetching config: %v", err)
+	}
+	if maxItems, _ := cfg.GetString("pubsub#max_items"); maxItems != "10" {
+		t.Errorf("wrong value for max items: want=10, got=%q", maxItems)
+	}
+	err = pubsub.SetConfig(ctx, cs.Client, cs.Server.LocalAddr(), "princely_musings", cfg)
+	if err != nil {
+		t.Fatalf("error setting config: %v", err)
+	}
+	err = pubsub.DeleteNode(ctx, cs.Client, cs.Server.LocalAddr(), "princely_musings")
+	if err != nil {
+		t.Fatalf("error deleting node: %v", err)
+	}
+	if len(got) != 3 || got[0] != "get" || got[1] != "configure" || got[2] != "delete" {
+		t.Errorf("wrong requests: want=[get configure delete], got=%v", got)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	type event struct {
+		node, id, payload string
+		retract           bool
+	}
+	events := make(chan event, 2)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(pubsub.Handle(pubsub.Handler{
+			Item: func(_ jid.JID, node, id string, payload xml.TokenReader) error {
+				var entry string
+				err := xml.NewTokenDecoder(payload).Decode(&entry)
+				events <- event{node: node, id: id, payload: entry}
+				return err
+			},
+			Retract: func(_ jid.JID, node, id string) error {
+				events <- event{node: node, id: id, retract: true}
+				return nil
+			},
+		}))),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(`<message xmlns="jabber:client" from="pubsub.shakespeare.lit" to="francisco@denmark.lit" type="headline"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="princely_musings"><item id="1"><entry xmlns="http://www.w3.org/2005/Atom">one</entry></item><retract id="2"/></items></event></message>`)))
+	if err != nil {
+		t.Fatalf("error sending event: %v", err)
+	}
+
+	for _, want := range []event{
+		{node: "princely_musings", id: "1", payload: "one"},
+		{node: "princely_musings", id: "2", retract: true},
+	} {
+		select {
+		case e := <-events:
+			if e != want {
+				t.Errorf("wrong event: want=%+v, got=%+v", want, e)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for event %+v", want)
+		}
+	}
+}
+
+// requiredForm returns a form with a required field that has not been set.
+func requiredForm(t *testing.T) *form.Data {
+	t.Helper()
+	var f form.Data
+	err := xml.NewDecoder(strings.NewReader(`<x xmlns="jabber:x:data" type="form"><field var="pubsub#title" type="text-single"><required/></field></x>`)).Decode(&f)
+	if err != nil {
+		t.Fatalf("error decoding form: %v", err)
+	}
+	return &f
+}
+
+var incompleteFormTestCases = map[string]func(context.Context, *xmpp.Session, *form.Data) error{
+	"CreateNode": func(ctx context.Context, s *xmpp.Session, f *form.Data) error {
+		_, err := pubsub.CreateNode(ctx, s, jid.MustParse("pubsub.example.net"), "princely_musings", f)
+		return err
+	},
+	"SetConfig": func(ctx context.Context, s *xmpp.Session, f *form.Data) error {
+		return pubsub.SetConfig(ctx, s, jid.MustParse("pubsub.example.net"), "princely_musings", f)
+	},
+	"PublishWithOptions": func(ctx context.Context, s *xmpp.Session, f *form.Data) error {
+		_, err := pubsub.PublishWithOptions(ctx, s, jid.MustParse("pubsub.example.net"), "princely_musings", "", f, nil)
+		return err
+	},
+}
+
+func TestIncompleteForm(t *testing.T) {
+	for name, f := range incompleteFormTestCases {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			s := xmpptest.NewSession(0, &buf)
+			err := f(context.Background(), s, requiredForm(t))
+			if !errors.Is(err, form.ErrRequired) {
+				t.Errorf("wrong error: want=%v, got=%v", form.ErrRequired, err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("did not expect incomplete form to be sent, got %s", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// SubscriptionState is the state of a subscription to a node.
+type SubscriptionState string
+
+// A list of subscription states.
+const (
+	SubNone         SubscriptionState = "none"
+	SubPending      SubscriptionState = "pending"
+	SubUnconfigured SubscriptionState = "unconfigured"
+	SubSubscribed   SubscriptionState = "subscribed"
+)
+
+// Subscription is a subscription to a node.
+type Subscription struct {
+	Node  string            `xml:"node,attr"`
+	JID   jid.JID           `xml:"jid,attr"`
+	SubID string            `xml:"subid,attr,omitempty"`
+	State SubscriptionState `xml:"subscription,attr"`
+}
+
+// Subscribe subscribes j to a node on the provided pubsub service.
+// Normally j is the bare JID of the session.
+func Subscribe(ctx context.Context, s *xmpp.Session, to jid.JID, node string, j jid.JID) (Subscription, error) {
+	return SubscribeIQ(ctx, s, stanza.IQ{To: to}, node, j)
+}
+
+// SubscribeIQ is like Subscribe but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func SubscribeIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node string, j jid.JID) (Subscription, error) {
+	iq.Type = stanza.SetIQ
+	resp := struct {
+		XMLName      xml.Name     `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Subscription Subscription `xml:"subscription"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, pubsubElem(NS, "subscribe", node, j, ""), iq, &resp)
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub := resp.Subscription
+	if sub.Node == "" {
+		sub.Node = node
+	}
+	if sub.JID.Equal(jid.JID{}) {
+		sub.JID = j
+	}
+	return sub, nil
+}
+
+// Unsubscribe removes j's subscription to a node on the provided pubsub
+// service.
+// If j has multiple subscriptions to the node, subID selects the one to remove.
+func Unsubscribe(ctx context.Context, s *xmpp.Session, to jid.JID, node string, j jid.JID, subID string) error {
+	return UnsubscribeIQ(ctx, s, stanza.IQ{To: to}, node, j, subID)
+}
+
+// UnsubscribeIQ is like Unsubscribe but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func UnsubscribeIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, node string, j jid.JID, subID string) error {
+	iq.Type = stanza.SetIQ
+	return s.UnmarshalIQElement(ctx, pubsubElem(NS, "unsubscribe", node, j, subID), iq, nil)
+}
+
+// FetchConfig returns the configuration form of a node.
+// Only owners of the node may fetch its configuration.
+func FetchConfig(ctx context.Context, s *xmpp.Session, to jid.JID, node string) (*form.Data, error) {
+	resp := struct {
+		XMLName   xml.Name `xml:"http://jabber.org/protocol/pubsub#owner pubsub"`
+		Configure struct {
+			Form form.Data `xml:"jabber:x:data x"`
+		} `xml:"configure"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, pubsubElem(NSOwner, "configure", node, jid.JID{}, ""), stanza.IQ{
+		To:   to,
+		Type: stanza.GetIQ,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Configure.Form, nil
+}
+
+// SetConfig updates the configuration of a node.
+// Normally cfg will be the result of FetchConfig after any values that need to
+// be changed have been set.
+// Only owners of the node may change its configuration.
+func SetConfig(ctx context.Context, s *xmpp.Session, to jid.JID, node string, cfg *form.Data) error {
+	submission, ok := cfg.Submit()
+	if !ok {
+		return fmt.Errorf("pubsub: cannot submit node configuration: %w", form.ErrRequired)
+	}
+	return s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(submission, xml.StartElement{
+			Name: xml.Name{Local: "configure"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "pubsub"}},
+	), stanza.IQ{To: to, Type: stanza.SetIQ}, nil)
+}
+
+// DeleteNode deletes a node and all of its items.
+// Only owners of the node may delete it.
+func DeleteNode(ctx context.Context, s *xmpp.Session, to jid.JID, node string) error {
+	return s.UnmarshalIQElement(ctx, pubsubElem(NSOwner, "delete", node, jid.JID{}, ""), stanza.IQ{
+		To:   to,
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+// pubsubElem returns a pubsub element in the namespace ns with a single empty
+// child element that has node, jid, and subid attributes.
+// The jid and subid attributes are only added if they are not empty.
+func pubsubElem(ns, local, node string, j jid.JID, subID string) xml.TokenReader {
+	start := xml.StartElement{
+		Name: xml.Name{Local: local},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+	}
+	if !j.Equal(jid.JID{}) {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "jid"}, Value: j.String()})
+	}
+	if subID != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "subid"}, Value: subID})
+	}
+	return xmlstream.Wrap(
+		xmlstream.Wrap(nil, start),
+		xml.StartElement{Name: xml.Name{Space: ns, Local: "pubsub"}},
+	)
+}
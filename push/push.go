@@ -0,0 +1,165 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package push implements registering for push notifications.
+//
+// Push notifications let a client that is not connected (for example, a mobile
+// app that has been suspended by the operating system) learn that it has new
+// messages.
+// The client registers an "app server", a pubsub service that knows how to
+// reach the client through a third party push service, with its own server
+// using Enable.
+// The user's server then publishes a notification to the app server node
+// whenever something happens that the client would want to know about.
+package push // import "mellium.im/xmpp/push"
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package.
+const (
+	NS = "urn:xmpp:push:0"
+
+	// NSPublishOptions is the FORM_TYPE of the publish options form.
+	NSPublishOptions = "http://jabber.org/protocol/pubsub#publish-options"
+)
+
+// AppServer is the identity advertised by app servers.
+var AppServer = disco.Identity{Category: "pubsub", Type: "push"}
+
+// Supported returns true if the disco info advertises support for push
+// notifications.
+// Servers advertise support on the user's bare JID.
+func Supported(info disco.Info) bool {
+	for _, f := range info.Features {
+		if f.Var == NS {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAppServer returns true if the disco info contains the app server identity.
+func IsAppServer(info disco.Info) bool {
+	for _, ident := range info.Identity {
+		if ident.Category == AppServer.Category && ident.Type == AppServer.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishOptions returns a form that can be passed to Enable with the correct
+// FORM_TYPE.
+// The fields that are required depend on the app server, but will commonly
+// include a secret or a device token.
+func PublishOptions(f ...form.Field) *form.Data {
+	return form.New(append([]form.Field{
+		form.Hidden("FORM_TYPE", form.Value(NSPublishOptions)),
+	}, f...)...)
+}
+
+// Enable is the payload of a request to enable push notifications.
+type Enable struct {
+	// JID is the address of the app server and Node is the node on the app
+	// server that notifications should be published to.
+	JID  jid.JID
+	Node string
+
+	// Options are publish options that are passed along to the app server with
+	// each notification. It may be nil.
+	// If any required fields are not set, EnableNotifications returns an error
+	// wrapping form.ErrRequired.
+	Options *form.Data
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (e Enable) TokenReader() xml.TokenReader {
+	var inner xml.TokenReader
+	if e.Options != nil {
+		// Missing required fields are reported by EnableNotifications before the
+		// options are sent.
+		inner, _ = e.Options.Submit()
+	}
+	return xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "enable"},
+		Attr: toggleAttrs(e.JID, e.Node),
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (e Enable) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, e.TokenReader())
+}
+
+// Disable is the payload of a request to disable push notifications.
+// If Node is empty, all nodes on the app server are disabled.
+type Disable struct {
+	JID  jid.JID
+	Node string
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (d Disable) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "disable"},
+		Attr: toggleAttrs(d.JID, d.Node),
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (d Disable) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, d.TokenReader())
+}
+
+func toggleAttrs(j jid.JID, node string) []xml.Attr {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: j.String()}}
+	if node != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "node"}, Value: node})
+	}
+	return attrs
+}
+
+// EnableNotifications asks the user's server to start publishing notifications
+// to the app server.
+func EnableNotifications(ctx context.Context, s *xmpp.Session, e Enable) error {
+	return EnableNotificationsIQ(ctx, s, stanza.IQ{}, e)
+}
+
+// EnableNotificationsIQ is like EnableNotifications but it allows you to
+// customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func EnableNotificationsIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, e Enable) error {
+	iq.Type = stanza.SetIQ
+	if e.Options != nil {
+		if _, ok := e.Options.Submit(); !ok {
+			return fmt.Errorf("push: cannot submit publish options: %w", form.ErrRequired)
+		}
+	}
+	return s.UnmarshalIQElement(ctx, e.TokenReader(), iq, nil)
+}
+
+// DisableNotifications asks the user's server to stop publishing notifications
+// to the app server.
+func DisableNotifications(ctx context.Context, s *xmpp.Session, d Disable) error {
+	return DisableNotificationsIQ(ctx, s, stanza.IQ{}, d)
+}
+
+// DisableNotificationsIQ is like DisableNotifications but it allows you to
+// customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func DisableNotificationsIQ(ctx context.Context, s *xmpp.Session, iq stanza.IQ, d Disable) error {
+	iq.Type = stanza.SetIQ
+	return s.UnmarshalIQElement(ctx, d.TokenReader(), iq, nil)
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package push_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/push"
+	"mellium.im/xmpp/stanza"
+)
+
+type toggle struct {
+	XMLName xml.Name
+	JID     string    `xml:"jid,attr"`
+	Node    *string   `xml:"node,attr"`
+	Form    form.Data `xml:"jabber:x:data x"`
+}
+
+func TestEnableDisable(t *testing.T) {
+	var reqs []toggle
+	handler := func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var req toggle
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&req)
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+		_, err = xmlstream.Copy(t, iq.Result(nil))
+		return err
+	}
+	m := mux.New(
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: push.NS, Local: "enable"}, handler),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: push.NS, Local: "disable"}, handler),
+	)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	appServer := jid.MustParse("push.example.net")
+	opts := push.PublishOptions(form.TextPrivate("secret"))
+	_, err := opts.Set("secret", "eruio234vzxc2kla-91")
+	if err != nil {
+		t.Fatalf("error setting form value: %v", err)
+	}
+	err = push.EnableNotifications(context.Background(), cs.Client, push.Enable{
+		JID:     appServer,
+		Node:    "yxs32uqsflafdk3iuqo",
+		Options: opts,
+	})
+	if err != nil {
+		t.Fatalf("error enabling push: %v", err)
+	}
+	err = push.DisableNotifications(context.Background(), cs.Client, push.Disable{JID: appServer})
+	if err != nil {
+		t.Fatalf("error disabling push: %v", err)
+	}
+
+	if len(reqs) != 2 {
+		t.Fatalf("wrong number of requests: want=2, got=%d", len(reqs))
+	}
+	enable, disable := reqs[0], reqs[1]
+	if enable.XMLName.Local != "enable" || enable.JID != appServer.String() || enable.Node == nil || *enable.Node != "yxs32uqsflafdk3iuqo" {
+		t.Errorf("wrong enable request: %+v", enable)
+	}
+	if formType, _ := enable.Form.GetString("FORM_TYPE"); formType != push.NSPublishOptions {
+		t.Errorf("wrong form type: want=%q, got=%q", push.NSPublishOptions, formType)
+	}
+	if secret, _ := enable.Form.GetString("secret"); secret != "eruio234vzxc2kla-91" {
+		t.Errorf("wrong secret: want=eruio234vzxc2kla-91, got=%q", secret)
+	}
+	if disable.XMLName.Local != "disable" || disable.JID != appServer.String() || disable.Node != nil {
+		t.Errorf("wrong disable request: %+v", disable)
+	}
+}
+
+func TestDisco(t *testing.T) {
+	info := disco.Info{
+		Identity: []disco.Identity{push.AppServer},
+		Features: []disco.Feature{{Var: push.NS}},
+	}
+	if !push.Supported(info) {
+		t.Errorf("expected push to be supported")
+	}
+	if !push.IsAppServer(info) {
+		t.Errorf("expected identity to be an app server")
+	}
+	if push.Supported(disco.Info{}) || push.IsAppServer(disco.Info{}) {
+		t.Errorf("expected empty info to not support push")
+	}
+}
+
+func TestEnableRequired(t *testing.T) {
+	var buf bytes.Buffer
+	s := xmpptest.NewSession(0, &buf)
+	err := push.EnableNotifications(context.Background(), s, push.Enable{
+		JID:     jid.MustParse("push.example.net"),
+		Options: push.PublishOptions(form.TextPrivate("secret", form.Required)),
+	})
+	if !errors.Is(err, form.ErrRequired) {
+		t.Errorf("wrong error: want=%v, got=%v", form.ErrRequired, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("did not expect incomplete options to be sent, got %s", buf.String())
+	}
+}
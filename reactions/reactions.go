@@ -0,0 +1,242 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package reactions implements message reactions.
+//
+// A reaction update contains the full set of emoji that the sender is currently
+// reacting to a message with, replacing any previous update from the same
+// sender.
+// Sending an empty set removes all of the sender's reactions to the message.
+package reactions // import "mellium.im/xmpp/reactions"
+
+import (
+	"context"
+	"encoding/xml"
+	"sort"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:reactions:0"
+
+const nsHints = "urn:xmpp:hints"
+
+// Reactions is a reaction update for the message with the given ID.
+// For group chat messages the ID is the stanza ID assigned by the room, for all
+// other messages it is the ID of the message stanza.
+type Reactions struct {
+	XMLName xml.Name `xml:"urn:xmpp:reactions:0 reactions"`
+	ID      string   `xml:"id,attr"`
+	Emoji   []string `xml:"reaction"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Reactions) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, e := range r.Emoji {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(e)),
+			xml.StartElement{Name: xml.Name{Local: "reaction"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{
+			Name: xml.Name{Space: NS, Local: "reactions"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: r.ID}},
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Reactions) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Reactions) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Message returns a message stanza that updates the sender's reactions to the
+// message with ID id.
+// The message should be sent to the same recipient and with the same type as
+// the original message.
+// It includes a hint asking the server to store the update in the archive.
+// If msg does not have an ID, one is generated.
+func Message(msg stanza.Message, id string, emoji ...string) xml.TokenReader {
+	if msg.ID == "" {
+		msg.ID = attr.RandomID()
+	}
+	return msg.Wrap(xmlstream.MultiReader(
+		Reactions{ID: id, Emoji: emoji}.TokenReader(),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsHints, Local: "store"}}),
+	))
+}
+
+// Send sends a reaction update for the message with ID id.
+// For more information see Message.
+func Send(ctx context.Context, s *xmpp.Session, msg stanza.Message, id string, emoji ...string) error {
+	return s.Send(ctx, Message(msg, id, emoji...))
+}
+
+// Handle returns an option that registers a Handler for reaction updates.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		reactions := xml.Name{Space: NS, Local: "reactions"}
+		mux.Message(stanza.NormalMessage, reactions, h)(m)
+		mux.Message(stanza.ChatMessage, reactions, h)(m)
+		mux.Message(stanza.GroupChatMessage, reactions, h)(m)
+	}
+}
+
+// Handler keeps track of the reactions to messages.
+//
+// Reactions in group chats are tracked per occupant, all others are tracked per
+// bare JID.
+type Handler struct {
+	// Update, if set, is called after a reaction update is received with the
+	// ID of the message, the sender of the update, and the emoji they are now
+	// reacting with.
+	Update func(id string, from jid.JID, emoji []string)
+
+	mu   sync.Mutex
+	msgs map[string]map[string]reacted
+}
+
+type reacted struct {
+	from  jid.JID
+	emoji []string
+}
+
+// HandleMessage implements mux.MessageHandler.
+func (h *Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+	// Pop the start message token.
+	_, err := t.Token()
+	if err != nil {
+		return err
+	}
+
+	r := Reactions{}
+	var found bool
+	iter := xmlstream.NewIter(t)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil || start.Name.Space != NS || start.Name.Local != "reactions" {
+			continue
+		}
+		d := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), inner, xmlstream.Token(start.End())))
+		err = d.Decode(&r)
+		if err != nil {
+			return err
+		}
+		found = true
+		break
+	}
+	err = iter.Err()
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	from := msg.From
+	if msg.Type != stanza.GroupChatMessage {
+		from = from.Bare()
+	}
+	emoji := dedup(r.Emoji)
+	h.set(r.ID, from, emoji)
+	if h.Update != nil {
+		h.Update(r.ID, from, emoji)
+	}
+	return nil
+}
+
+func (h *Handler) set(id string, from jid.JID, emoji []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	senders := h.msgs[id]
+	if len(emoji) == 0 {
+		delete(senders, from.String())
+		if len(senders) == 0 {
+			delete(h.msgs, id)
+		}
+		return
+	}
+	if h.msgs == nil {
+		h.msgs = make(map[string]map[string]reacted)
+	}
+	if senders == nil {
+		senders = make(map[string]reacted)
+		h.msgs[id] = senders
+	}
+	senders[from.String()] = reacted{from: from, emoji: emoji}
+}
+
+// Get returns the reactions to the message with ID id as a map of emoji to the
+// addresses that reacted with them, sorted by address.
+// If there are no reactions to the message, Get returns nil.
+func (h *Handler) Get(id string) map[string][]jid.JID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	senders := h.msgs[id]
+	if len(senders) == 0 {
+		return nil
+	}
+	m := make(map[string][]jid.JID)
+	for _, r := range senders {
+		for _, e := range r.emoji {
+			m[e] = append(m[e], r.from)
+		}
+	}
+	for _, from := range m {
+		sort.Slice(from, func(i, j int) bool {
+			return from[i].String() < from[j].String()
+		})
+	}
+	return m
+}
+
+// Forget stops tracking reactions to the message with ID id.
+func (h *Handler) Forget(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.msgs, id)
+}
+
+// dedup removes empty and repeated emoji while preserving their order.
+func dedup(emoji []string) []string {
+	seen := make(map[string]struct{}, len(emoji))
+	out := emoji[:0]
+	for _, e := range emoji {
+		if _, ok := seen[e]; ok || e == "" {
+			continue
+		}
+		seen[e] = struct{}{}
+		out = append(out, e)
+	}
+	return out
+}
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "reactions"}, func() interface{} {
+		return &Reactions{}
+	})
+}
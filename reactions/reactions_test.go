@@ -0,0 +1,132 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package reactions_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/reactions"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestMessage(t *testing.T) {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, reactions.Message(stanza.Message{
+		ID:   "reaction1",
+		To:   jid.MustParse("romeo@montague.lit"),
+		Type: stanza.ChatMessage,
+	}, "744f6e18", "👋", "🐢"))
+	if err != nil {
+		t.Fatalf("error encoding message: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<message type="chat" id="reaction1" to="romeo@montague.lit"><reactions xmlns="urn:xmpp:reactions:0" id="744f6e18"><reaction>👋</reaction><reaction>🐢</reaction></reactions><store xmlns="urn:xmpp:hints"></store></message>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var updates []string
+	h := &reactions.Handler{
+		Update: func(id string, from jid.JID, emoji []string) {
+			updates = append(updates, fmt.Sprintf("%s:%s:%s", id, from, strings.Join(emoji, "")))
+		},
+	}
+	m := mux.New(reactions.Handle(h))
+
+	for _, raw := range []string{
+		`<message xmlns="jabber:client" from="romeo@montague.lit/orchard" type="chat"><reactions xmlns="urn:xmpp:reactions:0" id="1"><reaction>👋</reaction><reaction>👋</reaction><reaction>🐢</reaction></reactions></message>`,
+		`<message xmlns="jabber:client" from="juliet@capulet.lit/balcony" type="chat"><body>hi</body><reactions xmlns="urn:xmpp:reactions:0" id="1"><reaction>👋</reaction></reactions></message>`,
+		`<message xmlns="jabber:client" from="romeo@montague.lit/garden" type="chat"><reactions xmlns="urn:xmpp:reactions:0" id="1"><reaction>🐢</reaction></reactions></message>`,
+		`<message xmlns="jabber:client" from="coven@chat.shakespeare.lit/firstwitch" type="groupchat"><reactions xmlns="urn:xmpp:reactions:0" id="2"><reaction>🐈</reaction></reactions></message>`,
+		`<message xmlns="jabber:client" from="coven@chat.shakespeare.lit/secondwitch" type="groupchat"><reactions xmlns="urn:xmpp:reactions:0" id="2"><reaction>🐈</reaction></reactions></message>`,
+		`<message xmlns="jabber:client" from="coven@chat.shakespeare.lit/secondwitch" type="groupchat"><reactions xmlns="urn:xmpp:reactions:0" id="2"/></message>`,
+	} {
+		d := xml.NewDecoder(strings.NewReader(raw))
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatalf("error popping start token: %v", err)
+		}
+		start := tok.(xml.StartElement)
+		err = m.HandleXMPP(struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: d,
+			Encoder:     xml.NewEncoder(ioutil.Discard),
+		}, &start)
+		if err != nil {
+			t.Fatalf("error handling %s: %v", raw, err)
+		}
+	}
+
+	wantUpdates := []string{
+		"1:romeo@montague.lit:👋🐢",
+		"1:juliet@capulet.lit:👋",
+		"1:romeo@montague.lit:🐢",
+		"2:coven@chat.shakespeare.lit/firstwitch:🐈",
+		"2:coven@chat.shakespeare.lit/secondwitch:🐈",
+		"2:coven@chat.shakespeare.lit/secondwitch:",
+	}
+	if !reflect.DeepEqual(updates, wantUpdates) {
+		t.Errorf("wrong updates:\nwant=%q,\n got=%q", wantUpdates, updates)
+	}
+
+	for _, tc := range []struct {
+		id   string
+		want map[string][]jid.JID
+	}{
+		{id: "1", want: map[string][]jid.JID{
+			"👋": {jid.MustParse("juliet@capulet.lit")},
+			"🐢": {jid.MustParse("romeo@montague.lit")},
+		}},
+		{id: "2", want: map[string][]jid.JID{
+			"🐈": {jid.MustParse("coven@chat.shakespeare.lit/firstwitch")},
+		}},
+		{id: "3"},
+	} {
+		if got := h.Get(tc.id); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("wrong reactions for %s: want=%v, got=%v", tc.id, tc.want, got)
+		}
+	}
+
+	h.Forget("1")
+	if got := h.Get("1"); got != nil {
+		t.Errorf("expected reactions to be forgotten, got %v", got)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><reactions xmlns="urn:xmpp:reactions:0" id="123"><reaction>👍</reaction></reactions></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		&reactions.Reactions{XMLName: xml.Name{Space: reactions.NS, Local: "reactions"}, ID: "123", Emoji: []string{"👍"}},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
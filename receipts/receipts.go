@@ -65,6 +65,37 @@ func (r *Requested) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	return d.Skip()
 }
 
+// Received is a receipt for the message with the given ID.
+//
+// This type is used to manually include a receipt in a message struct.
+// Receipts for messages handled by Handler are sent automatically.
+type Received struct {
+	XMLName xml.Name `xml:"urn:xmpp:receipts received"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Received) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "received"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: r.ID}},
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Received) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Received) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
 // Request is an xmlstream.Transformer that inserts a request for a read receipt
 // into any message read through r that is not itself a receipt.
 // It is provided to allow easily requesting read receipts asynchronously.
@@ -150,7 +181,8 @@ func Handle(h *Handler) mux.Option {
 }
 
 // Handler listens for incoming message receipts and matches them to outgoing
-// messages sent with SendMessage or SendMessageElement.
+// messages sent with SendMessage or SendMessageElement, or passed to Expect and
+// Wait.
 type Handler struct {
 	sent map[string]chan struct{}
 	m    sync.Mutex
@@ -176,15 +208,15 @@ func (h *Handler) HandleMessage(msg stanza.Message, t xmlstream.TokenReadEncoder
 			_, id := attr.Get(start.Attr, "id")
 			h.m.Lock()
 			c, ok := h.sent[id]
+			h.m.Unlock()
 			if ok {
-				delete(h.sent, id)
-				h.m.Unlock()
-			} else {
-				h.m.Unlock()
-				return nil
+				// The channel is buffered so that the receipt is not lost if nobody is
+				// waiting on it yet, and duplicate receipts are dropped.
+				select {
+				case c <- struct{}{}:
+				default:
+				}
 			}
-
-			c <- struct{}{}
 			return nil
 		case "request":
 			msg.From, msg.To = msg.To, msg.From
@@ -238,38 +270,90 @@ func (h *Handler) SendMessage(ctx context.Context, s *xmpp.Session, r xml.TokenR
 //
 // SendMessageElement is safe for concurrent use by multiple goroutines.
 func (h *Handler) SendMessageElement(ctx context.Context, s *xmpp.Session, payload xml.TokenReader, msg stanza.Message) error {
-	if h.sent == nil {
-		h.m.Lock()
-		h.sent = make(map[string]chan struct{})
-		h.m.Unlock()
-	}
-
 	if msg.ID == "" {
 		msg.ID = attr.RandomID()
 	}
 
-	c := make(chan struct{})
-	h.m.Lock()
-	h.sent[msg.ID] = c
-	h.m.Unlock()
-
+	h.Expect(msg.ID)
 	r := Requested{Value: true}.TokenReader()
 	if payload != nil {
 		r = xmlstream.MultiReader(payload, r)
 	}
 	err := s.SendElement(ctx, r, msg.StartElement())
 	if err != nil {
+		h.forget(msg.ID)
 		return err
 	}
 
+	return h.Wait(ctx, msg.ID)
+}
+
+// Expect starts listening for a receipt for the message with the given ID.
+// It should be called before sending a message that requests a receipt by
+// means other than SendMessage or SendMessageElement, for example after adding
+// a request using the Request transformer, so that a receipt that arrives
+// before Wait is called is not missed.
+// Calling Expect more than once for the same ID has no effect.
+//
+// Expect is safe for concurrent use by multiple goroutines.
+func (h *Handler) Expect(id string) {
+	h.expect(id)
+}
+
+func (h *Handler) expect(id string) chan struct{} {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.sent == nil {
+		h.sent = make(map[string]chan struct{})
+	}
+	c, ok := h.sent[id]
+	if !ok {
+		c = make(chan struct{}, 1)
+		h.sent[id] = c
+	}
+	return c
+}
+
+func (h *Handler) forget(id string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	delete(h.sent, id)
+}
+
+// Wait blocks until a receipt for the message with the given ID is received or
+// the context is canceled, similar to how IQ responses are awaited.
+// If Expect was not called for the message before it was sent, a receipt
+// that arrives before Wait is called will be missed.
+//
+// If the context is canceled before the receipt is received, Wait stops
+// listening for the receipt and returns the context error.
+// If the returned error is nil, receipt of the message was successfully
+// acknowledged.
+//
+// Wait is safe for concurrent use by multiple goroutines, but only one call
+// to Wait for any given ID will return nil.
+func (h *Handler) Wait(ctx context.Context, id string) error {
+	c := h.expect(id)
+	defer func() {
+		h.m.Lock()
+		if h.sent[id] == c {
+			delete(h.sent, id)
+		}
+		h.m.Unlock()
+	}()
 	select {
 	case <-c:
 		return nil
 	case <-ctx.Done():
-		h.m.Lock()
-		delete(h.sent, msg.ID)
-		h.m.Unlock()
-		close(c)
 		return ctx.Err()
 	}
 }
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "request"}, func() interface{} {
+		return &Requested{}
+	})
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "received"}, func() interface{} {
+		return &Received{}
+	})
+}
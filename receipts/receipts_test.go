@@ -8,9 +8,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/xml"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/internal/ns"
@@ -228,3 +230,67 @@ func TestRoundTrip(t *testing.T) {
 		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, out)
 	}
 }
+
+func TestWait(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	clientHandler := &receipts.Handler{}
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(receipts.Handle(clientHandler))),
+		xmpptest.ServerHandler(mux.New(receipts.Handle(&receipts.Handler{}))),
+	)
+	defer cs.Close()
+
+	// Send a message with a request added by the transformer and make sure that
+	// the receipt is not missed even if it arrives before we start waiting.
+	clientHandler.Expect("123")
+	err := cs.Client.Send(ctx, receipts.Request(stanza.Message{
+		XMLName: xml.Name{Space: ns.Client, Local: "message"},
+		ID:      "123",
+		Type:    stanza.ChatMessage,
+	}.Wrap(nil)))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	err = clientHandler.Wait(ctx, "123")
+	if err != nil {
+		t.Fatalf("error waiting for receipt: %v", err)
+	}
+
+	err = clientHandler.SendMessageElement(ctx, cs.Client, nil, stanza.Message{
+		Type: stanza.ChatMessage,
+	})
+	if err != nil {
+		t.Fatalf("error waiting for receipt: %v", err)
+	}
+
+	// Waiting again should time out since the receipt has already been handled.
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer waitCancel()
+	err = clientHandler.Wait(waitCtx, "123")
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected wait for handled receipt to time out, got %v", err)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><request xmlns="urn:xmpp:receipts"/><received xmlns="urn:xmpp:receipts" id="123"/></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	want := []interface{}{
+		&receipts.Requested{Value: true},
+		&receipts.Received{XMLName: xml.Name{Space: receipts.NS, Local: "received"}, ID: "123"},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("wrong number of payloads: want=%d, got=%d", len(want), len(payloads))
+	}
+	for i, p := range payloads {
+		if !reflect.DeepEqual(p.Value, want[i]) {
+			t.Errorf("wrong value for payload %d: want=%#v, got=%#v", i, want[i], p.Value)
+		}
+	}
+}
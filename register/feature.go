@@ -0,0 +1,122 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package register
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/stream"
+)
+
+// Feature returns a stream feature that registers a new account with the server
+// before authentication.
+//
+// When the feature is negotiated the registration information required by the
+// server is fetched and passed to f, which returns the filled out query to
+// submit.
+// The query is submitted as if by Submit and negotiation then continues with
+// the next feature, normally SASL authentication using the new credentials.
+// Because credentials are sent, the feature is only negotiated after the
+// connection is secured.
+//
+// Feature may only be used by clients, negotiating it on a server session
+// returns an error.
+func Feature(f func(context.Context, Query) (Query, error)) xmpp.StreamFeature {
+	return xmpp.StreamFeature{
+		Name:       xml.Name{Space: NSFeature, Local: "register"},
+		Necessary:  xmpp.Secure,
+		Prohibited: xmpp.Authn,
+		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (bool, error) {
+			_, err := xmlstream.Copy(e, xmlstream.Wrap(nil, start))
+			return false, err
+		},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			return false, nil, d.Skip()
+		},
+		Negotiate: func(ctx context.Context, session *xmpp.Session, _ interface{}) (xmpp.SessionState, io.ReadWriter, error) {
+			if session.State()&xmpp.Received == xmpp.Received {
+				return 0, nil, errors.New("register: registration during stream negotiation is only supported by clients")
+			}
+
+			r := session.TokenReader()
+			defer r.Close()
+			w := session.TokenWriter()
+			defer w.Close()
+
+			q, err := negotiateIQ(w, r, stanza.GetIQ, Query{}.TokenReader())
+			if err != nil {
+				return 0, nil, err
+			}
+			q, err = f(ctx, q)
+			if err != nil {
+				return 0, nil, err
+			}
+			if q.Form != nil {
+				err = q.Form.Validate()
+				if err != nil {
+					return 0, nil, err
+				}
+			}
+			_, err = negotiateIQ(w, r, stanza.SetIQ, q.tokenReader(true))
+			return 0, nil, err
+		},
+	}
+}
+
+// negotiateIQ sends an IQ with the given payload and waits for the response.
+//
+// IQs cannot be sent using the normal session methods during stream
+// negotiation, so the response is read directly from the stream.
+func negotiateIQ(w xmlstream.TokenWriteFlusher, r xml.TokenReader, typ stanza.IQType, payload xml.TokenReader) (Query, error) {
+	id := attr.RandomID()
+	_, err := xmlstream.Copy(w, stanza.IQ{
+		XMLName: xml.Name{Space: ns.Client, Local: "iq"},
+		ID:      id,
+		Type:    typ,
+	}.Wrap(payload))
+	if err != nil {
+		return Query{}, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return Query{}, err
+	}
+
+	d := xml.NewTokenDecoder(r)
+	tok, err := d.Token()
+	if err != nil {
+		return Query{}, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || start.Name.Local != "iq" {
+		return Query{}, stream.BadFormat
+	}
+	resp := struct {
+		stanza.IQ
+		Query Query         `xml:"jabber:iq:register query"`
+		Err   *stanza.Error `xml:"error"`
+	}{}
+	err = d.DecodeElement(&resp, &start)
+	if err != nil {
+		return Query{}, err
+	}
+	switch {
+	case resp.ID != id:
+		return Query{}, stream.UndefinedCondition
+	case resp.Type == stanza.ErrorIQ && resp.Err != nil:
+		return Query{}, *resp.Err
+	case resp.Type != stanza.ResultIQ:
+		return Query{}, stanza.Error{Condition: stanza.BadRequest}
+	}
+	return resp.Query, nil
+}
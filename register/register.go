@@ -0,0 +1,208 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package register implements in-band registration.
+//
+// In-band registration lets clients create accounts on a server, change the
+// password of an account, and remove an account without using any out of band
+// mechanism such as a web page.
+// New accounts may be registered during stream negotiation using Feature, or
+// after authentication (for example with services that require registration)
+// using Fetch and Submit.
+package register // import "mellium.im/xmpp/register"
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/marshal"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS = "jabber:iq:register"
+
+	// NSFeature is the namespace of the stream feature advertised by servers
+	// that allow registration during stream negotiation.
+	NSFeature = "http://jabber.org/features/iq-register"
+)
+
+// Query is the payload of a registration request or response.
+//
+// Servers may ask for registration information using legacy fields, a data
+// form, or both.
+// If a form is present it should be filled out instead of the legacy fields.
+type Query struct {
+	// Registered is set in responses if the entity is already registered.
+	Registered bool
+
+	// Instructions for filling out the legacy fields.
+	Instructions string
+
+	// Fields contains the legacy fields by name (for example "username",
+	// "password", or "email").
+	// In responses, requested fields that have no value are present with an
+	// empty string.
+	Fields map[string]string
+
+	// Form is a data form to be filled out instead of Fields.
+	Form *form.Data
+
+	// Remove requests that the registration be cancelled.
+	Remove bool
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (q Query) TokenReader() xml.TokenReader {
+	return q.tokenReader(false)
+}
+
+// tokenReader marshals the query.
+// If submit is true, any form is marshaled as a submission and reading the
+// query fails if a required field of the form has no value.
+func (q Query) tokenReader(submit bool) xml.TokenReader {
+	var inner []xml.TokenReader
+	if q.Registered {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "registered"}}))
+	}
+	if q.Instructions != "" {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(q.Instructions)),
+			xml.StartElement{Name: xml.Name{Local: "instructions"}},
+		))
+	}
+	names := make([]string, 0, len(q.Fields))
+	for name := range q.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var val xml.TokenReader
+		if v := q.Fields[name]; v != "" {
+			val = xmlstream.Token(xml.CharData(v))
+		}
+		inner = append(inner, xmlstream.Wrap(val, xml.StartElement{Name: xml.Name{Local: name}}))
+	}
+	if q.Form != nil {
+		if submit {
+			r, ok := q.Form.Submit()
+			if !ok {
+				r = marshal.ErrReader(fmt.Errorf("register: cannot submit registration: %w", form.ErrRequired))
+			}
+			inner = append(inner, r)
+		} else {
+			inner = append(inner, q.Form.TokenReader())
+		}
+	}
+	if q.Remove {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "remove"}}))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "query"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (q Query) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, q.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (q Query) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := q.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (q *Query) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == form.NS && t.Name.Local == "x":
+				q.Form = &form.Data{}
+				err = d.DecodeElement(q.Form, &t)
+			case t.Name.Space != NS:
+				err = d.Skip()
+			case t.Name.Local == "registered":
+				q.Registered = true
+				err = d.Skip()
+			case t.Name.Local == "remove":
+				q.Remove = true
+				err = d.Skip()
+			case t.Name.Local == "instructions":
+				err = d.DecodeElement(&q.Instructions, &t)
+			default:
+				var v string
+				err = d.DecodeElement(&v, &t)
+				if q.Fields == nil {
+					q.Fields = make(map[string]string)
+				}
+				q.Fields[t.Name.Local] = v
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Fetch requests the registration information required by to.
+// If to is the zero value the request is sent to the server.
+func Fetch(ctx context.Context, s *xmpp.Session, to jid.JID) (Query, error) {
+	var q Query
+	err := s.UnmarshalIQElement(ctx, Query{}.TokenReader(), stanza.IQ{
+		To:   to,
+		Type: stanza.GetIQ,
+	}, &q)
+	return q, err
+}
+
+// Submit sends registration information to to.
+// If q contains a form, it is validated and sent as a form submission.
+// If to is the zero value the request is sent to the server.
+func Submit(ctx context.Context, s *xmpp.Session, to jid.JID, q Query) error {
+	if q.Form != nil {
+		err := q.Form.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return s.UnmarshalIQElement(ctx, q.tokenReader(true), stanza.IQ{
+		To:   to,
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+// Cancel removes the registration with to.
+// If to is the zero value, the account that the session is authenticated as is
+// removed from the server.
+func Cancel(ctx context.Context, s *xmpp.Session, to jid.JID) error {
+	return Submit(ctx, s, to, Query{Remove: true})
+}
+
+// ChangePassword changes the password of the account that the session is
+// authenticated as.
+func ChangePassword(ctx context.Context, s *xmpp.Session, password string) error {
+	return Submit(ctx, s, s.LocalAddr().Domain(), Query{
+		Fields: map[string]string{
+			"username": s.LocalAddr().Localpart(),
+			"password": password,
+		},
+	})
+}
@@ -0,0 +1,206 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package register_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/register"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestUnmarshal(t *testing.T) {
+	const in = `<query xmlns="jabber:iq:register">
+  <instructions>Choose a username and password.</instructions>
+  <username/>
+  <password/>
+  <email>juliet@example.com</email>
+  <x xmlns="jabber:x:data" type="form">
+    <field type="text-single" var="username"><required/></field>
+  </x>
+  <registered/>
+</query>`
+	var q register.Query
+	err := xml.Unmarshal([]byte(in), &q)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if !q.Registered || q.Remove {
+		t.Errorf("wrong flags: registered=%t, remove=%t", q.Registered, q.Remove)
+	}
+	if q.Instructions != "Choose a username and password." {
+		t.Errorf("wrong instructions: %q", q.Instructions)
+	}
+	want := map[string]string{"username": "", "password": "", "email": "juliet@example.com"}
+	if fmt.Sprint(q.Fields) != fmt.Sprint(want) {
+		t.Errorf("wrong fields: want=%v, got=%v", want, q.Fields)
+	}
+	if q.Form == nil {
+		t.Fatalf("expected form to be decoded")
+	}
+	if err = q.Form.Validate(); !errors.Is(err, form.ErrRequired) {
+		t.Errorf("expected unset form field to be required, got %v", err)
+	}
+
+	out, err := xml.Marshal(register.Query{
+		Fields: want,
+		Remove: true,
+	})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	const wantOut = `<query xmlns="jabber:iq:register"><email>juliet@example.com</email><password></password><username></username><remove></remove></query>`
+	if string(out) != wantOut {
+		t.Errorf("wrong output:\nwant=%s\n got=%s", wantOut, out)
+	}
+}
+
+func TestIQ(t *testing.T) {
+	submitted := make(chan register.Query, 3)
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(mux.New(
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: register.NS, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			_, err := xmlstream.Copy(t, iq.Result(register.Query{
+				Instructions: "Choose a password.",
+				Fields:       map[string]string{"username": "", "password": ""},
+			}.TokenReader()))
+			return err
+		}),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: register.NS, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var q register.Query
+			err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&q)
+			if err != nil {
+				return err
+			}
+			submitted <- q
+			_, err = xmlstream.Copy(t, iq.Result(nil))
+			return err
+		}),
+	)))
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q, err := register.Fetch(ctx, cs.Client, jid.JID{})
+	if err != nil {
+		t.Fatalf("error fetching registration: %v", err)
+	}
+	if q.Instructions != "Choose a password." || len(q.Fields) != 2 {
+		t.Fatalf("wrong registration fetched: %+v", q)
+	}
+
+	q.Fields["username"] = "juliet"
+	q.Fields["password"] = "rosemary"
+	err = register.Submit(ctx, cs.Client, jid.JID{}, q)
+	if err != nil {
+		t.Fatalf("error submitting registration: %v", err)
+	}
+	if got := <-submitted; got.Fields["username"] != "juliet" || got.Fields["password"] != "rosemary" {
+		t.Errorf("wrong registration submitted: %+v", got)
+	}
+
+	err = register.ChangePassword(ctx, cs.Client, "thyme")
+	if err != nil {
+		t.Fatalf("error changing password: %v", err)
+	}
+	if got := <-submitted; got.Fields["username"] != cs.Client.LocalAddr().Localpart() || got.Fields["password"] != "thyme" {
+		t.Errorf("wrong password change submitted: %+v", got)
+	}
+
+	err = register.Cancel(ctx, cs.Client, jid.JID{})
+	if err != nil {
+		t.Fatalf("error cancelling registration: %v", err)
+	}
+	if got := <-submitted; !got.Remove {
+		t.Errorf("expected cancellation to be submitted, got %+v", got)
+	}
+
+	err = register.Submit(ctx, cs.Client, jid.JID{}, register.Query{
+		Form: form.New(form.Text("username", form.Required)),
+	})
+	if !errors.Is(err, form.ErrRequired) {
+		t.Errorf("expected incomplete form not to be submitted, got %v", err)
+	}
+}
+
+func TestFeature(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		d := xml.NewDecoder(serverConn)
+		respond := func(payload string) (register.Query, error) {
+			var iq struct {
+				stanza.IQ
+				Query register.Query `xml:"jabber:iq:register query"`
+			}
+			err := d.Decode(&iq)
+			if err != nil {
+				return iq.Query, err
+			}
+			_, err = fmt.Fprintf(serverConn, `<iq type="result" id="%s">%s</iq>`, iq.ID, payload)
+			return iq.Query, err
+		}
+		_, err := respond(`<query xmlns="jabber:iq:register"><username/><password/></query>`)
+		if err != nil {
+			errs <- err
+			return
+		}
+		q, err := respond(``)
+		if err == nil && (q.Fields["username"] != "juliet" || q.Fields["password"] != "rosemary") {
+			err = fmt.Errorf("wrong registration submitted: %+v", q)
+		}
+		errs <- err
+	}()
+
+	feature := register.Feature(func(_ context.Context, q register.Query) (register.Query, error) {
+		if _, ok := q.Fields["username"]; !ok {
+			return q, errors.New("username was not requested")
+		}
+		q.Fields["username"] = "juliet"
+		q.Fields["password"] = "rosemary"
+		return q, nil
+	})
+	s := xmpptest.NewSession(xmpp.Secure, clientConn)
+	mask, rw, err := feature.Negotiate(context.Background(), s, nil)
+	if err != nil {
+		t.Fatalf("error negotiating: %v", err)
+	}
+	if mask != 0 || rw != nil {
+		t.Errorf("registration should not change the session state, got mask=%v, rw=%v", mask, rw)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	e := xml.NewEncoder(&b)
+	req, err := feature.List(context.Background(), e, xml.StartElement{Name: feature.Name})
+	if err != nil {
+		t.Fatalf("error listing feature: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const wantList = `<register xmlns="http://jabber.org/features/iq-register"></register>`
+	if req || b.String() != wantList {
+		t.Errorf("wrong listing: want=%s, got req=%t %s", wantList, req, b.String())
+	}
+}
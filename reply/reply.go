@@ -0,0 +1,109 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package reply implements message replies.
+//
+// Replies indicate that a message is a response to an earlier message.
+// To support clients that do not understand replies, the reply normally starts
+// with a quote of the original message that is marked as a fallback so that
+// clients that do support replies can remove it.
+package reply // import "mellium.im/xmpp/reply"
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package.
+const NS = "urn:xmpp:reply:0"
+
+// Reply indicates that a message is a reply to the message with the given ID
+// that was sent by To.
+// In group chats ID is the stanza ID assigned by the room and To is the
+// occupant JID of the original sender.
+// When unmarshaled into a message struct, an empty ID means that the message is
+// not a reply.
+type Reply struct {
+	XMLName xml.Name `xml:"urn:xmpp:reply:0 reply"`
+	To      jid.JID  `xml:"to,attr,omitempty"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Reply) TokenReader() xml.TokenReader {
+	var attrs []xml.Attr
+	if to := r.To.String(); to != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "to"}, Value: to})
+	}
+	attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "id"}, Value: r.ID})
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "reply"},
+		Attr: attrs,
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Reply) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Reply) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Quote returns a body that quotes the original message followed by the reply,
+// and a fallback indication that covers the quote.
+// Each line of the original message is prefixed with "> ".
+func Quote(original, body string) (string, fallback.Fallback) {
+	var quote strings.Builder
+	for _, line := range strings.Split(original, "\n") {
+		quote.WriteString("> ")
+		quote.WriteString(line)
+		quote.WriteByte('\n')
+	}
+	q := quote.String()
+	return q + body, fallback.Fallback{
+		For:  NS,
+		Body: []fallback.Range{{Start: 0, End: fallback.Len(q)}},
+	}
+}
+
+// Message returns a message stanza with the given body that replies to the
+// message described by r.
+// If original is not empty it is quoted at the start of the body and marked
+// as a fallback.
+func Message(msg stanza.Message, r Reply, original, body string) xml.TokenReader {
+	payload := []xml.TokenReader{nil, r.TokenReader()}
+	if original != "" {
+		var f fallback.Fallback
+		body, f = Quote(original, body)
+		payload = append(payload, f.TokenReader())
+	}
+	payload[0] = xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(body)),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	)
+	return msg.Wrap(xmlstream.MultiReader(payload...))
+}
+
+// Body returns the body of a reply with any quoted fallback removed.
+func Body(body string, f ...fallback.Fallback) string {
+	return fallback.Strip(body, NS, f...)
+}
+
+func init() {
+	stanza.DefaultRegistry.Register(xml.Name{Space: NS, Local: "reply"}, func() interface{} {
+		return &Reply{}
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package reply_test
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/reply"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = reply.Reply{}
+	_ xmlstream.Marshaler = reply.Reply{}
+	_ xmlstream.WriterTo  = reply.Reply{}
+)
+
+func TestMessage(t *testing.T) {
+	anna := jid.MustParse("anna@example.com/laptop")
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, reply.Message(stanza.Message{
+		ID:   "reply1",
+		To:   anna.Bare(),
+		Type: stanza.ChatMessage,
+	}, reply.Reply{To: anna, ID: "message-id1"}, "We should bake a cake\nwith ☃", "Great idea!"))
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	if err = e.Flush(); err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<message type="chat" id="reply1" to="anna@example.com"><body>&gt; We should bake a cake
+&gt; with ☃
+Great idea!</body><reply xmlns="urn:xmpp:reply:0" to="anna@example.com/laptop" id="message-id1"></reply><fallback xmlns="urn:xmpp:fallback:0" for="urn:xmpp:reply:0"><body start="0" end="33"></body></fallback></message>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+
+	var msg struct {
+		stanza.Message
+		Body     string              `xml:"body"`
+		Reply    reply.Reply         `xml:"urn:xmpp:reply:0 reply"`
+		Fallback []fallback.Fallback `xml:"urn:xmpp:fallback:0 fallback"`
+	}
+	err = xml.Unmarshal([]byte(buf.String()), &msg)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if msg.Reply.ID != "message-id1" || !msg.Reply.To.Equal(anna) {
+		t.Errorf("wrong reply: %+v", msg.Reply)
+	}
+	if body := reply.Body(msg.Body, msg.Fallback...); body != "Great idea!" {
+		t.Errorf("wrong body with fallback removed: want=%q, got=%q", "Great idea!", body)
+	}
+}
+
+func TestMessageNoQuote(t *testing.T) {
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, reply.Message(stanza.Message{ID: "reply2"}, reply.Reply{ID: "message-id1"}, "", "Great idea!"))
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	if err = e.Flush(); err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<message type="" id="reply2"><body>Great idea!</body><reply xmlns="urn:xmpp:reply:0" id="message-id1"></reply></message>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	const input = `<message xmlns="jabber:client"><reply xmlns="urn:xmpp:reply:0" to="juliet@example.net/balcony" id="123"/></message>`
+	_, payloads, err := stanza.DecodeMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("wrong number of payloads: want=1, got=%d", len(payloads))
+	}
+	r, ok := payloads[0].Value.(*reply.Reply)
+	if !ok {
+		t.Fatalf("expected reply to be registered, got %+v", payloads[0])
+	}
+	if r.ID != "123" || r.To.String() != "juliet@example.net/balcony" {
+		t.Errorf("wrong reply: %+v", r)
+	}
+}
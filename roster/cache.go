@@ -0,0 +1,259 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+)
+
+const subRemove = "remove"
+
+// Cache is a local copy of the roster.
+// It can be kept up to date by passing its Push method to a Handler and used
+// with Batch to change the roster.
+//
+// A Cache is safe for concurrent use.
+type Cache struct {
+	// Bus, if set, has every item applied by Push published to it after the
+	// cache has been updated.
+	// Setting it to the bus of a session lets applications react to roster
+	// changes without wrapping the Handler.
+	Bus *xmpp.Bus
+
+	mu    sync.Mutex
+	items map[string]Item
+}
+
+// Fetch replaces the contents of the cache with the roster fetched from the
+// server.
+func (c *Cache) Fetch(ctx context.Context, s *xmpp.Session) error {
+	iter := Fetch(ctx, s)
+	items := make(map[string]Item)
+	for iter.Next() {
+		item := iter.Item()
+		items[item.JID.Bare().String()] = item
+	}
+	err := iter.Err()
+	if e := iter.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = items
+	return nil
+}
+
+// Push applies a roster push to the cache.
+// It never returns an error and is meant to be used as the Push function of a
+// Handler.
+func (c *Cache) Push(item Item) error {
+	c.mu.Lock()
+	c.set(item)
+	c.mu.Unlock()
+	c.Bus.Publish(item)
+	return nil
+}
+
+func (c *Cache) set(item Item) {
+	key := item.JID.Bare().String()
+	if item.Subscription == subRemove {
+		delete(c.items, key)
+		return
+	}
+	if c.items == nil {
+		c.items = make(map[string]Item)
+	}
+	c.items[key] = item
+}
+
+// Item returns the cached roster item for the bare JID of j.
+func (c *Cache) Item(j jid.JID) (Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[j.Bare().String()]
+	return item, ok
+}
+
+// Items returns all cached roster items sorted by JID.
+func (c *Cache) Items() []Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	items := make([]Item, 0, len(c.items))
+	for _, item := range c.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].JID.String() < items[j].JID.String()
+	})
+	return items
+}
+
+// Groups returns the names of all groups used by cached roster items in
+// sorted order.
+func (c *Cache) Groups() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[string]struct{})
+	var groups []string
+	for _, item := range c.items {
+		for _, g := range item.Group {
+			if _, ok := seen[g]; ok {
+				continue
+			}
+			seen[g] = struct{}{}
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Batch collects changes to the roster so that they can be sent together.
+// Multiple changes to the same contact are coalesced into a single roster set.
+// The zero value is a valid batch that is not associated with a cache.
+type Batch struct {
+	cache *Cache
+	items map[string]Item
+	order []string
+}
+
+// Batch returns a new batch that applies its changes to the cache.
+func (c *Cache) Batch() *Batch {
+	return &Batch{cache: c}
+}
+
+// current returns the item as it will be after the batch is committed.
+func (b *Batch) current(j jid.JID) (Item, bool) {
+	key := j.Bare().String()
+	if item, ok := b.items[key]; ok {
+		return item, item.Subscription != subRemove
+	}
+	if b.cache != nil {
+		return b.cache.Item(j)
+	}
+	return Item{}, false
+}
+
+// Set adds or updates a roster item, replacing any earlier change to the same
+// contact in the batch.
+func (b *Batch) Set(item Item) {
+	key := item.JID.Bare().String()
+	if b.items == nil {
+		b.items = make(map[string]Item)
+	}
+	if _, ok := b.items[key]; !ok {
+		b.order = append(b.order, key)
+	}
+	b.items[key] = item
+}
+
+// Delete removes a contact from the roster.
+func (b *Batch) Delete(j jid.JID) {
+	b.Set(Item{JID: j.Bare(), Subscription: subRemove})
+}
+
+// Move moves a contact from one group to another.
+// If from is empty the contact is added to the group without being removed
+// from any others, and if to is empty it is only removed from the group.
+// If the contact is not in the cache or the batch Move does nothing.
+func (b *Batch) Move(j jid.JID, from, to string) {
+	item, ok := b.current(j)
+	if !ok {
+		return
+	}
+	groups := make([]string, 0, len(item.Group)+1)
+	for _, g := range item.Group {
+		if g == from || g == to {
+			continue
+		}
+		groups = append(groups, g)
+	}
+	if to != "" {
+		groups = append(groups, to)
+	}
+	item.Group = groups
+	b.Set(item)
+}
+
+// RenameGroup moves every contact in the group from to the group to.
+// Only contacts that are in the cache or already part of the batch are
+// affected.
+func (b *Batch) RenameGroup(from, to string) {
+	var items []Item
+	if b.cache != nil {
+		items = b.cache.Items()
+	}
+	for _, key := range b.order {
+		items = append(items, b.items[key])
+	}
+	for _, item := range items {
+		for _, g := range item.Group {
+			if g == from {
+				b.Move(item.JID, from, to)
+				break
+			}
+		}
+	}
+}
+
+// Commit sends the changes in the batch to the server.
+// Changes are applied to the cache optimistically before the server responds
+// and are reverted for any contact where the server returns an error.
+// Because the roster protocol only allows one item to be changed per request,
+// one request is sent for each contact.
+// The first error encountered is returned after all requests have been sent.
+// After Commit returns the batch is empty and may be reused.
+func (b *Batch) Commit(ctx context.Context, s *xmpp.Session) error {
+	items := make([]Item, 0, len(b.order))
+	for _, key := range b.order {
+		items = append(items, b.items[key])
+	}
+	b.items = nil
+	b.order = nil
+
+	var reverts []Item
+	if b.cache != nil {
+		b.cache.mu.Lock()
+		for _, item := range items {
+			prev, ok := b.cache.items[item.JID.Bare().String()]
+			if !ok {
+				prev = Item{JID: item.JID, Subscription: subRemove}
+			}
+			reverts = append(reverts, prev)
+			b.cache.set(item)
+		}
+		b.cache.mu.Unlock()
+	}
+
+	var firstErr error
+	for i, item := range items {
+		var err error
+		if item.Subscription == subRemove {
+			err = Delete(ctx, s, item.JID)
+		} else {
+			err = Set(ctx, s, item)
+		}
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if b.cache != nil {
+			/* #nosec */
+			b.cache.Push(reverts[i])
+		}
+	}
+	return firstErr
+}
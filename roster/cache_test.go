@@ -0,0 +1,114 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestBatch(t *testing.T) {
+	juliet := jid.MustParse("juliet@example.com")
+	benvolio := jid.MustParse("benvolio@example.org")
+	mercutio := jid.MustParse("mercutio@example.org")
+	tybalt := jid.MustParse("tybalt@example.net")
+
+	var sets []roster.Item
+	m := mux.New(mux.IQFunc(stanza.SetIQ, xml.Name{Space: roster.NS, Local: "query"}, func(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var q roster.IQ
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), t)).Decode(&q.Query)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, q.Query.Item...)
+		if len(q.Query.Item) == 1 && q.Query.Item[0].JID.Equal(tybalt) {
+			_, err = xmlstream.Copy(t, iq.Error(stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAllowed}))
+			return err
+		}
+		_, err = xmlstream.Copy(t, iq.Result(nil))
+		return err
+	}))
+	cs := xmpptest.NewClientServer(xmpptest.ServerHandler(m))
+
+	c := &roster.Cache{}
+	for _, item := range []roster.Item{
+		{JID: juliet, Subscription: "both", Group: []string{"Friends", "Capulet"}},
+		{JID: benvolio, Subscription: "to", Group: []string{"Friends"}},
+		{JID: tybalt, Subscription: "none", Group: []string{"Capulet"}},
+	} {
+		/* #nosec */
+		c.Push(item)
+	}
+
+	b := c.Batch()
+	b.RenameGroup("Friends", "Allies")
+	b.Set(roster.Item{JID: mercutio, Name: "Mercutio"})
+	b.Move(mercutio, "", "Allies")
+	b.Move(tybalt, "Capulet", "Enemies")
+	err := b.Commit(context.Background(), cs.Client)
+	if err == nil {
+		t.Fatalf("expected error from failed roster set")
+	}
+
+	// Juliet, Benvolio, Mercutio, and Tybalt should each have been sent exactly
+	// once even though Mercutio was changed twice.
+	if len(sets) != 4 {
+		t.Fatalf("wrong number of roster sets: want=4, got=%d: %+v", len(sets), sets)
+	}
+
+	want := []roster.Item{
+		{JID: benvolio, Subscription: "to", Group: []string{"Allies"}},
+		{JID: juliet, Subscription: "both", Group: []string{"Capulet", "Allies"}},
+		{JID: mercutio, Name: "Mercutio", Group: []string{"Allies"}},
+		{JID: tybalt, Subscription: "none", Group: []string{"Capulet"}},
+	}
+	if items := c.Items(); !reflect.DeepEqual(items, want) {
+		t.Errorf("wrong cached items:\nwant=%+v,\n got=%+v", want, items)
+	}
+	if groups := c.Groups(); !reflect.DeepEqual(groups, []string{"Allies", "Capulet"}) {
+		t.Errorf("wrong groups: %v", groups)
+	}
+}
+
+func TestCachePushRemove(t *testing.T) {
+	c := &roster.Cache{}
+	j := jid.MustParse("juliet@example.com/balcony")
+	/* #nosec */
+	c.Push(roster.Item{JID: j.Bare(), Subscription: "both"})
+	if _, ok := c.Item(j); !ok {
+		t.Fatalf("expected item to be cached")
+	}
+	/* #nosec */
+	c.Push(roster.Item{JID: j.Bare(), Subscription: "remove"})
+	if _, ok := c.Item(j); ok {
+		t.Errorf("expected item to be removed")
+	}
+}
+
+func TestCachePushBus(t *testing.T) {
+	var pushed []roster.Item
+	c := &roster.Cache{Bus: &xmpp.Bus{}}
+	c.Bus.Subscribe(func(item roster.Item) {
+		if _, ok := c.Item(item.JID); !ok {
+			t.Errorf("item was published before the cache was updated")
+		}
+		pushed = append(pushed, item)
+	})
+	/* #nosec */
+	c.Push(roster.Item{JID: jid.MustParse("juliet@example.com"), Subscription: "both"})
+	if len(pushed) != 1 {
+		t.Errorf("wrong number of items published: want=1, got=%d", len(pushed))
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+)
+
+type query struct {
+	XMLName xml.Name `xml:"jabber:iq:roster query"`
+	Items   []Item   `xml:"item"`
+}
+
+// Export writes the items as a roster query element including their
+// subscription states and groups.
+// This is the same format that is used for rosters in XEP-0227 export files,
+// which can be read and written using the pie package.
+//
+// Items are normally the result of Cache.Items or a Fetch.
+func Export(w xmlstream.TokenWriter, items []Item) error {
+	q := IQ{}
+	q.Query.Item = items
+	_, err := xmlstream.Copy(w, q.payload())
+	if err != nil {
+		return err
+	}
+	if f, ok := w.(xmlstream.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Import reads a roster query element such as the one written by Export.
+//
+// To add the imported items to an account, set them using a Batch.
+// Servers do not let clients change subscription states directly, so only the
+// names and groups of the items will be restored.
+func Import(r xml.TokenReader) ([]Item, error) {
+	var q query
+	err := xml.NewTokenDecoder(r).Decode(&q)
+	return q.Items, err
+}
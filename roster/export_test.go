@@ -0,0 +1,39 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/roster"
+)
+
+func TestExportImport(t *testing.T) {
+	items := []roster.Item{{
+		JID:          jid.MustParse("juliet@example.com"),
+		Name:         "Juliet",
+		Subscription: "both",
+		Group:        []string{"Friends", "Capulet"},
+	}, {
+		JID:          jid.MustParse("benvolio@example.org"),
+		Subscription: "to",
+	}}
+	var buf bytes.Buffer
+	err := roster.Export(xml.NewEncoder(&buf), items)
+	if err != nil {
+		t.Fatalf("error exporting roster: %v", err)
+	}
+	imported, err := roster.Import(xml.NewDecoder(&buf))
+	if err != nil {
+		t.Fatalf("error importing roster: %v", err)
+	}
+	if !reflect.DeepEqual(imported, items) {
+		t.Errorf("roster did not round trip:\nwant=%+v,\n got=%+v", items, imported)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+)
+
+// NSFeatures is the namespace of the stream feature advertised by servers that
+// support roster versioning.
+const NSFeatures = "urn:xmpp:features:rosterver"
+
+// Versioning is a stream feature that advertises support for roster versioning
+// on the server side of a connection and detects it on the client side.
+// There is nothing to negotiate, so clients do not have to use Versioning to
+// check for support, see VersioningSupported.
+func Versioning() xmpp.StreamFeature {
+	return xmpp.StreamFeature{
+		Name:      xml.Name{Space: NSFeatures, Local: "ver"},
+		Necessary: xmpp.Authn,
+		List: func(ctx context.Context, e xmlstream.TokenWriter, start xml.StartElement) (req bool, err error) {
+			if err = e.EncodeToken(start); err != nil {
+				return req, err
+			}
+			return req, e.EncodeToken(start.End())
+		},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			return false, nil, d.Skip()
+		},
+		Negotiate: func(ctx context.Context, session *xmpp.Session, data interface{}) (xmpp.SessionState, io.ReadWriter, error) {
+			return 0, nil, nil
+		},
+	}
+}
+
+// VersioningSupported reports whether the server advertised support for roster
+// versioning when the session was negotiated.
+// If it did, a version may be sent when fetching the roster and the server
+// may respond with only the changes since that version.
+func VersioningSupported(s *xmpp.Session) bool {
+	_, ok := s.Feature(NSFeatures)
+	return ok
+}
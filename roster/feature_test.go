@@ -0,0 +1,64 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/roster"
+)
+
+func TestFeature(t *testing.T) {
+	xmpptest.RunFeatureTests(t, []xmpptest.FeatureTestCase{
+		0: {
+			State:   xmpp.Authn,
+			Feature: roster.Versioning(),
+		},
+	})
+}
+
+var supportedTestCases = [...]struct {
+	features  string
+	supported bool
+}{
+	0: {features: `<stream:features/>`},
+	1: {
+		features:  `<stream:features><ver xmlns='urn:xmpp:features:rosterver'/></stream:features>`,
+		supported: true,
+	},
+}
+
+func TestVersioningSupported(t *testing.T) {
+	for i, tc := range supportedTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: strings.NewReader(`<stream:stream id='1' version='1.0' xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:client'>` + tc.features),
+				Writer: ioutil.Discard,
+			}
+			s, err := xmpp.NewSession(context.Background(), jid.MustParse("example.net"), jid.MustParse("juliet@example.net"), rw, xmpp.Authn, xmpp.NewNegotiator(xmpp.StreamConfig{
+				Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+					return []xmpp.StreamFeature{roster.Versioning()}
+				},
+			}))
+			if err != nil {
+				t.Fatalf("error negotiating session: %v", err)
+			}
+			if supported := roster.VersioningSupported(s); supported != tc.supported {
+				t.Errorf("wrong support: want=%t, got=%t", tc.supported, supported)
+			}
+		})
+	}
+}
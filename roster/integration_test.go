@@ -8,11 +8,9 @@ package roster_test
 
 import (
 	"context"
-	"crypto/tls"
 	"reflect"
 	"testing"
 
-	"mellium.im/sasl"
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/internal/integration"
 	"mellium.im/xmpp/internal/integration/ejabberd"
@@ -26,34 +24,16 @@ func TestIntegrationRoster(t *testing.T) {
 		integration.Log(),
 		prosody.ListenC2S(),
 	)
-	prosodyRun(integrationRoster)
+	prosodyRun.WithSession(nil, integrationRoster)
 
 	ejabberdRun := ejabberd.Test(context.TODO(), t,
 		integration.Log(),
 		ejabberd.ListenC2S(),
 	)
-	ejabberdRun(integrationRoster)
+	ejabberdRun.WithSession(nil, integrationRoster)
 }
 
-func integrationRoster(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
-	j, pass := cmd.User()
-	session, err := cmd.DialClient(ctx, j, t,
-		xmpp.StartTLS(&tls.Config{
-			InsecureSkipVerify: true,
-		}),
-		xmpp.SASL("", pass, sasl.Plain),
-		xmpp.BindResource(),
-	)
-	if err != nil {
-		t.Fatalf("error connecting: %v", err)
-	}
-	go func() {
-		err := session.Serve(nil)
-		if err != nil {
-			t.Logf("error from serve: %v", err)
-		}
-	}()
-
+func integrationRoster(ctx context.Context, t *testing.T, cmd *integration.Cmd, session *xmpp.Session) {
 	// The initial roster should be empty.
 	iter := roster.Fetch(ctx, session)
 	for iter.Next() {
@@ -72,7 +52,7 @@ func integrationRoster(ctx context.Context, t *testing.T, cmd *integration.Cmd)
 		Name:  "name",
 		Group: []string{"group"},
 	}
-	err = roster.Set(ctx, session, firstItem)
+	err := roster.Set(ctx, session, firstItem)
 	if err != nil {
 		t.Errorf("error adding first JID to roster: %v", err)
 	}
@@ -0,0 +1,255 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"context"
+	"encoding/xml"
+	"sort"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/storage"
+)
+
+// Request is a presence subscription request that has not yet been approved
+// or denied.
+type Request struct {
+	// JID is the bare JID of the contact that wants to subscribe to the users
+	// presence.
+	JID jid.JID
+
+	// Status is the optional message that was sent with the request.
+	Status string
+
+	// Received is when the request was first seen.
+	Received time.Time
+}
+
+// RequestStore persists pending subscription requests so that they survive
+// restarts of the application.
+// Contact addresses are always bare JIDs.
+type RequestStore interface {
+	// PutRequest stores a request, replacing any existing request from the same
+	// contact.
+	// It may be called more than once for the same request.
+	PutRequest(r Request) error
+
+	// RemoveRequest removes the request from contact if one exists.
+	RemoveRequest(contact jid.JID) error
+
+	// Requests returns all stored requests.
+	Requests() ([]Request, error)
+}
+
+// HandleRequests returns an option that registers q to receive subscription
+// requests and their cancellations.
+func HandleRequests(q *Queue) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.Presence(stanza.SubscribePresence, xml.Name{}, q)(m)
+		mux.Presence(stanza.UnsubscribePresence, xml.Name{}, q)(m)
+	}
+}
+
+// Queue accumulates presence subscription requests until the user decides
+// whether to approve or deny them.
+// Requests that are canceled by the contact before a decision is made are
+// removed from the queue.
+//
+// The zero value is a queue that stores requests in memory.
+// A Queue is safe for concurrent use.
+type Queue struct {
+	// Store persists requests.
+	// If Store is nil, requests are only kept in memory.
+	Store RequestStore
+
+	mu  sync.Mutex
+	mem map[string]Request
+}
+
+// HandlePresence implements mux.PresenceHandler.
+func (q *Queue) HandlePresence(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+	switch p.Type {
+	case stanza.SubscribePresence:
+		req := struct {
+			stanza.Presence
+			Status string `xml:"status"`
+		}{}
+		err := xml.NewTokenDecoder(t).Decode(&req)
+		if err != nil {
+			return err
+		}
+		return q.put(Request{
+			JID:      p.From.Bare(),
+			Status:   req.Status,
+			Received: time.Now(),
+		})
+	case stanza.UnsubscribePresence:
+		return q.remove(p.From.Bare())
+	}
+	return nil
+}
+
+func (q *Queue) put(r Request) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.Store != nil {
+		return q.Store.PutRequest(r)
+	}
+	key := r.JID.String()
+	// The mux may call the handler once for each child of the presence, keep
+	// the time that the request was first seen.
+	if old, ok := q.mem[key]; ok {
+		r.Received = old.Received
+	}
+	if q.mem == nil {
+		q.mem = make(map[string]Request)
+	}
+	q.mem[key] = r
+	return nil
+}
+
+func (q *Queue) remove(j jid.JID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.Store != nil {
+		return q.Store.RemoveRequest(j)
+	}
+	delete(q.mem, j.String())
+	return nil
+}
+
+// Pending returns the requests that have not yet been approved or denied
+// sorted by the time they were received.
+func (q *Queue) Pending() ([]Request, error) {
+	q.mu.Lock()
+	var reqs []Request
+	if q.Store != nil {
+		var err error
+		reqs, err = q.Store.Requests()
+		if err != nil {
+			q.mu.Unlock()
+			return nil, err
+		}
+	} else {
+		for _, r := range q.mem {
+			reqs = append(reqs, r)
+		}
+	}
+	q.mu.Unlock()
+
+	sort.SliceStable(reqs, func(i, j int) bool {
+		return reqs[i].Received.Before(reqs[j].Received)
+	})
+	return reqs, nil
+}
+
+// Approve allows each contact to subscribe to the users presence and removes
+// their requests from the queue.
+// If an error is encountered, the remaining contacts are left in the queue.
+func (q *Queue) Approve(ctx context.Context, s *xmpp.Session, contacts ...jid.JID) error {
+	return q.answer(ctx, s, stanza.SubscribedPresence, contacts)
+}
+
+// Deny rejects the subscription requests of each contact and removes their
+// requests from the queue.
+// If an error is encountered, the remaining contacts are left in the queue.
+func (q *Queue) Deny(ctx context.Context, s *xmpp.Session, contacts ...jid.JID) error {
+	return q.answer(ctx, s, stanza.UnsubscribedPresence, contacts)
+}
+
+func (q *Queue) answer(ctx context.Context, s *xmpp.Session, typ stanza.PresenceType, contacts []jid.JID) error {
+	for _, j := range contacts {
+		j = j.Bare()
+		err := s.Send(ctx, stanza.Presence{To: j, Type: typ}.Wrap(nil))
+		if err != nil {
+			return err
+		}
+		err = q.remove(j)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kvRequestPrefix is prepended to the keys used by KVRequestStore so that the
+// same KV can be shared with other packages.
+const kvRequestPrefix = "roster/request/"
+
+// KVRequestStore returns a RequestStore that stores requests in kv under keys
+// starting with "roster/request/".
+func KVRequestStore(kv storage.KV) RequestStore {
+	return kvRequestStore{kv: kv}
+}
+
+type kvRequestStore struct {
+	kv storage.KV
+}
+
+type storedRequest struct {
+	XMLName  xml.Name  `xml:"request"`
+	JID      jid.JID   `xml:"jid,attr"`
+	Received time.Time `xml:"received,attr"`
+	Status   string    `xml:"status,omitempty"`
+}
+
+func (s kvRequestStore) PutRequest(r Request) error {
+	key := kvRequestPrefix + r.JID.String()
+	// Keep the time that the request was first seen if it is stored more than
+	// once.
+	if v, err := s.kv.Get(key); err == nil {
+		var old storedRequest
+		if xml.Unmarshal(v, &old) == nil {
+			r.Received = old.Received
+		}
+	}
+	v, err := xml.Marshal(storedRequest{
+		JID:      r.JID,
+		Received: r.Received,
+		Status:   r.Status,
+	})
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(key, v)
+}
+
+func (s kvRequestStore) RemoveRequest(contact jid.JID) error {
+	return s.kv.Delete(kvRequestPrefix + contact.Bare().String())
+}
+
+func (s kvRequestStore) Requests() ([]Request, error) {
+	keys, err := s.kv.Keys(kvRequestPrefix)
+	if err != nil {
+		return nil, err
+	}
+	reqs := make([]Request, 0, len(keys))
+	for _, key := range keys {
+		v, err := s.kv.Get(key)
+		if err == storage.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var r storedRequest
+		err = xml.Unmarshal(v, &r)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, Request{
+			JID:      r.JID,
+			Status:   r.Status,
+			Received: r.Received,
+		})
+	}
+	return reqs, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/storage"
+)
+
+// requestStore records changes to a store so that the test can wait on them.
+type requestStore struct {
+	roster.RequestStore
+	changed chan string
+}
+
+func (s *requestStore) PutRequest(r roster.Request) error {
+	err := s.RequestStore.PutRequest(r)
+	s.changed <- "put " + r.JID.String()
+	return err
+}
+
+func (s *requestStore) RemoveRequest(j jid.JID) error {
+	err := s.RequestStore.RemoveRequest(j)
+	s.changed <- "remove " + j.String()
+	return err
+}
+
+func TestQueue(t *testing.T) {
+	store := &requestStore{
+		RequestStore: roster.KVRequestStore(&storage.Mem{}),
+		changed:      make(chan string, 10),
+	}
+	q := &roster.Queue{Store: store}
+	answers := make(chan stanza.Presence, 2)
+	cs := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(roster.HandleRequests(q))),
+		xmpptest.ServerHandler(mux.New(
+			mux.PresenceFunc(stanza.SubscribedPresence, xml.Name{}, func(p stanza.Presence, _ xmlstream.TokenReadEncoder) error {
+				answers <- p
+				return nil
+			}),
+			mux.PresenceFunc(stanza.UnsubscribedPresence, xml.Name{}, func(p stanza.Presence, _ xmlstream.TokenReadEncoder) error {
+				answers <- p
+				return nil
+			}),
+		)),
+	)
+	defer cs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, raw := range []string{
+		`<presence xmlns="jabber:client" from="romeo@montague.lit/orchard" type="subscribe"><status>It is my lady</status></presence>`,
+		`<presence xmlns="jabber:client" from="tybalt@capulet.lit" type="subscribe"/>`,
+		`<presence xmlns="jabber:client" from="benvolio@montague.lit" type="subscribe"/>`,
+		`<presence xmlns="jabber:client" from="benvolio@montague.lit" type="unsubscribe"/>`,
+	} {
+		err := cs.Server.Send(ctx, xml.NewDecoder(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("error sending presence: %v", err)
+		}
+	}
+	for _, want := range []string{
+		"put romeo@montague.lit",
+		"put tybalt@capulet.lit",
+		"put benvolio@montague.lit",
+		"remove benvolio@montague.lit",
+	} {
+		select {
+		case got := <-store.changed:
+			if got != want {
+				t.Fatalf("wrong store operation: want=%q, got=%q", want, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	pending, err := q.Pending()
+	if err != nil {
+		t.Fatalf("error listing pending requests: %v", err)
+	}
+	if len(pending) != 2 || pending[0].JID.String() != "romeo@montague.lit" || pending[0].Status != "It is my lady" || pending[1].JID.String() != "tybalt@capulet.lit" {
+		t.Fatalf("wrong pending requests: %+v", pending)
+	}
+
+	err = q.Approve(ctx, cs.Client, pending[0].JID)
+	if err != nil {
+		t.Fatalf("error approving request: %v", err)
+	}
+	err = q.Deny(ctx, cs.Client, pending[1].JID)
+	if err != nil {
+		t.Fatalf("error denying request: %v", err)
+	}
+	for _, want := range []stanza.Presence{
+		{To: pending[0].JID, Type: stanza.SubscribedPresence},
+		{To: pending[1].JID, Type: stanza.UnsubscribedPresence},
+	} {
+		select {
+		case p := <-answers:
+			if !p.To.Equal(want.To) || p.Type != want.Type {
+				t.Errorf("wrong answer: want=%s to %v, got=%s to %v", want.Type, want.To, p.Type, p.To)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s", want.Type)
+		}
+	}
+	pending, err = q.Pending()
+	if err != nil {
+		t.Fatalf("error listing pending requests: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected queue to be empty, got %+v", pending)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/paging"
 	"mellium.im/xmpp/stanza"
 )
 
@@ -24,9 +25,7 @@ const (
 
 // Iter is an iterator over roster items.
 type Iter struct {
-	iter    *xmlstream.Iter
-	current Item
-	err     error
+	*paging.ResultIter
 }
 
 // Handle returns an option that registers a Handler for roster pushes.
@@ -49,50 +48,10 @@ func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml
 	return h.Push(item)
 }
 
-// Next returns true if there are more items to decode.
-func (i *Iter) Next() bool {
-	if i.err != nil || !i.iter.Next() {
-		return false
-	}
-	start, r := i.iter.Current()
-	// If we encounter a lone token that doesn't begin with a start element (eg.
-	// a comment) skip it. This should never happen with XMPP, but we don't want
-	// to panic in case this somehow happens so just skip it.
-	if start == nil {
-		return i.Next()
-	}
-	d := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r))
-	item := Item{}
-	i.err = d.Decode(&item)
-	if i.err != nil {
-		return false
-	}
-	i.current = item
-	return true
-}
-
-// Err returns the last error encountered by the iterator (if any).
-func (i *Iter) Err() error {
-	if i.err != nil {
-		return i.err
-	}
-
-	return i.iter.Err()
-}
-
 // Item returns the last roster item parsed by the iterator.
 func (i *Iter) Item() Item {
-	return i.current
-}
-
-// Close indicates that we are finished with the given iterator and processing
-// the stream may continue.
-// Calling it multiple times has no effect.
-func (i *Iter) Close() error {
-	if i.iter == nil {
-		return nil
-	}
-	return i.iter.Close()
+	item, _ := i.Current().(Item)
+	return item
 }
 
 // Fetch requests the roster and returns an iterator over all roster items
@@ -113,14 +72,21 @@ func FetchIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session) *Iter {
 		iq.Type = stanza.GetIQ
 	}
 	rosterIQ := IQ{IQ: iq}
-	iter, err := s.IterIQ(ctx, rosterIQ.TokenReader())
-	if err != nil {
-		return &Iter{err: err}
-	}
-
-	// Return the iterator which will parse the rest of the payload incrementally.
 	return &Iter{
-		iter: iter,
+		ResultIter: paging.NewResultIter(ctx,
+			func(ctx context.Context, _ *paging.RequestNext) (*paging.Iter, error) {
+				iter, err := s.IterIQ(ctx, rosterIQ.TokenReader())
+				if err != nil {
+					return nil, err
+				}
+				return paging.WrapIter(iter, 0), nil
+			},
+			func(start xml.StartElement, r xml.TokenReader) (interface{}, error) {
+				item := Item{}
+				err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(start), r)).Decode(&item)
+				return item, err
+			},
+		),
 	}
 }
 
@@ -254,30 +220,20 @@ func (item Item) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
 }
 
 // Set creates a new roster item or updates an existing item.
+// If the server rejects the change, the stanza error is returned.
 func Set(ctx context.Context, s *xmpp.Session, item Item) error {
-	q := IQ{
-		IQ: stanza.IQ{Type: stanza.SetIQ},
-	}
+	q := IQ{}
 	q.Query.Item = append(q.Query.Item, item)
-	resp, err := s.SendIQ(ctx, q.TokenReader())
-	if err != nil {
-		return err
-	}
-	return resp.Close()
+	return s.UnmarshalIQElement(ctx, q.payload(), stanza.IQ{Type: stanza.SetIQ}, nil)
 }
 
 // Delete removes a roster item from the users roster.
+// If the server rejects the change, the stanza error is returned.
 func Delete(ctx context.Context, s *xmpp.Session, j jid.JID) error {
-	q := IQ{
-		IQ: stanza.IQ{Type: stanza.SetIQ},
-	}
+	q := IQ{}
 	q.Query.Item = append(q.Query.Item, Item{
 		JID:          j,
 		Subscription: "remove",
 	})
-	resp, err := s.SendIQ(ctx, q.TokenReader())
-	if err != nil {
-		return err
-	}
-	return resp.Close()
+	return s.UnmarshalIQElement(ctx, q.payload(), stanza.IQ{Type: stanza.SetIQ}, nil)
 }
@@ -13,6 +13,7 @@ import (
 	"mellium.im/xmpp/internal/integration"
 	"mellium.im/xmpp/internal/integration/mcabber"
 	"mellium.im/xmpp/internal/integration/mellium"
+	"mellium.im/xmpp/internal/integration/slixmpp"
 	"mellium.im/xmpp/jid"
 )
 
@@ -45,4 +46,16 @@ func integrationSASLClient(ctx context.Context, t *testing.T, cmd *integration.C
 	mcabberRun(func(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
 		t.Log("Connected successfully!")
 	})
+
+	slixmppRun := slixmpp.Test(context.TODO(), t,
+		integration.Log(),
+		slixmpp.ConfigFile(slixmpp.Config{
+			JID:      j,
+			Password: pass,
+			Port:     p,
+		}),
+	)
+	slixmppRun(func(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
+		t.Log("Connected successfully!")
+	})
 }
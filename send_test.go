@@ -10,6 +10,8 @@ import (
 	"crypto/rand"
 	"encoding/xml"
 	"errors"
+	"io"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -310,3 +312,43 @@ func TestSend(t *testing.T) {
 		})
 	}
 }
+
+func TestBroadcast(t *testing.T) {
+	var got []string
+	s := xmpptest.NewClientServer(xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "to" {
+				got = append(got, attr.Value)
+			}
+		}
+		for {
+			tok, err := t.Token()
+			switch {
+			case err == io.EOF:
+				return nil
+			case err != nil:
+				return err
+			}
+			if data, ok := tok.(xml.CharData); ok {
+				got = append(got, string(data))
+			}
+		}
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := s.Client.Broadcast(ctx, stanza.Presence{To: to, ID: "123"}.Wrap(
+		xmlstream.Wrap(xmlstream.Token(xml.CharData("away")), xml.StartElement{Name: xml.Name{Local: "status"}}),
+	), jid.MustParse("a@example.net"), jid.MustParse("b@example.net"), jid.MustParse(`c@example.net/"&`))
+	if err != nil {
+		t.Errorf("unexpected error broadcasting: %v", err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Errorf("unexpected error closing session: %v", err)
+	}
+	want := []string{"a@example.net", "away", "b@example.net", "away", `c@example.net/"&`, "away"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("wrong recipients or payloads: want=%v, got=%v", want, got)
+	}
+}
@@ -7,6 +7,7 @@
 package xmpp
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
@@ -15,12 +16,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"runtime/pprof"
 	"sync"
 	"time"
 
 	"mellium.im/xmlstream"
+	"mellium.im/xmpp/compat"
 	"mellium.im/xmpp/dial"
 	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/labels"
 	"mellium.im/xmpp/internal/marshal"
 	"mellium.im/xmpp/internal/ns"
 	intstream "mellium.im/xmpp/internal/stream"
@@ -90,7 +94,12 @@ type Session struct {
 	stateMutex sync.RWMutex
 
 	// The stream feature namespaces advertised for the current streams.
-	features map[string]interface{}
+	features      map[string]interface{}
+	featuresMutex sync.RWMutex
+
+	// The stream features used during negotiation, kept so that feature lists
+	// received after negotiation can be parsed.
+	streamFeatures []StreamFeature
 
 	// The negotiated features (by namespace) for the current session.
 	negotiated map[string]struct{}
@@ -98,6 +107,17 @@ type Session struct {
 	sentIQMutex sync.Mutex
 	sentIQs     map[string]chan xmlstream.TokenReadCloser
 
+	// Workarounds applied to the input stream.
+	compat compat.Mode
+
+	// The time the session was created.
+	started time.Time
+
+	bus Bus
+
+	// The pprof labels of the context used to create the session.
+	labels pprof.LabelSet
+
 	in struct {
 		stream.Info
 		d      xml.TokenReader
@@ -117,6 +137,13 @@ type Session struct {
 
 var _ tlsConn = (*Session)(nil)
 
+// Bus returns the event bus associated with the session.
+// Extension packages may be configured to publish events to it so that they
+// can all be subscribed to in one place.
+func (s *Session) Bus() *Bus {
+	return &s.bus
+}
+
 // ConnectionState returns the underlying connection's TLS state or the zero
 // value if TLS has not been negotiated.
 func (s *Session) ConnectionState() tls.ConnectionState {
@@ -129,6 +156,9 @@ func (s *Session) ConnectionState() tls.ConnectionState {
 // NewSession creates an XMPP session from the initiating entity's perspective
 // using negotiate to manage the initial handshake.
 // Calling NewSession with a nil Negotiator panics.
+// If a stream error is sent by the other side (or returned by a stream
+// feature) during negotiation, the underlying connection is closed and the
+// error is returned.
 //
 // For more information see the Negotiator type.
 func NewSession(ctx context.Context, location, origin jid.JID, rw io.ReadWriter, state SessionState, negotiate Negotiator) (*Session, error) {
@@ -138,6 +168,9 @@ func NewSession(ctx context.Context, location, origin jid.JID, rw io.ReadWriter,
 // ReceiveSession creates an XMPP session from the receiving server's
 // perspective using negotiate to manage the initial handshake.
 // Calling ReceiveSession with a nil Negotiator panics.
+// If a stream error is sent by the other side (or returned by a stream
+// feature) during negotiation, the underlying connection is closed and the
+// error is returned.
 //
 // For more information see the Negotiator type.
 func ReceiveSession(ctx context.Context, rw io.ReadWriter, state SessionState, negotiate Negotiator) (*Session, error) {
@@ -190,6 +223,8 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 		negotiated: make(map[string]struct{}),
 		sentIQs:    make(map[string]chan xmlstream.TokenReadCloser),
 		state:      state,
+		started:    time.Now(),
+		labels:     labels.From(ctx),
 	}
 
 	if s.state&Received == Received {
@@ -207,7 +242,7 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 	if tc, ok := s.conn.(tlsConn); ok {
 		s.connState = tc.ConnectionState
 	}
-	s.out.Locker = &sync.Mutex{}
+	s.out.Locker = &priorityLock{}
 	s.in.Locker = &sync.Mutex{}
 	s.in.d = xml.NewDecoder(s.conn)
 	s.out.e = xml.NewEncoder(s.conn)
@@ -238,6 +273,14 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 		}
 		mask, rw, data, err = negotiate(ctx, &s.in.Info, &s.out.Info, s, data)
 		if err != nil {
+			var se stream.Error
+			if errors.As(err, &se) {
+				// Stream errors are unrecoverable so close the underlying connection.
+				// We don't try to close the stream first because the other side may
+				// have already stopped reading and the write could block forever.
+				/* #nosec */
+				s.conn.Close()
+			}
 			return s, err
 		}
 		if rw != nil {
@@ -251,18 +294,21 @@ func negotiateSession(ctx context.Context, location, origin jid.JID, rw io.ReadW
 			if tc, ok := s.conn.(tlsConn); ok {
 				s.connState = tc.ConnectionState
 			}
-			s.in.d = xml.NewDecoder(s.conn)
+			s.in.d = xml.NewDecoder(compat.NewReader(s.conn, s.compat))
 			s.out.e = xml.NewEncoder(s.conn)
 		}
 		s.state |= mask
 	}
 
 	s.in.d = intstream.Reader(s.in.d)
+	if s.compat != 0 {
+		s.in.d = compat.InheritLang(s.in.Info.Lang)(s.in.d)
+	}
 	streamNS := ns.Client
 	if s.state&S2S == S2S {
 		streamNS = ns.Server
 	}
-	se := &stanzaEncoder{TokenWriteFlusher: s.out.e, ns: streamNS}
+	se := &stanzaEncoder{TokenWriteFlusher: s.out.e, ns: streamNS, lang: s.out.Info.Lang}
 	if s.state&S2S == S2S {
 		se.from = s.LocalAddr()
 	}
@@ -400,7 +446,21 @@ func ReceiveServerSession(ctx context.Context, location, origin jid.JID, rw io.R
 // so the handler should not close over the session or use any of its send
 // methods or a deadlock will occur.
 // After Serve finishes running the handler, it flushes the output stream.
-func (s *Session) Serve(h Handler) (err error) {
+//
+// For use in pprof profiles, the session is served from a separate goroutine
+// that is labeled with the session's local and remote addresses in addition to
+// any labels on the context that was used to create the session.
+// The labels of the goroutine that calls Serve are not changed.
+func (s *Session) Serve(h Handler) error {
+	ctx := pprof.WithLabels(context.Background(), s.labels)
+	errs := make(chan error, 1)
+	go pprof.Do(ctx, labels.Session(s.LocalAddr(), s.RemoteAddr(), "serve"), func(context.Context) {
+		errs <- s.serve(h)
+	})
+	return <-errs
+}
+
+func (s *Session) serve(h Handler) (err error) {
 	if h == nil {
 		h = nopHandler{}
 	}
@@ -519,6 +579,15 @@ func handleInputStream(s *Session, handler Handler) (err error) {
 		return fmt.Errorf("xmpp: stream in a bad state, expected start element or whitespace but got %T", tok)
 	}
 
+	// If the server sent us an updated features list, parse it using the
+	// features that we negotiated.
+	if start.Name.Space == stream.NS && start.Name.Local == featuresLocal {
+		if s.State()&Received == Received {
+			return intstream.ErrUnknownStreamElement
+		}
+		return updateFeatures(s.in.ctx, s, xmlstream.Inner(r))
+	}
+
 	// If this is a stanza, normalize the "from" attribute.
 	if stanza.Is(start.Name) {
 		for i, attr := range start.Attr {
@@ -650,6 +719,8 @@ func (rw *responseChecker) EncodeElement(v interface{}, start xml.StartElement)
 // by the server for the current stream. If it was data will be the canonical
 // representation of the feature as returned by the feature's Parse function.
 func (s *Session) Feature(namespace string) (data interface{}, ok bool) {
+	s.featuresMutex.RLock()
+	defer s.featuresMutex.RUnlock()
 	data, ok = s.features[namespace]
 	return data, ok
 }
@@ -820,6 +891,20 @@ func (s *Session) OutSID() string {
 	return s.out.ID
 }
 
+// InLang returns the default language of the input stream as declared by the
+// remote entity in its stream header.
+// Stanzas that do not have an xml:lang attribute should be treated as if they
+// were in this language.
+func (s *Session) InLang() string {
+	return s.in.Info.Lang
+}
+
+// OutLang returns the default language of the output stream.
+// For more information see the Lang field on StreamConfig.
+func (s *Session) OutLang() string {
+	return s.out.Info.Lang
+}
+
 // LocalAddr returns the Origin address for initiated connections, or the
 // Location for received connections.
 func (s *Session) LocalAddr() jid.JID {
@@ -873,10 +958,12 @@ func (s *Session) EncodeIQElement(ctx context.Context, payload interface{}, iq s
 }
 
 // Encode writes the XML encoding of v to the stream.
+// If other writes are waiting, stanzas are sent in order of their Priority,
+// which is determined from the name of the element that v encodes to.
 //
 // For more information see "encoding/xml".Encode.
 func (s *Session) Encode(ctx context.Context, v interface{}) error {
-	s.out.Lock()
+	s.lockOut(ctx, marshal.Name(v))
 	defer s.out.Unlock()
 
 	defer setWriteDeadline(ctx, s.conn)()
@@ -888,7 +975,7 @@ func (s *Session) Encode(ctx context.Context, v interface{}) error {
 //
 // For more information see "encoding/xml".EncodeElement.
 func (s *Session) EncodeElement(ctx context.Context, v interface{}, start xml.StartElement) error {
-	s.out.Lock()
+	s.lockOut(ctx, start.Name)
 	defer s.out.Unlock()
 
 	defer setWriteDeadline(ctx, s.conn)()
@@ -896,6 +983,7 @@ func (s *Session) EncodeElement(ctx context.Context, v interface{}, start xml.St
 }
 
 // Send transmits the first element read from the provided token reader.
+// If other writes are waiting, stanzas are sent in order of their Priority.
 //
 // Send is safe for concurrent use by multiple goroutines.
 func (s *Session) Send(ctx context.Context, r xml.TokenReader) error {
@@ -910,12 +998,104 @@ func (s *Session) SendElement(ctx context.Context, r xml.TokenReader, start xml.
 	return send(ctx, s, r, &start)
 }
 
-func send(ctx context.Context, s *Session, r xml.TokenReader, start *xml.StartElement) error {
-	s.out.Lock()
+// Broadcast transmits the first element read from the provided token reader
+// once for each address in to, setting the "to" attribute of each copy to the
+// recipient's address.
+// The element is read and serialized once, and only the value of the "to"
+// attribute is written for each recipient, making this cheaper than marshaling
+// the same stanza (for example, a presence broadcast to all occupants of a chat
+// room or all contacts of a gateway user) thousands of times.
+// The session is locked while all copies are written.
+//
+// Every copy has the same ID.
+// If the element does not have an id attribute, one is generated.
+// Broadcast is safe for concurrent use by multiple goroutines.
+func (s *Session) Broadcast(ctx context.Context, r xml.TokenReader, to ...jid.JID) error {
+	tok, err := r.Token()
+	if err != nil {
+		return err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return errNotStart
+	}
+
+	// Put an empty "to" attribute first so that it can be found in the output
+	// and the address filled in for each recipient.
+	attrs := []xml.Attr{{Name: xml.Name{Local: "to"}}}
+	for _, a := range start.Attr {
+		if a.Name.Space == "" && a.Name.Local == "to" {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	start.Attr = attrs
+
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	var w xmlstream.TokenWriteFlusher = e
+	if se, ok := s.out.e.(*stanzaEncoder); ok {
+		w = &stanzaEncoder{TokenWriteFlusher: e, from: se.from, ns: se.ns, lang: se.lang}
+	}
+	err = w.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	_, err = xmlstream.Copy(w, xmlstream.Inner(r))
+	if err != nil {
+		return err
+	}
+	err = w.EncodeToken(start.End())
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	// Attribute values are escaped, so the first occurrence is always the
+	// attribute that we added.
+	p := buf.Bytes()
+	toAttr := []byte(` to="`)
+	idx := bytes.Index(p, append(toAttr, '"'))
+	if idx == -1 {
+		return errors.New("xmpp: could not find to attribute in broadcast stanza")
+	}
+	idx += len(toAttr)
+	prefix, suffix := p[:idx], p[idx:]
+
+	s.lockOut(ctx, start.Name)
 	defer s.out.Unlock()
 
 	defer setWriteDeadline(ctx, s.conn)()
 
+	// Make sure anything already written to the encoder is sent first.
+	err = s.out.e.Flush()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(s.conn)
+	for _, j := range to {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err = bw.Write(prefix)
+		if err != nil {
+			return err
+		}
+		err = xml.EscapeText(bw, []byte(j.String()))
+		if err != nil {
+			return err
+		}
+		_, err = bw.Write(suffix)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func send(ctx context.Context, s *Session, r xml.TokenReader, start *xml.StartElement) error {
 	if start == nil {
 		tok, err := r.Token()
 		if err != nil {
@@ -930,6 +1110,11 @@ func send(ctx context.Context, s *Session, r xml.TokenReader, start *xml.StartEl
 		r = xmlstream.Inner(r)
 	}
 
+	s.lockOut(ctx, start.Name)
+	defer s.out.Unlock()
+
+	defer setWriteDeadline(ctx, s.conn)()
+
 	err := s.out.e.EncodeToken(*start)
 	if err != nil {
 		return err
@@ -1038,6 +1223,7 @@ func (s *Session) SendIQElement(ctx context.Context, payload xml.TokenReader, iq
 // UnmarshalIQ is like SendIQ except that error replies are unmarshaled into a
 // stanza.Error and returned and otherwise the response payload is unmarshaled
 // into v.
+// If the response does not have a payload, v is left unchanged.
 // For more information see SendIQ.
 //
 // UnmarshalIQ is safe for concurrent use by multiple goroutines.
@@ -1140,10 +1326,9 @@ func unmarshalIQ(ctx context.Context, iq xml.TokenReader, v interface{}, s *Sess
 	if err != nil {
 		return err
 	}
-	d := xml.NewTokenDecoder(resp)
 	if iqStart.Type == stanza.ErrorIQ {
 		var err stanza.Error
-		decodeErr := d.Decode(&err)
+		decodeErr := xml.NewTokenDecoder(resp).Decode(&err)
 		if decodeErr != nil {
 			return decodeErr
 		}
@@ -1153,7 +1338,19 @@ func unmarshalIQ(ctx context.Context, iq xml.TokenReader, v interface{}, s *Sess
 	if v == nil {
 		return nil
 	}
-	return d.Decode(v)
+	// If the response has no payload, leave v unchanged.
+	for {
+		tok, err = resp.Token()
+		switch tok.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			return xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(tok), resp)).Decode(v)
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
 func (s *Session) sendResp(ctx context.Context, id string, payload xml.TokenReader, start xml.StartElement) (xmlstream.TokenReadCloser, error) {
@@ -1198,6 +1395,7 @@ type stanzaEncoder struct {
 	depth int
 	from  jid.JID
 	ns    string
+	lang  string
 }
 
 func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
@@ -1209,7 +1407,7 @@ func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
 			if tok.Name.Space == "" {
 				tok.Name.Space = se.ns
 			}
-			var foundID, foundFrom bool
+			var foundID, foundFrom, foundLang bool
 			attrs := tok.Attr[:0]
 			for _, attr := range tok.Attr {
 				switch attr.Name.Local {
@@ -1232,6 +1430,11 @@ func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
 						continue
 					}
 					foundFrom = true
+				case "lang":
+					// RFC6120 § 8.1.5
+					// If the stanza does not possess an 'xml:lang' attribute, it inherits
+					// the default language of the stream.
+					foundLang = foundLang || attr.Name.Space == ns.XML || attr.Name.Space == "xml"
 				}
 				attrs = append(attrs, attr)
 			}
@@ -1248,6 +1451,12 @@ func (se *stanzaEncoder) EncodeToken(t xml.Token) error {
 					Value: attr.RandomID(),
 				})
 			}
+			if se.lang != "" && !foundLang {
+				tok.Attr = append(tok.Attr, xml.Attr{
+					Name:  xml.Name{Space: ns.XML, Local: "lang"},
+					Value: se.lang,
+				})
+			}
 		}
 
 		// For all start elements, regardless of depth, prevent duplicate xmlns
@@ -11,14 +11,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/compat"
 	intstream "mellium.im/xmpp/internal/stream"
 	"mellium.im/xmpp/internal/xmpptest"
 	"mellium.im/xmpp/jid"
@@ -405,7 +408,10 @@ func TestNegotiateStreamError(t *testing.T) {
 	defer cancel()
 	clientConn, serverConn := net.Pipe()
 	clientJID := jid.MustParse("me@example.net")
+	done := make(chan struct{})
+	defer func() { <-done }()
 	go func() {
+		defer close(done)
 		s, err := xmpp.ReceiveSession(ctx, serverConn, 0, xmpp.NewNegotiator(xmpp.StreamConfig{
 			Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
 				return []xmpp.StreamFeature{errorStartTLS(stream.Conflict)}
@@ -428,3 +434,248 @@ func TestNegotiateStreamError(t *testing.T) {
 		t.Errorf("unexpected client err: want=%v, got=%v", stream.Conflict, err)
 	}
 }
+
+func TestNegotiateStreamErrorCloses(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	sent := make(chan string, 1)
+	go func() {
+		// Read until the client closes the connection.
+		read := make(chan error, 1)
+		go func() {
+			_, err := ioutil.ReadAll(serverConn)
+			read <- err
+		}()
+		_, err := io.WriteString(serverConn, `<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' id='1' from='example.net' version='1.0'><stream:error><policy-violation xmlns='urn:ietf:params:xml:ns:xmpp-streams'/><text xmlns='urn:ietf:params:xml:ns:xmpp-streams'>too many connections</text></stream:error>`)
+		if err != nil {
+			sent <- err.Error()
+			return
+		}
+		err = <-read
+		if err != nil {
+			sent <- err.Error()
+			return
+		}
+		sent <- ""
+	}()
+	clientJID := jid.MustParse("me@example.net")
+	_, err := xmpp.NewSession(context.Background(), clientJID.Domain(), clientJID, clientConn, 0, xmpp.NewNegotiator(xmpp.StreamConfig{}))
+	if !errors.Is(err, stream.PolicyViolation) {
+		t.Errorf("unexpected client err: want=%v, got=%v", stream.PolicyViolation, err)
+	}
+	const wantErr = "policy-violation: too many connections"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("wrong error text: want=%q, got=%v", wantErr, err)
+	}
+	if errStr := <-sent; errStr != "" {
+		t.Errorf("expected the client to close the connection, got error: %s", errStr)
+	}
+}
+
+func TestNegotiateGreetingTimeout(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	// Read everything the client sends but never respond so that the client
+	// stalls waiting for the stream header.
+	go func() {
+		/* #nosec */
+		io.Copy(ioutil.Discard, serverConn)
+	}()
+	clientJID := jid.MustParse("me@example.net")
+	_, err := xmpp.NewSession(context.Background(), clientJID.Domain(), clientJID, clientConn, 0, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Timeouts: xmpp.Timeouts{
+			Greeting: 10 * time.Millisecond,
+		},
+	}))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got: %v", err)
+	}
+}
+
+func TestNegotiateCompat(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		/* #nosec */
+		io.Copy(ioutil.Discard, serverConn)
+	}()
+	go func() {
+		/* #nosec */
+		io.WriteString(serverConn, "\ufeff<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' id='1' from='example.net' version='1.0' xml:lang='fr'><stream:features/><message><body>\xff</body></message>")
+	}()
+	clientJID := jid.MustParse("me@example.net")
+	s, err := xmpp.NewSession(context.Background(), clientJID.Domain(), clientJID, clientConn, 0, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Compat: compat.Lenient,
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	msg := struct {
+		stanza.Message
+		Body string `xml:"body"`
+	}{}
+	r := s.TokenReader()
+	defer r.Close()
+	err = xml.NewTokenDecoder(r).Decode(&msg)
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	if msg.Lang != "fr" {
+		t.Errorf("message did not inherit stream language: want=fr, got=%q", msg.Lang)
+	}
+	if msg.Body != "\ufffd" {
+		t.Errorf("invalid UTF-8 was not replaced: got=%q", msg.Body)
+	}
+}
+
+func TestStreamLang(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	out := make(chan string, 1)
+	go func() {
+		b, _ := ioutil.ReadAll(serverConn)
+		out <- string(b)
+	}()
+	go func() {
+		/* #nosec */
+		io.WriteString(serverConn, `<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' id='1' from='example.net' version='1.0' xml:lang='fr'><stream:features/>`)
+	}()
+	clientJID := jid.MustParse("me@example.net")
+	s, err := xmpp.NewSession(context.Background(), clientJID.Domain(), clientJID, clientConn, 0, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Lang: "en",
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	if lang := s.InLang(); lang != "fr" {
+		t.Errorf("wrong input stream language: want=fr, got=%q", lang)
+	}
+	if lang := s.OutLang(); lang != "en" {
+		t.Errorf("wrong output stream language: want=en, got=%q", lang)
+	}
+	err = s.Send(context.Background(), stanza.Message{ID: "1"}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	err = s.Send(context.Background(), stanza.Message{ID: "2", Lang: "de"}.Wrap(nil))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	/* #nosec */
+	clientConn.Close()
+
+	const want = `<message xmlns="jabber:client" type="" id="1" xml:lang="en"></message><message xmlns="jabber:client" type="" id="2" xml:lang="de"></message>`
+	if o := <-out; !strings.HasSuffix(o, want) {
+		t.Errorf("wrong output:\nwant suffix=%s,\n got=%s", want, o)
+	}
+}
+
+func TestUpdateFeatures(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		/* #nosec */
+		io.Copy(ioutil.Discard, serverConn)
+	}()
+	go func() {
+		/* #nosec */
+		io.WriteString(serverConn, `<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' id='1' from='example.net' version='1.0'><stream:features/><stream:features><test xmlns='urn:example' v='2'/><other xmlns='urn:other'/></stream:features><message/></stream:stream>`)
+	}()
+	var updated interface{}
+	feature := xmpp.StreamFeature{
+		Name: xml.Name{Space: "urn:example", Local: "test"},
+		Parse: func(ctx context.Context, d *xml.Decoder, start *xml.StartElement) (bool, interface{}, error) {
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "v" {
+					return false, attr.Value, nil
+				}
+			}
+			return false, nil, nil
+		},
+		Negotiate: func(context.Context, *xmpp.Session, interface{}) (xmpp.SessionState, io.ReadWriter, error) {
+			return 0, nil, nil
+		},
+		Update: func(_ context.Context, _ *xmpp.Session, data interface{}) error {
+			updated = data
+			return nil
+		},
+	}
+	clientJID := jid.MustParse("me@example.net")
+	s, err := xmpp.NewSession(context.Background(), clientJID.Domain(), clientJID, clientConn, 0, xmpp.NewNegotiator(xmpp.StreamConfig{
+		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
+			return []xmpp.StreamFeature{feature}
+		},
+	}))
+	if err != nil {
+		t.Fatalf("error negotiating session: %v", err)
+	}
+	var msgs int
+	err = s.Serve(xmpp.HandlerFunc(func(_ xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		if start.Name.Local == "message" {
+			msgs++
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("error serving session: %v", err)
+	}
+	if msgs != 1 {
+		t.Errorf("wrong number of messages handled after features update: want=1, got=%d", msgs)
+	}
+	if updated != "2" {
+		t.Errorf("update was not called with the parsed data: want=2, got=%v", updated)
+	}
+	if data, ok := s.Feature("urn:example"); !ok || data != "2" {
+		t.Errorf("feature data was not updated: want=2, got=%v (%t)", data, ok)
+	}
+	if _, ok := s.Feature("urn:other"); !ok {
+		t.Errorf("expected unsupported feature to be advertised")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s := xmpptest.NewSession(xmpp.Authn, &bytes.Buffer{})
+	snap := s.Snapshot()
+	if snap.State != s.State() {
+		t.Errorf("wrong state: want=%v, got=%v", s.State(), snap.State)
+	}
+	if !snap.LocalAddr.Equal(s.LocalAddr()) || !snap.RemoteAddr.Equal(s.RemoteAddr()) {
+		t.Errorf("wrong addresses: want=%v/%v, got=%v/%v", s.LocalAddr(), s.RemoteAddr(), snap.LocalAddr, snap.RemoteAddr)
+	}
+	if snap.InSID != s.InSID() || snap.OutSID != s.OutSID() {
+		t.Errorf("wrong stream IDs: want=%q/%q, got=%q/%q", s.InSID(), s.OutSID(), snap.InSID, snap.OutSID)
+	}
+	if snap.TLS != nil {
+		t.Errorf("expected no TLS state on insecure session, got %+v", snap.TLS)
+	}
+	if snap.PendingIQs != 0 {
+		t.Errorf("expected no pending IQs, got %d", snap.PendingIQs)
+	}
+	if snap.Started.IsZero() || snap.Uptime < 0 {
+		t.Errorf("invalid start time or uptime: %v, %v", snap.Started, snap.Uptime)
+	}
+}
+
+func TestUnmarshalIQEmptyResult(t *testing.T) {
+	s := xmpptest.NewClientServer(xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		_, err := xmlstream.Copy(t, stanza.IQ{ID: testIQID, Type: stanza.ResultIQ}.Wrap(nil))
+		return err
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	v := struct {
+		XMLName xml.Name `xml:"urn:xmpp:time time"`
+		TZO     string   `xml:"tzo"`
+	}{TZO: "unchanged"}
+	err := s.Client.UnmarshalIQElement(ctx, nil, stanza.IQ{
+		ID:   testIQID,
+		Type: stanza.GetIQ,
+	}, &v)
+	if err != nil {
+		t.Fatalf("unexpected error unmarshaling empty result: %v", err)
+	}
+	if v.TZO != "unchanged" {
+		t.Errorf("value was modified by empty result: %+v", v)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package sign attaches and verifies cryptographic assertions on stanzas.
+//
+// It is meant to be used by gateways and other servers that need to prove the
+// origin of stanzas that cross federated hops.
+// Each entity that handles a stanza may attach an assertion element containing
+// its address and a signature over the stanza.
+// The signature algorithm and key management are left to the user; this
+// package only provides the extension point, the canonical form that is
+// signed, and the wire format of the assertion.
+//
+// The canonical form covers the name of the stanza, its "from", "id", "to", and
+// "type" attributes, and its payload excluding any assertions in the configured
+// namespace.
+// Entities that rewrite any of these (for example, a gateway that translates
+// addresses) invalidate existing assertions and should attach their own.
+package sign // import "mellium.im/xmpp/sign"
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/stanzaname"
+	"mellium.im/xmpp/jid"
+)
+
+// Errors returned by this package.
+var (
+	ErrNoAssertion = errors.New("sign: stanza has no valid assertion")
+	ErrNotStanza   = errors.New("sign: expected a stanza")
+	ErrNoSign      = errors.New("sign: no Sign function configured")
+	ErrNoVerify    = errors.New("sign: no Verify function configured")
+)
+
+// Assertion is a signature over a stanza made by Origin.
+type Assertion struct {
+	XMLName   xml.Name
+	Origin    jid.JID
+	Signature []byte
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (a Assertion) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(a.Signature))),
+		xml.StartElement{
+			Name: xml.Name{Space: a.XMLName.Space, Local: "assertion"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "origin"}, Value: a.Origin.String()}},
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (a Assertion) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, a.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (a Assertion) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := a.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (a *Assertion) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		XMLName   xml.Name
+		Origin    jid.JID `xml:"origin,attr"`
+		Signature string  `xml:",chardata"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return err
+	}
+	*a = Assertion{
+		XMLName:   s.XMLName,
+		Origin:    s.Origin,
+		Signature: sig,
+	}
+	return nil
+}
+
+// Config controls how assertions are attached to and verified on stanzas.
+type Config struct {
+	// NS is the namespace of the assertion elements.
+	NS string
+
+	// Origin is the address used when attaching assertions.
+	Origin jid.JID
+
+	// Sign returns a signature over data.
+	// If it is not set, Attach returns ErrNoSign when it encounters a stanza.
+	Sign func(data []byte) ([]byte, error)
+
+	// Verify returns an error if sig is not a valid signature over data made by
+	// origin.
+	// If it is not set, Check returns ErrNoVerify.
+	Verify func(origin jid.JID, data, sig []byte) error
+}
+
+// Attach returns a transformer that signs any stanzas in the token stream and
+// appends an assertion to them.
+// Stanzas are signed wherever they appear, including inside elements that are
+// not stanzas such as the stream element.
+// Stanzas that are part of the payload of another stanza (for example,
+// forwarded messages) are only covered by the signature of the outer stanza.
+// Any other elements are passed through unchanged.
+func (c Config) Attach() xmlstream.Transformer {
+	return func(r xml.TokenReader) xml.TokenReader {
+		var queue []xml.Token
+		return xmlstream.ReaderFunc(func() (xml.Token, error) {
+			if len(queue) > 0 {
+				tok := queue[0]
+				queue = queue[1:]
+				return tok, nil
+			}
+			tok, err := r.Token()
+			if tok == nil {
+				return tok, err
+			}
+			t, ok := tok.(xml.StartElement)
+			if !ok || !stanzaname.Is(t.Name) {
+				return tok, err
+			}
+			if c.Sign == nil {
+				return nil, ErrNoSign
+			}
+			stanza, e := readStanza(t, r)
+			if e != nil {
+				return nil, e
+			}
+			sig, e := c.Sign(canonical(c.NS, stanza))
+			if e != nil {
+				return nil, e
+			}
+			assertion, e := xmlstream.ReadAll(Assertion{
+				XMLName:   xml.Name{Space: c.NS, Local: "assertion"},
+				Origin:    c.Origin,
+				Signature: sig,
+			}.TokenReader())
+			if e != nil {
+				return nil, e
+			}
+			end := stanza[len(stanza)-1]
+			queue = append(queue, stanza[1:len(stanza)-1]...)
+			queue = append(queue, assertion...)
+			queue = append(queue, end)
+			return stanza[0], err
+		})
+	}
+}
+
+// Check reads a stanza from r and checks the assertions attached to it.
+// It returns the origins of all valid assertions and a token reader that
+// replays the stanza.
+// If the stanza does not contain any valid assertions, ErrNoAssertion is
+// returned.
+func (c Config) Check(r xml.TokenReader) (xml.TokenReader, []jid.JID, error) {
+	if c.Verify == nil {
+		return nil, nil, ErrNoVerify
+	}
+	tok, err := r.Token()
+	if err != nil && (tok == nil || err != io.EOF) {
+		return nil, nil, err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || !stanzaname.Is(start.Name) {
+		return nil, nil, ErrNotStanza
+	}
+	stanza, err := readStanza(start, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	replay := xmlstream.MultiReader(tokens(stanza)...)
+
+	data := canonical(c.NS, stanza)
+	var origins []jid.JID
+	d := xml.NewTokenDecoder(xmlstream.MultiReader(tokens(stanza)...))
+	// Pop the stanza start element.
+	_, err = d.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space != c.NS || start.Name.Local != "assertion" {
+			err = d.Skip()
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		var a Assertion
+		err = d.DecodeElement(&a, &start)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.Verify(a.Origin, data, a.Signature) == nil {
+			origins = append(origins, a.Origin)
+		}
+	}
+	if len(origins) == 0 {
+		return replay, nil, ErrNoAssertion
+	}
+	return replay, origins, nil
+}
+
+// readStanza reads tokens from r until the end of the element started by start
+// and returns all tokens including start and the end element.
+func readStanza(start xml.StartElement, r xml.TokenReader) ([]xml.Token, error) {
+	toks := []xml.Token{start.Copy()}
+	depth := 1
+	for depth > 0 {
+		tok, err := r.Token()
+		if tok != nil {
+			switch t := tok.(type) {
+			case xml.StartElement:
+				depth++
+			case xml.EndElement:
+				depth--
+			default:
+				tok = xml.CopyToken(t)
+			}
+			toks = append(toks, tok)
+		}
+		if err == io.EOF && depth > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return toks, nil
+}
+
+func tokens(toks []xml.Token) []xml.TokenReader {
+	readers := make([]xml.TokenReader, 0, len(toks))
+	for _, tok := range toks {
+		readers = append(readers, xmlstream.Token(tok))
+	}
+	return readers
+}
+
+// canonical returns the data that is signed for a stanza.
+func canonical(assertionNS string, stanza []xml.Token) []byte {
+	var b bytes.Buffer
+	e := xml.NewEncoder(&b)
+	var skip int
+	for i, tok := range stanza {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 {
+				skip++
+				continue
+			}
+			if i == 0 {
+				var attrs []xml.Attr
+				for _, a := range t.Attr {
+					if a.Name.Space == "" {
+						switch a.Name.Local {
+						case "from", "id", "to", "type":
+							attrs = append(attrs, a)
+						}
+					}
+				}
+				t = xml.StartElement{Name: xml.Name{Local: t.Name.Local}, Attr: attrs}
+			} else if t.Name.Space == assertionNS && t.Name.Local == "assertion" {
+				skip = 1
+				continue
+			}
+			tok = canonicalStart(t)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if i == len(stanza)-1 {
+				tok = xml.EndElement{Name: xml.Name{Local: t.Name.Local}}
+			}
+		case xml.ProcInst, xml.Comment, xml.Directive:
+			continue
+		default:
+			if skip > 0 {
+				continue
+			}
+		}
+		// Errors are not possible when encoding to a bytes.Buffer with balanced
+		// tokens.
+		/* #nosec */
+		e.EncodeToken(tok)
+	}
+	/* #nosec */
+	e.Flush()
+	return b.Bytes()
+}
+
+// canonicalStart removes namespace declarations (the encoder adds them back
+// based on the element names) and sorts the remaining attributes.
+func canonicalStart(start xml.StartElement) xml.StartElement {
+	attrs := make([]xml.Attr, 0, len(start.Attr))
+	for _, a := range start.Attr {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].Name.Space != attrs[j].Name.Space {
+			return attrs[i].Name.Space < attrs[j].Name.Space
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+	start.Attr = attrs
+	return start
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package sign_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/sign"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = sign.Assertion{}
+	_ xml.Unmarshaler     = (*sign.Assertion)(nil)
+	_ xmlstream.Marshaler = sign.Assertion{}
+	_ xmlstream.WriterTo  = sign.Assertion{}
+)
+
+const testNS = "urn:example:assertion"
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &sign.Assertion{
+				XMLName:   xml.Name{Space: testNS, Local: "assertion"},
+				Origin:    jid.MustParse("gateway.example.net"),
+				Signature: []byte("sig"),
+			},
+			XML: `<assertion xmlns="urn:example:assertion" origin="gateway.example.net">c2ln</assertion>`,
+		},
+	})
+}
+
+func newConfig(t *testing.T) sign.Config {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	origin := jid.MustParse("gateway.example.net")
+	return sign.Config{
+		NS:     testNS,
+		Origin: origin,
+		Sign: func(data []byte) ([]byte, error) {
+			return ed25519.Sign(priv, data), nil
+		},
+		Verify: func(o jid.JID, data, sig []byte) error {
+			if !o.Equal(origin) || !ed25519.Verify(pub, data, sig) {
+				return errors.New("bad signature")
+			}
+			return nil
+		},
+	}
+}
+
+func attach(t *testing.T, c sign.Config, r xml.TokenReader) string {
+	var b bytes.Buffer
+	e := xml.NewEncoder(&b)
+	_, err := xmlstream.Copy(e, c.Attach()(r))
+	if err != nil {
+		t.Fatalf("error attaching assertion: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	return b.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := newConfig(t)
+	msg := stanza.Message{
+		ID:   "123",
+		To:   jid.MustParse("juliet@example.com"),
+		From: jid.MustParse("romeo@example.net"),
+		Type: stanza.ChatMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Token(xml.CharData("Wherefore art thou?")),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	))
+	signed := attach(t, c, msg)
+	if !strings.Contains(signed, `<assertion xmlns="`+testNS+`" origin="gateway.example.net">`) {
+		t.Fatalf("no assertion attached: %s", signed)
+	}
+
+	r, origins, err := c.Check(xml.NewDecoder(strings.NewReader(signed)))
+	if err != nil {
+		t.Fatalf("error verifying %s: %v", signed, err)
+	}
+	if len(origins) != 1 || !origins[0].Equal(c.Origin) {
+		t.Errorf("wrong origins: want=[%v], got=%v", c.Origin, origins)
+	}
+	var b bytes.Buffer
+	e := xml.NewEncoder(&b)
+	_, err = xmlstream.Copy(e, r)
+	if err != nil {
+		t.Fatalf("error replaying stanza: %v", err)
+	}
+	if err = e.Flush(); err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	if !strings.Contains(b.String(), "Wherefore art thou?") {
+		t.Errorf("replayed stanza missing payload: %s", b.String())
+	}
+
+	tampered := strings.Replace(signed, "Wherefore", "Where", 1)
+	_, _, err = c.Check(xml.NewDecoder(strings.NewReader(tampered)))
+	if err != sign.ErrNoAssertion {
+		t.Errorf("wrong error for tampered stanza: want=%v, got=%v", sign.ErrNoAssertion, err)
+	}
+}
+
+func TestCheckNotStanza(t *testing.T) {
+	c := newConfig(t)
+	_, _, err := c.Check(xml.NewDecoder(strings.NewReader(`<foo/>`)))
+	if err != sign.ErrNotStanza {
+		t.Errorf("wrong error: want=%v, got=%v", sign.ErrNotStanza, err)
+	}
+}
+
+func TestAttachPassthrough(t *testing.T) {
+	c := newConfig(t)
+	const in = `<foo xmlns="urn:example"><message>not a stanza</message></foo>`
+	out := attach(t, c, xml.NewDecoder(strings.NewReader(in)))
+	if strings.Contains(out, "assertion") {
+		t.Errorf("assertion attached to non-stanza: %s", out)
+	}
+}
+
+func TestAttachWrapped(t *testing.T) {
+	c := newConfig(t)
+	const in = `<stream:stream xmlns="jabber:server" xmlns:stream="http://etherx.jabber.org/streams"><message id="1"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" id="2"/></forwarded></message><presence id="3"/></stream:stream>`
+	out := attach(t, c, xml.NewDecoder(strings.NewReader(in)))
+	if n := strings.Count(out, "<assertion "); n != 2 {
+		t.Errorf("expected top level stanzas inside the stream to be signed once each, got %d assertions: %s", n, out)
+	}
+}
+
+func TestNoFuncs(t *testing.T) {
+	c := newConfig(t)
+	c.Sign = nil
+	c.Verify = nil
+	_, err := xmlstream.ReadAll(c.Attach()(xml.NewDecoder(strings.NewReader(`<message xmlns="jabber:client"/>`))))
+	if err != sign.ErrNoSign {
+		t.Errorf("wrong error attaching: want=%v, got=%v", sign.ErrNoSign, err)
+	}
+	_, _, err = c.Check(xml.NewDecoder(strings.NewReader(`<message xmlns="jabber:client"/>`)))
+	if err != sign.ErrNoVerify {
+		t.Errorf("wrong error checking: want=%v, got=%v", sign.ErrNoVerify, err)
+	}
+}
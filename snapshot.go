@@ -0,0 +1,75 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpp
+
+import (
+	"crypto/tls"
+	"sort"
+	"time"
+
+	"mellium.im/xmpp/jid"
+)
+
+// Snapshot is a point in time view of the state of a session.
+// It is meant to be used for debugging and health checks in long running
+// services.
+type Snapshot struct {
+	// State is the current state of the session.
+	State SessionState
+
+	// LocalAddr and RemoteAddr are the addresses negotiated for the session.
+	// For more information see the LocalAddr and RemoteAddr methods on Session.
+	LocalAddr  jid.JID
+	RemoteAddr jid.JID
+
+	// InSID and OutSID are the stream IDs of the input and output streams.
+	InSID  string
+	OutSID string
+
+	// TLS is the state of the underlying TLS connection, or nil if the session
+	// is not secure.
+	TLS *tls.ConnectionState
+
+	// Negotiated is a sorted list of the namespaces of the features that were
+	// negotiated on the current stream.
+	// If stream management was negotiated, its namespace will be in the list.
+	Negotiated []string
+
+	// PendingIQs is the number of IQs that have been sent using SendIQ (or one of
+	// the similar methods) and are still waiting for a response.
+	PendingIQs int
+
+	// Started is the time the session was created and Uptime is the time that
+	// has elapsed since then.
+	Started time.Time
+	Uptime  time.Duration
+}
+
+// Snapshot returns a snapshot of the current state of the session.
+func (s *Session) Snapshot() Snapshot {
+	snap := Snapshot{
+		State:      s.State(),
+		LocalAddr:  s.LocalAddr(),
+		RemoteAddr: s.RemoteAddr(),
+		InSID:      s.InSID(),
+		OutSID:     s.OutSID(),
+		Started:    s.started,
+		Uptime:     time.Since(s.started),
+	}
+	if snap.State&Secure == Secure && s.connState != nil {
+		connState := s.connState()
+		snap.TLS = &connState
+	}
+	for k := range s.negotiated {
+		snap.Negotiated = append(snap.Negotiated, k)
+	}
+	sort.Strings(snap.Negotiated)
+
+	s.sentIQMutex.Lock()
+	snap.PendingIQs = len(s.sentIQs)
+	s.sentIQMutex.Unlock()
+
+	return snap
+}
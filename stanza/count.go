@@ -0,0 +1,111 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza
+
+import (
+	"encoding/xml"
+	"sync/atomic"
+
+	"mellium.im/xmlstream"
+)
+
+// Counter counts stanzas using the same rules as XEP-0198: Stream Management.
+// Only top level message, presence, and iq elements are counted, nonzas (such
+// as stream management requests and acknowledgements) are not.
+// Elements are counted once they have been completely read or written.
+// Because stanzas that are being written may not have a namespace until they
+// reach the session, stanzas with an empty namespace are counted as well as
+// those in the client and server namespaces.
+//
+// Like the sequence numbers used by stream management, the count wraps back to
+// zero when it reaches 2^32.
+// The zero value is a counter that starts at 0.
+// A Counter is safe for concurrent use by multiple goroutines.
+type Counter struct {
+	n uint32
+}
+
+// Load returns the number of stanzas counted.
+func (c *Counter) Load() uint32 {
+	return atomic.LoadUint32(&c.n)
+}
+
+// Store sets the number of stanzas counted, for example when a stream is
+// resumed.
+func (c *Counter) Store(n uint32) {
+	atomic.StoreUint32(&c.n, n)
+}
+
+// Reader returns a token reader that counts the stanzas read from r.
+// It can be used as an xmlstream.Transformer.
+func (c *Counter) Reader(r xml.TokenReader) xml.TokenReader {
+	var t tracker
+	return xmlstream.ReaderFunc(func() (xml.Token, error) {
+		tok, err := r.Token()
+		if tok != nil && t.complete(tok) {
+			atomic.AddUint32(&c.n, 1)
+		}
+		return tok, err
+	})
+}
+
+// Writer returns a token writer that counts the stanzas written to w.
+func (c *Counter) Writer(w xmlstream.TokenWriter) xmlstream.TokenWriter {
+	return &countWriter{w: w, c: c}
+}
+
+type countWriter struct {
+	w xmlstream.TokenWriter
+	c *Counter
+	t tracker
+}
+
+func (cw *countWriter) EncodeToken(tok xml.Token) error {
+	err := cw.w.EncodeToken(tok)
+	if err != nil {
+		return err
+	}
+	if cw.t.complete(tok) {
+		atomic.AddUint32(&cw.c.n, 1)
+	}
+	return nil
+}
+
+// Flush calls the underlying writer's Flush method if it has one.
+func (cw *countWriter) Flush() error {
+	if f, ok := cw.w.(xmlstream.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// tracker keeps track of the depth of tokens in a stream and whether the
+// current top level element is a stanza.
+type tracker struct {
+	depth    int
+	inStanza bool
+}
+
+// complete reports whether tok is the end of a top level stanza.
+func (t *tracker) complete(tok xml.Token) bool {
+	switch tt := tok.(type) {
+	case xml.StartElement:
+		if t.depth == 0 {
+			t.inStanza = Is(tt.Name) || (tt.Name.Space == "" && isStanzaName(tt.Name.Local))
+		}
+		t.depth++
+	case xml.EndElement:
+		t.depth--
+		if t.depth == 0 && t.inStanza {
+			t.inStanza = false
+			return true
+		}
+	}
+	return false
+}
+
+func isStanzaName(local string) bool {
+	return local == "iq" || local == "message" || local == "presence"
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/stanza"
+)
+
+var counterTestCases = [...]struct {
+	in string
+	n  uint32
+}{
+	0: {},
+	1: {in: `<message xmlns="jabber:client"><body>test</body></message>`, n: 1},
+	2: {in: `<iq xmlns="jabber:server"/><presence xmlns="jabber:client"/>`, n: 2},
+	3: {in: `<r xmlns="urn:xmpp:sm:3"/><a xmlns="urn:xmpp:sm:3" h="1"/>`},
+	4: {in: `<message/><iq/><presence/>`, n: 3},
+	5: {in: `<message xmlns="urn:example"/>`},
+	6: {in: `<foo><message xmlns="jabber:client"/></foo>`},
+	7: {in: `<message xmlns="jabber:client"><iq xmlns="jabber:client"/></message>`, n: 1},
+	8: {in: `<message xmlns="jabber:client">`},
+}
+
+func TestCounterReader(t *testing.T) {
+	for i, tc := range counterTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var c stanza.Counter
+			r := c.Reader(xml.NewDecoder(strings.NewReader(tc.in)))
+			for {
+				_, err := r.Token()
+				if err != nil {
+					break
+				}
+			}
+			if n := c.Load(); n != tc.n {
+				t.Errorf("wrong count: want=%d, got=%d", tc.n, n)
+			}
+		})
+	}
+}
+
+func TestCounterWriter(t *testing.T) {
+	for i, tc := range counterTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var c stanza.Counter
+			var buf bytes.Buffer
+			e := xml.NewEncoder(&buf)
+			w := c.Writer(e)
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			for {
+				tok, err := d.Token()
+				if err != nil {
+					break
+				}
+				err = w.EncodeToken(tok)
+				if err != nil {
+					t.Fatalf("error encoding token: %v", err)
+				}
+			}
+			if n := c.Load(); n != tc.n {
+				t.Errorf("wrong count: want=%d, got=%d", tc.n, n)
+			}
+		})
+	}
+}
+
+func TestCounterWrap(t *testing.T) {
+	var c stanza.Counter
+	c.Store(1<<32 - 1)
+	var tr xmlstream.Transformer = c.Reader
+	r := tr(xml.NewDecoder(strings.NewReader(`<iq xmlns="jabber:client"/>`)))
+	_, err := xmlstream.Copy(xml.NewEncoder(ioutil.Discard), r)
+	if err != nil {
+		t.Fatalf("error copying tokens: %v", err)
+	}
+	if n := c.Load(); n != 0 {
+		t.Errorf("expected counter to wrap to 0, got %d", n)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza
+
+import (
+	"encoding/xml"
+	"strings"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+)
+
+// dedupKey identifies a stanza by the entity that assigned the ID, the kind of
+// ID, and the ID itself.
+type dedupKey struct {
+	entity string
+	local  string
+	id     string
+}
+
+// dedupCache remembers the last window keys that were seen.
+type dedupCache struct {
+	mu   sync.Mutex
+	seen map[dedupKey]struct{}
+	ring []dedupKey
+	next int
+}
+
+// check reports whether any of keys has been seen before and records them.
+func (c *dedupCache) check(keys []dedupKey) (dup bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		if _, ok := c.seen[k]; ok {
+			dup = true
+		}
+	}
+	for _, k := range keys {
+		if _, ok := c.seen[k]; ok {
+			continue
+		}
+		if len(c.ring) == cap(c.ring) {
+			delete(c.seen, c.ring[c.next])
+			c.ring[c.next] = k
+			c.next = (c.next + 1) % len(c.ring)
+		} else {
+			c.ring = append(c.ring, k)
+		}
+		c.seen[k] = struct{}{}
+	}
+	return dup
+}
+
+// Dedup returns a transformer that silently drops stanzas that have already
+// been seen.
+// This is useful when the same message may be received more than once, for
+// example when it is delivered live, as a carbon copy, and again while
+// catching up with a message archive.
+//
+// Stanzas are identified by any stanza ID (keyed on the entity in its "by"
+// attribute) or origin ID (keyed on the "from" attribute of the stanza) that
+// they contain.
+// IDs in stanzas that are forwarded (for example, message carbons) are also
+// used, as is the ID of a message archive result which is the stanza ID that
+// the archive assigned to the forwarded message.
+// Stanzas that do not contain any of these IDs and elements that are not
+// stanzas are never dropped.
+// At most window IDs are remembered; once the limit is reached the oldest ID is
+// forgotten to make room for a new one.
+// Because a single stanza may contain several IDs, this may cover fewer than
+// the last window stanzas.
+// If window is less than 1, the returned transformer does nothing.
+//
+// The IDs are shared by all token readers created by the transformer so the
+// same transformer can be used for each element read from a session.
+// Because stanzas have to be read in their entirety before it can be
+// determined whether they are duplicates, each stanza is buffered in memory.
+func Dedup(window int) xmlstream.Transformer {
+	if window < 1 {
+		return func(r xml.TokenReader) xml.TokenReader {
+			return r
+		}
+	}
+	cache := &dedupCache{
+		seen: make(map[dedupKey]struct{}, window),
+		ring: make([]dedupKey, 0, window),
+	}
+	return func(r xml.TokenReader) xml.TokenReader {
+		return &dedupReader{r: r, cache: cache}
+	}
+}
+
+type dedupReader struct {
+	r     xml.TokenReader
+	cache *dedupCache
+	buf   []xml.Token
+	err   error
+}
+
+func (r *dedupReader) Token() (xml.Token, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return nil, r.err
+		}
+		tok, err := r.r.Token()
+		if err != nil {
+			r.err = err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || !Is(start.Name) {
+			if tok == nil {
+				continue
+			}
+			return tok, err
+		}
+
+		r.buf = append(r.buf[:0], start.Copy())
+		if err != nil {
+			break
+		}
+		var keys []dedupKey
+		// parents contains the start elements of all elements that are open
+		// inside of the stanza.
+		parents := []xml.StartElement{start}
+		for {
+			tok, err = r.r.Token()
+			if tok != nil {
+				tok = xml.CopyToken(tok)
+				r.buf = append(r.buf, tok)
+				switch t := tok.(type) {
+				case xml.StartElement:
+					keys = appendKeys(keys, parents, t)
+					parents = append(parents, t)
+				case xml.EndElement:
+					parents = parents[:len(parents)-1]
+				}
+			}
+			if err != nil || len(parents) == 0 {
+				break
+			}
+		}
+		if err != nil {
+			// If we can't read the entire stanza, return what we have and let the
+			// caller handle the error.
+			r.err = err
+			break
+		}
+		if len(keys) > 0 && r.cache.check(keys) {
+			r.buf = r.buf[:0]
+		}
+	}
+	tok := r.buf[0]
+	r.buf = r.buf[1:]
+	return tok, nil
+}
+
+const (
+	nsForward   = "urn:xmpp:forward:0"
+	nsMAMPrefix = "urn:xmpp:mam:"
+)
+
+// appendKeys appends the key for child, if any, to keys.
+// parents are the elements that child is nested in, starting with the stanza
+// being checked.
+//
+// Stanza IDs and origin IDs are used if they are children of the stanza or
+// children of a stanza that is forwarded (for example, by message carbons or a
+// message archive).
+// The ID of a message archive result is also used because it is the stanza ID
+// assigned to the forwarded message by the archive.
+func appendKeys(keys []dedupKey, parents []xml.StartElement, child xml.StartElement) []dedupKey {
+	n := len(parents)
+	parent := parents[n-1]
+	switch {
+	case n == 1:
+		if k, ok := resultKey(parent, child); ok {
+			return append(keys, k)
+		}
+	case !Is(parent.Name) || parents[n-2].Name != (xml.Name{Space: nsForward, Local: "forwarded"}):
+		return keys
+	}
+	if k, ok := idKey(parent, child); ok {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// resultKey returns a stanza ID key for child if it is a message archive
+// result.
+// Results from the users own archive do not have a from attribute, so the
+// archive is assumed to be the bare JID of the recipient.
+func resultKey(stanza, child xml.StartElement) (dedupKey, bool) {
+	if child.Name.Local != "result" || !strings.HasPrefix(child.Name.Space, nsMAMPrefix) {
+		return dedupKey{}, false
+	}
+	k := dedupKey{local: "stanza-id"}
+	for _, a := range child.Attr {
+		if a.Name.Local == "id" && a.Name.Space == "" {
+			k.id = a.Value
+		}
+	}
+	var to string
+	for _, a := range stanza.Attr {
+		switch {
+		case a.Name.Space != "":
+		case a.Name.Local == "from":
+			k.entity = a.Value
+		case a.Name.Local == "to":
+			to = a.Value
+		}
+	}
+	if k.entity == "" && to != "" {
+		j, err := jid.Parse(to)
+		if err != nil {
+			return dedupKey{}, false
+		}
+		k.entity = j.Bare().String()
+	}
+	if k.id == "" {
+		return dedupKey{}, false
+	}
+	return k, true
+}
+
+// idKey returns the key for child if it is a stanza ID or origin ID.
+func idKey(stanza, child xml.StartElement) (dedupKey, bool) {
+	if child.Name.Space != NSSid {
+		return dedupKey{}, false
+	}
+	var entityAttr string
+	switch child.Name.Local {
+	case "stanza-id":
+		entityAttr = "by"
+	case "origin-id":
+		entityAttr = "from"
+	default:
+		return dedupKey{}, false
+	}
+	k := dedupKey{local: child.Name.Local}
+	for _, a := range child.Attr {
+		if a.Name.Local == "id" && a.Name.Space == "" {
+			k.id = a.Value
+		}
+		if entityAttr == "by" && a.Name.Local == "by" && a.Name.Space == "" {
+			k.entity = a.Value
+		}
+	}
+	if entityAttr == "from" {
+		for _, a := range stanza.Attr {
+			if a.Name.Local == "from" && a.Name.Space == "" {
+				k.entity = a.Value
+			}
+		}
+	}
+	if k.id == "" {
+		return dedupKey{}, false
+	}
+	return k, true
+}
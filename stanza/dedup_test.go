@@ -0,0 +1,133 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza_test
+
+import (
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp/stanza"
+)
+
+var dedupTestCases = [...]struct {
+	window int
+	in     string
+	out    string
+}{
+	0: {},
+	1: {
+		// Stanzas without IDs are never dropped.
+		window: 10,
+		in:     `<message xmlns="jabber:client"></message><message xmlns="jabber:client"></message>`,
+		out:    `<message xmlns="jabber:client"></message><message xmlns="jabber:client"></message>`,
+	},
+	2: {
+		window: 10,
+		in:     `<message xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id><body>1</body></message><message xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id><body>2</body></message>`,
+		out:    `<message xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id><body>1</body></message>`,
+	},
+	3: {
+		// Origin IDs are unique per sender.
+		window: 10,
+		in:     `<message xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message><message xmlns="jabber:client" from="b@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message>`,
+		out:    `<message xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message><message xmlns="jabber:client" from="b@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message>`,
+	},
+	4: {
+		// Stanza IDs are unique per the entity that assigned them.
+		window: 10,
+		in:     `<message xmlns="jabber:client" from="a@example.net"><stanza-id xmlns="urn:xmpp:sid:0" id="1" by="example.net"></stanza-id></message><message xmlns="jabber:client" from="b@example.net"><stanza-id xmlns="urn:xmpp:sid:0" id="1" by="example.net"></stanza-id></message><message xmlns="jabber:client"><stanza-id xmlns="urn:xmpp:sid:0" id="1" by="example.com"></stanza-id></message>`,
+		out:    `<message xmlns="jabber:client" from="a@example.net"><stanza-id xmlns="urn:xmpp:sid:0" id="1" by="example.net"></stanza-id></message><message xmlns="jabber:client"><stanza-id xmlns="urn:xmpp:sid:0" id="1" by="example.com"></stanza-id></message>`,
+	},
+	5: {
+		// Only the last window IDs are remembered.
+		window: 1,
+		in:     `<iq xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></iq><iq xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="2"></origin-id></iq><iq xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></iq>`,
+		out:    `<iq xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></iq><iq xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="2"></origin-id></iq><iq xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></iq>`,
+	},
+	6: {
+		// IDs in nested elements and non-stanzas are ignored.
+		window: 10,
+		in:     `<a><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></a><a><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></a><message xmlns="jabber:client"><x><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></x></message><message xmlns="jabber:client"><x><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></x></message>`,
+		out:    `<a><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></a><a><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></a><message xmlns="jabber:client"><x><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></x></message><message xmlns="jabber:client"><x><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></x></message>`,
+	},
+	7: {
+		// Disabled if the window is too small.
+		in:  `<message xmlns="jabber:client"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message><message xmlns="jabber:client"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message>`,
+		out: `<message xmlns="jabber:client"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message><message xmlns="jabber:client"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message>`,
+	},
+	8: {
+		// Carbons are dropped if the forwarded message was already seen.
+		window: 10,
+		in:     `<message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/pc"><stanza-id xmlns="urn:xmpp:sid:0" id="s1" by="b@example.com"></stanza-id><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message><message xmlns="jabber:client" from="b@example.com" to="b@example.com/pc"><received xmlns="urn:xmpp:carbons:2"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/laptop"><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message></forwarded></received></message>`,
+		out:    `<message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/pc"><stanza-id xmlns="urn:xmpp:sid:0" id="s1" by="b@example.com"></stanza-id><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message>`,
+	},
+	9: {
+		// Messages are dropped if they were already seen as a carbon.
+		window: 10,
+		in:     `<message xmlns="jabber:client" from="b@example.com" to="b@example.com/pc"><received xmlns="urn:xmpp:carbons:2"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/laptop"><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message></forwarded></received></message><message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/pc"><stanza-id xmlns="urn:xmpp:sid:0" id="s1" by="b@example.com"></stanza-id><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message>`,
+		out:    `<message xmlns="jabber:client" from="b@example.com" to="b@example.com/pc"><received xmlns="urn:xmpp:carbons:2"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/laptop"><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message></forwarded></received></message>`,
+	},
+	10: {
+		// Archived messages are dropped if they were already seen and vice versa.
+		window: 10,
+		in:     `<message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/pc"><stanza-id xmlns="urn:xmpp:sid:0" id="s1" by="b@example.com"></stanza-id><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message><message xmlns="jabber:client" to="b@example.com/pc"><result xmlns="urn:xmpp:mam:2" queryid="q1" id="s1"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com"><body>hi</body></message></forwarded></result></message><message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/pc"><stanza-id xmlns="urn:xmpp:sid:0" id="s1" by="b@example.com"></stanza-id><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message>`,
+		out:    `<message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/pc"><stanza-id xmlns="urn:xmpp:sid:0" id="s1" by="b@example.com"></stanza-id><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message>`,
+	},
+	11: {
+		// Archive result IDs are unique per archive.
+		window: 10,
+		in:     `<message xmlns="jabber:client" to="b@example.com/pc"><result xmlns="urn:xmpp:mam:2" queryid="q1" id="s1"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com"><body>hi</body></message></forwarded></result></message><message xmlns="jabber:client" from="room@muc.example.com" to="b@example.com/pc"><result xmlns="urn:xmpp:mam:2" queryid="q1" id="s1"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" from="room@muc.example.com/a"><body>hi</body></message></forwarded></result></message>`,
+		out:    `<message xmlns="jabber:client" to="b@example.com/pc"><result xmlns="urn:xmpp:mam:2" queryid="q1" id="s1"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com"><body>hi</body></message></forwarded></result></message><message xmlns="jabber:client" from="room@muc.example.com" to="b@example.com/pc"><result xmlns="urn:xmpp:mam:2" queryid="q1" id="s1"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" from="room@muc.example.com/a"><body>hi</body></message></forwarded></result></message>`,
+	},
+	12: {
+		// Stanzas nested in other elements are not forwarded.
+		window: 10,
+		in:     `<message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/pc"><stanza-id xmlns="urn:xmpp:sid:0" id="s1" by="b@example.com"></stanza-id><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message><message xmlns="jabber:client" from="b@example.com" to="b@example.com/pc"><x xmlns="urn:example"><message xmlns="jabber:client" from="a@example.net/phone"><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id></message></x></message>`,
+		out:    `<message xmlns="jabber:client" from="a@example.net/phone" to="b@example.com/pc"><stanza-id xmlns="urn:xmpp:sid:0" id="s1" by="b@example.com"></stanza-id><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id><body>hi</body></message><message xmlns="jabber:client" from="b@example.com" to="b@example.com/pc"><x xmlns="urn:example"><message xmlns="jabber:client" from="a@example.net/phone"><origin-id xmlns="urn:xmpp:sid:0" id="o1"></origin-id></message></x></message>`,
+	},
+}
+
+// readAll returns all tokens read from r.
+func readAll(t *testing.T, r xml.TokenReader) []xml.Token {
+	t.Helper()
+	var toks []xml.Token
+	for {
+		tok, err := r.Token()
+		if err == io.EOF {
+			return toks
+		}
+		if err != nil {
+			t.Fatalf("error reading tokens: %v", err)
+		}
+		toks = append(toks, xml.CopyToken(tok))
+	}
+}
+
+func TestDedup(t *testing.T) {
+	for i, tc := range dedupTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			got := readAll(t, stanza.Dedup(tc.window)(xml.NewDecoder(strings.NewReader(tc.in))))
+			want := readAll(t, xml.NewDecoder(strings.NewReader(tc.out)))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("wrong output:\nwant=%v,\n got=%v", want, got)
+			}
+		})
+	}
+}
+
+func TestDedupShared(t *testing.T) {
+	const msg = `<message xmlns="jabber:client" from="a@example.net"><origin-id xmlns="urn:xmpp:sid:0" id="1"></origin-id></message>`
+	dedup := stanza.Dedup(10)
+	if toks := readAll(t, dedup(xml.NewDecoder(strings.NewReader(msg)))); len(toks) != 4 {
+		t.Errorf("expected first message to be read, got %d tokens", len(toks))
+	}
+	if toks := readAll(t, dedup(xml.NewDecoder(strings.NewReader(msg)))); len(toks) != 0 {
+		t.Errorf("expected duplicate message read by new reader to be dropped, got %v", toks)
+	}
+}
@@ -259,6 +259,10 @@ type Error struct {
 	Type      ErrorType
 	Condition Condition
 	Text      map[string]string
+
+	// AppCondition is the name of an optional application-specific condition
+	// element that further qualifies the defined condition.
+	AppCondition xml.Name
 }
 
 // Wrap wraps the payload in an error.
@@ -301,6 +305,11 @@ func (se Error) Wrap(payload xml.TokenReader) xml.TokenReader {
 		)
 	}
 
+	var appCondition xml.TokenReader
+	if se.AppCondition.Local != "" {
+		appCondition = xmlstream.Wrap(nil, xml.StartElement{Name: se.AppCondition})
+	}
+
 	return xmlstream.Wrap(
 		xmlstream.MultiReader(
 			xmlstream.Wrap(
@@ -310,6 +319,7 @@ func (se Error) Wrap(payload xml.TokenReader) xml.TokenReader {
 				},
 			),
 			text,
+			appCondition,
 			payload,
 		),
 		start,
@@ -367,7 +377,7 @@ func (se Error) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
 // UnmarshalXML satisfies the xml.Unmarshaler interface for StanzaError.
 func (se *Error) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	decoded := struct {
-		Condition struct {
+		Condition []struct {
 			XMLName xml.Name
 		} `xml:",any"`
 		Type ErrorType `xml:"type,attr"`
@@ -382,8 +392,13 @@ func (se *Error) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	}
 	se.Type = decoded.Type
 	se.By = decoded.By
-	if decoded.Condition.XMLName.Space == ns.Stanza {
-		se.Condition = Condition(decoded.Condition.XMLName.Local)
+	for _, cond := range decoded.Condition {
+		switch {
+		case cond.XMLName.Space == ns.Stanza:
+			se.Condition = Condition(cond.XMLName.Local)
+		case se.AppCondition.Local == "":
+			se.AppCondition = cond.XMLName
+		}
 	}
 
 	for _, text := range decoded.Text {
@@ -130,6 +130,7 @@ func TestMarshalStanzaError(t *testing.T) {
 		3: {stanza.Error{Type: stanza.Wait, Condition: stanza.UndefinedCondition}, `<error type="wait"><undefined-condition xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></undefined-condition></error>`, false},
 		4: {stanza.Error{Type: stanza.Modify, By: jid.MustParse("test@example.net"), Condition: stanza.SubscriptionRequired}, `<error type="modify" by="test@example.net"><subscription-required xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></subscription-required></error>`, false},
 		5: {stanza.Error{Type: stanza.Continue, Condition: stanza.ServiceUnavailable, Text: simpleText}, `<error type="continue"><service-unavailable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></service-unavailable><text xmlns="urn:ietf:params:xml:ns:xmpp-stanzas">test</text></error>`, false},
+		6: {stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAcceptable, AppCondition: xml.Name{Space: "urn:xmpp:blocking:errors", Local: "blocked"}}, `<error type="cancel"><not-acceptable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></not-acceptable><blocked xmlns="urn:xmpp:blocking:errors"></blocked></error>`, false},
 	} {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
 			b, err := xml.Marshal(data.se)
@@ -188,6 +189,10 @@ func TestUnmarshalStanzaError(t *testing.T) {
 			stanza.Error{Condition: stanza.RecipientUnavailable, Text: map[string]string{
 				"ac-u": "test",
 			}}, false},
+		13: {`<error type="cancel"><not-acceptable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></not-acceptable><blocked xmlns="urn:xmpp:blocking:errors"></blocked></error>`,
+			stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAcceptable, AppCondition: xml.Name{Space: "urn:xmpp:blocking:errors", Local: "blocked"}}, false},
+		14: {`<error type="cancel"><blocked xmlns="urn:xmpp:blocking:errors"></blocked><not-acceptable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"></not-acceptable></error>`,
+			stanza.Error{Type: stanza.Cancel, Condition: stanza.NotAcceptable, AppCondition: xml.Name{Space: "urn:xmpp:blocking:errors", Local: "blocked"}}, false},
 	} {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
 			se2 := stanza.Error{}
@@ -0,0 +1,239 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"sync"
+
+	"mellium.im/xmpp/internal/ns"
+)
+
+// Payload is a child element of a stanza.
+// If the element was registered with the Registry used to decode it, Value is
+// the decoded payload and Raw is nil.
+// Otherwise Value is nil and Raw contains the tokens that make up the element
+// including its start and end element.
+type Payload struct {
+	Name  xml.Name
+	Value interface{}
+	Raw   []xml.Token
+}
+
+// Registry maps the names of stanza payloads to functions that create values
+// that the payloads can be unmarshaled into.
+// The functions must return a pointer to a value that can be unmarshaled using
+// encoding/xml.
+//
+// The zero value is an empty registry ready to use.
+// A Registry is safe for concurrent use by multiple goroutines.
+type Registry struct {
+	mu sync.RWMutex
+	m  map[xml.Name]func() interface{}
+}
+
+// DefaultRegistry is the Registry used by DecodeMessage, DecodePresence, and
+// DecodeIQ.
+// Packages that implement stanza payloads may register them with the default
+// registry.
+// It comes with stanza errors, stanza IDs, and origin IDs already registered.
+var DefaultRegistry = &Registry{}
+
+func init() {
+	DefaultRegistry.Register(xml.Name{Space: ns.Client, Local: "error"}, func() interface{} { return &Error{} })
+	DefaultRegistry.Register(xml.Name{Space: ns.Server, Local: "error"}, func() interface{} { return &Error{} })
+	DefaultRegistry.Register(xml.Name{Space: NSSid, Local: "stanza-id"}, func() interface{} { return &ID{} })
+	DefaultRegistry.Register(xml.Name{Space: NSSid, Local: "origin-id"}, func() interface{} { return &OriginID{} })
+}
+
+// Register adds f as the function that creates values for payloads named n.
+// If f is nil or n was already registered, Register panics.
+func (r *Registry) Register(n xml.Name, f func() interface{}) {
+	if f == nil {
+		panic("stanza: nil payload func")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.m[n]; ok {
+		panic("stanza: multiple registrations for {" + n.Space + "}" + n.Local)
+	}
+	if r.m == nil {
+		r.m = make(map[xml.Name]func() interface{})
+	}
+	r.m[n] = f
+}
+
+// New returns a new value for the payload named n.
+// If n has not been registered, ok is false.
+func (r *Registry) New(n xml.Name) (v interface{}, ok bool) {
+	r.mu.RLock()
+	f, ok := r.m[n]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return f(), true
+}
+
+// Payloads decodes the children of a stanza from d.
+// It reads tokens until the stanza's end element or io.EOF is reached so d
+// should be positioned just after the start element of the stanza.
+func (r *Registry) Payloads(d xml.TokenReader) ([]Payload, error) {
+	var payloads []Payload
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return payloads, nil
+		}
+		if err != nil {
+			return payloads, err
+		}
+		var start xml.StartElement
+		switch t := tok.(type) {
+		case xml.StartElement:
+			start = t.Copy()
+		case xml.EndElement:
+			return payloads, nil
+		default:
+			continue
+		}
+
+		raw, err := readElement(d, start)
+		if err != nil {
+			return payloads, err
+		}
+		p := Payload{Name: start.Name}
+		v, ok := r.New(start.Name)
+		if !ok {
+			p.Raw = raw
+			payloads = append(payloads, p)
+			continue
+		}
+		err = xml.NewTokenDecoder(&tokenSlice{toks: raw}).Decode(v)
+		if err != nil {
+			return payloads, err
+		}
+		p.Value = v
+		payloads = append(payloads, p)
+	}
+}
+
+// Message decodes a message and its payloads from d.
+func (r *Registry) Message(d xml.TokenReader) (Message, []Payload, error) {
+	start, err := nextStart(d)
+	if err != nil {
+		return Message{}, nil, err
+	}
+	msg, err := NewMessage(start)
+	if err != nil {
+		return msg, nil, err
+	}
+	payloads, err := r.Payloads(d)
+	return msg, payloads, err
+}
+
+// Presence decodes a presence and its payloads from d.
+func (r *Registry) Presence(d xml.TokenReader) (Presence, []Payload, error) {
+	start, err := nextStart(d)
+	if err != nil {
+		return Presence{}, nil, err
+	}
+	p, err := NewPresence(start)
+	if err != nil {
+		return p, nil, err
+	}
+	payloads, err := r.Payloads(d)
+	return p, payloads, err
+}
+
+// IQ decodes an IQ and its payloads from d.
+func (r *Registry) IQ(d xml.TokenReader) (IQ, []Payload, error) {
+	start, err := nextStart(d)
+	if err != nil {
+		return IQ{}, nil, err
+	}
+	iq, err := NewIQ(start)
+	if err != nil {
+		return iq, nil, err
+	}
+	payloads, err := r.Payloads(d)
+	return iq, payloads, err
+}
+
+// DecodeMessage decodes a message and its payloads from d using the default
+// registry.
+func DecodeMessage(d xml.TokenReader) (Message, []Payload, error) {
+	return DefaultRegistry.Message(d)
+}
+
+// DecodePresence decodes a presence and its payloads from d using the default
+// registry.
+func DecodePresence(d xml.TokenReader) (Presence, []Payload, error) {
+	return DefaultRegistry.Presence(d)
+}
+
+// DecodeIQ decodes an IQ and its payloads from d using the default registry.
+func DecodeIQ(d xml.TokenReader) (IQ, []Payload, error) {
+	return DefaultRegistry.IQ(d)
+}
+
+// nextStart returns the first start element read from d.
+func nextStart(d xml.TokenReader) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Copy(), nil
+		}
+		if err == io.EOF {
+			return xml.StartElement{}, errors.New("stanza: expected start element")
+		}
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+	}
+}
+
+// readElement returns start and the remaining tokens of the element it starts.
+func readElement(d xml.TokenReader, start xml.StartElement) ([]xml.Token, error) {
+	toks := []xml.Token{start}
+	depth := 1
+	for depth > 0 {
+		tok, err := d.Token()
+		if tok != nil {
+			switch tok.(type) {
+			case xml.StartElement:
+				depth++
+			case xml.EndElement:
+				depth--
+			}
+			toks = append(toks, xml.CopyToken(tok))
+		}
+		if err != nil {
+			if err == io.EOF && depth == 0 {
+				break
+			}
+			if err == io.EOF {
+				return toks, io.ErrUnexpectedEOF
+			}
+			return toks, err
+		}
+	}
+	return toks, nil
+}
+
+type tokenSlice struct {
+	toks []xml.Token
+}
+
+func (s *tokenSlice) Token() (xml.Token, error) {
+	if len(s.toks) == 0 {
+		return nil, io.EOF
+	}
+	tok := s.toks[0]
+	s.toks = s.toks[1:]
+	return tok, nil
+}
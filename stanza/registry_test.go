@@ -0,0 +1,74 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package stanza_test
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp/stanza"
+)
+
+type testPayload struct {
+	XMLName xml.Name `xml:"urn:example test"`
+	Value   string   `xml:",chardata"`
+}
+
+func TestDecodeMessage(t *testing.T) {
+	const in = `<message xmlns="jabber:client" type="chat" from="a@example.net"><body>hi</body><origin-id xmlns="urn:xmpp:sid:0" id="123"/><test xmlns="urn:example">foo</test></message>`
+	reg := &stanza.Registry{}
+	reg.Register(xml.Name{Space: "urn:example", Local: "test"}, func() interface{} { return &testPayload{} })
+	reg.Register(xml.Name{Space: stanza.NSSid, Local: "origin-id"}, func() interface{} { return &stanza.OriginID{} })
+
+	msg, payloads, err := reg.Message(xml.NewDecoder(strings.NewReader(in)))
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	if msg.Type != stanza.ChatMessage || msg.From.String() != "a@example.net" {
+		t.Errorf("wrong message decoded: %+v", msg)
+	}
+	if len(payloads) != 3 {
+		t.Fatalf("wrong number of payloads: want=3, got=%d", len(payloads))
+	}
+	if payloads[0].Value != nil || len(payloads[0].Raw) != 3 || payloads[0].Name.Local != "body" {
+		t.Errorf("expected unregistered body to be returned as raw tokens, got %+v", payloads[0])
+	}
+	if id, ok := payloads[1].Value.(*stanza.OriginID); !ok || id.ID != "123" {
+		t.Errorf("wrong origin ID: %+v", payloads[1])
+	}
+	if p, ok := payloads[2].Value.(*testPayload); !ok || p.Value != "foo" {
+		t.Errorf("wrong test payload: %+v", payloads[2])
+	}
+}
+
+func TestDecodeDefaultRegistry(t *testing.T) {
+	const in = `<iq xmlns="jabber:client" type="error" id="1"><ping xmlns="urn:xmpp:ping"/><error type="cancel"><service-unavailable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`
+	iq, payloads, err := stanza.DecodeIQ(xml.NewDecoder(strings.NewReader(in)))
+	if err != nil {
+		t.Fatalf("error decoding IQ: %v", err)
+	}
+	if iq.Type != stanza.ErrorIQ || iq.ID != "1" {
+		t.Errorf("wrong IQ decoded: %+v", iq)
+	}
+	if len(payloads) != 2 {
+		t.Fatalf("wrong number of payloads: want=2, got=%d", len(payloads))
+	}
+	if e, ok := payloads[1].Value.(*stanza.Error); !ok || e.Condition != stanza.ServiceUnavailable {
+		t.Errorf("wrong error payload: %+v", payloads[1])
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	reg := &stanza.Registry{}
+	name := xml.Name{Space: "urn:example", Local: "test"}
+	reg.Register(name, func() interface{} { return &testPayload{} })
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected duplicate registration to panic")
+		}
+	}()
+	reg.Register(name, func() interface{} { return &testPayload{} })
+}
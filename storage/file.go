@@ -0,0 +1,383 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Dir is a KV and Blob that stores each value as a file in a directory.
+// File names are derived from keys so that any key (including one containing
+// path separators) can be stored safely, even on case insensitive file systems.
+// Keys that are too long to be used in a file name are stored in a file named
+// after a hash of the key, and the key itself is stored at the start of the
+// file.
+// Values are written to a temporary file and renamed into place so that a
+// crash never leaves a partially written value behind.
+// The directory is created when the first value is stored if it does not
+// exist.
+type Dir string
+
+const (
+	// maxKeyLen is the length of the longest key that is stored in a file named
+	// after the key.
+	// Keys are hex encoded, so this keeps file names well below the 255 byte
+	// limit on the length of a name used by most file systems.
+	maxKeyLen = 100
+
+	// hashedPrefix is the prefix of files named after the hash of a key.
+	// It is not a valid hex digit so it never conflicts with an encoded key.
+	hashedPrefix = "_"
+)
+
+// path returns the file name used for key and whether it is named after the
+// hash of the key.
+func (dir Dir) path(key string) (string, bool) {
+	if len(key) > maxKeyLen {
+		h := sha256.Sum256([]byte(key))
+		return filepath.Join(string(dir), hashedPrefix+hex.EncodeToString(h[:])), true
+	}
+	return filepath.Join(string(dir), hex.EncodeToString([]byte(key))), false
+}
+
+// readKey reads the key that is stored at the start of a file named after the
+// hash of the key.
+func readKey(r io.Reader) (string, error) {
+	var size [4]byte
+	_, err := io.ReadFull(r, size[:])
+	if err != nil {
+		return "", err
+	}
+	key := make([]byte, binary.BigEndian.Uint32(size[:]))
+	_, err = io.ReadFull(r, key)
+	return string(key), err
+}
+
+// Get implements KV.
+func (dir Dir) Get(key string) ([]byte, error) {
+	r, err := dir.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	v, err := ioutil.ReadAll(r)
+	if err != nil {
+		/* #nosec */
+		r.Close()
+		return nil, err
+	}
+	return v, r.Close()
+}
+
+// Put implements KV.
+func (dir Dir) Put(key string, value []byte) error {
+	w, err := dir.Create(key)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(value)
+	if err != nil {
+		/* #nosec */
+		w.(*dirWriter).abort()
+		return err
+	}
+	return w.Close()
+}
+
+// Delete implements KV.
+func (dir Dir) Delete(key string) error {
+	return dir.Remove(key)
+}
+
+// Keys implements KV.
+func (dir Dir) Keys(prefix string) ([]string, error) {
+	infos, err := ioutil.ReadDir(string(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		var key string
+		if strings.HasPrefix(info.Name(), hashedPrefix) {
+			key, err = dir.hashedKey(info.Name())
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// Temporary files and anything else that was not created by Dir will
+			// fail to decode and are skipped.
+			k, err := hex.DecodeString(info.Name())
+			if err != nil {
+				continue
+			}
+			key = string(k)
+		}
+		if len(key) > 0 && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// hashedKey returns the key stored in the file with the given name, or an
+// empty string if the file does not exist (for example, because it was removed
+// after the directory was read).
+func (dir Dir) hashedKey(name string) (string, error) {
+	f, err := os.Open(filepath.Join(string(dir), name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	key, err := readKey(f)
+	if err != nil {
+		/* #nosec */
+		f.Close()
+		return "", err
+	}
+	return key, f.Close()
+}
+
+// Open implements Blob.
+func (dir Dir) Open(name string) (io.ReadCloser, error) {
+	path, hashed := dir.path(name)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hashed {
+		key, err := readKey(f)
+		if err != nil {
+			/* #nosec */
+			f.Close()
+			return nil, err
+		}
+		// Guard against the (unlikely) case of a hash collision.
+		if key != name {
+			/* #nosec */
+			f.Close()
+			return nil, ErrNotFound
+		}
+	}
+	return f, nil
+}
+
+// Create implements Blob.
+func (dir Dir) Create(name string) (io.WriteCloser, error) {
+	err := os.MkdirAll(string(dir), 0700)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(string(dir), ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	path, hashed := dir.path(name)
+	w := &dirWriter{File: f, name: path}
+	if hashed {
+		header := make([]byte, 4, 4+len(name))
+		binary.BigEndian.PutUint32(header, uint32(len(name)))
+		_, err = w.Write(append(header, name...))
+		if err != nil {
+			w.abort()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Remove implements Blob.
+func (dir Dir) Remove(name string) error {
+	path, _ := dir.path(name)
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+type dirWriter struct {
+	*os.File
+	name string
+}
+
+func (w *dirWriter) abort() {
+	/* #nosec */
+	w.File.Close()
+	/* #nosec */
+	os.Remove(w.File.Name())
+}
+
+func (w *dirWriter) Close() error {
+	err := w.File.Sync()
+	if err != nil {
+		w.abort()
+		return err
+	}
+	err = w.File.Close()
+	if err != nil {
+		/* #nosec */
+		os.Remove(w.File.Name())
+		return err
+	}
+	return os.Rename(w.File.Name(), w.name)
+}
+
+// Record types used by FileQueue.
+const (
+	recordAppend byte = 'a'
+	recordRemove byte = 'r'
+)
+
+// recordHeader is the length of the type and length prefix of each record.
+const recordHeader = 5
+
+// FileQueue is a Queue that stores values in a file.
+// Each change is appended to the file and synced to disk before returning, and
+// the file is truncated whenever the queue becomes empty.
+// If the process exits while writing a record the incomplete record is
+// discarded by Load.
+//
+// A FileQueue is safe for concurrent use by multiple goroutines.
+type FileQueue struct {
+	mu sync.Mutex
+	f  *os.File
+	n  int
+}
+
+// OpenQueue opens the queue file with the given name, creating it if it does
+// not exist.
+// Load should be called before any values are appended or removed.
+func OpenQueue(name string) (*FileQueue, error) {
+	/* #nosec */
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileQueue{f: f}, nil
+}
+
+// Close closes the underlying file.
+func (q *FileQueue) Close() error {
+	return q.f.Close()
+}
+
+// Append implements Queue.
+func (q *FileQueue) Append(value []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := q.write(recordAppend, value)
+	if err != nil {
+		return err
+	}
+	q.n++
+	return nil
+}
+
+// Remove implements Queue.
+func (q *FileQueue) Remove(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n > q.n {
+		n = q.n
+	}
+	if n == q.n {
+		// Nothing left to recover, so start the file over instead of letting it
+		// grow forever.
+		err := q.f.Truncate(0)
+		if err != nil {
+			return err
+		}
+		q.n = 0
+		return q.f.Sync()
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	err := q.write(recordRemove, b[:])
+	if err != nil {
+		return err
+	}
+	q.n -= n
+	return nil
+}
+
+func (q *FileQueue) write(typ byte, p []byte) error {
+	rec := make([]byte, recordHeader, recordHeader+len(p))
+	rec[0] = typ
+	binary.BigEndian.PutUint32(rec[1:], uint32(len(p)))
+	rec = append(rec, p...)
+	_, err := q.f.Write(rec)
+	if err != nil {
+		return err
+	}
+	return q.f.Sync()
+}
+
+// Load implements Queue.
+func (q *FileQueue) Load() ([][]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.f.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(q.f)
+	if err != nil {
+		return nil, err
+	}
+
+	var values [][]byte
+	var off int
+	for len(b)-off >= recordHeader {
+		size := int(binary.BigEndian.Uint32(b[off+1:]))
+		if len(b)-off-recordHeader < size {
+			break
+		}
+		p := b[off+recordHeader : off+recordHeader+size]
+		switch b[off] {
+		case recordAppend:
+			values = append(values, p)
+		case recordRemove:
+			if size == 4 {
+				n := int(binary.BigEndian.Uint32(p))
+				if n > len(values) {
+					n = len(values)
+				}
+				values = values[n:]
+			}
+		}
+		off += recordHeader + size
+	}
+	// Discard any incomplete record left over from a crash so that new records
+	// are not appended after it.
+	if off < len(b) {
+		err = q.f.Truncate(int64(off))
+		if err != nil {
+			return nil, err
+		}
+	}
+	q.n = len(values)
+	return values, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mem is a KV and Blob that stores values in memory.
+// The zero value is an empty store that is ready to use.
+//
+// A Mem is safe for concurrent use by multiple goroutines.
+type Mem struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+// Get implements KV.
+func (s *Mem) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.m[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+// Put implements KV.
+func (s *Mem) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string][]byte)
+	}
+	s.m[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// Delete implements KV.
+func (s *Mem) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	return nil
+}
+
+// Keys implements KV.
+func (s *Mem) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.m {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Open implements Blob.
+func (s *Mem) Open(name string) (io.ReadCloser, error) {
+	v, err := s.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(v)), nil
+}
+
+// Create implements Blob.
+func (s *Mem) Create(name string) (io.WriteCloser, error) {
+	return &memWriter{s: s, name: name}, nil
+}
+
+// Remove implements Blob.
+func (s *Mem) Remove(name string) error {
+	return s.Delete(name)
+}
+
+type memWriter struct {
+	bytes.Buffer
+	s    *Mem
+	name string
+}
+
+func (w *memWriter) Close() error {
+	return w.s.Put(w.name, w.Bytes())
+}
+
+// MemQueue is a Queue that stores values in memory.
+// The zero value is an empty queue that is ready to use.
+//
+// A MemQueue is safe for concurrent use by multiple goroutines.
+type MemQueue struct {
+	mu sync.Mutex
+	q  [][]byte
+}
+
+// Append implements Queue.
+func (q *MemQueue) Append(value []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.q = append(q.q, append([]byte(nil), value...))
+	return nil
+}
+
+// Remove implements Queue.
+func (q *MemQueue) Remove(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n > len(q.q) {
+		n = len(q.q)
+	}
+	q.q = q.q[n:]
+	return nil
+}
+
+// Load implements Queue.
+func (q *MemQueue) Load() ([][]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([][]byte(nil), q.q...), nil
+}
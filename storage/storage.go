@@ -0,0 +1,68 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package storage contains small persistence interfaces used throughout the
+// library.
+//
+// Packages that need to persist state (such as avatar caches, pending roster
+// subscription requests, trust decisions, or queued stanzas) define
+// interfaces that fit their own needs, but also provide adapters that
+// implement those interfaces on top of the primitives in this package.
+// This means that applications only have to implement KV, Blob, and Queue
+// once (or use one of the memory or file backed implementations in this
+// package) instead of implementing a different interface for every feature.
+package storage // import "mellium.im/xmpp/storage"
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when a key or blob does not exist.
+var ErrNotFound = errors.New("storage: not found")
+
+// KV is a key value store for small values.
+type KV interface {
+	// Get returns the value stored for key or ErrNotFound if there is none.
+	Get(key string) ([]byte, error)
+
+	// Put stores value for key, replacing any existing value.
+	Put(key string, value []byte) error
+
+	// Delete removes key.
+	// Deleting a key that does not exist is not an error.
+	Delete(key string) error
+
+	// Keys returns all keys that start with prefix in sorted order.
+	Keys(prefix string) ([]string, error)
+}
+
+// Blob is a store for values that are too large to keep in memory all at once.
+type Blob interface {
+	// Open returns a reader for the named blob or ErrNotFound if it does not
+	// exist.
+	Open(name string) (io.ReadCloser, error)
+
+	// Create returns a writer that replaces the contents of the named blob.
+	// The blob may not be visible to Open until the writer is closed.
+	Create(name string) (io.WriteCloser, error)
+
+	// Remove removes the named blob.
+	// Removing a blob that does not exist is not an error.
+	Remove(name string) error
+}
+
+// Queue is a persistent ordered list of values.
+type Queue interface {
+	// Append adds a value to the end of the queue.
+	// It must not return until the value has been persisted.
+	Append(value []byte) error
+
+	// Remove removes the first n values from the queue.
+	Remove(n int) error
+
+	// Load returns all values in the queue in the order in which they were
+	// appended.
+	Load() ([][]byte, error)
+}
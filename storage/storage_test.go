@@ -0,0 +1,290 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mellium.im/xmpp/storage"
+)
+
+var (
+	_ storage.KV    = (*storage.Mem)(nil)
+	_ storage.Blob  = (*storage.Mem)(nil)
+	_ storage.Queue = (*storage.MemQueue)(nil)
+	_ storage.KV    = storage.Dir("")
+	_ storage.Blob  = storage.Dir("")
+	_ storage.Queue = (*storage.FileQueue)(nil)
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	return dir
+}
+
+func testKV(t *testing.T, kv storage.KV) {
+	_, err := kv.Get("missing")
+	if err != storage.ErrNotFound {
+		t.Errorf("wrong error for missing key: want=%v, got=%v", storage.ErrNotFound, err)
+	}
+	for _, k := range []string{"b/2", "a/1", "b/1", "../escape"} {
+		err = kv.Put(k, []byte(k))
+		if err != nil {
+			t.Fatalf("error putting %q: %v", k, err)
+		}
+	}
+	err = kv.Put("a/1", []byte("replaced"))
+	if err != nil {
+		t.Fatalf("error replacing value: %v", err)
+	}
+	v, err := kv.Get("a/1")
+	if err != nil || string(v) != "replaced" {
+		t.Errorf("wrong value: want=replaced, got=%q (%v)", v, err)
+	}
+	keys, err := kv.Keys("b/")
+	if err != nil {
+		t.Fatalf("error listing keys: %v", err)
+	}
+	if s := fmt.Sprint(keys); s != "[b/1 b/2]" {
+		t.Errorf("wrong keys: want=[b/1 b/2], got=%s", s)
+	}
+	err = kv.Delete("b/1")
+	if err != nil {
+		t.Fatalf("error deleting key: %v", err)
+	}
+	err = kv.Delete("b/1")
+	if err != nil {
+		t.Errorf("error deleting missing key: %v", err)
+	}
+	keys, err = kv.Keys("")
+	if err != nil {
+		t.Fatalf("error listing keys: %v", err)
+	}
+	if s := fmt.Sprint(keys); s != "[../escape a/1 b/2]" {
+		t.Errorf("wrong keys after delete: want=[../escape a/1 b/2], got=%s", s)
+	}
+}
+
+func testBlob(t *testing.T, b storage.Blob) {
+	_, err := b.Open("missing")
+	if err != storage.ErrNotFound {
+		t.Errorf("wrong error for missing blob: want=%v, got=%v", storage.ErrNotFound, err)
+	}
+	w, err := b.Create("blob")
+	if err != nil {
+		t.Fatalf("error creating blob: %v", err)
+	}
+	_, err = fmt.Fprint(w, "data")
+	if err != nil {
+		t.Fatalf("error writing blob: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("error closing blob: %v", err)
+	}
+	r, err := b.Open("blob")
+	if err != nil {
+		t.Fatalf("error opening blob: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading blob: %v", err)
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatalf("error closing blob reader: %v", err)
+	}
+	if string(data) != "data" {
+		t.Errorf("wrong blob data: want=data, got=%q", data)
+	}
+	err = b.Remove("blob")
+	if err != nil {
+		t.Fatalf("error removing blob: %v", err)
+	}
+	_, err = b.Open("blob")
+	if err != storage.ErrNotFound {
+		t.Errorf("expected removed blob to be missing, got %v", err)
+	}
+}
+
+func testQueue(t *testing.T, q storage.Queue) {
+	for _, s := range []string{"<a/>", "<b/>", "<c/>"} {
+		err := q.Append([]byte(s))
+		if err != nil {
+			t.Fatalf("error appending %s: %v", s, err)
+		}
+	}
+	err := q.Remove(1)
+	if err != nil {
+		t.Fatalf("error removing value: %v", err)
+	}
+	values, err := q.Load()
+	if err != nil {
+		t.Fatalf("error loading queue: %v", err)
+	}
+	if s := fmt.Sprintf("%s", values); s != "[<b/> <c/>]" {
+		t.Errorf("wrong values: want=[<b/> <c/>], got=%s", s)
+	}
+	err = q.Remove(5)
+	if err != nil {
+		t.Fatalf("error removing all values: %v", err)
+	}
+	values, err = q.Load()
+	if err != nil || len(values) != 0 {
+		t.Errorf("expected queue to be empty, got %s (%v)", values, err)
+	}
+}
+
+func TestMem(t *testing.T) {
+	testKV(t, &storage.Mem{})
+	testBlob(t, &storage.Mem{})
+	testQueue(t, &storage.MemQueue{})
+}
+
+func TestDir(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	testKV(t, storage.Dir(filepath.Join(dir, "kv")))
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Errorf("expected key not to be written outside of the directory, got err=%v", err)
+	}
+	testBlob(t, storage.Dir(filepath.Join(dir, "blob")))
+
+	keys, err := storage.Dir(filepath.Join(dir, "missing")).Keys("")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected missing directory to have no keys, got %v (%v)", keys, err)
+	}
+}
+
+func TestDirNames(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	kv := storage.Dir(dir)
+	long := strings.Repeat("long/", 100)
+	for _, k := range []string{"a", "A", long, long + "2"} {
+		err := kv.Put(k, []byte(k))
+		if err != nil {
+			t.Fatalf("error putting %q: %v", k, err)
+		}
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading dir: %v", err)
+	}
+	names := make(map[string]struct{})
+	for _, info := range infos {
+		name := info.Name()
+		if len(name) > 255 {
+			t.Errorf("file name is too long: %d bytes", len(name))
+		}
+		if _, ok := names[strings.ToLower(name)]; ok {
+			t.Errorf("file names only differ in case: %s", name)
+		}
+		names[strings.ToLower(name)] = struct{}{}
+	}
+	for _, k := range []string{"a", "A", long, long + "2"} {
+		v, err := kv.Get(k)
+		if err != nil || string(v) != k {
+			t.Errorf("wrong value for %.10q: want=%.10q, got=%.10q (%v)", k, k, v, err)
+		}
+	}
+	keys, err := kv.Keys("long/")
+	if err != nil {
+		t.Fatalf("error listing keys: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != long || keys[1] != long+"2" {
+		t.Errorf("wrong long keys: %q", keys)
+	}
+	err = kv.Delete(long)
+	if err != nil {
+		t.Fatalf("error deleting long key: %v", err)
+	}
+	_, err = kv.Get(long)
+	if err != storage.ErrNotFound {
+		t.Errorf("expected deleted long key to be missing, got %v", err)
+	}
+}
+
+func TestFileQueue(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "queue")
+
+	q, err := storage.OpenQueue(name)
+	if err != nil {
+		t.Fatalf("error opening queue: %v", err)
+	}
+	testQueue(t, q)
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("error checking queue size: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("expected empty queue to be truncated, got size %d", info.Size())
+	}
+	for _, s := range []string{"<a/>", "<b/>", "<c/>"} {
+		err = q.Append([]byte(s))
+		if err != nil {
+			t.Fatalf("error appending %s: %v", s, err)
+		}
+	}
+	err = q.Remove(1)
+	if err != nil {
+		t.Fatalf("error removing value: %v", err)
+	}
+	err = q.Close()
+	if err != nil {
+		t.Fatalf("error closing queue: %v", err)
+	}
+
+	// Simulate a crash while writing a record.
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("error opening queue file: %v", err)
+	}
+	_, err = f.Write([]byte{'a', 0, 0, 0, 10, '<'})
+	if err != nil {
+		t.Fatalf("error writing partial record: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("error closing queue file: %v", err)
+	}
+
+	q, err = storage.OpenQueue(name)
+	if err != nil {
+		t.Fatalf("error reopening queue: %v", err)
+	}
+	defer q.Close()
+	values, err := q.Load()
+	if err != nil {
+		t.Fatalf("error loading queue: %v", err)
+	}
+	if s := fmt.Sprintf("%s", values); s != "[<b/> <c/>]" {
+		t.Fatalf("wrong values after reopening: want=[<b/> <c/>], got=%s", s)
+	}
+	err = q.Append([]byte("<d/>"))
+	if err != nil {
+		t.Fatalf("error appending after reopening: %v", err)
+	}
+	values, err = q.Load()
+	if err != nil {
+		t.Fatalf("error loading queue: %v", err)
+	}
+	if s := fmt.Sprintf("%s", values); s != "[<b/> <c/> <d/>]" {
+		t.Errorf("wrong values after append: want=[<b/> <c/> <d/>], got=%s", s)
+	}
+}
@@ -202,7 +202,12 @@ func (s Error) Is(err error) bool {
 //     </stream:error>
 //
 // Error() would return "restricted-xml".
+// If the error contains human readable text, the first text is appended after
+// the condition, for example "policy-violation: too many connections".
 func (s Error) Error() string {
+	if len(s.Text) > 0 && s.Text[0].Value != "" {
+		return s.Err + ": " + s.Text[0].Value
+	}
 	return s.Err
 }
 
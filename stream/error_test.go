@@ -166,3 +166,14 @@ func TestErrorReturnsCondition(t *testing.T) {
 		t.Error("error should return the error condition")
 	}
 }
+
+func TestErrorIncludesText(t *testing.T) {
+	e := stream.Error{Err: "policy-violation", Text: []struct {
+		Lang  string
+		Value string
+	}{{Value: "too many connections"}}}
+	const want = "policy-violation: too many connections"
+	if s := e.Error(); s != want {
+		t.Errorf("wrong error string: want=%q, got=%q", want, s)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package styling
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"mellium.im/xmlstream"
+)
+
+// MeCommand is the prefix that marks the body of a message as an action, as
+// described in XEP-0245: The /me Command.
+const MeCommand = "/me "
+
+// Action reports whether body is an action (it starts with the /me command)
+// and returns the text of the action with the command removed.
+// If body is not an action it is returned unchanged.
+//
+// The command is only recognized at the very start of the body, so any
+// fallback text (such as the quote in a reply) should be removed first.
+// The returned text may itself contain styling and can be passed to a Decoder.
+func Action(body string) (text string, ok bool) {
+	if !strings.HasPrefix(body, MeCommand) {
+		return body, false
+	}
+	return body[len(MeCommand):], true
+}
+
+// Body is the body of a message, split into the text and whether it was an
+// action.
+// It can be used as a field in a message struct to have actions detected
+// automatically, or marshaled to build a message body.
+type Body struct {
+	XMLName xml.Name `xml:"body"`
+
+	// Text is the text of the body, without the /me command if Action is true.
+	Text string
+
+	// Action is true if the body started with the /me command.
+	Action bool
+}
+
+// String returns the body as it would be sent, including the /me command if
+// the body is an action.
+func (b Body) String() string {
+	if b.Action {
+		return MeCommand + b.Text
+	}
+	return b.Text
+}
+
+// Display returns the text that should be shown for the body if it was sent by
+// nick.
+// Actions are shown as "* nick text" and other bodies are returned unchanged.
+func (b Body) Display(nick string) string {
+	if !b.Action {
+		return b.Text
+	}
+	return "* " + nick + " " + b.Text
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (b Body) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(b.String())),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (b Body) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, b.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (b Body) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := b.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (b *Body) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	b.XMLName = start.Name
+	b.Text, b.Action = Action(s)
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package styling_test
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/styling"
+)
+
+var (
+	_ xml.Marshaler       = styling.Body{}
+	_ xmlstream.Marshaler = styling.Body{}
+	_ xmlstream.WriterTo  = styling.Body{}
+	_ xml.Unmarshaler     = (*styling.Body)(nil)
+)
+
+var actionTestCases = [...]struct {
+	body    string
+	text    string
+	action  bool
+	display string
+}{
+	0: {},
+	1: {
+		body:    "/me shrugs in disgust",
+		text:    "shrugs in disgust",
+		action:  true,
+		display: "* Juliet shrugs in disgust",
+	},
+	2: {
+		body:    "/me *waves*\nsecond line",
+		text:    "*waves*\nsecond line",
+		action:  true,
+		display: "* Juliet *waves*\nsecond line",
+	},
+	3: {
+		body:    "/me",
+		text:    "/me",
+		display: "/me",
+	},
+	4: {
+		body:    " /me waves",
+		text:    " /me waves",
+		display: " /me waves",
+	},
+	5: {
+		body:    "/ME waves",
+		text:    "/ME waves",
+		display: "/ME waves",
+	},
+	6: {
+		body:    "/meet me at the balcony",
+		text:    "/meet me at the balcony",
+		display: "/meet me at the balcony",
+	},
+	7: {
+		body:    "> /me waves\nhi",
+		text:    "> /me waves\nhi",
+		display: "> /me waves\nhi",
+	},
+}
+
+func TestAction(t *testing.T) {
+	for i, tc := range actionTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			text, ok := styling.Action(tc.body)
+			if text != tc.text {
+				t.Errorf("wrong text: want=%q, got=%q", tc.text, text)
+			}
+			if ok != tc.action {
+				t.Errorf("wrong action: want=%t, got=%t", tc.action, ok)
+			}
+
+			var msg struct {
+				stanza.Message
+				Body styling.Body `xml:"body"`
+			}
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, stanza.Message{}.Wrap(styling.Body{Text: tc.text, Action: tc.action}.TokenReader()))
+			if err != nil {
+				t.Fatalf("error encoding: %v", err)
+			}
+			if err = e.Flush(); err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			err = xml.NewDecoder(strings.NewReader(buf.String())).Decode(&msg)
+			if err != nil {
+				t.Fatalf("error decoding %s: %v", buf.String(), err)
+			}
+			if msg.Body.String() != tc.body {
+				t.Errorf("wrong round tripped body: want=%q, got=%q", tc.body, msg.Body.String())
+			}
+			if msg.Body.Text != tc.text || msg.Body.Action != tc.action {
+				t.Errorf("wrong decoded body: want=%q/%t, got=%q/%t", tc.text, tc.action, msg.Body.Text, msg.Body.Action)
+			}
+			if d := msg.Body.Display("Juliet"); d != tc.display {
+				t.Errorf("wrong display text: want=%q, got=%q", tc.display, d)
+			}
+		})
+	}
+}
+
+func TestActionStyling(t *testing.T) {
+	text, ok := styling.Action("/me *waves*")
+	if !ok {
+		t.Fatalf("expected action")
+	}
+	d := styling.NewDecoder(strings.NewReader(text))
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatalf("error decoding styling: %v", err)
+	}
+	if tok.Mask&styling.SpanStrongStart == 0 {
+		t.Errorf("expected action text to start with strong span, got %v", tok.Mask)
+	}
+}
@@ -36,6 +36,13 @@
 //     ```an optional tag may go here.
 //     Lines of pre-formatted text go here.
 //     ```
+//
+// Actions
+//
+// Messages with a body that starts with the "/me" command described in
+// XEP-0245 are actions that should be displayed after the name of the sender.
+// The Action function and Body type detect the command and remove it so that
+// the remaining text can be styled normally.
 package styling // import "mellium.im/xmpp/styling"
 
 import (
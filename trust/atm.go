@@ -0,0 +1,270 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=Level
+
+package trust
+
+import (
+	"mellium.im/xmpp/jid"
+)
+
+// Level is the trust level of a key.
+type Level uint8
+
+// A list of trust levels.
+const (
+	// Undecided keys have not been trusted or distrusted yet.
+	Undecided Level = iota
+
+	// Distrusted keys must not be used.
+	Distrusted
+
+	// BlindTrusted keys are trusted without having been authenticated.
+	// See NewKey for more information.
+	BlindTrusted
+
+	// Authenticated keys have been verified by the user, either directly or by
+	// receiving a trust message from another authenticated key.
+	Authenticated
+)
+
+// Key identifies an end-to-end encryption key and the entity that owns it.
+type Key struct {
+	Owner jid.JID
+	ID    []byte
+}
+
+// Store stores the trust levels of keys and any trust messages that cannot be
+// processed yet.
+// It is normally implemented by the same type that stores the device list or
+// sessions of an encryption protocol such as OMEMO.
+//
+// The owners of keys are always bare JIDs.
+type Store interface {
+	// Level returns the trust level of a key.
+	// Unknown keys should be reported as Undecided.
+	Level(key Key) (Level, error)
+
+	// SetLevel changes the trust level of a key.
+	SetLevel(key Key, level Level) error
+
+	// Owners returns the bare JIDs of all entities with known keys, including
+	// the user's own JID.
+	Owners() ([]jid.JID, error)
+
+	// Keys returns all known keys belonging to owner.
+	Keys(owner jid.JID) ([]Key, error)
+
+	// Cache stores a trust message that was sent using a key that has not been
+	// authenticated so that it can be processed if the key is authenticated
+	// later.
+	Cache(sender Key, m Message) error
+
+	// Cached returns and removes all messages cached for sender.
+	Cached(sender Key) ([]Message, error)
+}
+
+// Envelope is a trust message and the entity it should be sent to.
+type Envelope struct {
+	To      jid.JID
+	Message Message
+}
+
+// NewKey returns the trust level that should be given to a newly discovered key
+// using "Blind Trust Before Verification".
+// If none of the owner's keys have been authenticated, the new key is blindly
+// trusted, otherwise it is left undecided until the user authenticates it.
+// The level is not stored.
+func NewKey(store Store, key Key) (Level, error) {
+	keys, err := store.Keys(key.Owner.Bare())
+	if err != nil {
+		return Undecided, err
+	}
+	for _, k := range keys {
+		level, err := store.Level(k)
+		if err != nil {
+			return Undecided, err
+		}
+		if level == Authenticated {
+			return Undecided, nil
+		}
+	}
+	return BlindTrusted, nil
+}
+
+// Process applies the trust decisions in a trust message received from the
+// owner of sender, the key that was used to encrypt the message.
+// self is the user's own JID.
+//
+// Messages that do not have the ATM usage are ignored.
+// If sender has not been authenticated, the message is cached and processed
+// once it is.
+// Trust messages from the user's own devices may contain decisions about any
+// key, but trust messages from contacts are only used to authenticate or
+// distrust the contact's own keys.
+func Process(store Store, self jid.JID, sender Key, m Message) error {
+	if m.Usage != NSATM {
+		return nil
+	}
+	sender.Owner = sender.Owner.Bare()
+	level, err := store.Level(sender)
+	if err != nil {
+		return err
+	}
+	if level != Authenticated {
+		return store.Cache(sender, m)
+	}
+
+	fromSelf := sender.Owner.Equal(self.Bare())
+	for _, owner := range m.KeyOwners {
+		ownerJID := owner.JID.Bare()
+		if !fromSelf && !ownerJID.Equal(sender.Owner) {
+			continue
+		}
+		for _, id := range owner.Distrust {
+			err = store.SetLevel(Key{Owner: ownerJID, ID: id}, Distrusted)
+			if err != nil {
+				return err
+			}
+		}
+		for _, id := range owner.Trust {
+			err = authenticate(store, self, Key{Owner: ownerJID, ID: id})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// authenticate marks a key as authenticated and processes any messages that
+// were cached while waiting for it.
+func authenticate(store Store, self jid.JID, key Key) error {
+	level, err := store.Level(key)
+	if err != nil {
+		return err
+	}
+	if level == Authenticated {
+		return nil
+	}
+	err = store.SetLevel(key, Authenticated)
+	if err != nil {
+		return err
+	}
+	cached, err := store.Cached(key)
+	if err != nil {
+		return err
+	}
+	for _, m := range cached {
+		err = Process(store, self, key, m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Authenticate marks a key as authenticated after the user has verified it
+// (for example, by scanning a QR code) and returns the trust messages that must
+// be sent to keep the user's other devices and their contacts in sync.
+// self is the user's own JID and encryption is the namespace of the encryption
+// protocol that the key belongs to.
+//
+// If key belongs to a contact, the user's other devices are told to trust it
+// and the contact is told to trust the user's authenticated keys.
+// If key belongs to one of the user's own devices, the user's devices are told
+// about every key that has been authenticated or distrusted and every contact
+// with an authenticated key is told to trust the new device.
+func Authenticate(store Store, self jid.JID, key Key, encryption string) ([]Envelope, error) {
+	self = self.Bare()
+	key.Owner = key.Owner.Bare()
+	err := authenticate(store, self, key)
+	if err != nil {
+		return nil, err
+	}
+
+	newMessage := func(owners ...KeyOwner) Message {
+		return Message{
+			Usage:      NSATM,
+			Encryption: encryption,
+			KeyOwners:  owners,
+		}
+	}
+
+	ownKeys, err := decisions(store, self)
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.Owner.Equal(self) {
+		envelopes := []Envelope{{
+			To: self,
+			Message: newMessage(KeyOwner{
+				JID:   key.Owner,
+				Trust: [][]byte{key.ID},
+			}),
+		}}
+		if len(ownKeys.Trust) > 0 {
+			envelopes = append(envelopes, Envelope{
+				To:      key.Owner,
+				Message: newMessage(KeyOwner{JID: self, Trust: ownKeys.Trust}),
+			})
+		}
+		return envelopes, nil
+	}
+
+	owners, err := store.Owners()
+	if err != nil {
+		return nil, err
+	}
+	toSelf := []KeyOwner{ownKeys}
+	var envelopes []Envelope
+	for _, owner := range owners {
+		owner = owner.Bare()
+		if owner.Equal(self) {
+			continue
+		}
+		keyOwner, err := decisions(store, owner)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyOwner.Trust) == 0 && len(keyOwner.Distrust) == 0 {
+			continue
+		}
+		toSelf = append(toSelf, keyOwner)
+		if len(keyOwner.Trust) > 0 {
+			envelopes = append(envelopes, Envelope{
+				To: owner,
+				Message: newMessage(KeyOwner{
+					JID:   self,
+					Trust: [][]byte{key.ID},
+				}),
+			})
+		}
+	}
+	return append([]Envelope{{To: self, Message: newMessage(toSelf...)}}, envelopes...), nil
+}
+
+// decisions returns the authenticated and distrusted keys of owner.
+func decisions(store Store, owner jid.JID) (KeyOwner, error) {
+	keyOwner := KeyOwner{JID: owner}
+	keys, err := store.Keys(owner)
+	if err != nil {
+		return keyOwner, err
+	}
+	for _, k := range keys {
+		level, err := store.Level(k)
+		if err != nil {
+			return keyOwner, err
+		}
+		switch level {
+		case Authenticated:
+			keyOwner.Trust = append(keyOwner.Trust, k.ID)
+		case Distrusted:
+			keyOwner.Distrust = append(keyOwner.Distrust, k.ID)
+		}
+	}
+	return keyOwner, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package trust
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/storage"
+)
+
+// Prefixes of the keys used by KVStore so that the same KV can be shared with
+// other packages.
+const (
+	kvLevelPrefix = "trust/level/"
+	kvCachePrefix = "trust/cache/"
+)
+
+// KVStore returns a Store that stores trust levels and cached trust messages
+// in kv under keys starting with "trust/".
+func KVStore(kv storage.KV) Store {
+	return kvStore{kv: kv}
+}
+
+type kvStore struct {
+	kv storage.KV
+}
+
+// kvKey returns the storage key for a key.
+// Owners are bare JIDs which cannot contain a "/" and IDs are encoded, so the
+// key can be split on the last "/".
+func kvKey(prefix string, key Key) string {
+	return prefix + key.Owner.Bare().String() + "/" + base64.RawURLEncoding.EncodeToString(key.ID)
+}
+
+func (s kvStore) Level(key Key) (Level, error) {
+	v, err := s.kv.Get(kvKey(kvLevelPrefix, key))
+	switch {
+	case err == storage.ErrNotFound:
+		return Undecided, nil
+	case err != nil:
+		return Undecided, err
+	case len(v) != 1:
+		return Undecided, fmt.Errorf("trust: invalid stored level for key owned by %v", key.Owner)
+	}
+	return Level(v[0]), nil
+}
+
+func (s kvStore) SetLevel(key Key, level Level) error {
+	return s.kv.Put(kvKey(kvLevelPrefix, key), []byte{byte(level)})
+}
+
+func (s kvStore) Owners() ([]jid.JID, error) {
+	keys, err := s.kv.Keys(kvLevelPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var owners []jid.JID
+	seen := make(map[string]struct{})
+	for _, k := range keys {
+		k = strings.TrimPrefix(k, kvLevelPrefix)
+		idx := strings.LastIndexByte(k, '/')
+		if idx == -1 {
+			continue
+		}
+		owner := k[:idx]
+		if _, ok := seen[owner]; ok {
+			continue
+		}
+		seen[owner] = struct{}{}
+		j, err := jid.Parse(owner)
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, j)
+	}
+	return owners, nil
+}
+
+func (s kvStore) Keys(owner jid.JID) ([]Key, error) {
+	prefix := kvLevelPrefix + owner.Bare().String() + "/"
+	keys, err := s.kv.Keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Key, 0, len(keys))
+	for _, k := range keys {
+		id, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(k, prefix))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Key{Owner: owner.Bare(), ID: id})
+	}
+	return result, nil
+}
+
+func (s kvStore) Cache(sender Key, m Message) error {
+	key := kvKey(kvCachePrefix, sender)
+	v, err := s.kv.Get(key)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	b, err := xml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(key, append(v, b...))
+}
+
+func (s kvStore) Cached(sender Key) ([]Message, error) {
+	key := kvKey(kvCachePrefix, sender)
+	v, err := s.kv.Get(key)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msgs []Message
+	d := xml.NewDecoder(bytes.NewReader(v))
+	for {
+		var m Message
+		err = d.Decode(&m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, s.kv.Delete(key)
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package trust implements XEP-0434: Trust Messages and XEP-0450: Automatic
+// Trust Management (ATM).
+//
+// Trust messages are used to share decisions about which end-to-end encryption
+// keys are trusted between a user's own devices and with their contacts.
+// This package does not implement any encryption protocol, instead the
+// encryption package is expected to store its keys in something that
+// implements the Store interface and to pass decrypted trust messages to
+// Process along with the key that was used to encrypt them.
+// Trust messages must only ever be sent and received over an end-to-end
+// encrypted channel.
+package trust // import "mellium.im/xmpp/trust"
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS    = `urn:xmpp:tm:1`
+	NSATM = `urn:xmpp:atm:1`
+)
+
+// Namespaces of encryption protocols that may be used as the value of
+// Message.Encryption.
+const (
+	EncryptionOMEMO = `urn:xmpp:omemo:2`
+	EncryptionOX    = `urn:xmpp:openpgp:0`
+)
+
+// Message is a trust message containing trust decisions about the keys of one
+// or more key owners.
+type Message struct {
+	XMLName    xml.Name   `xml:"urn:xmpp:tm:1 trust-message"`
+	Usage      string     `xml:"usage,attr"`
+	Encryption string     `xml:"encryption,attr"`
+	KeyOwners  []KeyOwner `xml:"key-owner"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (m Message) TokenReader() xml.TokenReader {
+	var payloads []xml.TokenReader
+	for _, owner := range m.KeyOwners {
+		payloads = append(payloads, owner.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(payloads...),
+		xml.StartElement{
+			Name: xml.Name{Space: NS, Local: "trust-message"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "usage"}, Value: m.Usage},
+				{Name: xml.Name{Local: "encryption"}, Value: m.Encryption},
+			},
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (m Message) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, m.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (m Message) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := m.WriteXML(e)
+	return err
+}
+
+// KeyOwner contains the identifiers of keys belonging to a single entity that
+// should be trusted or distrusted.
+type KeyOwner struct {
+	XMLName  xml.Name `xml:"key-owner"`
+	JID      jid.JID  `xml:"jid,attr"`
+	Trust    [][]byte `xml:"-"`
+	Distrust [][]byte `xml:"-"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (o KeyOwner) TokenReader() xml.TokenReader {
+	var payloads []xml.TokenReader
+	for _, id := range o.Trust {
+		payloads = append(payloads, keyElem("trust", id))
+	}
+	for _, id := range o.Distrust {
+		payloads = append(payloads, keyElem("distrust", id))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(payloads...),
+		xml.StartElement{
+			Name: xml.Name{Local: "key-owner"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: o.JID.String()}},
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (o KeyOwner) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, o.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (o KeyOwner) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := o.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (o *KeyOwner) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		XMLName  xml.Name `xml:"key-owner"`
+		JID      jid.JID  `xml:"jid,attr"`
+		Trust    []string `xml:"trust"`
+		Distrust []string `xml:"distrust"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	owner := KeyOwner{
+		XMLName: s.XMLName,
+		JID:     s.JID,
+	}
+	for _, id := range s.Trust {
+		b, err := base64.StdEncoding.DecodeString(id)
+		if err != nil {
+			return err
+		}
+		owner.Trust = append(owner.Trust, b)
+	}
+	for _, id := range s.Distrust {
+		b, err := base64.StdEncoding.DecodeString(id)
+		if err != nil {
+			return err
+		}
+		owner.Distrust = append(owner.Distrust, b)
+	}
+	*o = owner
+	return nil
+}
+
+func keyElem(local string, id []byte) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(id))),
+		xml.StartElement{Name: xml.Name{Local: local}},
+	)
+}
@@ -0,0 +1,329 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package trust_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/storage"
+	"mellium.im/xmpp/trust"
+)
+
+var (
+	_ xml.Marshaler       = trust.Message{}
+	_ xmlstream.Marshaler = trust.Message{}
+	_ xmlstream.WriterTo  = trust.Message{}
+	_ xml.Marshaler       = trust.KeyOwner{}
+	_ xml.Unmarshaler     = (*trust.KeyOwner)(nil)
+	_ xmlstream.Marshaler = trust.KeyOwner{}
+	_ xmlstream.WriterTo  = trust.KeyOwner{}
+)
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, []xmpptest.EncodingTestCase{
+		0: {
+			Value: &trust.Message{
+				XMLName:    xml.Name{Space: trust.NS, Local: "trust-message"},
+				Usage:      trust.NSATM,
+				Encryption: trust.EncryptionOMEMO,
+				KeyOwners: []trust.KeyOwner{{
+					XMLName:  xml.Name{Space: trust.NS, Local: "key-owner"},
+					JID:      jid.MustParse("juliet@example.net"),
+					Trust:    [][]byte{[]byte("one"), []byte("two")},
+					Distrust: [][]byte{[]byte("three")},
+				}},
+			},
+			XML: `<trust-message xmlns="urn:xmpp:tm:1" usage="urn:xmpp:atm:1" encryption="urn:xmpp:omemo:2"><key-owner jid="juliet@example.net"><trust>b25l</trust><trust>dHdv</trust><distrust>dGhyZWU=</distrust></key-owner></trust-message>`,
+		},
+	})
+}
+
+type memStore struct {
+	levels map[string]trust.Level
+	keys   map[string][]trust.Key
+	cache  map[string][]trust.Message
+}
+
+func newStore() *memStore {
+	return &memStore{
+		levels: make(map[string]trust.Level),
+		keys:   make(map[string][]trust.Key),
+		cache:  make(map[string][]trust.Message),
+	}
+}
+
+func id(k trust.Key) string {
+	return k.Owner.String() + "/" + string(k.ID)
+}
+
+func (s *memStore) add(k trust.Key, l trust.Level) {
+	s.keys[k.Owner.String()] = append(s.keys[k.Owner.String()], k)
+	s.levels[id(k)] = l
+}
+
+func (s *memStore) Level(k trust.Key) (trust.Level, error) {
+	return s.levels[id(k)], nil
+}
+
+func (s *memStore) SetLevel(k trust.Key, l trust.Level) error {
+	if _, ok := s.levels[id(k)]; !ok {
+		s.keys[k.Owner.String()] = append(s.keys[k.Owner.String()], k)
+	}
+	s.levels[id(k)] = l
+	return nil
+}
+
+func (s *memStore) Owners() ([]jid.JID, error) {
+	var owners []jid.JID
+	for owner := range s.keys {
+		owners = append(owners, jid.MustParse(owner))
+	}
+	return owners, nil
+}
+
+func (s *memStore) Keys(owner jid.JID) ([]trust.Key, error) {
+	return s.keys[owner.String()], nil
+}
+
+func (s *memStore) Cache(sender trust.Key, m trust.Message) error {
+	s.cache[id(sender)] = append(s.cache[id(sender)], m)
+	return nil
+}
+
+func (s *memStore) Cached(sender trust.Key) ([]trust.Message, error) {
+	m := s.cache[id(sender)]
+	delete(s.cache, id(sender))
+	return m, nil
+}
+
+var (
+	self    = jid.MustParse("romeo@example.net")
+	contact = jid.MustParse("juliet@example.com")
+	other   = jid.MustParse("mercutio@example.com")
+)
+
+func TestNewKey(t *testing.T) {
+	store := newStore()
+	level, err := trust.NewKey(store, trust.Key{Owner: contact, ID: []byte("a")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if level != trust.BlindTrusted {
+		t.Errorf("wrong level for first key: want=%v, got=%v", trust.BlindTrusted, level)
+	}
+
+	store.add(trust.Key{Owner: contact, ID: []byte("a")}, trust.Authenticated)
+	level, err = trust.NewKey(store, trust.Key{Owner: contact, ID: []byte("b")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if level != trust.Undecided {
+		t.Errorf("wrong level after authentication: want=%v, got=%v", trust.Undecided, level)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	store := newStore()
+	ownDevice := trust.Key{Owner: self, ID: []byte("own")}
+	contactKey := trust.Key{Owner: contact, ID: []byte("contact")}
+	store.add(ownDevice, trust.BlindTrusted)
+	store.add(contactKey, trust.Authenticated)
+
+	// Contacts may not make decisions about other entities keys.
+	err := trust.Process(store, self, contactKey, trust.Message{
+		Usage: trust.NSATM,
+		KeyOwners: []trust.KeyOwner{
+			{JID: other, Trust: [][]byte{[]byte("other")}},
+			{JID: contact, Trust: [][]byte{[]byte("new")}, Distrust: [][]byte{[]byte("old")}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error processing contact message: %v", err)
+	}
+	for k, want := range map[string]trust.Level{
+		id(trust.Key{Owner: other, ID: []byte("other")}):   trust.Undecided,
+		id(trust.Key{Owner: contact, ID: []byte("new")}):   trust.Authenticated,
+		id(trust.Key{Owner: contact, ID: []byte("old")}):   trust.Distrusted,
+		id(trust.Key{Owner: contact, ID: []byte("other")}): trust.Undecided,
+	} {
+		if got := store.levels[k]; got != want {
+			t.Errorf("wrong level for %s: want=%v, got=%v", k, want, got)
+		}
+	}
+
+	// Messages from unauthenticated keys are cached until the key is
+	// authenticated.
+	ownMsg := trust.Message{
+		Usage: trust.NSATM,
+		KeyOwners: []trust.KeyOwner{
+			{JID: other, Trust: [][]byte{[]byte("other")}},
+		},
+	}
+	err = trust.Process(store, self, ownDevice, ownMsg)
+	if err != nil {
+		t.Fatalf("unexpected error processing own message: %v", err)
+	}
+	otherKey := id(trust.Key{Owner: other, ID: []byte("other")})
+	if got := store.levels[otherKey]; got != trust.Undecided {
+		t.Errorf("key trusted by unauthenticated device: got=%v", got)
+	}
+	_, err = trust.Authenticate(store, self, ownDevice, trust.EncryptionOMEMO)
+	if err != nil {
+		t.Fatalf("unexpected error authenticating own device: %v", err)
+	}
+	if got := store.levels[otherKey]; got != trust.Authenticated {
+		t.Errorf("cached message not processed: want=%v, got=%v", trust.Authenticated, got)
+	}
+}
+
+func TestAuthenticateContact(t *testing.T) {
+	store := newStore()
+	store.add(trust.Key{Owner: self, ID: []byte("own")}, trust.Authenticated)
+	contactKey := trust.Key{Owner: contact, ID: []byte("contact")}
+	store.add(contactKey, trust.BlindTrusted)
+
+	envelopes, err := trust.Authenticate(store, self, contactKey, trust.EncryptionOMEMO)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []trust.Envelope{{
+		To: self,
+		Message: trust.Message{
+			Usage:      trust.NSATM,
+			Encryption: trust.EncryptionOMEMO,
+			KeyOwners:  []trust.KeyOwner{{JID: contact, Trust: [][]byte{[]byte("contact")}}},
+		},
+	}, {
+		To: contact,
+		Message: trust.Message{
+			Usage:      trust.NSATM,
+			Encryption: trust.EncryptionOMEMO,
+			KeyOwners:  []trust.KeyOwner{{JID: self, Trust: [][]byte{[]byte("own")}}},
+		},
+	}}
+	if !reflect.DeepEqual(envelopes, want) {
+		t.Errorf("wrong trust messages:\nwant=%+v,\n got=%+v", want, envelopes)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	const want = "01234567 89abcdef 0123"
+	fp := trust.Fingerprint([]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23})
+	if fp != want {
+		t.Errorf("wrong fingerprint: want=%q, got=%q", want, fp)
+	}
+}
+
+func TestURIRoundTrip(t *testing.T) {
+	devices := []trust.Device{
+		{ID: 1234, Key: []byte{0xab, 0xcd}},
+		{ID: 5678, Key: []byte{0xef, 0x01}},
+	}
+	u := trust.URI(contact, devices...)
+	const want = "xmpp:juliet@example.com?omemo2-sid-1234=abcd;omemo2-sid-5678=ef01"
+	if u != want {
+		t.Errorf("wrong URI: want=%q, got=%q", want, u)
+	}
+	owner, parsed, err := trust.ParseURI(u)
+	if err != nil {
+		t.Fatalf("error parsing URI: %v", err)
+	}
+	if !owner.Equal(contact) {
+		t.Errorf("wrong owner: want=%v, got=%v", contact, owner)
+	}
+	if !reflect.DeepEqual(parsed, devices) {
+		t.Errorf("wrong devices: want=%+v, got=%+v", devices, parsed)
+	}
+
+	store := newStore()
+	_, err = trust.Scan(store, self, u, trust.EncryptionOMEMO)
+	if err != nil {
+		t.Fatalf("error scanning URI: %v", err)
+	}
+	for _, d := range devices {
+		if l, _ := store.Level(trust.Key{Owner: contact, ID: d.Key}); l != trust.Authenticated {
+			t.Errorf("device %d not authenticated: got=%v", d.ID, l)
+		}
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	store := trust.KVStore(&storage.Mem{})
+	keyA := trust.Key{Owner: contact, ID: []byte("a/../b")}
+	keyB := trust.Key{Owner: contact, ID: []byte{0, 0xff}}
+	ownKey := trust.Key{Owner: self, ID: []byte("own")}
+
+	level, err := store.Level(keyA)
+	if err != nil || level != trust.Undecided {
+		t.Fatalf("wrong level for unknown key: want=%v, got=%v (%v)", trust.Undecided, level, err)
+	}
+	for _, k := range []trust.Key{keyA, keyB, ownKey} {
+		err = store.SetLevel(k, trust.Authenticated)
+		if err != nil {
+			t.Fatalf("error setting level: %v", err)
+		}
+	}
+	err = store.SetLevel(keyB, trust.Distrusted)
+	if err != nil {
+		t.Fatalf("error changing level: %v", err)
+	}
+	level, err = store.Level(keyB)
+	if err != nil || level != trust.Distrusted {
+		t.Errorf("wrong level: want=%v, got=%v (%v)", trust.Distrusted, level, err)
+	}
+
+	owners, err := store.Owners()
+	if err != nil {
+		t.Fatalf("error listing owners: %v", err)
+	}
+	if len(owners) != 2 || !owners[0].Equal(contact) || !owners[1].Equal(self) {
+		t.Errorf("wrong owners: want=[%v %v], got=%v", contact, self, owners)
+	}
+	keys, err := store.Keys(contact)
+	if err != nil {
+		t.Fatalf("error listing keys: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("wrong number of keys: want=2, got=%d", len(keys))
+	}
+	for _, k := range keys {
+		if !reflect.DeepEqual(k, keyA) && !reflect.DeepEqual(k, keyB) {
+			t.Errorf("unexpected key %+v", k)
+		}
+	}
+
+	msg := trust.Message{
+		XMLName:    xml.Name{Space: trust.NS, Local: "trust-message"},
+		Usage:      trust.NSATM,
+		Encryption: trust.EncryptionOMEMO,
+		KeyOwners: []trust.KeyOwner{{
+			XMLName: xml.Name{Space: trust.NS, Local: "key-owner"},
+			JID:     other,
+			Trust:   [][]byte{[]byte("one")},
+		}},
+	}
+	for i := 0; i < 2; i++ {
+		err = store.Cache(keyA, msg)
+		if err != nil {
+			t.Fatalf("error caching message: %v", err)
+		}
+	}
+	cached, err := store.Cached(keyA)
+	if err != nil {
+		t.Fatalf("error loading cached messages: %v", err)
+	}
+	if len(cached) != 2 || !reflect.DeepEqual(cached[0], msg) {
+		t.Errorf("wrong cached messages:\nwant=2×%+v,\n got=%+v", msg, cached)
+	}
+	cached, err = store.Cached(keyA)
+	if err != nil || len(cached) != 0 {
+		t.Errorf("expected cached messages to be removed, got %+v (%v)", cached, err)
+	}
+}
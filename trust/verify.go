@@ -0,0 +1,171 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package trust
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmpp/jid"
+)
+
+// omemoPrefix is the prefix of URI query parameters that contain the
+// fingerprint of an OMEMO device.
+// The rest of the parameter name is the device ID.
+const omemoPrefix = "omemo2-sid-"
+
+// ErrBadURI is returned by ParseURI if the URI is not a valid XMPP URI
+// containing key fingerprints.
+var ErrBadURI = errors.New("trust: invalid verification URI")
+
+// Device is an end-to-end encryption device and the identity key that is used
+// as its key identifier in trust messages.
+type Device struct {
+	ID  uint32
+	Key []byte
+}
+
+// Fingerprint formats a key identifier for display to the user so that they
+// can compare it with the fingerprint shown on another device.
+// The identifier is hex encoded and split into groups of eight characters.
+func Fingerprint(id []byte) string {
+	h := hex.EncodeToString(id)
+	var b strings.Builder
+	for i := 0; i < len(h); i += 8 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		end := i + 8
+		if end > len(h) {
+			end = len(h)
+		}
+		b.WriteString(h[i:end])
+	}
+	return b.String()
+}
+
+// URI returns an XMPP URI containing the fingerprints of the OMEMO devices
+// belonging to owner.
+// It is meant to be displayed as a QR code and scanned by another device which
+// passes it to Scan to authenticate the keys.
+//
+//     xmpp:juliet@example.net?omemo2-sid-1234=abcd…;omemo2-sid-5678=ef01…
+func URI(owner jid.JID, devices ...Device) string {
+	params := make([]string, 0, len(devices))
+	for _, d := range devices {
+		params = append(params, omemoPrefix+strconv.FormatUint(uint64(d.ID), 10)+"="+hex.EncodeToString(d.Key))
+	}
+	u := url.URL{
+		Scheme:   "xmpp",
+		Opaque:   owner.Bare().String(),
+		RawQuery: strings.Join(params, ";"),
+	}
+	return u.String()
+}
+
+// ParseURI parses a URI created by URI and returns the owner of the devices
+// and their keys.
+// Query parameters that do not contain an OMEMO fingerprint are ignored.
+func ParseURI(raw string) (jid.JID, []Device, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return jid.JID{}, nil, err
+	}
+	if u.Scheme != "xmpp" || u.Opaque == "" {
+		return jid.JID{}, nil, ErrBadURI
+	}
+	owner, err := jid.Parse(u.Opaque)
+	if err != nil {
+		return jid.JID{}, nil, err
+	}
+
+	var devices []Device
+	// The parameters are separated by semicolons which url.ParseQuery does not
+	// support, so split them manually.
+	for _, param := range strings.Split(u.RawQuery, ";") {
+		idx := strings.IndexByte(param, '=')
+		if idx == -1 || !strings.HasPrefix(param, omemoPrefix) {
+			continue
+		}
+		id, err := strconv.ParseUint(param[len(omemoPrefix):idx], 10, 32)
+		if err != nil {
+			return owner, nil, ErrBadURI
+		}
+		key, err := hex.DecodeString(param[idx+1:])
+		if err != nil {
+			return owner, nil, ErrBadURI
+		}
+		devices = append(devices, Device{ID: uint32(id), Key: key})
+	}
+	return owner.Bare(), devices, nil
+}
+
+// Scan authenticates all of the keys in a URI created by URI, for example after
+// the user scans a QR code displayed by another device.
+// The trust messages that must be sent as a result are returned.
+// See Authenticate for more information.
+func Scan(store Store, self jid.JID, raw, encryption string) ([]Envelope, error) {
+	owner, devices, err := ParseURI(raw)
+	if err != nil {
+		return nil, err
+	}
+	var envelopes []Envelope
+	for _, d := range devices {
+		e, err := Authenticate(store, self, Key{Owner: owner, ID: d.Key}, encryption)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, e...)
+	}
+	return envelopes, nil
+}
+
+// Distrust marks a key as distrusted and returns the trust messages that must
+// be sent as a result.
+// The user's own devices are always told to distrust the key.
+// If the key belongs to one of the user's own devices, every contact with an
+// authenticated key is told to distrust it as well.
+func Distrust(store Store, self jid.JID, key Key, encryption string) ([]Envelope, error) {
+	self = self.Bare()
+	key.Owner = key.Owner.Bare()
+	err := store.SetLevel(key, Distrusted)
+	if err != nil {
+		return nil, err
+	}
+	msg := Message{
+		Usage:      NSATM,
+		Encryption: encryption,
+		KeyOwners: []KeyOwner{{
+			JID:      key.Owner,
+			Distrust: [][]byte{key.ID},
+		}},
+	}
+	envelopes := []Envelope{{To: self, Message: msg}}
+	if !key.Owner.Equal(self) {
+		return envelopes, nil
+	}
+
+	owners, err := store.Owners()
+	if err != nil {
+		return nil, err
+	}
+	for _, owner := range owners {
+		owner = owner.Bare()
+		if owner.Equal(self) {
+			continue
+		}
+		keyOwner, err := decisions(store, owner)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyOwner.Trust) > 0 {
+			envelopes = append(envelopes, Envelope{To: owner, Message: msg})
+		}
+	}
+	return envelopes, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+//+build integration
+
+package upload_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"mellium.im/sasl"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/integration"
+	"mellium.im/xmpp/internal/integration/prosody"
+	"mellium.im/xmpp/upload"
+)
+
+func TestIntegrationUpload(t *testing.T) {
+	prosodyRun := prosody.Test(context.TODO(), t,
+		integration.Log(),
+		prosody.ListenC2S(),
+		prosody.HTTPUpload(),
+	)
+	prosodyRun(integrationUpload)
+}
+
+func integrationUpload(ctx context.Context, t *testing.T, cmd *integration.Cmd) {
+	const content = "Hello, world!"
+	j, pass := cmd.User()
+	session, err := cmd.DialClient(ctx, j, t,
+		xmpp.StartTLS(&tls.Config{
+			InsecureSkipVerify: true,
+		}),
+		xmpp.SASL("", pass, sasl.Plain),
+		xmpp.BindResource(),
+	)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	go func() {
+		err := session.Serve(nil)
+		if err != nil {
+			t.Logf("error from serve: %v", err)
+		}
+	}()
+	service, _ := prosody.UploadService(cmd)
+	getURL, err := upload.File(ctx, session, service, nil, upload.Request{
+		Filename:    "hello.txt",
+		Size:        int64(len(content)),
+		ContentType: "text/plain",
+	}, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("error uploading file: %v", err)
+	}
+	/* #nosec */
+	resp, err := http.Get(getURL)
+	if err != nil {
+		t.Fatalf("error downloading file: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if string(b) != content {
+		t.Errorf("wrong file contents: want=%q, got=%q", content, b)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package upload implements XEP-0363: HTTP File Upload.
+//
+// Uploading a file is a two step process: first a slot is requested from the
+// upload service over XMPP, then the file is uploaded to the URL contained in
+// the slot using an HTTP PUT request.
+// Once uploaded the file can be shared with others using the slots GET URL, for
+// example by including it in a message using the oob package.
+package upload // import "mellium.im/xmpp/upload"
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by HTTP File Upload, provided as a convenience.
+const NS = "urn:xmpp:http:upload:0"
+
+// allowedHeaders is the list of headers that a service may ask us to include
+// in the PUT request.
+// Any other headers must be ignored.
+var allowedHeaders = [...]string{"Authorization", "Cookie", "Expires"}
+
+// Request is a request for an upload slot.
+type Request struct {
+	XMLName     xml.Name `xml:"urn:xmpp:http:upload:0 request"`
+	Filename    string   `xml:"filename,attr"`
+	Size        int64    `xml:"size,attr"`
+	ContentType string   `xml:"content-type,attr,omitempty"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Request) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{
+		{Name: xml.Name{Local: "filename"}, Value: r.Filename},
+		{Name: xml.Name{Local: "size"}, Value: strconv.FormatInt(r.Size, 10)},
+	}
+	if r.ContentType != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "content-type"}, Value: r.ContentType})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "request"},
+		Attr: attrs,
+	})
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (r Request) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Request) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	return err
+}
+
+// Slot is a location that a file can be uploaded to and later retrieved from.
+type Slot struct {
+	// PutURL is the URL that the file should be uploaded to.
+	PutURL string
+
+	// GetURL is the URL that the file can be downloaded from once it has been
+	// uploaded.
+	GetURL string
+
+	// Header contains headers that must be included in the upload request.
+	// When unmarshaling a slot, headers that are not allowed by the
+	// specification are dropped.
+	Header http.Header
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (s Slot) TokenReader() xml.TokenReader {
+	var headers []xml.TokenReader
+	for _, name := range allowedHeaders {
+		for _, v := range s.Header.Values(name) {
+			headers = append(headers, xmlstream.Wrap(
+				xmlstream.Token(xml.CharData(v)),
+				xml.StartElement{
+					Name: xml.Name{Local: "header"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}},
+				},
+			))
+		}
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(
+			xmlstream.Wrap(
+				xmlstream.MultiReader(headers...),
+				xml.StartElement{
+					Name: xml.Name{Local: "put"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "url"}, Value: s.PutURL}},
+				},
+			),
+			xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "get"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "url"}, Value: s.GetURL}},
+			}),
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "slot"}},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo.
+func (s Slot) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s Slot) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (s *Slot) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	foo := struct {
+		XMLName xml.Name `xml:"urn:xmpp:http:upload:0 slot"`
+		Put     struct {
+			URL    string `xml:"url,attr"`
+			Header []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"header"`
+		} `xml:"put"`
+		Get struct {
+			URL string `xml:"url,attr"`
+		} `xml:"get"`
+	}{}
+	err := d.DecodeElement(&foo, &start)
+	if err != nil {
+		return err
+	}
+	s.PutURL = foo.Put.URL
+	s.GetURL = foo.Get.URL
+	s.Header = nil
+	for _, h := range foo.Put.Header {
+		name := http.CanonicalHeaderKey(h.Name)
+		var allowed bool
+		for _, a := range allowedHeaders {
+			if name == a {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			continue
+		}
+		if s.Header == nil {
+			s.Header = make(http.Header)
+		}
+		// Headers containing newlines must be stripped of them to prevent header
+		// injection.
+		s.Header.Add(name, strings.NewReplacer("\r", "", "\n", "").Replace(h.Value))
+	}
+	return nil
+}
+
+// GetSlot requests an upload slot from the provided upload service.
+// It blocks until a response is received.
+func GetSlot(ctx context.Context, s *xmpp.Session, service jid.JID, r Request) (Slot, error) {
+	return GetSlotIQ(ctx, stanza.IQ{To: service}, s, r)
+}
+
+// GetSlotIQ is like GetSlot but it allows you to customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func GetSlotIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session, r Request) (Slot, error) {
+	if iq.Type != stanza.GetIQ {
+		iq.Type = stanza.GetIQ
+	}
+	var slot Slot
+	err := s.UnmarshalIQ(ctx, iq.Wrap(r.TokenReader()), &slot)
+	return slot, err
+}
+
+// Put uploads the contents of body to the slot.
+// The size and content type of the file are taken from r and should be the
+// same as the ones used to request the slot.
+// If client is nil, http.DefaultClient is used.
+func (s Slot) Put(ctx context.Context, client *http.Client, r Request, body io.Reader) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodPut, s.PutURL, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.ContentLength = r.Size
+	if r.ContentType != "" {
+		req.Header.Set("Content-Type", r.ContentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	/* #nosec */
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upload: unexpected response to PUT: %s", resp.Status)
+	}
+	return nil
+}
+
+// File requests a slot from the provided upload service and uploads the
+// contents of body to it using client.
+// If the upload succeeds, the URL that can be used to download the file is
+// returned.
+// If client is nil, http.DefaultClient is used.
+func File(ctx context.Context, s *xmpp.Session, service jid.JID, client *http.Client, r Request, body io.Reader) (string, error) {
+	slot, err := GetSlot(ctx, s, service, r)
+	if err != nil {
+		return "", err
+	}
+	err = slot.Put(ctx, client, r, body)
+	if err != nil {
+		return "", err
+	}
+	return slot.GetURL, nil
+}
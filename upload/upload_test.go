@@ -0,0 +1,168 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package upload_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/upload"
+)
+
+var (
+	_ xmlstream.Marshaler = upload.Request{}
+	_ xmlstream.WriterTo  = upload.Request{}
+	_ xml.Marshaler       = upload.Request{}
+	_ xmlstream.Marshaler = upload.Slot{}
+	_ xmlstream.WriterTo  = upload.Slot{}
+	_ xml.Marshaler       = upload.Slot{}
+	_ xml.Unmarshaler     = (*upload.Slot)(nil)
+)
+
+var marshalTests = [...]struct {
+	in  interface{}
+	out string
+}{
+	0: {
+		in:  upload.Request{Filename: "très cool.jpg", Size: 23456, ContentType: "image/jpeg"},
+		out: `<request xmlns="urn:xmpp:http:upload:0" filename="très cool.jpg" size="23456" content-type="image/jpeg"></request>`,
+	},
+	1: {
+		in:  upload.Request{Filename: "a.txt"},
+		out: `<request xmlns="urn:xmpp:http:upload:0" filename="a.txt" size="0"></request>`,
+	},
+	2: {
+		in: upload.Slot{
+			PutURL: "https://upload.example.net/put",
+			GetURL: "https://download.example.net/get",
+			Header: http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}, "X-Foo": {"bar"}},
+		},
+		out: `<slot xmlns="urn:xmpp:http:upload:0"><put url="https://upload.example.net/put"><header name="Authorization">Basic Zm9vOmJhcg==</header></put><get url="https://download.example.net/get"></get></slot>`,
+	},
+}
+
+func TestMarshal(t *testing.T) {
+	for i, tc := range marshalTests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			b, err := xml.Marshal(tc.in)
+			if err != nil {
+				t.Fatalf("error marshaling: %v", err)
+			}
+			if string(b) != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, b)
+			}
+		})
+	}
+}
+
+func TestUnmarshalSlot(t *testing.T) {
+	const in = `<slot xmlns='urn:xmpp:http:upload:0'>
+  <put url='https://upload.montague.tld/4a771ac1-f0b2-4a4a-9700-f2a26fa2bb67/tr%C3%A8s%20cool.jpg'>
+    <header name='Authorization'>Basic Base64String==</header>
+    <header name='cookie'>foo=bar;
+user=romeo</header>
+    <header name='X-Evil'>nope</header>
+  </put>
+  <get url='https://download.montague.tld/4a771ac1-f0b2-4a4a-9700-f2a26fa2bb67/tr%C3%A8s%20cool.jpg' />
+</slot>`
+	var slot upload.Slot
+	err := xml.Unmarshal([]byte(in), &slot)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	want := upload.Slot{
+		PutURL: "https://upload.montague.tld/4a771ac1-f0b2-4a4a-9700-f2a26fa2bb67/tr%C3%A8s%20cool.jpg",
+		GetURL: "https://download.montague.tld/4a771ac1-f0b2-4a4a-9700-f2a26fa2bb67/tr%C3%A8s%20cool.jpg",
+		Header: http.Header{
+			"Authorization": {"Basic Base64String=="},
+			"Cookie":        {"foo=bar;user=romeo"},
+		},
+	}
+	if !reflect.DeepEqual(slot, want) {
+		t.Errorf("wrong slot:\nwant=%+v,\n got=%+v", want, slot)
+	}
+}
+
+func TestFile(t *testing.T) {
+	const content = "hello"
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("wrong method: want=%s, got=%s", http.MethodPut, r.Method)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("wrong authorization header: %q", auth)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("wrong content type: %q", ct)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading body: %v", err)
+		}
+		got = string(b)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			iq, err := stanza.NewIQ(*start)
+			if err != nil {
+				return err
+			}
+			var req upload.Request
+			err = xml.NewTokenDecoder(e).Decode(&req)
+			if err != nil {
+				return err
+			}
+			if req.Filename != "hello.txt" || req.Size != int64(len(content)) {
+				t.Errorf("unexpected request: %+v", req)
+			}
+			_, err = xmlstream.Copy(e, iq.Result(upload.Slot{
+				PutURL: srv.URL + "/put",
+				GetURL: srv.URL + "/get",
+				Header: http.Header{"Authorization": {"Bearer token"}},
+			}.TokenReader()))
+			return err
+		}),
+	)
+	getURL, err := upload.File(context.Background(), cs.Client, jid.MustParse("upload.example.net"), srv.Client(), upload.Request{
+		Filename:    "hello.txt",
+		Size:        int64(len(content)),
+		ContentType: "text/plain",
+	}, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("error uploading: %v", err)
+	}
+	if want := srv.URL + "/get"; getURL != want {
+		t.Errorf("wrong get URL: want=%s, got=%s", want, getURL)
+	}
+	if got != content {
+		t.Errorf("wrong content uploaded: want=%q, got=%q", content, got)
+	}
+}
+
+func TestPutStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+	defer srv.Close()
+	err := upload.Slot{PutURL: srv.URL}.Put(context.Background(), srv.Client(), upload.Request{Size: 1}, strings.NewReader("a"))
+	if err == nil {
+		t.Errorf("expected error for bad status")
+	}
+}
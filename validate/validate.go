@@ -0,0 +1,335 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package validate checks incoming elements against structural rules.
+//
+// Most packages in this module are lenient and will happily decode payloads
+// that are missing required attributes or that contain too many children.
+// This is usually what you want in production, but when developing against a
+// buggy server (or when writing a server) it is useful to know exactly what
+// was wrong with a payload instead of ending up with zero values.
+// Wrapping a handler in a validate.Handler enables a strict mode where every
+// incoming element is checked before it is passed on, and elements that fail
+// validation are reported and refused instead.
+//
+// Rules are keyed by the fully qualified name of the element they apply to.
+// The schema returned by Default contains rules for many of the extensions
+// implemented by this module and may be extended or modified like any other
+// map:
+//
+//     s := validate.Default()
+//     s[xml.Name{Space: "urn:example", Local: "thing"}] = validate.Rule{
+//         Attrs: []string{"id"},
+//     }
+//
+// Elements that do not have a rule, and children or attributes that are not
+// mentioned by a rule, are not checked.
+package validate // import "mellium.im/xmpp/validate"
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/ns"
+	"mellium.im/xmpp/stanza"
+)
+
+// Child limits the number of times that a child element may occur.
+type Child struct {
+	Name xml.Name
+	Min  int
+
+	// Max is the maximum number of times the child may occur.
+	// If Max is 0 there is no upper limit.
+	Max int
+}
+
+// Rule describes the structure of an element.
+type Rule struct {
+	// Attrs is a list of unqualified attributes that must be present and
+	// non-empty.
+	Attrs []string
+
+	// Children limits the number of times a child element may occur.
+	Children []Child
+
+	// Check, if set, is called with the start element after the other
+	// attribute checks have passed and may be used to implement rules that
+	// cannot be described by the other fields.
+	Check func(xml.StartElement) error
+}
+
+// Schema is a set of rules keyed by the element they apply to.
+type Schema map[xml.Name]Rule
+
+// Error is returned when an element fails validation.
+type Error struct {
+	// Path is the name of every element from the root of the validated element
+	// to the element that failed validation.
+	Path []xml.Name
+	Err  error
+}
+
+// Error satisfies the error interface.
+// Namespaces are only included in the path when they differ from the
+// namespace of the parent element.
+func (e Error) Error() string {
+	var buf strings.Builder
+	buf.WriteString("validate: ")
+	var space string
+	for i, name := range e.Path {
+		if i > 0 {
+			buf.WriteByte('/')
+		}
+		if name.Space != space {
+			fmt.Fprintf(&buf, "{%s}", name.Space)
+			space = name.Space
+		}
+		buf.WriteString(name.Local)
+	}
+	buf.WriteString(": ")
+	buf.WriteString(e.Err.Error())
+	return buf.String()
+}
+
+// Unwrap returns the underlying error.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+type frame struct {
+	name   xml.Name
+	rule   Rule
+	ok     bool
+	counts map[xml.Name]int
+}
+
+// Validate reads a single element from r and checks it and all of its children
+// against the schema.
+// It stops reading and returns an error of type Error as soon as the first
+// problem is found.
+func (s Schema) Validate(r xml.TokenReader) error {
+	var stack []frame
+	path := func() []xml.Name {
+		p := make([]xml.Name, 0, len(stack))
+		for _, f := range stack {
+			p = append(p, f.name)
+		}
+		return p
+	}
+	for {
+		tok, err := r.Token()
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				if parent.ok {
+					parent.counts[t.Name]++
+				}
+			}
+			f := frame{name: t.Name}
+			f.rule, f.ok = s[t.Name]
+			if f.ok {
+				f.counts = make(map[xml.Name]int)
+			}
+			stack = append(stack, f)
+			if f.ok {
+				if e := checkAttrs(f.rule, t); e != nil {
+					return Error{Path: path(), Err: e}
+				}
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return fmt.Errorf("validate: unexpected end element %s", t.Name.Local)
+			}
+			f := stack[len(stack)-1]
+			if f.ok {
+				if e := checkChildren(f.rule, f.counts); e != nil {
+					return Error{Path: path(), Err: e}
+				}
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return nil
+			}
+		}
+		switch {
+		case err == io.EOF && len(stack) > 0:
+			return io.ErrUnexpectedEOF
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+}
+
+func checkAttrs(rule Rule, start xml.StartElement) error {
+	for _, name := range rule.Attrs {
+		var found bool
+		for _, a := range start.Attr {
+			if a.Name.Space == "" && a.Name.Local == name && a.Value != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("missing required attribute %q", name)
+		}
+	}
+	if rule.Check != nil {
+		return rule.Check(start)
+	}
+	return nil
+}
+
+func checkChildren(rule Rule, counts map[xml.Name]int) error {
+	for _, c := range rule.Children {
+		n := counts[c.Name]
+		if n < c.Min {
+			return fmt.Errorf("expected at least %d <%s/> but got %d", c.Min, c.Name.Local, n)
+		}
+		if c.Max > 0 && n > c.Max {
+			return fmt.Errorf("expected at most %d <%s/> but got %d", c.Max, c.Name.Local, n)
+		}
+	}
+	return nil
+}
+
+// Handler is an xmpp.Handler that validates each element before passing it on
+// to another handler.
+type Handler struct {
+	Schema  Schema
+	Handler xmpp.Handler
+
+	// Invalid is called for every element that fails validation and any error it
+	// returns is returned from HandleXMPP (which will normally end the session).
+	// If Invalid is nil, invalid elements are dropped.
+	// Either way, invalid IQs of type get or set are responded to with a
+	// bad-request error containing a description of the problem.
+	Invalid func(start xml.StartElement, err error) error
+}
+
+// HandleXMPP satisfies xmpp.Handler.
+func (h Handler) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	var toks []xml.Token
+	for {
+		tok, err := t.Token()
+		if tok != nil {
+			toks = append(toks, xml.CopyToken(tok))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err := h.Schema.Validate(xmlstream.MultiReader(
+		xmlstream.Token(*start),
+		xmlstream.ReaderFunc(tokenReader(toks)),
+	))
+	if err != nil {
+		if start.Name.Local == "iq" {
+			iq, e := stanza.NewIQ(*start)
+			if e == nil && (iq.Type == stanza.GetIQ || iq.Type == stanza.SetIQ) {
+				_, e = xmlstream.Copy(t, iq.Error(stanza.Error{
+					Type:      stanza.Modify,
+					Condition: stanza.BadRequest,
+					Text: map[string]string{
+						"": err.Error(),
+					},
+				}))
+				if e != nil {
+					return e
+				}
+			}
+		}
+		if h.Invalid != nil {
+			return h.Invalid(*start, err)
+		}
+		return nil
+	}
+
+	if h.Handler == nil {
+		return nil
+	}
+	return h.Handler.HandleXMPP(struct {
+		xml.TokenReader
+		xmlstream.Encoder
+	}{
+		TokenReader: xmlstream.ReaderFunc(tokenReader(toks)),
+		Encoder:     t,
+	}, start)
+}
+
+func tokenReader(toks []xml.Token) func() (xml.Token, error) {
+	return func() (xml.Token, error) {
+		if len(toks) == 0 {
+			return nil, io.EOF
+		}
+		var tok xml.Token
+		tok, toks = toks[0], toks[1:]
+		return tok, nil
+	}
+}
+
+func iqType(start xml.StartElement) error {
+	for _, a := range start.Attr {
+		if a.Name.Local != "type" {
+			continue
+		}
+		switch stanza.IQType(a.Value) {
+		case stanza.GetIQ, stanza.SetIQ, stanza.ResultIQ, stanza.ErrorIQ:
+			return nil
+		}
+		return fmt.Errorf("invalid IQ type %q", a.Value)
+	}
+	return nil
+}
+
+// Default returns a new schema containing rules for the stanzas defined in RFC
+// 6120 and for payloads defined by some of the extensions implemented in this
+// module.
+func Default() Schema {
+	s := Schema{
+		{Space: ns.DiscoInfo, Local: "identity"}: {Attrs: []string{"category", "type"}},
+		{Space: ns.DiscoInfo, Local: "feature"}:  {Attrs: []string{"var"}},
+		{Space: ns.DiscoItems, Local: "item"}:    {Attrs: []string{"jid"}},
+		{Space: ns.Roster, Local: "item"}:        {Attrs: []string{"jid"}},
+		{Space: ns.Version, Local: "query"}: {Children: []Child{
+			{Name: xml.Name{Space: ns.Version, Local: "name"}, Max: 1},
+			{Name: xml.Name{Space: ns.Version, Local: "version"}, Max: 1},
+			{Name: xml.Name{Space: ns.Version, Local: "os"}, Max: 1},
+		}},
+		{Space: ns.Delay, Local: "delay"}:              {Attrs: []string{"stamp"}},
+		{Space: ns.Form, Local: "x"}:                   {Attrs: []string{"type"}},
+		{Space: ns.Receipts, Local: "received"}:        {Attrs: []string{"id"}},
+		{Space: ns.ChatMarkers, Local: "displayed"}:    {Attrs: []string{"id"}},
+		{Space: ns.ChatMarkers, Local: "received"}:     {Attrs: []string{"id"}},
+		{Space: ns.ChatMarkers, Local: "acknowledged"}: {Attrs: []string{"id"}},
+		{Space: ns.Correct, Local: "replace"}:          {Attrs: []string{"id"}},
+		{Space: ns.Reactions, Local: "reactions"}:      {Attrs: []string{"id"}},
+		{Space: ns.Reply, Local: "reply"}:              {Attrs: []string{"id"}},
+		{Space: ns.StanzaID, Local: "stanza-id"}:       {Attrs: []string{"id", "by"}},
+		{Space: ns.MAM, Local: "result"}:               {Attrs: []string{"id"}},
+		{Space: ns.Hashes, Local: "hash"}:              {Attrs: []string{"algo"}},
+		{Space: ns.Jingle, Local: "jingle"}:            {Attrs: []string{"action", "sid"}},
+	}
+	for _, space := range []string{ns.Client, ns.Server} {
+		s[xml.Name{Space: space, Local: "iq"}] = Rule{
+			Attrs: []string{"id", "type"},
+			Check: iqType,
+		}
+		s[xml.Name{Space: space, Local: "error"}] = Rule{
+			Attrs: []string{"type"},
+		}
+	}
+	return s
+}
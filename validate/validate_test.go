@@ -0,0 +1,153 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package validate_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/validate"
+)
+
+var _ xmpp.Handler = validate.Handler{}
+
+var validateTestCases = [...]struct {
+	in  string
+	err string
+}{
+	0: {in: `<iq xmlns="jabber:client" id="1" type="get"><query xmlns="jabber:iq:version"/></iq>`},
+	1: {
+		in:  `<iq xmlns="jabber:client" type="get"><query xmlns="jabber:iq:version"/></iq>`,
+		err: `validate: {jabber:client}iq: missing required attribute "id"`,
+	},
+	2: {
+		in:  `<iq xmlns="jabber:client" id="1" type="foo"/>`,
+		err: `validate: {jabber:client}iq: invalid IQ type "foo"`,
+	},
+	3: {
+		in:  `<iq xmlns="jabber:client" id="1" type="result"><query xmlns="jabber:iq:version"><name>a</name><name>b</name></query></iq>`,
+		err: `validate: {jabber:client}iq/{jabber:iq:version}query: expected at most 1 <name/> but got 2`,
+	},
+	4: {
+		in:  `<query xmlns="http://jabber.org/protocol/disco#info"><identity category="client"/></query>`,
+		err: `validate: {http://jabber.org/protocol/disco#info}query/identity: missing required attribute "type"`,
+	},
+	5: {in: `<unknown xmlns="urn:example"><item/></unknown>`},
+}
+
+func TestValidate(t *testing.T) {
+	s := validate.Default()
+	for i, tc := range validateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := s.Validate(xml.NewDecoder(strings.NewReader(tc.in)))
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.err != "" && err == nil:
+				t.Errorf("expected error %q", tc.err)
+			case err != nil && err.Error() != tc.err:
+				t.Errorf("wrong error: want=%q, got=%q", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestUnexpectedEOF(t *testing.T) {
+	err := validate.Default().Validate(xmlstream.Token(xml.StartElement{
+		Name: xml.Name{Space: "jabber:iq:version", Local: "query"},
+	}))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("wrong error: want=%v, got=%v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestCustomRule(t *testing.T) {
+	errOdd := errors.New("odd")
+	s := validate.Schema{
+		{Space: "urn:example", Local: "a"}: {
+			Children: []validate.Child{{Name: xml.Name{Space: "urn:example", Local: "b"}, Min: 1}},
+			Check: func(start xml.StartElement) error {
+				if len(start.Attr) > 1 {
+					return errOdd
+				}
+				return nil
+			},
+		},
+	}
+	err := s.Validate(xml.NewDecoder(strings.NewReader(`<a xmlns="urn:example"></a>`)))
+	var validateErr validate.Error
+	if !errors.As(err, &validateErr) || len(validateErr.Path) != 1 {
+		t.Errorf("expected validation error with path, got %v", err)
+	}
+	err = s.Validate(xml.NewDecoder(strings.NewReader(`<a xmlns="urn:example" foo="bar"><b/></a>`)))
+	if !errors.Is(err, errOdd) {
+		t.Errorf("expected custom check error, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var invalid []error
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(validate.Handler{
+			Schema: validate.Default(),
+			Handler: mux.New(mux.IQFunc(stanza.SetIQ, xml.Name{Space: roster.NS, Local: "query"},
+				func(iq stanza.IQ, t xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+					_, err := xmlstream.Copy(t, iq.Result(nil))
+					return err
+				},
+			)),
+			Invalid: func(_ xml.StartElement, err error) error {
+				invalid = append(invalid, err)
+				return nil
+			},
+		}),
+	)
+	defer cs.Close()
+
+	item := func(attr []xml.Attr) xml.TokenReader {
+		return stanza.IQ{Type: stanza.SetIQ, To: jid.JID{}}.Wrap(xmlstream.Wrap(
+			xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "item"}, Attr: attr}),
+			xml.StartElement{Name: xml.Name{Space: roster.NS, Local: "query"}},
+		))
+	}
+
+	resp, err := cs.Client.SendIQ(ctx, item([]xml.Attr{{Name: xml.Name{Local: "jid"}, Value: "a@example.net"}}))
+	if err != nil {
+		t.Fatalf("error sending valid IQ: %v", err)
+	}
+	err = resp.Close()
+	if err != nil {
+		t.Fatalf("error closing response: %v", err)
+	}
+
+	err = cs.Client.UnmarshalIQ(ctx, item(nil), nil)
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.BadRequest {
+		t.Fatalf("wrong error: want=%v, got=%v", stanza.BadRequest, err)
+	}
+	const want = `missing required attribute "jid"`
+	if !strings.Contains(stanzaErr.Text[""], want) {
+		t.Errorf("wrong error text: want to contain %q, got %q", want, stanzaErr.Text[""])
+	}
+	if len(invalid) != 1 {
+		t.Errorf("wrong number of invalid elements reported: want=1, got=%d", len(invalid))
+	}
+}
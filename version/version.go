@@ -2,16 +2,19 @@
 // Use of this source code is governed by the BSD 2-clause
 // license that can be found in the LICENSE file.
 
-// Package version queries a remote entity for software version info.
+// Package version queries a remote entity for software version info and
+// responds to software version queries.
 package version // import "mellium.im/xmpp/version"
 
 import (
 	"context"
 	"encoding/xml"
+	"runtime/debug"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/stanza"
 )
 
@@ -78,3 +81,56 @@ func GetIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session) (Query, error) {
 	err := s.UnmarshalIQ(ctx, iq.Wrap(query.TokenReader()), &query)
 	return query, err
 }
+
+// Handle returns an option that registers a Handler for software version
+// queries.
+func Handle(h Handler) mux.Option {
+	return mux.IQ(stanza.GetIQ, xml.Name{Space: NS, Local: "query"}, h)
+}
+
+// Handler responds to software version queries.
+type Handler struct {
+	// Name and Version identify the software.
+	// If both are empty, the path and version of the main module are read from
+	// the build information embedded in the binary.
+	Name    string
+	Version string
+
+	// OS is the operating system the software is running on.
+	// Because knowing the operating system may help an attacker, it is not
+	// filled in automatically and is only sent if set.
+	OS string
+
+	// Allow is called with the address of the entity requesting our version.
+	// If it is not nil and returns false, the request is refused with a
+	// service-unavailable error as if software version were not supported.
+	Allow func(jid.JID) bool
+}
+
+// HandleIQ implements mux.IQHandler.
+func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if iq.Type != stanza.GetIQ || start.Name.Local != "query" || start.Name.Space != NS {
+		return nil
+	}
+	if h.Allow != nil && !h.Allow(iq.From) {
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ServiceUnavailable,
+		}))
+		return err
+	}
+
+	q := Query{
+		Name:    h.Name,
+		Version: h.Version,
+		OS:      h.OS,
+	}
+	if q.Name == "" && q.Version == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			q.Name = info.Main.Path
+			q.Version = info.Main.Version
+		}
+	}
+	_, err := xmlstream.Copy(t, iq.Result(q.TokenReader()))
+	return err
+}
@@ -7,6 +7,7 @@ package version_test
 import (
 	"context"
 	"encoding/xml"
+	"errors"
 	"reflect"
 	"strconv"
 	"strings"
@@ -15,6 +16,7 @@ import (
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/internal/xmpptest"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/stanza"
 	"mellium.im/xmpp/version"
 )
@@ -22,6 +24,7 @@ import (
 var (
 	_ xmlstream.Marshaler = (*version.Query)(nil)
 	_ xmlstream.WriterTo  = (*version.Query)(nil)
+	_ mux.IQHandler       = version.Handler{}
 )
 
 var marshalTests = [...]struct {
@@ -101,3 +104,33 @@ func TestGet(t *testing.T) {
 		t.Errorf("unexpected response: want=%v, got=%v", query, resp)
 	}
 }
+
+func TestHandle(t *testing.T) {
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(version.Handle(version.Handler{
+			Name:    "name",
+			Version: "ver",
+		}))),
+	)
+	defer cs.Close()
+
+	resp, err := version.Get(context.Background(), cs.Client, jid.JID{})
+	if err != nil {
+		t.Fatalf("error querying version: %v", err)
+	}
+	if resp.Name != "name" || resp.Version != "ver" || resp.OS != "" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	cs = xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(version.Handle(version.Handler{
+			Allow: func(jid.JID) bool { return false },
+		}))),
+	)
+	defer cs.Close()
+	_, err = version.Get(context.Background(), cs.Client, jid.JID{})
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.ServiceUnavailable {
+		t.Errorf("wrong error: want=%v, got=%v", stanza.ServiceUnavailable, err)
+	}
+}
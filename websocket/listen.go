@@ -0,0 +1,156 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// ErrListenerClosed is returned by Accept after the listener has been closed.
+var ErrListenerClosed = errors.New("websocket: listener closed")
+
+var errNoProtocol = errors.New("websocket: client did not request the " + WSProtocol + " subprotocol")
+
+// Listener accepts WebSocket connections that use the XMPP subprotocol.
+//
+// It is an http.Handler that performs the WebSocket handshake for each request
+// and then hands the connection to the next call to Accept, so it can be
+// mounted on any path of an existing HTTP server:
+//
+//     l := websocket.NewListener(nil)
+//     http.Handle("/xmpp-websocket", l)
+//     go http.ListenAndServe(":5280", nil)
+//     for {
+//         conn, err := l.Accept()
+//         if err != nil {
+//             return err
+//         }
+//         go websocket.ReceiveSession(ctx, conn, features...)
+//     }
+//
+// Requests that do not ask for the XMPP subprotocol are rejected.
+type Listener struct {
+	// CheckOrigin, if set, is called with each request before the handshake is
+	// completed and the request is rejected if it returns false.
+	// It must not be modified after the listener starts serving requests.
+	// If it is nil, all origins are accepted (including requests with no
+	// origin, which are normally made by clients that are not web browsers).
+	CheckOrigin func(r *http.Request) bool
+
+	addr      net.Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener returns a listener that has not yet accepted any connections.
+// The address is returned from the listener's Addr method and should normally
+// be the address of the HTTP server that the listener is mounted on.
+func NewListener(addr net.Addr) *Listener {
+	return &Listener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Accept waits for and returns the next WebSocket connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close stops the listener from accepting new connections.
+// Requests that are received after the listener is closed are rejected, but
+// connections that have already been accepted are not closed.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the address that was passed to NewListener.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// ServeHTTP performs the WebSocket handshake and blocks until the connection
+// has been accepted and closed.
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-l.closed:
+		http.Error(w, ErrListenerClosed.Error(), http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	websocket.Server{
+		Handshake: l.handshake,
+		Handler:   l.handle,
+	}.ServeHTTP(w, r)
+}
+
+func (l *Listener) handshake(cfg *websocket.Config, r *http.Request) error {
+	if l.CheckOrigin != nil && !l.CheckOrigin(r) {
+		return errors.New("websocket: origin not allowed")
+	}
+	for _, proto := range cfg.Protocol {
+		if proto == WSProtocol {
+			cfg.Protocol = []string{WSProtocol}
+			return nil
+		}
+	}
+	return errNoProtocol
+}
+
+func (l *Listener) handle(ws *websocket.Conn) {
+	c := &conn{Conn: ws, done: make(chan struct{})}
+	select {
+	case l.conns <- c:
+	case <-l.closed:
+		/* #nosec */
+		ws.Close()
+		return
+	}
+	// The connection is closed by the websocket package when the handler
+	// returns, so wait until the user is done with it.
+	<-c.done
+}
+
+// conn is a WebSocket connection that signals the HTTP handler that accepted
+// it when it is closed.
+type conn struct {
+	*websocket.Conn
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return err
+}
+
+// wsConn returns the underlying WebSocket connection of rw if it has one.
+func wsConn(rw interface{}) (*websocket.Conn, bool) {
+	switch c := rw.(type) {
+	case *websocket.Conn:
+		return c, true
+	case *conn:
+		return c.Conn, true
+	}
+	return nil, false
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package websocket_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	xwebsocket "golang.org/x/net/websocket"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/websocket"
+)
+
+var _ net.Listener = (*websocket.Listener)(nil)
+
+func TestListener(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	l := websocket.NewListener(nil)
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+	defer l.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("error accepting connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		// The server waits for the client to select a feature, so this only
+		// returns once the client goes away.
+		/* #nosec */
+		websocket.ReceiveSession(ctx, conn)
+	}()
+
+	conn, err := websocket.DialDirect(ctx, "http://localhost/", url)
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	s, err := websocket.NewSession(ctx, jid.MustParse("me@example.net"), conn)
+	if err != nil {
+		t.Fatalf("error negotiating client session: %v", err)
+	}
+	if s.State()&xmpp.Ready == 0 {
+		t.Errorf("expected client session to be ready")
+	}
+	/* #nosec */
+	s.Close()
+	/* #nosec */
+	conn.Close()
+	select {
+	case <-serverDone:
+	case <-ctx.Done():
+		t.Errorf("server session did not end after the client disconnected")
+	}
+}
+
+func TestListenerNoProtocol(t *testing.T) {
+	l := websocket.NewListener(nil)
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+	defer l.Close()
+
+	cfg, err := xwebsocket.NewConfig("ws"+strings.TrimPrefix(srv.URL, "http"), "http://localhost/")
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+	_, err = xwebsocket.DialConfig(cfg)
+	if err == nil {
+		t.Errorf("expected handshake without the xmpp subprotocol to fail")
+	}
+}
+
+func TestListenerOrigin(t *testing.T) {
+	l := websocket.NewListener(nil)
+	l.CheckOrigin = func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://example.net"
+	}
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+	defer l.Close()
+
+	_, err := websocket.DialDirect(context.Background(), "https://example.com", "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if err == nil {
+		t.Errorf("expected handshake from disallowed origin to fail")
+	}
+}
+
+func TestListenerClosed(t *testing.T) {
+	l := websocket.NewListener(nil)
+	/* #nosec */
+	l.Close()
+	if _, err := l.Accept(); err != websocket.ErrListenerClosed {
+		t.Errorf("wrong error: want=%v, got=%v", websocket.ErrListenerClosed, err)
+	}
+}
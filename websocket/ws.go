@@ -11,7 +11,6 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"sort"
 	"strings"
 
 	"golang.org/x/net/websocket"
@@ -32,7 +31,7 @@ func NewSession(ctx context.Context, addr jid.JID, rw io.ReadWriter, features ..
 		WebSocket: true,
 	})
 	var mask xmpp.SessionState
-	if wsConn, ok := rw.(*websocket.Conn); ok && wsConn.LocalAddr().(*websocket.Addr).Scheme == "wss" {
+	if ws, ok := wsConn(rw); ok && ws.LocalAddr().(*websocket.Addr).Scheme == "wss" {
 		mask |= xmpp.Secure
 	}
 	return xmpp.NewSession(ctx, addr.Domain(), addr, rw, mask, n)
@@ -40,7 +39,8 @@ func NewSession(ctx context.Context, addr jid.JID, rw io.ReadWriter, features ..
 
 // ReceiveSession establishes an XMPP session from the perspective of the
 // receiving server on rw using the WebSocket subprotocol.
-// It does not perform the WebSocket handshake.
+// It does not perform the WebSocket handshake, to accept connections that have
+// already been through the handshake use a Listener.
 func ReceiveSession(ctx context.Context, rw io.ReadWriter, features ...xmpp.StreamFeature) (*xmpp.Session, error) {
 	n := xmpp.NewNegotiator(xmpp.StreamConfig{
 		Features: func(*xmpp.Session, ...xmpp.StreamFeature) []xmpp.StreamFeature {
@@ -49,7 +49,7 @@ func ReceiveSession(ctx context.Context, rw io.ReadWriter, features ...xmpp.Stre
 		WebSocket: true,
 	})
 	var mask xmpp.SessionState
-	if wsConn, ok := rw.(*websocket.Conn); ok && wsConn.LocalAddr().(*websocket.Addr).Scheme == "wss" {
+	if ws, ok := wsConn(rw); ok && ws.LocalAddr().(*websocket.Addr).Scheme == "wss" {
 		mask |= xmpp.Secure
 	}
 	return xmpp.ReceiveSession(ctx, rw, mask, n)
@@ -174,17 +174,7 @@ func (d *Dialer) Dial(ctx context.Context, addr jid.JID) (net.Conn, error) {
 	}
 	// Prioritize wss over anything else, then ws, then anything else that will
 	// likely just result in an error.
-	sort.Slice(urls, func(i, j int) bool {
-		switch {
-		case strings.HasPrefix(urls[i], "wss:"):
-			return true
-		case strings.HasPrefix(urls[i], "wss:"):
-			return false
-		case strings.HasPrefix(urls[i], "ws:"):
-			return true
-		}
-		return false
-	})
+	discover.SortByScheme(urls, "wss", "ws")
 
 	var conn net.Conn
 	var cfg *websocket.Config
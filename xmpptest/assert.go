@@ -0,0 +1,241 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/internal/xmpptest"
+)
+
+// assertTimeout is how long AssertSent waits for a matching stanza.
+const assertTimeout = 5 * time.Second
+
+// Wildcard may be used as the value of an attribute or as the entire text of an
+// element in a golden stanza to match any value.
+// This is useful for attributes that are different every time a test is run
+// such as IDs and timestamps.
+const Wildcard = "*"
+
+// Matcher checks a serialized stanza and returns an error describing why it
+// does not match, or nil if it does.
+type Matcher func(stanza string) error
+
+// Golden returns a matcher that compares stanzas to want.
+//
+// Stanzas are compared element by element so attribute order, namespace
+// prefixes, and whitespace between elements do not matter.
+// The top level element of want is in the jabber:client namespace unless
+// another namespace is declared.
+// Attribute values and text that are equal to Wildcard match anything.
+//
+//     Golden(`<message id="*" to="juliet@example.net"><body>Hi</body></message>`)
+func Golden(want string) Matcher {
+	return func(stanza string) error {
+		wantTree, err := parseTree(want, ns.Client)
+		if err != nil {
+			return fmt.Errorf("xmpptest: bad golden stanza: %w", err)
+		}
+		gotTree, err := parseTree(stanza, "")
+		if err != nil {
+			return err
+		}
+		return wantTree.match(gotTree)
+	}
+}
+
+// node is a simplified XML element used for comparing stanzas.
+type node struct {
+	name     xml.Name
+	attr     map[xml.Name]string
+	text     string
+	children []*node
+}
+
+func parseTree(s, defaultNS string) (*node, error) {
+	var d *xml.Decoder
+	if defaultNS == "" {
+		d = xml.NewDecoder(strings.NewReader(s))
+	} else {
+		d = xml.NewDecoder(strings.NewReader(`<wrapper xmlns="` + defaultNS + `">` + s + `</wrapper>`))
+		// Pop the wrapper.
+		_, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var stack []*node
+	var root *node
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: tok.Name, attr: make(map[xml.Name]string)}
+			for _, a := range tok.Attr {
+				if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+					continue
+				}
+				n.attr[a.Name] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return root, nil
+			}
+			root, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return root, nil
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(tok)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("xmpptest: no element found in %q", s)
+	}
+	return root, nil
+}
+
+func (n *node) match(got *node) error {
+	if n.name != got.name {
+		return fmt.Errorf("wrong element: want=%v, got=%v", n.name, got.name)
+	}
+	for name, v := range n.attr {
+		gotV, ok := got.attr[name]
+		if !ok {
+			return fmt.Errorf("missing attribute %v on %v", name, n.name)
+		}
+		if v != Wildcard && v != gotV {
+			return fmt.Errorf("wrong value for attribute %v on %v: want=%q, got=%q", name, n.name, v, gotV)
+		}
+	}
+	for name := range got.attr {
+		if _, ok := n.attr[name]; !ok {
+			return fmt.Errorf("unexpected attribute %v on %v", name, n.name)
+		}
+	}
+	if want, text := strings.TrimSpace(n.text), strings.TrimSpace(got.text); want != Wildcard && want != text {
+		return fmt.Errorf("wrong text in %v: want=%q, got=%q", n.name, want, text)
+	}
+	if len(n.children) != len(got.children) {
+		return fmt.Errorf("wrong number of children in %v: want=%d, got=%d", n.name, len(n.children), len(got.children))
+	}
+	for i, child := range n.children {
+		err := child.match(got.children[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FakeSession is a client session connected to a fake server that records
+// every stanza sent by the client so that it can be checked using AssertSent.
+type FakeSession struct {
+	*xmpp.Session
+
+	cs      *xmpptest.ClientServer
+	mu      sync.Mutex
+	sent    []string
+	changed chan struct{}
+}
+
+// NewFakeSession returns a FakeSession.
+// If h is not nil, each stanza is passed to it after it is recorded so that it
+// can respond.
+func NewFakeSession(h xmpp.Handler) *FakeSession {
+	s := &FakeSession{changed: make(chan struct{})}
+	rec := NewRecorder(sentWriter{s: s}, nil)
+	s.cs = xmpptest.NewClientServer(xmpptest.ServerHandler(rec.Handler(h)))
+	s.Session = s.cs.Client
+	return s
+}
+
+// sentWriter collects the stanzas recorded for a FakeSession.
+// Recorders write each stanza in a single call to Write.
+type sentWriter struct {
+	s *FakeSession
+}
+
+func (w sentWriter) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	w.s.sent = append(w.s.sent, string(p))
+	close(w.s.changed)
+	w.s.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// Sent returns the stanzas that have been received by the fake server so far.
+func (s *FakeSession) Sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+// Close closes the client session and the fake server.
+func (s *FakeSession) Close() error {
+	return s.cs.Close()
+}
+
+// wait returns the stanzas that have been sent and a channel that is closed
+// when the next stanza is recorded.
+func (s *FakeSession) wait() ([]string, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...), s.changed
+}
+
+// AssertSent fails the test if no stanza matching m is sent by s within a few
+// seconds.
+func AssertSent(t testing.TB, s *FakeSession, m Matcher) {
+	t.Helper()
+
+	timeout := time.NewTimer(assertTimeout)
+	defer timeout.Stop()
+	var checked int
+	var lastErr error
+	for {
+		sent, changed := s.wait()
+		for _, stanza := range sent[checked:] {
+			lastErr = m(stanza)
+			if lastErr == nil {
+				return
+			}
+		}
+		checked = len(sent)
+		select {
+		case <-changed:
+		case <-timeout.C:
+			if lastErr == nil {
+				t.Errorf("no stanzas sent")
+				return
+			}
+			t.Errorf("no matching stanza sent, last mismatch: %v\nsent:\n%s", lastErr, strings.Join(sent, "\n"))
+			return
+		}
+	}
+}
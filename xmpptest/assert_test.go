@@ -0,0 +1,100 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+var goldenTestCases = []struct {
+	want    string
+	got     string
+	noMatch bool
+}{
+	0: {
+		want: `<message id="*" to="juliet@example.net"><body>Hi</body></message>`,
+		got:  `<message xmlns="jabber:client" to="juliet@example.net" id="1234"><body>Hi</body></message>`,
+	},
+	1: {
+		want: `<message id="*"><delay xmlns="urn:xmpp:delay" stamp="*"/></message>`,
+		got: `<message xmlns="jabber:client" id="1234">
+			<delay xmlns="urn:xmpp:delay" stamp="2002-09-10T23:08:25Z"></delay>
+		</message>`,
+	},
+	2: {
+		want:    `<message id="*"><body>Hi</body></message>`,
+		got:     `<message xmlns="jabber:client" id="1234"><body>Bye</body></message>`,
+		noMatch: true,
+	},
+	3: {
+		want:    `<message/>`,
+		got:     `<message xmlns="jabber:client" id="1234"/>`,
+		noMatch: true,
+	},
+	4: {
+		want:    `<message id="*"/>`,
+		got:     `<message xmlns="jabber:server" id="1234"/>`,
+		noMatch: true,
+	},
+	5: {
+		want: `<iq type="get" id="*"><ping xmlns="urn:xmpp:ping"/></iq>`,
+		got:  `<iq xmlns="jabber:client" id="1" type="get"><p:ping xmlns:p="urn:xmpp:ping"/></iq>`,
+	},
+	6: {
+		want:    `<iq type="get" id="*"><ping xmlns="urn:xmpp:ping"/></iq>`,
+		got:     `<iq xmlns="jabber:client" id="1" type="get"/>`,
+		noMatch: true,
+	},
+	7: {
+		want: `<message><body>*</body></message>`,
+		got:  `<message xmlns="jabber:client"><body>anything</body></message>`,
+	},
+}
+
+func TestGolden(t *testing.T) {
+	for i, tc := range goldenTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := xmpptest.Golden(tc.want)(tc.got)
+			switch {
+			case tc.noMatch && err == nil:
+				t.Errorf("expected %s not to match %s", tc.got, tc.want)
+			case !tc.noMatch && err != nil:
+				t.Errorf("expected %s to match %s: %v", tc.got, tc.want, err)
+			}
+		})
+	}
+}
+
+func TestAssertSent(t *testing.T) {
+	s := xmpptest.NewFakeSession(nil)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.Send(ctx, stanza.Message{
+		ID:   "1234",
+		To:   jid.MustParse("juliet@example.net"),
+		Type: stanza.ChatMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Token(xml.CharData("Hi")),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	)))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	xmpptest.AssertSent(t, s, xmpptest.Golden(`<message id="*" type="chat" to="juliet@example.net"><body>Hi</body></message>`))
+	if sent := s.Sent(); len(sent) != 1 {
+		t.Errorf("wrong number of stanzas sent: want=1, got=%d: %v", len(sent), sent)
+	}
+}
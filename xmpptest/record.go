@@ -0,0 +1,361 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package xmpptest provides helpers for testing code that uses the xmpp
+// package.
+package xmpptest // import "mellium.im/xmpp/xmpptest"
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/xmpptest"
+)
+
+// Recorder captures the stanzas sent and received by a session so that they
+// can be used as fixtures.
+// Fixtures of received stanzas can be served to a handler using Replay.
+//
+// Only stanzas are recorded, so fixtures do not contain authentication data or
+// other secrets sent during session negotiation (but may still contain private
+// messages, so they should be checked before they are committed).
+type Recorder struct {
+	mu   sync.Mutex
+	in   io.Writer
+	out  io.Writer
+	tees []*teeRecorder
+}
+
+// NewRecorder returns a recorder that writes each stanza received by the
+// session to in and each stanza sent by the session to out.
+// If either writer is nil, stanzas in that direction are not recorded.
+// in and out may be the same writer.
+func NewRecorder(in, out io.Writer) *Recorder {
+	return &Recorder{in: in, out: out}
+}
+
+// Handler returns a handler that records each stanza it handles as received
+// before passing it on to h.
+// Any stanzas that h writes in response are recorded as sent.
+// Stanzas sent by the session from outside of the handler are not recorded; to
+// capture the entire session use Tee instead.
+func (r *Recorder) Handler(h xmpp.Handler) xmpp.Handler {
+	return xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var toks xmpptest.Tokens
+		for {
+			tok, err := t.Token()
+			if tok != nil {
+				toks = append(toks, xml.CopyToken(tok))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		err := r.recordIn(*start, toks)
+		if err != nil {
+			return err
+		}
+		if h == nil {
+			return nil
+		}
+		enc := &recordEncoder{r: r, Encoder: t}
+		enc.e = xml.NewEncoder(&enc.buf)
+		return h.HandleXMPP(struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: &toks,
+			Encoder:     enc,
+		}, start)
+	})
+}
+
+// Tee returns writers that record the stanzas in a raw XML stream and are
+// suitable for use as the TeeIn and TeeOut fields of xmpp.StreamConfig.
+// Elements named iq, message, or presence that are not nested inside of
+// another stanza are recorded, everything else is discarded.
+// Close must be called after the session is closed to release the resources
+// used by the writers.
+func (r *Recorder) Tee() (in, out io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	teeIn := newTeeRecorder(r, r.in)
+	teeOut := newTeeRecorder(r, r.out)
+	r.tees = append(r.tees, teeIn, teeOut)
+	return teeIn, teeOut
+}
+
+// Close stops recording the streams returned by Tee and waits for any stanzas
+// that have already been written to them to be recorded.
+// It does not close the underlying writers.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	tees := r.tees
+	r.tees = nil
+	r.mu.Unlock()
+
+	var err error
+	for _, tee := range tees {
+		e := tee.close()
+		if err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (r *Recorder) recordIn(start xml.StartElement, toks xmpptest.Tokens) error {
+	if r.in == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	err := encodeToken(e, start)
+	if err != nil {
+		return err
+	}
+	// Depending on how the handler was called the token stream may or may not
+	// include the end of the stanza, so keep track of whether we've seen it.
+	var level int
+	for _, tok := range toks {
+		switch tok.(type) {
+		case xml.StartElement:
+			level++
+		case xml.EndElement:
+			level--
+		}
+		err = encodeToken(e, tok)
+		if err != nil {
+			return err
+		}
+	}
+	if level == 0 {
+		err = e.EncodeToken(start.End())
+		if err != nil {
+			return err
+		}
+	}
+	err = e.Flush()
+	if err != nil {
+		return err
+	}
+	return r.write(r.in, buf.Bytes())
+}
+
+// write copies a complete stanza to w.
+// It is safe to call from multiple goroutines, and stanzas are never
+// interleaved even if the in and out writers are the same.
+func (r *Recorder) write(w io.Writer, p []byte) error {
+	if w == nil || len(p) == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := w.Write(p)
+	return err
+}
+
+// encodeToken writes tok to e, dropping namespace declarations that the
+// encoder adds itself based on the element name.
+// Tokens read from a decoder contain both the namespace in the name and the
+// attribute that declared it, which would otherwise be written twice.
+func encodeToken(e *xml.Encoder, tok xml.Token) error {
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return e.EncodeToken(tok)
+	}
+	attrs := make([]xml.Attr, 0, len(start.Attr))
+	for _, a := range start.Attr {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	start.Attr = attrs
+	return e.EncodeToken(start)
+}
+
+// recordEncoder records anything written by a handler as sent stanzas before
+// passing it on to the session's encoder.
+type recordEncoder struct {
+	xmlstream.Encoder
+
+	r     *Recorder
+	buf   bytes.Buffer
+	e     *xml.Encoder
+	level int
+}
+
+func (e *recordEncoder) EncodeToken(tok xml.Token) error {
+	err := e.Encoder.EncodeToken(tok)
+	if err != nil || e.r.out == nil {
+		return err
+	}
+	switch tok.(type) {
+	case xml.StartElement:
+		e.level++
+	case xml.EndElement:
+		e.level--
+	}
+	err = encodeToken(e.e, xml.CopyToken(tok))
+	if err != nil {
+		return err
+	}
+	return e.flush()
+}
+
+func (e *recordEncoder) Encode(v interface{}) error {
+	err := e.Encoder.Encode(v)
+	if err != nil || e.r.out == nil {
+		return err
+	}
+	err = e.e.Encode(v)
+	if err != nil {
+		return err
+	}
+	return e.flush()
+}
+
+func (e *recordEncoder) EncodeElement(v interface{}, start xml.StartElement) error {
+	err := e.Encoder.EncodeElement(v, start)
+	if err != nil || e.r.out == nil {
+		return err
+	}
+	err = e.e.EncodeElement(v, start)
+	if err != nil {
+		return err
+	}
+	return e.flush()
+}
+
+// flush records the buffered tokens once a complete element has been written.
+func (e *recordEncoder) flush() error {
+	if e.level > 0 {
+		return nil
+	}
+	err := e.e.Flush()
+	if err != nil {
+		return err
+	}
+	err = e.r.write(e.r.out, e.buf.Bytes())
+	e.buf.Reset()
+	return err
+}
+
+// teeRecorder parses a raw XML stream written to it and records the stanzas it
+// contains.
+type teeRecorder struct {
+	*io.PipeWriter
+
+	done chan error
+}
+
+func newTeeRecorder(r *Recorder, w io.Writer) *teeRecorder {
+	pr, pw := io.Pipe()
+	tee := &teeRecorder{
+		PipeWriter: pw,
+		done:       make(chan error, 1),
+	}
+	go func() {
+		err := recordStream(r, w, xml.NewDecoder(pr))
+		// Never block the session, even if the stream could not be parsed.
+		/* #nosec */
+		io.Copy(ioutil.Discard, pr)
+		tee.done <- err
+	}()
+	return tee
+}
+
+func (tee *teeRecorder) close() error {
+	err := tee.PipeWriter.Close()
+	if err != nil {
+		return err
+	}
+	err = <-tee.done
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
+
+// recordStream decodes a stream and writes each stanza to w.
+// The stream may be restarted any number of times or be made up of individual
+// framed elements, as long as the stanzas themselves are well formed.
+func recordStream(r *Recorder, w io.Writer, d *xml.Decoder) error {
+	var (
+		buf   bytes.Buffer
+		e     = xml.NewEncoder(&buf)
+		level int
+	)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if se, ok := err.(*xml.SyntaxError); ok && se.Msg == "unexpected EOF" {
+				return io.EOF
+			}
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case level > 0:
+				level++
+			case t.Name.Local == "iq" || t.Name.Local == "message" || t.Name.Local == "presence":
+				level = 1
+			default:
+				continue
+			}
+		case xml.EndElement:
+			if level == 0 {
+				continue
+			}
+			level--
+		default:
+			if level == 0 {
+				continue
+			}
+		}
+		err = encodeToken(e, tok)
+		if err != nil {
+			return err
+		}
+		if level == 0 {
+			err = e.Flush()
+			if err != nil {
+				return err
+			}
+			err = r.write(w, buf.Bytes())
+			if err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+	}
+}
+
+// Replay serves each stanza in a fixture of received stanzas created by a
+// Recorder to h and blocks until the fixture has been consumed.
+// Anything that h writes in response is written to out, followed by the
+// closing stream element.
+// This allows application handlers to be tested against traffic that was
+// captured from a real server.
+func Replay(fixture io.Reader, out io.Writer, h xmpp.Handler) error {
+	return xmpptest.NewSession(0, struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: fixture,
+		Writer: out,
+	}).Serve(h)
+}
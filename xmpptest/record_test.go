@@ -0,0 +1,151 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	intxmpptest "mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/xmpptest"
+)
+
+func echoBody(bodies *[]string) xmpp.Handler {
+	return mux.New(mux.MessageFunc(stanza.ChatMessage, xml.Name{Local: "body"}, func(msg stanza.Message, t xmlstream.TokenReadEncoder) error {
+		var m struct {
+			stanza.Message
+			Body string `xml:"body"`
+		}
+		err := xml.NewTokenDecoder(t).Decode(&m)
+		if err != nil {
+			return err
+		}
+		*bodies = append(*bodies, m.Body)
+		reply := stanza.Message{To: msg.From, Type: stanza.ChatMessage}
+		_, err = xmlstream.Copy(t, reply.Wrap(xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(m.Body)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		)))
+		return err
+	}))
+}
+
+func TestRecordReplay(t *testing.T) {
+	// Record traffic from a live session.
+	var recorded []string
+	handled := make(chan struct{}, 2)
+	fixture := &bytes.Buffer{}
+	sent := &bytes.Buffer{}
+	rec := xmpptest.NewRecorder(fixture, sent)
+	cs := intxmpptest.NewClientServer(intxmpptest.ServerHandler(rec.Handler(xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		defer func() {
+			handled <- struct{}{}
+		}()
+		return echoBody(&recorded).HandleXMPP(t, start)
+	}))))
+	defer cs.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, body := range []string{"one", "two"} {
+		err := cs.Client.Send(ctx, stanza.Message{
+			To:   cs.Server.LocalAddr(),
+			Type: stanza.ChatMessage,
+		}.Wrap(xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(body)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		)))
+		if err != nil {
+			t.Fatalf("error sending message: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for messages to be recorded")
+		}
+	}
+	if n := strings.Count(sent.String(), "<body>"); n != 2 {
+		t.Errorf("wrong number of replies recorded: want=2, got=%d (%q)", n, sent.String())
+	}
+
+	// Replay the fixture without a server.
+	var bodies []string
+	out := &bytes.Buffer{}
+	err := xmpptest.Replay(fixture, out, echoBody(&bodies))
+	if err != nil {
+		t.Fatalf("error replaying fixture: %v", err)
+	}
+	if len(bodies) != 2 || bodies[0] != "one" || bodies[1] != "two" {
+		t.Errorf("wrong messages replayed: want=[one two], got=%v", bodies)
+	}
+	if n := strings.Count(out.String(), "<body>"); n != 2 {
+		t.Errorf("wrong number of replies written during replay: want=2, got=%d (%q)", n, out.String())
+	}
+}
+
+var teeTestCases = [...]struct {
+	in   string
+	want string
+}{
+	0: {},
+	1: {
+		in:   `<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams"><message id="1"><body>Hi</body></message></stream:stream>`,
+		want: `<message xmlns="jabber:client" id="1"><body xmlns="jabber:client">Hi</body></message>`,
+	},
+	2: {
+		// Negotiation is not recorded and restarts are handled.
+		in: `<?xml version="1.0"?><stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams">` +
+			`<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">c2VjcmV0</auth>` +
+			`<?xml version="1.0"?><stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams">` +
+			`<iq id="1" type="get"><ping xmlns="urn:xmpp:ping"/></iq><presence/>`,
+		want: `<iq xmlns="jabber:client" id="1" type="get"><ping xmlns="urn:xmpp:ping"></ping></iq><presence xmlns="jabber:client"></presence>`,
+	},
+	3: {
+		// Framed streams and nested stanzas.
+		in: `<open xmlns="urn:ietf:params:xml:ns:xmpp-framing"/>` +
+			`<message xmlns="jabber:client"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client"/></forwarded></message>` +
+			`<close xmlns="urn:ietf:params:xml:ns:xmpp-framing"/>`,
+		want: `<message xmlns="jabber:client"><forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client"></message></forwarded></message>`,
+	},
+}
+
+func TestTee(t *testing.T) {
+	for i, tc := range teeTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var in, out bytes.Buffer
+			rec := xmpptest.NewRecorder(&in, &out)
+			teeIn, teeOut := rec.Tee()
+			_, err := fmt.Fprint(teeIn, tc.in)
+			if err != nil {
+				t.Fatalf("error writing to tee: %v", err)
+			}
+			_, err = fmt.Fprint(teeOut, `<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams"><presence/>`)
+			if err != nil {
+				t.Fatalf("error writing to tee: %v", err)
+			}
+			err = rec.Close()
+			if err != nil {
+				t.Fatalf("error closing recorder: %v", err)
+			}
+			if s := in.String(); s != tc.want {
+				t.Errorf("wrong stanzas recorded:\nwant=%s,\n got=%s", tc.want, s)
+			}
+			if s, want := out.String(), `<presence xmlns="jabber:client"></presence>`; s != want {
+				t.Errorf("wrong sent stanzas recorded: want=%s, got=%s", want, s)
+			}
+		})
+	}
+}
@@ -130,6 +130,11 @@ func Handle(h Handler) mux.Option {
 // If timeFunc is nil, time.Now is used.
 type Handler struct {
 	TimeFunc func() time.Time
+
+	// Allow is called with the address of the entity requesting our time.
+	// If it is not nil and returns false, the request is refused with a
+	// service-unavailable error as if entity time were not supported.
+	Allow func(jid.JID) bool
 }
 
 // HandleIQ responds to entity time requests.
@@ -137,6 +142,13 @@ func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml
 	if iq.Type != stanza.GetIQ || start.Name.Local != "time" || start.Name.Space != NS {
 		return nil
 	}
+	if h.Allow != nil && !h.Allow(iq.From) {
+		_, err := xmlstream.Copy(t, iq.Error(stanza.Error{
+			Type:      stanza.Cancel,
+			Condition: stanza.ServiceUnavailable,
+		}))
+		return err
+	}
 
 	var tt Time
 	if h.TimeFunc == nil {
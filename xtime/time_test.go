@@ -7,12 +7,15 @@ package xtime_test
 import (
 	"context"
 	"encoding/xml"
+	"errors"
 	"testing"
 	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
 	"mellium.im/xmpp/xtime"
 )
 
@@ -48,6 +51,21 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+func TestDisallowed(t *testing.T) {
+	m := mux.New(xtime.Handle(xtime.Handler{
+		Allow: func(jid.JID) bool { return false },
+	}))
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(m),
+	)
+
+	_, err := xtime.Get(context.Background(), cs.Client, cs.Server.LocalAddr())
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.ServiceUnavailable {
+		t.Errorf("wrong error: want=%v, got=%v", stanza.ServiceUnavailable, err)
+	}
+}
+
 func TestAttrMarshal(t *testing.T) {
 	zeroTime := time.Time{}.Add(24 * time.Hour)
 	xt := xtime.Time{Time: zeroTime}